@@ -0,0 +1,533 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gatekeepertest
+
+import (
+	"crypto/rsa"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/coreos/go-oidc/jose"
+	"github.com/go-chi/chi"
+)
+
+const (
+	// Username and Password are the default credentials of the test user, for the password grant
+	Username = "gatekeeper"
+	Password = "test-password"
+	// Realm is the default realm of the provider
+	Realm = "gatekeeper"
+
+	realmsPath    = "/auth/realms/"
+	discoveryPath = "/.well-known/openid-configuration"
+	authPath      = "/protocol/openid-connect/auth"
+	certsPath     = "/protocol/openid-connect/certs"
+	tokenPath     = "/protocol/openid-connect/token"
+	userinfoPath  = "/protocol/openid-connect/userinfo"
+	logoutPath    = "/protocol/openid-connect/logout"
+)
+
+// Endpoint designates an endpoint of the provider, for the failures injected
+type Endpoint string
+
+const (
+	// DiscoveryEndpoint serves the discovery document
+	DiscoveryEndpoint Endpoint = "discovery"
+	// AuthEndpoint grants the authorization requests
+	AuthEndpoint Endpoint = "auth"
+	// TokenEndpoint issues the tokens
+	TokenEndpoint Endpoint = "token"
+	// KeysEndpoint serves the key set
+	KeysEndpoint Endpoint = "keys"
+	// UserinfoEndpoint serves the claims of the test user
+	UserinfoEndpoint Endpoint = "userinfo"
+	// LogoutEndpoint ends the sessions
+	LogoutEndpoint Endpoint = "logout"
+)
+
+// Failure is an error injected in the responses of an endpoint
+type Failure struct {
+	// Status is the status code of the responses. When zero, the responses are only delayed.
+	Status int
+	// Error is the oauth error code of the responses, server_error by default
+	Error string
+	// Delay delays the responses
+	Delay time.Duration
+	// Count is the number of responses failed, all of them when zero
+	Count int
+}
+
+// Options customizes a fake provider
+type Options struct {
+	// Realm is the realm of the provider, Realm by default
+	Realm string
+	// Key signs the tokens, a key being generated by default
+	Key *rsa.PrivateKey
+	// Username and Password are the credentials of the test user, Username and Password by default
+	Username string
+	Password string
+	// Address is the address the provider listens on, a random local port by default
+	Address string
+	// TLS serves the provider over https with the certificates of the configuration, over http when nil
+	TLS *tls.Config
+}
+
+// AuthServer is a fake openid provider, modelled on a keycloak realm. The authorization requests are granted
+// without any login, and the tokens issued are those of the test user.
+type AuthServer struct {
+	server   *httptest.Server
+	clientID string
+	options  Options
+	// codes counts the authorization codes issued
+	codes int64
+
+	mu sync.RWMutex
+	// key signs the tokens, the retired keys being still published until retired
+	key        *signingKey
+	previous   []*signingKey
+	rotations  int
+	claims     jose.Claims
+	expiration time.Duration
+	// refreshExpiration is the lifetime of the refresh tokens, the one of the access tokens when zero
+	refreshExpiration time.Duration
+	revoked           map[string]bool
+	failures          map[Endpoint]*Failure
+	requests          map[Endpoint]int
+}
+
+// NewAuthServer starts a fake provider for a client
+func NewAuthServer(clientID string) (*AuthServer, error) {
+	return NewAuthServerWithOptions(clientID, Options{})
+}
+
+// NewAuthServerWithOptions starts a fake provider for a client, with some options
+func NewAuthServerWithOptions(clientID string, options Options) (*AuthServer, error) {
+	if options.Realm == "" {
+		options.Realm = Realm
+	}
+	if options.Username == "" {
+		options.Username, options.Password = Username, Password
+	}
+	var key *signingKey
+	if options.Key != nil {
+		key = newSigningKeyFrom("gatekeepertest", options.Key)
+	} else {
+		generated, err := newSigningKey("gatekeepertest")
+		if err != nil {
+			return nil, err
+		}
+		key = generated
+	}
+	s := &AuthServer{
+		key:        key,
+		clientID:   clientID,
+		options:    options,
+		expiration: time.Hour,
+		revoked:    make(map[string]bool),
+		failures:   make(map[Endpoint]*Failure),
+		requests:   make(map[Endpoint]int),
+	}
+
+	router := chi.NewRouter()
+	router.Route(realmsPath+options.Realm, func(r chi.Router) {
+		r.Get(discoveryPath, s.endpoint(DiscoveryEndpoint, s.discoveryHandler))
+		r.Get(certsPath, s.endpoint(KeysEndpoint, s.keysHandler))
+		r.Get(authPath, s.endpoint(AuthEndpoint, s.authHandler))
+		r.Post(tokenPath, s.endpoint(TokenEndpoint, s.tokenHandler))
+		r.Get(userinfoPath, s.endpoint(UserinfoEndpoint, s.userinfoHandler))
+		r.Post(userinfoPath, s.endpoint(UserinfoEndpoint, s.userinfoHandler))
+		r.Get(logoutPath, s.endpoint(LogoutEndpoint, s.logoutHandler))
+		r.Post(logoutPath, s.endpoint(LogoutEndpoint, s.logoutHandler))
+	})
+	s.server = httptest.NewUnstartedServer(router)
+	if options.Address != "" {
+		listener, err := net.Listen("tcp", options.Address)
+		if err != nil {
+			return nil, err
+		}
+		_ = s.server.Listener.Close()
+		s.server.Listener = listener
+	}
+	scheme := "http"
+	if options.TLS != nil {
+		scheme = "https"
+		s.server.TLS = options.TLS
+		s.server.StartTLS()
+	} else {
+		s.server.Start()
+	}
+	// the provider is reached by the address configured, which may be a host name
+	if options.Address != "" {
+		s.server.URL = scheme + "://" + options.Address
+	}
+
+	return s, nil
+}
+
+// DiscoveryURL is the url of the realm, as set in the discovery-url of gatekeeper
+func (s *AuthServer) DiscoveryURL() string {
+	return s.server.URL + realmsPath + s.options.Realm
+}
+
+// LogoutURL is the url of the logout endpoint, as set in the revocation-url of gatekeeper
+func (s *AuthServer) LogoutURL() string {
+	return s.DiscoveryURL() + logoutPath
+}
+
+// Close stops the provider
+func (s *AuthServer) Close() error {
+	s.server.Close()
+	return nil
+}
+
+// SetClaims sets claims of the tokens issued, in addition to or in place of those of the test user. A nil
+// value removes a claim of the test user.
+func (s *AuthServer) SetClaims(claims jose.Claims) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.claims == nil {
+		s.claims = make(jose.Claims)
+	}
+	for name, value := range claims {
+		s.claims[name] = value
+	}
+}
+
+// SetExpiration sets the lifetime of the tokens issued, an hour by default
+func (s *AuthServer) SetExpiration(expiration time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expiration = expiration
+}
+
+// SetRefreshExpiration sets the lifetime of the refresh tokens issued, the one of the access tokens by default
+func (s *AuthServer) SetRefreshExpiration(expiration time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refreshExpiration = expiration
+}
+
+// RevokeSession makes the userinfo endpoint reject the tokens of a session, identified by their session_state
+func (s *AuthServer) RevokeSession(session string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revoked[session] = true
+}
+
+// RotateKey signs the tokens with a new key. The previous keys remain in the key set until retired, so that
+// the tokens they signed are still valid.
+func (s *AuthServer) RotateKey() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, err := newSigningKey(fmt.Sprintf("gatekeepertest-%d", s.rotations+1))
+	if err != nil {
+		return err
+	}
+	s.rotations++
+	s.previous = append(s.previous, s.key)
+	s.key = key
+
+	return nil
+}
+
+// RetireKeys removes the previous keys from the key set, the tokens they signed being no longer valid
+func (s *AuthServer) RetireKeys() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.previous = nil
+}
+
+// Fail injects a failure in the responses of an endpoint, in place of any previous one
+func (s *AuthServer) Fail(endpoint Endpoint, failure Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[endpoint] = &failure
+}
+
+// Recover removes the failure injected in the responses of an endpoint
+func (s *AuthServer) Recover(endpoint Endpoint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.failures, endpoint)
+}
+
+// Requests is the number of requests received by an endpoint, the failed ones included
+func (s *AuthServer) Requests(endpoint Endpoint) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.requests[endpoint]
+}
+
+// Keys is the key set published by the provider
+func (s *AuthServer) Keys() jose.JWKSet {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := []jose.JWK{s.key.key}
+	for _, key := range s.previous {
+		keys = append(keys, key.key)
+	}
+
+	return jose.JWKSet{Keys: keys}
+}
+
+// SignToken signs a token with the key of the provider
+func (s *AuthServer) SignToken(claims jose.Claims) (string, error) {
+	s.mu.RLock()
+	key := s.key
+	s.mu.RUnlock()
+
+	return key.sign(claims)
+}
+
+// Token issues an access token of the test user, with some realm roles
+func (s *AuthServer) Token(roles ...string) (string, error) {
+	claims := s.userClaims("Bearer")
+	realmRoles := make([]interface{}, 0, len(roles))
+	for _, role := range roles {
+		realmRoles = append(realmRoles, role)
+	}
+	claims["realm_access"] = map[string]interface{}{"roles": realmRoles}
+
+	return s.SignToken(claims)
+}
+
+// userClaims are the claims of the tokens of the test user
+func (s *AuthServer) userClaims(kind string) jose.Claims {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := time.Now()
+	expiration := s.expiration
+	if kind == "Refresh" && s.refreshExpiration != 0 {
+		expiration = s.refreshExpiration
+	}
+
+	claims := jose.Claims{
+		"iss":                s.DiscoveryURL(),
+		"aud":                s.clientID,
+		"azp":                s.clientID,
+		"sub":                "gatekeepertest-user",
+		"typ":                kind,
+		"jti":                fmt.Sprintf("%d", now.UnixNano()),
+		"email":              "gatekeeper@example.com",
+		"email_verified":     true,
+		"name":               "Gatekeeper Test",
+		"preferred_username": s.options.Username,
+		"session_state":      "gatekeepertest-session",
+		"iat":                now.Unix(),
+		"exp":                now.Add(expiration).Unix(),
+	}
+	for name, value := range s.claims {
+		if value == nil {
+			delete(claims, name)
+			continue
+		}
+		claims[name] = value
+	}
+
+	return claims
+}
+
+// endpoint counts the requests of an endpoint, and injects its failure if any
+func (s *AuthServer) endpoint(endpoint Endpoint, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		failure := s.failure(endpoint)
+		if failure == nil {
+			next(w, req)
+			return
+		}
+		if failure.Delay > 0 {
+			select {
+			case <-time.After(failure.Delay):
+			case <-req.Context().Done():
+				return
+			}
+		}
+		if failure.Status == 0 {
+			next(w, req)
+			return
+		}
+		code := failure.Error
+		if code == "" {
+			code = "server_error"
+		}
+		renderJSON(w, failure.Status, map[string]string{
+			"error":             code,
+			"error_description": "failure injected by gatekeepertest",
+		})
+	}
+}
+
+// failure counts a request of an endpoint, returning the failure injected if any
+func (s *AuthServer) failure(endpoint Endpoint) *Failure {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests[endpoint]++
+	failure, found := s.failures[endpoint]
+	if !found {
+		return nil
+	}
+	if failure.Count > 0 {
+		failure.Count--
+		if failure.Count == 0 {
+			delete(s.failures, endpoint)
+		}
+	}
+	injected := *failure
+
+	return &injected
+}
+
+// keysHandler serves the signing key and the keys not yet retired
+func (s *AuthServer) keysHandler(w http.ResponseWriter, req *http.Request) {
+	renderJSON(w, http.StatusOK, s.Keys())
+}
+
+func (s *AuthServer) discoveryHandler(w http.ResponseWriter, req *http.Request) {
+	issuer := s.DiscoveryURL()
+	renderJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + authPath,
+		"token_endpoint":                        issuer + tokenPath,
+		"userinfo_endpoint":                     issuer + userinfoPath,
+		"end_session_endpoint":                  issuer + logoutPath,
+		"jwks_uri":                              issuer + certsPath,
+		"grant_types_supported":                 []string{"authorization_code", "refresh_token", "password", "client_credentials"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"response_types_supported":              []string{"code"},
+		"response_modes_supported":              []string{"query", "form_post"},
+		"subject_types_supported":               []string{"public"},
+	})
+}
+
+// authHandler grants the authorization requests, redirecting back to the client with a code
+func (s *AuthServer) authHandler(w http.ResponseWriter, req *http.Request) {
+	redirect, err := url.Parse(req.URL.Query().Get("redirect_uri"))
+	if err != nil || redirect.String() == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	query := redirect.Query()
+	query.Set("state", req.URL.Query().Get("state"))
+	query.Set("code", fmt.Sprintf("gatekeepertest-code-%d", atomic.AddInt64(&s.codes, 1)))
+	redirect.RawQuery = query.Encode()
+
+	http.Redirect(w, req, redirect.String(), http.StatusFound)
+}
+
+func (s *AuthServer) tokenHandler(w http.ResponseWriter, req *http.Request) {
+	switch grant := req.FormValue("grant_type"); grant {
+	case "password":
+		if req.FormValue("username") != s.options.Username || req.FormValue("password") != s.options.Password {
+			renderJSON(w, http.StatusUnauthorized, map[string]string{
+				"error":             "invalid_grant",
+				"error_description": "Invalid user credentials",
+			})
+			return
+		}
+	case "authorization_code", "refresh_token", "client_credentials":
+	default:
+		renderJSON(w, http.StatusBadRequest, map[string]string{
+			"error":             "unsupported_grant_type",
+			"error_description": "Unsupported grant_type " + grant,
+		})
+		return
+	}
+
+	access, err := s.SignToken(s.userClaims("Bearer"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	identity, _ := s.SignToken(s.userClaims("ID"))
+	refresh, _ := s.SignToken(s.userClaims("Refresh"))
+	s.mu.RLock()
+	expiration := s.expiration
+	s.mu.RUnlock()
+
+	renderJSON(w, http.StatusOK, map[string]interface{}{
+		"token_type":    "bearer",
+		"access_token":  access,
+		"id_token":      identity,
+		"refresh_token": refresh,
+		"expires_in":    int(expiration.Seconds()),
+	})
+}
+
+func (s *AuthServer) userinfoHandler(w http.ResponseWriter, req *http.Request) {
+	token, err := jose.ParseJWT(strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "))
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	claims, err := token.Claims()
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	s.mu.RLock()
+	session, _, _ := claims.StringClaim("session_state")
+	revoked := s.revoked[session]
+	s.mu.RUnlock()
+	if revoked {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	info := map[string]interface{}{
+		"sub":                claims["sub"],
+		"name":               claims["name"],
+		"preferred_username": claims["preferred_username"],
+		"email":              claims["email"],
+		"email_verified":     claims["email_verified"],
+	}
+	// the claims set are returned as well, those removed are not
+	s.mu.RLock()
+	for name, value := range s.claims {
+		if value == nil {
+			delete(info, name)
+			continue
+		}
+		info[name] = claims[name]
+	}
+	s.mu.RUnlock()
+
+	renderJSON(w, http.StatusOK, info)
+}
+
+// logoutHandler ends the sessions, by redirecting the browsers back to the client or, as keycloak does, by
+// revoking the refresh token posted by the client
+func (s *AuthServer) logoutHandler(w http.ResponseWriter, req *http.Request) {
+	if redirect := req.FormValue("redirect_uri"); redirect != "" {
+		http.Redirect(w, req, redirect, http.StatusFound)
+		return
+	}
+	if req.Method == http.MethodPost && req.FormValue("refresh_token") == "" {
+		renderJSON(w, http.StatusBadRequest, map[string]string{
+			"error":             "invalid_request",
+			"error_description": "No refresh token",
+		})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
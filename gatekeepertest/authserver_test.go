@@ -0,0 +1,218 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gatekeepertest_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-oidc/jose"
+	"github.com/coreos/go-oidc/key"
+	"github.com/coreos/go-oidc/oidc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/oneconcern/keycloak-gatekeeper/gatekeepertest"
+)
+
+// passwordGrant requests the tokens of the test user
+func passwordGrant(t *testing.T, idp *gatekeepertest.AuthServer) (int, map[string]interface{}) {
+	resp, err := http.PostForm(idp.DiscoveryURL()+"/protocol/openid-connect/token", url.Values{
+		"grant_type": {"password"},
+		"username":   {gatekeepertest.Username},
+		"password":   {gatekeepertest.Password},
+	})
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var document map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&document))
+
+	return resp.StatusCode, document
+}
+
+// publishedKeys fetches the key set of the provider
+func publishedKeys(t *testing.T, idp *gatekeepertest.AuthServer) []key.PublicKey {
+	resp, err := http.Get(idp.DiscoveryURL() + "/protocol/openid-connect/certs")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var set jose.JWKSet
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&set))
+	keys := make([]key.PublicKey, 0, len(set.Keys))
+	for _, jwk := range set.Keys {
+		keys = append(keys, *key.NewPublicKey(jwk))
+	}
+
+	return keys
+}
+
+func TestAuthServerClaims(t *testing.T) {
+	idp, err := gatekeepertest.NewAuthServer(testClientID)
+	require.NoError(t, err)
+	defer idp.Close()
+	idp.SetExpiration(5 * time.Minute)
+	idp.SetClaims(jose.Claims{
+		"groups": []string{"/admins"},
+		"email":  nil,
+	})
+
+	code, tokens := passwordGrant(t, idp)
+	require.Equal(t, http.StatusOK, code)
+	assert.EqualValues(t, 300, tokens["expires_in"])
+	token, err := jose.ParseJWT(tokens["access_token"].(string))
+	require.NoError(t, err)
+	claims, err := token.Claims()
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{"/admins"}, claims["groups"])
+	assert.NotContains(t, claims, "email")
+	assert.Equal(t, gatekeepertest.Username, claims["preferred_username"])
+
+	req, err := http.NewRequest(http.MethodGet, idp.DiscoveryURL()+"/protocol/openid-connect/userinfo", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+tokens["access_token"].(string))
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var info map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&info))
+	assert.Equal(t, []interface{}{"/admins"}, info["groups"])
+	assert.NotContains(t, info, "email")
+}
+
+func TestAuthServerKeyRotation(t *testing.T) {
+	idp, err := gatekeepertest.NewAuthServer(testClientID)
+	require.NoError(t, err)
+	defer idp.Close()
+
+	encoded, err := idp.Token("user")
+	require.NoError(t, err)
+	before, err := jose.ParseJWT(encoded)
+	require.NoError(t, err)
+
+	require.NoError(t, idp.RotateKey())
+	encoded, err = idp.Token("user")
+	require.NoError(t, err)
+	after, err := jose.ParseJWT(encoded)
+	require.NoError(t, err)
+	beforeID, _ := before.KeyID()
+	afterID, _ := after.KeyID()
+	assert.NotEqual(t, beforeID, afterID)
+
+	// the previous key remains published until retired
+	keys := publishedKeys(t, idp)
+	assert.Len(t, keys, 2)
+	_, err = oidc.VerifySignature(before, keys)
+	assert.NoError(t, err)
+	_, err = oidc.VerifySignature(after, keys)
+	assert.NoError(t, err)
+
+	idp.RetireKeys()
+	keys = publishedKeys(t, idp)
+	assert.Len(t, keys, 1)
+	verified, _ := oidc.VerifySignature(before, keys)
+	assert.False(t, verified, "the tokens of a retired key must not verify")
+	_, err = oidc.VerifySignature(after, keys)
+	assert.NoError(t, err)
+}
+
+func TestAuthServerFailures(t *testing.T) {
+	idp, err := gatekeepertest.NewAuthServer(testClientID)
+	require.NoError(t, err)
+	defer idp.Close()
+
+	idp.Fail(gatekeepertest.TokenEndpoint, gatekeepertest.Failure{Status: http.StatusServiceUnavailable, Count: 1})
+	code, document := passwordGrant(t, idp)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "server_error", document["error"])
+	code, _ = passwordGrant(t, idp)
+	assert.Equal(t, http.StatusOK, code, "the failure must be injected once")
+
+	idp.Fail(gatekeepertest.TokenEndpoint, gatekeepertest.Failure{Status: http.StatusBadRequest, Error: "invalid_grant"})
+	for i := 0; i < 2; i++ {
+		code, document = passwordGrant(t, idp)
+		assert.Equal(t, http.StatusBadRequest, code)
+		assert.Equal(t, "invalid_grant", document["error"])
+	}
+	idp.Recover(gatekeepertest.TokenEndpoint)
+	code, _ = passwordGrant(t, idp)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 5, idp.Requests(gatekeepertest.TokenEndpoint))
+
+	// the responses are only delayed without a status
+	idp.Fail(gatekeepertest.TokenEndpoint, gatekeepertest.Failure{Delay: 100 * time.Millisecond})
+	start := time.Now()
+	code, _ = passwordGrant(t, idp)
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+}
+
+func TestAuthServerOptions(t *testing.T) {
+	idp, err := gatekeepertest.NewAuthServerWithOptions(testClientID, gatekeepertest.Options{
+		Realm:    "other",
+		Username: "someone",
+		Password: "secret",
+	})
+	require.NoError(t, err)
+	defer idp.Close()
+	assert.Contains(t, idp.DiscoveryURL(), "/auth/realms/other")
+	idp.SetRefreshExpiration(2 * time.Hour)
+
+	code, _ := passwordGrant(t, idp)
+	assert.Equal(t, http.StatusUnauthorized, code, "the default credentials are replaced")
+	resp, err := http.PostForm(idp.DiscoveryURL()+"/protocol/openid-connect/token", url.Values{
+		"grant_type": {"password"},
+		"username":   {"someone"},
+		"password":   {"secret"},
+	})
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var tokens map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&tokens))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	refresh, err := jose.ParseJWT(tokens["refresh_token"].(string))
+	require.NoError(t, err)
+	claims, err := refresh.Claims()
+	require.NoError(t, err)
+	expires, _, err := claims.TimeClaim("exp")
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(2*time.Hour), expires, time.Minute)
+
+	// the tokens of a revoked session are rejected by the userinfo endpoint
+	userinfo := func() int {
+		req, err := http.NewRequest(http.MethodGet, idp.DiscoveryURL()+"/protocol/openid-connect/userinfo", nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+tokens["access_token"].(string))
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusOK, userinfo())
+	idp.RevokeSession("gatekeepertest-session")
+	assert.Equal(t, http.StatusUnauthorized, userinfo())
+
+	// as keycloak, the logout of a client requires the refresh token
+	resp, err = http.PostForm(idp.LogoutURL(), url.Values{})
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, err = http.PostForm(idp.LogoutURL(), url.Values{"refresh_token": {tokens["refresh_token"].(string)}})
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+}
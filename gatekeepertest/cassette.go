@@ -0,0 +1,89 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gatekeepertest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Interaction is a request to the provider and its response. The credentials of the requests are not recorded.
+type Interaction struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	// GrantType is the grant of the requests to the token endpoint
+	GrantType string `json:"grant_type,omitempty"`
+	Status    int    `json:"status"`
+	// Header holds the content type and location of the response
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body"`
+	// Time is the time of the response, which the times of the tokens replayed are relative to
+	Time time.Time `json:"time"`
+}
+
+// key identifies the interactions replayed in turn
+func (i Interaction) key() string {
+	return i.Method + " " + i.Path + " " + i.GrantType
+}
+
+// Cassette is a recording of the interactions with a provider
+type Cassette struct {
+	// Provider is the discovery url of the provider recorded
+	Provider     string        `json:"provider"`
+	Interactions []Interaction `json:"interactions"`
+}
+
+// origin is the scheme and host of the provider
+func (c *Cassette) origin() string {
+	u, err := url.Parse(c.Provider)
+	if err != nil {
+		return ""
+	}
+
+	return u.Scheme + "://" + u.Host
+}
+
+// LoadCassette reads a cassette from a file
+func LoadCassette(filename string) (*Cassette, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	cassette := &Cassette{}
+	if err := json.Unmarshal(content, cassette); err != nil {
+		return nil, err
+	}
+
+	return cassette, nil
+}
+
+// Save writes the cassette to a file, creating its directory if need be
+func (c *Cassette) Save(filename string) error {
+	content, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filename, content, 0644)
+}
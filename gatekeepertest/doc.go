@@ -0,0 +1,41 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package gatekeepertest provides openid providers for the integration tests of the services behind gatekeeper.
+//
+// AuthServer is a fake provider, issuing the tokens of a test user. Its claims may be set, its signing key
+// rotated, and failures injected in the responses of its endpoints, to test how the services behave when the
+// provider misbehaves:
+//
+//	idp.SetClaims(jose.Claims{"groups": []string{"/admins"}})
+//	idp.Fail(gatekeepertest.TokenEndpoint, gatekeepertest.Failure{Status: http.StatusServiceUnavailable, Count: 1})
+//
+// Recorder proxies a real provider, e.g. a
+// keycloak started for the tests, and records its interactions in a cassette, which Replayer plays back without
+// the provider. The tokens replayed are signed again by the replayer, with their times shifted to the replay,
+// so that the recorded claims remain valid.
+//
+//	provider, err := gatekeepertest.NewProvider("testdata/login.json")
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	defer provider.Close()
+//
+//	config := proxy.NewDefaultConfig()
+//	config.DiscoveryURL = provider.DiscoveryURL()
+//
+// The provider replays the cassette, unless the GATEKEEPERTEST_RECORD environment variable is set to the url of
+// the provider to record.
+package gatekeepertest
@@ -0,0 +1,78 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gatekeepertest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+
+	"github.com/coreos/go-oidc/jose"
+)
+
+// signingKey is the key signing the tokens of a provider
+type signingKey struct {
+	key    jose.JWK
+	signer jose.Signer
+}
+
+// newSigningKey generates a signing key
+func newSigningKey(id string) (*signingKey, error) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSigningKeyFrom(id, privateKey), nil
+}
+
+// newSigningKeyFrom makes a signing key of a private key
+func newSigningKeyFrom(id string, privateKey *rsa.PrivateKey) *signingKey {
+	return &signingKey{
+		key: jose.JWK{
+			ID:       id,
+			Type:     "RSA",
+			Alg:      "RS256",
+			Use:      "sig",
+			Exponent: privateKey.PublicKey.E,
+			Modulus:  privateKey.PublicKey.N,
+		},
+		signer: jose.NewSignerRSA(id, *privateKey),
+	}
+}
+
+// sign encodes a token signed with the key
+func (k *signingKey) sign(claims jose.Claims) (string, error) {
+	token, err := jose.NewSignedJWT(claims, k.signer)
+	if err != nil {
+		return "", err
+	}
+
+	return token.Encode(), nil
+}
+
+// keysHandler serves the key set of the provider
+func (k *signingKey) keysHandler(w http.ResponseWriter, req *http.Request) {
+	renderJSON(w, http.StatusOK, jose.JWKSet{Keys: []jose.JWK{k.key}})
+}
+
+// renderJSON writes a json response
+func renderJSON(w http.ResponseWriter, code int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(data)
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gatekeepertest
+
+import (
+	"os"
+)
+
+// RecordEnv is the environment variable holding the discovery url of the provider to record, in place of
+// replaying the cassettes
+const RecordEnv = "GATEKEEPERTEST_RECORD"
+
+// Provider is an openid provider of the tests
+type Provider interface {
+	// DiscoveryURL is the url set in the discovery-url of gatekeeper
+	DiscoveryURL() string
+	// Close stops the provider
+	Close() error
+}
+
+// recordingProvider saves its cassette once closed
+type recordingProvider struct {
+	*Recorder
+	filename string
+}
+
+// Close stops recording and saves the cassette
+func (p *recordingProvider) Close() error {
+	if err := p.Recorder.Close(); err != nil {
+		return err
+	}
+
+	return p.Cassette().Save(p.filename)
+}
+
+// NewProvider replays the cassette of a file or, when the RecordEnv environment variable is set, records it
+// against the provider of the variable. The cassette is saved once the recording provider is closed.
+func NewProvider(cassette string) (Provider, error) {
+	if provider := os.Getenv(RecordEnv); provider != "" {
+		recorder, err := NewRecorder(provider)
+		if err != nil {
+			return nil, err
+		}
+		return &recordingProvider{Recorder: recorder, filename: cassette}, nil
+	}
+
+	recorded, err := LoadCassette(cassette)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewReplayer(recorded)
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gatekeepertest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Recorder proxies the requests to a provider, recording their responses. As the discovery document is
+// served for the origin of the recorder, the tokens are signed again by the recorder for its issuer, and their
+// originals are sent back to the provider.
+type Recorder struct {
+	server   *httptest.Server
+	provider *url.URL
+	client   *http.Client
+	rewriter *rewriter
+
+	mu       sync.Mutex
+	cassette Cassette
+	// certsPath is the path of the key set, served by the recorder
+	certsPath string
+	// originals are the tokens of the provider, by the tokens signed again
+	originals map[string]string
+}
+
+// NewRecorder starts recording the interactions with the provider of a discovery url
+func NewRecorder(provider string) (*Recorder, error) {
+	u, err := url.Parse(provider)
+	if err != nil {
+		return nil, err
+	}
+	rw, err := newRewriter(u.Scheme + "://" + u.Host)
+	if err != nil {
+		return nil, err
+	}
+	r := &Recorder{
+		provider: u,
+		rewriter: rw,
+		// the redirections of the provider are recorded as such
+		client: &http.Client{
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+			Timeout:       30 * time.Second,
+		},
+		cassette:  Cassette{Provider: provider},
+		originals: make(map[string]string),
+	}
+	r.server = httptest.NewServer(http.HandlerFunc(r.recordHandler))
+	rw.to = r.server.URL
+
+	return r, nil
+}
+
+// DiscoveryURL is the url of the provider through the recorder
+func (r *Recorder) DiscoveryURL() string {
+	return r.server.URL + r.provider.Path
+}
+
+// Cassette returns the interactions recorded so far
+func (r *Recorder) Cassette() *Cassette {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cassette := r.cassette
+	cassette.Interactions = append([]Interaction(nil), r.cassette.Interactions...)
+
+	return &cassette
+}
+
+// Close stops the recorder
+func (r *Recorder) Close() error {
+	r.server.Close()
+	return nil
+}
+
+func (r *Recorder) recordHandler(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	certsPath := r.certsPath
+	r.mu.Unlock()
+	if certsPath != "" && req.URL.Path == certsPath {
+		r.rewriter.key.keysHandler(w, req)
+		return
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	target := *req.URL
+	target.Scheme = r.provider.Scheme
+	target.Host = r.provider.Host
+	outbound, err := http.NewRequest(req.Method, target.String(), bytes.NewReader(r.restoreTokens(body)))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	outbound.Header = req.Header.Clone()
+	if authorization := outbound.Header.Get("Authorization"); authorization != "" {
+		outbound.Header.Set("Authorization", string(r.restoreTokens([]byte(authorization))))
+	}
+	// the bodies are recorded decoded
+	outbound.Header.Del("Accept-Encoding")
+
+	resp, err := r.client.Do(outbound)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	interaction := Interaction{
+		Method: req.Method,
+		Path:   req.URL.Path,
+		Status: resp.StatusCode,
+		Header: http.Header{},
+		Body:   string(content),
+		Time:   time.Now().UTC(),
+	}
+	if req.Method == http.MethodPost && strings.HasSuffix(req.URL.Path, tokenPath) {
+		form, _ := url.ParseQuery(string(body))
+		interaction.GrantType = form.Get("grant_type")
+	}
+	for _, name := range []string{"Content-Type", "Location"} {
+		if value := resp.Header.Get(name); value != "" {
+			interaction.Header.Set(name, value)
+		}
+	}
+
+	rewritten, tokens, err := r.rewriter.body(interaction.Path, interaction.Body, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+	for signed, original := range tokens {
+		r.originals[signed] = original
+	}
+	if strings.HasSuffix(req.URL.Path, discoveryPath) && resp.StatusCode == http.StatusOK {
+		r.certsPath, _, _ = discoveryPaths(interaction.Body)
+	}
+	r.mu.Unlock()
+
+	for name, values := range resp.Header {
+		switch name {
+		case "Content-Length":
+		case "Location":
+			w.Header().Set(name, r.rewriter.header(values[0]))
+		default:
+			w.Header()[name] = values
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, _ = w.Write([]byte(rewritten))
+}
+
+// restoreTokens replaces the tokens signed by the recorder with those of the provider
+func (r *Recorder) restoreTokens(content []byte) []byte {
+	if len(content) == 0 {
+		return content
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	restored := string(content)
+	for signed, original := range r.originals {
+		restored = strings.Replace(restored, signed, original, -1)
+	}
+
+	return []byte(restored)
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gatekeepertest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Replayer plays back the interactions of a cassette, in place of the provider recorded. The interactions of a
+// request are replayed in the order recorded, the last one being repeated. The authorization requests are
+// granted without any login, and the tokens are signed by the replayer.
+type Replayer struct {
+	server   *httptest.Server
+	cassette *Cassette
+	rewriter *rewriter
+	// certsPath and authPath are the paths of the key set and the authorization endpoint
+	certsPath string
+	authPath  string
+
+	mu     sync.Mutex
+	played map[string]int
+	codes  int
+}
+
+// NewReplayer starts replaying a cassette
+func NewReplayer(cassette *Cassette) (*Replayer, error) {
+	origin := cassette.origin()
+	if origin == "" {
+		return nil, errors.New("the cassette does not have the url of its provider")
+	}
+	r := &Replayer{
+		cassette: cassette,
+		played:   make(map[string]int),
+	}
+	for _, interaction := range cassette.Interactions {
+		if strings.HasSuffix(interaction.Path, discoveryPath) && interaction.Status == http.StatusOK {
+			var err error
+			if r.certsPath, r.authPath, err = discoveryPaths(interaction.Body); err != nil {
+				return nil, fmt.Errorf("invalid discovery document in the cassette: %v", err)
+			}
+			break
+		}
+	}
+	if r.certsPath == "" {
+		return nil, errors.New("the cassette does not have the discovery of its provider")
+	}
+	rw, err := newRewriter(origin)
+	if err != nil {
+		return nil, err
+	}
+	r.rewriter = rw
+	r.server = httptest.NewServer(http.HandlerFunc(r.replayHandler))
+	rw.to = r.server.URL
+
+	return r, nil
+}
+
+// discoveryPaths returns the paths of the key set and the authorization endpoint of a discovery document
+func discoveryPaths(document string) (string, string, error) {
+	var endpoints struct {
+		JwksURI               string `json:"jwks_uri"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+	}
+	if err := json.Unmarshal([]byte(document), &endpoints); err != nil {
+		return "", "", err
+	}
+
+	return endpointPath(endpoints.JwksURI), endpointPath(endpoints.AuthorizationEndpoint), nil
+}
+
+// endpointPath is the path of an endpoint url
+func endpointPath(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return ""
+	}
+
+	return u.Path
+}
+
+// DiscoveryURL is the url of the provider replayed
+func (r *Replayer) DiscoveryURL() string {
+	return r.server.URL + endpointPath(r.cassette.Provider)
+}
+
+// Close stops the replayer
+func (r *Replayer) Close() error {
+	r.server.Close()
+	return nil
+}
+
+func (r *Replayer) replayHandler(w http.ResponseWriter, req *http.Request) {
+	switch req.URL.Path {
+	case r.certsPath:
+		r.rewriter.key.keysHandler(w, req)
+		return
+	case r.authPath:
+		r.authHandler(w, req)
+		return
+	}
+
+	lookup := Interaction{Method: req.Method, Path: req.URL.Path}
+	if req.Method == http.MethodPost && strings.HasSuffix(req.URL.Path, tokenPath) {
+		lookup.GrantType = req.FormValue("grant_type")
+	}
+	interaction, found := r.next(lookup.key())
+	if !found {
+		http.Error(w, "no interaction recorded for "+lookup.key(), http.StatusNotFound)
+		return
+	}
+
+	// the tokens are valid from the time of the replay, as they were when recorded
+	body, _, err := r.rewriter.body(interaction.Path, interaction.Body, time.Since(interaction.Time).Truncate(time.Second))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for name, values := range interaction.Header {
+		for _, value := range values {
+			w.Header().Add(name, r.rewriter.header(value))
+		}
+	}
+	w.WriteHeader(interaction.Status)
+	_, _ = w.Write([]byte(body))
+}
+
+// next returns the next interaction recorded for a request
+func (r *Replayer) next(key string) (Interaction, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var matches []Interaction
+	for _, interaction := range r.cassette.Interactions {
+		if interaction.key() == key {
+			matches = append(matches, interaction)
+		}
+	}
+	if len(matches) == 0 {
+		return Interaction{}, false
+	}
+	played := r.played[key]
+	if played < len(matches)-1 {
+		r.played[key] = played + 1
+	}
+
+	return matches[played], true
+}
+
+// authHandler grants the authorization requests, redirecting back to the client with a code
+func (r *Replayer) authHandler(w http.ResponseWriter, req *http.Request) {
+	redirect, err := url.Parse(req.URL.Query().Get("redirect_uri"))
+	if err != nil || redirect.String() == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	r.mu.Lock()
+	r.codes++
+	code := r.codes
+	r.mu.Unlock()
+
+	query := redirect.Query()
+	query.Set("state", req.URL.Query().Get("state"))
+	query.Set("code", fmt.Sprintf("gatekeepertest-replay-%d", code))
+	redirect.RawQuery = query.Encode()
+
+	http.Redirect(w, req, redirect.String(), http.StatusFound)
+}
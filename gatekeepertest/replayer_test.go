@@ -0,0 +1,163 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gatekeepertest_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-oidc/jose"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/oneconcern/keycloak-gatekeeper/gatekeepertest"
+	"github.com/oneconcern/keycloak-gatekeeper/proxy"
+)
+
+const testClientID = "gatekeepertest"
+
+// loginThroughGatekeeper logs in the test user with the login handler of a gatekeeper on the provider,
+// then requests a protected resource with the access token obtained
+func loginThroughGatekeeper(t *testing.T, provider gatekeepertest.Provider) string {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Upstream-Subject", req.Header.Get("X-Auth-Subject"))
+	}))
+	defer upstream.Close()
+
+	config := proxy.NewDefaultConfig()
+	config.ClientID = testClientID
+	config.ClientSecret = "secret"
+	config.DisableAllLogging = true
+	config.DiscoveryURL = provider.DiscoveryURL()
+	config.EnableLoginHandler = true
+	config.Listen = "127.0.0.1:0"
+	config.RedirectionURL = "http://127.0.0.1"
+	config.SecureCookie = false
+	config.Upstream = upstream.URL
+	config.Resources = []*proxy.Resource{{URL: "/private*", Methods: []string{http.MethodGet}}}
+	g, err := proxy.New(config)
+	require.NoError(t, err)
+	defer g.Close()
+	svc := httptest.NewServer(g)
+	defer svc.Close()
+
+	resp, err := http.PostForm(svc.URL+"/oauth/login", url.Values{
+		"username": {gatekeepertest.Username},
+		"password": {gatekeepertest.Password},
+	})
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&tokens))
+
+	req, err := http.NewRequest(http.MethodGet, svc.URL+"/private/page", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	return resp.Header.Get("X-Upstream-Subject")
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gatekeepertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cassette := filepath.Join(dir, "login.json")
+
+	idp, err := gatekeepertest.NewAuthServer(testClientID)
+	require.NoError(t, err)
+	recorder, err := gatekeepertest.NewRecorder(idp.DiscoveryURL())
+	require.NoError(t, err)
+	recorded := loginThroughGatekeeper(t, recorder)
+	assert.NotEmpty(t, recorded)
+	require.NoError(t, recorder.Close())
+	require.NoError(t, recorder.Cassette().Save(cassette))
+	// the provider is no longer needed
+	require.NoError(t, idp.Close())
+
+	content, err := ioutil.ReadFile(cassette)
+	require.NoError(t, err)
+	assert.NotContains(t, string(content), gatekeepertest.Password, "the credentials must not be recorded")
+
+	provider, err := gatekeepertest.NewProvider(cassette)
+	require.NoError(t, err)
+	defer provider.Close()
+	assert.Equal(t, recorded, loginThroughGatekeeper(t, provider))
+}
+
+func TestReplayShiftsTokens(t *testing.T) {
+	idp, err := gatekeepertest.NewAuthServer(testClientID)
+	require.NoError(t, err)
+	defer idp.Close()
+	recorder, err := gatekeepertest.NewRecorder(idp.DiscoveryURL())
+	require.NoError(t, err)
+	defer recorder.Close()
+
+	resp, err := http.Get(recorder.DiscoveryURL() + "/.well-known/openid-configuration")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	resp, err = http.PostForm(recorder.DiscoveryURL()+"/protocol/openid-connect/token", url.Values{"grant_type": {"client_credentials"}})
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+
+	// the interactions are replayed as if recorded an hour ago
+	cassette := recorder.Cassette()
+	require.Len(t, cassette.Interactions, 2)
+	for i := range cassette.Interactions {
+		cassette.Interactions[i].Time = cassette.Interactions[i].Time.Add(-time.Hour)
+	}
+	replayer, err := gatekeepertest.NewReplayer(cassette)
+	require.NoError(t, err)
+	defer replayer.Close()
+
+	resp, err = http.PostForm(replayer.DiscoveryURL()+"/protocol/openid-connect/token", url.Values{"grant_type": {"client_credentials"}})
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&tokens))
+	token, err := jose.ParseJWT(tokens.AccessToken)
+	require.NoError(t, err)
+	claims, err := token.Claims()
+	require.NoError(t, err)
+	expires, _, err := claims.TimeClaim("exp")
+	require.NoError(t, err)
+	assert.True(t, expires.After(time.Now().Add(time.Hour+50*time.Minute)), "the expiry must be shifted to the replay")
+	issuer, _, _ := claims.StringClaim("iss")
+	assert.True(t, strings.HasPrefix(replayer.DiscoveryURL(), issuer))
+
+	// the requests which were not recorded are not found
+	resp, err = http.PostForm(replayer.DiscoveryURL()+"/protocol/openid-connect/token", url.Values{"grant_type": {"password"}})
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
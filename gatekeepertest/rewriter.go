@@ -0,0 +1,107 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gatekeepertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/jose"
+)
+
+// tokenFields are the fields of the token responses holding tokens
+var tokenFields = []string{"access_token", "id_token", "refresh_token"}
+
+// timeClaims are the claims shifted to the time of the replay
+var timeClaims = []string{"iat", "exp", "nbf", "auth_time"}
+
+// rewriter rewrites the responses of a provider to be served from another origin. As the issuer of the tokens
+// changes, they are signed again with a key of the rewriter.
+type rewriter struct {
+	from string
+	to   string
+	key  *signingKey
+}
+
+// newRewriter creates a rewriter of the responses of a provider
+func newRewriter(from string) (*rewriter, error) {
+	key, err := newSigningKey("gatekeepertest")
+	if err != nil {
+		return nil, err
+	}
+
+	return &rewriter{from: from, key: key}, nil
+}
+
+// header rewrites the value of a response header
+func (w *rewriter) header(value string) string {
+	return strings.Replace(value, w.from, w.to, -1)
+}
+
+// body rewrites the body of a response, with the times of the tokens shifted. It returns the tokens signed
+// again, mapped to the originals.
+func (w *rewriter) body(path, body string, shift time.Duration) (string, map[string]string, error) {
+	var document map[string]interface{}
+	var tokens map[string]string
+	if strings.HasSuffix(path, tokenPath) && json.Unmarshal([]byte(body), &document) == nil {
+		tokens = make(map[string]string)
+		for _, field := range tokenFields {
+			encoded, ok := document[field].(string)
+			if !ok {
+				continue
+			}
+			signed, err := w.resign(encoded, shift)
+			if err != nil {
+				return "", nil, fmt.Errorf("unable to sign the %s again: %v", field, err)
+			}
+			document[field] = signed
+			tokens[signed] = encoded
+		}
+		rewritten, err := json.Marshal(document)
+		if err != nil {
+			return "", nil, err
+		}
+		body = string(rewritten)
+	}
+
+	// the encoded tokens do not contain the origin
+	return strings.Replace(body, w.from, w.to, -1), tokens, nil
+}
+
+// resign signs a token with the key of the rewriter, for the issuer of the new origin, with its times shifted.
+// The opaque tokens are kept as is.
+func (w *rewriter) resign(encoded string, shift time.Duration) (string, error) {
+	token, err := jose.ParseJWT(encoded)
+	if err != nil {
+		return encoded, nil
+	}
+	claims, err := token.Claims()
+	if err != nil {
+		return "", err
+	}
+	if issuer, ok := claims["iss"].(string); ok {
+		claims["iss"] = strings.Replace(issuer, w.from, w.to, 1)
+	}
+	for _, name := range timeClaims {
+		if value, ok := claims[name].(float64); ok && value > 0 {
+			claims[name] = int64(value) + int64(shift.Seconds())
+		}
+	}
+
+	return w.key.sign(claims)
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/cel-go/cel"
+)
+
+// maxAccessExpressionCost bounds the cost of the evaluation of an access expression on a request
+const maxAccessExpressionCost = 10000
+
+// accessExpression is a compiled access expression of a resource: a CEL expression on the claims and the
+// request, which must hold for the requests to be admitted, e.g.
+//
+//	"admin" in claims.realm_access.roles || (method == "GET" && claims.tenant == query.tenant)
+type accessExpression struct {
+	program cel.Program
+}
+
+// newAccessExpressionEnv declares the variables of the access expressions
+func newAccessExpressionEnv() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("claims", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("method", cel.StringType),
+		cel.Variable("path", cel.StringType),
+		cel.Variable("query", cel.MapType(cel.StringType, cel.StringType)),
+		cel.Variable("client_ip", cel.StringType),
+	)
+}
+
+// compileAccessExpression parses and type-checks the access expression of a resource, an empty expression
+// yields nil
+func compileAccessExpression(source string) (*accessExpression, error) {
+	if strings.TrimSpace(source) == "" {
+		return nil, nil
+	}
+	env, err := newAccessExpressionEnv()
+	if err != nil {
+		return nil, err
+	}
+	ast, issues := env.Compile(source)
+	if issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	if ast.OutputType() != cel.BoolType {
+		return nil, fmt.Errorf("the expression yields a %s, not a bool", ast.OutputType())
+	}
+	program, err := env.Program(ast, cel.CostLimit(maxAccessExpressionCost), cel.InterruptCheckFrequency(100))
+	if err != nil {
+		return nil, err
+	}
+
+	return &accessExpression{program: program}, nil
+}
+
+// allows evaluates the expression on the request of a user
+func (x *accessExpression) allows(req *http.Request, user *userContext, timeout time.Duration) (bool, error) {
+	ctx := req.Context()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	claims := make(map[string]interface{})
+	if user != nil {
+		for k, v := range user.claims {
+			claims[k] = v
+		}
+	}
+	query := make(map[string]string)
+	for k, v := range req.URL.Query() {
+		query[k] = v[0]
+	}
+
+	v, _, err := x.program.ContextEval(ctx, map[string]interface{}{
+		"claims":    claims,
+		"method":    req.Method,
+		"path":      req.URL.Path,
+		"query":     query,
+		"client_ip": realIP(req),
+	})
+	if err != nil {
+		return false, err
+	}
+	allowed, ok := v.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("the expression yields a %s, not a bool", v.Type())
+	}
+
+	return allowed, nil
+}
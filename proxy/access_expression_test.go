@@ -0,0 +1,133 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompileAccessExpression(t *testing.T) {
+	cs := []struct {
+		Expression string
+		Ok         bool
+	}{
+		{Expression: "", Ok: true},
+		{Expression: `"admin" in claims.roles`, Ok: true},
+		{Expression: "method == \"GET\" &&\n  claims.level >= 2 // the readers", Ok: true},
+		{Expression: `size(query) < 3 || client_ip.startsWith("10.")`, Ok: true},
+		{Expression: `method`},
+		{Expression: `"admin" in roles`},
+		{Expression: `path + 1 == "/1"`},
+		{Expression: `claims.level >`},
+		{Expression: `unknown == 1`},
+	}
+	for i, c := range cs {
+		_, err := compileAccessExpression(c.Expression)
+		assert.Equal(t, c.Ok, err == nil, "case %d, expression: %q, error: %v", i, c.Expression, err)
+	}
+}
+
+func TestAccessExpressionAllows(t *testing.T) {
+	user := &userContext{
+		id: "1e11e539",
+		claims: map[string]interface{}{
+			"tenant":  "acme",
+			"level":   float64(3),
+			"roles":   []interface{}{"admin", "test"},
+			"groups":  []interface{}{"devops"},
+			"address": map[string]interface{}{"country": "fr"},
+		},
+	}
+	cs := []struct {
+		Expression string
+		Allowed    bool
+	}{
+		{Expression: `"admin" in claims.roles`, Allowed: true},
+		{Expression: `"ops" in claims.groups`},
+		{Expression: `"country" in claims.address && !has(claims.address.city)`, Allowed: true},
+		{Expression: `claims.level >= 3 && claims.level < 4`, Allowed: true},
+		{Expression: `claims.level > 3`},
+		{Expression: `int(query.limit) <= 100 && query.tenant == claims.tenant`, Allowed: true},
+		{Expression: `int(query.limit) > 99`, Allowed: true},
+		{Expression: `"missing" in query`},
+		{Expression: `client_ip == "10.0.0.1"`, Allowed: true},
+		{Expression: `method == "POST" || size(claims.roles) == 2`, Allowed: true},
+		{Expression: `path.matches("^/api/") && path.endsWith("s")`, Allowed: true},
+	}
+	for i, c := range cs {
+		x, err := compileAccessExpression(c.Expression)
+		require.NoError(t, err, "case %d", i)
+		req := httptest.NewRequest(http.MethodGet, "/api/items?limit=100&tenant=acme", nil)
+		req.Header.Set("X-Forwarded-For", "10.0.0.1")
+		allowed, err := x.allows(req, user, time.Second)
+		require.NoError(t, err, "case %d", i)
+		assert.Equal(t, c.Allowed, allowed, "case %d, expression: %q", i, c.Expression)
+	}
+}
+
+func TestAccessExpressionErrors(t *testing.T) {
+	x, err := compileAccessExpression(`claims.missing == "value"`)
+	require.NoError(t, err)
+	_, err = x.allows(httptest.NewRequest(http.MethodGet, "/", nil), nil, time.Second)
+	assert.Error(t, err)
+}
+
+func TestAccessExpressionAdmission(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.Resources = []*Resource{
+		{
+			URL:              "/reports/*",
+			Methods:          allHTTPMethods,
+			AccessExpression: `method == "GET" && claims.tenant == query.tenant`,
+		},
+	}
+	requests := []fakeRequest{
+		{
+			URI:           "/reports/1?tenant=acme",
+			HasToken:      true,
+			TokenClaims:   map[string]interface{}{"tenant": "acme"},
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+		},
+		{
+			URI:          "/reports/1?tenant=other",
+			HasToken:     true,
+			TokenClaims:  map[string]interface{}{"tenant": "acme"},
+			ExpectedCode: http.StatusForbidden,
+		},
+		{
+			URI:          "/reports/1?tenant=acme",
+			Method:       http.MethodDelete,
+			HasToken:     true,
+			TokenClaims:  map[string]interface{}{"tenant": "acme"},
+			ExpectedCode: http.StatusForbidden,
+		},
+	}
+	newFakeProxy(cfg).RunTests(t, requests)
+}
+
+func TestInvalidResourceAccessExpression(t *testing.T) {
+	assert.Error(t, (&Resource{URL: "/test", AccessExpression: `claims.roles ==`}).valid())
+	assert.Error(t, (&Resource{URL: "/test", AccessExpression: `"admin" in roles`}).valid())
+	assert.Error(t, (&Resource{URL: "/test", WhiteListed: true, AccessExpression: `"admin" in claims.roles`}).valid())
+	assert.NoError(t, (&Resource{URL: "/test", AccessExpression: `"admin" in claims.roles`}).valid())
+}
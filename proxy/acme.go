@@ -0,0 +1,89 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// acmeManager returns the manager of the certificates obtained from the acme authority. The manager is shared by
+// the listeners, so that the certificates are obtained and renewed once.
+func (r *oauthProxy) acmeManager(config listenerConfig) *autocert.Manager {
+	if r.acme != nil {
+		return r.acme
+	}
+	r.log.Info("enabling letsencrypt tls support",
+		zap.String("challenge", r.config.LetsEncryptChallenge),
+		zap.String("directory", r.config.LetsEncryptDirectoryURL),
+		zap.Duration("renew_before", r.config.LetsEncryptRenewBefore))
+
+	r.acme = &autocert.Manager{
+		Prompt:      autocert.AcceptTOS,
+		Cache:       autocert.DirCache(config.letsEncryptCacheDir),
+		HostPolicy:  acmeHostPolicy(config.hostnames, config.redirectionURL),
+		Email:       r.config.LetsEncryptEmail,
+		RenewBefore: r.config.LetsEncryptRenewBefore,
+	}
+	if r.config.LetsEncryptDirectoryURL != "" {
+		r.acme.Client = &acme.Client{DirectoryURL: r.config.LetsEncryptDirectoryURL}
+	}
+
+	return r.acme
+}
+
+// acmeHostPolicy restricts the certificates requested to the hostnames of the service, or else to the host of
+// the redirection url
+func acmeHostPolicy(hostnames []string, redirectionURL string) autocert.HostPolicy {
+	return func(_ context.Context, host string) error {
+		if len(hostnames) > 0 {
+			for _, h := range hostnames {
+				if h == host {
+					return nil
+				}
+			}
+
+			return ErrHostNotConfigured
+		}
+		if redirectionURL != "" {
+			u, err := url.Parse(redirectionURL)
+			if err != nil {
+				return err
+			}
+			if u.Hostname() != host {
+				return ErrHostNotConfigured
+			}
+		}
+
+		return nil
+	}
+}
+
+// acmeHTTPHandler answers the http-01 challenges on the http listener, the other requests being served by the
+// handler
+func (r *oauthProxy) acmeHTTPHandler(handler http.Handler) http.Handler {
+	if !r.config.UseLetsEncrypt || r.config.LetsEncryptChallenge != acmeHTTPChallenge || r.acme == nil {
+		return handler
+	}
+	r.log.Info("answering the letsencrypt http-01 challenges", zap.String("interface", r.config.ListenHTTP))
+
+	return r.acme.HTTPHandler(handler)
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestIsLetsEncryptValid(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Config Config
+		Error  string
+	}{
+		{
+			Name:   "disabled",
+			Config: Config{LetsEncryptChallenge: "unknown"},
+		},
+		{
+			Name: "tls-alpn-01",
+			Config: Config{
+				UseLetsEncrypt:       true,
+				LetsEncryptCacheDir:  "./cache",
+				LetsEncryptChallenge: acmeTLSALPNChallenge,
+				Hostnames:            []string{"example.com"},
+			},
+		},
+		{
+			Name: "http-01",
+			Config: Config{
+				UseLetsEncrypt:          true,
+				LetsEncryptCacheDir:     "./cache",
+				LetsEncryptChallenge:    acmeHTTPChallenge,
+				LetsEncryptDirectoryURL: "https://acme-staging-v02.api.letsencrypt.org/directory",
+				ListenHTTP:              ":80",
+				RedirectionURL:          "https://example.com",
+			},
+		},
+		{
+			Name: "missing cache dir",
+			Config: Config{
+				UseLetsEncrypt:       true,
+				LetsEncryptChallenge: acmeTLSALPNChallenge,
+				Hostnames:            []string{"example.com"},
+			},
+			Error: "the letsencrypt cache dir has not been set",
+		},
+		{
+			Name: "http-01 without http listener",
+			Config: Config{
+				UseLetsEncrypt:       true,
+				LetsEncryptCacheDir:  "./cache",
+				LetsEncryptChallenge: acmeHTTPChallenge,
+				Hostnames:            []string{"example.com"},
+			},
+			Error: "listen-http must be set",
+		},
+		{
+			Name: "unknown challenge",
+			Config: Config{
+				UseLetsEncrypt:       true,
+				LetsEncryptCacheDir:  "./cache",
+				LetsEncryptChallenge: "dns-01",
+				Hostnames:            []string{"example.com"},
+			},
+			Error: "the letsencrypt challenge must be either",
+		},
+		{
+			Name: "plain http directory",
+			Config: Config{
+				UseLetsEncrypt:          true,
+				LetsEncryptCacheDir:     "./cache",
+				LetsEncryptChallenge:    acmeTLSALPNChallenge,
+				LetsEncryptDirectoryURL: "http://127.0.0.1:14000/dir",
+				Hostnames:               []string{"example.com"},
+			},
+			Error: "must be an https url",
+		},
+		{
+			Name: "negative renewal",
+			Config: Config{
+				UseLetsEncrypt:         true,
+				LetsEncryptCacheDir:    "./cache",
+				LetsEncryptChallenge:   acmeTLSALPNChallenge,
+				LetsEncryptRenewBefore: -time.Hour,
+				Hostnames:              []string{"example.com"},
+			},
+			Error: "positive duration",
+		},
+		{
+			Name: "any host",
+			Config: Config{
+				UseLetsEncrypt:       true,
+				LetsEncryptCacheDir:  "./cache",
+				LetsEncryptChallenge: acmeTLSALPNChallenge,
+			},
+			Error: "the hostnames or the redirection url must be set",
+		},
+	}
+	for _, c := range tests {
+		err := c.Config.isLetsEncryptValid()
+		if c.Error == "" {
+			assert.NoError(t, err, c.Name)
+			continue
+		}
+		if assert.Error(t, err, c.Name) {
+			assert.Contains(t, err.Error(), c.Error, c.Name)
+		}
+	}
+}
+
+func TestACMEHostPolicy(t *testing.T) {
+	ctx := context.Background()
+
+	policy := acmeHostPolicy([]string{"a.example.com", "b.example.com"}, "https://c.example.com")
+	assert.NoError(t, policy(ctx, "a.example.com"))
+	assert.NoError(t, policy(ctx, "b.example.com"))
+	assert.Equal(t, ErrHostNotConfigured, policy(ctx, "c.example.com"))
+
+	policy = acmeHostPolicy(nil, "https://c.example.com:8443/oauth")
+	assert.NoError(t, policy(ctx, "c.example.com"))
+	assert.Equal(t, ErrHostNotConfigured, policy(ctx, "a.example.com"))
+}
+
+func TestACMEHTTPHandler(t *testing.T) {
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	config := newFakeKeycloakConfig()
+	config.UseLetsEncrypt = true
+	config.LetsEncryptCacheDir = "./cache"
+	config.LetsEncryptChallenge = acmeHTTPChallenge
+	config.ListenHTTP = "127.0.0.1:0"
+	config.Hostnames = []string{"example.com"}
+	proxy := &oauthProxy{config: config, log: zap.NewNop()}
+	proxy.acmeManager(makeListenerConfig(config))
+	assert.Same(t, proxy.acme, proxy.acmeManager(makeListenerConfig(config)), "the manager must be shared by the listeners")
+	assert.Equal(t, config.LetsEncryptRenewBefore, proxy.acme.RenewBefore)
+
+	svc := httptest.NewServer(proxy.acmeHTTPHandler(upstream))
+	defer svc.Close()
+
+	// the challenges are answered for the hostnames only, and are not found unless pending
+	resp, err := http.Get(svc.URL + "/.well-known/acme-challenge/token")
+	if assert.NoError(t, err) {
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	}
+	req, _ := http.NewRequest(http.MethodGet, svc.URL+"/.well-known/acme-challenge/token", nil)
+	req.Host = "example.com"
+	resp, err = http.DefaultClient.Do(req)
+	if assert.NoError(t, err) {
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	}
+	resp, err = http.Get(svc.URL + "/page")
+	if assert.NoError(t, err) {
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusTeapot, resp.StatusCode)
+	}
+
+	// the http listener serves the router only with the tls-alpn-01 challenge
+	config.LetsEncryptChallenge = acmeTLSALPNChallenge
+	handler := proxy.acmeHTTPHandler(upstream)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/.well-known/acme-challenge/token", nil))
+	assert.Equal(t, http.StatusTeapot, rec.Code)
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// drainResponse is the response of the admin api to a drain request
+type drainResponse struct {
+	Status   string    `json:"status"`
+	Deadline time.Time `json:"deadline"`
+}
+
+// isDraining checks if the service has been taken out of rotation
+func (r *oauthProxy) isDraining() bool {
+	return atomic.LoadInt32(&r.draining) == 1
+}
+
+// drainHandler takes the service out of rotation: the health check starts failing, and the main listeners
+// stop accepting connections while the in-flight requests complete, up to a deadline. The admin listener
+// keeps running, so the orchestration is able to observe the service state.
+func (r *oauthProxy) drainHandler(w http.ResponseWriter, req *http.Request) {
+	ctx, span, logger := r.traceSpan(req.Context(), "drain handler")
+	if span != nil {
+		defer span.End()
+	}
+	req = req.WithContext(ctx)
+
+	timeout := r.config.DrainTimeout
+	if value := req.URL.Query().Get("timeout"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 {
+			r.errorResponse(w, req, "timeout must be a positive duration", http.StatusBadRequest, nil)
+			return
+		}
+		timeout = parsed
+	}
+
+	if !atomic.CompareAndSwapInt32(&r.draining, 0, 1) {
+		r.errorResponse(w, req, "the service is already draining", http.StatusConflict, nil)
+		return
+	}
+	deadline := time.Now().Add(timeout)
+	logger.Warn("draining the service on operator request", zap.Duration("timeout", timeout))
+
+	go r.drain(deadline)
+
+	w.Header().Set("Content-Type", jsonMime)
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(drainResponse{Status: "draining", Deadline: deadline})
+}
+
+// drain gracefully shuts down the main listeners, forcing the remaining connections closed on the deadline
+func (r *oauthProxy) drain(deadline time.Time) {
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	for _, server := range []*http.Server{r.server, r.httpServer} {
+		if server == nil {
+			continue
+		}
+		server.SetKeepAlivesEnabled(false)
+		if err := server.Shutdown(ctx); err != nil {
+			r.log.Warn("in-flight requests did not complete before the drain deadline", zap.Error(err))
+			_ = server.Close()
+		}
+	}
+	r.log.Info("the service has been drained")
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDrainHandler(t *testing.T) {
+	p, cleanup := newFakeAdminAPIProxy(t)
+	defer cleanup()
+
+	admin := func(method, uri string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, uri, nil)
+		req.Header.Set(authorizationHeader, "Bearer secret")
+		w := httptest.NewRecorder()
+		p.proxy.adminRouter.ServeHTTP(w, req)
+		return w
+	}
+	healthURI := p.config.OAuthURI + healthURL
+
+	assert.Equal(t, http.StatusOK, admin(http.MethodGet, healthURI).Code)
+	resp, err := http.Get(p.getServiceURL() + healthURI)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+
+	assert.Equal(t, http.StatusBadRequest, admin(http.MethodPost, adminAPIURL+drainURL+"?timeout=soon").Code)
+	assert.Equal(t, http.StatusAccepted, admin(http.MethodPost, adminAPIURL+drainURL+"?timeout=1s").Code)
+	assert.Equal(t, http.StatusConflict, admin(http.MethodPost, adminAPIURL+drainURL).Code)
+	assert.Equal(t, http.StatusServiceUnavailable, admin(http.MethodGet, healthURI).Code)
+
+	// the main listener no longer accepts connections
+	assert.Eventually(t, func() bool {
+		resp, err := http.Get(p.getServiceURL() + healthURI)
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		return err != nil
+	}, 2*time.Second, 50*time.Millisecond)
+}
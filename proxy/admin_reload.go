@@ -0,0 +1,142 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+)
+
+const (
+	reloadCerts     = "certs"
+	reloadJWKS      = "jwks"
+	reloadDiscovery = "discovery"
+)
+
+// reloadResponse is the response of the admin api to a reload request
+type reloadResponse struct {
+	Reloaded string   `json:"reloaded"`
+	Details  []string `json:"details,omitempty"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// reloadHandler forces a reload of the tls certificates, the provider keys or the provider discovery,
+// e.g. after a rotation, without waiting for them to expire
+func (r *oauthProxy) reloadHandler(w http.ResponseWriter, req *http.Request) {
+	ctx, span, logger := r.traceSpan(req.Context(), "reload handler")
+	if span != nil {
+		defer span.End()
+	}
+	req = req.WithContext(ctx)
+
+	resp := reloadResponse{Reloaded: chi.URLParam(req, "target")}
+	var err error
+	switch resp.Reloaded {
+	case reloadCerts:
+		resp.Details, err = r.reloadCertificates()
+	case reloadJWKS:
+		resp.Details, err = r.reloadKeys()
+	case reloadDiscovery:
+		resp.Details, err = r.reloadDiscovery()
+	default:
+		r.errorResponse(w, req, fmt.Sprintf("unknown reload target, must be one of %s|%s|%s", reloadCerts, reloadJWKS, reloadDiscovery), http.StatusNotFound, nil)
+		return
+	}
+
+	code := http.StatusOK
+	if err != nil {
+		logger.Warn("unable to reload", zap.String("target", resp.Reloaded), zap.Error(err))
+		resp.Errors = append(resp.Errors, err.Error())
+		code = http.StatusUnprocessableEntity
+	} else {
+		logger.Info("reloaded on operator request", zap.String("target", resp.Reloaded), zap.Strings("details", resp.Details))
+	}
+
+	w.Header().Set("Content-Type", jsonMime)
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// reloadCertificates loads the tls certificates of the listeners from their files again
+func (r *oauthProxy) reloadCertificates() ([]string, error) {
+	if len(r.certs) == 0 {
+		return nil, errors.New("no tls certificate files are configured")
+	}
+	var details []string
+	for _, rotate := range r.certs {
+		expires, err := rotate.reload()
+		if err != nil {
+			return details, err
+		}
+		details = append(details, fmt.Sprintf("certificate %s valid until %s", rotate.certificateFile, expires.Format(time.RFC3339)))
+	}
+
+	return details, nil
+}
+
+// reloadKeys fetches the signing keys from the provider again
+func (r *oauthProxy) reloadKeys() ([]string, error) {
+	if !r.isProviderReady() {
+		return nil, errProviderDiscovery
+	}
+	keys := r.getProviderKeys()
+	if keys == nil {
+		return nil, errors.New("the provider does not publish any keys")
+	}
+	count, err := keys.reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{fmt.Sprintf("%d keys retrieved", count)}, nil
+}
+
+// reloadDiscovery fetches the provider configuration from the discovery url again. A configuration
+// for another issuer is rejected, as the tokens already issued would no longer be accepted.
+func (r *oauthProxy) reloadDiscovery() ([]string, error) {
+	if r.idpClient == nil {
+		return nil, errors.New("token verification is disabled")
+	}
+	if !r.isProviderReady() {
+		return nil, errProviderDiscovery
+	}
+	idp, err := r.fetchProviderConfig(r.idpClient)
+	if err != nil {
+		return nil, err
+	}
+	if err = idp.Valid(); err != nil {
+		return nil, err
+	}
+	current := r.getProviderConfig()
+	if idp.Issuer.String() != current.Issuer.String() {
+		return nil, fmt.Errorf("the provider issuer has changed from %s to %s", current.Issuer, idp.Issuer)
+	}
+	r.setProviderConfig(idp)
+
+	details := []string{fmt.Sprintf("provider configuration of %s", idp.Issuer)}
+	if keys := r.getProviderKeys(); keys != nil && idp.KeysEndpoint != nil && (current.KeysEndpoint == nil || idp.KeysEndpoint.String() != current.KeysEndpoint.String()) {
+		keys.setRepo(newSharedKeySetRepo(r.idpClient, idp.KeysEndpoint.String()))
+		details = append(details, fmt.Sprintf("keys endpoint changed to %s", idp.KeysEndpoint))
+	}
+
+	return details, nil
+}
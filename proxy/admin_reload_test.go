@@ -0,0 +1,62 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReloadHandler(t *testing.T) {
+	p, cleanup := newFakeAdminAPIProxy(t)
+	defer cleanup()
+
+	cases := []struct {
+		Target       string
+		ExpectedCode int
+	}{
+		{Target: reloadJWKS, ExpectedCode: http.StatusOK},
+		{Target: reloadDiscovery, ExpectedCode: http.StatusOK},
+		// the proxy is not listening with tls certificate files
+		{Target: reloadCerts, ExpectedCode: http.StatusUnprocessableEntity},
+		{Target: "unknown", ExpectedCode: http.StatusNotFound},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodPost, adminAPIURL+reloadURL+"/"+c.Target, nil)
+		req.Header.Set(authorizationHeader, "Bearer secret")
+		w := httptest.NewRecorder()
+		p.proxy.adminRouter.ServeHTTP(w, req)
+		require.Equal(t, c.ExpectedCode, w.Code, c.Target)
+		if c.ExpectedCode == http.StatusNotFound {
+			continue
+		}
+
+		var resp reloadResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp), c.Target)
+		assert.Equal(t, c.Target, resp.Reloaded)
+		if c.ExpectedCode == http.StatusOK {
+			assert.NotEmpty(t, resp.Details, c.Target)
+			assert.Empty(t, resp.Errors, c.Target)
+		} else {
+			assert.NotEmpty(t, resp.Errors, c.Target)
+		}
+	}
+}
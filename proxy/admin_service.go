@@ -1,11 +1,11 @@
-package main
+package proxy
 
 import (
 	"net/http"
 	"path"
+	"time"
 
 	"github.com/go-chi/chi"
-	"github.com/go-chi/chi/middleware"
 	"go.opencensus.io/zpages"
 	"go.uber.org/zap"
 )
@@ -21,7 +21,10 @@ func (r *oauthProxy) createAdminServices() {
 	adminEngine := chi.NewRouter()
 	adminEngine.MethodNotAllowed(emptyHandler)
 	adminEngine.NotFound(http.NotFound)
-	adminEngine.Use(middleware.Recoverer)
+	adminEngine.Use(r.recoveryMiddleware("admin"))
+	if _, maxBytes, maxCount := r.config.adminHeaderLimits(); maxBytes > 0 || maxCount > 0 {
+		adminEngine.Use(r.headerLimitsMiddleware(maxBytes, maxCount))
+	}
 	adminEngine.Use(proxyDenyMiddleware)
 
 	adminEngine.Route(r.config.OAuthURI,
@@ -29,6 +32,10 @@ func (r *oauthProxy) createAdminServices() {
 			e.Mount("/", r.createAdminRoutes())
 		})
 
+	if r.config.EnableAdminAPI {
+		adminEngine.Mount(adminAPIURL, r.createAdminAPIRoutes())
+	}
+
 	if debugEngine := r.createDebugRoutes(); debugEngine != nil {
 		adminEngine.Mount(debugURL, debugEngine)
 	}
@@ -60,15 +67,45 @@ func (r *oauthProxy) createAdminRoutes() chi.Router {
 	return admin
 }
 
+func (r *oauthProxy) createAdminAPIRoutes() chi.Router {
+	api := chi.NewRouter()
+	r.log.Info("enabling admin api", zap.String("path", adminAPIURL))
+	if r.config.AdminAPIToken == "" {
+		r.log.Warn("the admin api is not protected by a token (--admin-api-token)")
+	}
+	api.Use(r.adminAPIMiddleware)
+	api.Get(sessionsURL, r.listSessionsHandler)
+	api.Delete(sessionsURL, r.revokeSessionsHandler)
+	api.Post(reloadURL+"/{target}", r.reloadHandler)
+	api.Post(drainURL, r.drainHandler)
+	if r.faults != nil {
+		api.Get(faultsURL, r.listFaultsHandler)
+		api.Put(faultsURL, r.setFaultHandler)
+		api.Delete(faultsURL, r.clearFaultsHandler)
+	}
+
+	return api
+}
+
 func (r *oauthProxy) createDebugRoutes() chi.Router {
 	// step: define profiling endpoints
 	var debugEngine chi.Router
 	if r.config.EnableProfiling {
 		r.log.Warn("enabling debug profiling", zap.String("path", debugURL))
 		debugEngine = chi.NewRouter()
+		debugEngine.Use(r.profilingMiddleware(newProfilingGuard(r.config.ProfilingToken, r.config.ProfilingRateLimit, r.config.ProfilingDuration)))
 		debugEngine.Get("/{name}", r.debugHandler)
 		debugEngine.Post("/{name}", r.debugHandler)
 
+		if r.config.ProfilingToken == "" {
+			r.log.Warn("the profiling endpoints are not protected by a token (--profiling-token)")
+		}
+		if r.config.ProfilingDuration > 0 {
+			time.AfterFunc(r.config.ProfilingDuration, func() {
+				r.log.Info("profiling endpoints are now disabled", zap.String("path", debugURL))
+			})
+		}
+
 		// @check if the server write-timeout is still set and throw a warning
 		if r.config.ServerWriteTimeout > 0 {
 			r.log.Warn("you should disable the server write timeout (--server-write-timeout) when using pprof profiling")
@@ -0,0 +1,265 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultSessionsPageSize is the number of sessions returned when no limit is requested
+	defaultSessionsPageSize = 100
+	// maxSessionsPageSize is the maximum number of sessions returned at once
+	maxSessionsPageSize = 1000
+)
+
+// storedSession is the record of a user session kept in the store
+type storedSession struct {
+	ID        string    `json:"id"`
+	Subject   string    `json:"sub"`
+	Email     string    `json:"email,omitempty"`
+	Client    string    `json:"client,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// TokenKey is the store key of the refresh token of the session
+	TokenKey string `json:"token_key,omitempty"`
+}
+
+// sessionsPage is a page of stored sessions returned by the admin api
+type sessionsPage struct {
+	Sessions []*storedSession `json:"sessions"`
+	Total    int              `json:"total"`
+	Offset   int              `json:"offset"`
+	Limit    int              `json:"limit"`
+}
+
+// revokedSessions is the response of the admin api to a revocation of sessions
+type revokedSessions struct {
+	Subject string `json:"sub"`
+	Revoked int    `json:"revoked"`
+}
+
+// sessionsFilter selects the stored sessions returned by the admin api
+type sessionsFilter struct {
+	subject string
+	client  string
+	ip      string
+	minAge  time.Duration
+	maxAge  time.Duration
+}
+
+// sessionID returns the identifier of a user session: the provider session, or the access token otherwise
+func sessionID(user *userContext) string {
+	if session := user.getSessionID(); session != "" {
+		return session
+	}
+
+	return getHashKey(&user.token)
+}
+
+// parseSessionsFilter extracts the sessions filter from the query of a request
+func parseSessionsFilter(req *http.Request) (sessionsFilter, error) {
+	query := req.URL.Query()
+	filter := sessionsFilter{
+		subject: query.Get("sub"),
+		client:  query.Get("client"),
+		ip:      query.Get("ip"),
+	}
+	for name, age := range map[string]*time.Duration{"min_age": &filter.minAge, "max_age": &filter.maxAge} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 {
+			return filter, errors.New(name + " must be a positive duration")
+		}
+		*age = parsed
+	}
+
+	return filter, nil
+}
+
+// matches checks if a stored session is selected by the filter
+func (f sessionsFilter) matches(session *storedSession, now time.Time) bool {
+	age := now.Sub(session.CreatedAt)
+	switch {
+	case f.subject != "" && session.Subject != f.subject:
+		return false
+	case f.client != "" && session.Client != f.client:
+		return false
+	case f.ip != "" && session.IP != f.ip:
+		return false
+	case f.minAge > 0 && age < f.minAge:
+		return false
+	case f.maxAge > 0 && age > f.maxAge:
+		return false
+	}
+
+	return true
+}
+
+// parsePagination extracts the offset and limit from the query of a request
+func parsePagination(req *http.Request) (int, int, error) {
+	offset, limit := 0, defaultSessionsPageSize
+	if value := req.URL.Query().Get("offset"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			return 0, 0, errors.New("offset must be a positive number")
+		}
+		offset = parsed
+	}
+	if value := req.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > maxSessionsPageSize {
+			return 0, 0, errors.New("limit must be a number between 1 and " + strconv.Itoa(maxSessionsPageSize))
+		}
+		limit = parsed
+	}
+
+	return offset, limit, nil
+}
+
+// listSessionsHandler returns the active sessions kept in the store, most recent first
+func (r *oauthProxy) listSessionsHandler(w http.ResponseWriter, req *http.Request) {
+	ctx, span, _ := r.traceSpan(req.Context(), "list sessions handler")
+	if span != nil {
+		defer span.End()
+	}
+	req = req.WithContext(ctx)
+
+	if !r.useStore() {
+		r.errorResponse(w, req, "listing sessions requires a server-side store", http.StatusNotImplemented, nil)
+		return
+	}
+	filter, err := parseSessionsFilter(req)
+	if err != nil {
+		r.errorResponse(w, req, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+	offset, limit, err := parsePagination(req)
+	if err != nil {
+		r.errorResponse(w, req, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	sessions, err := r.ListStoredSessions()
+	if err != nil {
+		r.errorResponse(w, req, "unable to list the sessions from the store", http.StatusInternalServerError, err)
+		return
+	}
+
+	now := time.Now()
+	selected := make([]*storedSession, 0, len(sessions))
+	for _, session := range sessions {
+		if session.ExpiresAt.After(now) && filter.matches(session, now) {
+			session.TokenKey = ""
+			selected = append(selected, session)
+		}
+	}
+	sort.Slice(selected, func(i, j int) bool {
+		return selected[i].CreatedAt.After(selected[j].CreatedAt)
+	})
+
+	page := sessionsPage{
+		Sessions: []*storedSession{},
+		Total:    len(selected),
+		Offset:   offset,
+		Limit:    limit,
+	}
+	if offset < len(selected) {
+		end := offset + limit
+		if end > len(selected) {
+			end = len(selected)
+		}
+		page.Sessions = selected[offset:end]
+	}
+
+	w.Header().Set("Content-Type", jsonMime)
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(page)
+}
+
+// revokeSessionsHandler removes all the sessions of a subject and rejects the tokens already issued to it
+func (r *oauthProxy) revokeSessionsHandler(w http.ResponseWriter, req *http.Request) {
+	ctx, span, logger := r.traceSpan(req.Context(), "revoke sessions handler")
+	if span != nil {
+		defer span.End()
+	}
+	req = req.WithContext(ctx)
+
+	if !r.useStore() {
+		r.errorResponse(w, req, "revoking sessions requires a server-side store", http.StatusNotImplemented, nil)
+		return
+	}
+	subject := req.URL.Query().Get("sub")
+	if subject == "" {
+		r.errorResponse(w, req, "the subject of the sessions to revoke must be specified (sub)", http.StatusBadRequest, nil)
+		return
+	}
+
+	revoked, err := r.RevokeSubjectSessions(subject, time.Now())
+	if err != nil {
+		r.errorResponse(w, req, "unable to revoke the sessions from the store", http.StatusInternalServerError, err)
+		return
+	}
+	logger.Info("revoked the sessions of subject", zap.String("sub", subject), zap.Int("sessions", revoked))
+
+	w.Header().Set("Content-Type", jsonMime)
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(revokedSessions{Subject: subject, Revoked: revoked})
+}
+
+// isSubjectRevoked checks if a token has been issued before the sessions of its subject were revoked.
+// A store which can't be reached is not considered as a revocation.
+func (r *oauthProxy) isSubjectRevoked(user *userContext) bool {
+	if !r.useStore() {
+		return false
+	}
+	revokedAt, err := r.GetSubjectRevocation(user.id)
+	if err != nil {
+		r.log.Warn("unable to retrieve the revocation of the subject from the store", zap.Error(err))
+		return false
+	}
+	if revokedAt.IsZero() {
+		return false
+	}
+	issuedAt, found, err := user.claims.TimeClaim("iat")
+	if err != nil || !found {
+		return true
+	}
+
+	return issuedAt.Before(revokedAt)
+}
+
+// adminAPIMiddleware requires the admin api token on the admin api, if any is configured
+func (r *oauthProxy) adminAPIMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if r.config.AdminAPIToken != "" && !hasBearerToken(req, r.config.AdminAPIToken) {
+			r.errorResponse(w, req, "invalid or missing admin api token", http.StatusUnauthorized, nil)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
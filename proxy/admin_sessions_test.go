@@ -0,0 +1,219 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-oidc/jose"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newFakeAdminAPIProxy(t *testing.T) (*fakeProxy, func()) {
+	tmpfile, err := ioutil.TempFile("", "keycloak-gatekeeper")
+	require.NoError(t, err)
+	_ = tmpfile.Close()
+
+	cfg := newFakeKeycloakConfig()
+	cfg.ListenAdmin = "127.0.0.1:0"
+	cfg.EnableAdminAPI = true
+	cfg.AdminAPIToken = "secret"
+	cfg.StoreURL = fmt.Sprintf("boltdb:///%s", tmpfile.Name())
+	p := newFakeProxy(cfg)
+
+	return p, func() {
+		_ = p.proxy.CloseStore()
+		_ = os.Remove(tmpfile.Name())
+	}
+}
+
+func listStoredSessions(t *testing.T, p *fakeProxy, query string) (int, sessionsPage) {
+	req := httptest.NewRequest(http.MethodGet, adminAPIURL+sessionsURL+query, nil)
+	req.Header.Set(authorizationHeader, "Bearer secret")
+	w := httptest.NewRecorder()
+	p.proxy.adminRouter.ServeHTTP(w, req)
+
+	var page sessionsPage
+	if w.Code == http.StatusOK {
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&page))
+	}
+
+	return w.Code, page
+}
+
+func TestListSessions(t *testing.T) {
+	p, cleanup := newFakeAdminAPIProxy(t)
+	defer cleanup()
+
+	now := time.Now()
+	for _, session := range []*storedSession{
+		{ID: "1", Subject: "alice", Client: "app", IP: "10.0.0.1", CreatedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(time.Hour), TokenKey: "key1"},
+		{ID: "2", Subject: "alice", Client: "other", IP: "10.0.0.2", CreatedAt: now.Add(-time.Minute), ExpiresAt: now.Add(time.Hour), TokenKey: "key2"},
+		{ID: "3", Subject: "bob", Client: "app", IP: "10.0.0.1", CreatedAt: now.Add(-time.Hour), ExpiresAt: now.Add(time.Hour), TokenKey: "key3"},
+		{ID: "4", Subject: "bob", Client: "app", IP: "10.0.0.1", CreatedAt: now.Add(-time.Hour), ExpiresAt: now.Add(-time.Minute)},
+	} {
+		require.NoError(t, p.proxy.putStoredSession(session, time.Hour))
+	}
+
+	code, page := listStoredSessions(t, p, "")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 3, page.Total)
+	require.Len(t, page.Sessions, 3)
+	assert.Equal(t, "2", page.Sessions[0].ID)
+	assert.Equal(t, "3", page.Sessions[1].ID)
+	assert.Equal(t, "1", page.Sessions[2].ID)
+	for _, session := range page.Sessions {
+		assert.Empty(t, session.TokenKey)
+	}
+
+	cases := []struct {
+		Query    string
+		Expected []string
+	}{
+		{Query: "?sub=alice", Expected: []string{"2", "1"}},
+		{Query: "?client=app", Expected: []string{"3", "1"}},
+		{Query: "?ip=10.0.0.1&sub=bob", Expected: []string{"3"}},
+		{Query: "?min_age=30m", Expected: []string{"3", "1"}},
+		{Query: "?max_age=90m", Expected: []string{"2", "3"}},
+		{Query: "?limit=1&offset=1", Expected: []string{"3"}},
+		{Query: "?offset=5", Expected: []string{}},
+	}
+	for _, c := range cases {
+		code, page := listStoredSessions(t, p, c.Query)
+		require.Equal(t, http.StatusOK, code, c.Query)
+		ids := []string{}
+		for _, session := range page.Sessions {
+			ids = append(ids, session.ID)
+		}
+		assert.Equal(t, c.Expected, ids, c.Query)
+	}
+
+	for _, query := range []string{"?limit=0", "?limit=5000", "?offset=-1", "?min_age=yesterday"} {
+		code, _ := listStoredSessions(t, p, query)
+		assert.Equal(t, http.StatusBadRequest, code, query)
+	}
+}
+
+func TestListSessionsUnauthorized(t *testing.T) {
+	p, cleanup := newFakeAdminAPIProxy(t)
+	defer cleanup()
+
+	req := httptest.NewRequest(http.MethodGet, adminAPIURL+sessionsURL, nil)
+	w := httptest.NewRecorder()
+	p.proxy.adminRouter.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestStoredSessionLifecycle(t *testing.T) {
+	p, cleanup := newFakeAdminAPIProxy(t)
+	defer cleanup()
+
+	token := newTestToken(p.idp.getLocation())
+	token.merge(jose.Claims{claimSessionState: "session-1"})
+	signed, err := p.idp.signToken(token.claims)
+	require.NoError(t, err)
+	user, err := extractIdentity(*signed)
+	require.NoError(t, err)
+
+	require.NoError(t, p.proxy.StoreSession(user, "10.0.0.1", time.Hour))
+	sessions, err := p.proxy.ListStoredSessions()
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, "session-1", sessions[0].ID)
+	assert.Equal(t, user.id, sessions[0].Subject)
+	assert.Equal(t, "10.0.0.1", sessions[0].IP)
+
+	refreshed, err := p.idp.signToken(token.claims)
+	require.NoError(t, err)
+	require.NoError(t, p.proxy.RenewStoredSession("session-1", *refreshed, time.Hour))
+	sessions, err = p.proxy.ListStoredSessions()
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, getHashKey(refreshed), sessions[0].TokenKey)
+
+	require.NoError(t, p.proxy.DeleteStoredSession("session-1"))
+	sessions, err = p.proxy.ListStoredSessions()
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+}
+
+func TestRevokeSessions(t *testing.T) {
+	p, cleanup := newFakeAdminAPIProxy(t)
+	defer cleanup()
+
+	user, err := extractIdentity(newTestToken(p.idp.getLocation()).getToken())
+	require.NoError(t, err)
+	require.NoError(t, p.proxy.store.Set("refresh-key", "refresh-token", 0))
+	now := time.Now()
+	for _, session := range []*storedSession{
+		{ID: "1", Subject: user.id, CreatedAt: now, ExpiresAt: now.Add(time.Hour), TokenKey: "refresh-key"},
+		{ID: "2", Subject: "other", CreatedAt: now, ExpiresAt: now.Add(time.Hour)},
+	} {
+		require.NoError(t, p.proxy.putStoredSession(session, time.Hour))
+	}
+
+	revoke := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodDelete, adminAPIURL+sessionsURL+query, nil)
+		req.Header.Set(authorizationHeader, "Bearer secret")
+		w := httptest.NewRecorder()
+		p.proxy.adminRouter.ServeHTTP(w, req)
+		return w
+	}
+	assert.Equal(t, http.StatusBadRequest, revoke("").Code)
+
+	w := revoke("?sub=" + user.id)
+	require.Equal(t, http.StatusOK, w.Code)
+	var revoked revokedSessions
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&revoked))
+	assert.Equal(t, 1, revoked.Revoked)
+
+	sessions, err := p.proxy.ListStoredSessions()
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, "other", sessions[0].Subject)
+	refresh, err := p.proxy.store.Get("refresh-key")
+	require.NoError(t, err)
+	assert.Empty(t, refresh)
+
+	// the tokens issued after the revocation are issued in the future, within the leeway of the clocks
+	p.config.ClockSkewLeeway = 2 * time.Minute
+	requests := []fakeRequest{
+		{
+			URI:          testAdminURI,
+			HasToken:     true,
+			Roles:        []string{fakeAdminRole},
+			TokenClaims:  jose.Claims{"iat": float64(now.Add(-time.Minute).Unix())},
+			ExpectedCode: http.StatusUnauthorized,
+		},
+		{
+			URI:           testAdminURI,
+			HasToken:      true,
+			Roles:         []string{fakeAdminRole},
+			TokenClaims:   jose.Claims{"iat": float64(now.Add(time.Minute).Unix())},
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+		},
+	}
+	p.RunTests(t, requests)
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// allowedHostsMiddleware rejects the requests for hosts other than the allowed ones, including the forwarded hosts
+// the redirections may be built from
+func (r *oauthProxy) allowedHostsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Host == "" {
+			r.errorResponse(w, req, "missing host header", http.StatusBadRequest, nil)
+			return
+		}
+		hosts := []string{req.Host}
+		for _, forwarded := range strings.Split(req.Header.Get("X-Forwarded-Host"), ",") {
+			if forwarded = strings.TrimSpace(forwarded); forwarded != "" {
+				hosts = append(hosts, forwarded)
+			}
+		}
+		for _, host := range hosts {
+			allowed, err := isAllowedHost(host, r.config.AllowedHosts)
+			if err != nil {
+				r.errorResponse(w, req, "invalid host header", http.StatusBadRequest, err)
+				return
+			}
+			if !allowed {
+				_, logger := r.traceSpanRequest(req)
+				logger.Warn("request for a host which is not allowed", zap.String("host", host))
+				errorResponse(w, "misdirected request", http.StatusMisdirectedRequest)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// isAllowedHost checks a host header, possibly with a port, against the allowed host patterns.
+// Patterns without a port match any port.
+func isAllowedHost(host string, patterns []string) (bool, error) {
+	hostname := host
+	if strings.Contains(host, ":") {
+		h, _, err := net.SplitHostPort(host)
+		if err != nil {
+			if strings.Count(host, ":") < 2 {
+				return false, err
+			}
+			// an IPv6 address without a port
+			h = host
+		}
+		hostname = h
+	}
+	hostname = strings.Trim(hostname, "[]")
+	if hostname == "" || strings.ContainsAny(hostname, "/\\@ ") {
+		return false, fmt.Errorf("invalid host %q", host)
+	}
+
+	return matchHostname(hostname, patterns) || matchHostname(host, patterns), nil
+}
+
+// isAllowedHostValid checks an allowed host pattern
+func isAllowedHostValid(host string) error {
+	if host == "" || strings.ContainsAny(host, "/\\@ ") || strings.Contains(strings.TrimPrefix(host, "*."), wildcard) {
+		return fmt.Errorf("invalid allowed host: %q. Expect a hostname, possibly with a port, e.g. app.example.com, *.example.com or app.example.com:8443", host)
+	}
+
+	return nil
+}
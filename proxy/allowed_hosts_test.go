@@ -0,0 +1,101 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsAllowedHost(t *testing.T) {
+	patterns := []string{"app.example.com", "*.apps.example.com", "admin.example.com:8443", "127.0.0.1", "::1"}
+	cs := []struct {
+		Host    string
+		Allowed bool
+		Invalid bool
+	}{
+		{Host: "app.example.com", Allowed: true},
+		{Host: "APP.example.com.", Allowed: true},
+		{Host: "app.example.com:3000", Allowed: true},
+		{Host: "one.apps.example.com", Allowed: true},
+		{Host: "apps.example.com"},
+		{Host: "admin.example.com:8443", Allowed: true},
+		{Host: "admin.example.com"},
+		{Host: "admin.example.com:443"},
+		{Host: "127.0.0.1:8080", Allowed: true},
+		{Host: "[::1]:8080", Allowed: true},
+		{Host: "::1", Allowed: true},
+		{Host: "evil.com"},
+		{Host: "app.example.com.evil.com"},
+		{Host: "evil.com/app.example.com", Invalid: true},
+		{Host: ":8080", Invalid: true},
+	}
+	for i, c := range cs {
+		allowed, err := isAllowedHost(c.Host, patterns)
+		assert.Equal(t, c.Invalid, err != nil, "case %d, host %s, error: %v", i, c.Host, err)
+		assert.Equal(t, c.Allowed, allowed, "case %d, host %s", i, c.Host)
+	}
+}
+
+func TestIsAllowedHostValid(t *testing.T) {
+	assert.NoError(t, isAllowedHostValid("app.example.com"))
+	assert.NoError(t, isAllowedHostValid("*.example.com"))
+	assert.NoError(t, isAllowedHostValid("app.example.com:8443"))
+	assert.Error(t, isAllowedHostValid(""))
+	assert.Error(t, isAllowedHostValid("https://app.example.com"))
+	assert.Error(t, isAllowedHostValid("app.*.com"))
+}
+
+func TestAllowedHostsMiddleware(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.AllowedHosts = []string{"127.0.0.1", "app.example.com"}
+	newFakeProxy(cfg).RunTests(t, []fakeRequest{
+		{
+			URI:           fakeTestWhitelistedURL,
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+		},
+		{
+			URI:           fakeTestWhitelistedURL,
+			Headers:       map[string]string{"X-Forwarded-Host": "app.example.com"},
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+		},
+		{
+			URI:          fakeTestWhitelistedURL,
+			Headers:      map[string]string{"X-Forwarded-Host": "evil.com"},
+			ExpectedCode: http.StatusMisdirectedRequest,
+		},
+		{
+			URI:          fakeTestWhitelistedURL,
+			Headers:      map[string]string{"X-Forwarded-Host": "app.example.com, evil.com"},
+			ExpectedCode: http.StatusMisdirectedRequest,
+		},
+		{
+			URI:          fakeTestWhitelistedURL,
+			Headers:      map[string]string{"Host": "evil.com"},
+			ExpectedCode: http.StatusMisdirectedRequest,
+		},
+		{
+			// the login redirection is not built from an unknown host
+			URI:          fakeAuthAllURL,
+			Headers:      map[string]string{"X-Forwarded-Host": "evil.com"},
+			ExpectedCode: http.StatusMisdirectedRequest,
+		},
+	})
+}
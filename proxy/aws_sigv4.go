@@ -0,0 +1,453 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	sha "crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oneconcern/keycloak-gatekeeper/version"
+)
+
+const (
+	awsSigV4Algorithm   = "AWS4-HMAC-SHA256"
+	awsSigV4DateFormat  = "20060102T150405Z"
+	awsHeaderDate       = "X-Amz-Date"
+	awsHeaderToken      = "X-Amz-Security-Token"
+	awsHeaderSHA256     = "X-Amz-Content-Sha256"
+	awsIMDSEndpoint     = "http://169.254.169.254"
+	awsECSEndpoint      = "http://169.254.170.2"
+	awsCredentialsLease = 5 * time.Minute
+	// awsSigV4MaxPayload is the size of the largest body read to be signed
+	awsSigV4MaxPayload = 10 << 20
+	// awsUnsignedPayload is the payload hash of the s3 requests streamed without signing their body
+	awsUnsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+var (
+	errNoAWSCredentials = errors.New("no aws credentials found in the environment, web identity or instance profile")
+	// errAWSPayloadTooLarge indicates the body of a request is too large to be signed
+	errAWSPayloadTooLarge = errors.New("the request body is too large to be signed")
+)
+
+// awsCredentials are the credentials signing the upstream requests
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// Expiration is zero for static credentials
+	Expiration time.Time
+}
+
+// awsCredentialsProvider resolves the aws credentials like the aws sdks: from the environment, a web identity
+// token (e.g. IRSA on EKS), the container credentials (ECS) or the instance profile. The temporary credentials
+// are cached until shortly before their expiry.
+type awsCredentialsProvider struct {
+	sync.Mutex
+	client *http.Client
+	cached *awsCredentials
+	// the endpoints are overridden by tests
+	stsEndpoint  string
+	imdsEndpoint string
+	ecsEndpoint  string
+}
+
+func newAWSCredentialsProvider() *awsCredentialsProvider {
+	return &awsCredentialsProvider{
+		client:       &http.Client{Timeout: 5 * time.Second},
+		imdsEndpoint: awsIMDSEndpoint,
+		ecsEndpoint:  awsECSEndpoint,
+	}
+}
+
+// get returns valid credentials
+func (p *awsCredentialsProvider) get(ctx context.Context) (*awsCredentials, error) {
+	if id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+		return &awsCredentials{AccessKeyID: id, SecretAccessKey: secret, SessionToken: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	if p.cached != nil && time.Until(p.cached.Expiration) > awsCredentialsLease {
+		return p.cached, nil
+	}
+
+	var credentials *awsCredentials
+	var err error
+	switch {
+	case os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE") != "" && os.Getenv("AWS_ROLE_ARN") != "":
+		credentials, err = p.assumeRoleWithWebIdentity(ctx)
+	case os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI") != "":
+		credentials, err = p.getJSONCredentials(ctx, p.ecsEndpoint+os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"), nil)
+	default:
+		credentials, err = p.getInstanceProfileCredentials(ctx)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", errNoAWSCredentials, err)
+	}
+	p.cached = credentials
+
+	return credentials, nil
+}
+
+// assumeRoleWithWebIdentity exchanges the web identity token of the workload for temporary credentials
+func (p *awsCredentialsProvider) assumeRoleWithWebIdentity(ctx context.Context) (*awsCredentials, error) {
+	token, err := ioutil.ReadFile(os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"))
+	if err != nil {
+		return nil, err
+	}
+	endpoint := p.stsEndpoint
+	if endpoint == "" {
+		endpoint = "https://sts.amazonaws.com"
+		if region := awsRegion(""); region != "" {
+			endpoint = "https://sts." + region + ".amazonaws.com"
+		}
+	}
+	session := os.Getenv("AWS_ROLE_SESSION_NAME")
+	if session == "" {
+		session = version.Prog
+	}
+	form := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {os.Getenv("AWS_ROLE_ARN")},
+		"RoleSessionName":  {session},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	content, err := p.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Credentials struct {
+			AccessKeyID     string    `xml:"AccessKeyId"`
+			SecretAccessKey string    `xml:"SecretAccessKey"`
+			SessionToken    string    `xml:"SessionToken"`
+			Expiration      time.Time `xml:"Expiration"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err := xml.Unmarshal(content, &response); err != nil {
+		return nil, err
+	}
+
+	return &awsCredentials{
+		AccessKeyID:     response.Credentials.AccessKeyID,
+		SecretAccessKey: response.Credentials.SecretAccessKey,
+		SessionToken:    response.Credentials.SessionToken,
+		Expiration:      response.Credentials.Expiration,
+	}, nil
+}
+
+// getInstanceProfileCredentials retrieves the credentials of the instance profile from the metadata service (IMDSv2)
+func (p *awsCredentialsProvider) getInstanceProfileCredentials(ctx context.Context) (*awsCredentials, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, p.imdsEndpoint+"/latest/api/token", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+	token, err := p.do(req)
+	if err != nil {
+		return nil, err
+	}
+	headers := map[string]string{"X-aws-ec2-metadata-token": string(token)}
+
+	base := p.imdsEndpoint + "/latest/meta-data/iam/security-credentials/"
+	if req, err = http.NewRequestWithContext(ctx, http.MethodGet, base, nil); err != nil {
+		return nil, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	roles, err := p.do(req)
+	if err != nil {
+		return nil, err
+	}
+	role := strings.TrimSpace(strings.SplitN(string(roles), "\n", 2)[0])
+	if role == "" {
+		return nil, errors.New("no instance profile")
+	}
+
+	return p.getJSONCredentials(ctx, base+role, headers)
+}
+
+// getJSONCredentials retrieves the credentials served in json by the container or instance metadata
+func (p *awsCredentialsProvider) getJSONCredentials(ctx context.Context, endpoint string, headers map[string]string) (*awsCredentials, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"); token != "" && headers == nil {
+		req.Header.Set(authorizationHeader, token)
+	}
+	content, err := p.do(req)
+	if err != nil {
+		return nil, err
+	}
+	var response struct {
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		Token           string    `json:"Token"`
+		Expiration      time.Time `json:"Expiration"`
+	}
+	if err := json.Unmarshal(content, &response); err != nil {
+		return nil, err
+	}
+
+	return &awsCredentials{
+		AccessKeyID:     response.AccessKeyID,
+		SecretAccessKey: response.SecretAccessKey,
+		SessionToken:    response.Token,
+		Expiration:      response.Expiration,
+	}, nil
+}
+
+func (p *awsCredentialsProvider) do(req *http.Request) ([]byte, error) {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s responded %d", req.Method, req.URL.Path, resp.StatusCode)
+	}
+
+	return content, nil
+}
+
+// awsRegion returns the region of a resource, defaulting to the region of the environment
+func awsRegion(region string) string {
+	if region != "" {
+		return region
+	}
+	if region = os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+
+	return os.Getenv("AWS_DEFAULT_REGION")
+}
+
+// awsSigner signs the upstream requests of a resource with AWS Signature Version 4
+type awsSigner struct {
+	service     string
+	region      string
+	credentials *awsCredentialsProvider
+	now         func() time.Time
+}
+
+// newAWSSigner returns the signer of a resource, or nil when its requests are not signed
+func (r *oauthProxy) newAWSSigner(resource *Resource) *awsSigner {
+	if resource == nil || resource.AWSSigV4Service == "" {
+		return nil
+	}
+	if r.awsCredentials == nil {
+		r.awsCredentials = newAWSCredentialsProvider()
+	}
+
+	return &awsSigner{
+		service:     resource.AWSSigV4Service,
+		region:      awsRegion(resource.AWSSigV4Region),
+		credentials: r.awsCredentials,
+		now:         time.Now,
+	}
+}
+
+// sign signs the request as sent upstream
+func (s *awsSigner) sign(req *http.Request) error {
+	credentials, err := s.credentials.get(req.Context())
+	if err != nil {
+		return err
+	}
+	hashedPayload, err := s.hashPayload(req)
+	if err != nil {
+		return err
+	}
+
+	now := s.now().UTC()
+	amzDate := now.Format(awsSigV4DateFormat)
+	scope := strings.Join([]string{amzDate[:8], s.region, s.service, "aws4_request"}, "/")
+
+	// any signature of the client is replaced
+	req.Header.Del(authorizationHeader)
+	req.Header.Set(awsHeaderDate, amzDate)
+	req.Header.Del(awsHeaderToken)
+	if credentials.SessionToken != "" {
+		req.Header.Set(awsHeaderToken, credentials.SessionToken)
+	}
+	if s.service == "s3" {
+		req.Header.Set(awsHeaderSHA256, hashedPayload)
+	}
+
+	// the headers set later on by the upstream proxy are not signed
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for _, name := range []string{awsHeaderDate, awsHeaderToken, awsHeaderSHA256} {
+		if value := req.Header.Get(name); value != "" {
+			headers[strings.ToLower(name)] = value
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.Join(strings.Fields(headers[name]), " ") + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s.canonicalURI(req.URL),
+		canonicalQuery(req.URL),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hashedPayload,
+	}, "\n")
+	canonicalHash := sha.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{awsSigV4Algorithm, amzDate, scope, hex.EncodeToString(canonicalHash[:])}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+credentials.SecretAccessKey), amzDate[:8])
+	for _, part := range []string{s.region, s.service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set(authorizationHeader, fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		awsSigV4Algorithm, credentials.AccessKeyID, scope, signedHeaders, signature))
+
+	return nil
+}
+
+// hashPayload hashes the body of the request, which is read then put back. The s3 bodies of unknown length or larger
+// than awsSigV4MaxPayload are streamed unsigned, and the larger bodies of the other services are refused.
+func (s *awsSigner) hashPayload(req *http.Request) (string, error) {
+	payload := []byte{}
+	if req.Body != nil && req.Body != http.NoBody {
+		if s.service == "s3" && (req.ContentLength < 0 || req.ContentLength > awsSigV4MaxPayload) {
+			return awsUnsignedPayload, nil
+		}
+		var err error
+		if payload, err = ioutil.ReadAll(io.LimitReader(req.Body, awsSigV4MaxPayload+1)); err != nil {
+			return "", err
+		}
+		if len(payload) > awsSigV4MaxPayload {
+			return "", errAWSPayloadTooLarge
+		}
+		_ = req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(payload))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(payload)), nil
+		}
+	}
+	payloadHash := sha.Sum256(payload)
+
+	return hex.EncodeToString(payloadHash[:]), nil
+}
+
+// canonicalURI returns the escaped path, escaped once more but for s3
+func (s *awsSigner) canonicalURI(u *url.URL) string {
+	escaped := u.EscapedPath()
+	if escaped == "" {
+		return "/"
+	}
+	if s.service == "s3" {
+		return escaped
+	}
+	segments := strings.Split(escaped, "/")
+	for i, segment := range segments {
+		segments[i] = awsEscape(segment)
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// canonicalQuery returns the query sorted by names and values, escaped the aws way
+func canonicalQuery(u *url.URL) string {
+	query := u.Query()
+	pairs := make([][2]string, 0, len(query))
+	for name, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, [2]string{awsEscape(name), awsEscape(value)})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] != pairs[j][0] {
+			return pairs[i][0] < pairs[j][0]
+		}
+		return pairs[i][1] < pairs[j][1]
+	})
+	encoded := make([]string, 0, len(pairs))
+	for _, pair := range pairs {
+		encoded = append(encoded, pair[0]+"="+pair[1])
+	}
+
+	return strings.Join(encoded, "&")
+}
+
+// awsEscape escapes all but the unreserved characters
+func awsEscape(value string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' || c == '_' || c == '.' || c == '~' {
+			escaped.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&escaped, "%%%02X", c)
+	}
+
+	return escaped.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha.New, key)
+	_, _ = mac.Write([]byte(data))
+
+	return mac.Sum(nil)
+}
@@ -0,0 +1,285 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var awsEnvironment = []string{
+	"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_REGION", "AWS_DEFAULT_REGION",
+	"AWS_WEB_IDENTITY_TOKEN_FILE", "AWS_ROLE_ARN", "AWS_ROLE_SESSION_NAME",
+	"AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "AWS_CONTAINER_AUTHORIZATION_TOKEN",
+}
+
+// setAWSEnvironment replaces the aws environment of the test, returning a function restoring it
+func setAWSEnvironment(values map[string]string) func() {
+	saved := make(map[string]string)
+	for _, name := range awsEnvironment {
+		if value, ok := os.LookupEnv(name); ok {
+			saved[name] = value
+		}
+		_ = os.Unsetenv(name)
+	}
+	for name, value := range values {
+		_ = os.Setenv(name, value)
+	}
+
+	return func() {
+		for _, name := range awsEnvironment {
+			_ = os.Unsetenv(name)
+		}
+		for name, value := range saved {
+			_ = os.Setenv(name, value)
+		}
+	}
+}
+
+func TestAWSSignerTestSuite(t *testing.T) {
+	defer setAWSEnvironment(map[string]string{
+		"AWS_ACCESS_KEY_ID":     "AKIDEXAMPLE",
+		"AWS_SECRET_ACCESS_KEY": "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	})()
+
+	// the vectors of the aws signature v4 test suite
+	cases := []struct {
+		URL       string
+		Signature string
+	}{
+		{
+			URL:       "https://example.amazonaws.com/",
+			Signature: "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			URL:       "https://example.amazonaws.com/?Param2=value2&Param1=value1",
+			Signature: "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+	}
+	signer := &awsSigner{
+		service:     "service",
+		region:      "us-east-1",
+		credentials: newAWSCredentialsProvider(),
+		now: func() time.Time {
+			return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+		},
+	}
+	for i, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, c.URL, nil)
+		req.Header.Set(authorizationHeader, "Bearer client")
+		require.NoError(t, signer.sign(req), "case %d", i)
+		assert.Equal(t, "20150830T123600Z", req.Header.Get(awsHeaderDate), "case %d", i)
+		assert.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, "+
+			"SignedHeaders=host;x-amz-date, Signature="+c.Signature, req.Header.Get(authorizationHeader), "case %d", i)
+	}
+}
+
+func TestAWSSignerBody(t *testing.T) {
+	defer setAWSEnvironment(map[string]string{
+		"AWS_ACCESS_KEY_ID":     "AKIDEXAMPLE",
+		"AWS_SECRET_ACCESS_KEY": "secret",
+		"AWS_SESSION_TOKEN":     "session",
+	})()
+
+	signer := &awsSigner{service: "s3", region: "eu-west-1", credentials: newAWSCredentialsProvider(), now: time.Now}
+	req := httptest.NewRequest(http.MethodPut, "https://bucket.s3.amazonaws.com/a%20b", nil)
+	req.Body = ioutil.NopCloser(strings.NewReader("content"))
+	require.NoError(t, signer.sign(req))
+
+	content, err := ioutil.ReadAll(req.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+	assert.Equal(t, "ed7002b439e9ac845f22357d822bac1444730fbdb6016d3ec9432297b9ec9f73", req.Header.Get(awsHeaderSHA256))
+	assert.Equal(t, "session", req.Header.Get(awsHeaderToken))
+	assert.Contains(t, req.Header.Get(authorizationHeader), "SignedHeaders=host;x-amz-content-sha256;x-amz-date;x-amz-security-token,")
+	assert.Equal(t, "/a%20b", signer.canonicalURI(req.URL))
+	signer.service = "execute-api"
+	assert.Equal(t, "/a%2520b", signer.canonicalURI(req.URL))
+}
+
+func TestAWSSignerLargeBody(t *testing.T) {
+	defer setAWSEnvironment(map[string]string{
+		"AWS_ACCESS_KEY_ID":     "AKIDEXAMPLE",
+		"AWS_SECRET_ACCESS_KEY": "secret",
+	})()
+
+	// the s3 bodies of unknown length are streamed unsigned
+	signer := &awsSigner{service: "s3", region: "eu-west-1", credentials: newAWSCredentialsProvider(), now: time.Now}
+	req := httptest.NewRequest(http.MethodPut, "https://bucket.s3.amazonaws.com/object", nil)
+	req.Body = ioutil.NopCloser(strings.NewReader("content"))
+	req.ContentLength = -1
+	require.NoError(t, signer.sign(req))
+	assert.Equal(t, awsUnsignedPayload, req.Header.Get(awsHeaderSHA256))
+	content, err := ioutil.ReadAll(req.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+
+	// the bodies of the other services are read up to the limit
+	signer.service = "execute-api"
+	req = httptest.NewRequest(http.MethodPost, "https://api.execute-api.eu-west-1.amazonaws.com/items", nil)
+	req.Body = ioutil.NopCloser(io.LimitReader(zeroReader{}, awsSigV4MaxPayload+1))
+	req.ContentLength = -1
+	assert.Equal(t, errAWSPayloadTooLarge, signer.sign(req))
+}
+
+// zeroReader reads zeros endlessly
+type zeroReader struct{}
+
+func (zeroReader) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = 0
+	}
+	return len(b), nil
+}
+
+func TestAWSInstanceProfileCredentials(t *testing.T) {
+	defer setAWSEnvironment(nil)()
+
+	var calls int
+	imds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		calls++
+		if req.Method == http.MethodPut && req.URL.Path == "/latest/api/token" {
+			_, _ = w.Write([]byte("imds-token"))
+			return
+		}
+		if req.Header.Get("X-aws-ec2-metadata-token") != "imds-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch req.URL.Path {
+		case "/latest/meta-data/iam/security-credentials/":
+			_, _ = w.Write([]byte("gatekeeper\n"))
+		case "/latest/meta-data/iam/security-credentials/gatekeeper":
+			_, _ = w.Write([]byte(`{"AccessKeyId":"ASIA","SecretAccessKey":"secret","Token":"session","Expiration":"` +
+				time.Now().Add(time.Hour).UTC().Format(time.RFC3339) + `"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer imds.Close()
+
+	provider := newAWSCredentialsProvider()
+	provider.imdsEndpoint = imds.URL
+	credentials, err := provider.get(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "ASIA", credentials.AccessKeyID)
+	assert.Equal(t, "secret", credentials.SecretAccessKey)
+	assert.Equal(t, "session", credentials.SessionToken)
+	assert.Equal(t, 3, calls)
+
+	// the credentials are cached until shortly before their expiry
+	_, err = provider.get(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 3, calls)
+
+	provider.imdsEndpoint = "http://127.0.0.1:1"
+	provider.cached = nil
+	_, err = provider.get(context.Background())
+	assert.Error(t, err)
+}
+
+func TestAWSWebIdentityCredentials(t *testing.T) {
+	file, err := ioutil.TempFile("", "web-identity")
+	require.NoError(t, err)
+	defer os.Remove(file.Name())
+	_, _ = file.WriteString("web-identity-token\n")
+	_ = file.Close()
+
+	defer setAWSEnvironment(map[string]string{
+		"AWS_WEB_IDENTITY_TOKEN_FILE": file.Name(),
+		"AWS_ROLE_ARN":                "arn:aws:iam::123456789012:role/gatekeeper",
+	})()
+
+	sts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.FormValue("Action") != "AssumeRoleWithWebIdentity" || req.FormValue("WebIdentityToken") != "web-identity-token" ||
+			req.FormValue("RoleArn") != "arn:aws:iam::123456789012:role/gatekeeper" || req.FormValue("RoleSessionName") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`<AssumeRoleWithWebIdentityResponse><AssumeRoleWithWebIdentityResult><Credentials>` +
+			`<AccessKeyId>ASIA</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>session</SessionToken>` +
+			`<Expiration>` + time.Now().Add(time.Hour).UTC().Format(time.RFC3339) + `</Expiration>` +
+			`</Credentials></AssumeRoleWithWebIdentityResult></AssumeRoleWithWebIdentityResponse>`))
+	}))
+	defer sts.Close()
+
+	provider := newAWSCredentialsProvider()
+	provider.stsEndpoint = sts.URL
+	credentials, err := provider.get(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "ASIA", credentials.AccessKeyID)
+	assert.Equal(t, "session", credentials.SessionToken)
+}
+
+func TestAWSSignedUpstream(t *testing.T) {
+	defer setAWSEnvironment(map[string]string{
+		"AWS_ACCESS_KEY_ID":     "AKIDTEST",
+		"AWS_SECRET_ACCESS_KEY": "secret",
+	})()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = w.Write([]byte(req.Header.Get(authorizationHeader)))
+	}))
+	defer upstream.Close()
+
+	cfg := newFakeKeycloakConfig()
+	cfg.Resources = []*Resource{
+		{
+			URL:             "/api/*",
+			Methods:         allHTTPMethods,
+			Upstream:        upstream.URL,
+			MaxIdleConns:    10,
+			AWSSigV4Service: "execute-api",
+			AWSSigV4Region:  "eu-west-1",
+		},
+	}
+	requests := []fakeRequest{
+		{
+			URI:                     "/api/items",
+			HasToken:                true,
+			ExpectedCode:            http.StatusOK,
+			ExpectedContentContains: "AWS4-HMAC-SHA256 Credential=AKIDTEST/",
+		},
+		{
+			URI:                     "/api/items",
+			HasToken:                true,
+			ExpectedCode:            http.StatusOK,
+			ExpectedContentContains: "/eu-west-1/execute-api/aws4_request, SignedHeaders=host;x-amz-date, Signature=",
+		},
+	}
+	newFakeProxy(cfg).RunTests(t, requests)
+}
+
+func TestAWSSigV4ResourceValid(t *testing.T) {
+	defer setAWSEnvironment(nil)()
+
+	assert.NoError(t, (&Resource{URL: "/*", AWSSigV4Service: "es", AWSSigV4Region: "eu-west-1"}).valid())
+	assert.Error(t, (&Resource{URL: "/*", AWSSigV4Service: "es"}).valid())
+	assert.Error(t, (&Resource{URL: "/*", AWSSigV4Region: "eu-west-1"}).valid())
+
+	_ = os.Setenv("AWS_REGION", "eu-west-1")
+	assert.NoError(t, (&Resource{URL: "/*", AWSSigV4Service: "es"}).valid())
+}
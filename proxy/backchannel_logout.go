@@ -0,0 +1,218 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/jose"
+	"go.uber.org/zap"
+)
+
+const (
+	// backChannelLogoutEvent is the event claimed by the logout tokens of the OpenID Connect back-channel logout
+	backChannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+	// logoutTokenMaxAge is the age past which a logout token is rejected, whatever its expiry. The ids of the
+	// tokens accepted are kept as long, so that a token can't be replayed.
+	logoutTokenMaxAge = 2 * time.Minute
+)
+
+// logoutToken is the user or session logged out by the provider
+type logoutToken struct {
+	id       string
+	subject  string
+	session  string
+	issuedAt time.Time
+}
+
+// backChannelLogoutHandler accepts the logout tokens posted by the provider when a user logs out of the provider
+// (OpenID Connect back-channel logout). The session of the token, or all the sessions of its subject, are revoked.
+func (r *oauthProxy) backChannelLogoutHandler(w http.ResponseWriter, req *http.Request) {
+	ctx, span, logger := r.traceSpan(req.Context(), "back-channel logout handler")
+	if span != nil {
+		defer span.End()
+	}
+	req = req.WithContext(ctx)
+	w.Header().Set("Cache-Control", "no-store")
+
+	req.Body = http.MaxBytesReader(w, req.Body, 64*1024)
+	encoded := req.PostFormValue("logout_token")
+	if encoded == "" {
+		r.errorResponse(w, req, "the logout token is missing", http.StatusBadRequest, nil)
+		return
+	}
+
+	token, err := jose.ParseJWT(encoded)
+	if err != nil {
+		r.errorResponse(w, req, "unable to parse the logout token", http.StatusBadRequest, err)
+		return
+	}
+
+	claims, err := r.verifyLogoutToken(token)
+	if err != nil {
+		r.errorResponse(w, req, "the logout token failed verification", http.StatusBadRequest, err)
+		return
+	}
+
+	logout, err := parseLogoutToken(claims)
+	if err != nil {
+		r.errorResponse(w, req, "invalid logout token", http.StatusBadRequest, err)
+		return
+	}
+
+	// step: a logout token is accepted once
+	unused, err := r.UseLogoutToken(logout.id, logoutTokenMaxAge+2*r.config.ClockSkewLeeway)
+	if err != nil {
+		r.errorResponse(w, req, "unable to record the logout token in the store", http.StatusInternalServerError, err)
+		return
+	}
+	if !unused {
+		r.errorResponse(w, req, "the logout token has already been used", http.StatusBadRequest, nil)
+		return
+	}
+
+	// step: the tokens issued before the logout for the session, or for the subject, are no longer accepted
+	user := &userContext{id: logout.subject, claims: jose.Claims{}}
+	revoked := 0
+	if logout.session != "" {
+		session, err := r.RevokeSession(logout.session, logout.issuedAt)
+		if err != nil {
+			r.errorResponse(w, req, "unable to revoke the session from the store", http.StatusInternalServerError, err)
+			return
+		}
+		if session != nil {
+			user.id = session.Subject
+			user.email = session.Email
+			revoked++
+		}
+		user.claims[claimSessionID] = logout.session
+	} else {
+		if revoked, err = r.RevokeSubjectSessions(logout.subject, logout.issuedAt); err != nil {
+			r.errorResponse(w, req, "unable to revoke the sessions from the store", http.StatusInternalServerError, err)
+			return
+		}
+	}
+	logger.Info("the provider logged out the user",
+		zap.String("sub", user.id),
+		zap.String("sid", logout.session),
+		zap.Int("sessions", revoked))
+
+	// @metric increment the logout counter
+	oauthTokensMetric.WithLabelValues("backchannel-logout").Inc()
+
+	// step: let the applications clean up their own sessions
+	if len(r.config.LogoutWebhooks) > 0 && user.id != "" {
+		go r.notifyLogout(user)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// verifyLogoutToken checks the signature, issuer, audience and age of a logout token. Unlike the other tokens of
+// the provider, a logout token may have no subject nor expiry: it is rejected past a maximum age.
+func (r *oauthProxy) verifyLogoutToken(token jose.JWT) (jose.Claims, error) {
+	if err := r.verifyAdminAction(token); err != nil {
+		return nil, err
+	}
+	claims, err := token.Claims()
+	if err != nil {
+		return nil, err
+	}
+
+	issuer, err := r.expectedIssuer(token)
+	if err != nil {
+		return nil, err
+	}
+	if iss, _, _ := claims.StringClaim("iss"); strings.TrimSuffix(iss, "/") != strings.TrimSuffix(issuer, "/") {
+		return nil, fmt.Errorf("the logout token is issued by another provider: %q", iss)
+	}
+	audiences, found, err := claims.StringsClaim(claimAudience)
+	if err != nil || !found {
+		aud, _, _ := claims.StringClaim(claimAudience)
+		audiences = []string{aud}
+	}
+	if !containsString(r.config.ClientID, audiences) {
+		return nil, errors.New("the logout token is intended for another client")
+	}
+	issuedAt, found, err := claims.TimeClaim("iat")
+	if err != nil || !found {
+		return nil, errors.New("the logout token has no issuance time")
+	}
+	now := time.Now()
+	if issuedAt.Before(now.Add(-logoutTokenMaxAge-r.config.ClockSkewLeeway)) || issuedAt.After(now.Add(r.config.ClockSkewLeeway)) {
+		return nil, fmt.Errorf("the logout token is issued out of the last %s", logoutTokenMaxAge)
+	}
+	if expiry, found, err := claims.TimeClaim("exp"); err != nil || found && time.Now().After(expiry.Add(r.config.ClockSkewLeeway)) {
+		return nil, errors.New("the logout token has expired")
+	}
+
+	return claims, nil
+}
+
+// parseLogoutToken extracts the user or session logged out from the claims of a logout token
+func parseLogoutToken(claims jose.Claims) (logoutToken, error) {
+	events, ok := claims["events"].(map[string]interface{})
+	if !ok {
+		return logoutToken{}, errors.New("the logout token has no events")
+	}
+	if _, found := events[backChannelLogoutEvent]; !found {
+		return logoutToken{}, errors.New("the logout token has no back-channel logout event")
+	}
+	// a logout token is not an id token
+	if _, found := claims["nonce"]; found {
+		return logoutToken{}, errors.New("the logout token has a nonce")
+	}
+
+	id, _, _ := claims.StringClaim("jti")
+	if id == "" {
+		return logoutToken{}, errors.New("the logout token has no id")
+	}
+	issuedAt, _, _ := claims.TimeClaim("iat")
+	subject, _, _ := claims.StringClaim("sub")
+	session, _, _ := claims.StringClaim(claimSessionID)
+	if subject == "" && session == "" {
+		return logoutToken{}, errors.New("the logout token has neither a subject nor a session")
+	}
+
+	return logoutToken{id: id, subject: subject, session: session, issuedAt: issuedAt}, nil
+}
+
+// isSessionRevoked checks if a token has been issued before its session was logged out by the provider.
+// A store which can't be reached is not considered as a revocation.
+func (r *oauthProxy) isSessionRevoked(user *userContext) bool {
+	session := user.getSessionID()
+	if !r.useStore() || session == "" {
+		return false
+	}
+	revokedAt, err := r.GetSessionRevocation(session)
+	if err != nil {
+		r.log.Warn("unable to retrieve the revocation of the session from the store", zap.Error(err))
+		return false
+	}
+	if revokedAt.IsZero() {
+		return false
+	}
+	issuedAt, found, err := user.claims.TimeClaim("iat")
+	if err != nil || !found {
+		return true
+	}
+
+	return issuedAt.Before(revokedAt)
+}
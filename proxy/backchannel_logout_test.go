@@ -0,0 +1,208 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-oidc/jose"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newFakeLogoutToken(issuer, clientID string, claims jose.Claims) jose.Claims {
+	token := jose.Claims{
+		"iss":    issuer,
+		"aud":    clientID,
+		"iat":    float64(time.Now().Unix()),
+		"exp":    float64(time.Now().Add(time.Minute).Unix()),
+		"jti":    uuid.NewV4().String(),
+		"events": map[string]interface{}{backChannelLogoutEvent: map[string]interface{}{}},
+	}
+	for k, v := range claims {
+		token[k] = v
+	}
+
+	return token
+}
+
+func TestParseLogoutToken(t *testing.T) {
+	issuedAt := time.Now().Add(-time.Minute).Truncate(time.Second)
+	logout, err := parseLogoutToken(newFakeLogoutToken("test", "test", jose.Claims{
+		"jti": "5f1c3f9e",
+		"iat": float64(issuedAt.Unix()),
+		"sub": "user",
+		"sid": "session",
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, "5f1c3f9e", logout.id)
+	assert.Equal(t, "user", logout.subject)
+	assert.Equal(t, "session", logout.session)
+	assert.True(t, issuedAt.Equal(logout.issuedAt))
+
+	logout, err = parseLogoutToken(newFakeLogoutToken("test", "test", jose.Claims{"sid": "session"}))
+	require.NoError(t, err)
+	assert.Equal(t, "session", logout.session)
+	assert.Empty(t, logout.subject)
+
+	_, err = parseLogoutToken(newFakeLogoutToken("test", "test", jose.Claims{"sub": "user", "jti": ""}))
+	assert.Error(t, err, "a logout token has an id")
+
+	_, err = parseLogoutToken(newFakeLogoutToken("test", "test", nil))
+	assert.Error(t, err, "a logout token has a subject or a session")
+
+	_, err = parseLogoutToken(newFakeLogoutToken("test", "test", jose.Claims{"sub": "user", "nonce": "abcd"}))
+	assert.Error(t, err, "an id token is not a logout token")
+
+	_, err = parseLogoutToken(newFakeLogoutToken("test", "test", jose.Claims{
+		"sub":    "user",
+		"events": map[string]interface{}{"http://schemas.openid.net/event/other": map[string]interface{}{}},
+	}))
+	assert.Error(t, err)
+}
+
+func TestBackChannelLogout(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "keycloak-gatekeeper")
+	require.NoError(t, err)
+	_ = tmpfile.Close()
+	defer os.Remove(tmpfile.Name())
+
+	notified := make(chan logoutNotification, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var notification logoutNotification
+		assert.NoError(t, json.NewDecoder(req.Body).Decode(&notification))
+		notified <- notification
+	}))
+	defer hook.Close()
+
+	cfg := newFakeKeycloakConfig()
+	cfg.EnableBackChannelLogout = true
+	cfg.StoreURL = fmt.Sprintf("boltdb:///%s", tmpfile.Name())
+	cfg.LogoutWebhooks = []string{hook.URL}
+	p := newFakeProxy(cfg)
+	defer func() {
+		_ = p.proxy.CloseStore()
+	}()
+	logoutURL := p.getServiceURL() + cfg.WithOAuthURI(backChannelURL)
+
+	require.NoError(t, p.proxy.store.Set("refresh-key", "refresh-token", 0))
+	now := time.Now()
+	for _, session := range []*storedSession{
+		{ID: "session-1", Subject: "user", Email: "user@example.com", CreatedAt: now.Add(-time.Minute), ExpiresAt: now.Add(time.Hour), TokenKey: "refresh-key"},
+		{ID: "session-2", Subject: "user", CreatedAt: now.Add(-time.Minute), ExpiresAt: now.Add(time.Hour)},
+		{ID: "session-3", Subject: "user", CreatedAt: now.Add(time.Minute), ExpiresAt: now.Add(time.Hour)},
+	} {
+		require.NoError(t, p.proxy.putStoredSession(session, time.Hour))
+	}
+
+	post := func(token string) int {
+		resp, err := http.PostForm(logoutURL, url.Values{"logout_token": {token}})
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+	sign := func(claims jose.Claims) string {
+		token, err := p.idp.signToken(claims)
+		require.NoError(t, err)
+		return token.Encode()
+	}
+	issuer := p.idp.getLocation()
+
+	// the tokens which are not logout tokens of the provider for the client are rejected
+	assert.Equal(t, http.StatusBadRequest, post(""))
+	unsigned, err := jose.NewJWT(jose.JOSEHeader{"alg": "RS256"}, newFakeLogoutToken(issuer, cfg.ClientID, jose.Claims{"sid": "session-1"}))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, post(unsigned.Encode()))
+	assert.Equal(t, http.StatusBadRequest, post(sign(newFakeLogoutToken(issuer, "other", jose.Claims{"sid": "session-1"}))))
+	assert.Equal(t, http.StatusBadRequest, post(sign(newFakeLogoutToken("https://other.example.com", cfg.ClientID, jose.Claims{"sid": "session-1"}))))
+	assert.Equal(t, http.StatusBadRequest, post(sign(newFakeLogoutToken(issuer, cfg.ClientID, jose.Claims{
+		"sid": "session-1",
+		"exp": float64(now.Add(-time.Hour).Unix()),
+	}))))
+	// without an expiry, a logout token is rejected past its maximum age
+	stale := newFakeLogoutToken(issuer, cfg.ClientID, jose.Claims{"sid": "session-1", "iat": float64(now.Add(-time.Hour).Unix())})
+	delete(stale, "exp")
+	assert.Equal(t, http.StatusBadRequest, post(sign(stale)))
+	sessions, err := p.proxy.ListStoredSessions()
+	require.NoError(t, err)
+	assert.Len(t, sessions, 3)
+
+	// the session logged out of the provider is revoked, and its logout token can't be replayed
+	logout := sign(newFakeLogoutToken(issuer, cfg.ClientID, jose.Claims{"sid": "session-1"}))
+	assert.Equal(t, http.StatusOK, post(logout))
+	assert.Equal(t, http.StatusBadRequest, post(logout))
+	sessions, err = p.proxy.ListStoredSessions()
+	require.NoError(t, err)
+	assert.Len(t, sessions, 2)
+	refresh, err := p.proxy.store.Get("refresh-key")
+	require.NoError(t, err)
+	assert.Empty(t, refresh)
+	select {
+	case notification := <-notified:
+		assert.Equal(t, logoutNotification{Subject: "user", SessionID: "session-1", Email: "user@example.com"}, notification)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the back-channel logout to be notified to the webhook")
+	}
+
+	// without a session, the sessions of the subject created before the logout are revoked
+	assert.Equal(t, http.StatusOK, post(sign(newFakeLogoutToken(issuer, cfg.ClientID, jose.Claims{"sub": "user"}))))
+	sessions, err = p.proxy.ListStoredSessions()
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, "session-3", sessions[0].ID)
+
+	// the tokens of the session logged out are rejected, unlike the tokens of the other sessions
+	p.config.ClockSkewLeeway = 2 * time.Minute
+	requests := []fakeRequest{
+		{
+			URI:          testAdminURI,
+			HasToken:     true,
+			Roles:        []string{fakeAdminRole},
+			TokenClaims:  jose.Claims{"sid": "session-1", "iat": float64(now.Add(-time.Minute).Unix())},
+			ExpectedCode: http.StatusUnauthorized,
+		},
+		{
+			URI:           testAdminURI,
+			HasToken:      true,
+			Roles:         []string{fakeAdminRole},
+			TokenClaims:   jose.Claims{"sid": "session-4", "iat": float64(now.Add(-time.Minute).Unix())},
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+		},
+	}
+	p.RunTests(t, requests)
+}
+
+func TestBackChannelLogoutDisabled(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	requests := []fakeRequest{
+		{
+			URI:          cfg.WithOAuthURI(backChannelURL),
+			Method:       http.MethodPost,
+			ExpectedCode: http.StatusNotFound,
+		},
+	}
+	newFakeProxy(cfg).RunTests(t, requests)
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// forwardedPrefix is the path prefix under which the outer proxy exposes the service, when trusted
+func (r *oauthProxy) forwardedPrefix(req *http.Request) (string, bool) {
+	if !r.config.EnableForwardedPrefix {
+		return "", false
+	}
+	prefix := req.Header.Get(headerXForwardedPrefix)
+	// an open redirect must not be crafted from the header
+	if !strings.HasPrefix(prefix, "/") || strings.HasPrefix(prefix, "//") || strings.ContainsAny(prefix, "?#\\") {
+		return "", false
+	}
+
+	prefix = strings.TrimRight(prefix, "/")
+
+	return prefix, prefix != ""
+}
+
+// basePath is the common prefix of the generated URIs for this request
+func (r *oauthProxy) basePath(req *http.Request) string {
+	if prefix, found := r.forwardedPrefix(req); found {
+		return prefix
+	}
+
+	return r.config.BaseURI
+}
+
+// withOAuthURI returns the oauth uri as seen by the client of this request
+func (r *oauthProxy) withOAuthURI(req *http.Request, uri string) string {
+	if prefix, found := r.forwardedPrefix(req); found {
+		return fmt.Sprintf("%s/%s/%s", prefix, r.config.OAuthURI, uri)
+	}
+
+	return r.config.WithOAuthURI(uri)
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBaseURIOAuthRoutes(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.BaseURI = "/myapp"
+	requests := []fakeRequest{
+		{
+			URI:              "/admin",
+			Redirects:        true,
+			ExpectedLocation: "/myapp/oauth/authorize?state",
+			ExpectedCode:     http.StatusTemporaryRedirect,
+		},
+		{
+			// the outer proxy strips the base path
+			URI:              "/oauth/authorize",
+			ExpectedLocation: "%2Fmyapp%2F%2Foauth%2Fcallback",
+			ExpectedCode:     http.StatusTemporaryRedirect,
+		},
+		{
+			// or forwards it
+			URI:              "/myapp/oauth/authorize",
+			ExpectedLocation: "%2Fmyapp%2F%2Foauth%2Fcallback",
+			ExpectedCode:     http.StatusTemporaryRedirect,
+		},
+		{
+			URI:          "/myapp/oauth/test",
+			ExpectedCode: http.StatusNotFound,
+		},
+	}
+	newFakeProxy(cfg).RunTests(t, requests)
+}
+
+func TestForwardedPrefix(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.EnableForwardedPrefix = true
+	requests := []fakeRequest{
+		{
+			URI:              "/admin",
+			Redirects:        true,
+			Headers:          map[string]string{headerXForwardedPrefix: "/myapp/"},
+			ExpectedLocation: "/myapp/oauth/authorize?state",
+			ExpectedCode:     http.StatusTemporaryRedirect,
+		},
+		{
+			URI:              "/oauth/authorize",
+			Headers:          map[string]string{headerXForwardedPrefix: "/myapp"},
+			ExpectedLocation: "%2Fmyapp%2F%2Foauth%2Fcallback",
+			ExpectedCode:     http.StatusTemporaryRedirect,
+		},
+		{
+			URI:              "/admin",
+			Redirects:        true,
+			Headers:          map[string]string{headerXForwardedPrefix: "//evil.com"},
+			ExpectedLocation: "/oauth/authorize?state",
+			ExpectedCode:     http.StatusTemporaryRedirect,
+		},
+	}
+	newFakeProxy(cfg).RunTests(t, requests)
+}
+
+func TestForwardedPrefixValues(t *testing.T) {
+	p := &oauthProxy{config: &Config{EnableForwardedPrefix: true, BaseURI: "/base", OAuthURI: "/oauth"}}
+	cs := []struct {
+		Prefix   string
+		Expected string
+	}{
+		{Prefix: "", Expected: "/base"},
+		{Prefix: "/", Expected: "/base"},
+		{Prefix: "/myapp", Expected: "/myapp"},
+		{Prefix: "/myapp/", Expected: "/myapp"},
+		{Prefix: "myapp", Expected: "/base"},
+		{Prefix: "//evil.com", Expected: "/base"},
+		{Prefix: "/\\evil.com", Expected: "/base"},
+		{Prefix: "/myapp?x=1", Expected: "/base"},
+		{Prefix: "/myapp#x", Expected: "/base"},
+	}
+	for i, c := range cs {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(headerXForwardedPrefix, c.Prefix)
+		assert.Equal(t, c.Expected, p.basePath(req), "case %d", i)
+	}
+
+	p.config.EnableForwardedPrefix = false
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(headerXForwardedPrefix, "/myapp")
+	assert.Equal(t, "/base", p.basePath(req))
+	assert.Equal(t, p.config.WithOAuthURI(callbackURL), p.withOAuthURI(req, callbackURL))
+}
+
+func TestExternalURLCookiePath(t *testing.T) {
+	cfg := &Config{ExternalURL: "https://example.com/myapp/"}
+	assert.NoError(t, cfg.isExternalURLValid())
+	assert.Equal(t, "https://example.com", cfg.RedirectionURL)
+	assert.Equal(t, "/myapp", cfg.BaseURI)
+	assert.Equal(t, "/myapp", cfg.cookiePath())
+
+	// the base uri alone keeps the cookies on the whole host
+	assert.Equal(t, "/", (&Config{BaseURI: "/myapp"}).cookiePath())
+
+	p := &oauthProxy{config: cfg}
+	p.cookieDropper = p.makeCookieDropper()
+	w := httptest.NewRecorder()
+	p.dropCookie(w, "example.com", "test", "value", 0)
+	cookies := w.Result().Cookies()
+	if assert.Len(t, cookies, 1) {
+		assert.Equal(t, "/myapp", cookies[0].Path)
+	}
+}
@@ -0,0 +1,290 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/oneconcern/keycloak-gatekeeper/gatekeepertest"
+	"github.com/urfave/cli"
+)
+
+// benchOptions are the parameters of a load test
+type benchOptions struct {
+	// concurrency is the number of concurrent clients
+	concurrency int
+	// requests is the total number of requests, when no duration is set
+	requests int
+	// duration is the length of the load test
+	duration time.Duration
+	// path is the path requested on the proxy
+	path string
+	// roles are the realm roles granted to the token
+	roles []string
+	// payloadSize is the size of the responses of the stub upstream
+	payloadSize int
+}
+
+// benchResult collects the outcome of a load test
+type benchResult struct {
+	elapsed   time.Duration
+	errors    int
+	codes     map[int]int
+	latencies []time.Duration
+}
+
+// newBenchCommand creates the bench subcommand, which measures the proxy throughput and latency
+// against a stub identity provider and upstream
+func newBenchCommand() cli.Command {
+	return cli.Command{
+		Name:      "bench",
+		Usage:     "load test the proxy against a stub identity provider and upstream, reporting latency percentiles",
+		UsageText: "keycloak-gatekeeper [--config FILE] bench [options]",
+		Flags: []cli.Flag{
+			cli.IntFlag{Name: "concurrency", Value: 10, Usage: "number of concurrent clients"},
+			cli.IntFlag{Name: "requests", Value: 10000, Usage: "total number of requests, unless a duration is set"},
+			cli.DurationFlag{Name: "duration", Usage: "duration of the load test, e.g. 30s"},
+			cli.StringFlag{Name: "path", Value: "/", Usage: "path requested through the proxy"},
+			cli.StringSliceFlag{Name: "role", Usage: "realm role granted to the access token, may be repeated"},
+			cli.IntFlag{Name: "payload-size", Value: 1024, Usage: "size in bytes of the upstream responses"},
+		},
+		Action: func(cx *cli.Context) error {
+			config := newDefaultConfig()
+			if configFile := cx.GlobalString("config"); configFile != "" {
+				if err := readConfigFile(configFile, config); err != nil {
+					return printError("unable to read the configuration file: %s, error: %s", configFile, err.Error())
+				}
+			}
+			options := benchOptions{
+				concurrency: cx.Int("concurrency"),
+				requests:    cx.Int("requests"),
+				duration:    cx.Duration("duration"),
+				path:        cx.String("path"),
+				roles:       cx.StringSlice("role"),
+				payloadSize: cx.Int("payload-size"),
+			}
+
+			result, err := runBenchmark(config, options)
+			if err != nil {
+				return printError(err.Error())
+			}
+			result.report(os.Stdout)
+
+			return nil
+		},
+	}
+}
+
+// runBenchmark starts the proxy with a stub identity provider and upstream, and drives requests through it
+func runBenchmark(config *Config, options benchOptions) (*benchResult, error) {
+	if options.concurrency <= 0 {
+		return nil, errors.New("the concurrency must be a number > 0")
+	}
+	if options.duration <= 0 && options.requests <= 0 {
+		return nil, errors.New("either a number of requests or a duration must be set")
+	}
+
+	if config.ClientID == "" {
+		config.ClientID = "bench"
+		config.ClientSecret = "bench"
+	}
+	idp, err := gatekeepertest.NewAuthServer(config.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = idp.Close()
+	}()
+
+	upstream, err := newStubUpstream(options.payloadSize)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = upstream.Close()
+	}()
+
+	// step: the proxy is bound to the stubs, the rest of the configuration is used as is
+	config.DiscoveryURL = idp.DiscoveryURL()
+	config.Upstream = "http://" + upstream.Addr().String()
+	config.Listen = "127.0.0.1:0"
+	config.ListenHTTP = ""
+	config.ListenAdmin = ""
+	config.TLSCertificate = ""
+	config.TLSPrivateKey = ""
+	config.EnableLogging = false
+	config.DisableAllLogging = true
+	config.SkipOpenIDProviderTLSVerify = true
+	config.SecureCookie = false
+	config.RedirectionURL = "http://127.0.0.1"
+	if len(config.Resources) == 0 {
+		config.Resources = []*Resource{{URL: allRoutes, Methods: allHTTPMethods}}
+	}
+	if err = config.isValid(); err != nil {
+		return nil, err
+	}
+
+	proxy, err := newProxy(config)
+	if err != nil {
+		return nil, err
+	}
+	if err = proxy.Run(); err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = proxy.server.Close()
+	}()
+
+	idp.SetExpiration(time.Hour + options.duration)
+	token, err := idp.Token(options.roles...)
+	if err != nil {
+		return nil, err
+	}
+
+	target := "http://" + proxy.listener.Addr().String() + options.path
+	client := &http.Client{
+		Transport: &http.Transport{
+			MaxIdleConns:        options.concurrency,
+			MaxIdleConnsPerHost: options.concurrency,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	var (
+		remaining = int64(options.requests)
+		deadline  = time.Now().Add(options.duration)
+		wg        sync.WaitGroup
+		results   = make([]*benchResult, options.concurrency)
+	)
+	next := func() bool {
+		if options.duration > 0 {
+			return time.Now().Before(deadline)
+		}
+		return atomic.AddInt64(&remaining, -1) >= 0
+	}
+
+	start := time.Now()
+	for i := range results {
+		result := &benchResult{codes: make(map[int]int)}
+		results[i] = result
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for next() {
+				result.record(client, target, token)
+			}
+		}()
+	}
+	wg.Wait()
+
+	total := &benchResult{elapsed: time.Since(start), codes: make(map[int]int)}
+	for _, result := range results {
+		total.errors += result.errors
+		total.latencies = append(total.latencies, result.latencies...)
+		for code, count := range result.codes {
+			total.codes[code] += count
+		}
+	}
+	sort.Slice(total.latencies, func(i, j int) bool { return total.latencies[i] < total.latencies[j] })
+
+	return total, nil
+}
+
+// record sends a request and records its outcome
+func (r *benchResult) record(client *http.Client, target, token string) {
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		r.errors++
+		return
+	}
+	req.Header.Set(authorizationHeader, authorizationType+" "+token)
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		r.errors++
+		return
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	r.latencies = append(r.latencies, time.Since(start))
+	r.codes[resp.StatusCode]++
+}
+
+// percentile returns the latency below which the given percentage of requests fall
+func (r *benchResult) percentile(p float64) time.Duration {
+	if len(r.latencies) == 0 {
+		return 0
+	}
+	index := int(float64(len(r.latencies))*p/100+0.5) - 1
+	if index < 0 {
+		index = 0
+	}
+	if index >= len(r.latencies) {
+		index = len(r.latencies) - 1
+	}
+
+	return r.latencies[index]
+}
+
+// report prints a summary of the load test
+func (r *benchResult) report(w io.Writer) {
+	requests := len(r.latencies)
+	fmt.Fprintf(w, "requests:   %d in %s (%.0f req/s)\n", requests, r.elapsed.Round(time.Millisecond), float64(requests)/r.elapsed.Seconds())
+	fmt.Fprintf(w, "errors:     %d\n", r.errors)
+
+	codes := make([]int, 0, len(r.codes))
+	for code := range r.codes {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Fprintf(w, "status %d: %d\n", code, r.codes[code])
+	}
+
+	fmt.Fprintf(w, "latency:    p50=%s p90=%s p95=%s p99=%s max=%s\n",
+		r.percentile(50), r.percentile(90), r.percentile(95), r.percentile(99), r.percentile(100))
+}
+
+// newStubUpstream starts an upstream responding with a fixed payload
+func newStubUpstream(size int) (net.Listener, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	payload := bytes.Repeat([]byte("x"), size)
+	go func() {
+		_ = http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write(payload)
+		}))
+	}()
+
+	return listener, nil
+}
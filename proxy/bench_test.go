@@ -0,0 +1,65 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunBenchmark(t *testing.T) {
+	config := newDefaultConfig()
+	config.Resources = []*Resource{
+		{URL: "/admin/*", Methods: allHTTPMethods, Roles: []string{"admin"}},
+		{URL: allRoutes, Methods: allHTTPMethods},
+	}
+	result, err := runBenchmark(config, benchOptions{concurrency: 4, requests: 100, path: "/admin/test", roles: []string{"admin"}})
+	require.NoError(t, err)
+	assert.Zero(t, result.errors)
+	assert.Equal(t, map[int]int{http.StatusOK: 100}, result.codes)
+	assert.Len(t, result.latencies, 100)
+
+	var report bytes.Buffer
+	result.report(&report)
+	assert.Contains(t, report.String(), "status 200: 100")
+	assert.Contains(t, report.String(), "p99=")
+
+	// load test for a duration
+	result, err = runBenchmark(newDefaultConfig(), benchOptions{concurrency: 1, duration: 100 * time.Millisecond, path: "/"})
+	require.NoError(t, err)
+	assert.NotZero(t, result.codes[http.StatusOK])
+
+	_, err = runBenchmark(newDefaultConfig(), benchOptions{})
+	assert.Error(t, err)
+}
+
+func TestBenchResultPercentile(t *testing.T) {
+	result := &benchResult{}
+	assert.Zero(t, result.percentile(50))
+
+	for i := 1; i <= 100; i++ {
+		result.latencies = append(result.latencies, time.Duration(i)*time.Millisecond)
+	}
+	assert.Equal(t, 50*time.Millisecond, result.percentile(50))
+	assert.Equal(t, 99*time.Millisecond, result.percentile(99))
+	assert.Equal(t, 100*time.Millisecond, result.percentile(100))
+	assert.Equal(t, time.Millisecond, result.percentile(0))
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// BodyRewrite is a substitution in the bodies of the upstream responses
+type BodyRewrite struct {
+	// Match is the literal text replaced
+	Match string `json:"match" yaml:"match"`
+	// Regex is the regular expression replaced, in place of a literal text
+	Regex string `json:"regex" yaml:"regex"`
+	// Replace is the replacement, expanding the submatches of a regex, e.g. $1
+	Replace string `json:"replace" yaml:"replace"`
+}
+
+// parseBodyRewrite parses a rewrite of the command line, e.g. match=http://backend:8080|replace=https://app.example.com
+func parseBodyRewrite(rewrite string) (*BodyRewrite, error) {
+	r := &BodyRewrite{}
+	for _, x := range strings.Split(rewrite, "|") {
+		kp := strings.SplitN(x, "=", 2)
+		if len(kp) != 2 {
+			return nil, errors.New("invalid rewrite keypair, should be (match|regex|replace)=value")
+		}
+		switch kp[0] {
+		case "match":
+			r.Match = kp[1]
+		case "regex":
+			r.Regex = kp[1]
+		case "replace":
+			r.Replace = kp[1]
+		default:
+			return nil, fmt.Errorf("invalid rewrite keypair %s, should be (match|regex|replace)=value", kp[0])
+		}
+	}
+
+	return r, nil
+}
+
+// isBodyRewritesValid checks the rewrites have either a literal text or a regular expression
+func isBodyRewritesValid(rewrites []*BodyRewrite) error {
+	for _, x := range rewrites {
+		if (x.Match == "") == (x.Regex == "") {
+			return errors.New("a response body rewrite must have either a match or a regex")
+		}
+		if x.Regex != "" {
+			if _, err := regexp.Compile(x.Regex); err != nil {
+				return fmt.Errorf("invalid response body rewrite regex %q: %v", x.Regex, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// bodyRewriter applies the substitutions to the upstream responses of some content types, up to a size
+type bodyRewriter struct {
+	rewrites []bodySubstitution
+	// types are the media types rewritten, a trailing wildcard matching a prefix
+	types []string
+	// maxSize is the size of the largest body rewritten, the larger ones being passed unmodified
+	maxSize int
+	// decompress rewrites the gzip and deflate encoded bodies, compressed again once rewritten
+	decompress bool
+}
+
+// bodySubstitution is a compiled rewrite
+type bodySubstitution struct {
+	literal []byte
+	regex   *regexp.Regexp
+	replace []byte
+}
+
+// newBodyRewriter compiles the rewrites, with nil when there are none
+func newBodyRewriter(rewrites []*BodyRewrite, types []string, maxSize int, decompress bool) *bodyRewriter {
+	if len(rewrites) == 0 {
+		return nil
+	}
+	b := &bodyRewriter{types: types, maxSize: maxSize, decompress: decompress}
+	for _, x := range rewrites {
+		s := bodySubstitution{replace: []byte(x.Replace)}
+		if x.Regex != "" {
+			s.regex = regexp.MustCompile(x.Regex)
+		} else {
+			s.literal = []byte(x.Match)
+		}
+		b.rewrites = append(b.rewrites, s)
+	}
+
+	return b
+}
+
+// isRewritten checks if the body of a response is rewritten: bodies compressed (unless decompressed), of another
+// media type or announced larger than the maximum size are passed unmodified
+func (b *bodyRewriter) isRewritten(res *http.Response) bool {
+	if res.Body == nil || res.Body == http.NoBody || (res.Request != nil && res.Request.Method == http.MethodHead) {
+		return false
+	}
+	if encoding := contentEncoding(res.Header); encoding != "" && !(b.decompress && isDecompressed(encoding)) {
+		return false
+	}
+	if res.ContentLength > int64(b.maxSize) {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	for _, x := range b.types {
+		if strings.EqualFold(x, mediaType) ||
+			(strings.HasSuffix(x, wildcard) && strings.HasPrefix(mediaType, strings.ToLower(strings.TrimSuffix(x, wildcard)))) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rewrite applies the substitutions to the body of an upstream response. The body is read up to the maximum
+// size: a larger body is streamed unmodified. The compressed bodies are rewritten within the same limit once
+// decompressed, and passed unmodified when larger or invalid.
+func (b *bodyRewriter) rewrite(res *http.Response) error {
+	if !b.isRewritten(res) {
+		return nil
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, int64(b.maxSize)+1))
+	if err != nil {
+		return err
+	}
+	if len(body) > b.maxSize {
+		res.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), res.Body), res.Body}
+		return nil
+	}
+	_ = res.Body.Close()
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	encoding := contentEncoding(res.Header)
+	content := body
+	if encoding != "" {
+		if content, err = decompress(encoding, bytes.NewReader(body), b.maxSize); err != nil {
+			// the body is passed as sent by the upstream
+			return nil
+		}
+	}
+	rewritten := content
+	for _, s := range b.rewrites {
+		if s.regex != nil {
+			rewritten = s.regex.ReplaceAll(rewritten, s.replace)
+			continue
+		}
+		rewritten = bytes.ReplaceAll(rewritten, s.literal, s.replace)
+	}
+	if bytes.Equal(content, rewritten) {
+		return nil
+	}
+	if encoding != "" {
+		if rewritten, err = compress(encoding, rewritten); err != nil {
+			return err
+		}
+	}
+
+	// the validators of the upstream do not apply to the rewritten body
+	res.Header.Del("ETag")
+	res.Body = ioutil.NopCloser(bytes.NewReader(rewritten))
+	res.ContentLength = int64(len(rewritten))
+	res.TransferEncoding = nil
+	res.Header.Set("Content-Length", strconv.Itoa(len(rewritten)))
+
+	return nil
+}
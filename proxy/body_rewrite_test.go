@@ -0,0 +1,150 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBodyRewrite(t *testing.T) {
+	rewrite, err := parseBodyRewrite("match=http://backend:8080/?a=b|replace=https://app.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, &BodyRewrite{Match: "http://backend:8080/?a=b", Replace: "https://app.example.com"}, rewrite)
+
+	_, err = parseBodyRewrite("match")
+	assert.Error(t, err)
+	_, err = parseBodyRewrite("with=value")
+	assert.Error(t, err)
+}
+
+func TestBodyRewritesValid(t *testing.T) {
+	assert.NoError(t, isBodyRewritesValid([]*BodyRewrite{{Match: "a"}, {Regex: "http://([a-z]+).internal", Replace: "https://$1"}}))
+	assert.Error(t, isBodyRewritesValid([]*BodyRewrite{{Replace: "a"}}))
+	assert.Error(t, isBodyRewritesValid([]*BodyRewrite{{Match: "a", Regex: "a"}}))
+	assert.Error(t, isBodyRewritesValid([]*BodyRewrite{{Regex: "("}}))
+}
+
+func TestBodyRewriter(t *testing.T) {
+	rewriter := newBodyRewriter([]*BodyRewrite{
+		{Match: "http://backend:8080", Replace: "https://app.example.com"},
+		{Regex: `http://([a-z]+)\.internal`, Replace: "https://$1.example.com"},
+	}, []string{"text/html", "application/*"}, 64, false)
+	assert.Nil(t, newBodyRewriter(nil, []string{"text/html"}, 64, false))
+
+	response := func(contentType, body string) *http.Response {
+		return &http.Response{
+			Header:        http.Header{"Content-Type": {contentType}, "Etag": {`"v1"`}},
+			Body:          ioutil.NopCloser(strings.NewReader(body)),
+			ContentLength: -1,
+			Request:       httptest.NewRequest(http.MethodGet, "/", nil),
+		}
+	}
+	read := func(res *http.Response) string {
+		content, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err)
+		return string(content)
+	}
+
+	res := response("text/html; charset=utf-8", `<a href="http://backend:8080/a">http://api.internal/b</a>`)
+	require.NoError(t, rewriter.rewrite(res))
+	assert.Equal(t, `<a href="https://app.example.com/a">https://api.example.com/b</a>`, read(res))
+	assert.EqualValues(t, 65, res.ContentLength)
+	assert.Equal(t, "65", res.Header.Get("Content-Length"))
+	assert.Empty(t, res.Header.Get("Etag"))
+
+	res = response("application/json", `{"self":"http://backend:8080/a"}`)
+	require.NoError(t, rewriter.rewrite(res))
+	assert.Equal(t, `{"self":"https://app.example.com/a"}`, read(res))
+
+	// the bodies of other types are not rewritten
+	res = response("text/plain", "http://backend:8080")
+	require.NoError(t, rewriter.rewrite(res))
+	assert.Equal(t, "http://backend:8080", read(res))
+	assert.NotEmpty(t, res.Header.Get("Etag"))
+
+	// the compressed bodies are not rewritten
+	res = response("text/html", "http://backend:8080")
+	res.Header.Set("Content-Encoding", "gzip")
+	require.NoError(t, rewriter.rewrite(res))
+	assert.Equal(t, "http://backend:8080", read(res))
+
+	// the bodies larger than the maximum size are streamed unmodified
+	large := "http://backend:8080" + strings.Repeat(" ", 64)
+	res = response("text/html", large)
+	require.NoError(t, rewriter.rewrite(res))
+	assert.Equal(t, large, read(res))
+	res = response("text/html", "http://backend:8080")
+	res.ContentLength = 65
+	require.NoError(t, rewriter.rewrite(res))
+	assert.Equal(t, "http://backend:8080", read(res))
+}
+
+func TestUpstreamBodyRewrite(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(`<a href="http://backend.internal:8080/login">login</a>`))
+	}))
+	defer upstream.Close()
+
+	cfg := newFakeKeycloakConfig()
+	cfg.ResponseBodyRewrites = []*BodyRewrite{{Match: "http://backend.internal:8080", Replace: "https://app.example.com"}}
+	cfg.ResponseBodyRewriteTypes = []string{"text/html"}
+	cfg.ResponseBodyRewriteMaxSize = 1024
+	cfg.Resources = []*Resource{
+		{
+			URL:                  "/legacy/*",
+			Methods:              allHTTPMethods,
+			WhiteListed:          true,
+			Upstream:             upstream.URL,
+			ResponseBodyRewrites: []*BodyRewrite{{Match: "login", Replace: "sign-in"}},
+		},
+		{
+			URL:         "/streamed/*",
+			Methods:     allHTTPMethods,
+			WhiteListed: true,
+			Upstream:    upstream.URL,
+			Streaming:   true,
+		},
+	}
+	p := newFakeProxy(cfg)
+	defer func() {
+		p.idp.Close()
+		p.proxy.server.Close()
+	}()
+	p.proxy.upstream = p.proxy.newUpstreamProxy(http.DefaultTransport, false)
+
+	get := func(uri string) string {
+		resp, err := http.Get(p.getServiceURL() + uri)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var content bytes.Buffer
+		_, err = content.ReadFrom(resp.Body)
+		require.NoError(t, err)
+		assert.EqualValues(t, content.Len(), resp.ContentLength)
+		return content.String()
+	}
+	assert.Equal(t, `<a href="https://app.example.com/sign-in">sign-in</a>`, get("/legacy/page"))
+	// the bodies of the streaming resources are not rewritten
+	assert.Equal(t, `<a href="http://backend.internal:8080/login">login</a>`, get("/streamed/page"))
+}
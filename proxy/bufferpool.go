@@ -0,0 +1,99 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"sync"
+)
+
+const (
+	// copyBufferSize is the size of the buffers used to copy responses from upstream
+	copyBufferSize = 32 * 1024
+	// maxPooledBufferSize avoids retaining the occasional very large buffer in the pool
+	maxPooledBufferSize = 64 * 1024
+)
+
+var (
+	copyBuffers = &copyBufferPool{
+		pool: sync.Pool{
+			New: func() interface{} {
+				b := make([]byte, copyBufferSize)
+				return &b
+			},
+		},
+	}
+
+	buffers = sync.Pool{
+		New: func() interface{} {
+			return new(bytes.Buffer)
+		},
+	}
+
+	zlibWriters = sync.Pool{
+		New: func() interface{} {
+			return zlib.NewWriter(nil)
+		},
+	}
+)
+
+// copyBufferPool provides the reverse proxy with reusable buffers to copy response bodies
+type copyBufferPool struct {
+	pool sync.Pool
+}
+
+// Get implements httputil.BufferPool
+func (p *copyBufferPool) Get() []byte {
+	return *(p.pool.Get().(*[]byte))
+}
+
+// Put implements httputil.BufferPool
+func (p *copyBufferPool) Put(b []byte) {
+	if cap(b) != copyBufferSize {
+		return
+	}
+	b = b[:copyBufferSize]
+	p.pool.Put(&b)
+}
+
+// getBuffer retrieves an empty buffer from the pool
+func getBuffer() *bytes.Buffer {
+	return buffers.Get().(*bytes.Buffer)
+}
+
+// putBuffer returns a buffer to the pool: its content must no longer be referenced
+func putBuffer(b *bytes.Buffer) {
+	if b.Cap() > maxPooledBufferSize {
+		return
+	}
+	b.Reset()
+	buffers.Put(b)
+}
+
+// getZlibWriter retrieves a zlib compressor writing to w from the pool
+func getZlibWriter(w io.Writer) *zlib.Writer {
+	z := zlibWriters.Get().(*zlib.Writer)
+	z.Reset(w)
+
+	return z
+}
+
+// putZlibWriter returns a zlib compressor to the pool
+func putZlibWriter(z *zlib.Writer) {
+	zlibWriters.Put(z)
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestCopyBufferPool(t *testing.T) {
+	b := copyBuffers.Get()
+	assert.Len(t, b, copyBufferSize)
+	copyBuffers.Put(b[:10])
+	assert.Len(t, copyBuffers.Get(), copyBufferSize)
+
+	// foreign buffers are not retained
+	copyBuffers.Put(make([]byte, 10))
+	assert.Len(t, copyBuffers.Get(), copyBufferSize)
+}
+
+func TestPutBuffer(t *testing.T) {
+	b := getBuffer()
+	b.WriteString("content")
+	putBuffer(b)
+	assert.Zero(t, getBuffer().Len())
+}
+
+func newBenchmarkUpstreamProxy(b *testing.B, pooled bool) (reverseProxy, string) {
+	body := bytes.Repeat([]byte("x"), 256*1024)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(body)
+	}))
+	b.Cleanup(upstream.Close)
+
+	cfg := newFakeKeycloakConfig()
+	cfg.UpstreamKeepalives = true
+	r := &oauthProxy{config: cfg, log: zap.NewNop()}
+	transport, err := r.newUpstreamTransport("benchmark", benchmarkDialer(), nil, connectionPool{maxIdleConns: 100, maxIdleConnsPerHost: 100})
+	require.NoError(b, err)
+	proxy := r.newUpstreamProxy(transport, false)
+	if !pooled {
+		proxy.(*httputil.ReverseProxy).BufferPool = nil
+	}
+
+	return proxy, upstream.URL
+}
+
+func benchmarkDialer() dialContextFunc {
+	return (&net.Dialer{}).DialContext
+}
+
+// discardResponseWriter drops the response, so that only the proxy allocations are measured
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (w *discardResponseWriter) Header() http.Header         { return w.header }
+func (w *discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardResponseWriter) WriteHeader(int)             {}
+
+func benchmarkUpstreamProxy(b *testing.B, pooled bool) {
+	proxy, upstream := newBenchmarkUpstreamProxy(b, pooled)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			req := httptest.NewRequest(http.MethodGet, upstream, nil)
+			proxy.ServeHTTP(&discardResponseWriter{header: make(http.Header)}, req)
+		}
+	})
+}
+
+func BenchmarkUpstreamProxyPooledBuffers(b *testing.B) {
+	benchmarkUpstreamProxy(b, true)
+}
+
+func BenchmarkUpstreamProxyUnpooledBuffers(b *testing.B) {
+	benchmarkUpstreamProxy(b, false)
+}
+
+func newBenchmarkCookieRequest() *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	token := strings.Repeat("t", 3000)
+	req.AddCookie(&http.Cookie{Name: "kc-access", Value: token})
+	for i := 1; i < 4; i++ {
+		req.AddCookie(&http.Cookie{Name: "kc-access-" + strconv.Itoa(i), Value: token})
+	}
+	req.AddCookie(&http.Cookie{Name: "kc-state", Value: "state"})
+	req.AddCookie(&http.Cookie{Name: "application", Value: "value"})
+
+	return req
+}
+
+func BenchmarkGetTokenInCookie(b *testing.B) {
+	req := newBenchmarkCookieRequest()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		_, _ = getTokenInCookie(req, "kc-access")
+	}
+}
+
+func BenchmarkFilterCookies(b *testing.B) {
+	header := newBenchmarkCookieRequest().Header.Get("Cookie")
+	filter := []string{"kc-access", "kc-refresh", "kc-state"}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		req.Header.Set("Cookie", header)
+		_ = filterCookies(req, filter)
+	}
+}
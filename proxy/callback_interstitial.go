@@ -0,0 +1,117 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	cryptorand "crypto/rand"
+	"encoding/base64"
+	"html/template"
+	"net/http"
+	"sort"
+
+	"go.uber.org/zap"
+)
+
+// callbackReplayParam marks the callbacks replayed by the interstitial page
+const callbackReplayParam = "replayed"
+
+// callbackInterstitialTemplate replays the callback from a page of the proxy. The form is submitted by a script
+// allowed by its nonce, and otherwise by the user with the visible button, e.g. when scripts are disabled.
+var callbackInterstitialTemplate = template.Must(template.New("callback").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Signing in</title></head>
+<body>
+<form method="GET" action="{{ .Action }}">
+{{- range .Params }}
+<input type="hidden" name="{{ .Name }}" value="{{ .Value }}">
+{{- end }}
+<button type="submit">Continue</button>
+</form>
+{{- if .Nonce }}
+<script nonce="{{ .Nonce }}">document.forms[0].submit()</script>
+{{- end }}
+</body>
+</html>
+`))
+
+type interstitialParam struct {
+	Name  string
+	Value string
+}
+
+// isCallbackWithoutCookies checks if the callback arrives without the state cookie set along with the authorization
+// request, as happens when the browser withholds the cookies from the cross-site redirection of the provider
+// (SameSite policy, blocked third-party cookies in frames)
+func isCallbackWithoutCookies(req *http.Request) bool {
+	if req.URL.Query().Get("state") == "" {
+		return false
+	}
+	cookie, _ := req.Cookie(requestStateCookie)
+
+	return cookie == nil
+}
+
+// callbackInterstitial renders a page replaying the callback arriving without cookies. The replay is a
+// navigation from the site of the proxy, for which the browser sends the cookies. It returns false when the
+// callback has already been replayed.
+func (r *oauthProxy) callbackInterstitial(w http.ResponseWriter, req *http.Request) bool {
+	_, logger := r.traceSpanRequest(req)
+
+	query := req.URL.Query()
+	if query.Get(callbackReplayParam) != "" {
+		logger.Warn("the oauth callback has been replayed without cookies, the browser probably blocks the cookies of the proxy (e.g. in a third-party frame)")
+		return false
+	}
+
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	params := make([]interstitialParam, 0, len(query)+1)
+	for _, name := range names {
+		for _, value := range query[name] {
+			params = append(params, interstitialParam{Name: name, Value: value})
+		}
+	}
+	params = append(params, interstitialParam{Name: callbackReplayParam, Value: "true"})
+
+	logger.Debug("the oauth callback arrived without cookies, replaying it from an interstitial page")
+	// the page sets its own policy, in place of a configured policy which would block its script
+	var nonce string
+	secret := make([]byte, 16)
+	if _, err := cryptorand.Read(secret); err != nil {
+		logger.Error("failed to generate the nonce of the callback interstitial", zap.Error(err))
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; form-action 'self'")
+	} else {
+		nonce = base64.RawURLEncoding.EncodeToString(secret)
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; script-src 'nonce-"+nonce+"'; form-action 'self'")
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate, max-age=0")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	noSniff(w)
+	w.WriteHeader(http.StatusOK)
+	if err := callbackInterstitialTemplate.Execute(w, map[string]interface{}{
+		"Action": req.URL.Path,
+		"Params": params,
+		"Nonce":  nonce,
+	}); err != nil {
+		logger.Error("failed to render the callback interstitial", zap.Error(err))
+	}
+
+	return true
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// claimOperators are the numeric comparisons of the claim matchers, the longest first
+var claimOperators = []string{">=", "<=", "==", "!=", ">", "<"}
+
+// claimMatcher is a requirement on a claim of the access tokens, found by name or else by a dotted path into the
+// nested claims. The requirement is a regular expression, or a numeric comparison when prefixed by an operator, e.g.
+// ">=2". A claim holding a list matches when any of its values does.
+type claimMatcher struct {
+	claim       string
+	requirement string
+	regex       *regexp.Regexp
+	operator    string
+	number      float64
+}
+
+// newClaimMatcher parses the requirement on a claim
+func newClaimMatcher(claim, requirement string) (*claimMatcher, error) {
+	m := &claimMatcher{claim: claim, requirement: requirement}
+	for _, operator := range claimOperators {
+		if !strings.HasPrefix(requirement, operator) {
+			continue
+		}
+		number, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimPrefix(requirement, operator)), 64)
+		if err != nil {
+			return nil, fmt.Errorf("the claim matcher: %s for claim: %s is not a valid numeric comparison", requirement, claim)
+		}
+		m.operator, m.number = operator, number
+		return m, nil
+	}
+	regex, err := regexp.Compile(requirement)
+	if err != nil {
+		return nil, fmt.Errorf("the claim matcher: %s for claim: %s is not a valid regex", requirement, claim)
+	}
+	m.regex = regex
+
+	return m, nil
+}
+
+// newClaimMatchers parses the requirements on the claims
+func newClaimMatchers(requirements map[string]string) ([]*claimMatcher, error) {
+	matchers := make([]*claimMatcher, 0, len(requirements))
+	for claim, requirement := range requirements {
+		m, err := newClaimMatcher(claim, requirement)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+
+	return matchers, nil
+}
+
+// values returns the values of the claim, the ones of a list or a single scalar, and whether the claim is found
+func (m *claimMatcher) values(claims map[string]interface{}) ([]interface{}, bool) {
+	value, found := lookupClaim(claims, m.claim)
+	if !found || value == nil {
+		return nil, false
+	}
+	switch v := value.(type) {
+	case []interface{}:
+		return v, true
+	case []string:
+		list := make([]interface{}, 0, len(v))
+		for _, x := range v {
+			list = append(list, x)
+		}
+		return list, true
+	default:
+		return []interface{}{v}, true
+	}
+}
+
+// matches checks if a claim value meets the requirement
+func (m *claimMatcher) matches(value interface{}) bool {
+	if m.regex != nil {
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			return false
+		}
+		return m.regex.MatchString(fmt.Sprintf("%v", value))
+	}
+
+	var number float64
+	switch v := value.(type) {
+	case float64:
+		number = v
+	case int:
+		number = float64(v)
+	case int64:
+		number = float64(v)
+	case json.Number:
+		n, err := v.Float64()
+		if err != nil {
+			return false
+		}
+		number = n
+	case string:
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return false
+		}
+		number = n
+	default:
+		return false
+	}
+	switch m.operator {
+	case ">=":
+		return number >= m.number
+	case "<=":
+		return number <= m.number
+	case "==":
+		return number == m.number
+	case "!=":
+		return number != m.number
+	case ">":
+		return number > m.number
+	default:
+		return number < m.number
+	}
+}
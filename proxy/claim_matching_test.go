@@ -0,0 +1,70 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestClaimMatcher(t *testing.T) {
+	claims := map[string]interface{}{
+		"email_verified":             true,
+		"acr":                        float64(2),
+		"tier":                       "3",
+		"groups":                     []interface{}{"/staff", "/staff/finance"},
+		"scopes":                     []string{"read", "write"},
+		"levels":                     []interface{}{float64(1), float64(5)},
+		"https://example.com/tenant": "acme",
+		"realm_access":               map[string]interface{}{"roles": []interface{}{"user", "admin"}},
+	}
+	cases := []struct {
+		Claim       string
+		Requirement string
+		Matches     bool
+	}{
+		{Claim: "email_verified", Requirement: "^true$", Matches: true},
+		{Claim: "acr", Requirement: ">=2", Matches: true},
+		{Claim: "acr", Requirement: ">2", Matches: false},
+		{Claim: "acr", Requirement: "== 2", Matches: true},
+		{Claim: "acr", Requirement: "!=2", Matches: false},
+		{Claim: "tier", Requirement: "<4", Matches: true},
+		{Claim: "tier", Requirement: "^3$", Matches: true},
+		{Claim: "groups", Requirement: "^/staff/finance$", Matches: true},
+		{Claim: "groups", Requirement: "^/finance$", Matches: false},
+		{Claim: "scopes", Requirement: "^write$", Matches: true},
+		{Claim: "levels", Requirement: ">4", Matches: true},
+		{Claim: "levels", Requirement: ">5", Matches: false},
+		{Claim: "https://example.com/tenant", Requirement: "^acme$", Matches: true},
+		{Claim: "realm_access.roles", Requirement: "^admin$", Matches: true},
+		{Claim: "realm_access", Requirement: ".*", Matches: false},
+		{Claim: "missing", Requirement: ".*", Matches: false},
+	}
+	p := &oauthProxy{log: zap.NewNop()}
+	for i, c := range cases {
+		m, err := newClaimMatcher(c.Claim, c.Requirement)
+		require.NoError(t, err, "case %d", i)
+		assert.Equal(t, c.Matches, p.checkClaim(&userContext{claims: claims}, m, "/"), "case %d: %s %s", i, c.Claim, c.Requirement)
+	}
+
+	_, err := newClaimMatcher("acr", ">=two")
+	assert.Error(t, err)
+	_, err = newClaimMatcher("acr", "[")
+	assert.Error(t, err)
+}
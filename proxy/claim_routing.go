@@ -0,0 +1,93 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// claimRouting selects the upstream of a resource from a claim of the user
+type claimRouting struct {
+	claim     string
+	upstreams map[string]*url.URL
+}
+
+// newClaimRouting returns the claim routing of a resource, or nil when the resource routes all requests
+// to the same upstream
+func newClaimRouting(resource *Resource) *claimRouting {
+	if resource == nil || resource.UpstreamClaim == "" {
+		return nil
+	}
+	routing := &claimRouting{claim: resource.UpstreamClaim, upstreams: make(map[string]*url.URL, len(resource.ClaimUpstreams))}
+	for value, upstream := range resource.ClaimUpstreams {
+		// the urls have been checked with the resource
+		u, _ := url.Parse(upstream)
+		routing.upstreams[value] = u
+	}
+
+	return routing
+}
+
+// upstream returns the upstream mapped to the claim value of the user, if any
+func (c *claimRouting) upstream(req *http.Request) (string, *url.URL) {
+	scope, _ := req.Context().Value(contextScopeName).(*RequestScope)
+	if scope == nil || scope.Identity == nil {
+		return "", nil
+	}
+	value, found := lookupClaim(scope.Identity.claims, c.claim)
+	if !found {
+		return "", nil
+	}
+	var key string
+	switch v := value.(type) {
+	case string:
+		key = v
+	case float64:
+		key = strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		key = strconv.FormatBool(v)
+	default:
+		return "", nil
+	}
+
+	return key, c.upstreams[key]
+}
+
+// isClaimRoutingValid checks the claim routing settings of a resource
+func isClaimRoutingValid(resource *Resource) error {
+	if resource.UpstreamClaim == "" {
+		if len(resource.ClaimUpstreams) > 0 {
+			return fmt.Errorf("the resource %s maps claim values to upstreams, but has no upstream-claim", resource.URL)
+		}
+		return nil
+	}
+	if resource.WhiteListed {
+		return fmt.Errorf("the white-listed resource %s has no claims to route the requests upon", resource.URL)
+	}
+	if len(resource.ClaimUpstreams) == 0 {
+		return fmt.Errorf("the resource %s routes on the claim %s, but has no claim-upstream-urls", resource.URL, resource.UpstreamClaim)
+	}
+	for value, upstream := range resource.ClaimUpstreams {
+		if u, err := url.Parse(upstream); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("upstream for the claim value %q of resource %s is not a valid URL: %q", value, resource.URL, upstream)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newNamedUpstream(name string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = w.Write([]byte(name + " " + req.URL.Path))
+	}))
+}
+
+func TestClaimRouting(t *testing.T) {
+	acme := newNamedUpstream("acme")
+	defer acme.Close()
+	globex := newNamedUpstream("globex")
+	defer globex.Close()
+	shared := newNamedUpstream("shared")
+	defer shared.Close()
+
+	cfg := newFakeKeycloakConfig()
+	cfg.Resources = []*Resource{
+		{
+			URL:           "/tenant/*",
+			Methods:       allHTTPMethods,
+			Upstream:      shared.URL,
+			MaxIdleConns:  10,
+			UpstreamClaim: "organization.tenant",
+			ClaimUpstreams: map[string]string{
+				"acme":   acme.URL,
+				"globex": globex.URL + "/v2",
+				"42":     acme.URL + "/numbered",
+			},
+		},
+	}
+	requests := []fakeRequest{
+		{
+			URI:                     "/tenant/items",
+			HasToken:                true,
+			TokenClaims:             map[string]interface{}{"organization": map[string]interface{}{"tenant": "acme"}},
+			ExpectedCode:            http.StatusOK,
+			ExpectedContentContains: "acme /tenant/items",
+		},
+		{
+			URI:                     "/tenant/items",
+			HasToken:                true,
+			TokenClaims:             map[string]interface{}{"organization": map[string]interface{}{"tenant": "globex"}},
+			ExpectedCode:            http.StatusOK,
+			ExpectedContentContains: "globex /v2/tenant/items",
+		},
+		{
+			URI:                     "/tenant/items",
+			HasToken:                true,
+			TokenClaims:             map[string]interface{}{"organization": map[string]interface{}{"tenant": float64(42)}},
+			ExpectedCode:            http.StatusOK,
+			ExpectedContentContains: "acme /numbered/tenant/items",
+		},
+		{
+			// the unmapped tenants use the upstream of the resource
+			URI:                     "/tenant/items",
+			HasToken:                true,
+			TokenClaims:             map[string]interface{}{"organization": map[string]interface{}{"tenant": "initech"}},
+			ExpectedCode:            http.StatusOK,
+			ExpectedContentContains: "shared /tenant/items",
+		},
+		{
+			URI:                     "/tenant/items",
+			HasToken:                true,
+			ExpectedCode:            http.StatusOK,
+			ExpectedContentContains: "shared /tenant/items",
+		},
+	}
+	newFakeProxy(cfg).RunTests(t, requests)
+}
+
+func TestClaimRoutingValid(t *testing.T) {
+	valid := &Resource{URL: "/tenant/*", UpstreamClaim: "tenant", ClaimUpstreams: map[string]string{"acme": "http://acme:8080"}}
+	assert.NoError(t, valid.valid())
+
+	cs := []*Resource{
+		{URL: "/tenant/*", UpstreamClaim: "tenant"},
+		{URL: "/tenant/*", ClaimUpstreams: map[string]string{"acme": "http://acme:8080"}},
+		{URL: "/tenant/*", UpstreamClaim: "tenant", ClaimUpstreams: map[string]string{"acme": "acme:8080"}},
+		{URL: "/tenant/*", UpstreamClaim: "tenant", ClaimUpstreams: map[string]string{"acme": "http://acme:8080"}, WhiteListed: true},
+	}
+	for i, c := range cs {
+		assert.Error(t, c.valid(), "case %d", i)
+	}
+}
@@ -13,7 +13,7 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package main
+package proxy
 
 import (
 	"fmt"
@@ -29,8 +29,8 @@ import (
 
 const durationType = "time.Duration"
 
-// newOauthProxyApp creates a new cli application and runs it
-func newOauthProxyApp() *cli.App {
+// NewOauthProxyApp creates the command line application of the proxy
+func NewOauthProxyApp() *cli.App {
 	config := newDefaultConfig()
 	app := cli.NewApp()
 	app.Name = version.Prog
@@ -40,6 +40,12 @@ func newOauthProxyApp() *cli.App {
 	app.Email = version.Email
 	app.Flags = getCommandLineOptions()
 	app.UsageText = "keycloak-gatekeeper [options]"
+	app.Commands = []cli.Command{
+		newBenchCommand(),
+		newClientCommand(),
+		newSyncResourcesCommand(),
+		newTestACLCommand(),
+	}
 
 	// step: the standard usage message isn't that helpful
 	app.OnUsageError = func(context *cli.Context, err error, isSubcommand bool) error {
@@ -161,7 +167,7 @@ func getCommandLineOptions() []cli.Flag {
 // parseCLIOptions parses the command line options and constructs a config object
 func parseCLIOptions(cx *cli.Context, config *Config) (err error) {
 	// step: we can ignore these options in the Config struct
-	ignoredOptions := []string{"tag-data", "match-claims", "resources", "headers"}
+	ignoredOptions := []string{"tag-data", "match-claims", "resources", "headers", "response-body-rewrites", "realms"}
 	// step: iterate the Config and grab command line options via reflection
 	count := reflect.TypeOf(config).Elem().NumField()
 	for i := 0; i < count; i++ {
@@ -221,6 +227,24 @@ func parseCLIOptions(cx *cli.Context, config *Config) (err error) {
 			config.Resources = append(config.Resources, resource)
 		}
 	}
+	if cx.IsSet("response-body-rewrites") {
+		for _, x := range cx.StringSlice("response-body-rewrites") {
+			rewrite, err := parseBodyRewrite(x)
+			if err != nil {
+				return fmt.Errorf("invalid response body rewrite %s, %s", x, err)
+			}
+			config.ResponseBodyRewrites = append(config.ResponseBodyRewrites, rewrite)
+		}
+	}
+	if cx.IsSet("realms") {
+		for _, x := range cx.StringSlice("realms") {
+			realm, err := parseRealm(x)
+			if err != nil {
+				return fmt.Errorf("invalid realm %s, %s", x, err)
+			}
+			config.Realms = append(config.Realms, realm)
+		}
+	}
 
 	return nil
 }
@@ -13,7 +13,7 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package main
+package proxy
 
 import (
 	"testing"
@@ -23,7 +23,7 @@ import (
 )
 
 func TestNewOauthProxyApp(t *testing.T) {
-	a := newOauthProxyApp()
+	a := NewOauthProxyApp()
 	assert.NotNil(t, a)
 }
 
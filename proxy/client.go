@@ -0,0 +1,191 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+// healthCheckOptions are the parameters of the health check client
+type healthCheckOptions struct {
+	// url overrides the health endpoint derived from the configuration
+	url string
+	// timeout is the deadline of the health check
+	timeout time.Duration
+	// certificate and privateKey are a client certificate presented to the listener
+	certificate string
+	privateKey  string
+}
+
+// newClientCommand creates the client subcommand, which queries a running proxy
+func newClientCommand() cli.Command {
+	return cli.Command{
+		Name:  "client",
+		Usage: "query a running instance of the proxy",
+		Subcommands: []cli.Command{
+			{
+				Name:      "health",
+				Usage:     "check the health endpoint of the local proxy, exiting 0 when healthy and 1 otherwise",
+				UsageText: "keycloak-gatekeeper [--config FILE] [options] client health [options]",
+				Flags: []cli.Flag{
+					cli.StringFlag{Name: "url", Usage: "url of the health endpoint, derived from the configuration by default"},
+					cli.DurationFlag{Name: "timeout", Value: 5 * time.Second, Usage: "deadline of the health check"},
+					cli.StringFlag{Name: "client-cert", Usage: "path to a client certificate presented to the listener"},
+					cli.StringFlag{Name: "client-key", Usage: "path to the private key of the client certificate"},
+				},
+				Action: func(cx *cli.Context) error {
+					config := newDefaultConfig()
+					if configFile := cx.GlobalString("config"); configFile != "" {
+						if err := readConfigFile(configFile, config); err != nil {
+							return printError("unable to read the configuration file: %s, error: %s", configFile, err.Error())
+						}
+					}
+					// the listener options may also come from the global flags and environment
+					root := cx
+					for root.Parent() != nil {
+						root = root.Parent()
+					}
+					if err := parseCLIOptions(root, config); err != nil {
+						return printError(err.Error())
+					}
+
+					options := healthCheckOptions{
+						url:         cx.String("url"),
+						timeout:     cx.Duration("timeout"),
+						certificate: cx.String("client-cert"),
+						privateKey:  cx.String("client-key"),
+					}
+					if err := checkHealth(config, options); err != nil {
+						return printError("the service is unhealthy: %s", err)
+					}
+
+					return nil
+				},
+			},
+		},
+	}
+}
+
+// checkHealth requests the health endpoint of the proxy from the listener settings of the configuration
+func checkHealth(config *Config, options healthCheckOptions) error {
+	listen, secure := config.Listen, config.isTLSEnabled()
+	if config.ListenAdmin != "" {
+		listen = config.ListenAdmin
+		secure = config.ListenAdminScheme != unsecureScheme && (secure || config.TLSAdminCertificate != "")
+	}
+
+	target := options.url
+	network, address := "tcp", listen
+	if strings.HasPrefix(listen, "unix://") {
+		network, address = "unix", listen[7:]
+	} else {
+		host, port, err := net.SplitHostPort(listen)
+		if err != nil {
+			return fmt.Errorf("invalid listener %s: %s", listen, err)
+		}
+		if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+			host = "127.0.0.1"
+			if (ip != nil && ip.To4() == nil) || config.ListenIPv6Only {
+				host = "::1"
+			}
+		}
+		address = net.JoinHostPort(host, port)
+	}
+	if target == "" {
+		scheme := unsecureScheme
+		if secure {
+			scheme = secureScheme
+		}
+		host := address
+		if network == "unix" {
+			host = "localhost"
+		}
+		target = fmt.Sprintf("%s://%s%s", scheme, host, path.Clean(config.WithOAuthURI(healthURL)))
+	}
+
+	transport := &http.Transport{
+		DisableKeepAlives: true,
+		// the listener certificate is usually not issued for the local address
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, // nolint: gosec
+	}
+	if options.certificate != "" {
+		certificate, err := tls.LoadX509KeyPair(options.certificate, options.privateKey)
+		if err != nil {
+			return fmt.Errorf("unable to load the client certificate: %s", err)
+		}
+		transport.TLSClientConfig.Certificates = []tls.Certificate{certificate}
+	}
+	if options.url == "" {
+		// dial the configured listener, whatever the address in the url
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			conn, err := (&net.Dialer{}).DialContext(ctx, network, address)
+			if err != nil || !config.EnableProxyProtocol {
+				return conn, err
+			}
+			if _, err := io.WriteString(conn, proxyProtocolHeader(conn)); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return conn, nil
+		}
+	}
+
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   options.timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(target)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded with %s", target, resp.Status)
+	}
+
+	return nil
+}
+
+// proxyProtocolHeader is the PROXY protocol (v1) preamble of a local connection
+func proxyProtocolHeader(conn net.Conn) string {
+	local, okLocal := conn.LocalAddr().(*net.TCPAddr)
+	remote, okRemote := conn.RemoteAddr().(*net.TCPAddr)
+	if !okLocal || !okRemote {
+		return "PROXY UNKNOWN\r\n"
+	}
+	family := "TCP4"
+	if local.IP.To4() == nil {
+		family = "TCP6"
+	}
+
+	return fmt.Sprintf("PROXY %s %s %s %d %d\r\n", family, local.IP, remote.IP, local.Port, remote.Port)
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+)
+
+// clientCertificate returns the client certificate of a request, when verified by the listener
+func clientCertificate(req *http.Request) *x509.Certificate {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	return req.TLS.VerifiedChains[0][0]
+}
+
+// clientCertNames returns the alternative names of a certificate: dns names, emails and uris
+func clientCertNames(cert *x509.Certificate) []string {
+	names := make([]string, 0, len(cert.DNSNames)+len(cert.EmailAddresses)+len(cert.URIs))
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		names = append(names, u.String())
+	}
+
+	return names
+}
+
+// clientCertIdentity returns the identity of a certificate matching one of the identities, if any
+func clientCertIdentity(cert *x509.Certificate, identities []string) (string, bool) {
+	if cert.Subject.CommonName != "" && containedIn(cert.Subject.CommonName, identities, false) {
+		return cert.Subject.CommonName, true
+	}
+	for _, name := range clientCertNames(cert) {
+		if containedIn(name, identities, false) {
+			return name, true
+		}
+	}
+
+	return "", false
+}
+
+// clientCertMiddleware admits the requests with a verified client certificate matching the identities of the
+// resource without a token. The other requests are authenticated by the middlewares given.
+func (r *oauthProxy) clientCertMiddleware(resource *Resource, authentication ...func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	chain := chi.Chain(authentication...)
+	if len(resource.ClientCertIdentities) == 0 {
+		return chain.Handler
+	}
+
+	return func(next http.Handler) http.Handler {
+		authenticated := chain.Handler(next)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if cert := clientCertificate(req); cert != nil {
+				if identity, ok := clientCertIdentity(cert, resource.ClientCertIdentities); ok {
+					_, logger := r.traceSpanRequest(req)
+					logger.Debug("access permitted to the client certificate",
+						zap.String("identity", identity),
+						zap.String("resource", resource.URL))
+
+					next.ServeHTTP(w, req)
+					return
+				}
+			}
+			authenticated.ServeHTTP(w, req)
+		})
+	}
+}
+
+// clientCertHeadersMiddleware adds the subject, alternative names and fingerprint of the verified client certificate
+// to the upstream requests, in place of the headers sent by the clients
+func clientCertHeadersMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.Header.Del(headerXClientCertSubject)
+		req.Header.Del(headerXClientCertSANs)
+		req.Header.Del(headerXClientCertSHA256)
+		if cert := clientCertificate(req); cert != nil {
+			fingerprint := sha256.Sum256(cert.Raw)
+			req.Header.Set(headerXClientCertSubject, cert.Subject.String())
+			if names := clientCertNames(cert); len(names) > 0 {
+				req.Header.Set(headerXClientCertSANs, strings.Join(names, ","))
+			}
+			req.Header.Set(headerXClientCertSHA256, hex.EncodeToString(fingerprint[:]))
+		}
+		next.ServeHTTP(w, req)
+	})
+}
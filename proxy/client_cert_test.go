@@ -0,0 +1,183 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// withClientCertificate sets a client certificate verified by the listener on a request
+func withClientCertificate(req *http.Request, cert *x509.Certificate) *http.Request {
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	return req
+}
+
+func TestClientCertValid(t *testing.T) {
+	tlsConfig := func() *Config {
+		return &Config{
+			TLSCertificate:       testCertificateFile,
+			TLSPrivateKey:        testPrivateKeyFile,
+			TLSClientCertificate: testCertificateFile,
+		}
+	}
+
+	c := tlsConfig()
+	c.TLSClientAuth = clientAuthVerifyIfGiven
+	c.EnableClientCertHeaders = true
+	c.Resources = []*Resource{{URL: "/machines", ClientCertIdentities: []string{"robot.example.com"}}}
+	assert.NoError(t, c.isClientCertValid())
+
+	c = tlsConfig()
+	c.TLSClientAuth = "optional"
+	assert.Error(t, c.isClientCertValid())
+
+	c = tlsConfig()
+	c.TLSClientCertificate = ""
+	c.EnableClientCertHeaders = true
+	assert.Error(t, c.isClientCertValid(), "the headers require the client certificates to be verified")
+
+	c = tlsConfig()
+	c.TLSCertificate = ""
+	c.Resources = []*Resource{{URL: "/machines", ClientCertIdentities: []string{"robot.example.com"}}}
+	assert.Error(t, c.isClientCertValid(), "the identities require a tls listener")
+
+	resource := &Resource{URL: "/machines", Methods: allHTTPMethods, WhiteListed: true, ClientCertIdentities: []string{"robot.example.com"}}
+	assert.Error(t, resource.valid())
+}
+
+func TestClientCertIdentity(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.com/robot")
+	cert := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "robot"},
+		DNSNames:       []string{"robot.example.com"},
+		EmailAddresses: []string{"robot@example.com"},
+		URIs:           []*url.URL{spiffe},
+	}
+	for _, identity := range []string{"robot", "robot.example.com", "robot@example.com", "spiffe://example.com/robot"} {
+		matched, ok := clientCertIdentity(cert, []string{"other", identity})
+		assert.True(t, ok, identity)
+		assert.Equal(t, identity, matched)
+	}
+	_, ok := clientCertIdentity(cert, []string{"other"})
+	assert.False(t, ok)
+}
+
+func TestClientCertMiddleware(t *testing.T) {
+	c := newFakeKeycloakConfig()
+	c.EnableClientCertHeaders = true
+	c.NoRedirects = true
+	c.Resources = []*Resource{
+		{
+			URL:                  "/machines/*",
+			Methods:              allHTTPMethods,
+			ClientCertIdentities: []string{"robot.example.com"},
+		},
+		{
+			URL:     "/users/*",
+			Methods: allHTTPMethods,
+		},
+	}
+	p := newFakeProxy(c)
+	defer func() {
+		p.idp.Close()
+		p.proxy.server.Close()
+	}()
+
+	robot := &x509.Certificate{
+		Raw:      []byte("robot"),
+		Subject:  pkix.Name{CommonName: "robot", Organization: []string{"Machines"}},
+		DNSNames: []string{"robot.example.com"},
+	}
+	intruder := &x509.Certificate{Raw: []byte("intruder"), Subject: pkix.Name{CommonName: "intruder"}}
+
+	serve := func(req *http.Request) (*httptest.ResponseRecorder, fakeUpstreamResponse) {
+		recorder := httptest.NewRecorder()
+		p.proxy.router.ServeHTTP(recorder, req)
+		var upstream fakeUpstreamResponse
+		if recorder.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &upstream))
+		}
+		return recorder, upstream
+	}
+
+	// the identities of the resource are admitted without a token
+	req := withClientCertificate(httptest.NewRequest(http.MethodGet, "/machines/jobs", nil), robot)
+	req.Header.Set(headerXClientCertSubject, "CN=forged")
+	recorder, upstream := serve(req)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "CN=robot,O=Machines", upstream.Headers.Get(headerXClientCertSubject))
+	assert.Equal(t, "robot.example.com", upstream.Headers.Get(headerXClientCertSANs))
+	assert.Len(t, upstream.Headers.Get(headerXClientCertSHA256), 64)
+
+	// the other certificates and resources require a token
+	recorder, _ = serve(withClientCertificate(httptest.NewRequest(http.MethodGet, "/machines/jobs", nil), intruder))
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	recorder, _ = serve(withClientCertificate(httptest.NewRequest(http.MethodGet, "/users/me", nil), robot))
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+
+	// the headers sent by the clients without a certificate are removed
+	token, err := p.idp.signToken(newTestToken(p.idp.getLocation()).claims)
+	require.NoError(t, err)
+	req = httptest.NewRequest(http.MethodGet, "/machines/jobs", nil)
+	req.Header.Set(authorizationHeader, authorizationType+" "+token.Encode())
+	req.Header.Set(headerXClientCertSubject, "CN=forged")
+	recorder, upstream = serve(req)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.Empty(t, upstream.Headers.Get(headerXClientCertSubject))
+}
+
+func TestClientCertOptional(t *testing.T) {
+	get := func(optional bool) error {
+		r := &oauthProxy{config: newDefaultConfig(), log: zap.NewNop()}
+		listener, err := r.createHTTPListener(listenerConfig{
+			listen:             "127.0.0.1:0",
+			useFileTLS:         true,
+			certificate:        testCertificateFile,
+			privateKey:         testPrivateKeyFile,
+			clientCerts:        []string{testCertificateFile},
+			clientCertOptional: optional,
+			tlsAdvancedConfig:  &tlsAdvancedConfig{},
+		})
+		require.NoError(t, err)
+		server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})}
+		go func() { _ = server.Serve(listener) }()
+		defer server.Close()
+
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			//nolint:gas
+			InsecureSkipVerify: true,
+		}}}
+		resp, err := client.Get("https://" + listener.Addr().String())
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		return err
+	}
+
+	assert.Error(t, get(false), "the client certificates are required by default")
+	assert.NoError(t, get(true), "the clients may connect without a certificate when verified if given")
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newFakeHealthService(status int) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth/health", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(status)
+	})
+
+	return mux
+}
+
+func TestCheckHealth(t *testing.T) {
+	healthy := httptest.NewServer(newFakeHealthService(http.StatusOK))
+	defer healthy.Close()
+	draining := httptest.NewServer(newFakeHealthService(http.StatusServiceUnavailable))
+	defer draining.Close()
+	secure := httptest.NewTLSServer(newFakeHealthService(http.StatusOK))
+	defer secure.Close()
+
+	_, port, err := net.SplitHostPort(healthy.Listener.Addr().String())
+	require.NoError(t, err)
+
+	cs := []struct {
+		Config *Config
+		Ok     bool
+	}{
+		{Config: &Config{Listen: healthy.Listener.Addr().String(), OAuthURI: "/oauth"}, Ok: true},
+		{Config: &Config{Listen: ":" + port, OAuthURI: "/oauth"}, Ok: true},
+		{Config: &Config{Listen: "0.0.0.0:" + port, OAuthURI: "/oauth"}, Ok: true},
+		{Config: &Config{Listen: draining.Listener.Addr().String(), OAuthURI: "/oauth"}},
+		{Config: &Config{Listen: healthy.Listener.Addr().String(), OAuthURI: "/other"}},
+		{
+			Config: &Config{
+				Listen:         secure.Listener.Addr().String(),
+				OAuthURI:       "/oauth",
+				TLSCertificate: "cert.pem",
+				TLSPrivateKey:  "key.pem",
+			},
+			Ok: true,
+		},
+		{
+			// the admin listener is checked in place of the main one
+			Config: &Config{
+				Listen:            secure.Listener.Addr().String(),
+				ListenAdmin:       healthy.Listener.Addr().String(),
+				ListenAdminScheme: unsecureScheme,
+				OAuthURI:          "/oauth",
+				TLSCertificate:    "cert.pem",
+				TLSPrivateKey:     "key.pem",
+			},
+			Ok: true,
+		},
+		{Config: &Config{Listen: "127.0.0.1:1", OAuthURI: "/oauth"}},
+		{Config: &Config{Listen: "invalid", OAuthURI: "/oauth"}},
+	}
+	for i, c := range cs {
+		err := checkHealth(c.Config, healthCheckOptions{timeout: time.Second})
+		assert.Equal(t, c.Ok, err == nil, "case %d, error: %v", i, err)
+	}
+
+	err = checkHealth(&Config{Listen: "127.0.0.1:1"}, healthCheckOptions{url: healthy.URL + "/oauth/health", timeout: time.Second})
+	assert.NoError(t, err)
+}
+
+func TestCheckHealthUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gatekeeper")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "gatekeeper.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	server := &http.Server{Handler: newFakeHealthService(http.StatusOK)}
+	go func() { _ = server.Serve(listener) }()
+	defer server.Close()
+
+	assert.NoError(t, checkHealth(&Config{Listen: "unix://" + socket, OAuthURI: "/oauth"}, healthCheckOptions{timeout: time.Second}))
+}
+
+func TestAdminUnixSocketPermissions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gatekeeper")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := newDefaultConfig()
+	config.Listen = "127.0.0.1:0"
+	config.ListenAdmin = "unix://" + filepath.Join(dir, "admin.sock")
+	config.ListenAdminScheme = unsecureScheme
+	config.ListenAdminSocketMode = "0600"
+	config.ListenAdminSocketGroup = strconv.Itoa(os.Getgid())
+	r := &oauthProxy{config: config, log: zap.NewNop()}
+	listener, err := r.createHTTPListener(listenerConfig{
+		listen:      config.ListenAdmin,
+		socketMode:  config.adminSocketMode(),
+		socketGroup: config.ListenAdminSocketGroup,
+	})
+	require.NoError(t, err)
+	server := &http.Server{Handler: newFakeHealthService(http.StatusOK)}
+	go func() { _ = server.Serve(listener) }()
+	defer server.Close()
+
+	info, err := os.Stat(filepath.Join(dir, "admin.sock"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	assert.NoError(t, checkHealth(config, healthCheckOptions{timeout: time.Second}))
+
+	_, err = r.createHTTPListener(listenerConfig{listen: "unix://" + filepath.Join(dir, "other.sock"), socketGroup: "no-such-group"})
+	assert.Error(t, err)
+}
+
+func TestClientHealthCommand(t *testing.T) {
+	healthy := httptest.NewServer(newFakeHealthService(http.StatusOK))
+	defer healthy.Close()
+
+	app := NewOauthProxyApp()
+	err := app.Run([]string{"keycloak-gatekeeper", "--listen", healthy.Listener.Addr().String(), "client", "health", "--timeout", "1s"})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// coalescedHeaders are the request headers which, with the identity and the url, make identical requests
+var coalescedHeaders = []string{"Accept", "Accept-Encoding", "Accept-Language", authorizationHeader, "Cookie"}
+
+// coalescedResponse records the response of an upstream round trip shared by identical concurrent requests.
+// A body outgrowing maxSize is not shared: the leading request streams it to its own client.
+type coalescedResponse struct {
+	code    int
+	header  http.Header
+	body    bytes.Buffer
+	maxSize int
+	// w is the response of the leading request, written directly once streaming
+	w         http.ResponseWriter
+	streaming bool
+	// overflow is called when the response is found too large to be shared
+	overflow func()
+}
+
+func (c *coalescedResponse) Header() http.Header {
+	if c.streaming {
+		return c.w.Header()
+	}
+
+	return c.header
+}
+
+// WriteHeader records the final status, the informational responses not being shared
+func (c *coalescedResponse) WriteHeader(code int) {
+	if c.code == 0 && code >= http.StatusOK {
+		c.code = code
+	}
+}
+
+func (c *coalescedResponse) Write(b []byte) (int, error) {
+	if c.streaming {
+		return c.w.Write(b)
+	}
+	if c.code == 0 {
+		c.code = http.StatusOK
+	}
+	if c.body.Len()+len(b) <= c.maxSize {
+		return c.body.Write(b)
+	}
+	c.streaming = true
+	c.overflow()
+	c.writeTo(c.w)
+
+	return c.w.Write(b)
+}
+
+func (c *coalescedResponse) Flush() {
+	if f, ok := c.w.(http.Flusher); ok && c.streaming {
+		f.Flush()
+	}
+}
+
+// writeTo copies the recorded response, which is not modified
+func (c *coalescedResponse) writeTo(w http.ResponseWriter) {
+	for k, v := range c.header {
+		w.Header()[k] = append([]string(nil), v...)
+	}
+	code := c.code
+	if code == 0 {
+		code = http.StatusOK
+	}
+	w.WriteHeader(code)
+	_, _ = w.Write(c.body.Bytes())
+}
+
+// coalescedCall is an upstream round trip shared by the identical requests joining it while in flight
+type coalescedCall struct {
+	// done is closed once the call is published
+	done chan struct{}
+	// resp is the shared response, nil when it could not be shared
+	resp *coalescedResponse
+}
+
+// coalescer tracks the shared upstream round trips in flight
+type coalescer struct {
+	mu    sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+// join returns the call in flight for the key, or a new one led by the caller
+func (c *coalescer) join(key string) (*coalescedCall, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if call, found := c.calls[key]; found {
+		return call, false
+	}
+	if c.calls == nil {
+		c.calls = make(map[string]*coalescedCall)
+	}
+	call := &coalescedCall{done: make(chan struct{})}
+	c.calls[key] = call
+
+	return call, true
+}
+
+// publish hands the response of a call to the requests which joined it, a nil response letting them make their own
+// round trip. The later requests no longer join the call, and a call is only published once.
+func (c *coalescer) publish(key string, call *coalescedCall, resp *coalescedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.calls[key] != call {
+		return
+	}
+	delete(c.calls, key)
+	call.resp = resp
+	close(call.done)
+}
+
+// coalescedContext keeps the values of the request leading a shared round trip, but not its cancellation:
+// the round trip is not aborted when the first client goes away
+type coalescedContext struct {
+	context.Context
+}
+
+func (coalescedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (coalescedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (coalescedContext) Err() error {
+	return nil
+}
+
+// coalesceKey identifies the requests which may share an upstream round trip: the GET requests without
+// a body, a range or an upgrade, from the same identity to the same url
+func coalesceKey(req *http.Request) (string, bool) {
+	if req.Method != http.MethodGet || req.ContentLength > 0 || req.Header.Get(headerUpgrade) != "" || req.Header.Get("Range") != "" {
+		return "", false
+	}
+	var identity string
+	if scope, ok := req.Context().Value(contextScopeName).(*RequestScope); ok && scope.Identity != nil {
+		identity = scope.Identity.id
+	}
+	parts := make([]string, 0, len(coalescedHeaders)+3)
+	parts = append(parts, identity, req.Host, req.URL.String())
+	for _, name := range coalescedHeaders {
+		parts = append(parts, strings.Join(req.Header.Values(name), ","))
+	}
+
+	return strings.Join(parts, "\x00"), true
+}
+
+// coalesceUpstream proxies the request upstream, sharing the round trip with the identical requests in flight
+func (r *oauthProxy) coalesceUpstream(upstream reverseProxy, w http.ResponseWriter, req *http.Request) {
+	key, ok := coalesceKey(req)
+	if !ok {
+		upstream.ServeHTTP(w, req)
+		return
+	}
+
+	call, leading := r.coalesced.join(key)
+	if !leading {
+		coalescedRequestsMetric.Inc()
+		select {
+		case <-call.done:
+		case <-req.Context().Done():
+			return
+		}
+		if call.resp == nil {
+			// the response was too large to be shared, or the round trip failed
+			upstream.ServeHTTP(w, req)
+			return
+		}
+		call.resp.writeTo(w)
+		return
+	}
+	defer r.coalesced.publish(key, call, nil)
+
+	ctx := context.Context(coalescedContext{req.Context()})
+	if deadline, ok := req.Context().Deadline(); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	recorder := &coalescedResponse{
+		header:   make(http.Header),
+		maxSize:  r.config.CoalesceMaxSize,
+		w:        w,
+		overflow: func() { r.coalesced.publish(key, call, nil) },
+	}
+	upstream.ServeHTTP(recorder, req.WithContext(ctx))
+	if recorder.streaming {
+		return
+	}
+	r.coalesced.publish(key, call, recorder)
+	recorder.writeTo(w)
+}
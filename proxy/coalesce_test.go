@@ -0,0 +1,161 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingUpstream counts the round trips, which complete once released
+type blockingUpstream struct {
+	calls   int32
+	release chan struct{}
+}
+
+func (b *blockingUpstream) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	atomic.AddInt32(&b.calls, 1)
+	<-b.release
+	w.Header().Set("X-Upstream", "shared")
+	w.WriteHeader(http.StatusAccepted)
+	_, _ = w.Write([]byte(req.URL.RawQuery))
+}
+
+// newCoalescingProxy returns a proxy coalescing the requests to a blocking upstream, with a getter of its responses
+func newCoalescingProxy(t *testing.T, maxSize int) (*fakeProxy, *blockingUpstream, func(string) (int, string, http.Header)) {
+	cfg := newFakeKeycloakConfig()
+	cfg.CoalesceMaxSize = maxSize
+	cfg.Resources = []*Resource{
+		{
+			URL:         "/shared/*",
+			Methods:     allHTTPMethods,
+			WhiteListed: true,
+			Coalesce:    true,
+		},
+	}
+	p := newFakeProxy(cfg)
+	upstream := &blockingUpstream{release: make(chan struct{})}
+	p.proxy.upstream = upstream
+
+	get := func(query string) (int, string, http.Header) {
+		resp, err := http.Get(p.getServiceURL() + "/shared/resource?" + query)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		return resp.StatusCode, string(body), resp.Header
+	}
+
+	return p, upstream, get
+}
+
+func TestCoalesceRequests(t *testing.T) {
+	p, upstream, get := newCoalescingProxy(t, 1024)
+	defer func() {
+		p.idp.Close()
+		p.proxy.server.Close()
+	}()
+
+	const concurrency = 10
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			code, body, header := get("q=same")
+			assert.Equal(t, http.StatusAccepted, code)
+			assert.Equal(t, "q=same", body)
+			assert.Equal(t, "shared", header.Get("X-Upstream"))
+		}()
+	}
+	// leave the time for the requests to join the round trip in flight
+	time.Sleep(300 * time.Millisecond)
+	close(upstream.release)
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&upstream.calls))
+
+	// the requests to another url are not shared
+	_, body, _ := get("q=other")
+	assert.Equal(t, "q=other", body)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&upstream.calls))
+}
+
+func TestCoalesceLargeResponses(t *testing.T) {
+	p, upstream, get := newCoalescingProxy(t, 1024)
+	defer func() {
+		p.idp.Close()
+		p.proxy.server.Close()
+	}()
+
+	query := "q=" + strings.Repeat("x", 2048)
+	const concurrency = 5
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			code, body, header := get(query)
+			assert.Equal(t, http.StatusAccepted, code)
+			assert.Equal(t, query, body)
+			assert.Equal(t, "shared", header.Get("X-Upstream"))
+		}()
+	}
+	time.Sleep(300 * time.Millisecond)
+	close(upstream.release)
+	wg.Wait()
+	// the requests waiting for a response too large to be shared make their own round trip
+	assert.Equal(t, int32(concurrency), atomic.LoadInt32(&upstream.calls))
+}
+
+func TestCoalesceKey(t *testing.T) {
+	key := func(method, uri string, headers map[string]string) (string, bool) {
+		req := newFakeHTTPRequest(method, uri)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		return coalesceKey(req)
+	}
+
+	base, ok := key(http.MethodGet, "/resource", nil)
+	assert.True(t, ok)
+	same, _ := key(http.MethodGet, "/resource", map[string]string{"X-Request-ID": "1"})
+	assert.Equal(t, base, same)
+	other, _ := key(http.MethodGet, "/resource", map[string]string{"Accept": "text/html"})
+	assert.NotEqual(t, base, other)
+	other, _ = key(http.MethodGet, "/resource", map[string]string{authorizationHeader: "Bearer other"})
+	assert.NotEqual(t, base, other)
+
+	_, ok = key(http.MethodPost, "/resource", nil)
+	assert.False(t, ok)
+	_, ok = key(http.MethodGet, "/resource", map[string]string{"Range": "bytes=0-10"})
+	assert.False(t, ok)
+	_, ok = key(http.MethodGet, "/resource", map[string]string{headerUpgrade: "websocket"})
+	assert.False(t, ok)
+}
+
+func TestCoalesceStreamingResource(t *testing.T) {
+	r := &Resource{URL: "/stream/*", Coalesce: true, Streaming: true}
+	assert.Error(t, r.valid())
+}
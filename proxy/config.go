@@ -0,0 +1,1192 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// newDefaultConfig returns a initialized config
+func newDefaultConfig() *Config {
+	var hostnames []string
+	if name, err := os.Hostname(); err == nil {
+		hostnames = append(hostnames, name)
+	}
+	hostnames = append(hostnames, []string{"localhost", "127.0.0.1", "::1"}...)
+
+	return &Config{
+		AccessTokenDuration:           time.Duration(720) * time.Hour,
+		AuthRedirectWindow:            time.Minute,
+		ClockSkewLeeway:               5 * time.Second,
+		CookieAccessName:              accessCookie,
+		CookieRefreshName:             refreshCookie,
+		CSRFCookieName:                "kc-csrf",
+		CSRFHeader:                    "X-Csrf-Token",
+		CSRFMode:                      csrfModeSession,
+		CSRFTokenDuration:             12 * time.Hour,
+		EnableAuthorizationCookies:    false,
+		EnableAuthorizationHeader:     true,
+		EnableCSRF:                    false,
+		EnableCookieCompression:       true,
+		EnableDefaultDeny:             true,
+		EnableSessionCookies:          true,
+		EnableTokenHeader:             true,
+		EnableClaimsHeaders:           true,
+		EnableMetrics:                 true,
+		TracingExporter:               "jaeger",
+		HTTPOnlyCookie:                true,
+		IntrospectionCacheDuration:    time.Minute,
+		Headers:                       make(map[string]string),
+		LetsEncryptCacheDir:           "./cache/",
+		LetsEncryptChallenge:          acmeTLSALPNChallenge,
+		LetsEncryptRenewBefore:        30 * 24 * time.Hour,
+		LogoutWebhookTimeout:          5 * time.Second,
+		MatchClaims:                   make(map[string]string),
+		OPATimeout:                    2 * time.Second,
+		StoreConnectTimeout:           5 * time.Second,
+		MaxIdleConns:                  100,
+		MaxIdleConnsPerHost:           50,
+		OAuthURI:                      "/oauth",
+		OpenIDProviderTimeout:         30 * time.Second,
+		OpenIDProviderRetryAfter:      30 * time.Second,
+		PreserveHost:                  false,
+		SelfSignedTLSExpiration:       3 * time.Hour,
+		SelfSignedTLSHostnames:        hostnames,
+		RequestIDHeader:               "X-Request-ID",
+		ResponseMode:                  responseModeQuery,
+		ResponseHeaders:               make(map[string]string),
+		ResponseBodyRewriteMaxSize:    1 << 20,
+		ResponseBodyRewriteTypes:      []string{"text/html", "application/json"},
+		RevocationMode:                revocationModeEndSession,
+		SameSiteCookie:                SameSiteLax,
+		SecureCookie:                  true,
+		ServerIdleTimeout:             120 * time.Second,
+		DrainTimeout:                  30 * time.Second,
+		ScriptTimeout:                 10 * time.Millisecond,
+		StateExpiration:               10 * time.Minute,
+		WellKnownCacheDuration:        5 * time.Minute,
+		DebugCaptureRate:              100,
+		DebugCaptureMaxBody:           4096,
+		DecompressMaxSize:             10 << 20,
+		CoalesceMaxSize:               1 << 20,
+		DebugCaptureRedactions:        []string{"authorization", "cookie", "password", "secret", "token", "key", "credential"},
+		ServerReadTimeout:             10 * time.Second,
+		ServerMaxHeaderBytes:          http.DefaultMaxHeaderBytes,
+		ServerWriteTimeout:            11 * time.Second, // make it upstream timeout + 1s to avoid closing the connection before headers are sent
+		SkipOpenIDProviderTLSVerify:   false,
+		SkipUpstreamTLSVerify:         true,
+		Tags:                          make(map[string]string),
+		TLSClientAuth:                 clientAuthRequire,
+		UpstreamExpectContinueTimeout: 10 * time.Second,
+		UpstreamKeepaliveTimeout:      10 * time.Second,
+		UpstreamKeepalives:            true,
+		UpstreamResponseHeaderTimeout: 10 * time.Second,
+		UpstreamTLSHandshakeTimeout:   10 * time.Second,
+		UpstreamTimeout:               10 * time.Second,
+		UpstreamBackoffMaxDuration:    5 * time.Minute,
+		UseLetsEncrypt:                false,
+	}
+}
+
+// cookiePath is the path of the cookies, under the path of the external url when set
+func (r *Config) cookiePath() string {
+	if r.ExternalURL != "" && r.BaseURI != "" {
+		return r.BaseURI
+	}
+
+	return "/"
+}
+
+// sessionCookiePath is the path of the access and refresh cookies, defaulting to the path of the cookies
+func (r *Config) sessionCookiePath() string {
+	return defaultTo(r.CookiePath, r.cookiePath())
+}
+
+// isExternalURLValid checks the external url, setting the redirection url and the base uri from it
+func (r *Config) isExternalURLValid() error {
+	if r.ExternalURL == "" {
+		return nil
+	}
+	u, err := url.Parse(r.ExternalURL)
+	if err != nil {
+		return fmt.Errorf("the external url is invalid: %s", err)
+	}
+	if (u.Scheme != unsecureScheme && u.Scheme != secureScheme) || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return errors.New("the external url must be an absolute http or https url, without query or fragment")
+	}
+	base := strings.TrimRight(u.Path, "/")
+	switch {
+	case r.BaseURI == "":
+		r.BaseURI = base
+	case strings.TrimRight(r.BaseURI, "/") != base:
+		return errors.New("the base uri differs from the path of the external url")
+	}
+	if r.RedirectionURL == "" {
+		r.RedirectionURL = u.Scheme + "://" + u.Host
+	}
+
+	return nil
+}
+
+// WithOAuthURI returns the oauth uri
+func (r *Config) WithOAuthURI(uri string) string {
+	if r.BaseURI != "" {
+		return fmt.Sprintf("%s/%s/%s", r.BaseURI, r.OAuthURI, uri)
+	}
+
+	return fmt.Sprintf("%s/%s", r.OAuthURI, uri)
+}
+
+// isValid validates if the config is valid
+func (r *Config) isValid() error {
+	if r.Harden {
+		r.applyHardening()
+	}
+	if err := r.isListenValid(); err != nil {
+		return err
+	}
+	if err := r.isTLSValid(); err != nil {
+		return err
+	}
+
+	if err := r.isLetsEncryptValid(); err != nil {
+		return err
+	}
+
+	if err := r.isHostResolutionValid(); err != nil {
+		return err
+	}
+	if err := r.isProviderPinningValid(); err != nil {
+		return err
+	}
+	if err := r.isClientCertValid(); err != nil {
+		return err
+	}
+	if r.UpstreamTimeout < 0 || r.OpenIDProviderConnectTimeout < 0 || r.StoreConnectTimeout < 0 {
+		return errors.New("the connect timeouts must be positive durations")
+	}
+
+	if err := r.isRealmsValid(); err != nil {
+		return err
+	}
+
+	if r.EnableForwarding {
+		if r.EnableStartWithoutProvider {
+			return errors.New("the forwarding proxy cannot start without the openid provider")
+		}
+		return r.isForwardingValid()
+	}
+
+	if r.EnableTracing && r.TracingAgentEndpoint == "" {
+		return fmt.Errorf("an agent endpoint must be specified when enabling tracing")
+	}
+	const (
+		jaegerExporter  = "jaeger"
+		datadogExporter = "datadog"
+	)
+	if r.EnableTracing && r.TracingExporter != jaegerExporter && r.TracingExporter != datadogExporter {
+		return fmt.Errorf("unsupported trace exporter. Current supported values are %q|%q", jaegerExporter, datadogExporter)
+	}
+
+	if r.ProfilingRateLimit < 0 {
+		return errors.New("profiling-rate-limit must be a positive number")
+	}
+	if r.ProfilingDuration < 0 {
+		return errors.New("profiling-duration must be a positive duration")
+	}
+
+	if r.SameSiteCookie != "" && r.SameSiteCookie != SameSiteStrict && r.SameSiteCookie != SameSiteLax && r.SameSiteCookie != SameSiteNone {
+		return errors.New("same-site-cookie must be one of Strict|Lax|None")
+	}
+	if r.CookiePath != "" && !strings.HasPrefix(r.CookiePath, "/") {
+		return errors.New("cookie-path must be an absolute path")
+	}
+
+	return r.isReverseProxyValid()
+}
+
+// hasCustomSignInPage checks if there is a custom sign in  page
+func (r *Config) hasCustomSignInPage() bool {
+	return r.SignInPage != ""
+}
+
+// hasForbiddenPage checks if there is a custom forbidden page
+func (r *Config) hasCustomForbiddenPage() bool {
+	return r.ForbiddenPage != ""
+}
+
+// adminHeaderLimits are the header limits of the admin listener, defaulting to the ones of the server
+func (r *Config) adminHeaderLimits() (time.Duration, int, int) {
+	timeout, maxBytes, maxCount := r.ServerReadHeaderTimeout, r.ServerMaxHeaderBytes, r.ServerMaxHeaderCount
+	if r.AdminReadHeaderTimeout > 0 {
+		timeout = r.AdminReadHeaderTimeout
+	}
+	if r.AdminMaxHeaderBytes > 0 {
+		maxBytes = r.AdminMaxHeaderBytes
+	}
+	if r.AdminMaxHeaderCount > 0 {
+		maxCount = r.AdminMaxHeaderCount
+	}
+
+	return timeout, maxBytes, maxCount
+}
+
+// isEndpointDisabled checks if an oauth endpoint has been disabled
+func (r *Config) isEndpointDisabled(endpoint string) bool {
+	for _, x := range r.DisabledEndpoints {
+		if trailer+strings.Trim(x, trailer) == endpoint {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isTLSEnabled checks if the main listener serves TLS
+func (r *Config) isTLSEnabled() bool {
+	return (r.TLSCertificate != "" && r.TLSPrivateKey != "") || r.UseLetsEncrypt || r.EnabledSelfSignedTLS
+}
+
+// applyHardening enforces the secure settings of the harden preset, and the strict mode so that the remaining
+// insecure combinations reported by the startup self-check refuse the start of the service
+func (r *Config) applyHardening() {
+	r.Strict = true
+	r.SecureCookie = true
+	r.HTTPOnlyCookie = true
+	if r.SameSiteCookie == "" || r.SameSiteCookie == SameSiteNone {
+		r.SameSiteCookie = SameSiteLax
+	}
+	r.EnableDefaultDeny = true
+	r.EnableSecurityFilter = true
+	r.EnableContentNoSniff = true
+	r.EnableFrameDeny = true
+	r.EnableBrowserXSSFilter = true
+	if r.isTLSEnabled() {
+		r.EnableSTS = true
+	}
+}
+
+// hasBodyRewrites checks if the bodies of the upstream responses are rewritten
+func (r *Config) hasBodyRewrites() bool {
+	if len(r.ResponseBodyRewrites) > 0 {
+		return true
+	}
+	for _, x := range r.Resources {
+		if len(x.ResponseBodyRewrites) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// listenNetwork returns the network of the tcp listeners
+func (r *Config) listenNetwork() string {
+	if r.ListenIPv6Only {
+		return "tcp6"
+	}
+
+	return "tcp"
+}
+
+// hasCors checks if CORS is handled by the gatekeeper, either globally or on some resource
+func (r *Config) hasCors() bool {
+	if len(r.CorsOrigins) > 0 {
+		return true
+	}
+	for _, x := range r.Resources {
+		if len(x.CorsOrigins) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isAllowedRedirect checks if a landing URL complies with the allowed redirection hosts and schemes.
+// Relative paths are always allowed, provided they can't be interpreted as protocol-relative URLs. Unless
+// hosts are configured, the absolute URLs may only land on the host of the request or of the redirection url.
+func (r *Config) isAllowedRedirect(target, requestHost string) bool {
+	if strings.HasPrefix(target, "/\\") || strings.HasPrefix(target, "\\") {
+		return false
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+	if u.Scheme == "" && u.Host == "" {
+		return true
+	}
+
+	if u.Scheme != "" {
+		schemes := r.AllowedRedirectSchemes
+		if len(schemes) == 0 {
+			schemes = []string{unsecureScheme, secureScheme}
+		}
+		if !containsFold(u.Scheme, schemes) {
+			return false
+		}
+		if u.Host == "" {
+			// e.g. mailto:, javascript:
+			return false
+		}
+	}
+
+	// a session shared across subdomains may land on any of these
+	if r.EnableCrossSubdomainSession {
+		domain := strings.TrimPrefix(r.CookieDomain, ".")
+		if matchHostname(u.Hostname(), []string{domain, wildcard + "." + domain}) {
+			return true
+		}
+	}
+
+	hosts := r.AllowedRedirectHosts
+	if len(hosts) == 0 {
+		if host := (&url.URL{Host: requestHost}).Hostname(); host != "" {
+			hosts = append(hosts, host)
+		}
+		if redirection, err := url.Parse(r.RedirectionURL); err == nil && redirection.Hostname() != "" {
+			hosts = append(hosts, redirection.Hostname())
+		}
+	}
+
+	return matchHostname(u.Hostname(), hosts)
+}
+
+// tlsAdvancedConfig holds advanced parameters to control TLS negotiation
+type tlsAdvancedConfig struct {
+	tlsUseModernSettings        bool
+	tlsPreferServerCipherSuites bool
+	tlsMinVersion               string
+	tlsCipherSuites             []string
+	tlsCurvePreferences         []string
+}
+
+// tlsSettings holds advanced TLS parameters, parsed from config
+type tlsSettings struct {
+	tlsPreferServerCipherSuites bool
+	tlsMinVersion               uint16
+	tlsCipherSuites             []uint16
+	tlsCurvePreferences         []tls.CurveID
+}
+
+func parseTLS(config *tlsAdvancedConfig) (*tlsSettings, error) {
+	parsed := &tlsSettings{}
+
+	parsed.tlsPreferServerCipherSuites = config.tlsPreferServerCipherSuites || config.tlsUseModernSettings
+
+	if config.tlsMinVersion != "" {
+		switch config.tlsMinVersion {
+		case "TLS1.0":
+			parsed.tlsMinVersion = tls.VersionTLS10
+		case "TLS1.1":
+			parsed.tlsMinVersion = tls.VersionTLS11
+		case "TLS1.2":
+			parsed.tlsMinVersion = tls.VersionTLS12
+		case "TLS1.3":
+			parsed.tlsMinVersion = tls.VersionTLS13
+		default:
+			return nil, errors.New("invalid TLS version configured. Accepted values are: TLS1.0, TLS1.1, TLS1.2, TLS.1.3")
+		}
+	} else if config.tlsUseModernSettings {
+		// standard modern setting
+		// https://www.owasp.org/index.php/Transport_Layer_Protection_Cheat_Sheet#Rule_-_Only_Support_Strong_Protocols
+		parsed.tlsMinVersion = tls.VersionTLS12
+	}
+
+	if config.tlsUseModernSettings || len(config.tlsCurvePreferences) > 0 {
+		if len(config.tlsCurvePreferences) > 0 {
+			parsed.tlsCurvePreferences = make([]tls.CurveID, 0, len(config.tlsCurvePreferences))
+			for _, curveName := range config.tlsCurvePreferences {
+				switch curveName {
+				case "P256":
+					parsed.tlsCurvePreferences = append(parsed.tlsCurvePreferences, tls.CurveP256)
+				case "P384":
+					parsed.tlsCurvePreferences = append(parsed.tlsCurvePreferences, tls.CurveP384)
+				case "P521":
+					parsed.tlsCurvePreferences = append(parsed.tlsCurvePreferences, tls.CurveP521)
+				case "X25519":
+					parsed.tlsCurvePreferences = append(parsed.tlsCurvePreferences, tls.X25519)
+				default:
+					return nil, errors.New("invalid TLS curve configured. Accepted values are: P256, P384, P521, X25519")
+				}
+			}
+		} else if config.tlsUseModernSettings {
+			// standard modern settings
+			// Only use curves which have assembly implementations
+			// https://github.com/golang/go/tree/master/src/crypto/elliptic
+			parsed.tlsCurvePreferences = []tls.CurveID{
+				tls.CurveP256,
+				tls.X25519,
+			}
+		}
+	}
+
+	if config.tlsUseModernSettings || len(config.tlsCipherSuites) > 0 {
+		parsed.tlsCipherSuites = make([]uint16, 0, len(config.tlsCurvePreferences))
+		for _, cipher := range config.tlsCipherSuites {
+			switch cipher {
+			case "TLS_FALLBACK_SCSV":
+				parsed.tlsCipherSuites = append(parsed.tlsCipherSuites, tls.TLS_FALLBACK_SCSV)
+			case "TLS_RSA_WITH_RC4_128_SHA":
+				parsed.tlsCipherSuites = append(parsed.tlsCipherSuites, tls.TLS_RSA_WITH_RC4_128_SHA)
+			case "TLS_RSA_WITH_3DES_EDE_CBC_SHA":
+				parsed.tlsCipherSuites = append(parsed.tlsCipherSuites, tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA)
+			case "TLS_RSA_WITH_AES_128_CBC_SHA":
+				parsed.tlsCipherSuites = append(parsed.tlsCipherSuites, tls.TLS_RSA_WITH_AES_128_CBC_SHA)
+			case "TLS_RSA_WITH_AES_256_CBC_SHA":
+				parsed.tlsCipherSuites = append(parsed.tlsCipherSuites, tls.TLS_RSA_WITH_AES_256_CBC_SHA)
+			case "TLS_RSA_WITH_AES_128_CBC_SHA256":
+				parsed.tlsCipherSuites = append(parsed.tlsCipherSuites, tls.TLS_RSA_WITH_AES_128_CBC_SHA256)
+			case "TLS_RSA_WITH_AES_128_GCM_SHA256":
+				parsed.tlsCipherSuites = append(parsed.tlsCipherSuites, tls.TLS_RSA_WITH_AES_128_GCM_SHA256)
+			case "TLS_RSA_WITH_AES_256_GCM_SHA384":
+				parsed.tlsCipherSuites = append(parsed.tlsCipherSuites, tls.TLS_RSA_WITH_AES_256_GCM_SHA384)
+			case "TLS_ECDHE_ECDSA_WITH_RC4_128_SHA":
+				parsed.tlsCipherSuites = append(parsed.tlsCipherSuites, tls.TLS_ECDHE_ECDSA_WITH_RC4_128_SHA)
+			case "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA":
+				parsed.tlsCipherSuites = append(parsed.tlsCipherSuites, tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA)
+			case "TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA":
+				parsed.tlsCipherSuites = append(parsed.tlsCipherSuites, tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA)
+			case "TLS_ECDHE_RSA_WITH_RC4_128_SHA":
+				parsed.tlsCipherSuites = append(parsed.tlsCipherSuites, tls.TLS_ECDHE_RSA_WITH_RC4_128_SHA)
+			case "TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA":
+				parsed.tlsCipherSuites = append(parsed.tlsCipherSuites, tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA)
+			case "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA":
+				parsed.tlsCipherSuites = append(parsed.tlsCipherSuites, tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA)
+			case "TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA":
+				parsed.tlsCipherSuites = append(parsed.tlsCipherSuites, tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA)
+			case "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256":
+				parsed.tlsCipherSuites = append(parsed.tlsCipherSuites, tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256)
+			case "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256":
+				parsed.tlsCipherSuites = append(parsed.tlsCipherSuites, tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256)
+			case "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":
+				parsed.tlsCipherSuites = append(parsed.tlsCipherSuites, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256)
+			case "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256":
+				parsed.tlsCipherSuites = append(parsed.tlsCipherSuites, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256)
+			case "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384":
+				parsed.tlsCipherSuites = append(parsed.tlsCipherSuites, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384)
+			case "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384":
+				parsed.tlsCipherSuites = append(parsed.tlsCipherSuites, tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384)
+			case "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305":
+				parsed.tlsCipherSuites = append(parsed.tlsCipherSuites, tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305)
+			case "TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305":
+				parsed.tlsCipherSuites = append(parsed.tlsCipherSuites, tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305)
+			case "TLS_CHACHA20_POLY1305_SHA256":
+				parsed.tlsCipherSuites = append(parsed.tlsCipherSuites, tls.TLS_CHACHA20_POLY1305_SHA256)
+			case "TLS_AES_128_GCM_SHA256":
+				parsed.tlsCipherSuites = append(parsed.tlsCipherSuites, tls.TLS_AES_128_GCM_SHA256)
+			case "TLS_AES_256_GCM_SHA384":
+				parsed.tlsCipherSuites = append(parsed.tlsCipherSuites, tls.TLS_AES_256_GCM_SHA384)
+			default:
+				return nil, errors.New("invalid TLS cipher suite configured. Accepted values are listed at https://golang.org/pkg/crypto/tls/#pkg-constants")
+			}
+		}
+		if config.tlsUseModernSettings && len(config.tlsCurvePreferences) == 0 {
+			// Use modern tls mode https://wiki.mozilla.org/Security/Server_Side_TLS#Modern_compatibility
+			// See security linter code: https://github.com/securego/gosec/blob/master/rules/tls_config.go#L11
+			// These ciphersuites support Forward Secrecy: https://en.wikipedia.org/wiki/Forward_secrecy
+			parsed.tlsCipherSuites = []uint16{
+				tls.TLS_CHACHA20_POLY1305_SHA256,
+				tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+				tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+				tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_AES_128_GCM_SHA256,
+				tls.TLS_AES_256_GCM_SHA384,
+			}
+		}
+		// when some cipher preferences are explicitly provided, enforce the presence of TLS_FALLBACK_SCSV
+		// at the top of cipher suites preferences.
+		// When no suites are specified, stick to golang's defaults.
+		if len(parsed.tlsCipherSuites) > 0 {
+			enforcedSCSV := make([]uint16, 1, len(parsed.tlsCipherSuites)+1)
+			enforcedSCSV[0] = tls.TLS_FALLBACK_SCSV
+			for _, cph := range parsed.tlsCipherSuites {
+				if cph == tls.TLS_FALLBACK_SCSV {
+					continue
+				}
+				enforcedSCSV = append(enforcedSCSV, cph)
+			}
+			parsed.tlsCipherSuites = enforcedSCSV
+		}
+	}
+	return parsed, nil
+}
+
+// adminSocketMode returns the file mode of the unix socket of the admin listener, zero when left to the umask
+func (r *Config) adminSocketMode() os.FileMode {
+	mode, _ := strconv.ParseUint(defaultTo(r.ListenAdminSocketMode, "0"), 8, 32)
+
+	return os.FileMode(mode)
+}
+
+func (r *Config) isListenValid() error {
+	if r.Listen == "" {
+		return errors.New("you have not specified the listening interface")
+	}
+	if r.ListenAdmin == r.Listen {
+		r.ListenAdmin = ""
+	}
+	if err := r.isListenIPv6Valid(); err != nil {
+		return err
+	}
+	if r.ListenAdminScheme == "" {
+		r.ListenAdminScheme = secureScheme
+	}
+	if r.ListenAdminScheme != secureScheme && r.ListenAdminScheme != unsecureScheme {
+		return errors.New("scheme for admin listener must be one of [http, https]")
+	}
+	if (r.ListenAdminSocketMode != "" || r.ListenAdminSocketGroup != "") && !strings.HasPrefix(r.ListenAdmin, "unix://") {
+		return errors.New("the permissions of the admin socket require a unix socket admin listener (listen-admin)")
+	}
+	if mode, err := strconv.ParseUint(defaultTo(r.ListenAdminSocketMode, "0"), 8, 32); err != nil || mode > 0777 {
+		return errors.New("listen-admin-socket-mode must be an octal file mode, e.g. 0660")
+	}
+	if r.EnableAdminAPI && r.ListenAdmin == "" {
+		return errors.New("the admin api requires a separate admin listener (listen-admin)")
+	}
+	if r.RequestTimeout < 0 {
+		return errors.New("request-timeout must be a positive duration")
+	}
+	if r.OpenIDProviderRetryAfter < 0 {
+		return errors.New("openid-provider-retry-after must be a positive duration")
+	}
+	if r.ServerReadHeaderTimeout < 0 || r.AdminReadHeaderTimeout < 0 {
+		return errors.New("the read header timeouts must be positive durations")
+	}
+	if r.ServerMaxHeaderBytes < 0 || r.ServerMaxHeaderCount < 0 || r.AdminMaxHeaderBytes < 0 || r.AdminMaxHeaderCount < 0 {
+		return errors.New("the header limits must be positive numbers")
+	}
+	if r.DrainTimeout < 0 {
+		return errors.New("drain-timeout must be a positive duration")
+	}
+	if r.ScriptTimeout < 0 {
+		return errors.New("script-timeout must be a positive duration")
+	}
+	if r.DebugCaptureRate < 0 || r.DebugCaptureRate > 100 {
+		return errors.New("debug-capture-rate must be a percentage between 0 and 100")
+	}
+	if r.DebugCaptureMaxBody < 0 {
+		return errors.New("debug-capture-max-body must be a number >= 0")
+	}
+	if r.MaxIdleConns <= 0 {
+		return errors.New("max-idle-connections must be a number > 0")
+	}
+	if r.MaxIdleConnsPerHost < 0 || r.MaxIdleConnsPerHost > r.MaxIdleConns {
+		return errors.New("maxi-idle-connections-per-host must be a number > 0 and <= max-idle-connections")
+	}
+	if r.UpstreamIdleConnTimeout < 0 || r.UpstreamMaxConnLifetime < 0 {
+		return errors.New("upstream-idle-connection-timeout and upstream-max-connection-lifetime must be positive durations")
+	}
+	if r.MaxInflightPerIP < 0 || r.MaxInflightPerIdentity < 0 {
+		return errors.New("max-inflight-per-ip and max-inflight-per-identity must be positive numbers")
+	}
+	if r.InflightQueueTimeout < 0 {
+		return errors.New("inflight-queue-timeout must be a positive duration")
+	}
+	if r.MaxAuthRedirects < 0 {
+		return errors.New("max-auth-redirects must be a positive number")
+	}
+	if r.MaxAuthRedirects > 0 && r.AuthRedirectWindow <= 0 {
+		return errors.New("auth-redirect-window must be a positive duration")
+	}
+	if r.EnableUpstreamBackoff && r.UpstreamBackoffMaxDuration <= 0 {
+		return errors.New("upstream-backoff-max-duration must be a positive duration")
+	}
+	if r.IdentityDailyByteQuota < 0 {
+		return errors.New("identity-daily-byte-quota must be a positive number")
+	}
+	if r.IdentityDailyByteQuota > 0 && !r.EnableIdentityAccounting {
+		return errors.New("identity-daily-byte-quota requires enable-identity-accounting")
+	}
+	if err := isOPAURLValid(r.OPAURL); err != nil {
+		return err
+	}
+	if r.EnableUMA && r.Provider != "" && r.Provider != providerKeycloak {
+		return errors.New("enable-uma requires the keycloak authorization services, with the keycloak provider")
+	}
+	if r.OPATimeout < 0 {
+		return errors.New("opa-timeout must be a positive duration")
+	}
+	if r.ClockSkewLeeway < 0 {
+		return errors.New("clock-skew-leeway must be a positive duration")
+	}
+	if r.TokenCacheSize < 0 {
+		return errors.New("token-cache-size must be a positive number")
+	}
+	return nil
+}
+
+// isListenIPv6Valid checks the separate IPv6 interface of the main listener
+func (r *Config) isListenIPv6Valid() error {
+	if r.ListenIPv6 == "" {
+		return nil
+	}
+	if r.ListenIPv6Only {
+		return errors.New("listen-ipv6 and listen-ipv6-only are exclusive: the listen interface is bound on IPv4 with listen-ipv6")
+	}
+	if strings.HasPrefix(r.Listen, "unix://") {
+		return errors.New("listen-ipv6 requires a tcp listen interface")
+	}
+	host, _, err := net.SplitHostPort(r.ListenIPv6)
+	if err != nil {
+		return fmt.Errorf("invalid listen-ipv6 interface: %v", err)
+	}
+	if ip := net.ParseIP(host); host != "" && (ip == nil || ip.To4() != nil) {
+		return fmt.Errorf("invalid listen-ipv6 interface: %q is not an IPv6 address", host)
+	}
+
+	return nil
+}
+
+// isProviderPinningValid validates the pins of the provider certificates
+func (r *Config) isProviderPinningValid() error {
+	if len(r.OpenIDProviderGracePins) > 0 && len(r.OpenIDProviderPins) == 0 {
+		return errors.New("the openid provider grace pins are only accepted with openid-provider-pins")
+	}
+	if _, err := parseSPKIPins(r.OpenIDProviderPins); err != nil {
+		return err
+	}
+	_, err := parseSPKIPins(r.OpenIDProviderGracePins)
+
+	return err
+}
+
+// isLetsEncryptValid validates the acme settings
+func (r *Config) isLetsEncryptValid() error {
+	if !r.UseLetsEncrypt {
+		return nil
+	}
+	if r.LetsEncryptCacheDir == "" {
+		return fmt.Errorf("the letsencrypt cache dir has not been set")
+	}
+	switch r.LetsEncryptChallenge {
+	case acmeTLSALPNChallenge:
+	case acmeHTTPChallenge:
+		if r.ListenHTTP == "" {
+			return errors.New("the http-01 letsencrypt challenge is answered on the http listener, listen-http must be set")
+		}
+	default:
+		return fmt.Errorf("the letsencrypt challenge must be either %s or %s", acmeTLSALPNChallenge, acmeHTTPChallenge)
+	}
+	if r.LetsEncryptDirectoryURL != "" {
+		if u, err := url.Parse(r.LetsEncryptDirectoryURL); err != nil || u.Scheme != secureScheme || u.Host == "" {
+			return fmt.Errorf("the letsencrypt directory url %q must be an https url", r.LetsEncryptDirectoryURL)
+		}
+	}
+	if r.LetsEncryptRenewBefore < 0 {
+		return errors.New("the letsencrypt renewal must be a positive duration")
+	}
+	if len(r.Hostnames) == 0 && r.RedirectionURL == "" {
+		return errors.New("the hostnames or the redirection url must be set to restrict the letsencrypt certificates")
+	}
+
+	return nil
+}
+
+func (r *Config) isTLSValid() error {
+	if r.TLSCertificate != "" && r.TLSPrivateKey == "" {
+		return errors.New("you have not provided a private key")
+	}
+	if r.TLSAdminCertificate != "" && r.TLSAdminPrivateKey == "" {
+		return errors.New("you have not provided a private key for admin endpoint")
+	}
+	if r.TLSPrivateKey != "" && r.TLSCertificate == "" {
+		return errors.New("you have not provided a certificate file")
+	}
+	if r.TLSAdminPrivateKey != "" && r.TLSAdminCertificate == "" {
+		return errors.New("you have not provided a certificate file for admin endpoint")
+	}
+	if r.TLSCertificate != "" && !fileExists(r.TLSCertificate) {
+		return fmt.Errorf("the tls certificate %s does not exist", r.TLSCertificate)
+	}
+	if r.TLSAdminCertificate != "" && !fileExists(r.TLSAdminCertificate) {
+		return fmt.Errorf("the tls certificate %s does not exist for admin endpoint", r.TLSAdminCertificate)
+	}
+	if r.TLSPrivateKey != "" && !fileExists(r.TLSPrivateKey) {
+		return fmt.Errorf("the tls private key %s does not exist", r.TLSPrivateKey)
+	}
+	if r.TLSAdminPrivateKey != "" && !fileExists(r.TLSAdminPrivateKey) {
+		return fmt.Errorf("the tls private key %s does not exist for admin endpoint", r.TLSAdminPrivateKey)
+	}
+	if r.TLSCaCertificate != "" && !fileExists(r.TLSCaCertificate) {
+		return fmt.Errorf("the tls ca certificate file %s does not exist", r.TLSCaCertificate)
+	}
+	if r.TLSAdminCaCertificate != "" && !fileExists(r.TLSAdminCaCertificate) {
+		return fmt.Errorf("the tls ca certificate file %s does not exist for admin endpoint", r.TLSAdminCaCertificate)
+	}
+	if r.TLSClientCertificate != "" && len(r.TLSClientCertificates) > 0 {
+		return fmt.Errorf("specify only one of single TLSAdminClientCertificate or array TLSAdminClientCertificates")
+	}
+	if r.TLSClientCertificate != "" && !fileExists(r.TLSClientCertificate) {
+		return fmt.Errorf("the tls client certificate %s does not exist", r.TLSClientCertificate)
+	}
+	for _, clientCertFile := range r.TLSClientCertificates {
+		if !fileExists(clientCertFile) {
+			return fmt.Errorf("the tls client certificate %s does not exist", clientCertFile)
+		}
+	}
+	if r.TLSAdminClientCertificate != "" && len(r.TLSAdminClientCertificates) > 0 {
+		return fmt.Errorf("specify only one of single TLSAdminClientCertificate or array TLSAdminClientCertificates")
+	}
+	if r.TLSAdminClientCertificate != "" && !fileExists(r.TLSAdminClientCertificate) {
+		return fmt.Errorf("the tls client certificate %s does not exist for admin endpoint", r.TLSAdminClientCertificate)
+	}
+	for _, clientCertFile := range r.TLSAdminClientCertificates {
+		if !fileExists(clientCertFile) {
+			return fmt.Errorf("the tls client certificate %s does not exist for admin endpoint", clientCertFile)
+		}
+	}
+	return nil
+}
+
+// isClientCertValid validates the authentication of the clients by their certificates
+func (r *Config) isClientCertValid() error {
+	switch r.TLSClientAuth {
+	case "", clientAuthRequire, clientAuthVerifyIfGiven:
+	default:
+		return fmt.Errorf("the tls client auth must be either %s or %s", clientAuthRequire, clientAuthVerifyIfGiven)
+	}
+	verified := r.isTLSEnabled() && (r.TLSClientCertificate != "" || len(r.TLSClientCertificates) > 0)
+	if r.EnableClientCertHeaders && !verified {
+		return errors.New("the client certificates are only verified on a tls listener with tls-client-certificate(s), enable-client-cert-headers requires them")
+	}
+	for _, x := range r.Resources {
+		if len(x.ClientCertIdentities) > 0 && !verified {
+			return fmt.Errorf("the client certificates are only verified on a tls listener with tls-client-certificate(s), the client-cert-identities of resource %s require them", x.URL)
+		}
+	}
+
+	return nil
+}
+
+func (r *Config) isReverseProxyValid() error {
+	if err := r.isExternalURLValid(); err != nil {
+		return err
+	}
+	switch r.Upstream {
+	case "":
+		if r.EnableDefaultDeny && !r.EnableDefaultNotFound {
+			return errors.New("you expect some default fallback routing, but have not specified an upstream endpoint to proxy to")
+		}
+		for _, resource := range r.Resources {
+			if resource.Upstream == "" && resource.StaticDirectory == "" {
+				return fmt.Errorf("you did not set any default upstream and you have not specified an upstream endpoint to proxy to on resource: %s", resource.URL)
+			}
+		}
+	default:
+		if _, err := url.Parse(r.Upstream); err != nil {
+			return fmt.Errorf("the upstream endpoint is invalid, %s", err)
+		}
+	}
+	if err := isUpstreamBalancingValid(r.Upstream, r.UpstreamURLs, r.UpstreamBalancing); err != nil {
+		return err
+	}
+	if err := isEgressProxyValid("upstream-proxy", r.UpstreamProxy); err != nil {
+		return err
+	}
+	if r.UpstreamH2C && strings.HasPrefix(r.Upstream, secureScheme+":") {
+		return errors.New("upstream-h2c speaks HTTP/2 without TLS: HTTP/2 is already negotiated with a https upstream")
+	}
+	if r.UpstreamProxy != "" && strings.HasPrefix(r.Upstream, "unix://") {
+		return errors.New("the upstream-proxy cannot be used with a unix socket upstream")
+	}
+
+	if !r.SkipUpstreamTLSVerify && r.UpstreamCA == "" {
+		return fmt.Errorf("you cannot require to check upstream tls and omit to specify the root ca to verify it: %s", r.UpstreamCA)
+	}
+
+	// step: if token verification is enabled (skip is off), we need the below checks
+	if !r.SkipTokenVerification {
+		if err := r.isTokenConfigValid(); err != nil {
+			return err
+		}
+	}
+	if err := r.isUpstreamTokenCookieValid(); err != nil {
+		return err
+	}
+	if err := isCorsOriginsValid(r.CorsOrigins, r.CorsCredentials); err != nil {
+		return err
+	}
+	if err := isResponseRulesValid(r.StripResponseHeaders, r.ResponseCookieDomain); err != nil {
+		return err
+	}
+	if err := isBodyRewritesValid(r.ResponseBodyRewrites); err != nil {
+		return err
+	}
+	for _, x := range r.Resources {
+		if x.Decompress && r.DecompressMaxSize <= 0 {
+			return fmt.Errorf("the resource %s decompresses the request bodies, the decompress max size must be a positive number of bytes", x.URL)
+		}
+		if x.Coalesce && r.CoalesceMaxSize <= 0 {
+			return fmt.Errorf("the resource %s coalesces the requests, the coalesce max size must be a positive number of bytes", x.URL)
+		}
+	}
+	if r.hasBodyRewrites() {
+		if r.ResponseBodyRewriteMaxSize <= 0 {
+			return errors.New("the response body rewrite max size must be a positive number of bytes")
+		}
+		if len(r.ResponseBodyRewriteTypes) == 0 {
+			return errors.New("the response body rewrites require the media types of the bodies rewritten")
+		}
+	}
+	if _, found := providerProfiles[r.Provider]; r.Provider != "" && !found {
+		return fmt.Errorf("unknown provider %s, should be one of: %s", r.Provider, strings.Join(providerNames(), ", "))
+	}
+	if len(r.AllowedTenants) > 0 && !getProviderProfile(r.Provider).tenantIssuer {
+		return fmt.Errorf("the allowed tenants are only supported by the provider %s", providerAzureAD)
+	}
+	if _, err := lookupPlugins(r.Plugins); err != nil {
+		return err
+	}
+
+	// check: ensure each of the resource are valid
+	newResources := make([]*Resource, 0, len(r.Resources))
+	for _, resource := range r.Resources {
+		if err := resource.valid(); err != nil {
+			return err
+		}
+		// expand resources with multiple urls
+		if len(resource.URLs) > 0 {
+			for _, u := range resource.URLs {
+				res := &Resource{
+					URL:                     u,
+					URLs:                    nil,
+					Methods:                 append([]string{}, resource.Methods...),
+					WhiteListed:             resource.WhiteListed,
+					BlackListed:             resource.BlackListed,
+					RequireAnyRole:          resource.RequireAnyRole,
+					Roles:                   append([]string{}, resource.Roles...),
+					Groups:                  append([]string{}, resource.Groups...),
+					Audiences:               append([]string{}, resource.Audiences...),
+					Realm:                   resource.Realm,
+					MethodRequirements:      resource.MethodRequirements,
+					OptionalAuth:            resource.OptionalAuth,
+					EnableCSRF:              resource.EnableCSRF,
+					StripBasePath:           resource.StripBasePath,
+					CorsOrigins:             append([]string{}, resource.CorsOrigins...),
+					CorsMethods:             append([]string{}, resource.CorsMethods...),
+					CorsHeaders:             append([]string{}, resource.CorsHeaders...),
+					Upstream:                resource.Upstream,
+					Upstreams:               append([]string{}, resource.Upstreams...),
+					UpstreamBalancing:       resource.UpstreamBalancing,
+					UpstreamH2C:             resource.UpstreamH2C,
+					MaxIdleConns:            resource.MaxIdleConns,
+					MaxIdleConnsPerHost:     resource.MaxIdleConnsPerHost,
+					MaxConnsPerHost:         resource.MaxConnsPerHost,
+					IdleConnTimeout:         resource.IdleConnTimeout,
+					MaxConnLifetime:         resource.MaxConnLifetime,
+					ResponseTimeout:         resource.ResponseTimeout,
+					Streaming:               resource.Streaming,
+					Script:                  resource.Script,
+					AccessExpression:        resource.AccessExpression,
+					DebugCapture:            resource.DebugCapture,
+					StripResponseHeaders:    append([]string{}, resource.StripResponseHeaders...),
+					OverrideResponseHeaders: resource.OverrideResponseHeaders,
+					ResponseCookieDomain:    resource.ResponseCookieDomain,
+					ResponseBodyRewrites:    append([]*BodyRewrite{}, resource.ResponseBodyRewrites...),
+					StaticDirectory:         resource.StaticDirectory,
+					StaticFallback:          resource.StaticFallback,
+					StaticMaxAge:            resource.StaticMaxAge,
+					FaultInjection:          resource.FaultInjection,
+					OPAURL:                  resource.OPAURL,
+					UMAResource:             resource.UMAResource,
+					ClientCertIdentities:    append([]string{}, resource.ClientCertIdentities...),
+					Decompress:              resource.Decompress,
+				}
+				newResources = append(newResources, res)
+			}
+		} else {
+			newResources = append(newResources, resource)
+		}
+	}
+	r.Resources = newResources
+
+	// check for duplicate uris in resources
+	uris := make(map[string]struct{}, len(r.Resources))
+	for _, resource := range r.Resources {
+		if _, ok := uris[resource.URL]; !ok {
+			uris[resource.URL] = struct{}{}
+		} else {
+			return errors.New("a duplicate entry in resource URIs has been found")
+		}
+		if err := isCorsOriginsValid(resource.CorsOrigins, r.CorsCredentials); err != nil {
+			return fmt.Errorf("%v, on resource %s", err, resource.URL)
+		}
+		if resource.hasCors() && len(resource.CorsOrigins) == 0 && len(r.CorsOrigins) == 0 {
+			return fmt.Errorf("CORS overrides on resource %s require cors origins, either on the resource or globally", resource.URL)
+		}
+		if resource.FaultInjection != nil && !r.EnableFaultInjection {
+			return fmt.Errorf("the resource %s injects faults, but enable-fault-injection is not set", resource.URL)
+		}
+		if resource.URL == allRoutes && r.EnableDefaultDeny && resource.WhiteListed {
+			return errors.New("you've asked for a default denial (EnableDefaultDeny is true by default) but whitelisted everything")
+		}
+	}
+
+	// step: validate the claims are valid regex's or numeric comparisons
+	if _, err := newClaimMatchers(r.MatchClaims); err != nil {
+		return err
+	}
+
+	for _, host := range r.AllowedHosts {
+		if err := isAllowedHostValid(host); err != nil {
+			return err
+		}
+	}
+
+	// step: validity checks for redirection allowlists
+	for _, host := range r.AllowedRedirectHosts {
+		if host == "" || strings.ContainsAny(host, "/:") {
+			return fmt.Errorf("invalid allowed redirect host: %q. Expect a hostname, e.g. app.example.com or *.example.com", host)
+		}
+		if strings.Contains(strings.TrimPrefix(host, "*."), wildcard) {
+			return fmt.Errorf("invalid allowed redirect host: %q. Wildcards are only supported as a subdomain prefix, e.g. *.example.com", host)
+		}
+	}
+	for _, scheme := range r.AllowedRedirectSchemes {
+		if scheme == "" || strings.ContainsAny(scheme, ":/") {
+			return fmt.Errorf("invalid allowed redirect scheme: %q. Expect a scheme, e.g. https", scheme)
+		}
+	}
+
+	// step: a session shared across subdomains requires the cookies to be scoped to the parent domain
+	if r.EnableCrossSubdomainSession && r.CookieDomain == "" {
+		return errors.New("flag EnableCrossSubdomainSession requires CookieDomain to be set to the parent domain")
+	}
+
+	// step: only the oauth endpoints may be disabled
+	for _, x := range r.DisabledEndpoints {
+		switch trailer + strings.Trim(x, trailer) {
+		case authorizationURL, callbackURL, csrfURL, expiredURL, loginURL, logoutURL, refreshURL, silentURL, tokenURL:
+		default:
+			return fmt.Errorf("invalid disabled endpoint: %q. Expect one of: %s", x,
+				strings.Join([]string{authorizationURL, callbackURL, csrfURL, expiredURL, loginURL, logoutURL, refreshURL, silentURL, tokenURL}, ", "))
+		}
+	}
+
+	// step: validity checks for the authorization response
+	switch r.ResponseMode {
+	case "", responseModeQuery, responseModeFormPost:
+	default:
+		return fmt.Errorf("invalid response mode: %q. Expect one of: %s, %s", r.ResponseMode, responseModeQuery, responseModeFormPost)
+	}
+	if r.ResponseMode == responseModeFormPost && !r.SecureCookie {
+		return errors.New("the form_post response mode requires secure cookies, the state being sent along a cross-site post")
+	}
+
+	// step: validity checks for logout revocation
+	switch r.RevocationMode {
+	case "", revocationModeEndSession, revocationModeRevoke, revocationModeBoth, revocationModeNone:
+	default:
+		return fmt.Errorf("invalid revocation mode: %q. Expect one of: %s, %s, %s, %s",
+			r.RevocationMode, revocationModeEndSession, revocationModeRevoke, revocationModeBoth, revocationModeNone)
+	}
+
+	// step: validity checks for logout notifications
+	for _, hook := range r.LogoutWebhooks {
+		if u, err := url.Parse(hook); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("logout webhook is not a valid URL: %s", hook)
+		}
+	}
+
+	if r.EnableStrictState {
+		if len(r.EncryptionKey) != 16 && len(r.EncryptionKey) != 32 {
+			return errors.New("flag EnableStrictState requires an encryption key of 16 or 32 characters")
+		}
+		if r.StateExpiration <= 0 {
+			return errors.New("state-expiration must be a positive duration")
+		}
+	}
+
+	if r.EnableWellKnown {
+		if r.SkipTokenVerification {
+			return errors.New("the well-known endpoints cannot be served when skipping the token verification")
+		}
+		if r.WellKnownCacheDuration <= 0 {
+			return errors.New("well-known-cache-duration must be a positive duration")
+		}
+	}
+
+	// step: validity checks for CSRF options
+	if r.EnableCSRF {
+		if r.EncryptionKey == "" && r.CSRFMode != csrfModeStore {
+			return fmt.Errorf("flag EnableCSRF requires EncryptionKey to be set")
+		}
+		var found bool
+		for _, resource := range r.Resources {
+			if resource.EnableCSRF {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("flag EnableCSRF is set but no protected resource sets EnableCSRF")
+		}
+		switch r.CSRFMode {
+		case "", csrfModeSession:
+		case csrfModeDoubleSubmit, csrfModeStore:
+			if r.CSRFTokenDuration <= 0 {
+				return fmt.Errorf("the %s CSRF mode requires a positive CSRFTokenDuration", r.CSRFMode)
+			}
+			if r.CSRFMode == csrfModeStore && r.StoreURL == "" {
+				return fmt.Errorf("the store CSRF mode requires a StoreURL")
+			}
+		default:
+			return fmt.Errorf("invalid CSRF mode: %q. Expect one of: %s, %s, %s", r.CSRFMode, csrfModeSession, csrfModeDoubleSubmit, csrfModeStore)
+		}
+	}
+	return nil
+}
+
+func (r *Config) isDiscoveryValid() error {
+	if r.DiscoveryURL == "" {
+		return errors.New("you have not specified the discovery url")
+	}
+	if u, err := url.Parse(r.DiscoveryURL); err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("discovery url is not a valid URL: %s", r.DiscoveryURL)
+	}
+	if err := isEgressProxyValid("openid-provider-proxy", r.OpenIDProviderProxy); err != nil {
+		return err
+	}
+	return nil
+}
+
+// isIntrospectionValid validates the introspection of the access tokens
+func (r *Config) isIntrospectionValid() error {
+	if !r.EnableTokenIntrospection {
+		return nil
+	}
+	if r.ClientSecret == "" {
+		return errors.New("the token introspection is authenticated as the client, the client secret must be set")
+	}
+	if r.IntrospectionEndpoint != "" {
+		if u, err := url.Parse(r.IntrospectionEndpoint); err != nil || u.Host == "" {
+			return fmt.Errorf("the introspection url is not a valid URL: %s", r.IntrospectionEndpoint)
+		}
+	}
+	if r.IntrospectionCacheDuration < 0 {
+		return errors.New("the introspection cache duration must be a positive duration")
+	}
+
+	return nil
+}
+
+// isUpstreamTokenCookieValid validates the cookie carrying the access token to the upstream
+func (r *Config) isUpstreamTokenCookieValid() error {
+	if r.UpstreamTokenCookie == "" {
+		if r.UpstreamTokenCookieKey != "" {
+			return errors.New("the upstream token cookie key requires the upstream token cookie")
+		}
+		return nil
+	}
+	if (&http.Cookie{Name: r.UpstreamTokenCookie}).String() == "" {
+		return fmt.Errorf("the upstream token cookie is not a valid cookie name: %s", r.UpstreamTokenCookie)
+	}
+	if r.UpstreamTokenCookie == r.CookieAccessName || r.UpstreamTokenCookie == r.CookieRefreshName {
+		return errors.New("the upstream token cookie must differ from the access and refresh cookies")
+	}
+	if r.UpstreamTokenCookieKey != "" && len(r.UpstreamTokenCookieKey) != 16 && len(r.UpstreamTokenCookieKey) != 32 {
+		return fmt.Errorf("the upstream token cookie key (%d) must be either 16 or 32 characters for AES-128/AES-256 selection", len(r.UpstreamTokenCookieKey))
+	}
+
+	return nil
+}
+
+func (r *Config) isTokenConfigValid() error {
+	if r.ClientID == "" {
+		return errors.New("you have not specified the client id")
+	}
+	if err := r.isDiscoveryValid(); err != nil {
+		return err
+	}
+	if strings.HasSuffix(r.RedirectionURL, "/") {
+		r.RedirectionURL = strings.TrimSuffix(r.RedirectionURL, "/")
+	}
+	if r.RedirectionURL != "" {
+		if _, err := url.Parse(r.RedirectionURL); err != nil {
+			return fmt.Errorf("redirection url is not a valid URL: %s", r.RedirectionURL)
+		}
+	}
+	if !r.EnableSecurityFilter {
+		if r.EnableHTTPSRedirect {
+			return errors.New("the security filter must be switched on for this feature: http-redirect")
+		}
+		if r.EnableBrowserXSSFilter {
+			return errors.New("the security filter must be switched on for this feature: brower-xss-filter")
+		}
+		if r.EnableFrameDeny {
+			return errors.New("the security filter must be switched on for this feature: frame-deny-filter")
+		}
+		if r.ContentSecurityPolicy != "" {
+			return errors.New("the security filter must be switched on for this feature: content-security-policy")
+		}
+		if len(r.Hostnames) > 0 {
+			return errors.New("the security filter must be switched on for this feature: hostnames")
+		}
+	}
+
+	if err := r.isIntrospectionValid(); err != nil {
+		return err
+	}
+
+	if (r.EnableEncryptedToken || r.ForceEncryptedCookie) && r.EncryptionKey == "" {
+		return errors.New("you have not specified an encryption key for encoding the access token")
+	}
+	if r.EnableRefreshTokens && r.EncryptionKey == "" {
+		return errors.New("you have not specified an encryption key for encoding the session state")
+	}
+	if r.EnableRefreshTokens && (len(r.EncryptionKey) != 16 && len(r.EncryptionKey) != 32) {
+		return fmt.Errorf("the encryption key (%d) must be either 16 or 32 characters for AES-128/AES-256 selection", len(r.EncryptionKey))
+	}
+	if r.RefreshTokenCookieKey != "" && len(r.RefreshTokenCookieKey) != 16 && len(r.RefreshTokenCookieKey) != 32 {
+		return fmt.Errorf("the refresh token cookie key (%d) must be either 16 or 32 characters for AES-128/AES-256 selection", len(r.RefreshTokenCookieKey))
+	}
+	if r.RefreshTokenCookieDuration < 0 {
+		return errors.New("the refresh token cookie duration must be a positive duration")
+	}
+	if !r.NoRedirects && r.SecureCookie && r.RedirectionURL != "" && !strings.HasPrefix(r.RedirectionURL, "https") {
+		return errors.New("the cookie is set to secure but your redirection url is non-tls")
+	}
+
+	if err := r.isStoreValid(); err != nil {
+		return err
+	}
+	if r.EnableBackChannelLogout && r.StoreURL == "" {
+		return errors.New("the back-channel logout requires a StoreURL")
+	}
+	if r.EnableServerSideTokens {
+		if r.StoreURL == "" {
+			return errors.New("the server-side tokens require a StoreURL")
+		}
+		if len(r.EncryptionKey) != 16 && len(r.EncryptionKey) != 32 {
+			return errors.New("the server-side tokens require an encryption key of 16 or 32 characters")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,1078 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"crypto/tls"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewDefaultConfig(t *testing.T) {
+	if config := newDefaultConfig(); config == nil {
+		t.Error("we should have received a config")
+	}
+}
+
+func TestIsConfigValid(t *testing.T) {
+	tests := []struct {
+		Name          string
+		Config        *Config
+		Ok            bool
+		Error         string
+		ExtraAsserter func(testing.TB, Config)
+	}{
+		{
+			Name:   "empty config",
+			Config: &Config{},
+			Error:  "you have not specified the listening interface",
+		},
+		{
+			Name: "admin socket permissions without a unix socket",
+			Config: &Config{
+				Listen:                ":8080",
+				ListenAdmin:           ":8081",
+				ListenAdminSocketMode: "0660",
+			},
+			Error: "the permissions of the admin socket require a unix socket admin listener (listen-admin)",
+		},
+		{
+			Name: "invalid admin socket mode",
+			Config: &Config{
+				Listen:                ":8080",
+				ListenAdmin:           "unix:///run/gatekeeper/admin.sock",
+				ListenAdminSocketMode: "0999",
+			},
+			Error: "listen-admin-socket-mode must be an octal file mode, e.g. 0660",
+		},
+		{
+			Name: "missing client ID",
+			Config: &Config{
+				Listen:                ":8080",
+				MaxIdleConns:          10,
+				SkipUpstreamTLSVerify: true,
+			},
+			Error: "you have not specified the client id",
+		},
+		{
+			Name: "wrong discovery URL",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "wrong",
+				MaxIdleConns:          10,
+				ClientID:              "client",
+				SkipUpstreamTLSVerify: true,
+			},
+			Error: "discovery url is not a valid URL",
+		},
+		{
+			Name: "wrong idle ",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				MaxIdleConns:          -10,
+				SkipUpstreamTLSVerify: true,
+			},
+			Error: "max-idle-connections must be a number > 0",
+		},
+		{
+			Name: "wrong missing CA",
+			Config: &Config{
+				Listen:       ":8080",
+				DiscoveryURL: "http://127.0.0.1:8080",
+				ClientID:     "client",
+				ClientSecret: "client",
+				MaxIdleConns: 10,
+			},
+			Error: "you cannot require to check upstream tls and omit to specify the root ca to verify it",
+		},
+		{
+			Name: "wrong invalid upstream",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "http://120.0.0.1",
+				MaxIdleConns:          10,
+				SkipUpstreamTLSVerify: true,
+				Upstream:              "httpxxyz@:xxx/nowhere",
+			},
+			Error: "upstream endpoint is invalid",
+		},
+		{
+			Name: "missing discovery url",
+			Config: &Config{
+				Listen:         ":8080",
+				ClientID:       "client",
+				ClientSecret:   "client",
+				RedirectionURL: "http://120.0.0.1",
+				Upstream:       "http://120.0.0.1",
+				UpstreamCA:     "someCA",
+				MaxIdleConns:   10,
+			},
+			Error: "you have not specified the discovery url",
+		},
+		{
+			Name: "max-idle-connections > 0",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "http://120.0.0.1",
+				SkipUpstreamTLSVerify: false,
+				Upstream:              "http://120.0.0.1",
+				UpstreamCA:            "someCA",
+				MaxIdleConns:          0,
+				MaxIdleConnsPerHost:   0,
+			},
+			Error: "max-idle-connections must be a number > 0",
+		},
+		{
+			Name: "max-idle vs max-ide per host",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "http://120.0.0.1",
+				Upstream:              "http://120.0.0.1",
+				SkipUpstreamTLSVerify: false,
+				UpstreamCA:            "someCA",
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   200,
+			},
+			Error: "maxi-idle-connections-per-host must be a number > 0 and <= max-idle-connections",
+		},
+		{
+			Name: "invalid claim matcher",
+			Config: &Config{
+				Listen:         ":8080",
+				DiscoveryURL:   "http://127.0.0.1:8080",
+				ClientID:       "client",
+				ClientSecret:   "client",
+				RedirectionURL: "http://120.0.0.1",
+				Upstream:       "http://120.0.0.1",
+				UpstreamCA:     "someCA",
+				MatchClaims: map[string]string{
+					"test": "&&&[",
+				},
+				SkipUpstreamTLSVerify: true,
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+			},
+			Error: "is not a valid regex",
+		},
+		{
+			Name: "invalid CSFR flag",
+			Config: &Config{
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				Listen:                ":8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "http://120.0.0.1",
+				SkipUpstreamTLSVerify: true,
+				Upstream:              "http://120.0.0.1",
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+				EnableCSRF:            true,
+			},
+			Error: "flag EnableCSRF requires EncryptionKey to be set",
+		},
+		{
+			Name: "invalid CSRF flag (2)",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "http://120.0.0.1",
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+				SkipUpstreamTLSVerify: true,
+				Upstream:              "http://120.0.0.1",
+				EnableCSRF:            true,
+				EncryptionKey:         "xx",
+				Resources: []*Resource{
+					{
+						URL: "http://localhost/there",
+					},
+				},
+			},
+			Error: "EnableCSRF is set but no protected resource sets EnableCSRF",
+		},
+		{
+			Name: "invalid upstream override",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "http://120.0.0.1",
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+				SkipUpstreamTLSVerify: true,
+				EnableCSRF:            true,
+				EncryptionKey:         "xx",
+				Resources: []*Resource{
+					{
+						URL: "http://localhost/there",
+					},
+				},
+			},
+			Error: "you did not set any default upstream and you have not specified an upstream endpoint to proxy to on resource",
+		},
+		{
+			Name: "invalid TLS",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "http://120.0.0.1",
+				SkipUpstreamTLSVerify: false,
+				Upstream:              "this is a valid URL!!",
+				UpstreamCA:            "ca.crt",
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+				TLSPrivateKey:         "key.crt",
+			},
+			Error: "you have not provided a certificate file",
+		},
+		{
+			Name: "invalid upstream",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "https://120.0.0.1",
+				SkipUpstreamTLSVerify: true,
+				Upstream:              "ht@:this should fail",
+				SecureCookie:          true,
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+			},
+			Error: "the upstream endpoint is invalid",
+		},
+		{
+			Name: "invalid uri",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "https://120.0.0.1",
+				SkipUpstreamTLSVerify: true,
+				Upstream:              "https://upstream:",
+				SecureCookie:          true,
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+				Resources: []*Resource{
+					{
+						URL:  "/nowhere",
+						URLs: []string{"/somewhere"},
+					},
+				},
+			},
+			Error: "can't specify both uri and uris",
+		},
+		{
+			Name: "duplicate resources",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "https://120.0.0.1",
+				SkipUpstreamTLSVerify: true,
+				Upstream:              "this should not fail",
+				SecureCookie:          true,
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+				Resources: []*Resource{
+					{
+						URLs: []string{"/somewhere", "/everywhere"},
+					},
+					{
+						URL: "/somewhere",
+					},
+				},
+			},
+			Error: "a duplicate entry in resource URIs has been found",
+		},
+		{
+			Name: "happy path",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "http://120.0.0.1",
+				SkipUpstreamTLSVerify: false,
+				Upstream:              "http://120.0.0.1",
+				UpstreamCA:            "someCA",
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+			},
+			Ok: true,
+		},
+		{
+			Name: "happy path",
+			Config: &Config{
+				Listen:                ":8080",
+				SkipTokenVerification: true,
+				Upstream:              "http://120.0.0.1",
+				UpstreamCA:            "someCA",
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+			},
+			Ok: true,
+		},
+		{
+			Name: "happy path",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "https://120.0.0.1",
+				SkipUpstreamTLSVerify: true,
+				Upstream:              "this should not fail",
+				SecureCookie:          true,
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+			},
+			Ok: true,
+		},
+		{
+			Name: "happy path",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "https://120.0.0.1",
+				SkipUpstreamTLSVerify: true,
+				Upstream:              "this should not fail",
+				SecureCookie:          true,
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+				Resources: []*Resource{
+					{
+						URLs: []string{"/somewhere", "/everywhere"},
+					},
+				},
+			},
+			Ok: true,
+			ExtraAsserter: func(t testing.TB, config Config) {
+				assert.Len(t, config.Resources, 2)
+			},
+		},
+		{
+			Name: "invalid allowed redirect host",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "https://120.0.0.1",
+				SkipUpstreamTLSVerify: true,
+				Upstream:              "http://120.0.0.1",
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+				AllowedRedirectHosts:  []string{"https://app.example.com"},
+			},
+			Error: "invalid allowed redirect host",
+		},
+		{
+			Name: "invalid wildcard in allowed redirect host",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "https://120.0.0.1",
+				SkipUpstreamTLSVerify: true,
+				Upstream:              "http://120.0.0.1",
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+				AllowedRedirectHosts:  []string{"app.*.example.com"},
+			},
+			Error: "Wildcards are only supported as a subdomain prefix",
+		},
+		{
+			Name: "invalid logout webhook",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "https://120.0.0.1",
+				SkipUpstreamTLSVerify: true,
+				Upstream:              "http://120.0.0.1",
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+				LogoutWebhooks:        []string{"/logout"},
+			},
+			Error: "logout webhook is not a valid URL",
+		},
+		{
+			Name: "invalid revocation mode",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "https://120.0.0.1",
+				SkipUpstreamTLSVerify: true,
+				Upstream:              "http://120.0.0.1",
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+				RevocationMode:        "logout",
+			},
+			Error: "invalid revocation mode",
+		},
+		{
+			Name: "invalid response mode",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "https://120.0.0.1",
+				SkipUpstreamTLSVerify: true,
+				Upstream:              "http://120.0.0.1",
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+				ResponseMode:          "fragment",
+			},
+			Error: "invalid response mode",
+		},
+		{
+			Name: "form_post response mode without secure cookies",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "https://120.0.0.1",
+				SkipUpstreamTLSVerify: true,
+				Upstream:              "http://120.0.0.1",
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+				ResponseMode:          responseModeFormPost,
+			},
+			Error: "the form_post response mode requires secure cookies",
+		},
+		{
+			Name: "fault injection without enable-fault-injection",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "https://120.0.0.1",
+				SkipUpstreamTLSVerify: true,
+				Upstream:              "http://120.0.0.1",
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+				Resources:             []*Resource{{URL: "/*", FaultInjection: &FaultInjection{ErrorPercent: 10}}},
+			},
+			Error: "enable-fault-injection is not set",
+		},
+		{
+			Name: "byte quota without identity accounting",
+			Config: &Config{
+				Listen:                 ":8080",
+				DiscoveryURL:           "http://127.0.0.1:8080",
+				ClientID:               "client",
+				ClientSecret:           "client",
+				RedirectionURL:         "https://120.0.0.1",
+				SkipUpstreamTLSVerify:  true,
+				Upstream:               "http://120.0.0.1",
+				MaxIdleConns:           100,
+				MaxIdleConnsPerHost:    50,
+				IdentityDailyByteQuota: 1 << 30,
+			},
+			Error: "identity-daily-byte-quota requires enable-identity-accounting",
+		},
+		{
+			Name: "separate IPv6 interface with an IPv4 address",
+			Config: &Config{
+				Listen:                "0.0.0.0:8080",
+				ListenIPv6:            "127.0.0.1:8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "https://120.0.0.1",
+				SkipUpstreamTLSVerify: true,
+				Upstream:              "http://120.0.0.1",
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+			},
+			Error: "is not an IPv6 address",
+		},
+		{
+			Name: "separate IPv6 interface on IPv6 only",
+			Config: &Config{
+				Listen:                "0.0.0.0:8080",
+				ListenIPv6:            "[::]:8080",
+				ListenIPv6Only:        true,
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "https://120.0.0.1",
+				SkipUpstreamTLSVerify: true,
+				Upstream:              "http://120.0.0.1",
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+			},
+			Error: "are exclusive",
+		},
+		{
+			Name: "invalid opa url",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "https://120.0.0.1",
+				SkipUpstreamTLSVerify: true,
+				Upstream:              "http://120.0.0.1",
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+				OPAURL:                "localhost:8181/v1/data/gatekeeper",
+			},
+			Error: "is not a valid http url",
+		},
+		{
+			Name: "uma with another provider",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "https://120.0.0.1",
+				SkipUpstreamTLSVerify: true,
+				Upstream:              "http://120.0.0.1",
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+				Provider:              "okta",
+				EnableUMA:             true,
+			},
+			Error: "enable-uma requires the keycloak authorization services",
+		},
+		{
+			Name: "invalid upstream balancing",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "https://120.0.0.1",
+				SkipUpstreamTLSVerify: true,
+				Upstream:              "http://120.0.0.1",
+				UpstreamURLs:          []string{"http://120.0.0.2"},
+				UpstreamBalancing:     "random",
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+			},
+			Error: "invalid upstream balancing",
+		},
+		{
+			Name: "invalid upstream proxy",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "https://120.0.0.1",
+				SkipUpstreamTLSVerify: true,
+				Upstream:              "http://120.0.0.1",
+				UpstreamProxy:         "egress:3128",
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+			},
+			Error: "upstream-proxy",
+		},
+		{
+			Name: "dns resolver without port",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "https://120.0.0.1",
+				SkipUpstreamTLSVerify: true,
+				Upstream:              "http://120.0.0.1",
+				DNSResolver:           "10.0.0.10",
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+			},
+			Error: "the dns-resolver must be an address with a port",
+		},
+		{
+			Name: "h2c to a https upstream",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "https://120.0.0.1",
+				SkipUpstreamTLSVerify: true,
+				Upstream:              "https://120.0.0.1",
+				UpstreamH2C:           true,
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+			},
+			Error: "upstream-h2c speaks HTTP/2 without TLS",
+		},
+		{
+			Name: "wildcard CORS origin with credentials",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "https://120.0.0.1",
+				SkipUpstreamTLSVerify: true,
+				Upstream:              "http://120.0.0.1",
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+				CorsOrigins:           []string{"*"},
+				CorsCredentials:       true,
+			},
+			Error: "cannot be used with cors credentials",
+		},
+		{
+			Name: "resource CORS overrides without origins",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "https://120.0.0.1",
+				SkipUpstreamTLSVerify: true,
+				Upstream:              "http://120.0.0.1",
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+				Resources:             []*Resource{{URL: "/*", CorsMethods: []string{"GET"}}},
+			},
+			Error: "require cors origins",
+		},
+		{
+			Name: "invalid CSRF mode",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "https://120.0.0.1",
+				SkipUpstreamTLSVerify: true,
+				Upstream:              "http://120.0.0.1",
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+				EnableCSRF:            true,
+				EncryptionKey:         testKey,
+				Resources:             []*Resource{{URL: "/*", EnableCSRF: true}},
+				CSRFMode:              "cookie",
+			},
+			Error: "invalid CSRF mode",
+		},
+		{
+			Name: "negative profiling rate limit",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "https://120.0.0.1",
+				SkipUpstreamTLSVerify: true,
+				Upstream:              "http://120.0.0.1",
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+				EnableProfiling:       true,
+				ProfilingRateLimit:    -1,
+			},
+			Error: "profiling-rate-limit",
+		},
+		{
+			Name: "admin api without admin listener",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "https://120.0.0.1",
+				SkipUpstreamTLSVerify: true,
+				Upstream:              "http://120.0.0.1",
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+				EnableAdminAPI:        true,
+			},
+			Error: "admin listener",
+		},
+		{
+			Name: "cross subdomain session without cookie domain",
+			Config: &Config{
+				Listen:                      ":8080",
+				DiscoveryURL:                "http://127.0.0.1:8080",
+				ClientID:                    "client",
+				ClientSecret:                "client",
+				RedirectionURL:              "https://120.0.0.1",
+				SkipUpstreamTLSVerify:       true,
+				Upstream:                    "http://120.0.0.1",
+				MaxIdleConns:                100,
+				MaxIdleConnsPerHost:         50,
+				EnableCrossSubdomainSession: true,
+			},
+			Error: "requires CookieDomain",
+		},
+		{
+			Name: "invalid disabled endpoint",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "https://120.0.0.1",
+				SkipUpstreamTLSVerify: true,
+				Upstream:              "http://120.0.0.1",
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+				DisabledEndpoints:     []string{"health"},
+			},
+			Error: "invalid disabled endpoint",
+		},
+		{
+			Name: "generic oidc provider",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "https://120.0.0.1",
+				SkipUpstreamTLSVerify: true,
+				Upstream:              "http://120.0.0.1",
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+				Provider:              providerAzureAD,
+			},
+			Ok: true,
+		},
+		{
+			Name: "unknown provider",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "https://120.0.0.1",
+				SkipUpstreamTLSVerify: true,
+				Upstream:              "http://120.0.0.1",
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+				Provider:              "unknown",
+			},
+			Error: "unknown provider",
+		},
+		{
+			Name: "allowed tenants of a single tenant provider",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "https://120.0.0.1",
+				SkipUpstreamTLSVerify: true,
+				Upstream:              "http://120.0.0.1",
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+				Provider:              "okta",
+				AllowedTenants:        []string{"acme"},
+			},
+			Error: "the allowed tenants are only supported by the provider azure-ad",
+		},
+		{
+			Name: "negative provider retry after",
+			Config: &Config{
+				Listen:                   ":8080",
+				DiscoveryURL:             "http://127.0.0.1:8080",
+				ClientID:                 "client",
+				ClientSecret:             "client",
+				RedirectionURL:           "https://120.0.0.1",
+				SkipUpstreamTLSVerify:    true,
+				Upstream:                 "http://120.0.0.1",
+				MaxIdleConns:             100,
+				MaxIdleConnsPerHost:      50,
+				OpenIDProviderRetryAfter: -time.Second,
+			},
+			Error: "openid-provider-retry-after must be a positive duration",
+		},
+		{
+			Name: "strict state without encryption key",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "https://120.0.0.1",
+				SkipUpstreamTLSVerify: true,
+				Upstream:              "http://120.0.0.1",
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+				EnableStrictState:     true,
+				StateExpiration:       time.Minute,
+			},
+			Error: "flag EnableStrictState requires an encryption key",
+		},
+		{
+			Name: "strict state without expiration",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "https://120.0.0.1",
+				SkipUpstreamTLSVerify: true,
+				Upstream:              "http://120.0.0.1",
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+				EnableStrictState:     true,
+				EncryptionKey:         "ZSeCYDUxIlhDrmPpa1Ldc7il384esSF2",
+			},
+			Error: "state-expiration must be a positive duration",
+		},
+		{
+			Name: "external url",
+			Ok:   true,
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				ExternalURL:           "https://example.com/myapp/",
+				SkipUpstreamTLSVerify: true,
+				Upstream:              "http://120.0.0.1",
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+			},
+		},
+		{
+			Name: "relative external url",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				ExternalURL:           "/myapp",
+				SkipUpstreamTLSVerify: true,
+				Upstream:              "http://120.0.0.1",
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+			},
+			Error: "the external url must be an absolute http or https url, without query or fragment",
+		},
+		{
+			Name: "external url and another base uri",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				ExternalURL:           "https://example.com/myapp",
+				BaseURI:               "/other",
+				SkipUpstreamTLSVerify: true,
+				Upstream:              "http://120.0.0.1",
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+			},
+			Error: "the base uri differs from the path of the external url",
+		},
+		{
+			Name: "well-known without token verification",
+			Config: &Config{
+				Listen:                 ":8080",
+				DiscoveryURL:           "http://127.0.0.1:8080",
+				ClientID:               "client",
+				ClientSecret:           "client",
+				RedirectionURL:         "https://120.0.0.1",
+				SkipUpstreamTLSVerify:  true,
+				SkipTokenVerification:  true,
+				Upstream:               "http://120.0.0.1",
+				MaxIdleConns:           100,
+				MaxIdleConnsPerHost:    50,
+				EnableWellKnown:        true,
+				WellKnownCacheDuration: time.Minute,
+			},
+			Error: "the well-known endpoints cannot be served when skipping the token verification",
+		},
+		{
+			Name: "stored CSRF without store",
+			Config: &Config{
+				Listen:                ":8080",
+				DiscoveryURL:          "http://127.0.0.1:8080",
+				ClientID:              "client",
+				ClientSecret:          "client",
+				RedirectionURL:        "https://120.0.0.1",
+				SkipUpstreamTLSVerify: true,
+				Upstream:              "http://120.0.0.1",
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   50,
+				EnableCSRF:            true,
+				CSRFMode:              csrfModeStore,
+				CSRFTokenDuration:     time.Hour,
+				Resources:             []*Resource{{URL: "/*", Methods: allHTTPMethods, EnableCSRF: true}},
+			},
+			Error: "the store CSRF mode requires a StoreURL",
+		},
+		{
+			Name: "back-channel logout without store",
+			Config: &Config{
+				Listen:                  ":8080",
+				DiscoveryURL:            "http://127.0.0.1:8080",
+				ClientID:                "client",
+				ClientSecret:            "client",
+				RedirectionURL:          "https://120.0.0.1",
+				SkipUpstreamTLSVerify:   true,
+				Upstream:                "http://120.0.0.1",
+				MaxIdleConns:            100,
+				MaxIdleConnsPerHost:     50,
+				EnableBackChannelLogout: true,
+			},
+			Error: "the back-channel logout requires a StoreURL",
+		},
+		{
+			Name: "forwarding without provider",
+			Config: &Config{
+				Listen:                     ":8080",
+				DiscoveryURL:               "http://127.0.0.1:8080",
+				ClientID:                   "client",
+				ClientSecret:               "client",
+				EnableForwarding:           true,
+				EnableStartWithoutProvider: true,
+				MaxIdleConns:               100,
+				MaxIdleConnsPerHost:        50,
+			},
+			Error: "the forwarding proxy cannot start without the openid provider",
+		},
+	}
+
+	for i, c := range tests {
+		err := c.Config.isValid()
+		if c.Ok {
+			assert.NoErrorf(t, err, "test case %d (%q), the config should not have errored, error: %v", i, c.Name, err)
+		} else {
+			assert.Errorf(t, err, "test case %d (%q), the config should have errored", i, c.Name)
+			t.Logf("test case %d (%q), got error: %v", i, c.Name, err)
+			if c.Error != "" && err != nil {
+				assert.Contains(t, err.Error(), c.Error)
+			}
+		}
+	}
+}
+
+func TestIsAllowedRedirect(t *testing.T) {
+	config := &Config{}
+	assert.True(t, config.isAllowedRedirect("/admin", "app.example.com"))
+	assert.True(t, config.isAllowedRedirect("https://app.example.com/admin", "app.example.com"))
+	assert.True(t, config.isAllowedRedirect("//app.example.com:8443/admin", "app.example.com:8443"))
+	assert.False(t, config.isAllowedRedirect("https://anywhere.example.com/admin", "app.example.com"))
+	assert.False(t, config.isAllowedRedirect("https://evil.com", "app.example.com"))
+	assert.False(t, config.isAllowedRedirect("//evil.com", "app.example.com"))
+	assert.False(t, config.isAllowedRedirect("https://evil.com", ""))
+	assert.False(t, config.isAllowedRedirect("javascript:alert(1)", "app.example.com"))
+	assert.False(t, config.isAllowedRedirect("ftp://anywhere.example.com", "app.example.com"))
+	assert.False(t, config.isAllowedRedirect("/\\evil.com", "app.example.com"))
+
+	config.RedirectionURL = "https://gatekeeper.example.com"
+	assert.True(t, config.isAllowedRedirect("https://gatekeeper.example.com/admin", "app.example.com"))
+	assert.False(t, config.isAllowedRedirect("https://evil.com", "app.example.com"))
+
+	config.AllowedRedirectHosts = []string{"app.example.com", "*.apps.example.com"}
+	assert.True(t, config.isAllowedRedirect("/admin?query=value", "app.example.com"))
+	assert.True(t, config.isAllowedRedirect("https://app.example.com/admin", "app.example.com"))
+	assert.True(t, config.isAllowedRedirect("http://app.example.com:8080/admin", "app.example.com"))
+	assert.True(t, config.isAllowedRedirect("https://one.apps.example.com", "app.example.com"))
+	assert.True(t, config.isAllowedRedirect("//app.example.com/admin", "app.example.com"))
+	assert.False(t, config.isAllowedRedirect("https://evil.com/admin", "app.example.com"))
+	assert.False(t, config.isAllowedRedirect("//evil.com/admin", "app.example.com"))
+	assert.False(t, config.isAllowedRedirect("https://app.example.com.evil.com", "app.example.com"))
+	assert.False(t, config.isAllowedRedirect("https://user@evil.com", "app.example.com"))
+
+	config.AllowedRedirectSchemes = []string{"https"}
+	assert.True(t, config.isAllowedRedirect("https://app.example.com/admin", "app.example.com"))
+	assert.False(t, config.isAllowedRedirect("http://app.example.com/admin", "app.example.com"))
+
+	config.CookieDomain = ".shared.com"
+	assert.False(t, config.isAllowedRedirect("https://app2.shared.com/admin", "app.example.com"))
+	config.EnableCrossSubdomainSession = true
+	assert.True(t, config.isAllowedRedirect("https://app2.shared.com/admin", "app.example.com"))
+	assert.True(t, config.isAllowedRedirect("https://shared.com/admin", "app.example.com"))
+	assert.False(t, config.isAllowedRedirect("https://evilshared.com/admin", "app.example.com"))
+}
+
+func TestParseTLS(t *testing.T) {
+	tlsConfigFixture := tlsAdvancedConfig{
+		tlsPreferServerCipherSuites: true,
+		tlsMinVersion:               "TLS1.1",
+		tlsCipherSuites:             []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+		tlsCurvePreferences:         []string{"P384"},
+	}
+	res, err := parseTLS(&tlsConfigFixture)
+	assert.NoError(t, err)
+	if assert.NotNil(t, res) {
+		assert.Equal(t, &tlsSettings{
+			tlsPreferServerCipherSuites: true,
+			tlsMinVersion:               tls.VersionTLS11,
+			tlsCipherSuites:             []uint16{tls.TLS_FALLBACK_SCSV, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+			tlsCurvePreferences:         []tls.CurveID{tls.CurveP384},
+		}, res)
+	}
+
+	tlsConfigFixture = tlsAdvancedConfig{
+		tlsUseModernSettings: true,
+	}
+	res, err = parseTLS(&tlsConfigFixture)
+	assert.NoError(t, err)
+	if assert.NotNil(t, res) {
+		assert.Equal(t, &tlsSettings{
+			tlsPreferServerCipherSuites: true,
+			tlsMinVersion:               tls.VersionTLS12,
+			tlsCipherSuites: []uint16{
+				tls.TLS_FALLBACK_SCSV,
+				tls.TLS_CHACHA20_POLY1305_SHA256,
+				tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+				tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+				tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_AES_128_GCM_SHA256,
+				tls.TLS_AES_256_GCM_SHA384,
+			},
+			tlsCurvePreferences: []tls.CurveID{
+				tls.CurveP256,
+				tls.X25519,
+			},
+		}, res)
+	}
+}
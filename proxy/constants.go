@@ -0,0 +1,113 @@
+package proxy
+
+type contextKey int8
+
+const (
+	envPrefix = "PROXY_"
+
+	// defaults proxy endpoints
+	authorizationURL = "/authorize"
+	callbackURL      = "/callback"
+	expiredURL       = "/expired"
+	healthURL        = "/health"
+	loginURL         = "/login"
+	logoutURL        = "/logout"
+	metricsURL       = "/metrics"
+	tokenURL         = "/token"
+	debugURL         = "/debug/pprof"
+	refreshURL       = "/refresh"
+	traceURL         = "/trace"
+	silentURL        = "/silent"
+	pushNotBeforeURL = "/k_push_not_before"
+	backChannelURL   = "/backchannel-logout"
+	csrfURL          = "/csrf"
+	adminAPIURL      = "/admin"
+	sessionsURL      = "/sessions"
+	reloadURL        = "/reload"
+	drainURL         = "/drain"
+	faultsURL        = "/faults"
+	jwksURL          = "/jwks"
+	forwardAuthURL   = "/forward-auth"
+	wellKnownURL     = "/.well-known/openid-configuration"
+
+	// default claims used to analyze access token
+	claimAudience        = "aud"
+	claimAuthorizedParty = "azp"
+	claimPreferredName   = "preferred_username"
+	claimSubject         = "sub"
+	claimRealmAccess     = "realm_access"
+	claimResourceAccess  = "resource_access"
+	claimResourceRoles   = "roles"
+	claimGroups          = "groups"
+	claimSessionID       = "sid"
+	claimSessionState    = "session_state"
+
+	// default cookies names
+	accessCookie       = "kc-access"
+	refreshCookie      = "kc-state"
+	requestURICookie   = "request_uri"
+	requestStateCookie = "OAuth_Token_Request_State"
+	// redirectLoopCookie counts the redirections of a client to the authorization endpoint
+	redirectLoopCookie = "kc-redirects"
+
+	// silentStatePrefix marks the state of a silent renewal authorization
+	silentStatePrefix = "silent."
+
+	// CSRF protection modes
+	csrfModeSession      = "session"
+	csrfModeDoubleSubmit = "double-submit"
+	csrfModeStore        = "store"
+
+	// modes of the authorization response
+	responseModeQuery    = "query"
+	responseModeFormPost = "form_post"
+
+	// revocation modes on logout
+	revocationModeEndSession = "end-session"
+	revocationModeRevoke     = "revoke"
+	revocationModeBoth       = "both"
+	revocationModeNone       = "none"
+
+	unsecureScheme = "http"
+	secureScheme   = "https"
+	anyMethod      = "ANY"
+	allRoutes      = "/*"
+
+	// acme challenges
+	acmeTLSALPNChallenge = "tls-alpn-01"
+	acmeHTTPChallenge    = "http-01"
+
+	// verifications of the client certificates
+	clientAuthRequire       = "require"
+	clientAuthVerifyIfGiven = "verify-if-given"
+
+	_ contextKey = iota
+	contextScopeName
+	contextCSRFSkipName
+	contextCSRFTokenName
+	contextResponseRulesName
+
+	jsonMime                  = "application/json; charset=utf-8"
+	headerXForwardedFor       = "X-Forwarded-For"
+	headerXRealIP             = "X-Real-IP"
+	authorizationHeader       = "Authorization"
+	versionHeader             = "X-Auth-Proxy-Version"
+	loginURLHeader            = "X-Auth-Login-Url"
+	headerXContentTypeOptions = "X-Content-Type-Options"
+	headerXXSSProtection      = "X-XSS-Protection"
+	headerXFrameOptions       = "X-Frame-Options"
+	headerXSTS                = "X-Strict-Transport-Security"
+	headerXPolicy             = "X-Content-Security-Policy"
+	headerXMethodOverride     = "X-HTTP-Method-Override"
+	headerXRequestTimeout     = "X-Request-Timeout"
+	headerUpgrade             = "Upgrade"
+	headerRetryAfter          = "Retry-After"
+	headerXRequestID          = "X-Request-ID"
+	headerXForwardedPrefix    = "X-Forwarded-Prefix"
+	headerXClientCertSubject  = "X-Client-Cert-Subject"
+	headerXClientCertSANs     = "X-Client-Cert-Sans"
+	headerXClientCertSHA256   = "X-Client-Cert-Fingerprint"
+	headerXAuthSignature      = "X-Auth-Signature"
+	headerXAuthTimestamp      = "X-Auth-Timestamp"
+	authorizationType         = "Bearer"
+)
@@ -13,7 +13,7 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package main
+package proxy
 
 import (
 	"net/http"
@@ -44,7 +44,7 @@ func (r *oauthProxy) makeCookieDropper() func(string, string, string, time.Durat
 	baseCookie := &http.Cookie{
 		Domain:   r.config.CookieDomain,
 		HttpOnly: r.config.HTTPOnlyCookie,
-		Path:     "/",
+		Path:     r.config.cookiePath(),
 		Secure:   r.config.SecureCookie,
 	}
 
@@ -116,7 +116,7 @@ func (r *oauthProxy) getMaxCookieChunkLength(req *http.Request, cookieName strin
 func (r *oauthProxy) makeCookieChunker() func(string, string) int {
 	// chunkLengthCalculator parses the configuration and delivers a fast calculator:
 	// config is evaluated only once
-	maxCookieChunkLength := baseCookieChunkLength - len("; Path=/")
+	maxCookieChunkLength := baseCookieChunkLength - len("; Path=") - len(r.config.sessionCookiePath())
 	if r.config.HTTPOnlyCookie {
 		maxCookieChunkLength -= len("HttpOnly; ")
 	}
@@ -141,38 +141,90 @@ func (r *oauthProxy) makeCookieChunker() func(string, string) int {
 	}
 }
 
-// dropCookieWithChunks drops a cookie from the response, taking into account possible chunks
+// sessionCookie returns an access or refresh token cookie, with the path of the session cookies
+func (r *oauthProxy) sessionCookie(host, name, value string, duration time.Duration) *http.Cookie {
+	cookie := r.cookieDropper(host, name, value, duration)
+	cookie.Path = r.config.sessionCookiePath()
+	return cookie
+}
+
+// dropCookieWithChunks drops a session cookie from the response, taking into account possible chunks
 func (r *oauthProxy) dropCookieWithChunks(req *http.Request, w http.ResponseWriter, name, value string, duration time.Duration) {
+	r.dropChunks(req, name, value, func(name, value string) {
+		http.SetCookie(w, r.sessionCookie(req.Host, name, value, duration))
+	})
+}
+
+// dropChunks divides a value in as many cookies as needed to stay under the cookie size limit
+func (r *oauthProxy) dropChunks(req *http.Request, name, value string, drop func(name, value string)) {
 	maxCookieChunkLength := r.getMaxCookieChunkLength(req, name)
 	if len(value) <= maxCookieChunkLength {
-		r.dropCookie(w, req.Host, name, value, duration)
+		drop(name, value)
 		return
 	}
 	// write divided cookies because payload is too long for single cookie
-	r.dropCookie(w, req.Host, name, value[0:maxCookieChunkLength], duration)
+	drop(name, value[0:maxCookieChunkLength])
 	for i := maxCookieChunkLength; i < len(value); i += maxCookieChunkLength {
 		end := i + maxCookieChunkLength
 		if end > len(value) {
 			end = len(value)
 		}
-		r.dropCookie(w, req.Host, name+"-"+strconv.Itoa(i/maxCookieChunkLength), value[i:end], duration)
+		drop(name+"-"+strconv.Itoa(i/maxCookieChunkLength), value[i:end])
 	}
 }
 
+// encodeCookieValue encrypts a token to be stored in a cookie, compressing it beforehand unless disabled
+func (r *oauthProxy) encodeCookieValue(value string) (string, error) {
+	return encodeTextWithCompression(value, r.config.EncryptionKey, r.config.EnableCookieCompression)
+}
+
 // dropAccessTokenCookie drops a access token cookie from the response
 func (r *oauthProxy) dropAccessTokenCookie(req *http.Request, w http.ResponseWriter, value string, duration time.Duration) {
 	r.dropCookieWithChunks(req, w, r.config.CookieAccessName, value, duration)
 }
 
-// dropRefreshTokenCookie drops a refresh token cookie from the response
+// encodeRefreshToken encrypts a refresh token with the refresh token cookie key, or the encryption key
+func (r *oauthProxy) encodeRefreshToken(value string) (string, error) {
+	return encodeTextWithCompression(value, r.refreshTokenKey(), r.config.EnableCookieCompression)
+}
+
+// refreshTokenKey returns the key encrypting the refresh tokens
+func (r *oauthProxy) refreshTokenKey() string {
+	return defaultTo(r.config.RefreshTokenCookieKey, r.config.EncryptionKey)
+}
+
+// dropRefreshTokenCookie drops a refresh token cookie from the response. With a refresh token cookie duration, the
+// cookie expires after this duration, even with session cookies, so that the session survives the restarts of the
+// browser and of the service, without a store.
 func (r *oauthProxy) dropRefreshTokenCookie(req *http.Request, w http.ResponseWriter, value string, duration time.Duration) {
-	r.dropCookieWithChunks(req, w, r.config.CookieRefreshName, value, duration)
+	if r.config.RefreshTokenCookieDuration == 0 {
+		r.dropCookieWithChunks(req, w, r.config.CookieRefreshName, value, duration)
+		return
+	}
+	expires := time.Now().Add(r.config.RefreshTokenCookieDuration)
+	r.dropChunks(req, r.config.CookieRefreshName, value, func(name, value string) {
+		cookie := r.sessionCookie(req.Host, name, value, r.config.RefreshTokenCookieDuration)
+		cookie.Expires = expires
+		http.SetCookie(w, cookie)
+	})
+}
+
+// dropCallbackCookie drops a cookie read back by the callback. The authorization responses posted to the callback
+// (form_post response mode) being cross-site requests, the browser only sends them the cookies without same site
+// restriction, which must be secure.
+func (r *oauthProxy) dropCallbackCookie(w http.ResponseWriter, host, name, value string, duration time.Duration) {
+	cookie := r.cookieDropper(host, name, value, duration)
+	if r.config.ResponseMode == responseModeFormPost {
+		cookie.SameSite = http.SameSiteNoneMode
+		cookie.Secure = true
+	}
+	http.SetCookie(w, cookie)
 }
 
 // writeStateParameterCookie sets a state parameter cookie into the response
 func (r *oauthProxy) writeStateParameterCookie(req *http.Request, w http.ResponseWriter) string {
 	uuid := uuid.NewV4().String()
-	r.dropCookie(w, req.Host, requestStateCookie, uuid, 0)
+	r.dropCallbackCookie(w, req.Host, requestStateCookie, uuid, 0)
 	return uuid
 }
 
@@ -185,14 +237,21 @@ func (r *oauthProxy) clearAllCookies(req *http.Request, w http.ResponseWriter) {
 
 // clearRefreshSessionCookie clears the session cookie
 func (r *oauthProxy) clearRefreshTokenCookie(req *http.Request, w http.ResponseWriter) {
-	r.dropCookie(w, req.Host, r.config.CookieRefreshName, "", -10*time.Hour)
-	r.clearDividedCookies(req, w, r.config.CookieRefreshName)
+	r.clearSessionCookie(req, w, r.config.CookieRefreshName)
 }
 
 // clearAccessTokenCookie clears the session cookie
 func (r *oauthProxy) clearAccessTokenCookie(req *http.Request, w http.ResponseWriter) {
-	r.dropCookie(w, req.Host, r.config.CookieAccessName, "", -10*time.Hour)
-	r.clearDividedCookies(req, w, r.config.CookieAccessName)
+	r.clearSessionCookie(req, w, r.config.CookieAccessName)
+}
+
+// clearSessionCookie clears a session cookie and its chunks, on the path of the session cookies
+func (r *oauthProxy) clearSessionCookie(req *http.Request, w http.ResponseWriter, name string) {
+	clear := func(name string) {
+		http.SetCookie(w, r.sessionCookie(req.Host, name, "", -10*time.Hour))
+	}
+	clear(name)
+	r.clearChunks(req, name, clear)
 }
 
 // clearStateCookie clears the session state cookie
@@ -202,13 +261,19 @@ func (r *oauthProxy) clearStateCookie(req *http.Request, w http.ResponseWriter)
 }
 
 func (r *oauthProxy) clearDividedCookies(req *http.Request, w http.ResponseWriter, name string) {
-	// clear divided cookies
+	r.clearChunks(req, name, func(name string) {
+		r.dropCookie(w, req.Host, name, "", -10*time.Hour)
+	})
+}
+
+// clearChunks clears the divided cookies of a value sent by the request
+func (r *oauthProxy) clearChunks(req *http.Request, name string, clear func(name string)) {
 	for i := 1; i < len(req.Cookies()); i++ {
 		var _, err = req.Cookie(name + "-" + strconv.Itoa(i))
 		if err != nil {
 			break
 		}
-		r.dropCookie(w, req.Host, name+"-"+strconv.Itoa(i), "", -10*time.Hour)
+		clear(name + "-" + strconv.Itoa(i))
 	}
 }
 
@@ -217,23 +282,51 @@ func filterCookies(req *http.Request, filter []string) error {
 	// @NOTE: there doesn't appear to be a way of removing a cookie from the http.Request as
 	// AddCookie() just append
 	cookies := req.Cookies()
-	// @step: empty the current cookies
-	req.Header.Set("Cookie", "")
+	header := getBuffer()
+	defer putBuffer(header)
 	// @step: iterate the cookies and filter out anything we
 	for _, x := range cookies {
-		var found bool
+		cookie := x
 		// @step: does this cookie match our filter?
 		for _, n := range filter {
 			if strings.HasPrefix(x.Name, n) {
-				req.AddCookie(&http.Cookie{Name: x.Name, Value: "redacted"})
-				found = true
+				cookie = &http.Cookie{Name: x.Name, Value: "redacted"}
 				break
 			}
 		}
-		if !found {
-			req.AddCookie(x)
+		if header.Len() > 0 {
+			header.WriteString("; ")
 		}
+		header.WriteString(cookie.String())
 	}
+	// @step: replace the current cookies
+	req.Header.Set("Cookie", header.String())
 
 	return nil
 }
+
+// setRequestCookie replaces the cookies of a name in a request with a value, removing them when the value is empty
+func setRequestCookie(req *http.Request, name, value string) {
+	header := getBuffer()
+	defer putBuffer(header)
+	for _, x := range req.Cookies() {
+		if x.Name == name {
+			continue
+		}
+		if header.Len() > 0 {
+			header.WriteString("; ")
+		}
+		header.WriteString(x.String())
+	}
+	if value != "" {
+		if header.Len() > 0 {
+			header.WriteString("; ")
+		}
+		header.WriteString((&http.Cookie{Name: name, Value: value}).String())
+	}
+	if header.Len() == 0 {
+		req.Header.Del("Cookie")
+		return
+	}
+	req.Header.Set("Cookie", header.String())
+}
@@ -13,7 +13,7 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package main
+package proxy
 
 import (
 	"net/http"
@@ -182,6 +182,28 @@ func TestSameSiteCookie(t *testing.T) {
 		"we have not set the cookie, headers: %v", resp.Header())
 }
 
+func TestFormPostCallbackCookies(t *testing.T) {
+	p, _, _ := newTestProxyService(nil)
+	p.config.SameSiteCookie = SameSiteLax
+	p.config.ResponseMode = responseModeFormPost
+	p.cookieDropper = p.makeCookieDropper()
+
+	req := newFakeHTTPRequest("GET", "/admin")
+	resp := httptest.NewRecorder()
+	state := p.writeStateParameterCookie(req, resp)
+
+	assert.Equal(t, resp.Header().Get("Set-Cookie"),
+		requestStateCookie+"="+state+"; Path=/; Domain=127.0.0.1; Secure; SameSite=None",
+		"the state cookie must be sent along the posted callback, headers: %v", resp.Header())
+
+	resp = httptest.NewRecorder()
+	p.dropCookie(resp, req.Host, "test-cookie", "test-value", 0)
+
+	assert.Equal(t, resp.Header().Get("Set-Cookie"),
+		"test-cookie=test-value; Path=/; Domain=127.0.0.1; SameSite=Lax",
+		"the other cookies keep their same site restriction, headers: %v", resp.Header())
+}
+
 func TestHTTPOnlyCookie(t *testing.T) {
 	p, _, _ := newTestProxyService(nil)
 
@@ -226,6 +248,25 @@ func TestClearRefreshAccessTokenCookie(t *testing.T) {
 		"we have not cleared the, headers: %v", resp.Header())
 }
 
+func TestSessionCookiePath(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.CookiePath = "/app"
+	p, _, _ := newTestProxyService(cfg)
+	req := newFakeHTTPRequest("GET", "/app/admin")
+
+	resp := httptest.NewRecorder()
+	p.dropAccessTokenCookie(req, resp, "test", 0)
+	p.writeStateParameterCookie(req, resp)
+	cookies := resp.Header()["Set-Cookie"]
+	require.Len(t, cookies, 2)
+	assert.Equal(t, accessCookie+"=test; Path=/app; Domain=127.0.0.1", cookies[0])
+	assert.Contains(t, cookies[1], "; Path=/; ", "the state cookie is not restricted to the session cookie path")
+
+	resp = httptest.NewRecorder()
+	p.clearRefreshTokenCookie(req, resp)
+	assert.Contains(t, resp.Header().Get("Set-Cookie"), refreshCookie+"=; Path=/app; Domain=127.0.0.1; Expires=")
+}
+
 func TestClearAllCookies(t *testing.T) {
 	p, _, _ := newTestProxyService(nil)
 	req := newFakeHTTPRequest("GET", "/admin")
@@ -263,3 +304,17 @@ func TestGetMaxCookieChunkLength(t *testing.T) {
 	assert.Equal(t, 3998, p.getMaxCookieChunkLength(req, ""),
 		"cookie chunk calculation is not correct")
 }
+
+func TestSetRequestCookie(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Cookie", "a=1; token=forged; b=2; token=again")
+	setRequestCookie(req, "token", "value")
+	assert.Equal(t, "a=1; b=2; token=value", req.Header.Get("Cookie"))
+
+	setRequestCookie(req, "token", "")
+	assert.Equal(t, "a=1; b=2", req.Header.Get("Cookie"))
+
+	req.Header.Set("Cookie", "token=forged")
+	setRequestCookie(req, "token", "")
+	assert.Empty(t, req.Header.Values("Cookie"))
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// corsRegexPrefix marks a CORS origin as a regular expression, e.g. regex:^https://[a-z]+\.example\.com$
+const corsRegexPrefix = "regex:"
+
+// corsSubdomainPattern matches any non-empty sequence of DNS labels
+const corsSubdomainPattern = `([a-z0-9]([a-z0-9-]*[a-z0-9])?\.)+`
+
+// originMatcher validates CORS origins against a list of exact origins and patterns
+type originMatcher struct {
+	any      bool
+	exact    map[string]struct{}
+	patterns []*regexp.Regexp
+}
+
+// hasOriginPatterns checks if a list of CORS origins contains some wildcard subdomain or regex
+func hasOriginPatterns(origins []string) bool {
+	for _, origin := range origins {
+		if origin != "*" && (strings.HasPrefix(origin, corsRegexPrefix) || strings.Contains(origin, "*")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newOriginMatcher compiles a list of CORS origins. Wildcards are only supported as a subdomain
+// prefix (e.g. https://*.example.com) and match at least one subdomain label.
+func newOriginMatcher(origins []string) (*originMatcher, error) {
+	m := &originMatcher{exact: make(map[string]struct{}, len(origins))}
+	for _, origin := range origins {
+		switch {
+		case origin == "*":
+			m.any = true
+		case strings.HasPrefix(origin, corsRegexPrefix):
+			re, err := regexp.Compile(strings.TrimPrefix(origin, corsRegexPrefix))
+			if err != nil {
+				return nil, fmt.Errorf("invalid CORS origin regex: %q: %v", origin, err)
+			}
+			m.patterns = append(m.patterns, re)
+		case strings.Contains(origin, "*"):
+			parts := strings.SplitN(strings.ToLower(origin), "://*.", 2)
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" || strings.Contains(parts[1], "*") {
+				return nil, fmt.Errorf("invalid CORS origin pattern: %q. Wildcards are only supported as a subdomain prefix, e.g. https://*.example.com", origin)
+			}
+			m.patterns = append(m.patterns, regexp.MustCompile(
+				"^"+regexp.QuoteMeta(parts[0]+"://")+corsSubdomainPattern+regexp.QuoteMeta(parts[1])+"$"))
+		default:
+			m.exact[strings.ToLower(origin)] = struct{}{}
+		}
+	}
+
+	return m, nil
+}
+
+// match checks if an origin is permitted
+func (m *originMatcher) match(origin string) bool {
+	if m.any {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if _, ok := m.exact[origin]; ok {
+		return true
+	}
+	for _, re := range m.patterns {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isCorsOriginsValid checks the CORS origins may be compiled and comply with the credentialed requests rules
+func isCorsOriginsValid(origins []string, credentials bool) error {
+	for _, origin := range origins {
+		if origin == "*" && credentials {
+			return fmt.Errorf("the wildcard CORS origin cannot be used with cors credentials: browsers reject credentialed responses for any origin")
+		}
+	}
+	_, err := newOriginMatcher(origins)
+
+	return err
+}
@@ -13,7 +13,7 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package main
+package proxy
 
 import (
 	"io/ioutil"
@@ -93,3 +93,37 @@ func TestCorsWithUpstream(t *testing.T) {
 		assert.Equal(t, []string{"*"}, resp.Header["Access-Control-Allow-Origin"])
 	}
 }
+
+func TestOriginMatcher(t *testing.T) {
+	m, err := newOriginMatcher([]string{
+		"https://console.example.com",
+		"https://*.customers.example.com",
+		`regex:^https://app-[0-9]+\.example\.org$`,
+	})
+	require.NoError(t, err)
+
+	for origin, expected := range map[string]bool{
+		"https://console.example.com":             true,
+		"https://CONSOLE.example.com":             true,
+		"http://console.example.com":              false,
+		"https://acme.customers.example.com":      true,
+		"https://eu.acme.customers.example.com":   true,
+		"https://customers.example.com":           false,
+		"https://.customers.example.com":          false,
+		"https://acme.customers.example.com.evil": false,
+		"https://evilcustomers.example.com":       false,
+		"https://app-42.example.org":              true,
+		"https://app-x.example.org":               false,
+	} {
+		assert.Equal(t, expected, m.match(origin), "origin: %s", origin)
+	}
+
+	for _, invalid := range []string{"https://app.*.example.com", "*.example.com", "https://*", "regex:^https://(.example.com"} {
+		_, err = newOriginMatcher([]string{invalid})
+		assert.Error(t, err, "origin: %s", invalid)
+	}
+
+	assert.Error(t, isCorsOriginsValid([]string{"*"}, true))
+	assert.NoError(t, isCorsOriginsValid([]string{"*"}, false))
+	assert.NoError(t, isCorsOriginsValid([]string{"https://*.example.com"}, true))
+}
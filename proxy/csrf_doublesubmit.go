@@ -0,0 +1,165 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	gcsrf "github.com/gorilla/csrf"
+)
+
+var (
+	errCSRFTokenMissing  = errors.New("CSRF token not found in request")
+	errCSRFTokenInvalid  = errors.New("CSRF token invalid")
+	errCSRFTokenMismatch = errors.New("CSRF token in header does not match the cookie")
+)
+
+// isDoubleSubmitCSRF checks if the stateless double-submit cookie CSRF protection is used
+func (r *oauthProxy) isDoubleSubmitCSRF() bool {
+	return r.config.CSRFMode == csrfModeDoubleSubmit
+}
+
+// csrfSkipCheck marks the request as not subject to CSRF check
+func (r *oauthProxy) csrfSkipCheck(req *http.Request) *http.Request {
+	if r.isDoubleSubmitCSRF() || r.isStoredCSRF() {
+		return req.WithContext(context.WithValue(req.Context(), contextCSRFSkipName, true))
+	}
+
+	return gcsrf.UnsafeSkipCheck(req)
+}
+
+// csrfToken returns the CSRF token for the request, if any
+func (r *oauthProxy) csrfToken(req *http.Request) string {
+	if r.isDoubleSubmitCSRF() || r.isStoredCSRF() {
+		token, _ := req.Context().Value(contextCSRFTokenName).(string)
+		return token
+	}
+
+	return gcsrf.Token(req)
+}
+
+// csrfSession returns the session the double-submit CSRF token is bound to
+func csrfSession(user *userContext) string {
+	if session := user.getSessionID(); session != "" {
+		return session
+	}
+
+	return user.id
+}
+
+// signCSRFToken computes a CSRF token as an HMAC over the session and expiry
+func (r *oauthProxy) signCSRFToken(session string, expiry time.Time) string {
+	expires := strconv.FormatInt(expiry.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(r.config.EncryptionKey))
+	_, _ = mac.Write([]byte(session + "|" + expires))
+
+	return expires + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyCSRFToken checks a CSRF token has been issued for the session, and returns its expiry
+func (r *oauthProxy) verifyCSRFToken(token, session string) (time.Time, error) {
+	if token == "" {
+		return time.Time{}, errCSRFTokenMissing
+	}
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return time.Time{}, errCSRFTokenInvalid
+	}
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, errCSRFTokenInvalid
+	}
+	expiry := time.Unix(expires, 0)
+	if time.Now().After(expiry) {
+		return time.Time{}, errCSRFTokenInvalid
+	}
+	if !hmac.Equal([]byte(token), []byte(r.signCSRFToken(session, expiry))) {
+		return time.Time{}, errCSRFTokenInvalid
+	}
+
+	return expiry, nil
+}
+
+// issueCSRFToken returns a valid CSRF token for the user session, renewing the cookie whenever
+// the current token is invalid or halfway to its expiry
+func (r *oauthProxy) issueCSRFToken(w http.ResponseWriter, req *http.Request, user *userContext) string {
+	session := csrfSession(user)
+	if cookie, err := req.Cookie(r.config.CSRFCookieName); err == nil {
+		if expiry, err := r.verifyCSRFToken(cookie.Value, session); err == nil && time.Until(expiry) > r.config.CSRFTokenDuration/2 {
+			return cookie.Value
+		}
+	}
+
+	token := r.signCSRFToken(session, time.Now().Add(r.config.CSRFTokenDuration))
+	r.dropCookie(w, req.Host, r.config.CSRFCookieName, token, 0)
+
+	return token
+}
+
+// csrfDoubleSubmitMiddleware provides a stateless CSRF protection: the token is an HMAC over the user session
+// and an expiry, sent by the client both as a cookie and a header. It requires no shared state across replicas.
+func (r *oauthProxy) csrfDoubleSubmitMiddleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			scope, _ := req.Context().Value(contextScopeName).(*RequestScope)
+			if scope == nil || scope.AccessDenied || scope.Identity == nil || scope.Identity.isBearer() {
+				// not authenticated or credentials in header, CSRF is irrelevant here
+				next.ServeHTTP(w, req)
+				return
+			}
+
+			skipped, _ := req.Context().Value(contextCSRFSkipName).(bool)
+			if !skipped && !isSafeMethod(req.Method) {
+				if err := r.checkDoubleSubmitCSRF(req, scope.Identity); err != nil {
+					r.accessForbidden(w, req, "CSRF error", err.Error(), req.RemoteAddr)
+					return
+				}
+			}
+
+			token := r.issueCSRFToken(w, req, scope.Identity)
+			next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), contextCSRFTokenName, token)))
+		})
+	}
+}
+
+// checkDoubleSubmitCSRF checks the CSRF header matches a valid CSRF cookie for the user session
+func (r *oauthProxy) checkDoubleSubmitCSRF(req *http.Request, user *userContext) error {
+	cookie, err := req.Cookie(r.config.CSRFCookieName)
+	if err != nil {
+		return errCSRFTokenMissing
+	}
+	if _, err = r.verifyCSRFToken(cookie.Value, csrfSession(user)); err != nil {
+		return err
+	}
+	header := req.Header.Get(r.config.CSRFHeader)
+	if header == "" {
+		return errCSRFTokenMissing
+	}
+	if subtle.ConstantTimeCompare([]byte(header), []byte(cookie.Value)) != 1 {
+		return errCSRFTokenMismatch
+	}
+
+	return nil
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	sha "crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// csrfKeyPrefix prefixes the keys of the CSRF secrets in the store
+const csrfKeyPrefix = "csrf:"
+
+// errCSRFTokenExpired indicates the CSRF secret of the session has expired
+var errCSRFTokenExpired = errors.New("CSRF token expired")
+
+// isStoredCSRF checks if the CSRF secrets are kept server-side in the shared store
+func (r *oauthProxy) isStoredCSRF() bool {
+	return r.config.CSRFMode == csrfModeStore
+}
+
+// csrfKey is the key of the CSRF secret of a session in the store
+func csrfKey(session string) string {
+	hash := sha.Sum256([]byte(session))
+	return csrfKeyPrefix + base64.RawStdEncoding.EncodeToString(hash[:])
+}
+
+// getStoredCSRFToken returns the CSRF secret of the session and its expiry, if any
+func (r *oauthProxy) getStoredCSRFToken(session string) (string, time.Time, error) {
+	value, err := r.store.Get(csrfKey(session))
+	if err != nil || value == "" {
+		return "", time.Time{}, err
+	}
+	parts := strings.SplitN(value, "|", 2)
+	if len(parts) != 2 {
+		return "", time.Time{}, errCSRFTokenInvalid
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", time.Time{}, errCSRFTokenInvalid
+	}
+
+	return parts[0], time.Unix(expires, 0), nil
+}
+
+// issueStoredCSRFToken returns the CSRF secret of the user session, recording a new one in the store
+// whenever there is none or the current one is halfway to its expiry
+func (r *oauthProxy) issueStoredCSRFToken(user *userContext) (string, error) {
+	session := csrfSession(user)
+	if token, expiry, err := r.getStoredCSRFToken(session); err == nil && token != "" && time.Until(expiry) > r.config.CSRFTokenDuration/2 {
+		return token, nil
+	}
+
+	secret := make([]byte, 32)
+	if _, err := cryptorand.Read(secret); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(secret)
+	expires := strconv.FormatInt(time.Now().Add(r.config.CSRFTokenDuration).Unix(), 10)
+	if err := r.store.Set(csrfKey(session), token+"|"+expires, r.config.CSRFTokenDuration); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// checkStoredCSRF checks the CSRF header matches the secret recorded for the user session
+func (r *oauthProxy) checkStoredCSRF(req *http.Request, user *userContext) error {
+	header := req.Header.Get(r.config.CSRFHeader)
+	if header == "" {
+		return errCSRFTokenMissing
+	}
+	token, expiry, err := r.getStoredCSRFToken(csrfSession(user))
+	if err != nil {
+		return err
+	}
+	if token == "" {
+		return errCSRFTokenMissing
+	}
+	if time.Now().After(expiry) {
+		return errCSRFTokenExpired
+	}
+	if subtle.ConstantTimeCompare([]byte(header), []byte(token)) != 1 {
+		return errCSRFTokenMismatch
+	}
+
+	return nil
+}
+
+// csrfStoreMiddleware provides a CSRF protection with the secrets kept in the shared store, keyed by the
+// user session: the clients hold no CSRF cookie and any replica may check the requests of a session.
+func (r *oauthProxy) csrfStoreMiddleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			scope, _ := req.Context().Value(contextScopeName).(*RequestScope)
+			if scope == nil || scope.AccessDenied || scope.Identity == nil || scope.Identity.isBearer() {
+				// not authenticated or credentials in header, CSRF is irrelevant here
+				next.ServeHTTP(w, req)
+				return
+			}
+
+			skipped, _ := req.Context().Value(contextCSRFSkipName).(bool)
+			if !skipped && !isSafeMethod(req.Method) {
+				if err := r.checkStoredCSRF(req, scope.Identity); err != nil {
+					r.accessForbidden(w, req, "CSRF error", err.Error(), req.RemoteAddr)
+					return
+				}
+			}
+
+			token, err := r.issueStoredCSRFToken(scope.Identity)
+			if err != nil {
+				_, logger := r.traceSpanRequest(req)
+				logger.Error("unable to record the CSRF token in the store", zap.Error(err))
+			}
+			next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), contextCSRFTokenName, token)))
+		})
+	}
+}
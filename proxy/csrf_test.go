@@ -13,15 +13,18 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package main
+package proxy
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
 	"path"
 	"strings"
 	"testing"
@@ -29,6 +32,8 @@ import (
 
 	"github.com/go-chi/chi"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	resty "gopkg.in/resty.v1"
 )
 
 const (
@@ -553,3 +558,229 @@ func TestCSRF(t *testing.T) {
 	}
 	t.Logf("CSRF test on POST upstream scenario 8 passed")
 }
+
+func TestCSRFTokenHandler(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.EnableCSRF = true
+	cfg.EncryptionKey = testKey
+	cfg.CSRFCookieName = "kc-csrf"
+	cfg.CSRFHeader = "X-Csrf-Token"
+	cfg.Resources = []*Resource{
+		{
+			URL:        "/*",
+			Methods:    allHTTPMethods,
+			EnableCSRF: true,
+		},
+	}
+	requests := []fakeRequest{
+		{
+			URI:          cfg.WithOAuthURI(csrfURL),
+			ExpectedCode: http.StatusUnauthorized,
+		},
+		{
+			URI:            cfg.WithOAuthURI(csrfURL),
+			HasToken:       true,
+			HasCookieToken: true,
+			ExpectedCode:   http.StatusOK,
+			ExpectedCookiesValidator: map[string]func(string) bool{
+				cfg.CSRFCookieName: func(value string) bool { return value != "" },
+			},
+			OnResponse: func(_ int, _ *resty.Request, resp *resty.Response) {
+				var response csrfTokenResponse
+				if assert.NoError(t, json.Unmarshal(resp.Body(), &response)) {
+					assert.Equal(t, cfg.CSRFHeader, response.Header)
+					assert.NotEmpty(t, response.Token)
+				}
+			},
+		},
+	}
+	newFakeProxy(cfg).RunTests(t, requests)
+}
+
+func TestDoubleSubmitCSRFToken(t *testing.T) {
+	p := &oauthProxy{config: &Config{EncryptionKey: testKey}}
+	expiry := time.Now().Add(time.Hour)
+	token := p.signCSRFToken("session", expiry)
+
+	parsed, err := p.verifyCSRFToken(token, "session")
+	assert.NoError(t, err)
+	assert.Equal(t, expiry.Unix(), parsed.Unix())
+
+	_, err = p.verifyCSRFToken(token, "other-session")
+	assert.Error(t, err)
+	_, err = p.verifyCSRFToken(p.signCSRFToken("session", time.Now().Add(-time.Minute)), "session")
+	assert.Error(t, err)
+	_, err = p.verifyCSRFToken("", "session")
+	assert.Error(t, err)
+	_, err = p.verifyCSRFToken(strings.Replace(token, ".", "0.", 1), "session")
+	assert.Error(t, err)
+}
+
+func TestDoubleSubmitCSRF(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.EnableCSRF = true
+	cfg.EncryptionKey = testKey
+	cfg.CSRFCookieName = "kc-csrf"
+	cfg.CSRFHeader = "X-Csrf-Token"
+	cfg.CSRFMode = csrfModeDoubleSubmit
+	cfg.CSRFTokenDuration = time.Hour
+	cfg.Resources = []*Resource{
+		{
+			URL:        "/*",
+			Methods:    allHTTPMethods,
+			EnableCSRF: true,
+		},
+	}
+	p := newFakeProxy(cfg)
+	token := p.proxy.signCSRFToken(defaultTestTokenClaims[claimSessionState].(string), time.Now().Add(time.Hour))
+	csrfCookie := []*http.Cookie{{Name: cfg.CSRFCookieName, Value: token}}
+
+	requests := []fakeRequest{
+		{
+			URI:            "/test",
+			HasToken:       true,
+			HasCookieToken: true,
+			ExpectedProxy:  true,
+			ExpectedCode:   http.StatusOK,
+			ExpectedCookiesValidator: map[string]func(string) bool{
+				cfg.CSRFCookieName: func(value string) bool {
+					_, err := p.proxy.verifyCSRFToken(value, defaultTestTokenClaims[claimSessionState].(string))
+					return err == nil
+				},
+			},
+			OnResponse: func(_ int, _ *resty.Request, resp *resty.Response) {
+				assert.Equal(t, resp.Header().Get(cfg.CSRFHeader), findCookie(cfg.CSRFCookieName, resp.Cookies()).Value)
+			},
+		},
+		{ // no CSRF header
+			URI:            "/test",
+			Method:         http.MethodPost,
+			HasToken:       true,
+			HasCookieToken: true,
+			Cookies:        csrfCookie,
+			ExpectedCode:   http.StatusForbidden,
+		},
+		{ // CSRF header not matching the cookie
+			URI:            "/test",
+			Method:         http.MethodPost,
+			HasToken:       true,
+			HasCookieToken: true,
+			Cookies:        csrfCookie,
+			Headers:        map[string]string{cfg.CSRFHeader: p.proxy.signCSRFToken("other", time.Now().Add(time.Hour))},
+			ExpectedCode:   http.StatusForbidden,
+		},
+		{
+			URI:            "/test",
+			Method:         http.MethodPost,
+			HasToken:       true,
+			HasCookieToken: true,
+			Cookies:        csrfCookie,
+			Headers:        map[string]string{cfg.CSRFHeader: token},
+			ExpectedProxy:  true,
+			ExpectedCode:   http.StatusOK,
+		},
+		{ // bearer tokens are not subject to CSRF checks
+			URI:           "/test",
+			Method:        http.MethodPost,
+			HasToken:      true,
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+		},
+	}
+	p.RunTests(t, requests)
+}
+
+func TestStoredCSRF(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "keycloak-gatekeeper")
+	require.NoError(t, err)
+	_ = tmpfile.Close()
+	defer os.Remove(tmpfile.Name())
+
+	cfg := newFakeKeycloakConfig()
+	cfg.EnableCSRF = true
+	cfg.CSRFHeader = "X-Csrf-Token"
+	cfg.CSRFMode = csrfModeStore
+	cfg.CSRFTokenDuration = time.Hour
+	cfg.StoreURL = fmt.Sprintf("boltdb:///%s", tmpfile.Name())
+	cfg.Resources = []*Resource{
+		{
+			URL:        "/*",
+			Methods:    allHTTPMethods,
+			EnableCSRF: true,
+		},
+	}
+	p := newFakeProxy(cfg)
+	defer func() {
+		_ = p.proxy.CloseStore()
+	}()
+	session := defaultTestTokenClaims[claimSessionState].(string)
+
+	var token string
+	requests := []fakeRequest{
+		{
+			URI:            "/test",
+			HasToken:       true,
+			HasCookieToken: true,
+			ExpectedProxy:  true,
+			ExpectedCode:   http.StatusOK,
+			OnResponse: func(_ int, _ *resty.Request, resp *resty.Response) {
+				token = resp.Header().Get(cfg.CSRFHeader)
+				assert.NotEmpty(t, token)
+				// the secret is kept server-side only
+				assert.Nil(t, findCookie(cfg.CSRFCookieName, resp.Cookies()))
+				stored, _, err := p.proxy.getStoredCSRFToken(session)
+				assert.NoError(t, err)
+				assert.Equal(t, stored, token)
+			},
+		},
+		{ // no CSRF header
+			URI:            "/test",
+			Method:         http.MethodPost,
+			HasToken:       true,
+			HasCookieToken: true,
+			ExpectedCode:   http.StatusForbidden,
+		},
+		{ // CSRF header not matching the stored secret
+			URI:            "/test",
+			Method:         http.MethodPost,
+			HasToken:       true,
+			HasCookieToken: true,
+			Headers:        map[string]string{cfg.CSRFHeader: "invalid"},
+			ExpectedCode:   http.StatusForbidden,
+		},
+		{
+			URI:            "/test",
+			Method:         http.MethodPost,
+			HasToken:       true,
+			HasCookieToken: true,
+			ExpectedProxy:  true,
+			ExpectedCode:   http.StatusOK,
+			OnResponse: func(_ int, req *resty.Request, resp *resty.Response) {
+				// the token is stable until halfway to its expiry
+				assert.Equal(t, token, resp.Header().Get(cfg.CSRFHeader))
+			},
+		},
+		{ // bearer tokens are not subject to CSRF checks
+			URI:           "/test",
+			Method:        http.MethodPost,
+			HasToken:      true,
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+		},
+	}
+	// the header of the valid request is only known once the token is issued
+	requests[2].OnResponse = func(int, *resty.Request, *resty.Response) {
+		requests[3].Headers = map[string]string{cfg.CSRFHeader: token}
+	}
+	p.RunTests(t, requests)
+
+	// another replica sharing the store checks the requests of the session
+	replica := &oauthProxy{config: cfg, store: p.proxy.store}
+	user := &userContext{id: "subject", claims: defaultTestTokenClaims}
+	req := httptest.NewRequest(http.MethodPost, "/test", nil)
+	req.Header.Set(cfg.CSRFHeader, token)
+	assert.NoError(t, replica.checkStoredCSRF(req, user))
+
+	require.NoError(t, p.proxy.store.Delete(csrfKey(session)))
+	assert.Equal(t, errCSRFTokenMissing, replica.checkStoredCSRF(req, user))
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const redactedValue = "[REDACTED]"
+
+// debugCapture logs the requests and responses of the resources in debug capture mode
+type debugCapture struct {
+	// rate is the percentage of the requests captured
+	rate int
+	// maxBody is the number of bytes captured from the bodies
+	maxBody int
+	// redactions are the lower-cased fragments of the names of the headers and fields to redact
+	redactions []string
+	// jsonFields and formFields match the secret-looking fields of the bodies
+	jsonFields *regexp.Regexp
+	formFields *regexp.Regexp
+}
+
+func newDebugCapture(rate, maxBody int, redactions []string) *debugCapture {
+	c := &debugCapture{rate: rate, maxBody: maxBody}
+	quoted := make([]string, 0, len(redactions))
+	for _, x := range redactions {
+		if x == "" {
+			continue
+		}
+		c.redactions = append(c.redactions, strings.ToLower(x))
+		quoted = append(quoted, regexp.QuoteMeta(x))
+	}
+	if len(quoted) > 0 {
+		fragments := strings.Join(quoted, "|")
+		c.jsonFields = regexp.MustCompile(`(?i)("[^"]*(?:` + fragments + `)[^"]*"\s*:\s*)(?:"(?:[^"\\]|\\.)*"?|\[(?:[^\]"]|"(?:[^"\\]|\\.)*"?)*\]?|[^\s,}\]]+)`)
+		c.formFields = regexp.MustCompile(`(?i)((?:^|&)[^=&]*(?:` + fragments + `)[^=&]*=)[^&]*`)
+	}
+
+	return c
+}
+
+// sampled decides whether a request is captured
+func (c *debugCapture) sampled() bool {
+	return c.rate >= 100 || rand.Intn(100) < c.rate // nolint: gosec
+}
+
+// isRedacted checks whether a header or field name looks like a secret
+func (c *debugCapture) isRedacted(name string) bool {
+	name = strings.ToLower(name)
+	for _, x := range c.redactions {
+		if strings.Contains(name, x) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// headers renders the headers, redacting the secret-looking ones
+func (c *debugCapture) headers(headers http.Header) map[string]string {
+	list := make(map[string]string, len(headers))
+	for name, values := range headers {
+		if c.isRedacted(name) {
+			list[name] = redactedValue
+			continue
+		}
+		list[name] = strings.Join(values, ", ")
+	}
+
+	return list
+}
+
+// body renders a captured body, redacting the secret-looking fields of json and form contents
+func (c *debugCapture) body(content []byte, contentType string, truncated bool) string {
+	if len(content) == 0 {
+		return ""
+	}
+	media, _, _ := mime.ParseMediaType(contentType)
+	var text string
+	switch {
+	case media == "application/x-www-form-urlencoded":
+		text = string(content)
+		if c.formFields != nil {
+			text = c.formFields.ReplaceAllString(text, "${1}"+redactedValue)
+		}
+	case strings.HasSuffix(media, "json"):
+		text = string(content)
+		if c.jsonFields != nil {
+			text = c.jsonFields.ReplaceAllString(text, "${1}\""+redactedValue+"\"")
+		}
+	case strings.HasPrefix(media, "text/"), strings.HasSuffix(media, "xml"):
+		text = string(content)
+	default:
+		return fmt.Sprintf("<%d bytes of %s>", len(content), contentType)
+	}
+	if truncated {
+		text += "...(truncated)"
+	}
+
+	return text
+}
+
+// cappedBuffer keeps the first bytes written to it
+type cappedBuffer struct {
+	bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); room < len(p) {
+		b.truncated = true
+		if room > 0 {
+			b.Buffer.Write(p[:room])
+		}
+		return len(p), nil
+	}
+
+	return b.Buffer.Write(p)
+}
+
+// debugCaptureMiddleware logs the headers and bodies of a sample of the requests and responses of a resource
+func (r *oauthProxy) debugCaptureMiddleware(resource *Resource) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !resource.DebugCapture || r.capture == nil {
+			return next
+		}
+		c := r.capture
+
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if !c.sampled() {
+				next.ServeHTTP(w, req)
+				return
+			}
+			start := time.Now()
+
+			// capture the head of the request body, and put it back in front of the rest
+			var requestBody []byte
+			var requestTruncated bool
+			if req.Body != nil && req.Body != http.NoBody {
+				head, err := ioutil.ReadAll(io.LimitReader(req.Body, int64(c.maxBody)+1))
+				if err != nil {
+					r.errorResponse(w, req, "unable to read the request body", http.StatusBadRequest, err)
+					return
+				}
+				requestBody, requestTruncated = head, len(head) > c.maxBody
+				if requestTruncated {
+					requestBody = head[:c.maxBody]
+				}
+				req.Body = struct {
+					io.Reader
+					io.Closer
+				}{io.MultiReader(bytes.NewReader(head), req.Body), req.Body}
+			}
+
+			responseBody := &cappedBuffer{max: c.maxBody}
+			resp := newResponseWriter(w, req.ProtoMajor)
+			resp.Tee(responseBody)
+			next.ServeHTTP(resp, req)
+
+			// the request headers are logged as sent to the upstream
+			r.log.Info("debug capture",
+				zap.String("resource", resource.URL),
+				zap.String("method", req.Method),
+				zap.String("path", req.URL.Path),
+				zap.Int("status", resp.Status()),
+				zap.Duration("latency", time.Since(start)),
+				zap.Any("request_headers", c.headers(req.Header)),
+				zap.String("request_body", c.body(requestBody, req.Header.Get("Content-Type"), requestTruncated)),
+				zap.Any("response_headers", c.headers(resp.Header())),
+				zap.String("response_body", c.body(responseBody.Bytes(), resp.Header().Get("Content-Type"), responseBody.truncated)))
+		})
+	}
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func newTestDebugCapture() *debugCapture {
+	return newDebugCapture(100, 64, newDefaultConfig().DebugCaptureRedactions)
+}
+
+func TestDebugCaptureHeaders(t *testing.T) {
+	c := newTestDebugCapture()
+	headers := c.headers(http.Header{
+		"Authorization": {"Bearer abc"},
+		"Cookie":        {"kc-access=abc"},
+		"X-Api-Key":     {"abc"},
+		"Accept":        {"text/html", "application/json"},
+	})
+	assert.Equal(t, map[string]string{
+		"Authorization": redactedValue,
+		"Cookie":        redactedValue,
+		"X-Api-Key":     redactedValue,
+		"Accept":        "text/html, application/json",
+	}, headers)
+}
+
+func TestDebugCaptureBody(t *testing.T) {
+	c := newTestDebugCapture()
+	cs := []struct {
+		Content     string
+		ContentType string
+		Truncated   bool
+		Expected    string
+	}{
+		{},
+		{
+			Content:     `{"user":"bob","password":"s3cr\"et","client_secret": 12,"nested":{"accessToken":"abc"}}`,
+			ContentType: "application/json; charset=utf-8",
+			Expected:    `{"user":"bob","password":"[REDACTED]","client_secret": "[REDACTED]","nested":{"accessToken":"[REDACTED]"}}`,
+		},
+		{
+			Content:     `{"tokens":["abc", "d]ef"],"passwords":[1,2],"user":"bob","password":"s3c`,
+			ContentType: "application/json",
+			Truncated:   true,
+			Expected:    `{"tokens":"[REDACTED]","passwords":"[REDACTED]","user":"bob","password":"[REDACTED]"...(truncated)`,
+		},
+		{
+			Content:     "grant_type=password&username=bob&password=s3cret&refresh_token=abc",
+			ContentType: "application/x-www-form-urlencoded",
+			Expected:    "grant_type=password&username=bob&password=[REDACTED]&refresh_token=[REDACTED]",
+		},
+		{
+			Content:     "hello",
+			ContentType: "text/plain",
+			Expected:    "hello",
+		},
+		{
+			Content:     "\x00\x01",
+			ContentType: "application/octet-stream",
+			Expected:    "<2 bytes of application/octet-stream>",
+		},
+	}
+	for i, x := range cs {
+		assert.Equal(t, x.Expected, c.body([]byte(x.Content), x.ContentType, x.Truncated), "case %d", i)
+	}
+}
+
+func TestCappedBuffer(t *testing.T) {
+	b := &cappedBuffer{max: 4}
+	n, err := b.Write([]byte("ab"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	n, err = b.Write([]byte("cdef"))
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+	assert.Equal(t, "abcd", b.String())
+	assert.True(t, b.truncated)
+}
+
+func TestDebugCaptureMiddleware(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.DebugCaptureRate = 100
+	cfg.DebugCaptureMaxBody = 1024
+	cfg.DebugCaptureRedactions = []string{"authorization", "password"}
+	cfg.Resources = []*Resource{
+		{URL: "/captured/*", Methods: allHTTPMethods, DebugCapture: true},
+		{URL: "/other/*", Methods: allHTTPMethods},
+	}
+	p := newFakeProxy(cfg)
+	core, logs := observer.New(zapcore.InfoLevel)
+	p.proxy.log = zap.New(core)
+
+	p.RunTests(t, []fakeRequest{
+		{
+			URI:           "/captured/test",
+			Method:        http.MethodPost,
+			HasToken:      true,
+			FormValues:    map[string]string{"user": "bob", "password": "s3cret"},
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+		},
+		{
+			URI:           "/other/test",
+			HasToken:      true,
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+		},
+	})
+
+	captures := logs.FilterMessage("debug capture").All()
+	require.Len(t, captures, 1)
+	fields := captures[0].ContextMap()
+	assert.Equal(t, "/captured/test", fields["path"])
+	assert.Equal(t, int64(http.StatusOK), fields["status"])
+	assert.Contains(t, fields["request_body"], "password=[REDACTED]")
+	assert.Contains(t, fields["request_body"], "user=bob")
+	requestHeaders := fields["request_headers"].(map[string]string)
+	assert.Equal(t, redactedValue, requestHeaders["Authorization"])
+	// the identity headers sent to the upstream are captured
+	assert.NotEmpty(t, requestHeaders["X-Auth-Email"])
+	// the upstream echoes the request, with its authorization header
+	assert.Contains(t, fields["response_body"], `"Authorization":"[REDACTED]"`)
+	assert.NotContains(t, fields["response_body"], "Bearer")
+}
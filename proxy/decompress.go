@@ -0,0 +1,144 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	encodingGzip    = "gzip"
+	encodingDeflate = "deflate"
+)
+
+// errDecompressedTooLarge indicates a body exceeds the maximum size once decompressed
+var errDecompressedTooLarge = errors.New("the decompressed body is too large")
+
+// contentEncoding returns the lower cased content encoding of a body, empty when not encoded
+func contentEncoding(header http.Header) string {
+	encoding := strings.ToLower(strings.TrimSpace(header.Get("Content-Encoding")))
+	if encoding == "identity" {
+		return ""
+	}
+	if encoding == "x-gzip" {
+		return encodingGzip
+	}
+
+	return encoding
+}
+
+// isDecompressed checks if the bodies of an encoding are decompressed
+func isDecompressed(encoding string) bool {
+	return encoding == encodingGzip || encoding == encodingDeflate
+}
+
+// decompress reads a gzip or deflate encoded body, up to a size once decompressed
+func decompress(encoding string, body io.Reader, maxSize int) ([]byte, error) {
+	var reader io.ReadCloser
+	var err error
+	switch encoding {
+	case encodingGzip:
+		reader, err = gzip.NewReader(body)
+	case encodingDeflate:
+		reader, err = zlib.NewReader(body)
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %s", encoding)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	content, err := ioutil.ReadAll(io.LimitReader(reader, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > maxSize {
+		return nil, errDecompressedTooLarge
+	}
+
+	return content, nil
+}
+
+// compress encodes a body with gzip or deflate
+func compress(encoding string, content []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	var writer io.WriteCloser
+	switch encoding {
+	case encodingGzip:
+		writer = gzip.NewWriter(&buffer)
+	case encodingDeflate:
+		writer = zlib.NewWriter(&buffer)
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %s", encoding)
+	}
+	if _, err := writer.Write(content); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// decompressMiddleware decompresses the gzip and deflate request bodies of a resource, so the features inspecting
+// the bodies (e.g. graphql admission) apply to the compressed requests. The bodies are proxied decompressed.
+func (r *oauthProxy) decompressMiddleware(resource *Resource) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !resource.Decompress {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			encoding := contentEncoding(req.Header)
+			if encoding == "" || req.Body == nil || req.Body == http.NoBody {
+				next.ServeHTTP(w, req)
+				return
+			}
+			if !isDecompressed(encoding) {
+				r.errorResponse(w, req, "unsupported request content encoding", http.StatusUnsupportedMediaType, nil)
+				return
+			}
+
+			content, err := decompress(encoding, req.Body, r.config.DecompressMaxSize)
+			_ = req.Body.Close()
+			switch {
+			case err == errDecompressedTooLarge:
+				r.errorResponse(w, req, "the decompressed request body is too large", http.StatusRequestEntityTooLarge, nil)
+				return
+			case err != nil:
+				r.errorResponse(w, req, "unable to decompress the request body", http.StatusBadRequest, err)
+				return
+			}
+			req.Body = ioutil.NopCloser(bytes.NewReader(content))
+			req.ContentLength = int64(len(content))
+			req.Header.Set("Content-Length", strconv.Itoa(len(content)))
+			req.Header.Del("Content-Encoding")
+
+			next.ServeHTTP(w, req)
+		})
+	}
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompression(t *testing.T) {
+	for _, encoding := range []string{encodingGzip, encodingDeflate} {
+		compressed, err := compress(encoding, []byte("hello world"))
+		require.NoError(t, err, encoding)
+		content, err := decompress(encoding, bytes.NewReader(compressed), 64)
+		require.NoError(t, err, encoding)
+		assert.Equal(t, "hello world", string(content), encoding)
+
+		_, err = decompress(encoding, bytes.NewReader(compressed), 5)
+		assert.Equal(t, errDecompressedTooLarge, err, encoding)
+		_, err = decompress(encoding, strings.NewReader("not compressed"), 64)
+		assert.Error(t, err, encoding)
+	}
+	_, err := decompress("br", strings.NewReader(""), 64)
+	assert.Error(t, err)
+
+	assert.Equal(t, encodingGzip, contentEncoding(http.Header{"Content-Encoding": {"X-Gzip"}}))
+	assert.Empty(t, contentEncoding(http.Header{"Content-Encoding": {"identity"}}))
+}
+
+func TestDecompressedBodyRewrite(t *testing.T) {
+	padding := strings.Repeat(" ", 256)
+	compressed, err := compress(encodingGzip, []byte(`{"self":"http://backend:8080/a"}`+padding))
+	require.NoError(t, err)
+	response := func() *http.Response {
+		return &http.Response{
+			Header:        http.Header{"Content-Type": {"application/json"}, "Content-Encoding": {"gzip"}},
+			Body:          ioutil.NopCloser(bytes.NewReader(compressed)),
+			ContentLength: int64(len(compressed)),
+			Request:       httptest.NewRequest(http.MethodGet, "/", nil),
+		}
+	}
+	rewrites := []*BodyRewrite{{Match: "http://backend:8080", Replace: "https://app.example.com"}}
+
+	res := response()
+	require.NoError(t, newBodyRewriter(rewrites, []string{"application/json"}, 1024, true).rewrite(res))
+	assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+	content, err := decompress(encodingGzip, res.Body, 1024)
+	require.NoError(t, err)
+	assert.Equal(t, `{"self":"https://app.example.com/a"}`+padding, string(content))
+
+	// the compressed bodies are not rewritten without decompression, or once decompressed larger than the maximum size
+	for _, rewriter := range []*bodyRewriter{
+		newBodyRewriter(rewrites, []string{"application/json"}, 1024, false),
+		newBodyRewriter(rewrites, []string{"application/json"}, len(compressed), true),
+	} {
+		res = response()
+		require.NoError(t, rewriter.rewrite(res))
+		body, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err)
+		assert.Equal(t, compressed, body)
+	}
+}
+
+func TestDecompressMiddleware(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Content-Encoding", req.Header.Get("Content-Encoding"))
+		body, _ := ioutil.ReadAll(req.Body)
+		_, _ = w.Write(body)
+	}))
+	defer upstream.Close()
+
+	cfg := newFakeKeycloakConfig()
+	cfg.DecompressMaxSize = 64
+	cfg.Resources = []*Resource{
+		{
+			URL:         "/uploads/*",
+			Methods:     allHTTPMethods,
+			WhiteListed: true,
+			Upstream:    upstream.URL,
+			Decompress:  true,
+		},
+	}
+	p := newFakeProxy(cfg)
+	defer func() {
+		p.idp.Close()
+		p.proxy.server.Close()
+	}()
+	p.proxy.upstream = p.proxy.newUpstreamProxy(http.DefaultTransport, false)
+
+	post := func(encoding string, body []byte) (int, string, string) {
+		req, err := http.NewRequest(http.MethodPost, p.getServiceURL()+"/uploads/file", bytes.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Encoding", encoding)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		content, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, resp.Header.Get("X-Content-Encoding"), string(content)
+	}
+
+	compressed, err := compress(encodingDeflate, []byte("hello"))
+	require.NoError(t, err)
+	code, encoding, body := post(encodingDeflate, compressed)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, encoding, "the body is proxied decompressed")
+	assert.Equal(t, "hello", body)
+
+	large, err := compress(encodingGzip, bytes.Repeat([]byte("a"), 65))
+	require.NoError(t, err)
+	code, _, _ = post(encodingGzip, large)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, code)
+
+	code, _, _ = post(encodingGzip, []byte("not compressed"))
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, _, _ = post("br", []byte("compressed"))
+	assert.Equal(t, http.StatusUnsupportedMediaType, code)
+}
@@ -0,0 +1,31 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"net"
+	"time"
+)
+
+// newDialer creates a dialer with a connect timeout, a keep-alive period and the delay before the connections
+// are attempted on the other address family of a dual-stack host (Happy Eyeballs, a negative delay disabling it)
+func newDialer(connectTimeout, keepAlive, fallbackDelay time.Duration) *net.Dialer {
+	return &net.Dialer{
+		Timeout:       connectTimeout,
+		KeepAlive:     keepAlive,
+		FallbackDelay: fallbackDelay,
+	}
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialerDualStack(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	// the host resolves to both loopback addresses, nothing listening on the port over IPv6
+	nameServer := newFakeNameServer(t, false)
+	defer nameServer.Close()
+	resolver := newHostResolver(&Config{DNSResolver: nameServer.LocalAddr().String(), DNSTimeout: time.Second})
+
+	for i, fallbackDelay := range []time.Duration{time.Minute, -1} {
+		started := time.Now()
+		dial := resolver.dialContext(newDialer(time.Second, 0, fallbackDelay))
+		conn, err := dial(context.Background(), "tcp", net.JoinHostPort("dual.split-horizon.example", port))
+		require.NoError(t, err, "case %d", i)
+		assert.Equal(t, listener.Addr().String(), conn.RemoteAddr().String(), "case %d", i)
+		assert.True(t, time.Since(started) < 5*time.Second, "case %d, expected no fallback delay", i)
+		_ = conn.Close()
+	}
+
+	dial := resolver.dialContext(newDialer(time.Second, 0, 0))
+	_, err = dial(context.Background(), "tcp6", net.JoinHostPort("dual.split-horizon.example", port))
+	assert.Error(t, err)
+}
@@ -0,0 +1,581 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+/*
+Package proxy provides a transparent authentication proxy suited for use with Keycloak as OIDC identity provider
+*/
+package proxy
+
+import (
+	"time"
+)
+
+// Config is the configuration for the proxy
+type Config struct {
+	// ConfigFile is the binding interface
+	ConfigFile string `json:"config" yaml:"config" usage:"path the a configuration file" env:"CONFIG_FILE"`
+	// Strict refuses to start when the startup self-check reports misconfigurations
+	Strict bool `json:"strict" yaml:"strict" usage:"refuse to start when the startup self-check reports misconfigurations, e.g. secure cookies over plain http" env:"STRICT"`
+	// Harden applies a preset of secure settings, and implies the strict mode
+	Harden bool `json:"harden" yaml:"harden" usage:"applies the secure settings (secure and http only cookies, default deny, security headers) and refuses to start on the insecure combinations reported by the startup self-check" env:"HARDEN"`
+	// Listen defines the binding interface for main listener, e.g. {address}:{port}. This is required and there is no default value.
+	Listen string `json:"listen" yaml:"listen" usage:"Defines the binding interface for main listener, e.g. {address}:{port}. This is required and there is no default value" env:"LISTEN"`
+	// ListenIPv6 is a separate IPv6 interface for the main listener, when the main interface is then bound on IPv4 only
+	ListenIPv6 string `json:"listen-ipv6" yaml:"listen-ipv6" usage:"separate IPv6 interface for the main listener, e.g. [::]:3000. The listen interface is then bound on IPv4 only" env:"LISTEN_IPV6"`
+	// ListenIPv6Only binds the listeners on IPv6 only, without accepting the IPv4 connections on a dual-stack socket
+	ListenIPv6Only bool `json:"listen-ipv6-only" yaml:"listen-ipv6-only" usage:"bind the listeners on IPv6 only, without accepting the IPv4 connections on a dual-stack socket" env:"LISTEN_IPV6_ONLY"`
+	// ListenHTTP is the interface to bind the http only service on
+	ListenHTTP string `json:"listen-http" yaml:"listen-http" usage:"interface we should be listening to for HTTP traffic" env:"LISTEN_HTTP"`
+	// ListenAdmin defines the interface to bind admin-only endpoint (live-status, debug, prometheus...). If not defined, this defaults to the main listener defined by Listen.
+	ListenAdmin string `json:"listen-admin" yaml:"listen-admin" usage:"defines the interface to bind admin-only endpoint (live-status, debug, prometheus...). If not defined, this defaults to the main listener defined by Listen" env:"LISTEN_ADMIN"`
+	// ListenAdminScheme defines the scheme admin endpoints are served with. If not defined, same as main listener.
+	ListenAdminScheme string `json:"listen-admin-scheme" yaml:"listen-admin-scheme" usage:"scheme to serve admin-only endpoint (http or https)." env:"LISTEN_ADMIN_SCHEME"`
+	// ListenAdminSocketMode is the file mode of the unix socket of the admin listener, e.g. 0660
+	ListenAdminSocketMode string `json:"listen-admin-socket-mode" yaml:"listen-admin-socket-mode" usage:"file mode of the unix socket of the admin listener, in octal, e.g. 0660" env:"LISTEN_ADMIN_SOCKET_MODE"`
+	// ListenAdminSocketGroup is the group owning the unix socket of the admin listener
+	ListenAdminSocketGroup string `json:"listen-admin-socket-group" yaml:"listen-admin-socket-group" usage:"name or id of the group owning the unix socket of the admin listener, e.g. the one of the local agents" env:"LISTEN_ADMIN_SOCKET_GROUP"`
+	// EnableAdminAPI enables the operational API on the admin listener, e.g. to list the active sessions
+	EnableAdminAPI bool `json:"enable-admin-api" yaml:"enable-admin-api" usage:"enables the operational api under /admin on the admin listener, e.g. to list the active sessions" env:"ENABLE_ADMIN_API"`
+	// AdminAPIToken is a bearer token required to access the admin API
+	AdminAPIToken string `json:"admin-api-token" yaml:"admin-api-token" usage:"bearer token required in the Authorization header to access the admin api" env:"ADMIN_API_TOKEN"`
+	// DrainTimeout is the deadline to complete the in-flight requests when draining from the admin API
+	DrainTimeout time.Duration `json:"drain-timeout" yaml:"drain-timeout" usage:"deadline to complete the in-flight requests when the service is drained from the admin api" env:"DRAIN_TIMEOUT"`
+	// EnableFaultInjection enables the fault injection on the resources, set in the configuration or from the admin API
+	EnableFaultInjection bool `json:"enable-fault-injection" yaml:"enable-fault-injection" usage:"enables the injection of delays and errors on the resources, for resilience testing (not for production)" env:"ENABLE_FAULT_INJECTION"`
+	// EnableForwardAuth enables the forward-auth endpoint, authenticating the requests of a reverse proxy in front of other services
+	EnableForwardAuth bool `json:"enable-forward-auth" yaml:"enable-forward-auth" usage:"enables the /oauth/forward-auth endpoint, for traefik forwardAuth or nginx auth_request to authenticate the requests to other services" env:"ENABLE_FORWARD_AUTH"`
+	// DiscoveryURL is the url for the keycloak server
+	DiscoveryURL string `json:"discovery-url" yaml:"discovery-url" usage:"discovery url to retrieve the openid configuration" env:"DISCOVERY_URL"`
+	// ClientID is the client id
+	ClientID string `json:"client-id" yaml:"client-id" usage:"client id used to authenticate to the oauth service" env:"CLIENT_ID"`
+	// ClientSecret is the secret for AS
+	ClientSecret string `json:"client-secret" yaml:"client-secret" usage:"client secret used to authenticate to the oauth service" env:"CLIENT_SECRET"`
+	// Realms are other openid providers or keycloak realms served by the proxy, selected per host or per resource
+	Realms []*Realm `json:"realms" yaml:"realms" usage:"other openid providers or keycloak realms served by the proxy, e.g. 'name=partners|discovery-url=https://keycloak/auth/realms/partners|client-id=gatekeeper|hostnames=partners.example.com'"`
+	// RedirectionURL the redirection url
+	RedirectionURL string `json:"redirection-url" yaml:"redirection-url" usage:"redirection url for the oauth callback url, defaults to host header is absent" env:"REDIRECTION_URL"`
+	// RevocationEndpoint is the token revocation endpoint to revoke refresh tokens
+	RevocationEndpoint string `json:"revocation-url" yaml:"revocation-url" usage:"url for the revocation endpoint to revoke refresh token" env:"REVOCATION_URL"`
+	// RevocationMode determines how the tokens are revoked on logout
+	RevocationMode string `json:"revocation-mode" yaml:"revocation-mode" usage:"how tokens are revoked on logout: end-session (post the refresh token to the provider logout endpoint), revoke (RFC 7009 token revocation), both or none"`
+	// EnableRevokeAccessToken indicates the access token is revoked on logout, alongside the refresh token
+	EnableRevokeAccessToken bool `json:"enable-revoke-access-token" yaml:"enable-revoke-access-token" usage:"revoke the access token as well as the refresh token on logout, when using the revoke revocation mode"`
+	// EnableTokenIntrospection validates the access tokens at the introspection endpoint in place of their signature
+	EnableTokenIntrospection bool `json:"enable-token-introspection" yaml:"enable-token-introspection" usage:"validate the access tokens at the introspection endpoint of the provider in place of their signature, accepting opaque tokens" env:"ENABLE_TOKEN_INTROSPECTION"`
+	// IntrospectionEndpoint is the RFC 7662 token introspection endpoint
+	IntrospectionEndpoint string `json:"introspection-url" yaml:"introspection-url" usage:"url for the token introspection endpoint, defaults to the keycloak endpoint of the discovery url" env:"INTROSPECTION_URL"`
+	// IntrospectionCacheDuration is how long an introspected token is considered active without introspecting it again
+	IntrospectionCacheDuration time.Duration `json:"introspection-cache-duration" yaml:"introspection-cache-duration" usage:"how long an active token is cached without being introspected again, bounded by its expiration and the token-cache-size" env:"INTROSPECTION_CACHE_DURATION"`
+	// SkipOpenIDProviderTLSVerify skips the tls verification for openid provider communication
+	SkipOpenIDProviderTLSVerify bool `json:"skip-openid-provider-tls-verify" yaml:"skip-openid-provider-tls-verify" usage:"skip the verification of any TLS communication with the openid provider"`
+	// OpenIDProviderProxy proxy for openid provider communication
+	OpenIDProviderProxy string `json:"openid-provider-proxy" yaml:"openid-provider-proxy" usage:"proxy for communication with the openid provider, or 'environment' to honor HTTP_PROXY, HTTPS_PROXY and NO_PROXY"`
+	// OpenIDProviderNoProxy are the hosts of the openid provider reached without the proxy
+	OpenIDProviderNoProxy []string `json:"openid-provider-no-proxy" yaml:"openid-provider-no-proxy" usage:"hosts, domains (.example.com) or networks (10.0.0.0/8) of the openid provider reached without the proxy"`
+	// OpenIDProviderTimeout is the timeout used to pulling the openid configuration from the provider
+	OpenIDProviderTimeout time.Duration `json:"openid-provider-timeout" yaml:"openid-provider-timeout" usage:"timeout for openid configuration on .well-known/openid-configuration"`
+	// OpenIDProviderConnectTimeout is the maximum amount of time a connection to the provider takes to be established
+	OpenIDProviderConnectTimeout time.Duration `json:"openid-provider-connect-timeout" yaml:"openid-provider-connect-timeout" usage:"maximum amount of time a connection to the openid provider takes to be established" env:"OPENID_PROVIDER_CONNECT_TIMEOUT"`
+	// OpenIDProviderKeepaliveTimeout is the keep-alive period of the connections to the provider
+	OpenIDProviderKeepaliveTimeout time.Duration `json:"openid-provider-keepalive-timeout" yaml:"openid-provider-keepalive-timeout" usage:"keep-alive period of the connections to the openid provider. Defaults to 15s" env:"OPENID_PROVIDER_KEEPALIVE_TIMEOUT"`
+	// OpenIDProviderFallbackDelay is the delay of the dual-stack fallback of the connections to the provider
+	OpenIDProviderFallbackDelay time.Duration `json:"openid-provider-fallback-delay" yaml:"openid-provider-fallback-delay" usage:"delay before connecting to the openid provider over the other ip family of a dual-stack host. Defaults to 300ms, negative to disable" env:"OPENID_PROVIDER_FALLBACK_DELAY"`
+	// OpenIDProviderCA is the certificate authority issuing the TLS certificate for the OpenID provider
+	OpenIDProviderCA string `json:"openid-provider-ca" yaml:"openid-provider-ca" usage:"certificate authority for openid configuration endpoints"`
+	// OpenIDProviderPins are the hashes of the public keys of the provider certificates, or of their authorities
+	OpenIDProviderPins []string `json:"openid-provider-pins" yaml:"openid-provider-pins" usage:"base64 encoded sha256 hashes of the subject public key info of the openid provider certificate or of its authorities, e.g. sha256/YLh1dUR9y6Kja30RrAn7JKnbQG/uEtLMkBgFF2Fuihg=" env:"OPENID_PROVIDER_PINS"`
+	// OpenIDProviderGracePins are the pins still accepted while the keys of the provider are rotated
+	OpenIDProviderGracePins []string `json:"openid-provider-grace-pins" yaml:"openid-provider-grace-pins" usage:"pins still accepted, with a warning, while the keys of the openid provider are rotated" env:"OPENID_PROVIDER_GRACE_PINS"`
+	// OpenIDProviderRetryAfter is the delay advised to the clients while the provider is unavailable
+	OpenIDProviderRetryAfter time.Duration `json:"openid-provider-retry-after" yaml:"openid-provider-retry-after" usage:"delay advised with the Retry-After header when the openid provider cannot be reached"`
+	// EnableStartWithoutProvider starts the service when the discovery fails, retrying it in the background
+	EnableStartWithoutProvider bool `json:"enable-start-without-provider" yaml:"enable-start-without-provider" usage:"start when the openid provider cannot be reached, serving the whitelisted resources while the discovery is retried in the background" env:"ENABLE_START_WITHOUT_PROVIDER"`
+	// BaseURI is prepended to all the generated URIs
+	BaseURI string `json:"base-uri" yaml:"base-uri" usage:"common prefix for all URIs" env:"BASE_URI"`
+	// ExternalURL is the url of the service as seen by the clients, when mounted under a path by an outer proxy
+	ExternalURL string `json:"external-url" yaml:"external-url" usage:"url of the service as seen by the clients, e.g. https://example.com/myapp, setting the redirection url, the base uri and the path of the cookies" env:"EXTERNAL_URL"`
+	// EnableForwardedPrefix trusts the X-Forwarded-Prefix header of the outer proxy as the base uri
+	EnableForwardedPrefix bool `json:"enable-forwarded-prefix" yaml:"enable-forwarded-prefix" usage:"use the X-Forwarded-Prefix header set by the outer proxy as the base uri of the redirections" env:"ENABLE_FORWARDED_PREFIX"`
+	// OAuthURI is the uri for the oauth endpoints for the proxy
+	OAuthURI string `json:"oauth-uri" yaml:"oauth-uri" usage:"the uri for proxy oauth endpoints" env:"OAUTH_URI"`
+	// Scopes is a list of scope we should request
+	Scopes []string `json:"scopes" yaml:"scopes" usage:"list of scopes requested when authenticating the user"`
+	// Upstream is the upstream endpoint i.e whom were proxying to
+	Upstream string `json:"upstream-url" yaml:"upstream-url" usage:"url for the upstream endpoint you wish to proxy" env:"UPSTREAM_URL"`
+	// UpstreamURLs are other endpoints identical to the upstream, the requests being balanced over all of them
+	UpstreamURLs []string `json:"upstream-urls" yaml:"upstream-urls" usage:"other endpoints identical to the upstream url, the requests being balanced over all of them"`
+	// UpstreamBalancing is the strategy balancing the requests over the upstreams: round-robin or least-connections
+	UpstreamBalancing string `json:"upstream-balancing" yaml:"upstream-balancing" usage:"strategy balancing the requests over the upstream urls: round-robin (default) or least-connections" env:"UPSTREAM_BALANCING"`
+	// DNSResolver is the name server resolving the hosts of the openid provider and the upstreams
+	DNSResolver string `json:"dns-resolver" yaml:"dns-resolver" usage:"address of the name server resolving the hosts of the openid provider and the upstreams, e.g. 10.0.0.10:53" env:"DNS_RESOLVER"`
+	// DNSTimeout is the timeout of the queries resolving the hosts of the openid provider and the upstreams
+	DNSTimeout time.Duration `json:"dns-timeout" yaml:"dns-timeout" usage:"timeout of the queries resolving the hosts of the openid provider and the upstreams" env:"DNS_TIMEOUT"`
+	// HostAliases are static addresses of the hosts of the openid provider and the upstreams, resolved in place of the dns
+	HostAliases map[string]string `json:"host-aliases" yaml:"host-aliases" usage:"static addresses of the hosts of the openid provider and the upstreams, e.g. keycloak.example.com=10.0.0.5"`
+	// UpstreamH2C speaks HTTP/2 without TLS (h2c) to the upstreams, e.g. to proxy gRPC services
+	UpstreamH2C bool `json:"upstream-h2c" yaml:"upstream-h2c" usage:"speak HTTP/2 without TLS (h2c) to the upstreams, e.g. to proxy gRPC services" env:"UPSTREAM_H2C"`
+	// UpstreamProxy is the proxy for the connections to the upstreams
+	UpstreamProxy string `json:"upstream-proxy" yaml:"upstream-proxy" usage:"proxy for the connections to the upstreams, or 'environment' to honor HTTP_PROXY, HTTPS_PROXY and NO_PROXY" env:"UPSTREAM_PROXY"`
+	// UpstreamNoProxy are the upstream hosts reached without the proxy
+	UpstreamNoProxy []string `json:"upstream-no-proxy" yaml:"upstream-no-proxy" usage:"hosts, domains (.svc.cluster.local) or networks (10.0.0.0/8) of the upstreams reached without the proxy"`
+	// UpstreamCA is the path to a CA certificate in PEM format to validate the upstream certificate
+	UpstreamCA string `json:"upstream-ca" yaml:"upstream-ca" usage:"the path to a file container a CA certificate to validate the upstream tls endpoint" env:"UPSTREAM_CA"`
+	// Resources is a list of protected resources
+	Resources []*Resource `json:"resources" yaml:"resources" usage:"list of resources 'uri=/admin*|methods=GET,PUT|roles=role1,role2'"`
+	// Headers permits adding customs headers across the board
+	Headers map[string]string `json:"headers" yaml:"headers" usage:"custom headers to the upstream request, key=value"`
+	// PreserveHost preserves the host header of the proxied request in the upstream request. Disabled by default.
+	PreserveHost bool `json:"preserve-host" yaml:"preserve-host" usage:"preserve the host header of the proxied request in the upstream request. Disabled by default" env:"PRESERVE_HOST"`
+	// RequestIDHeader is the header name for request ids
+	RequestIDHeader string `json:"request-id-header" yaml:"request-id-header" usage:"the http header name for request id" env:"REQUEST_ID_HEADER"`
+	// ResponseHeader is a map of response headers to add to the response
+	ResponseHeaders map[string]string `json:"response-headers" yaml:"response-headers" usage:"custom headers to be added to the http response key=value"`
+	// StripResponseHeaders are the headers removed from the upstream responses
+	StripResponseHeaders []string `json:"strip-response-headers" yaml:"strip-response-headers" usage:"headers removed from the upstream responses, a trailing wildcard matching a prefix (e.g. Server, X-Powered-By, Access-Control-*)"`
+	// OverrideResponseHeaders are the headers replacing the values set by the upstream responses
+	OverrideResponseHeaders map[string]string `json:"override-response-headers" yaml:"override-response-headers" usage:"headers replacing the values set by the upstream responses, an empty value removing the header, key=value"`
+	// ResponseBodyRewrites are the substitutions in the bodies of the upstream responses
+	ResponseBodyRewrites []*BodyRewrite `json:"response-body-rewrites" yaml:"response-body-rewrites" usage:"substitutions in the bodies of the upstream responses, e.g. 'match=http://backend:8080|replace=https://app.example.com' or 'regex=...|replace=...'"`
+	// ResponseBodyRewriteTypes are the media types of the rewritten response bodies
+	ResponseBodyRewriteTypes []string `json:"response-body-rewrite-types" yaml:"response-body-rewrite-types" usage:"media types of the response bodies rewritten, a trailing wildcard matching a prefix (e.g. text/*)"`
+	// ResponseBodyRewriteMaxSize is the size of the largest response body rewritten
+	ResponseBodyRewriteMaxSize int `json:"response-body-rewrite-max-size" yaml:"response-body-rewrite-max-size" usage:"the size in bytes of the largest response body rewritten, the larger ones being passed unmodified" env:"RESPONSE_BODY_REWRITE_MAX_SIZE"`
+	// CoalesceMaxSize is the size of the largest response body shared by coalesced requests
+	CoalesceMaxSize int `json:"coalesce-max-size" yaml:"coalesce-max-size" usage:"the size in bytes of the largest response body shared by the coalesced requests, the requests waiting for a larger one making their own round trip" env:"COALESCE_MAX_SIZE"`
+	// DecompressMaxSize is the size of the largest request body decompressed for the resources with decompress
+	DecompressMaxSize int `json:"decompress-max-size" yaml:"decompress-max-size" usage:"the size in bytes of the largest request body decompressed for the resources with decompress, the larger ones being refused" env:"DECOMPRESS_MAX_SIZE"`
+	// ResponseCookieDomain is the domain rewritten in the cookies set by the upstream responses
+	ResponseCookieDomain string `json:"response-cookie-domain" yaml:"response-cookie-domain" usage:"domain rewritten in the cookies set by the upstream responses, '-' removing the domain attribute" env:"RESPONSE_COOKIE_DOMAIN"`
+
+	// EnableSelfSignedTLS indicates we should create a self-signed certificate for the service
+	EnabledSelfSignedTLS bool `json:"enable-self-signed-tls" yaml:"enable-self-signed-tls" usage:"create self signed certificates for the proxy" env:"ENABLE_SELF_SIGNED_TLS"`
+	// SelfSignedTLSHostnames is the list of hostnames to place on the certificate
+	SelfSignedTLSHostnames []string `json:"self-signed-tls-hostnames" yaml:"self-signed-tls-hostnames" usage:"a list of hostnames to place on the self-signed certificate"`
+	// SelfSignedTLSExpiration is the expiration time of the tls certificate before rotation occurs
+	SelfSignedTLSExpiration time.Duration `json:"self-signed-tls-expiration" yaml:"self-signed-tls-expiration" usage:"the expiration of the certificate before rotation"`
+
+	// EnableRequestID indicates the proxy should add request id if none if found
+	EnableRequestID bool `json:"enable-request-id" yaml:"enable-request-id" usage:"indicates we should add a request id if none found" env:"ENABLE_REQUEST_ID"`
+	// LogoutWebhooks is a list of upstream endpoints notified of user logouts
+	LogoutWebhooks []string `json:"logout-webhooks" yaml:"logout-webhooks" usage:"list of urls notified with a POST of the subject and session id whenever a user logs out"`
+	// LogoutWebhookTimeout is the timeout of a logout notification
+	LogoutWebhookTimeout time.Duration `json:"logout-webhook-timeout" yaml:"logout-webhook-timeout" usage:"timeout of the POST notifying a logout to the webhooks"`
+	// LogoutWebhookSecret is the shared secret signing the logout notifications
+	LogoutWebhookSecret string `json:"logout-webhook-secret" yaml:"logout-webhook-secret" usage:"shared secret signing the logout notifications with an HMAC-SHA256 of their timestamp and body, sent in the X-Auth-Signature header" env:"LOGOUT_WEBHOOK_SECRET"`
+	// EnableLogoutRedirect indicates we should redirect to the identity provider for logging out
+	EnableLogoutRedirect bool `json:"enable-logout-redirect" yaml:"enable-logout-redirect" usage:"indicates we should redirect to the identity provider for logging out"`
+	// EnableDefaultDeny indicates we should deny by default all requests
+	EnableDefaultDeny bool `json:"enable-default-deny" yaml:"enable-default-deny" usage:"enables a default denial on all requests, you have to explicitly say what is permitted (recommended)" env:"ENABLE_DEFAULT_DENY"`
+	// EnableDefaultNotFound: makes explicit resources routing mandatory (i.e. responds with 404 NotFound, even if authenticated)
+	EnableDefaultNotFound bool `json:"enable-default-notfound" yaml:"enable-default-notfound" usage:"makes explicit resources routing mandatory (i.e. responds with 404 NotFound, even if authenticated)" env:"ENABLE_DEFAULT_NOTFOUND"`
+	// EnableMethodOverride routes POST requests with the method of the X-HTTP-Method-Override header
+	EnableMethodOverride bool `json:"enable-method-override" yaml:"enable-method-override" usage:"routes and proxies POST requests with the method of the X-HTTP-Method-Override header, e.g. for clients unable to send WebDAV methods" env:"ENABLE_METHOD_OVERRIDE"`
+	// EnableEncryptedToken indicates the access token should be encoded
+	EnableEncryptedToken bool `json:"enable-encrypted-token" yaml:"enable-encrypted-token" usage:"enable encryption for the access tokens"`
+	// ForceEncryptedCookie indicates that the access token in the cookie should be encoded, regardless what EnableEncryptedToken says. This way, gatekeeper may receive tokens in header in the clear, whereas tokens in cookies remain encrypted
+	ForceEncryptedCookie bool `json:"force-encrypted-cookie" yaml:"force-encrypted-cookie" usage:"force encryption for the access tokens in cookies"`
+	// EnableCookieCompression indicates the encrypted tokens in cookies are compressed before encryption (default: true)
+	EnableCookieCompression bool `json:"enable-cookie-compression" yaml:"enable-cookie-compression" usage:"compress the encrypted tokens in cookies, keeping them under the cookie size limit (enabled by default)" env:"ENABLE_COOKIE_COMPRESSION"`
+	// EnableLogging indicates if we should log all the requests
+	EnableLogging bool `json:"enable-logging" yaml:"enable-logging" usage:"enable http logging of the requests"`
+	// EnableJSONLogging is the logging format
+	EnableJSONLogging bool `json:"enable-json-logging" yaml:"enable-json-logging" usage:"switch on json logging rather than text"`
+	// EnableForwarding enables the forwarding proxy
+	EnableForwarding bool `json:"enable-forwarding" yaml:"enable-forwarding" usage:"enables the forwarding proxy mode, signing outbound request"`
+	// EnableSecurityFilter enables the security handler
+	EnableSecurityFilter bool `json:"enable-security-filter" yaml:"enable-security-filter" usage:"enables the security filter handler" env:"ENABLE_SECURITY_FILTER"`
+	// EnableRefreshTokens indicate's you wish to ignore using refresh tokens and re-auth on expiration of access token
+	EnableRefreshTokens bool `json:"enable-refresh-tokens" yaml:"enable-refresh-tokens" usage:"enables the handling of the refresh tokens" env:"ENABLE_REFRESH_TOKEN"`
+	// RefreshTokenCookieKey is the key encrypting the refresh tokens, independently of the encryption key
+	RefreshTokenCookieKey string `json:"refresh-token-cookie-key" yaml:"refresh-token-cookie-key" usage:"key encrypting the refresh tokens in their cookie or store, independently of the encryption key (16 or 32 characters). Defaults to the encryption key" env:"REFRESH_TOKEN_COOKIE_KEY"`
+	// RefreshTokenCookieDuration is the lifetime of the refresh token cookie, regardless of the session cookies
+	RefreshTokenCookieDuration time.Duration `json:"refresh-token-cookie-duration" yaml:"refresh-token-cookie-duration" usage:"lifetime of the refresh token cookie, kept across the restarts of the browser even with session cookies. Defaults to the expiration of the refresh token" env:"REFRESH_TOKEN_COOKIE_DURATION"`
+	// EnableSilentRenewal indicates the silent session renewal endpoint, performing a prompt=none authorization, is enabled
+	EnableSilentRenewal bool `json:"enable-silent-renewal" yaml:"enable-silent-renewal" usage:"enables the silent renewal endpoint, which renews the session without any user interaction when the sso session is still valid (e.g. from a hidden iframe)"`
+	// EnableSessionCookies indicates the cookies, both token and refresh should not be persisted
+	EnableSessionCookies bool `json:"enable-session-cookies" yaml:"enable-session-cookies" usage:"access and refresh tokens are session only i.e. removed browser close" env:"ENABLE_SESSION_COOKIES"`
+	// EnableStrictState accepts a callback once, and only after an authorization request issued to the client
+	EnableStrictState bool `json:"enable-strict-state" yaml:"enable-strict-state" usage:"accepts an oauth callback only once, and only when it follows an authorization request issued to the client (requires the encryption key)" env:"ENABLE_STRICT_STATE"`
+	// StateExpiration is the time allowed to authenticate on the provider, with a strict state
+	StateExpiration time.Duration `json:"state-expiration" yaml:"state-expiration" usage:"time allowed to complete an authorization on the provider, when the state is strictly validated"`
+	// ResponseMode is the mode of the authorization response of the provider: query or form_post
+	ResponseMode string `json:"response-mode" yaml:"response-mode" usage:"how the provider returns the authorization response to the callback: query (redirection with the code and state in the url) or form_post (form posted to the callback, for long responses and some broker configurations)" env:"RESPONSE_MODE"`
+	// EnableCallbackInterstitial replays from an interstitial page the callbacks arriving without cookies
+	EnableCallbackInterstitial bool `json:"enable-callback-interstitial" yaml:"enable-callback-interstitial" usage:"when the oauth callback arrives without the cookies withheld by the browser from the cross-site redirection (SameSite policy, embedded contexts), replays it once from an auto-submitting page of the proxy" env:"ENABLE_CALLBACK_INTERSTITIAL"`
+	// EnableWellKnown publishes the discovery of the provider, with its endpoints rewritten to the proxy
+	EnableWellKnown bool `json:"enable-well-known" yaml:"enable-well-known" usage:"serves the openid configuration and the signing keys of the provider, with the endpoints pointing at the proxy" env:"ENABLE_WELL_KNOWN"`
+	// WellKnownCacheDuration is the time the documents of the provider are cached
+	WellKnownCacheDuration time.Duration `json:"well-known-cache-duration" yaml:"well-known-cache-duration" usage:"duration the openid configuration and the signing keys of the provider are cached"`
+	// EnableCSRF will generate a new session object (e.g.a cookie, or in a supported backend storage) to store a CSRF token.
+	// To enable CSRF on upstream endpoints, an additional EnableCSRF is needed in the Resource config section.
+	EnableCSRF bool `json:"enable-csrf" yaml:"enable-csrf" usage:"when enabled, this automatically adds a CSRF token to all responses. Matching token expected for next request is stored in the session (e.g. cookie or storage)" env:"ENABLE_CSRF"`
+	// CSRFCookieName sets the name of the CSRF (encrypted) cookie, when session storage is a cookie (defaults to kc-csrf).
+	// Note that in this case EncryptionKey is required to encrypt the cookie.
+	CSRFCookieName string `json:"csrf-cookie-name" yaml:"csrf-cookie-name" usage:"the name of CSRF cookie. Defaults to: kc-csrf" env:"CSRF_COOKIE_NAME"`
+	// CSRFHeader sets the header used in requests and response for the CSRF challenge (defaults to X-CSRF-Token)
+	CSRFHeader string `json:"csrf-header" yaml:"csrf-header" usage:"the header added to responses by gatekeeper and to be added by requests to check against replayed credentials (CSRF). Defaults to: X-CSRF-Token" env:"CSRF_HEADER"`
+	// CSRFMode selects the CSRF protection: an encrypted session cookie (session), a stateless double-submit cookie (double-submit)
+	// or a secret in the shared store (store)
+	CSRFMode string `json:"csrf-mode" yaml:"csrf-mode" usage:"the CSRF protection mode: session (encrypted CSRF session cookie), double-submit (stateless HMAC over the user session and an expiry, no server affinity required) or store (secret kept in the shared store, keyed by the user session). Defaults to: session" env:"CSRF_MODE"`
+	// CSRFTokenDuration is the validity of a double-submit or stored CSRF token
+	CSRFTokenDuration time.Duration `json:"csrf-token-duration" yaml:"csrf-token-duration" usage:"the validity of a double-submit or stored CSRF token. Defaults to: 12h"`
+	// TokenCacheSize is the number of verified access tokens kept in memory
+	TokenCacheSize int `json:"token-cache-size" yaml:"token-cache-size" usage:"number of verified access tokens kept in memory until they expire, skipping repeated decoding and signature checks. Disabled when 0"`
+	// SessionValidationInterval is the interval at which sessions are validated against the provider
+	SessionValidationInterval time.Duration `json:"session-validation-interval" yaml:"session-validation-interval" usage:"interval at which sessions are validated online against the provider userinfo endpoint, terminating sessions killed on the provider. Disabled when 0"`
+	// EnableNotBeforePush indicates we accept not-before policies pushed by keycloak, shared through the store if any
+	EnableNotBeforePush bool `json:"enable-not-before-push" yaml:"enable-not-before-push" usage:"accepts not-before policies pushed by keycloak on the client admin url (the oauth uri), rejecting tokens issued earlier, e.g. after all sessions are revoked"`
+	// EnableBackChannelLogout indicates we accept the logout tokens posted by the provider
+	EnableBackChannelLogout bool `json:"enable-back-channel-logout" yaml:"enable-back-channel-logout" usage:"accepts the openid connect back-channel logout tokens posted by the provider on the oauth uri (/oauth/backchannel-logout), revoking the sessions logged out of the provider. Requires a store" env:"ENABLE_BACK_CHANNEL_LOGOUT"`
+	// DisabledEndpoints is a list of oauth endpoints which are not served
+	DisabledEndpoints []string `json:"disabled-endpoints" yaml:"disabled-endpoints" usage:"list of oauth endpoints which are not served and respond 404, e.g. login, token, expired"`
+	// EnableLoginHandler indicates we want the login handler enabled
+	EnableLoginHandler bool `json:"enable-login-handler" yaml:"enable-login-handler" usage:"enables the handling of the refresh tokens" env:"ENABLE_LOGIN_HANDLER"`
+	// EnableTokenHeader adds the JWT token to the upstream authentication headers as X-Auth-Token header
+	EnableTokenHeader bool `json:"enable-token-header" yaml:"enable-token-header" usage:"enables the token authentication header X-Auth-Token to upstream" env:"ENABLE_TOKEN_HEADER"`
+	// EnableClaimsHeaders adds decoded claims as headers X-Auth-{claim} to the upstream endpoint
+	EnableClaimsHeaders bool `json:"enable-claims-headers" yaml:"enable-claims-headers" usage:"adds decoded claims as headers X-Auth-{claim} to the upstream endpoint. Defaults to true" env:"ENABLE_CLAIMS_HEADERS"`
+	// EnableAuthorizationHeader indicates we should pass the authorization header to the upstream endpoint
+	EnableAuthorizationHeader bool `json:"enable-authorization-header" yaml:"enable-authorization-header" usage:"adds the authorization header to the proxy request" env:"ENABLE_AUTHORIZATION_HEADER"`
+	// UpstreamTokenCookie is the name of a cookie carrying the access token to the upstream endpoint
+	UpstreamTokenCookie string `json:"upstream-token-cookie" yaml:"upstream-token-cookie" usage:"name of a cookie carrying the access token to the upstream, for the upstreams reading cookies only" env:"UPSTREAM_TOKEN_COOKIE"`
+	// UpstreamTokenCookieKey is the key shared with the upstream to encrypt the access token of the upstream cookie
+	UpstreamTokenCookieKey string `json:"upstream-token-cookie-key" yaml:"upstream-token-cookie-key" usage:"key shared with the upstream to encrypt the access token of the upstream cookie (AES-GCM, 16 or 32 characters). Passed in clear when empty" env:"UPSTREAM_TOKEN_COOKIE_KEY"`
+	// EnableAuthorizationCookies indicates we should pass the authorization cookies to the upstream endpoint. Defaults to false.
+	EnableAuthorizationCookies bool `json:"enable-authorization-cookies" yaml:"enable-authorization-cookies" usage:"adds the authorization cookies to the uptream proxy request. Defaults to false" env:"ENABLE_AUTHORIZATION_COOKIES"`
+	// EnableHTTPSRedirect indicate we should redirect http -> https
+	EnableHTTPSRedirect bool `json:"enable-https-redirection" yaml:"enable-https-redirection" usage:"enable the http to https redirection on the http service"`
+	// EnableProfiling indicates if profiles is switched on
+	EnableProfiling bool `json:"enable-profiling" yaml:"enable-profiling" usage:"switching on the golang profiling via pprof on /debug/pprof, /debug/pprof/heap etc" env:"ENABLE_PROFILING"`
+	// ProfilingToken is a bearer token required to access the profiling endpoints
+	ProfilingToken string `json:"profiling-token" yaml:"profiling-token" usage:"bearer token required in the Authorization header to access the profiling endpoints" env:"PROFILING_TOKEN"`
+	// ProfilingRateLimit is the maximum number of requests per minute to the profiling endpoints
+	ProfilingRateLimit int `json:"profiling-rate-limit" yaml:"profiling-rate-limit" usage:"maximum number of requests per minute to the profiling endpoints. Unlimited when 0" env:"PROFILING_RATE_LIMIT"`
+	// ProfilingDuration is the time after startup when the profiling endpoints are disabled
+	ProfilingDuration time.Duration `json:"profiling-duration" yaml:"profiling-duration" usage:"disables the profiling endpoints after this duration since startup. Never disabled when 0" env:"PROFILING_DURATION"`
+	// ProfilesDir is the directory the profiles are written to on SIGUSR1
+	ProfilesDir string `json:"profiles-dir" yaml:"profiles-dir" usage:"directory to write the goroutine, heap and mutex profiles to on SIGUSR1. A goroutine dump is logged when empty" env:"PROFILES_DIR"`
+	// DebugCaptureRate is the percentage of the requests logged on the resources in debug capture mode
+	DebugCaptureRate int `json:"debug-capture-rate" yaml:"debug-capture-rate" usage:"percentage of the requests to the resources in debug capture mode logged with their headers and bodies" env:"DEBUG_CAPTURE_RATE"`
+	// DebugCaptureMaxBody is the number of bytes of the bodies logged in debug capture mode
+	DebugCaptureMaxBody int `json:"debug-capture-max-body" yaml:"debug-capture-max-body" usage:"maximum number of bytes of the request and response bodies logged in debug capture mode" env:"DEBUG_CAPTURE_MAX_BODY"`
+	// DebugCaptureRedactions are the fragments of the names of the headers and body fields redacted in debug capture mode
+	DebugCaptureRedactions []string `json:"debug-capture-redactions" yaml:"debug-capture-redactions" usage:"fragments of the names of the headers and body fields redacted in debug capture mode, case insensitive"`
+	// EnableMetrics indicates if the metrics is enabled (default: true)
+	EnableMetrics bool `json:"enable-metrics" yaml:"enable-metrics" usage:"enable the prometheus metrics collector on /oauth/metrics (enabled by default)" env:"ENABLE_METRICS"`
+	// EnableHealthDependencies indicates the health endpoint reports the status of the dependencies
+	EnableHealthDependencies bool `json:"enable-health-dependencies" yaml:"enable-health-dependencies" usage:"reports the status of the provider, store and upstreams on /oauth/health" env:"ENABLE_HEALTH_DEPENDENCIES"`
+	// TracingExporter defines the exporter for traces. Default is jaeger.
+	TracingExporter string `json:"tracing-exporter" yaml:"tracing-exporter" usage:"select tracing exporter (jaeger|datadog). Default is jaeger"`
+	// EnableTracing indicates if a tracing exporter is enabled
+	EnableTracing bool `json:"enable-tracing" yaml:"enable-tracing" usage:"enable the opencensus trace collector on /oauth/zpages" env:"ENABLE_TRACING"`
+	// TracingAgentEndpoint register the jaeger agent collecting trace spans
+	TracingAgentEndpoint string `json:"tracing-agent-endpoint" yaml:"tracing-agent-endpoint" usage:"register the opencensus trace collector agent" env:"TRACING_AGENT_ENDPOINT"`
+	// EnableBrowserXSSFilter indicates you want the filter on
+	EnableBrowserXSSFilter bool `json:"filter-browser-xss" yaml:"filter-browser-xss" usage:"enable the adds the X-XSS-Protection header with mode=block"`
+	// EnableContentNoSniff indicates you want the filter on
+	EnableContentNoSniff bool `json:"filter-content-nosniff" yaml:"filter-content-nosniff" usage:"adds the X-Content-Type-Options header with the value nosniff"`
+	// EnableFrameDeny indicates the filter is on
+	EnableFrameDeny bool `json:"filter-frame-deny" yaml:"filter-frame-deny" usage:"enable to the frame deny header"`
+	// ContentSecurityPolicy allows the Content-Security-Policy header value to be set with a custom value
+	ContentSecurityPolicy string `json:"content-security-policy" yaml:"content-security-policy" usage:"specify the content security policy"`
+	// EnableSTS adds the X-Transport-Strict-Transport-Security with some sensible default seconds and subdomains allowed (no STS preload)
+	EnableSTS bool `json:"filter-sts" yaml:"filter-sts" usage:"adds the X-Transport-Strict-Transport-Security header, without the preload option"`
+	// EnableSTSPreload adds the X-Transport-Strict-Transport-Security with some sensible default seconds and subdomains allowed (with STS preload)
+	EnableSTSPreload bool `json:"filter-sts-preload" yaml:"filter-sts-preload" usage:"adds the X-Transport-Strict-Transport-Security header (with STS preload)"`
+	// LocalhostMetrics indicates that metrics can only be consumed from localhost
+	LocalhostMetrics bool `json:"localhost-metrics" yaml:"localhost-metrics" usage:"enforces the metrics page can only been requested from 127.0.0.1"`
+
+	// AccessTokenDuration is default duration applied to the access token cookie
+	AccessTokenDuration time.Duration `json:"access-token-duration" yaml:"access-token-duration" usage:"fallback cookie duration for the access token when using refresh tokens"`
+	// CookieDomain is a list of domains the cookie is available to
+	CookieDomain string `json:"cookie-domain" yaml:"cookie-domain" usage:"domain the access cookie is available to, defaults host header" env:"COOKIE_DOMAIN"`
+	// EnableCrossSubdomainSession indicates the session is shared by all subdomains of the cookie domain
+	EnableCrossSubdomainSession bool `json:"enable-cross-subdomain-session" yaml:"enable-cross-subdomain-session" usage:"shares one session across the subdomains of the cookie domain, and lands back on the originating host after authentication"`
+	// CookieAccessName is the name of the access cookie holding the access token
+	CookieAccessName string `json:"cookie-access-name" yaml:"cookie-access-name" usage:"name of the cookie use to hold the access token"`
+	// CookieRefreshName is the name of the refresh cookie
+	CookieRefreshName string `json:"cookie-refresh-name" yaml:"cookie-refresh-name" usage:"name of the cookie used to hold the encrypted refresh token"`
+	// CookiePath is the path of the access and refresh cookies
+	CookiePath string `json:"cookie-path" yaml:"cookie-path" usage:"path of the access and refresh cookies, defaults to the base uri with an external url, or /" env:"COOKIE_PATH"`
+	// SameSiteCookie enforces cookies to be send only to same site requests. Defaults to Lax.
+	SameSiteCookie string `json:"same-site-cookie" yaml:"same-site-cookie" usage:"enforces cookies to be send only to same site requests according to the policy (can be Strict|Lax|None). Defaults to Lax" env:"SAME_SITE_COOKIE"`
+	// SecureCookie enforces the cookie as secure. Defaults to true.
+	SecureCookie bool `json:"secure-cookie" yaml:"secure-cookie" usage:"enforces the cookie to be secure. Defaults to true." env:"SECURE_COOKIE"`
+	// HTTPOnlyCookie enforces the cookie as http only. Defaults to true.
+	HTTPOnlyCookie bool `json:"http-only-cookie" yaml:"http-only-cookie" usage:"enforces the cookie is in http only mode. Defaults to true" env:"HTTP_ONLY_COOKIE"`
+	// MatchClaims is a series of checks, the claims in the token must match those here
+	MatchClaims map[string]string `json:"match-claims" yaml:"match-claims" usage:"keypair values for matching access token claims e.g. aud=myapp, iss=http://example.*"`
+	// OPAURL is the data API of an Open Policy Agent, queried for the admission of the authenticated requests
+	OPAURL string `json:"opa-url" yaml:"opa-url" usage:"url of an open policy agent decision queried with the claims, method and path of the authenticated requests, e.g. http://localhost:8181/v1/data/gatekeeper/allow" env:"OPA_URL"`
+	// OPATimeout is the timeout of the policy decisions
+	OPATimeout time.Duration `json:"opa-timeout" yaml:"opa-timeout" usage:"timeout of the open policy agent decisions" env:"OPA_TIMEOUT"`
+	// EnableUMA enforces the permissions of the keycloak authorization services on the authenticated requests
+	EnableUMA bool `json:"enable-uma" yaml:"enable-uma" usage:"enforces the permissions of the keycloak authorization services (uma) on the authenticated requests, asking the provider for a decision on the resource and method of each request" env:"ENABLE_UMA"`
+	// Provider selects the conventions of the identity provider
+	Provider string `json:"provider" yaml:"provider" usage:"conventions of the identity provider: keycloak, oidc, azure-ad, okta or auth0 (default: keycloak)" env:"PROVIDER"`
+	// AllowedTenants are the tenants admitted by a multi-tenant issuer, e.g. the common endpoint of azure-ad
+	AllowedTenants []string `json:"allowed-tenants" yaml:"allowed-tenants" usage:"tenants admitted by a multi-tenant issuer, e.g. the common endpoint of azure-ad, the tokens of the other tenants being refused"`
+	// RoleClaims are the claims holding the realm roles of the user
+	RoleClaims []string `json:"role-claims" yaml:"role-claims" usage:"claims holding the roles of the user, as names or dotted paths to nested claims (default: realm_access.roles for keycloak, roles otherwise)"`
+	// ClientRolesClaim is the claim holding the roles of the user per client
+	ClientRolesClaim string `json:"client-roles-claim" yaml:"client-roles-claim" usage:"claim holding the roles of the user per client, under a roles claim (default: resource_access)" env:"CLIENT_ROLES_CLAIM"`
+	// GroupClaims are the claims holding the groups of the user
+	GroupClaims []string `json:"group-claims" yaml:"group-claims" usage:"claims holding the groups of the user, as names or dotted paths to nested claims (default: groups)"`
+	// Plugins is the ordered list of compiled-in plugins processing the requests
+	Plugins []string `json:"plugins" yaml:"plugins" usage:"list of registered plugins processing the requests, applied in order"`
+	// ScriptTimeout is the time limit of the evaluation of a resource script on a request
+	ScriptTimeout time.Duration `json:"script-timeout" yaml:"script-timeout" usage:"time limit of the evaluation of a resource script on a request" env:"SCRIPT_TIMEOUT"`
+	// AddClaims is a series of claims that should be added to the auth headers
+	AddClaims []string `json:"add-claims" yaml:"add-claims" usage:"extra claims from the token and inject into headers, e.g given_name -> X-Auth-Given-Name"`
+
+	// TLSCertificate is the location for a tls certificate
+	TLSCertificate string `json:"tls-cert" yaml:"tls-cert" usage:"path to ths TLS certificate" env:"TLS_CERTIFICATE"`
+	// TLSPrivateKey is the location of a tls private key
+	TLSPrivateKey string `json:"tls-private-key" yaml:"tls-private-key" usage:"path to the private key for TLS" env:"TLS_PRIVATE_KEY"`
+	// TLSCaCertificate is the CA certificate which the client cert must be signed
+	TLSCaCertificate string `json:"tls-ca-certificate" yaml:"tls-ca-certificate" usage:"path to the ca certificate used for signing requests" env:"TLS_CA_CERTIFICATE"`
+	// TLSCaPrivateKey is the CA private key used for signing
+	TLSCaPrivateKey string `json:"tls-ca-key" yaml:"tls-ca-key" usage:"path the ca private key, used by the forward signing proxy" env:"TLS_CA_PRIVATE_KEY"`
+	// TLSClientCertificate is path to a client certificate to use for outbound connections
+	TLSClientCertificate string `json:"tls-client-certificate" yaml:"tls-client-certificate" usage:"path to the client certificate for outbound connections in reverse and forwarding proxy modes" env:"TLS_CLIENT_CERTIFICATE"`
+	// TLSClientCertificates is an array of paths to client certificates to use for outbound connections
+	TLSClientCertificates []string `json:"tls-client-certificates" yaml:"tls-client-certificates" usage:"paths to client certificates for outbound connections in reverse and forwarding proxy modes" env:"TLS_CLIENT_CERTIFICATES"`
+	// TLSClientAuth is the verification of the client certificates presented to the proxy listener
+	TLSClientAuth string `json:"tls-client-auth" yaml:"tls-client-auth" usage:"the verification of the client certificates on the proxy listener with tls-client-certificate(s), either require or verify-if-given. Defaults to require" env:"TLS_CLIENT_AUTH"`
+	// EnableClientCertHeaders adds the subject, alternative names and fingerprint of the verified client certificate to the upstream requests
+	EnableClientCertHeaders bool `json:"enable-client-cert-headers" yaml:"enable-client-cert-headers" usage:"adds the X-Client-Cert-Subject, X-Client-Cert-Sans and X-Client-Cert-Fingerprint headers of the verified client certificate to the upstream requests" env:"ENABLE_CLIENT_CERT_HEADERS"`
+	// TLSUseModernSettings sets all TLS options for proxy listener to modern settings (TLS 1.2, advanced cipher suites, ...)
+	TLSUseModernSettings bool `json:"tls-use-modern-settings" yaml:"tls-use-modern-settings" usage:"sets all TLS options for proxy listener to modern settings (TLS 1.2, advanced cipher suites, ...)" env:"TLS_USE_MODERN_SETTINGS"`
+	// TLSMinVersion is the minimum TLS protocol version accepted by proxy listener. TLS 1.0 is the default.
+	TLSMinVersion string `json:"tls-min-version" yaml:"tls-min-version" usage:"the minimum TLS protocol version accepted by proxy listener. Accepted values are: SSL3.0, TLS1.0, TLS1.1, TLS1.2. TLS1.0 is the default" env:"TLS_MIN_VERSION"`
+	// TLSCipherSuites is the list of cipher suites accepted by server during TLS negotiation. Defaults to golang TLS supported suites.
+	TLSCipherSuites []string `json:"tls-cipher-suites" yaml:"tls-cipher-suites" usage:"the list of cipher suites accepted by server during TLS negotiation. Defaults to golang TLS supported suites" env:"TLS_CIPHER_SUITES"`
+	// TLSPreferServerCipherSuites indicates the TLS negotiation prefers server cipher suites
+	TLSPreferServerCipherSuites bool `json:"tls-prefer-server-cipher-suites" yaml:"tls-prefer-server-cipher-suites" usage:"indicates the TLS negotiation prefers server cipher suites" env:"TLS_PREFER_SERVER_CIPHER_SUITES"`
+	// TLSCurvePreferences indicate the server preferred cipher curves
+	TLSCurvePreferences []string `json:"tls-curve-preferences" yaml:"tls-curve-preferences" usage:"the server preferred cipher curves" env:"TLS_CURVE_PREFERENCES"`
+	// SkipUpstreamTLSVerify skips the verification of any upstream tls
+	SkipUpstreamTLSVerify bool `json:"skip-upstream-tls-verify" yaml:"skip-upstream-tls-verify" usage:"skip the verification of any upstream TLS" env:"SKIP_UPSTREAM_TLS_VERIFY"`
+
+	// TLSAdminCertificate is the location for a tls certificate for admin https endpoint. Defaults to TLSCertificate.
+	TLSAdminCertificate string `json:"tls-admin-cert" yaml:"tls-admin-cert" usage:"path to ths TLS certificate" env:"TLS_ADMIN_CERTIFICATE"`
+	// TLSAdminPrivateKey is the location of a tls private key for admin https endpoint. Defaults to TLSPrivateKey
+	TLSAdminPrivateKey string `json:"tls-admin-private-key" yaml:"tls-admin-private-key" usage:"path to the private key for TLS" env:"TLS_ADMIN_PRIVATE_KEY"`
+	// TLSCaCertificate is the CA certificate which the client cert must be signed
+	TLSAdminCaCertificate string `json:"tls-admin-ca-certificate" yaml:"tls-admin-ca-certificate" usage:"path to the ca certificate used for signing requests" env:"TLS_ADMIN_CA_CERTIFICATE"`
+	// TLSAdminClientCertificate is path to a client certificate to use for admin endpoint
+	TLSAdminClientCertificate string `json:"tls-admin-client-certificate" yaml:"tls-admin-client-certificate" usage:"path to the client certificate for admin endpoint" env:"TLS_ADMIN_CLIENT_CERTIFICATE"`
+	// TLSAdminClientCertificates is an array of paths to client certificates to use for admin endpoint
+	TLSAdminClientCertificates []string `json:"tls-admin-client-certificates" yaml:"tls-admin-client-certificates" usage:"paths to client certificates for admin endpoint" env:"TLS_ADMIN_CLIENT_CERTIFICATES"`
+
+	// CorsOrigins is a list of origins permitted
+	CorsOrigins []string `json:"cors-origins" yaml:"cors-origins" usage:"origins to add to the CORE origins control (Access-Control-Allow-Origin), either exact, with a wildcard subdomain (e.g. https://*.example.com) or a regex prefixed by regex:"`
+	// CorsMethods is a set of access control methods
+	CorsMethods []string `json:"cors-methods" yaml:"cors-methods" usage:"methods permitted in the access control (Access-Control-Allow-Methods)"`
+	// CorsHeaders is a set of cors headers
+	CorsHeaders []string `json:"cors-headers" yaml:"cors-headers" usage:"set of headers to add to the CORS access control (Access-Control-Allow-Headers)"`
+	// CorsExposedHeaders are the exposed header fields
+	CorsExposedHeaders []string `json:"cors-exposed-headers" yaml:"cors-exposed-headers" usage:"expose cors headers access control (Access-Control-Expose-Headers)"`
+	// CorsCredentials set the credentials flag
+	CorsCredentials bool `json:"cors-credentials" yaml:"cors-credentials" usage:"credentials access control header (Access-Control-Allow-Credentials)"`
+	// CorsMaxAge is the age for CORS
+	CorsMaxAge time.Duration `json:"cors-max-age" yaml:"cors-max-age" usage:"max age applied to cors headers (Access-Control-Max-Age)"`
+	// CorsDisableUpstream disables CORS headers prepared by the gatekeeper from the relayed upstream response (deprecated)
+	CorsDisableUpstream bool `json:"cors-disable-upstream" yaml:"cors-disable-upstream" usage:"Deprecated: do not extend CORS support to upstream responses: only gatekeeper endpoints are CORS-enabled"`
+
+	// Hostnames is a list of hostname's the service should response to
+	Hostnames []string `json:"hostnames" yaml:"hostnames" usage:"list of hostnames the service will respond to"`
+	// AllowedHosts is a list of accepted Host headers, checked before any authentication or proxying
+	AllowedHosts []string `json:"allowed-hosts" yaml:"allowed-hosts" usage:"list of accepted host headers, e.g. app.example.com, *.example.com or app.example.com:8443, other hosts are rejected (421)"`
+
+	// AllowedRedirectHosts is a list of hosts permitted as landing URL after authentication (e.g. from the request_uri cookie).
+	// Wildcard subdomains may be specified as *.example.com. When empty, absolute landing URLs may only point to the host of the request or of the redirection url.
+	AllowedRedirectHosts []string `json:"allowed-redirect-hosts" yaml:"allowed-redirect-hosts" usage:"list of hosts permitted in redirections after authentication, e.g. app.example.com, *.example.com"`
+	// AllowedRedirectSchemes is a list of URL schemes permitted as landing URL after authentication. Defaults to http and https.
+	AllowedRedirectSchemes []string `json:"allowed-redirect-schemes" yaml:"allowed-redirect-schemes" usage:"list of URL schemes permitted in redirections after authentication. Defaults to http, https"`
+
+	// Store is a url for a store resource, used to hold the refresh tokens
+	StoreURL string `json:"store-url" yaml:"store-url" usage:"url for the storage subsystem, e.g redis://127.0.0.1:6379, file:///etc/tokens.file"`
+	// StoreConnectTimeout is the maximum amount of time a connection to the store takes to be established
+	StoreConnectTimeout time.Duration `json:"store-connect-timeout" yaml:"store-connect-timeout" usage:"maximum amount of time a connection to the store takes to be established. Defaults to 5s" env:"STORE_CONNECT_TIMEOUT"`
+	// StoreKeepaliveTimeout is the keep-alive period of the connections to the store
+	StoreKeepaliveTimeout time.Duration `json:"store-keepalive-timeout" yaml:"store-keepalive-timeout" usage:"keep-alive period of the connections to the store. Defaults to 15s" env:"STORE_KEEPALIVE_TIMEOUT"`
+	// StoreFallbackDelay is the delay of the dual-stack fallback of the connections to the store
+	StoreFallbackDelay time.Duration `json:"store-fallback-delay" yaml:"store-fallback-delay" usage:"delay before connecting to the store over the other ip family of a dual-stack host. Defaults to 300ms, negative to disable" env:"STORE_FALLBACK_DELAY"`
+	// EnableServerSideTokens keeps the access tokens in the store, the access cookie holding an opaque handle only
+	EnableServerSideTokens bool `json:"enable-server-side-tokens" yaml:"enable-server-side-tokens" usage:"keeps the access tokens in the store, the access cookie holding an opaque session handle only (requires the store url and the encryption key)" env:"ENABLE_SERVER_SIDE_TOKENS"`
+
+	// EncryptionKey is the encryption key used to encrypt the refresh token
+	EncryptionKey string `json:"encryption-key" yaml:"encryption-key" usage:"encryption key used to encryption the session state" env:"ENCRYPTION_KEY"`
+
+	// InvalidAuthRedirectsWith303 will make requests with invalid auth headers redirect using HTTP 303 instead of HTTP 307.  See github.com/keycloak/keycloak-gatekeeper/issues/292 for context.
+	InvalidAuthRedirectsWith303 bool `json:"invalid-auth-redirects-with-303" yaml:"invalid-auth-redirects-with-303" usage:"use HTTP 303 redirects instead of 307 for invalid auth tokens"`
+	// NoRedirects informs we should hand back a 401 not a redirect
+	NoRedirects bool `json:"no-redirects" yaml:"no-redirects" usage:"do not have back redirects when no authentication is present, 401 them"`
+	// NoRedirectsOnUnsafeMethods informs we should hand back a 401 with a login hint, rather than a redirect, for
+	// unauthenticated requests with an unsafe method, since the request body would be lost by the redirection
+	NoRedirectsOnUnsafeMethods bool `json:"no-redirects-on-unsafe-methods" yaml:"no-redirects-on-unsafe-methods" usage:"do not redirect unauthenticated POST, PUT, PATCH or DELETE requests, 401 them with a login url hint to retry after authentication"`
+
+	// SkipTokenVerification tells the service to skip verifying the access token - for testing purposes
+	SkipTokenVerification bool `json:"skip-token-verification" yaml:"skip-token-verification" usage:"TESTING ONLY; bypass token verification, only expiration and roles enforced"`
+	// ClockSkewLeeway is the leeway for the clocks of the provider and the proxy when checking the time claims
+	ClockSkewLeeway time.Duration `json:"clock-skew-leeway" yaml:"clock-skew-leeway" usage:"leeway for the clocks of the provider and the proxy when checking the exp, iat and nbf claims of the tokens" env:"CLOCK_SKEW_LEEWAY"`
+
+	// UpstreamKeepalives specifies whether we use keepalives on the upstream
+	UpstreamKeepalives bool `json:"upstream-keepalives" yaml:"upstream-keepalives" usage:"enables or disables the keepalive connections for upstream endpoint"`
+	// UpstreamTimeout is the maximum amount of time a dial will wait for a connect to complete. Defaults to 10s
+	UpstreamTimeout time.Duration `json:"upstream-timeout" yaml:"upstream-timeout" usage:"maximum amount of time a dial will wait for a connect to complete. Defaults to 10s" env:"UPSTREAM_TIMEOUT"`
+	// UpstreamKeepaliveTimeout is the upstream keepalive timeout. Defaults to 10s
+	UpstreamKeepaliveTimeout time.Duration `json:"upstream-keepalive-timeout" yaml:"upstream-keepalive-timeout" usage:"specifies the keep-alive period for an active network connection. Defaults to 10s" env:"UPSTREAM_KEEPALIVE_TIMEOUT"`
+	// UpstreamIdleConnTimeout closes the keepalive connections to the upstreams idle for longer
+	UpstreamIdleConnTimeout time.Duration `json:"upstream-idle-connection-timeout" yaml:"upstream-idle-connection-timeout" usage:"closes the keepalive connections to the upstreams idle for longer. Unlimited when 0" env:"UPSTREAM_IDLE_CONNECTION_TIMEOUT"`
+	// UpstreamMaxConnLifetime retires the keepalive connections to the upstreams open for longer
+	UpstreamMaxConnLifetime time.Duration `json:"upstream-max-connection-lifetime" yaml:"upstream-max-connection-lifetime" usage:"closes the keepalive connections to the upstreams open for longer after their next response, so that they are spread again over the upstream instances, e.g. along rolling deployments. Unlimited when 0" env:"UPSTREAM_MAX_CONNECTION_LIFETIME"`
+	// UpstreamFallbackDelay is the delay of the dual-stack fallback of the connections to the upstreams
+	UpstreamFallbackDelay time.Duration `json:"upstream-fallback-delay" yaml:"upstream-fallback-delay" usage:"delay before connecting to an upstream over the other ip family of a dual-stack host. Defaults to 300ms, negative to disable" env:"UPSTREAM_FALLBACK_DELAY"`
+	// UpstreamTLSHandshakeTimeout is the timeout for upstream to tls handshake
+	UpstreamTLSHandshakeTimeout time.Duration `json:"upstream-tls-handshake-timeout" yaml:"upstream-tls-handshake-timeout" usage:"the timeout placed on the tls handshake for upstream"`
+	// UpstreamResponseHeaderTimeout is the timeout for upstream header response
+	UpstreamResponseHeaderTimeout time.Duration `json:"upstream-response-header-timeout" yaml:"upstream-response-header-timeout" usage:"the timeout placed on the response header for upstream"`
+	// RequestTimeout is the overall budget of the proxied requests, propagated as a deadline to the upstream request
+	RequestTimeout time.Duration `json:"request-timeout" yaml:"request-timeout" usage:"the overall budget of the proxied requests, after which the upstream request is canceled (504), except for streaming resources and upgraded connections" env:"REQUEST_TIMEOUT"`
+	// EnableRequestTimeoutHeader sends the remaining budget of the request to the upstream
+	EnableRequestTimeoutHeader bool `json:"enable-request-timeout-header" yaml:"enable-request-timeout-header" usage:"sends the remaining budget of the proxied requests to the upstream, in milliseconds, with the X-Request-Timeout header" env:"ENABLE_REQUEST_TIMEOUT_HEADER"`
+	// UpstreamFlushInterval is the interval between flushes of the upstream response to the client
+	UpstreamFlushInterval time.Duration `json:"upstream-flush-interval" yaml:"upstream-flush-interval" usage:"the interval between flushes of the upstream response to the client. Streamed responses of unknown length are always flushed immediately, a negative value flushes after each write"`
+	// UpstreamExpectContinueTimeout is the timeout expect continue for upstream
+	UpstreamExpectContinueTimeout time.Duration `json:"upstream-expect-continue-timeout" yaml:"upstream-expect-continue-timeout" usage:"the timeout placed on the expect continue for upstream"`
+
+	// Verbose switches on debug logging
+	Verbose bool `json:"verbose" yaml:"verbose" usage:"switch on debug / verbose logging"`
+	// EnableProxyProtocol controls the proxy protocol
+	EnableProxyProtocol bool `json:"enabled-proxy-protocol" yaml:"enabled-proxy-protocol" usage:"enable proxy protocol"`
+
+	// MaxIdleConns is the max idle connections to keep alive, ready for reuse
+	MaxIdleConns int `json:"max-idle-connections" yaml:"max-idle-connections" usage:"max idle upstream / keycloak connections to keep alive, ready for reuse"`
+	// MaxIdleConnsPerHost limits the number of idle connections maintained per host
+	MaxIdleConnsPerHost int `json:"max-idle-connections-per-host" yaml:"max-idle-connections-per-host" usage:"limits the number of idle connections maintained per host"`
+	// MaxInflightPerIP limits the number of requests processed concurrently for a client IP
+	MaxInflightPerIP int `json:"max-inflight-per-ip" yaml:"max-inflight-per-ip" usage:"limits the number of requests processed concurrently for a client IP. Unlimited when 0" env:"MAX_INFLIGHT_PER_IP"`
+	// MaxInflightPerIdentity limits the number of requests processed concurrently for an authenticated user
+	MaxInflightPerIdentity int `json:"max-inflight-per-identity" yaml:"max-inflight-per-identity" usage:"limits the number of requests processed concurrently for an authenticated user. Unlimited when 0" env:"MAX_INFLIGHT_PER_IDENTITY"`
+	// EnableIdentityAccounting counts the bytes sent and received by the authenticated users, per resource
+	EnableIdentityAccounting bool `json:"enable-identity-accounting" yaml:"enable-identity-accounting" usage:"counts the bytes of the request and response bodies of the authenticated users, exported per resource in the metrics" env:"ENABLE_IDENTITY_ACCOUNTING"`
+	// IdentityDailyByteQuota is the bytes an authenticated user may send and receive per day (UTC), on each instance
+	IdentityDailyByteQuota int `json:"identity-daily-byte-quota" yaml:"identity-daily-byte-quota" usage:"bytes of request and response bodies an authenticated user may transfer per day (UTC), rejected with 429 past the quota. Counted by each instance, not across the replicas. Unlimited when 0" env:"IDENTITY_DAILY_BYTE_QUOTA"`
+	// InflightQueueTimeout is the maximum time a request waits for the client's in-flight requests to complete
+	InflightQueueTimeout time.Duration `json:"inflight-queue-timeout" yaml:"inflight-queue-timeout" usage:"maximum time a request over the in-flight limits waits before being rejected. Rejected immediately when 0" env:"INFLIGHT_QUEUE_TIMEOUT"`
+	// EnableUpstreamBackoff sheds the requests of a user while the upstream has asked them to retry later
+	EnableUpstreamBackoff bool `json:"enable-upstream-backoff" yaml:"enable-upstream-backoff" usage:"rejects the requests of an authenticated user at the gateway for the delay of the Retry-After header of an upstream 429 or 503 response to that user" env:"ENABLE_UPSTREAM_BACKOFF"`
+	// UpstreamBackoffMaxDuration caps the delay for which the requests of a user are shed
+	UpstreamBackoffMaxDuration time.Duration `json:"upstream-backoff-max-duration" yaml:"upstream-backoff-max-duration" usage:"maximum delay for which the requests of a user are shed after an upstream Retry-After" env:"UPSTREAM_BACKOFF_MAX_DURATION"`
+
+	// ServerReadTimeout is the read timeout on the http server
+	ServerReadTimeout time.Duration `json:"server-read-timeout" yaml:"server-read-timeout" usage:"the server read timeout on the http server"`
+	// ServerWriteTimeout is the write timeout on the http server. Defaults to 11s (should be larger than UpstreamTimeout)
+	ServerWriteTimeout time.Duration `json:"server-write-timeout" yaml:"server-write-timeout" usage:"the server write timeout on the http server"`
+	// ServerIdleTimeout is the idle timeout on the http server
+	ServerIdleTimeout time.Duration `json:"server-idle-timeout" yaml:"server-idle-timeout" usage:"the server idle timeout on the http server" env:"SERVER_IDLE_TIMEOUT"`
+	// ServerReadHeaderTimeout is the time allowed to read the request headers on the http server
+	ServerReadHeaderTimeout time.Duration `json:"server-read-header-timeout" yaml:"server-read-header-timeout" usage:"the time allowed to read the request headers on the http server, defaults to the server read timeout" env:"SERVER_READ_HEADER_TIMEOUT"`
+	// ServerMaxHeaderBytes is the maximum size of the request headers on the http server
+	ServerMaxHeaderBytes int `json:"server-max-header-bytes" yaml:"server-max-header-bytes" usage:"the maximum size in bytes of the request line and headers on the http server" env:"SERVER_MAX_HEADER_BYTES"`
+	// ServerMaxHeaderCount is the maximum number of request headers on the http server
+	ServerMaxHeaderCount int `json:"server-max-header-count" yaml:"server-max-header-count" usage:"the maximum number of request headers on the http server, unlimited by default" env:"SERVER_MAX_HEADER_COUNT"`
+	// AdminReadHeaderTimeout overrides the time allowed to read the request headers on the admin listener
+	AdminReadHeaderTimeout time.Duration `json:"admin-read-header-timeout" yaml:"admin-read-header-timeout" usage:"the time allowed to read the request headers on the admin listener, defaults to the server setting" env:"ADMIN_READ_HEADER_TIMEOUT"`
+	// AdminMaxHeaderBytes overrides the maximum size of the request headers on the admin listener
+	AdminMaxHeaderBytes int `json:"admin-max-header-bytes" yaml:"admin-max-header-bytes" usage:"the maximum size in bytes of the request line and headers on the admin listener, defaults to the server setting" env:"ADMIN_MAX_HEADER_BYTES"`
+	// AdminMaxHeaderCount overrides the maximum number of request headers on the admin listener
+	AdminMaxHeaderCount int `json:"admin-max-header-count" yaml:"admin-max-header-count" usage:"the maximum number of request headers on the admin listener, defaults to the server setting" env:"ADMIN_MAX_HEADER_COUNT"`
+
+	// UseLetsEncrypt controls if we should use letsencrypt to retrieve certificates
+	UseLetsEncrypt bool `json:"use-letsencrypt" yaml:"use-letsencrypt" usage:"use letsencrypt for certificates"`
+
+	// LetsEncryptCacheDir is the path to store letsencrypt certificates
+	LetsEncryptCacheDir string `json:"letsencrypt-cache-dir" yaml:"letsencrypt-cache-dir" usage:"path where cached letsencrypt certificates are stored"`
+	// LetsEncryptEmail is the contact address of the acme account
+	LetsEncryptEmail string `json:"letsencrypt-email" yaml:"letsencrypt-email" usage:"contact email of the acme account, notified of the certificate issues" env:"LETSENCRYPT_EMAIL"`
+	// LetsEncryptDirectoryURL is the directory of the acme certificate authority
+	LetsEncryptDirectoryURL string `json:"letsencrypt-directory-url" yaml:"letsencrypt-directory-url" usage:"directory url of the acme certificate authority, defaults to the letsencrypt production one" env:"LETSENCRYPT_DIRECTORY_URL"`
+	// LetsEncryptChallenge is the type of challenge answered to obtain the certificates
+	LetsEncryptChallenge string `json:"letsencrypt-challenge" yaml:"letsencrypt-challenge" usage:"the acme challenge answered, tls-alpn-01 on the listener, or http-01 on the http listener in addition" env:"LETSENCRYPT_CHALLENGE"`
+	// LetsEncryptRenewBefore is how early the certificates are renewed before they expire
+	LetsEncryptRenewBefore time.Duration `json:"letsencrypt-renew-before" yaml:"letsencrypt-renew-before" usage:"how early the certificates are renewed before they expire" env:"LETSENCRYPT_RENEW_BEFORE"`
+
+	// SignInPage is the relative url for the sign in page
+	SignInPage string `json:"sign-in-page" yaml:"sign-in-page" usage:"path to custom template displayed for signin"`
+	// ForbiddenPage is a access forbidden page
+	ForbiddenPage string `json:"forbidden-page" yaml:"forbidden-page" usage:"path to custom template used for access forbidden"`
+	// GatewayTimeoutPage is the page of the requests exceeding the response timeout of their resource
+	GatewayTimeoutPage string `json:"gateway-timeout-page" yaml:"gateway-timeout-page" usage:"path to custom template used for the requests exceeding the response timeout of their resource (504)"`
+	// ProviderUnavailablePage is the page of the requests which cannot be served while the provider is unreachable
+	ProviderUnavailablePage string `json:"provider-unavailable-page" yaml:"provider-unavailable-page" usage:"path to custom template used when the openid provider cannot be reached (503)"`
+	// HeaderLimitPage is the page of the requests exceeding the header limits
+	HeaderLimitPage string `json:"header-limit-page" yaml:"header-limit-page" usage:"path to custom template used for the requests exceeding the header limits (431)"`
+	// RedirectLoopPage is the page of the clients caught in a loop of redirections to the authorization endpoint
+	RedirectLoopPage string `json:"redirect-loop-page" yaml:"redirect-loop-page" usage:"path to custom template used when a client loops through the authorization endpoint (508)"`
+	// MaxAuthRedirects is the number of redirections of a client to the authorization endpoint within the window
+	MaxAuthRedirects int `json:"max-auth-redirects" yaml:"max-auth-redirects" usage:"number of redirections of a client to the authorization endpoint within auth-redirect-window, past which the loop is broken with an error page. Unlimited when 0" env:"MAX_AUTH_REDIRECTS"`
+	// AuthRedirectWindow is the window in which the redirections to the authorization endpoint are counted
+	AuthRedirectWindow time.Duration `json:"auth-redirect-window" yaml:"auth-redirect-window" usage:"window in which the redirections of a client to the authorization endpoint are counted" env:"AUTH_REDIRECT_WINDOW"`
+	// Tags is passed to the templates
+	Tags map[string]string `json:"tags" yaml:"tags" usage:"keypairs passed to the templates at render,e.g title=Page"`
+
+	// ForwardingUsername is the username to login to the oauth service
+	ForwardingUsername string `json:"forwarding-username" yaml:"forwarding-username" usage:"username to use when logging into the openid provider" env:"FORWARDING_USERNAME"`
+	// ForwardingPassword is the password to use for the above
+	ForwardingPassword string `json:"forwarding-password" yaml:"forwarding-password" usage:"password to use when logging into the openid provider" env:"FORWARDING_PASSWORD"`
+	// ForwardingDomains is a collection of domains to signs
+	ForwardingDomains []string `json:"forwarding-domains" yaml:"forwarding-domains" usage:"list of domains which should be signed; everything else is relayed unsigned"`
+
+	// DisableAllLogging indicates no logging at all
+	DisableAllLogging bool `json:"disable-all-logging" yaml:"disable-all-logging" usage:"disables all logging to stdout and stderr"`
+}
+
+// RequestScope is a request level context scope passed between middleware
+type RequestScope struct {
+	// AccessDenied indicates the request should not be proxied on
+	AccessDenied bool
+	// Identity is the user Identity of the request
+	Identity *userContext
+	// releaseInflight releases the concurrency slot held for the identity, if any
+	releaseInflight func()
+	// forwardedURI is the uri of the request authenticated by the forward-auth endpoint, if any
+	forwardedURI string
+	// noRedirects responds 401 in place of the redirections for authorization, e.g. to nginx auth_request
+	noRedirects bool
+	// resource is the url of the resource matched by the request, if any
+	resource string
+	// audiences are the audiences of the resource matched, accepted in place of the client id
+	audiences []string
+}
+
+// tokenResponse
+type tokenResponse struct {
+	TokenType    string `json:"token_type"`
+	AccessToken  string `json:"access_token"`
+	IDToken      string `json:"id_token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresIn    int    `json:"expires_in"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// csrfTokenResponse is the response of the CSRF token endpoint
+type csrfTokenResponse struct {
+	Header string `json:"header"`
+	Token  string `json:"token"`
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+var errListenerClosed = errors.New("the listener is closed")
+
+// listenTCP binds the tcp listener of a configuration. With a separate IPv6 interface, the listen
+// interface is bound on IPv4 only and both sockets are accepted from as one listener.
+func listenTCP(config listenerConfig) (net.Listener, error) {
+	network := defaultTo(config.network, "tcp")
+	if config.listenIPv6 == "" {
+		return net.Listen(network, config.listen)
+	}
+
+	v4, err := net.Listen("tcp4", config.listen)
+	if err != nil {
+		return nil, err
+	}
+	// the tcp6 sockets are bound with IPV6_V6ONLY
+	v6, err := net.Listen("tcp6", config.listenIPv6)
+	if err != nil {
+		_ = v4.Close()
+		return nil, err
+	}
+
+	return newDualStackListener(v4, v6), nil
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+// dualStackListener accepts the connections of several listeners
+type dualStackListener struct {
+	listeners []net.Listener
+	accepted  chan acceptResult
+	closing   chan struct{}
+	once      sync.Once
+}
+
+func newDualStackListener(listeners ...net.Listener) *dualStackListener {
+	l := &dualStackListener{
+		listeners: listeners,
+		accepted:  make(chan acceptResult),
+		closing:   make(chan struct{}),
+	}
+	for _, listener := range listeners {
+		go l.serve(listener)
+	}
+
+	return l
+}
+
+// serve passes on the connections of a listener, until it fails permanently
+func (l *dualStackListener) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		select {
+		case l.accepted <- acceptResult{conn: conn, err: err}:
+		case <-l.closing:
+			if conn != nil {
+				_ = conn.Close()
+			}
+			return
+		}
+		if ne, ok := err.(net.Error); err != nil && (!ok || !ne.Temporary()) { //nolint:staticcheck
+			return
+		}
+	}
+}
+
+// Accept waits for the next connection on any of the listeners
+func (l *dualStackListener) Accept() (net.Conn, error) {
+	select {
+	case res := <-l.accepted:
+		return res.conn, res.err
+	case <-l.closing:
+		return nil, errListenerClosed
+	}
+}
+
+// Close closes all the listeners
+func (l *dualStackListener) Close() error {
+	var err error
+	l.once.Do(func() {
+		close(l.closing)
+		for _, listener := range l.listeners {
+			if e := listener.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+	})
+
+	return err
+}
+
+// Addr returns the address of the first listener
+func (l *dualStackListener) Addr() net.Addr {
+	return l.listeners[0].Addr()
+}
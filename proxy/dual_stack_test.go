@@ -0,0 +1,73 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDualStackListener(t *testing.T) {
+	if ln, err := net.Listen("tcp6", "[::1]:0"); err != nil {
+		t.Skip("IPv6 is not available")
+	} else {
+		_ = ln.Close()
+	}
+
+	listener, err := listenTCP(listenerConfig{listen: "127.0.0.1:0", listenIPv6: "[::1]:0"})
+	require.NoError(t, err)
+	dual, ok := listener.(*dualStackListener)
+	require.True(t, ok)
+	assert.NotNil(t, dual.listeners[0].Addr().(*net.TCPAddr).IP.To4(), "expected the listen interface to be bound on IPv4")
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, realIP(req))
+	})}
+	go func() { _ = server.Serve(listener) }()
+	defer server.Close()
+
+	for i, l := range dual.listeners {
+		resp, err := http.Get("http://" + l.Addr().String())
+		require.NoError(t, err, "case %d", i)
+		body, _ := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		assert.Equal(t, []string{"127.0.0.1", "::1"}[i], string(body), "case %d, unexpected client ip", i)
+	}
+}
+
+func TestListenIPv6Only(t *testing.T) {
+	// the port of an IPv6 only socket is left free on IPv4, which other tests may bind in the meantime
+	for attempt := 0; attempt < 5; attempt++ {
+		listener, err := listenTCP(listenerConfig{listen: "[::]:0", network: "tcp6"})
+		if err != nil {
+			t.Skip("IPv6 is not available")
+		}
+		_, port, _ := net.SplitHostPort(listener.Addr().String())
+		v4, err := net.Listen("tcp4", net.JoinHostPort("127.0.0.1", port))
+		_ = listener.Close()
+		if err == nil {
+			_ = v4.Close()
+			return
+		}
+	}
+	t.Fatal("expected the IPv4 port to be left free")
+}
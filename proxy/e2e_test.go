@@ -13,7 +13,7 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package main
+package proxy
 
 import (
 	"crypto/tls"
@@ -30,6 +30,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/oneconcern/keycloak-gatekeeper/gatekeepertest"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/net/http2"
 )
@@ -175,59 +176,8 @@ func testDiscoveryURL(listener, realm string) string {
 
 func runTestAuth(t *testing.T, listener, realm string) error {
 	// a stub OIDC provider
-	fake := newFakeAuthServer()
-	fake.location, _ = url.Parse("http://" + listener)
-
-	issuer := "http://" + listener + path.Join("/auth", "realms", realm)
-	discoveryURL := testDiscoveryPath(realm)
-	authorizeURL := path.Join("/auth", "realms", realm, "protocol", "openid-connect", "auth")
-	// #nosec
-	tokenURL := path.Join("/auth", "realms", realm, "protocol", "openid-connect", "token")
-	jwksURL := path.Join("/auth", "realms", realm, "protocol", "openid-connect", "certs")
-
-	go func() {
-		mux := http.NewServeMux()
-		configurationHandler := func(w http.ResponseWriter, req *http.Request) {
-			w.Header().Set("Content-Type", "application/json")
-			_, _ = io.WriteString(w, `{
-				"issuer": "`+issuer+`",
-				"subject_types_supported":["public","pairwise"],
-				"id_token_signing_alg_values_supported":["ES384","RS384","HS256","HS512","ES256","RS256","HS384","ES512","RS512"],
-				"userinfo_signing_alg_values_supported":["ES384","RS384","HS256","HS512","ES256","RS256","HS384","ES512","RS512","none"],
-				"authorization_endpoint":"http://`+listener+authorizeURL+`",
-				"token_endpoint":"http://`+listener+tokenURL+`",
-				"jwks_uri":"http://`+listener+jwksURL+`"
-			}`)
-		}
-		authorizeHandler := func(w http.ResponseWriter, req *http.Request) {
-			redirect := req.FormValue("redirect_uri")
-			state := req.FormValue("state")
-			code := "xyz"
-			location, _ := url.PathUnescape(redirect)
-			u, _ := url.Parse(location)
-			v := u.Query()
-			v.Set("code", code)
-			v.Set("state", state)
-			u.RawQuery = v.Encode()
-			http.Redirect(w, req, u.String(), http.StatusFound)
-		}
-
-		tokenHandler := func(w http.ResponseWriter, req *http.Request) {
-			fake.tokenHandler(w, req)
-		}
-
-		keysHandler := func(w http.ResponseWriter, req *http.Request) {
-			fake.keysHandler(w, req)
-		}
-		mux.HandleFunc(discoveryURL, configurationHandler)
-		mux.HandleFunc(authorizeURL, authorizeHandler)
-		mux.HandleFunc(tokenURL, tokenHandler)
-		mux.HandleFunc(jwksURL, keysHandler)
-		_ = http.ListenAndServe(listener, mux)
-	}()
-	if !assert.True(t, checkListenOrBail("http://"+path.Join(listener, jwksURL))) {
-		err := fmt.Errorf("cannot connect to test http listener on: %s", "http://"+path.Join(listener, jwksURL))
-		t.Logf("%v", err)
+	if _, err := newFakeAuthServerWithOptions(gatekeepertest.Options{Realm: realm, Address: listener}); err != nil {
+		t.Logf("cannot start the test auth server on: %s: %v", listener, err)
 		t.FailNow()
 		return err
 	}
@@ -13,12 +13,11 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package main
+package proxy
 
 import (
 	"crypto/tls"
 	"fmt"
-	"io"
 	"net"
 	"net/http"
 	"net/url"
@@ -29,6 +28,7 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/oneconcern/keycloak-gatekeeper/gatekeepertest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -117,62 +117,18 @@ func runTestWSTLSApp(t *testing.T, listener, route string) error {
 }
 */
 
-// nolint: dupl
 func runTestWSTLSAuth(t *testing.T, listener, realm string) error {
 	// a stub OIDC provider
-	fake := newFakeAuthServer()
-	fake.location, _ = url.Parse("https://" + listener)
-
-	issuer := "https://" + listener + path.Join("/auth", "realms", realm)
-	discoveryURL := testDiscoveryPath(realm)
-	authorizeURL := path.Join("/auth", "realms", realm, "protocol", "openid-connect", "auth")
-	// #nosec
-	tokenURL := path.Join("/auth", "realms", realm, "protocol", "openid-connect", "token")
-	jwksURL := path.Join("/auth", "realms", realm, "protocol", "openid-connect", "certs")
-
-	go func() {
-		mux := http.NewServeMux()
-		configurationHandler := func(w http.ResponseWriter, req *http.Request) {
-			w.Header().Set("Content-Type", "application/json")
-			_, _ = io.WriteString(w, `{
-				"issuer": "`+issuer+`",
-				"subject_types_supported":["public","pairwise"],
-				"id_token_signing_alg_values_supported":["ES384","RS384","HS256","HS512","ES256","RS256","HS384","ES512","RS512"],
-				"userinfo_signing_alg_values_supported":["ES384","RS384","HS256","HS512","ES256","RS256","HS384","ES512","RS512","none"],
-				"authorization_endpoint":"https://`+listener+authorizeURL+`",
-				"token_endpoint":"https://`+listener+tokenURL+`",
-				"jwks_uri":"https://`+listener+jwksURL+`"
-			}`)
-		}
-		authorizeHandler := func(w http.ResponseWriter, req *http.Request) {
-			redirect := req.FormValue("redirect_uri")
-			state := req.FormValue("state")
-			code := "zzz"
-			location, _ := url.PathUnescape(redirect)
-			u, _ := url.Parse(location)
-			v := u.Query()
-			v.Set("code", code)
-			v.Set("state", state)
-			u.RawQuery = v.Encode()
-			http.Redirect(w, req, u.String(), http.StatusFound)
-		}
-
-		tokenHandler := func(w http.ResponseWriter, req *http.Request) {
-			fake.tokenHandler(w, req)
-		}
-
-		keysHandler := func(w http.ResponseWriter, req *http.Request) {
-			fake.keysHandler(w, req)
-		}
-		mux.HandleFunc(discoveryURL, configurationHandler)
-		mux.HandleFunc(authorizeURL, authorizeHandler)
-		mux.HandleFunc(tokenURL, tokenHandler)
-		mux.HandleFunc(jwksURL, keysHandler)
-		_ = http.ListenAndServeTLS(listener, authCert, authKey, mux)
-	}()
-	if !assert.True(t, checkListenOrBail("https://"+path.Join(listener, jwksURL))) {
-		err := fmt.Errorf("cannot connect to test https listener on: %s", "https://"+path.Join(listener, jwksURL))
-		t.Logf("%v", err)
+	certificate, err := tls.LoadX509KeyPair(authCert, authKey)
+	if err == nil {
+		_, err = newFakeAuthServerWithOptions(gatekeepertest.Options{
+			Realm:   realm,
+			Address: listener,
+			TLS:     &tls.Config{Certificates: []tls.Certificate{certificate}},
+		})
+	}
+	if err != nil {
+		t.Logf("cannot start the test TLS auth server on: %s: %v", listener, err)
 		t.FailNow()
 		return err
 	}
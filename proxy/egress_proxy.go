@@ -0,0 +1,62 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// proxyFromEnvironment is the proxy setting honoring the HTTP_PROXY, HTTPS_PROXY and NO_PROXY variables
+const proxyFromEnvironment = "environment"
+
+// makeProxyFunc returns the proxy selection of a transport, from the url of an egress proxy, or the environment,
+// and the hosts reached directly. The loopback hosts are never proxied.
+func makeProxyFunc(proxy string, noProxy []string) func(*http.Request) (*url.URL, error) {
+	var config *httpproxy.Config
+	switch proxy {
+	case "":
+		return nil
+	case proxyFromEnvironment:
+		config = httpproxy.FromEnvironment()
+		if len(noProxy) > 0 {
+			config.NoProxy = strings.Join(append([]string{config.NoProxy}, noProxy...), ",")
+		}
+	default:
+		config = &httpproxy.Config{HTTPProxy: proxy, HTTPSProxy: proxy, NoProxy: strings.Join(noProxy, ",")}
+	}
+	selectProxy := config.ProxyFunc()
+
+	return func(req *http.Request) (*url.URL, error) {
+		return selectProxy(req.URL)
+	}
+}
+
+// isEgressProxyValid checks the setting of an egress proxy
+func isEgressProxyValid(name, proxy string) error {
+	if proxy == "" || proxy == proxyFromEnvironment {
+		return nil
+	}
+	if u, err := url.Parse(proxy); err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("the %s %q is not a valid url, e.g. http://proxy.example.com:3128, or %q", name, proxy, proxyFromEnvironment)
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMakeProxyFunc(t *testing.T) {
+	assert.Nil(t, makeProxyFunc("", []string{"example.com"}))
+
+	selectProxy := makeProxyFunc("http://egress:3128", []string{".svc.cluster.local", "10.0.0.0/8"})
+	cs := []struct {
+		URL      string
+		Expected string
+	}{
+		{URL: "https://keycloak.example.com/auth", Expected: "http://egress:3128"},
+		{URL: "http://api.default.svc.cluster.local:8080", Expected: ""},
+		{URL: "http://10.1.2.3:8080", Expected: ""},
+		{URL: "http://127.0.0.1:8080", Expected: ""},
+	}
+	for i, c := range cs {
+		req, _ := http.NewRequest(http.MethodGet, c.URL, nil)
+		proxy, err := selectProxy(req)
+		require.NoError(t, err, "case %d", i)
+		if c.Expected == "" {
+			assert.Nil(t, proxy, "case %d, expected no proxy for %s", i, c.URL)
+			continue
+		}
+		require.NotNil(t, proxy, "case %d, expected a proxy for %s", i, c.URL)
+		assert.Equal(t, c.Expected, proxy.String(), "case %d", i)
+	}
+}
+
+func TestMakeProxyFuncFromEnvironment(t *testing.T) {
+	for name, value := range map[string]string{"HTTPS_PROXY": "http://corporate:8080", "NO_PROXY": "internal.example.com"} {
+		previous, found := os.LookupEnv(name)
+		require.NoError(t, os.Setenv(name, value))
+		defer func(name string) {
+			if found {
+				_ = os.Setenv(name, previous)
+				return
+			}
+			_ = os.Unsetenv(name)
+		}(name)
+	}
+
+	selectProxy := makeProxyFunc(proxyFromEnvironment, []string{"keycloak.example.com"})
+	for i, location := range []string{"https://internal.example.com", "https://keycloak.example.com"} {
+		req, _ := http.NewRequest(http.MethodGet, location, nil)
+		proxy, err := selectProxy(req)
+		require.NoError(t, err, "case %d", i)
+		assert.Nil(t, proxy, "case %d, expected no proxy for %s", i, location)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "https://accounts.example.org", nil)
+	proxy, err := selectProxy(req)
+	require.NoError(t, err)
+	require.NotNil(t, proxy)
+	assert.Equal(t, "corporate:8080", proxy.Host)
+}
+
+func TestUpstreamProxy(t *testing.T) {
+	var lock sync.Mutex
+	var proxied []string
+	egress := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// the proxied requests carry the absolute url of the upstream
+		lock.Lock()
+		proxied = append(proxied, req.URL.String())
+		lock.Unlock()
+	}))
+	defer egress.Close()
+
+	cfg := newFakeKeycloakConfig()
+	cfg.UpstreamProxy = egress.URL
+	cfg.Resources = []*Resource{
+		{
+			URL:          "/partner/*",
+			Methods:      allHTTPMethods,
+			Upstream:     "http://partner.example.com",
+			MaxIdleConns: 10,
+		},
+	}
+	newFakeProxy(cfg).RunTests(t, []fakeRequest{
+		{
+			URI:          "/partner/orders",
+			HasToken:     true,
+			ExpectedCode: http.StatusOK,
+		},
+	})
+
+	assert.Equal(t, []string{"http://partner.example.com/partner/orders"}, proxied)
+}
@@ -1,4 +1,4 @@
-package main
+package proxy
 
 import (
 	"context"
@@ -20,6 +20,8 @@ var (
 	ErrInvalidSession = errors.New("invalid session identifier")
 	// ErrAccessTokenExpired indicates the access token has expired
 	ErrAccessTokenExpired = errors.New("the access token has expired")
+	// ErrTokenNotYetValid indicates the token is not valid before a time, or issued in the future
+	ErrTokenNotYetValid = errors.New("the token is not valid yet, check the clocks of the provider and the proxy")
 	// ErrRefreshTokenExpired indicates the refresh token as expired
 	ErrRefreshTokenExpired = errors.New("the refresh token has expired")
 	// ErrNoTokenAudience indicates their is not audience in the token
@@ -28,6 +30,10 @@ var (
 	ErrDecryption = errors.New("failed to decrypt token")
 	// ErrEncode indicates a failure to encode the token
 	ErrEncode = errors.New("failed to encode token")
+	// ErrUserinfoRejected indicates the userinfo endpoint did not accept the token
+	ErrUserinfoRejected = errors.New("token not validate by userinfo endpoint")
+	// ErrProviderUnavailable indicates the openid provider cannot serve the requests
+	ErrProviderUnavailable = errors.New("the openid provider is unavailable")
 	// ErrEncryption indicates a failure to encrypt the token
 	ErrEncryption = errors.New("failed to encrypt token")
 )
@@ -78,6 +84,23 @@ func errorResponse(w http.ResponseWriter, msg string, code int) {
 	}
 }
 
+// errorPageResponse responds with a custom error page when set, or with the default error response
+func (r *oauthProxy) errorPageResponse(w http.ResponseWriter, req *http.Request, page, msg string, code int) {
+	if page == "" {
+		errorResponse(w, msg, code)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	noSniff(w)
+	w.WriteHeader(code)
+	name := path.Base(page)
+	if err := r.Render(w, name, r.config.Tags); err != nil {
+		_, logger := r.traceSpanRequest(req)
+		logger.Error("failed to render the template", zap.Error(err), zap.String("template", name))
+	}
+}
+
 // accessForbidden redirects the user to the forbidden page
 func (r *oauthProxy) accessForbidden(w http.ResponseWriter, req *http.Request, msgs ...string) context.Context {
 	_, logger := r.traceSpanRequest(req)
@@ -0,0 +1,222 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// headerXFaultInjected marks the responses of the injected errors
+const headerXFaultInjected = "X-Fault-Injected"
+
+// FaultInjection are the faults injected in the requests to a resource, to test the resilience of the apps
+type FaultInjection struct {
+	// Delay is added to the requests before they are proxied
+	Delay time.Duration `json:"delay" yaml:"delay"`
+	// ErrorPercent is the percentage of the requests responded with ErrorCode in place of the upstream
+	ErrorPercent float64 `json:"error-percent" yaml:"error-percent"`
+	// ErrorCode is the status of the injected errors, 503 by default
+	ErrorCode int `json:"error-code" yaml:"error-code"`
+}
+
+// faultInjectionSpec is the representation of a fault injection in the admin api, with a readable delay
+type faultInjectionSpec struct {
+	Delay        string  `json:"delay,omitempty"`
+	ErrorPercent float64 `json:"error-percent"`
+	ErrorCode    int     `json:"error-code,omitempty"`
+}
+
+// faultInjections are the faults of the resources in effect, set from the configuration or the admin api
+type faultInjections struct {
+	sync.RWMutex
+	faults map[string]FaultInjection
+}
+
+func newFaultInjections(resources []*Resource) *faultInjections {
+	f := &faultInjections{faults: make(map[string]FaultInjection)}
+	for _, resource := range resources {
+		if resource.FaultInjection != nil {
+			f.faults[resource.URL] = *resource.FaultInjection
+		}
+	}
+
+	return f
+}
+
+func (f *faultInjections) get(resource string) (FaultInjection, bool) {
+	f.RLock()
+	defer f.RUnlock()
+	fault, ok := f.faults[resource]
+
+	return fault, ok
+}
+
+func (f *faultInjections) set(resource string, fault FaultInjection) {
+	f.Lock()
+	defer f.Unlock()
+	f.faults[resource] = fault
+}
+
+func (f *faultInjections) clear(resource string) {
+	f.Lock()
+	defer f.Unlock()
+	if resource == "" {
+		f.faults = make(map[string]FaultInjection)
+		return
+	}
+	delete(f.faults, resource)
+}
+
+func (f *faultInjections) list() map[string]FaultInjection {
+	f.RLock()
+	defer f.RUnlock()
+	faults := make(map[string]FaultInjection, len(f.faults))
+	for resource, fault := range f.faults {
+		faults[resource] = fault
+	}
+
+	return faults
+}
+
+// isFaultInjectionValid checks a fault injection
+func isFaultInjectionValid(fault *FaultInjection) error {
+	if fault.Delay < 0 {
+		return errors.New("the fault delay must be a positive duration")
+	}
+	if fault.ErrorPercent < 0 || fault.ErrorPercent > 100 {
+		return errors.New("the fault error-percent must be between 0 and 100")
+	}
+	if fault.ErrorCode != 0 && (fault.ErrorCode < 400 || fault.ErrorCode > 599) {
+		return fmt.Errorf("the fault error-code must be an error status, got %d", fault.ErrorCode)
+	}
+
+	return nil
+}
+
+// faultInjectionMiddleware delays or fails the requests to a resource, once admitted and before they are proxied
+func (r *oauthProxy) faultInjectionMiddleware(resource *Resource) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if r.faults == nil {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			// the requests already denied or redirected have been answered
+			if scope, found := req.Context().Value(contextScopeName).(*RequestScope); found && scope.AccessDenied {
+				next.ServeHTTP(w, req)
+				return
+			}
+			fault, ok := r.faults.get(resource.URL)
+			if !ok {
+				next.ServeHTTP(w, req)
+				return
+			}
+			if fault.Delay > 0 {
+				select {
+				case <-time.After(fault.Delay):
+				case <-req.Context().Done():
+				}
+			}
+			if fault.ErrorPercent > 0 && rand.Float64()*100 < fault.ErrorPercent {
+				code := fault.ErrorCode
+				if code == 0 {
+					code = http.StatusServiceUnavailable
+				}
+				w.Header().Set(headerXFaultInjected, "true")
+				r.errorResponse(w, req, "fault injected", code, nil)
+				r.revokeProxy(w, req)
+				return
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// listFaultsHandler responds the faults in effect, by resource
+func (r *oauthProxy) listFaultsHandler(w http.ResponseWriter, req *http.Request) {
+	faults := r.faults.list()
+	specs := make(map[string]faultInjectionSpec, len(faults))
+	for resource, fault := range faults {
+		spec := faultInjectionSpec{ErrorPercent: fault.ErrorPercent, ErrorCode: fault.ErrorCode}
+		if fault.Delay > 0 {
+			spec.Delay = fault.Delay.String()
+		}
+		specs[resource] = spec
+	}
+
+	w.Header().Set("Content-Type", jsonMime)
+	_ = json.NewEncoder(w).Encode(specs)
+}
+
+// setFaultHandler injects faults in the requests to the resource of the query
+func (r *oauthProxy) setFaultHandler(w http.ResponseWriter, req *http.Request) {
+	resource := req.URL.Query().Get("resource")
+	var found bool
+	for _, x := range r.config.Resources {
+		if x.URL == resource {
+			found = true
+			break
+		}
+	}
+	if !found {
+		r.errorResponse(w, req, "unknown resource", http.StatusNotFound, nil)
+		return
+	}
+
+	var spec faultInjectionSpec
+	if err := json.NewDecoder(req.Body).Decode(&spec); err != nil {
+		r.errorResponse(w, req, "invalid fault injection", http.StatusBadRequest, err)
+		return
+	}
+	fault := FaultInjection{ErrorPercent: spec.ErrorPercent, ErrorCode: spec.ErrorCode}
+	if spec.Delay != "" {
+		delay, err := time.ParseDuration(spec.Delay)
+		if err != nil {
+			r.errorResponse(w, req, "the fault delay must be a duration, e.g. 2s", http.StatusBadRequest, nil)
+			return
+		}
+		fault.Delay = delay
+	}
+	if err := isFaultInjectionValid(&fault); err != nil {
+		r.errorResponse(w, req, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+	r.faults.set(resource, fault)
+	r.log.Warn("injecting faults on operator request",
+		zap.String("resource", resource),
+		zap.Duration("delay", fault.Delay),
+		zap.Float64("error_percent", fault.ErrorPercent),
+		zap.Int("error_code", fault.ErrorCode))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// clearFaultsHandler stops injecting faults in the requests to the resource of the query, or else to all resources
+func (r *oauthProxy) clearFaultsHandler(w http.ResponseWriter, req *http.Request) {
+	resource := req.URL.Query().Get("resource")
+	r.faults.clear(resource)
+	r.log.Info("fault injection cleared on operator request", zap.String("resource", resource))
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	resty "gopkg.in/resty.v1"
+)
+
+func TestFaultInjection(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.EnableFaultInjection = true
+	cfg.Resources = []*Resource{
+		{
+			URL:            "/down/*",
+			Methods:        allHTTPMethods,
+			FaultInjection: &FaultInjection{ErrorPercent: 100},
+		},
+		{
+			URL:            "/teapot/*",
+			WhiteListed:    true,
+			Methods:        allHTTPMethods,
+			FaultInjection: &FaultInjection{ErrorPercent: 100, ErrorCode: http.StatusTeapot},
+		},
+		{
+			URL:            "/slow/*",
+			Methods:        allHTTPMethods,
+			FaultInjection: &FaultInjection{Delay: 100 * time.Millisecond},
+		},
+	}
+	requests := []fakeRequest{
+		{
+			// the faults are injected once the request is admitted
+			URI:          "/down/items",
+			Redirects:    false,
+			ExpectedCode: http.StatusUnauthorized,
+		},
+		{
+			URI:             "/down/items",
+			HasToken:        true,
+			ExpectedCode:    http.StatusServiceUnavailable,
+			ExpectedHeaders: map[string]string{headerXFaultInjected: "true"},
+		},
+		{
+			URI:          "/teapot/items",
+			ExpectedCode: http.StatusTeapot,
+		},
+		{
+			URI:           "/slow/items",
+			HasToken:      true,
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+		},
+	}
+	newFakeProxy(cfg).RunTests(t, requests)
+}
+
+func TestFaultInjectionSkipsDeniedRequests(t *testing.T) {
+	resource := &Resource{URL: "/down/*", FaultInjection: &FaultInjection{ErrorPercent: 100}}
+	p := &oauthProxy{faults: newFaultInjections([]*Resource{resource})}
+	var proxied bool
+	handler := p.faultInjectionMiddleware(resource)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		proxied = true
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/down/items", nil)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req.WithContext(context.WithValue(req.Context(), contextScopeName, &RequestScope{AccessDenied: true})))
+	assert.True(t, proxied)
+	assert.Empty(t, resp.Header().Get(headerXFaultInjected))
+}
+
+func TestFaultInjectionAdmin(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.ListenAdmin = "127.0.0.1:0"
+	cfg.EnableAdminAPI = true
+	cfg.AdminAPIToken = "secret"
+	cfg.EnableFaultInjection = true
+	cfg.Resources = []*Resource{
+		{
+			URL:     "/api/*",
+			Methods: allHTTPMethods,
+		},
+	}
+	p := newFakeProxy(cfg)
+
+	admin := func(method, query, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, adminAPIURL+faultsURL+query, strings.NewReader(body))
+		req.Header.Set(authorizationHeader, "Bearer secret")
+		w := httptest.NewRecorder()
+		p.proxy.adminRouter.ServeHTTP(w, req)
+		return w
+	}
+	resource := "?resource=" + url.QueryEscape("/api/*")
+
+	assert.Equal(t, http.StatusNotFound, admin(http.MethodPut, "?resource=/missing", `{"error-percent":100}`).Code)
+	assert.Equal(t, http.StatusBadRequest, admin(http.MethodPut, resource, `{"error-percent":101}`).Code)
+	assert.Equal(t, http.StatusBadRequest, admin(http.MethodPut, resource, `{"delay":"soon"}`).Code)
+	assert.Equal(t, http.StatusNoContent, admin(http.MethodPut, resource, `{"delay":"10ms","error-percent":100,"error-code":502}`).Code)
+
+	w := admin(http.MethodGet, "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var faults map[string]faultInjectionSpec
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&faults))
+	assert.Equal(t, map[string]faultInjectionSpec{"/api/*": {Delay: "10ms", ErrorPercent: 100, ErrorCode: 502}}, faults)
+
+	p.RunTests(t, []fakeRequest{
+		{
+			URI:          "/api/items",
+			HasToken:     true,
+			ExpectedCode: http.StatusBadGateway,
+			OnResponse: func(int, *resty.Request, *resty.Response) {
+				assert.Equal(t, http.StatusNoContent, admin(http.MethodDelete, "", "").Code)
+			},
+		},
+		{
+			URI:           "/api/items",
+			HasToken:      true,
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+		},
+	})
+}
+
+func TestIsFaultInjectionValid(t *testing.T) {
+	assert.NoError(t, isFaultInjectionValid(&FaultInjection{Delay: time.Second, ErrorPercent: 50, ErrorCode: http.StatusBadGateway}))
+	assert.Error(t, isFaultInjectionValid(&FaultInjection{Delay: -time.Second}))
+	assert.Error(t, isFaultInjectionValid(&FaultInjection{ErrorPercent: 100, ErrorCode: http.StatusOK}))
+}
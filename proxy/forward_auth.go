@@ -0,0 +1,85 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	// the original request, as passed on by traefik forwardAuth
+	headerXForwardedMethod = "X-Forwarded-Method"
+	headerXForwardedURI    = "X-Forwarded-Uri"
+	// the original request, as usually passed on by nginx auth_request
+	headerXOriginalMethod = "X-Original-Method"
+	headerXOriginalURI    = "X-Original-URI"
+)
+
+// forwardAuthMiddleware authenticates the original request described by the headers of a forward-auth request.
+// The traefik requests are redirected for authorization like any request, when nginx expects a 401 response.
+func (r *oauthProxy) forwardAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		scope := req.Context().Value(contextScopeName).(*RequestScope)
+		// the scope is denied on the oauth endpoints, for the requests not to be proxied: the handler
+		// responds unless the authentication denies the request
+		scope.AccessDenied = false
+
+		if method := firstHeader(req, headerXForwardedMethod, headerXOriginalMethod); method != "" {
+			req.Method = strings.ToUpper(method)
+		}
+		if host := req.Header.Get("X-Forwarded-Host"); host != "" {
+			req.Host = host
+		}
+		scope.forwardedURI = req.Header.Get(headerXForwardedURI)
+		if scope.forwardedURI == "" {
+			scope.forwardedURI = req.Header.Get(headerXOriginalURI)
+			scope.noRedirects = true
+		}
+		if !strings.HasPrefix(scope.forwardedURI, "/") {
+			scope.forwardedURI = ""
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// forwardAuthHandler responds to the authenticated forward-auth requests with the identity headers, to be passed on
+// to the service
+func (r *oauthProxy) forwardAuthHandler() http.HandlerFunc {
+	setIdentityHeaders := r.identityHeaders(r.config.AddClaims)
+
+	return func(w http.ResponseWriter, req *http.Request) {
+		scope := req.Context().Value(contextScopeName).(*RequestScope)
+		if scope.AccessDenied || scope.Identity == nil {
+			return
+		}
+		setIdentityHeaders(w.Header(), scope.Identity)
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// firstHeader returns the first header set among some alternatives
+func firstHeader(req *http.Request, names ...string) string {
+	for _, name := range names {
+		if value := req.Header.Get(name); value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
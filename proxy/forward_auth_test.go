@@ -0,0 +1,77 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	resty "gopkg.in/resty.v1"
+)
+
+func TestForwardAuth(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.EnableForwardAuth = true
+	uri := cfg.WithOAuthURI(forwardAuthURL)
+	landing := base64.StdEncoding.EncodeToString([]byte("/reports?year=2020"))
+
+	requests := []fakeRequest{
+		{
+			// traefik forwardAuth: the browser is redirected for authorization, then lands on the original uri
+			URI:              uri,
+			Headers:          map[string]string{headerXForwardedMethod: "GET", headerXForwardedURI: "/reports?year=2020", "X-Forwarded-Host": "reports.example.com"},
+			Redirects:        true,
+			ExpectedCode:     http.StatusTemporaryRedirect,
+			ExpectedLocation: "/oauth/authorize?state",
+			ExpectedCookies:  map[string]string{requestURICookie: landing},
+		},
+		{
+			// nginx auth_request: unauthenticated requests are responded 401
+			URI:          uri,
+			Headers:      map[string]string{headerXOriginalURI: "/reports"},
+			Redirects:    true,
+			ExpectedCode: http.StatusUnauthorized,
+		},
+		{
+			URI:      uri,
+			Method:   http.MethodPost,
+			HasToken: true,
+			Headers:  map[string]string{headerXOriginalMethod: "POST", headerXOriginalURI: "/reports"},
+			TokenClaims: map[string]interface{}{
+				"email": "gambol99@gmail.com",
+			},
+			ExpectedCode:    http.StatusOK,
+			ExpectedProxy:   false,
+			ExpectedHeaders: map[string]string{"X-Auth-Email": "gambol99@gmail.com", "X-Auth-Username": "rjayawardene"},
+			OnResponse: func(i int, _ *resty.Request, resp *resty.Response) {
+				assert.True(t, strings.HasPrefix(resp.Header().Get(authorizationHeader), "Bearer "), "case %d, expected the token of the user", i)
+			},
+		},
+	}
+	newFakeProxy(cfg).RunTests(t, requests)
+
+	// the endpoint is not served unless enabled
+	newFakeProxy(newFakeKeycloakConfig()).RunTests(t, []fakeRequest{
+		{
+			URI:          uri,
+			HasToken:     true,
+			ExpectedCode: http.StatusNotFound,
+		},
+	})
+}
@@ -15,14 +15,13 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package main
+package proxy
 
 import (
 	"errors"
 	"fmt"
 	"io/ioutil"
 	httplog "log"
-	"net"
 	"net/http"
 	"time"
 
@@ -122,7 +121,7 @@ func (r *oauthProxy) createForwardingProxy() error {
 
 // forwardProxyHandler is responsible for signing outbound requests
 func (r *oauthProxy) forwardProxyHandler() func(*http.Request, *http.Response) {
-	client, err := r.client.OAuthClient()
+	client, err := r.getProviderClient().OAuthClient()
 	if err != nil {
 		r.log.Fatal("failed to create oauth client", zap.Error(err))
 	}
@@ -197,7 +196,7 @@ func (r *oauthProxy) forwardProxyHandler() func(*http.Request, *http.Response) {
 						zap.String("expires", state.expiration.Format(time.RFC3339)))
 
 					// step: attempt to refresh the access
-					token, newRefreshToken, expiration, _, err := getRefreshedToken(r.client, state.refresh)
+					token, newRefreshToken, expiration, _, err := getRefreshedToken(r.getProviderClient(), state.refresh, false)
 					if err != nil {
 						state.login = true
 						switch err {
@@ -263,10 +262,7 @@ func (r *oauthProxy) forwardProxyHandler() func(*http.Request, *http.Response) {
 
 // createProxy creates a reverse http proxy client to the upstream
 func (r *oauthProxy) createProxy() error {
-	dialer := (&net.Dialer{
-		KeepAlive: r.config.UpstreamKeepaliveTimeout,
-		Timeout:   r.config.UpstreamTimeout,
-	}).Dial
+	dialer := r.resolver.dialContext(newDialer(r.config.UpstreamTimeout, r.config.UpstreamKeepaliveTimeout, r.config.UpstreamFallbackDelay))
 
 	tlsConfig, err := r.buildProxyTLSConfig()
 	if err != nil {
@@ -280,8 +276,9 @@ func (r *oauthProxy) createProxy() error {
 	r.upstream = proxy
 
 	// update the tls configuration of the reverse proxy
-	r.upstream.(*goproxy.ProxyHttpServer).Tr = &http.Transport{
-		Dial:                  dialer,
+	transport := &http.Transport{
+		DialContext:           dialer,
+		Proxy:                 makeProxyFunc(r.config.UpstreamProxy, r.config.UpstreamNoProxy),
 		DisableKeepAlives:     !r.config.UpstreamKeepalives,
 		ExpectContinueTimeout: r.config.UpstreamExpectContinueTimeout,
 		ResponseHeaderTimeout: r.config.UpstreamResponseHeaderTimeout,
@@ -290,6 +287,8 @@ func (r *oauthProxy) createProxy() error {
 		MaxIdleConns:          r.config.MaxIdleConns,
 		MaxIdleConnsPerHost:   r.config.MaxIdleConnsPerHost,
 	}
+	r.useUpstreamCA(transport)
+	proxy.Tr = transport
 
 	return nil
 }
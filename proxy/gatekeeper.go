@@ -0,0 +1,94 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"net/http"
+)
+
+// Gatekeeper is the authentication proxy embedded in a Go service: it serves the requests as the
+// keycloak-gatekeeper process would, i.e. authenticating and authorizing them before proxying them
+// to the upstream of the configuration.
+type Gatekeeper struct {
+	proxy *oauthProxy
+}
+
+// NewDefaultConfig returns a configuration with the default values of the command line options
+func NewDefaultConfig() *Config {
+	return newDefaultConfig()
+}
+
+// New validates the configuration and creates the proxy. The proxy does not listen on any interface
+// until Run is called: it may be served by any http server instead.
+func New(config *Config) (*Gatekeeper, error) {
+	if err := config.isValid(); err != nil {
+		return nil, err
+	}
+	proxy, err := newProxy(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Gatekeeper{proxy: proxy}, nil
+}
+
+// ServeHTTP implements the http.Handler interface
+func (g *Gatekeeper) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	g.proxy.router.ServeHTTP(w, req)
+}
+
+// AdminHandler returns the handler of the admin endpoints, when these are served separately
+// (ListenAdmin) rather than by the proxy handler
+func (g *Gatekeeper) AdminHandler() http.Handler {
+	return g.proxy.adminRouter
+}
+
+// Run starts listening on the interfaces of the configuration
+func (g *Gatekeeper) Run() error {
+	return g.proxy.Run()
+}
+
+// Shutdown gracefully stops the listeners started by Run, waiting for the in-flight requests to complete
+// until the context is done, then releases the resources of the proxy
+func (g *Gatekeeper) Shutdown(ctx context.Context) error {
+	var err error
+	for _, server := range []*http.Server{g.proxy.server, g.proxy.httpServer, g.proxy.adminServer} {
+		if server == nil {
+			continue
+		}
+		if ers := server.Shutdown(ctx); ers != nil && err == nil {
+			err = ers
+		}
+	}
+	if ers := g.Close(); ers != nil && err == nil {
+		err = ers
+	}
+
+	return err
+}
+
+// Close releases the resources of the proxy, e.g. the connection to the store
+func (g *Gatekeeper) Close() error {
+	g.proxy.stopProvider()
+	g.proxy.stopProfilingSignal()
+	for _, x := range g.proxy.realms {
+		x.proxy.stopProvider()
+		_ = x.proxy.CloseStore()
+	}
+
+	return g.proxy.CloseStore()
+}
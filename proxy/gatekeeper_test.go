@@ -0,0 +1,76 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGatekeeperHandler(t *testing.T) {
+	idp := newFakeAuthServer()
+	defer idp.Close()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Upstream-Subject", req.Header.Get("X-Auth-Subject"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	cfg := NewDefaultConfig()
+	cfg.ClientID = fakeClientID
+	cfg.ClientSecret = fakeSecret
+	cfg.DisableAllLogging = true
+	cfg.DiscoveryURL = idp.getLocation()
+	cfg.EnableClaimsHeaders = true
+	cfg.Listen = "127.0.0.1:0"
+	cfg.RedirectionURL = "http://127.0.0.1"
+	cfg.SecureCookie = false
+	cfg.Upstream = upstream.URL
+	cfg.Resources = []*Resource{{URL: fakeAuthAllURL, Methods: allHTTPMethods}}
+	g, err := New(cfg)
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, g.Shutdown(context.Background())) }()
+
+	// the proxy is served by the embedding service
+	svc := httptest.NewServer(g)
+	defer svc.Close()
+
+	req, err := http.NewRequest(http.MethodGet, svc.URL+fakeAuthAllURL, nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+
+	token, err := idp.signToken(newTestToken(idp.getLocation()).claims)
+	require.NoError(t, err)
+	req.Header.Set(authorizationHeader, "Bearer "+token.Encode())
+	resp, err = http.DefaultTransport.RoundTrip(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get("X-Upstream-Subject"))
+}
+
+func TestGatekeeperInvalidConfig(t *testing.T) {
+	_, err := New(&Config{})
+	assert.Error(t, err)
+}
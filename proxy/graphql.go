@@ -0,0 +1,361 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// graphQLMaxBodySize is the largest GraphQL request body inspected by the admission
+	graphQLMaxBodySize = 1 << 20
+
+	graphQLQuery        = "query"
+	graphQLMutation     = "mutation"
+	graphQLSubscription = "subscription"
+)
+
+// GraphQLOperation is a role requirement on some of the operations sent to a GraphQL resource
+type GraphQLOperation struct {
+	// Type is the type of the operations: query, mutation or subscription (any type when empty)
+	Type string `json:"type" yaml:"type"`
+	// Name is the name of the operation (any name when empty)
+	Name string `json:"name" yaml:"name"`
+	// Roles the roles required to run the operations
+	Roles []string `json:"roles" yaml:"roles"`
+	// RequireAnyRole indicates that ANY of the roles are required, the default is all
+	RequireAnyRole bool `json:"require-any-role" yaml:"require-any-role"`
+}
+
+// matches checks if the rule applies to an operation
+func (o *GraphQLOperation) matches(op graphQLOperation) bool {
+	return (o.Type == "" || strings.EqualFold(o.Type, op.kind)) && (o.Name == "" || o.Name == op.name)
+}
+
+// graphQLOperation is an operation of a GraphQL document
+type graphQLOperation struct {
+	kind string
+	name string
+}
+
+// graphQLRequest is the json encoding of a GraphQL request
+type graphQLRequest struct {
+	Query         string `json:"query"`
+	OperationName string `json:"operationName"`
+}
+
+// graphQLBody is the buffered body of a request to a GraphQL resource, inspected by the admission
+// and sent upstream
+type graphQLBody struct {
+	*bytes.Reader
+	content []byte
+}
+
+func (b *graphQLBody) Close() error {
+	return nil
+}
+
+// graphQLBodyMiddleware buffers the body of the requests to a GraphQL resource. The body is buffered ahead of
+// the proxy middleware, for the upstream request to share the body inspected by the admission.
+func (r *oauthProxy) graphQLBodyMiddleware(resource *Resource) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !resource.GraphQL {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.Body != nil && req.Body != http.NoBody {
+				content, err := ioutil.ReadAll(io.LimitReader(req.Body, graphQLMaxBodySize+1))
+				_ = req.Body.Close()
+				if err != nil {
+					r.errorResponse(w, req, "unable to read the request body", http.StatusBadRequest, err)
+					return
+				}
+				if len(content) > graphQLMaxBodySize {
+					r.errorResponse(w, req, "the graphql request body is too large", http.StatusRequestEntityTooLarge, nil)
+					return
+				}
+				req.Body = &graphQLBody{Reader: bytes.NewReader(content), content: content}
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// readGraphQLOperations returns the operations run by a GraphQL request, several with batched requests
+func readGraphQLOperations(req *http.Request) ([]graphQLOperation, error) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		op, err := parseGraphQLOperation(req.URL.Query().Get("query"), req.URL.Query().Get("operationName"))
+		if err != nil {
+			return nil, err
+		}
+		return []graphQLOperation{op}, nil
+	case http.MethodPost:
+	default:
+		return nil, fmt.Errorf("the method %s is not supported for graphql requests", req.Method)
+	}
+
+	buffered, ok := req.Body.(*graphQLBody)
+	if !ok {
+		return nil, errors.New("the graphql request has no body")
+	}
+	body := buffered.content
+
+	if mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mediaType == "application/graphql" {
+		op, err := parseGraphQLOperation(string(body), req.URL.Query().Get("operationName"))
+		if err != nil {
+			return nil, err
+		}
+		return []graphQLOperation{op}, nil
+	}
+
+	var requests []graphQLRequest
+	var err error
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(trimmed, &requests)
+	} else {
+		requests = make([]graphQLRequest, 1)
+		err = json.Unmarshal(trimmed, &requests[0])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid graphql request: %v", err)
+	}
+	if len(requests) == 0 {
+		return nil, errors.New("the graphql batch has no requests")
+	}
+	ops := make([]graphQLOperation, 0, len(requests))
+	for _, request := range requests {
+		op, err := parseGraphQLOperation(request.Query, request.OperationName)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+
+	return ops, nil
+}
+
+// parseGraphQLOperation returns the operation of a GraphQL document selected by the operation name.
+// The document is not validated beyond the lexical analysis needed to locate its operations.
+func parseGraphQLOperation(document, operationName string) (graphQLOperation, error) {
+	ops, err := scanGraphQLOperations(document)
+	if err != nil {
+		return graphQLOperation{}, err
+	}
+	if operationName != "" {
+		for _, op := range ops {
+			if op.name == operationName {
+				return op, nil
+			}
+		}
+		return graphQLOperation{}, fmt.Errorf("the graphql operation %s is not in the document", operationName)
+	}
+	switch len(ops) {
+	case 0:
+		return graphQLOperation{}, errors.New("the graphql document has no operation")
+	case 1:
+		return ops[0], nil
+	default:
+		return graphQLOperation{}, errors.New("the graphql document has several operations, but no operation name")
+	}
+}
+
+// scanGraphQLOperations lists the operations defined at the top level of a GraphQL document
+func scanGraphQLOperations(document string) ([]graphQLOperation, error) {
+	var ops []graphQLOperation
+	var depth int
+	// inDefinition is set from the keyword of a definition to the end of its selection set,
+	// expectName while the operation name may follow its keyword
+	var inDefinition, expectName bool
+
+	for i := 0; i < len(document); {
+		c := document[i]
+		switch {
+		case c == '#':
+			for i < len(document) && document[i] != '\n' && document[i] != '\r' {
+				i++
+			}
+		case c == '"':
+			end, err := skipGraphQLString(document, i)
+			if err != nil {
+				return nil, err
+			}
+			i = end
+		case c == '{' || c == '(' || c == '[':
+			if depth == 0 && c == '{' && !inDefinition {
+				// the query shorthand
+				ops = append(ops, graphQLOperation{kind: graphQLQuery})
+				inDefinition = true
+			}
+			expectName = false
+			depth++
+			i++
+		case c == '}' || c == ')' || c == ']':
+			depth--
+			if depth < 0 {
+				return nil, errors.New("unbalanced graphql document")
+			}
+			if depth == 0 && c == '}' {
+				inDefinition = false
+			}
+			i++
+		case c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+			start := i
+			for i < len(document) && isGraphQLNameChar(document[i]) {
+				i++
+			}
+			if depth > 0 {
+				continue
+			}
+			name := document[start:i]
+			switch {
+			case expectName:
+				ops[len(ops)-1].name = name
+				expectName = false
+			case inDefinition:
+			case name == graphQLQuery || name == graphQLMutation || name == graphQLSubscription:
+				ops = append(ops, graphQLOperation{kind: name})
+				inDefinition, expectName = true, true
+			case name == "fragment":
+				inDefinition = true
+			default:
+				return nil, fmt.Errorf("unexpected %q in graphql document", name)
+			}
+		default:
+			if c == '@' || c == '$' {
+				expectName = false
+			}
+			i++
+		}
+	}
+	if depth != 0 || inDefinition {
+		return nil, errors.New("unbalanced graphql document")
+	}
+
+	return ops, nil
+}
+
+// skipGraphQLString returns the position past the string or block string starting at i
+func skipGraphQLString(document string, i int) (int, error) {
+	if strings.HasPrefix(document[i:], `"""`) {
+		for j := i + 3; j < len(document); j++ {
+			switch {
+			case strings.HasPrefix(document[j:], `\"""`):
+				j += 3
+			case strings.HasPrefix(document[j:], `"""`):
+				return j + 3, nil
+			}
+		}
+		return 0, errors.New("unterminated graphql block string")
+	}
+	for j := i + 1; j < len(document); j++ {
+		switch document[j] {
+		case '\\':
+			j++
+		case '"':
+			return j + 1, nil
+		case '\n', '\r':
+			return 0, errors.New("unterminated graphql string")
+		}
+	}
+
+	return 0, errors.New("unterminated graphql string")
+}
+
+func isGraphQLNameChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// graphQLAdmission checks the roles required by the operations of a GraphQL request, returning the first
+// operation denied, if any
+func graphQLAdmission(rules []*GraphQLOperation, ops []graphQLOperation, roles []string) (graphQLOperation, bool) {
+	for _, op := range ops {
+		for _, rule := range rules {
+			if rule.matches(op) && !hasAccess(rule.Roles, roles, !rule.RequireAnyRole, false) {
+				return op, false
+			}
+		}
+	}
+
+	return graphQLOperation{}, true
+}
+
+// isGraphQLValid checks the GraphQL settings of a resource
+func isGraphQLValid(resource *Resource) error {
+	if !resource.GraphQL {
+		if len(resource.GraphQLOperations) > 0 {
+			return fmt.Errorf("the resource %s has graphql-operations, but is not a graphql resource", resource.URL)
+		}
+		return nil
+	}
+	if resource.WhiteListed {
+		return fmt.Errorf("the white-listed resource %s has no admission of graphql operations", resource.URL)
+	}
+	for _, rule := range resource.GraphQLOperations {
+		switch strings.ToLower(rule.Type) {
+		case "", graphQLQuery, graphQLMutation, graphQLSubscription:
+		default:
+			return fmt.Errorf("invalid graphql operation type %q on resource %s, should be query, mutation or subscription", rule.Type, resource.URL)
+		}
+		if len(rule.Roles) == 0 {
+			return fmt.Errorf("the graphql operations %s %s of resource %s require no roles", rule.Type, rule.Name, resource.URL)
+		}
+	}
+
+	return nil
+}
+
+// graphQLAdmissionContext admits the operations of a request to a GraphQL resource, responding to the denied
+// and invalid requests. The returned context is revoked unless the operations are permitted.
+func (r *oauthProxy) graphQLAdmissionContext(w http.ResponseWriter, req *http.Request, resource *Resource, user *userContext) (context.Context, bool) {
+	_, logger := r.traceSpanRequest(req)
+
+	ops, err := readGraphQLOperations(req)
+	if err != nil {
+		r.errorResponse(w, req, "invalid graphql request", http.StatusBadRequest, err)
+		return r.revokeProxy(w, req), false
+	}
+
+	var roles []string
+	var email string
+	if user != nil {
+		roles, email = user.roles, user.email
+	}
+	if op, ok := graphQLAdmission(resource.GraphQLOperations, ops, roles); !ok {
+		logger.Warn("access denied, invalid roles for graphql operation",
+			zap.String("access", "denied"),
+			zap.String("email", email),
+			zap.String("resource", resource.URL),
+			zap.String("operation_type", op.kind),
+			zap.String("operation_name", op.name))
+
+		return r.accessForbidden(w, req), false
+	}
+
+	return req.Context(), true
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScanGraphQLOperations(t *testing.T) {
+	cs := []struct {
+		Document string
+		Expected []graphQLOperation
+	}{
+		{Document: `{ me { name } }`, Expected: []graphQLOperation{{kind: "query"}}},
+		{Document: `query { me { name } }`, Expected: []graphQLOperation{{kind: "query"}}},
+		{Document: `query Me($id: ID = "}") @cached { me(id: $id) { name } }`, Expected: []graphQLOperation{{kind: "query", name: "Me"}}},
+		{
+			Document: `
+# a comment with a mutation { }
+mutation Rename($input: In = {name: "x"}) { rename(input: $input) { ...F } }
+fragment F on User { name }
+subscription OnRename { renamed { name } }`,
+			Expected: []graphQLOperation{{kind: "mutation", name: "Rename"}, {kind: "subscription", name: "OnRename"}},
+		},
+		{Document: `query Q { search(text: """ a "quoted" } """) { id } }`, Expected: []graphQLOperation{{kind: "query", name: "Q"}}},
+	}
+	for i, c := range cs {
+		ops, err := scanGraphQLOperations(c.Document)
+		require.NoError(t, err, "case %d", i)
+		assert.Equal(t, c.Expected, ops, "case %d", i)
+	}
+
+	for i, document := range []string{`{ me `, `query { me } }`, `type Query { me: User }`, `query Q { f(a: "open) }`} {
+		_, err := scanGraphQLOperations(document)
+		assert.Error(t, err, "case %d", i)
+	}
+}
+
+func TestParseGraphQLOperation(t *testing.T) {
+	document := `query List { items { id } } mutation Delete { delete(id: 1) }`
+	op, err := parseGraphQLOperation(document, "Delete")
+	require.NoError(t, err)
+	assert.Equal(t, graphQLOperation{kind: "mutation", name: "Delete"}, op)
+
+	_, err = parseGraphQLOperation(document, "")
+	assert.Error(t, err)
+	_, err = parseGraphQLOperation(document, "Missing")
+	assert.Error(t, err)
+	_, err = parseGraphQLOperation("", "")
+	assert.Error(t, err)
+}
+
+func TestGraphQLAdmission(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		_, _ = w.Write(append([]byte("upstream received: "), body...))
+	}))
+	defer upstream.Close()
+
+	cfg := newFakeKeycloakConfig()
+	cfg.Resources = []*Resource{
+		{
+			URL:          "/graphql",
+			Methods:      allHTTPMethods,
+			Upstream:     upstream.URL,
+			MaxIdleConns: 10,
+			GraphQL:      true,
+			GraphQLOperations: []*GraphQLOperation{
+				{Type: "mutation", Roles: []string{"writer"}},
+				{Name: "Audit", Roles: []string{"auditor"}},
+			},
+		},
+	}
+	mutation := `{"query": "mutation Rename { rename(name: \"x\") }"}`
+	requests := []fakeRequest{
+		{
+			URI:                     "/graphql?query=" + "{me{name}}",
+			HasToken:                true,
+			ExpectedCode:            http.StatusOK,
+			ExpectedContentContains: "upstream received",
+		},
+		{
+			URI:                     "/graphql",
+			Method:                  http.MethodPost,
+			Body:                    mutation,
+			Headers:                 map[string]string{"Content-Type": "application/json"},
+			HasToken:                true,
+			Roles:                   []string{"writer"},
+			ExpectedCode:            http.StatusOK,
+			ExpectedContentContains: "upstream received: " + mutation,
+		},
+		{
+			URI:          "/graphql",
+			Method:       http.MethodPost,
+			Body:         mutation,
+			Headers:      map[string]string{"Content-Type": "application/json"},
+			HasToken:     true,
+			ExpectedCode: http.StatusForbidden,
+		},
+		{
+			// a denied operation in a batch denies the batch
+			URI:          "/graphql",
+			Method:       http.MethodPost,
+			Body:         `[{"query": "{ me { name } }"}, {"query": "query Audit { logs }"}]`,
+			Headers:      map[string]string{"Content-Type": "application/json"},
+			HasToken:     true,
+			Roles:        []string{"writer"},
+			ExpectedCode: http.StatusForbidden,
+		},
+		{
+			URI:                     "/graphql?operationName=Audit",
+			Method:                  http.MethodPost,
+			Body:                    "query List { items } query Audit { logs }",
+			Headers:                 map[string]string{"Content-Type": "application/graphql"},
+			HasToken:                true,
+			Roles:                   []string{"auditor"},
+			ExpectedCode:            http.StatusOK,
+			ExpectedContentContains: "upstream received",
+		},
+		{
+			URI:          "/graphql",
+			Method:       http.MethodPost,
+			Body:         `{"query": "query List { items } query Audit { logs }"}`,
+			Headers:      map[string]string{"Content-Type": "application/json"},
+			HasToken:     true,
+			Roles:        []string{"auditor", "writer"},
+			ExpectedCode: http.StatusBadRequest,
+		},
+	}
+	newFakeProxy(cfg).RunTests(t, requests)
+}
+
+func TestGraphQLValid(t *testing.T) {
+	valid := &Resource{URL: "/graphql", GraphQL: true, GraphQLOperations: []*GraphQLOperation{{Type: "Mutation", Roles: []string{"writer"}}}}
+	assert.NoError(t, valid.valid())
+
+	cs := []*Resource{
+		{URL: "/graphql", GraphQLOperations: []*GraphQLOperation{{Type: "mutation", Roles: []string{"writer"}}}},
+		{URL: "/graphql", GraphQL: true, GraphQLOperations: []*GraphQLOperation{{Type: "update", Roles: []string{"writer"}}}},
+		{URL: "/graphql", GraphQL: true, GraphQLOperations: []*GraphQLOperation{{Type: "mutation"}}},
+		{URL: "/graphql", GraphQL: true, WhiteListed: true},
+	}
+	for i, c := range cs {
+		assert.Error(t, c.valid(), "case %d", i)
+	}
+}
@@ -0,0 +1,42 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+
+	"golang.org/x/net/http2"
+)
+
+// newH2CTransport creates an instrumented transport speaking HTTP/2 without TLS to the upstream, e.g. to gRPC
+// services. The requests are multiplexed on a single connection per upstream.
+func newH2CTransport(name string, dialer dialContextFunc) http.RoundTripper {
+	dial := instrumentDialer(name, dialer, nil)
+
+	return &instrumentedTransport{
+		RoundTripper: &http2.Transport{
+			AllowHTTP: true,
+			// the connections are dialed in clear text, despite the name
+			DialTLS: func(network, address string, _ *tls.Config) (net.Conn, error) {
+				return dial(context.Background(), network, address)
+			},
+		},
+		name: name,
+	}
+}
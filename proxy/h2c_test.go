@@ -0,0 +1,65 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func TestUpstreamH2C(t *testing.T) {
+	// a cleartext HTTP/2 upstream, as gRPC services are
+	upstream := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Upstream-Proto", req.Proto)
+		w.Header().Set("Content-Type", "application/grpc")
+	}), &http2.Server{}))
+	defer upstream.Close()
+
+	cfg := newFakeKeycloakConfig()
+	cfg.Resources = []*Resource{
+		{
+			URL:         "/grpc.health.v1.Health/*",
+			Methods:     allHTTPMethods,
+			Upstream:    upstream.URL,
+			UpstreamH2C: true,
+		},
+		{
+			URL:          "/rest/*",
+			Methods:      allHTTPMethods,
+			Upstream:     upstream.URL,
+			MaxIdleConns: 10,
+		},
+	}
+	newFakeProxy(cfg).RunTests(t, []fakeRequest{
+		{
+			URI:             "/grpc.health.v1.Health/Check",
+			Method:          http.MethodPost,
+			HasToken:        true,
+			ExpectedCode:    http.StatusOK,
+			ExpectedHeaders: map[string]string{"X-Upstream-Proto": "HTTP/2.0"},
+		},
+		{
+			URI:             "/rest/health",
+			HasToken:        true,
+			ExpectedCode:    http.StatusOK,
+			ExpectedHeaders: map[string]string{"X-Upstream-Proto": "HTTP/1.1"},
+		},
+	})
+}
@@ -13,15 +13,13 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package main
+package proxy
 
 import (
-	"bytes"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 
 	"net/http"
 	"net/http/pprof"
@@ -36,6 +34,7 @@ import (
 	"github.com/oneconcern/keycloak-gatekeeper/version"
 
 	"github.com/go-chi/chi"
+	uuid "github.com/satori/go.uuid"
 	"go.uber.org/zap"
 )
 
@@ -58,13 +57,14 @@ func (r *oauthProxy) getRedirectionURL(w http.ResponseWriter, req *http.Request)
 		// @QUESTION: should I use the X-Forwarded-<header>?? ..
 		redirect = fmt.Sprintf("%s://%s",
 			defaultTo(req.Header.Get("X-Forwarded-Proto"), scheme),
-			defaultTo(req.Header.Get("X-Forwarded-Host"), req.Host))
+			requestHost(req))
 	default:
 		redirect = r.config.RedirectionURL
 	}
 
+	// a strict state is checked by the callback, against the encrypted cookie
 	state, _ := req.Cookie(requestStateCookie)
-	if state != nil && req.URL.Query().Get("state") != state.Value {
+	if !r.config.EnableStrictState && state != nil && req.URL.Query().Get("state") != state.Value {
 		logger.Error("state in cookie and url query parameter do not match", zap.String("cookie-state", state.Value),
 			zap.String("url-state", req.URL.Query().Get("state")))
 		// clear all cookies in response
@@ -72,7 +72,7 @@ func (r *oauthProxy) getRedirectionURL(w http.ResponseWriter, req *http.Request)
 		r.errorResponse(w, req.WithContext(ctx), "state parameter mismatch", http.StatusForbidden, nil)
 		return ""
 	}
-	return fmt.Sprintf("%s%s", redirect, r.config.WithOAuthURI("callback"))
+	return fmt.Sprintf("%s%s", redirect, r.withOAuthURI(req, "callback"))
 }
 
 // oauthAuthorizationHandler is responsible for performing the redirection to oauth provider
@@ -86,6 +86,9 @@ func (r *oauthProxy) oauthAuthorizationHandler(w http.ResponseWriter, req *http.
 		r.errorResponse(w, req.WithContext(ctx), "", http.StatusNotAcceptable, nil)
 		return
 	}
+	if !r.countAuthRedirect(w, req.WithContext(ctx)) {
+		return
+	}
 
 	client, err := r.getOAuthClient(r.getRedirectionURL(w, req.WithContext(ctx)))
 	if err != nil {
@@ -99,7 +102,15 @@ func (r *oauthProxy) oauthAuthorizationHandler(w http.ResponseWriter, req *http.
 		accessType = "offline"
 	}
 
-	authURL := client.AuthCodeURL(req.URL.Query().Get("state"), accessType, "")
+	state := req.URL.Query().Get("state")
+	if r.config.EnableStrictState {
+		if state, err = r.issueState(w, req.WithContext(ctx), ""); err != nil {
+			r.errorResponse(w, req.WithContext(ctx), "failed to issue the state of the authorization", http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	authURL := r.withResponseMode(client.AuthCodeURL(state, accessType, ""))
 	logger.Debug("incoming authorization request from client address",
 		zap.String("access_type", accessType),
 		zap.String("auth_url", authURL),
@@ -119,6 +130,51 @@ func (r *oauthProxy) oauthAuthorizationHandler(w http.ResponseWriter, req *http.
 	r.redirectToURL(authURL, w, req.WithContext(ctx), http.StatusTemporaryRedirect)
 }
 
+// silentRenewalHandler performs an authorization without any user interaction (prompt=none), so the session
+// may be renewed from a hidden iframe whenever the sso session on the provider is still valid. The callback
+// then responds with a 204 on success or a 401 when the provider requires an interactive login.
+func (r *oauthProxy) silentRenewalHandler(w http.ResponseWriter, req *http.Request) {
+	ctx, span, logger := r.traceSpan(req.Context(), "silent renewal handler")
+	if span != nil {
+		defer span.End()
+	}
+
+	if r.config.SkipTokenVerification {
+		r.errorResponse(w, req.WithContext(ctx), "", http.StatusNotAcceptable, nil)
+		return
+	}
+
+	client, err := r.getOAuthClient(r.getRedirectionURL(w, req.WithContext(ctx)))
+	if err != nil {
+		r.errorResponse(w, req.WithContext(ctx), "failed to retrieve the oauth client for silent renewal", http.StatusInternalServerError, err)
+		return
+	}
+
+	var accessType string
+	if containedIn("offline", r.config.Scopes, false) {
+		accessType = "offline"
+	}
+
+	// step: the state marks this authorization as silent for the callback handler
+	var state string
+	if r.config.EnableStrictState {
+		if state, err = r.issueState(w, req.WithContext(ctx), silentStatePrefix); err != nil {
+			r.errorResponse(w, req.WithContext(ctx), "failed to issue the state of the silent renewal", http.StatusInternalServerError, err)
+			return
+		}
+	} else {
+		state = silentStatePrefix + uuid.NewV4().String()
+		r.dropCallbackCookie(w, req.Host, requestStateCookie, state, 0)
+	}
+
+	authURL := r.withResponseMode(client.AuthCodeURL(state, accessType, "none"))
+	logger.Debug("incoming silent renewal request from client address",
+		zap.String("auth_url", authURL),
+		zap.String("client_ip", req.RemoteAddr))
+
+	r.redirectToURL(authURL, w, req.WithContext(ctx), http.StatusTemporaryRedirect)
+}
+
 // oauthCallbackHandler is responsible for handling the response from oauth service
 func (r *oauthProxy) oauthCallbackHandler(w http.ResponseWriter, req *http.Request) {
 	ctx, span, logger := r.traceSpan(req.Context(), "oauthCallbackHandler")
@@ -130,6 +186,33 @@ func (r *oauthProxy) oauthCallbackHandler(w http.ResponseWriter, req *http.Reque
 		r.errorResponse(w, req.WithContext(ctx), "", http.StatusNotAcceptable, nil)
 		return
 	}
+	// step: a posted authorization response is handled as the query of a redirection
+	if req.Method == http.MethodPost {
+		if err := formPostCallback(req); err != nil {
+			r.errorResponse(w, req.WithContext(ctx), "invalid authorization response", http.StatusBadRequest, err)
+			return
+		}
+	}
+	// step: a silent renewal does not end with a redirection, but with a status only
+	silent := strings.HasPrefix(req.URL.Query().Get("state"), silentStatePrefix)
+	if silent && req.URL.Query().Get("error") != "" {
+		r.errorResponse(w, req.WithContext(ctx), "silent renewal refused by the provider: "+req.URL.Query().Get("error"), http.StatusUnauthorized, nil)
+		return
+	}
+
+	// step: the browser may have withheld the cookies from the redirection of the provider
+	if r.config.EnableCallbackInterstitial && isCallbackWithoutCookies(req) && r.callbackInterstitial(w, req.WithContext(ctx)) {
+		return
+	}
+
+	// step: the callback must follow an authorization request issued to this client
+	if r.config.EnableStrictState {
+		if err := r.consumeState(w, req.WithContext(ctx)); err != nil {
+			r.errorResponse(w, req.WithContext(ctx), "invalid state parameter", http.StatusForbidden, err)
+			return
+		}
+	}
+
 	// step: ensure we have a authorization code
 	code := req.URL.Query().Get("code")
 	if code == "" {
@@ -144,6 +227,10 @@ func (r *oauthProxy) oauthCallbackHandler(w http.ResponseWriter, req *http.Reque
 	}
 
 	resp, err := exchangeAuthenticationCode(client, code)
+	if isProviderUnavailable(err) {
+		r.providerUnavailable(w, req.WithContext(ctx), "exchange", err)
+		return
+	}
 	if err != nil {
 		r.accessForbidden(w, req.WithContext(ctx), "unable to exchange code for access token", err.Error())
 		return
@@ -157,24 +244,40 @@ func (r *oauthProxy) oauthCallbackHandler(w http.ResponseWriter, req *http.Reque
 		r.accessForbidden(w, req.WithContext(ctx), "unable to parse ID token for identity", err.Error())
 		return
 	}
-	access, id, err := parseToken(resp.AccessToken)
-	if err == nil {
-		token = access
-		identity = id
-	} else {
-		logger.Warn("unable to parse the access token, using id token only", zap.Error(err))
+	if !r.profile.idTokenSession {
+		access, id, err := parseToken(resp.AccessToken)
+		if err == nil {
+			token = access
+			identity = id
+		} else {
+			logger.Warn("unable to parse the access token, using id token only", zap.Error(err))
+		}
 	}
 
 	// step: check the access token is valid
-	if err = verifyToken(r.client, token); err != nil {
+	if err = r.verifyToken(token); err != nil {
 		r.accessForbidden(w, req.WithContext(ctx), "unable to verify the ID token", err.Error())
 		return
 	}
 	accessToken := token.Encode()
+	// step: the session has just been opened by the provider, it needs no validation until the next interval
+	r.recordSessionValidation(token)
+
+	// step: the session lasts as long as the refresh token, or as the access token without one
+	sessionDuration := time.Until(identity.ExpiresAt)
+	if r.config.EnableRefreshTokens && resp.RefreshToken != "" {
+		sessionDuration = r.getAccessCookieExpiration(token, resp.RefreshToken)
+	}
 
-	// step: are we encrypting the access token?
-	if r.config.EnableEncryptedToken || r.config.ForceEncryptedCookie {
-		if accessToken, err = encodeText(accessToken, r.config.EncryptionKey); err != nil {
+	// step: are we keeping the access token in the store, or encrypting it?
+	switch {
+	case r.config.EnableServerSideTokens:
+		if accessToken, err = r.storeAccessToken("", accessToken, sessionDuration); err != nil {
+			r.errorResponse(w, req.WithContext(ctx), "unable to store the access token", http.StatusInternalServerError, err)
+			return
+		}
+	case r.config.EnableEncryptedToken || r.config.ForceEncryptedCookie:
+		if accessToken, err = r.encodeCookieValue(accessToken); err != nil {
 			r.errorResponse(w, req.WithContext(ctx), "unable to encode the access token", http.StatusInternalServerError, err)
 			return
 		}
@@ -191,20 +294,25 @@ func (r *oauthProxy) oauthCallbackHandler(w http.ResponseWriter, req *http.Reque
 	// step: does the response have a refresh token and we do NOT ignore refresh tokens?
 	if r.config.EnableRefreshTokens && resp.RefreshToken != "" {
 		var encrypted string
-		encrypted, err = encodeText(resp.RefreshToken, r.config.EncryptionKey)
+		encrypted, err = r.encodeRefreshToken(resp.RefreshToken)
 		if err != nil {
 			r.errorResponse(w, req.WithContext(ctx), "failed to encrypt the refresh token", http.StatusInternalServerError, err)
 			return
 		}
 
 		// drop in the access token - cookie expiration = access token
-		r.dropAccessTokenCookie(req.WithContext(ctx), w, accessToken, r.getAccessCookieExpiration(token, resp.RefreshToken))
+		r.dropAccessTokenCookie(req.WithContext(ctx), w, accessToken, sessionDuration)
 
 		switch r.useStore() {
 		case true:
 			if err = r.StoreRefreshToken(token, encrypted); err != nil {
 				logger.Warn("failed to save the refresh token in the store", zap.Error(err))
 			}
+			if user, err := r.identities.extractIdentity(token); err == nil {
+				if err = r.StoreSession(user, realIP(req), sessionDuration); err != nil {
+					logger.Warn("failed to save the session in the store", zap.Error(err))
+				}
+			}
 		default:
 			// notes: not all idp refresh tokens are readable, google for example, so we attempt to decode into
 			// a jwt and if possible extract the expiration, else we default to 10 days
@@ -218,6 +326,11 @@ func (r *oauthProxy) oauthCallbackHandler(w http.ResponseWriter, req *http.Reque
 		r.dropAccessTokenCookie(req.WithContext(ctx), w, accessToken, time.Until(identity.ExpiresAt))
 	}
 
+	if silent {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
 	// step: decode the request variable
 	redirectURI := "/"
 	if req.URL.Query().Get("state") != "" {
@@ -240,12 +353,23 @@ func (r *oauthProxy) oauthCallbackHandler(w http.ResponseWriter, req *http.Reque
 					zap.String("encoded_value", unescapedValue))
 			}
 			redirectURI = string(decoded)
+
+			// a landing url shared by subdomains, or recorded by the forward-auth endpoint, is only used once
+			if r.config.EnableCrossSubdomainSession || r.config.EnableForwardAuth {
+				r.dropCookie(w, req.Host, requestURICookie, "", -10*time.Hour)
+			}
 		}
 	}
 
-	if r.config.BaseURI != "" {
-		// assuming state starts with slash
-		redirectURI = r.config.BaseURI + redirectURI
+	if redirectURI != "" && !r.config.isAllowedRedirect(redirectURI, req.Host) {
+		logger.Warn("app did send a redirectURI in cookie which is not allowed: redirecting to / instead",
+			zap.String("request_uri", redirectURI))
+		redirectURI = "/"
+	}
+
+	if u, err := url.Parse(redirectURI); r.basePath(req) != "" && (err != nil || u.Scheme == "") {
+		// assuming state starts with slash, unless this is an absolute url
+		redirectURI = r.basePath(req) + redirectURI
 	}
 
 	r.redirectToURL(redirectURI, w, req.WithContext(ctx), http.StatusTemporaryRedirect)
@@ -268,7 +392,7 @@ func (r *oauthProxy) loginHandler(w http.ResponseWriter, req *http.Request) {
 			return "request does not have both username and password", http.StatusBadRequest, errors.New("no credentials")
 		}
 
-		client, err := r.client.OAuthClient()
+		client, err := r.getProviderClient().OAuthClient()
 		if err != nil {
 			return "unable to create the oauth client for user_credentials request", http.StatusInternalServerError, err
 		}
@@ -279,17 +403,27 @@ func (r *oauthProxy) loginHandler(w http.ResponseWriter, req *http.Request) {
 			if strings.HasPrefix(err.Error(), oauth2.ErrorInvalidGrant) {
 				return "invalid user credentials provided", http.StatusUnauthorized, err
 			}
+			if isProviderUnavailable(err) {
+				return "", http.StatusServiceUnavailable, err
+			}
 			return "unable to request the access token via grant_type 'password'", http.StatusInternalServerError, err
 		}
 		// @metric observe the time taken for a login request
 		oauthLatencyMetric.WithLabelValues("login").Observe(time.Since(start).Seconds())
 
-		_, identity, err := parseToken(token.AccessToken)
+		access, identity, err := parseToken(token.AccessToken)
 		if err != nil {
 			return "unable to decode the access token", http.StatusNotImplemented, err
 		}
+		r.recordSessionValidation(access)
 
-		r.dropAccessTokenCookie(req.WithContext(ctx), w, token.AccessToken, time.Until(identity.ExpiresAt))
+		accessToken := token.AccessToken
+		if r.config.EnableServerSideTokens {
+			if accessToken, err = r.storeAccessToken("", accessToken, time.Until(identity.ExpiresAt)); err != nil {
+				return "unable to store the access token", http.StatusInternalServerError, err
+			}
+		}
+		r.dropAccessTokenCookie(req.WithContext(ctx), w, accessToken, time.Until(identity.ExpiresAt))
 
 		// @metric a token has been issued
 		oauthTokensMetric.WithLabelValues("login").Inc()
@@ -308,7 +442,10 @@ func (r *oauthProxy) loginHandler(w http.ResponseWriter, req *http.Request) {
 
 		return "", http.StatusOK, nil
 	}()
-	if err != nil {
+	switch {
+	case code == http.StatusServiceUnavailable:
+		r.providerUnavailable(w, req.WithContext(ctx), "login", err)
+	case err != nil:
 		r.errorResponse(w, req.WithContext(ctx), strings.Join([]string{errorMsg, "client_ip", req.RemoteAddr}, ","), code, err)
 	}
 }
@@ -345,36 +482,45 @@ func (r *oauthProxy) logoutHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// step: can either use the id token or the refresh token
-	identityToken := user.token.Encode()
-	if refresh, _, err := r.retrieveRefreshToken(req, user); err == nil {
-		identityToken = refresh
+	// step: retrieve the refresh token to be revoked, if any
+	refresh, _, err := r.retrieveRefreshToken(req, user)
+	if err != nil {
+		refresh = ""
 	}
 	r.clearAllCookies(req, w)
 
 	// @metric increment the logout counter
 	oauthTokensMetric.WithLabelValues("logout").Inc()
 
+	// step: let the applications clean up their own sessions
+	if len(r.config.LogoutWebhooks) > 0 {
+		go r.notifyLogout(user)
+	}
+
 	// step: check if the user has a state session and if so revoke it
 	if r.useStore() {
 		go func() {
 			if err := r.DeleteRefreshToken(user.token); err != nil {
 				logger.Error("unable to remove the refresh token from store", zap.Error(err))
 			}
+			if err := r.DeleteStoredSession(sessionID(user)); err != nil {
+				logger.Error("unable to remove the session from store", zap.Error(err))
+			}
+			if user.sessionHandle != "" {
+				if err := r.deleteStoredAccessToken(user.sessionHandle); err != nil {
+					logger.Error("unable to remove the access token from store", zap.Error(err))
+				}
+			}
+			if r.config.EnableCSRF && r.isStoredCSRF() {
+				if err := r.store.Delete(csrfKey(csrfSession(user))); err != nil {
+					logger.Error("unable to remove the CSRF token from store", zap.Error(err))
+				}
+			}
 		}()
 	}
 
-	// set the default revocation url
-	revokeDefault := ""
-	if r.idp.EndSessionEndpoint != nil {
-		revokeDefault = r.idp.EndSessionEndpoint.String()
-	}
-	revocationURL := defaultTo(r.config.RevocationEndpoint, revokeDefault)
-
 	// @check if we should redirect to the provider
 	if r.config.EnableLogoutRedirect {
-		sendTo := fmt.Sprintf("%s/protocol/openid-connect/logout", strings.TrimSuffix(r.config.DiscoveryURL, "/.well-known/openid-configuration"))
-
 		// @step: if no redirect uri is set
 		if redirectURL == "" {
 			// @step: we first check for a redirection-url and then host header
@@ -385,58 +531,17 @@ func (r *oauthProxy) logoutHandler(w http.ResponseWriter, req *http.Request) {
 			}
 		}
 
-		r.redirectToURL(fmt.Sprintf("%s?redirect_uri=%s", sendTo, url.QueryEscape(redirectURL)), w, req, http.StatusTemporaryRedirect)
-
-		return
-	}
-
-	// step: do we have a revocation endpoint?
-	if revocationURL != "" {
-		client, err := r.client.OAuthClient()
-		if err != nil {
-			r.errorResponse(w, req.WithContext(ctx), "unable to retrieve the openid client", http.StatusInternalServerError, err)
-			return
-		}
-
-		// step: add the authentication headers
-		encodedID := url.QueryEscape(r.config.ClientID)
-		encodedSecret := url.QueryEscape(r.config.ClientSecret)
-
-		// step: construct the url for revocation
-		request, err := http.NewRequestWithContext(ctx, http.MethodPost, revocationURL, bytes.NewBufferString(fmt.Sprintf("refresh_token=%s", identityToken)))
-		if err != nil {
-			r.errorResponse(w, req.WithContext(ctx), "unable to construct the revocation request", http.StatusInternalServerError, err)
-			return
-		}
-
-		// step: add the authentication headers and content-type
-		request.SetBasicAuth(encodedID, encodedSecret)
-		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-		start := time.Now()
-		response, err := client.HttpClient().Do(request)
-		if err != nil {
-			logger.Error("unable to post to revocation endpoint", zap.Error(err))
+		sendTo, err := r.profile.logoutURL(r, user, redirectURL)
+		if err == nil {
+			r.redirectToURL(sendTo, w, req, http.StatusTemporaryRedirect)
 			return
 		}
-		defer func() {
-			_ = response.Body.Close()
-		}()
-
-		oauthLatencyMetric.WithLabelValues("revocation").Observe(time.Since(start).Seconds())
-
-		// step: check the response
-		switch response.StatusCode {
-		case http.StatusNoContent:
-			logger.Info("successfully logged out of the endpoint", zap.String("email", user.email))
-		default:
-			content, _ := ioutil.ReadAll(response.Body)
-			logger.Error("invalid response from revocation endpoint",
-				zap.Int("status", response.StatusCode),
-				zap.String("response", fmt.Sprintf("%s", content)))
-		}
+		logger.Warn("unable to redirect to the provider for logging out", zap.Error(err))
 	}
 
+	// step: revoke the tokens on the provider
+	r.revokeTokens(ctx, user, refresh)
+
 	// step: should we redirect the user
 	if redirectURL != "" {
 		r.redirectToURL(redirectURL, w, req.WithContext(ctx), http.StatusTemporaryRedirect)
@@ -487,9 +592,11 @@ func (r *oauthProxy) refreshHandler(w http.ResponseWriter, req *http.Request) {
 	}
 
 	if err = r.refreshToken(w, req.WithContext(ctx), user); err != nil {
-		switch err {
-		case ErrEncode, ErrEncryption:
+		switch {
+		case err == ErrEncode || err == ErrEncryption:
 			r.errorResponse(w, req.WithContext(ctx), err.Error(), http.StatusInternalServerError, err)
+		case isProviderUnavailable(err):
+			r.providerUnavailable(w, req.WithContext(ctx), "renew", err)
 		default:
 			r.errorResponse(w, req.WithContext(ctx), err.Error(), http.StatusUnauthorized, err)
 		}
@@ -518,10 +625,47 @@ func (r *oauthProxy) tokenHandler(w http.ResponseWriter, req *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// csrfTokenHandler returns the current CSRF token, so clients don't need to look for it in a cookie or a header
+func (r *oauthProxy) csrfTokenHandler(w http.ResponseWriter, req *http.Request) {
+	ctx, span, _ := r.traceSpan(req.Context(), "csrf token handler")
+	if span != nil {
+		defer span.End()
+	}
+
+	user, err := r.getIdentity(req)
+	if err != nil {
+		r.errorResponse(w, req.WithContext(ctx), "", http.StatusUnauthorized, nil)
+		return
+	}
+
+	token := r.csrfToken(req)
+	if r.isDoubleSubmitCSRF() {
+		token = r.issueCSRFToken(w, req, user)
+	}
+
+	w.Header().Set("Content-Type", jsonMime)
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(csrfTokenResponse{
+		Header: r.config.CSRFHeader,
+		Token:  token,
+	})
+}
+
 // healthHandler is a health check handler for the service
 func (r *oauthProxy) healthHandler(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", jsonMime)
 	w.Header().Set(versionHeader, version.GetVersion())
+	if r.isDraining() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(`{"status":"draining"}`))
+		return
+	}
+	if r.health != nil {
+		// a failing dependency is reported, but does not fail the probe
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(r.getHealthStatus())
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 	_, _ = w.Write([]byte(`{"status":"OK"}`))
 }
@@ -576,7 +720,7 @@ func (r *oauthProxy) retrieveRefreshToken(req *http.Request, user *userContext)
 	}
 
 	encrypted = token // returns encrypted, avoids encoding twice
-	token, err = decodeText(token, r.config.EncryptionKey)
+	token, err = decodeText(token, r.refreshTokenKey())
 	return
 }
 
@@ -611,7 +755,8 @@ func (r *oauthProxy) refreshToken(w http.ResponseWriter, req *http.Request, user
 	// exp: expiration of the access token
 	// expiresIn: expiration of the ID token
 
-	token, newRefreshToken, accessExpiresAt, refreshExpiresIn, err := getRefreshedToken(r.client, refresh)
+	// concurrent requests with the same expired access token share a single refresh
+	refreshed, leader, err := r.getRefreshedTokenOnce(refresh)
 	if err != nil {
 		switch err {
 		case ErrRefreshTokenExpired:
@@ -627,7 +772,8 @@ func (r *oauthProxy) refreshToken(w http.ResponseWriter, req *http.Request, user
 		return err
 	}
 
-	accessExpiresIn := time.Until(accessExpiresAt)
+	token, newRefreshToken, refreshExpiresIn := refreshed.token, refreshed.refreshToken, refreshed.refreshExpiresIn
+	accessExpiresIn := time.Until(refreshed.accessExpiresAt)
 
 	// get the expiration of the new refresh token
 	if newRefreshToken != "" {
@@ -646,9 +792,17 @@ func (r *oauthProxy) refreshToken(w http.ResponseWriter, req *http.Request, user
 		zap.Duration("expires_in", accessExpiresIn))
 
 	accessToken := token.Encode()
-	if r.config.EnableEncryptedToken || r.config.ForceEncryptedCookie {
+	switch {
+	case r.config.EnableServerSideTokens && user.sessionHandle != "":
+		// the refreshed access token is kept under the handle of the session
+		if accessToken, err = r.storeAccessToken(user.sessionHandle, accessToken, refreshExpiresIn); err != nil {
+			logger.Error("internal error while storing access token",
+				zap.String("client_ip", clientIP), zap.String("email", user.email), zap.Error(err))
+			return err
+		}
+	case r.config.EnableEncryptedToken || r.config.ForceEncryptedCookie:
 		// encrypt access token
-		if accessToken, err = encodeText(accessToken, r.config.EncryptionKey); err != nil {
+		if accessToken, err = r.encodeCookieValue(accessToken); err != nil {
 			logger.Error("internal error while encoding access token",
 				zap.String("client_ip", clientIP), zap.String("email", user.email), zap.Error(err))
 			return ErrEncode
@@ -662,7 +816,7 @@ func (r *oauthProxy) refreshToken(w http.ResponseWriter, req *http.Request, user
 	if newRefreshToken != "" {
 		logger.Debug("renew refresh cookie with new refresh token",
 			zap.Duration("refresh_expires_in", refreshExpiresIn))
-		encryptedRefreshToken, err := encodeText(newRefreshToken, r.config.EncryptionKey)
+		encryptedRefreshToken, err := r.encodeRefreshToken(newRefreshToken)
 		if err != nil {
 			logger.Error("internal error while encrypting refresh token",
 				zap.String("client_ip", clientIP), zap.String("email", user.email), zap.Error(err))
@@ -671,8 +825,9 @@ func (r *oauthProxy) refreshToken(w http.ResponseWriter, req *http.Request, user
 		r.dropRefreshTokenCookie(req.WithContext(ctx), w, encryptedRefreshToken, refreshExpiresIn)
 	}
 
-	if r.useStore() {
-		go func(old, new jose.JWT, encrypted string) {
+	// only the request which performed the refresh updates the store
+	if r.useStore() && leader {
+		go func(old, new jose.JWT, encrypted, session string, ttl time.Duration) {
 			if err := r.DeleteRefreshToken(old); err != nil {
 				logger.Error("failed to remove old token", zap.Error(err))
 			}
@@ -680,7 +835,10 @@ func (r *oauthProxy) refreshToken(w http.ResponseWriter, req *http.Request, user
 				logger.Error("failed to store refresh token", zap.Error(err))
 				return
 			}
-		}(user.token, token, encrypted)
+			if err := r.RenewStoredSession(session, new, ttl); err != nil {
+				logger.Error("failed to renew the session in the store", zap.Error(err))
+			}
+		}(user.token, token, encrypted, sessionID(user), refreshExpiresIn)
 	}
 
 	// update the user with the new access token and inject into the context
@@ -0,0 +1,755 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	resty "gopkg.in/resty.v1"
+)
+
+func TestDebugHandler(t *testing.T) {
+	c := newFakeKeycloakConfig()
+	c.Resources = make([]*Resource, 0)
+	c.EnableProfiling = true
+	requests := []fakeRequest{
+		{URI: "/debug/pprof/no_there", ExpectedCode: http.StatusNotFound},
+		{URI: "/debug/pprof/heap", ExpectedCode: http.StatusOK},
+		{URI: "/debug/pprof/goroutine", ExpectedCode: http.StatusOK},
+		{URI: "/debug/pprof/block", ExpectedCode: http.StatusOK},
+		{URI: "/debug/pprof/threadcreate", ExpectedCode: http.StatusOK},
+		{URI: "/debug/pprof/cmdline", ExpectedCode: http.StatusOK},
+		{URI: "/debug/pprof/trace", ExpectedCode: http.StatusOK},
+		{URI: "/debug/pprof/symbol", ExpectedCode: http.StatusOK},
+		{URI: "/debug/pprof/symbol", Method: http.MethodPost, ExpectedCode: http.StatusOK},
+		{URI: "/debug/pprof/symbol", Method: http.MethodPost, ExpectedCode: http.StatusOK},
+	}
+	newFakeProxy(c).RunTests(t, requests)
+}
+
+func TestDebugHandlerSafeguards(t *testing.T) {
+	c := newFakeKeycloakConfig()
+	c.Resources = make([]*Resource, 0)
+	c.EnableProfiling = true
+	c.ProfilingToken = "profiling"
+	c.ProfilingRateLimit = 2
+	requests := []fakeRequest{
+		{URI: "/debug/pprof/heap", ExpectedCode: http.StatusUnauthorized},
+		{URI: "/debug/pprof/heap", Headers: map[string]string{"Authorization": "Bearer invalid"}, ExpectedCode: http.StatusUnauthorized},
+		{URI: "/debug/pprof/heap", Headers: map[string]string{"Authorization": "Bearer profiling"}, ExpectedCode: http.StatusOK},
+		{URI: "/debug/pprof/symbol", Headers: map[string]string{"Authorization": "Bearer profiling"}, ExpectedCode: http.StatusOK},
+		{URI: "/debug/pprof/heap", Headers: map[string]string{"Authorization": "Bearer profiling"}, ExpectedCode: http.StatusTooManyRequests},
+	}
+	newFakeProxy(c).RunTests(t, requests)
+
+	c.ProfilingToken = ""
+	c.ProfilingRateLimit = 0
+	c.ProfilingDuration = 200 * time.Millisecond
+	wait := func(no int, req *resty.Request, resp *resty.Response) {
+		<-time.After(250 * time.Millisecond)
+	}
+	requests = []fakeRequest{
+		{URI: "/debug/pprof/heap", OnResponse: wait, ExpectedCode: http.StatusOK},
+		{URI: "/debug/pprof/heap", ExpectedCode: http.StatusNotFound},
+	}
+	newFakeProxy(c).RunTests(t, requests)
+}
+
+func TestExpirationHandler(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	uri := cfg.WithOAuthURI(expiredURL)
+	requests := []fakeRequest{
+		{
+			URI:          uri,
+			ExpectedCode: http.StatusUnauthorized,
+		},
+		{
+			URI:             uri,
+			HasToken:        true,
+			Expires:         -48 * time.Hour,
+			ExpectedCode:    http.StatusUnauthorized,
+			ExpectedHeaders: map[string]string{"Content-Type": jsonMime},
+		},
+		{
+			URI:             uri,
+			HasToken:        true,
+			Expires:         14 * time.Hour,
+			ExpectedCode:    http.StatusOK,
+			ExpectedHeaders: map[string]string{"Content-Type": jsonMime},
+		},
+	}
+	newFakeProxy(nil).RunTests(t, requests)
+}
+
+func TestOauthRequestNotProxying(t *testing.T) {
+	requests := []fakeRequest{
+		{URI: "/oauth/test"},
+		{URI: "/oauth/..//oauth/test/"},
+		{URI: "/oauth/expired", Method: http.MethodPost, ExpectedCode: http.StatusMethodNotAllowed},
+		{URI: "/oauth/expiring", Method: http.MethodPost},
+		{URI: "/oauth%2F///../test%2F%2Foauth"},
+	}
+	newFakeProxy(nil).RunTests(t, requests)
+}
+
+func TestLoginHandlerDisabled(t *testing.T) {
+	c := newFakeKeycloakConfig()
+	c.EnableLoginHandler = false
+	requests := []fakeRequest{
+		{URI: c.WithOAuthURI(loginURL), Method: http.MethodPost, ExpectedCode: http.StatusNotImplemented},
+		{URI: c.WithOAuthURI(loginURL), ExpectedCode: http.StatusMethodNotAllowed},
+	}
+	newFakeProxy(c).RunTests(t, requests)
+}
+
+func TestDisabledEndpoints(t *testing.T) {
+	c := newFakeKeycloakConfig()
+	c.EnableLoginHandler = true
+	c.DisabledEndpoints = []string{"login", "/token", "expired/"}
+	requests := []fakeRequest{
+		{URI: c.WithOAuthURI(loginURL), Method: http.MethodPost, ExpectedCode: http.StatusNotFound},
+		{URI: c.WithOAuthURI(loginURL), ExpectedCode: http.StatusNotFound},
+		{URI: c.WithOAuthURI(tokenURL), HasToken: true, ExpectedCode: http.StatusNotFound},
+		{URI: c.WithOAuthURI(expiredURL), HasToken: true, ExpectedCode: http.StatusNotFound},
+		{URI: c.WithOAuthURI(logoutURL), HasToken: true, ExpectedCode: http.StatusOK},
+	}
+	newFakeProxy(c).RunTests(t, requests)
+}
+
+func TestLoginHandlerNotDisabled(t *testing.T) {
+	c := newFakeKeycloakConfig()
+	c.EnableLoginHandler = true
+	requests := []fakeRequest{
+		{URI: "/oauth/login", Method: http.MethodPost, ExpectedCode: http.StatusBadRequest},
+	}
+	newFakeProxy(c).RunTests(t, requests)
+}
+
+func TestLoginHandler(t *testing.T) {
+	uri := newFakeKeycloakConfig().WithOAuthURI(loginURL)
+	requests := []fakeRequest{
+		{
+			URI:          uri,
+			Method:       http.MethodPost,
+			ExpectedCode: http.StatusBadRequest,
+		},
+		{
+			URI:          uri,
+			Method:       http.MethodPost,
+			FormValues:   map[string]string{"username": "test"},
+			ExpectedCode: http.StatusBadRequest,
+		},
+		{
+			URI:          uri,
+			Method:       http.MethodPost,
+			FormValues:   map[string]string{"password": "test"},
+			ExpectedCode: http.StatusBadRequest,
+		},
+		{
+			URI:    uri,
+			Method: http.MethodPost,
+			FormValues: map[string]string{
+				"password": "test",
+				"username": "test",
+			},
+			ExpectedCode: http.StatusOK,
+		},
+		{
+			URI:    uri,
+			Method: http.MethodPost,
+			FormValues: map[string]string{
+				"password": "test",
+				"username": "notmypassword",
+			},
+			ExpectedCode: http.StatusUnauthorized,
+		},
+	}
+	newFakeProxy(nil).RunTests(t, requests)
+}
+
+func TestLogoutHandlerBadRequest(t *testing.T) {
+	requests := []fakeRequest{
+		{
+			URI:             newFakeKeycloakConfig().WithOAuthURI(logoutURL),
+			ExpectedCode:    http.StatusUnauthorized,
+			ExpectedHeaders: map[string]string{"Content-Type": jsonMime},
+		},
+	}
+	newFakeProxy(nil).RunTests(t, requests)
+}
+
+func TestLogoutHandlerBadToken(t *testing.T) {
+	c := newFakeKeycloakConfig()
+	requests := []fakeRequest{
+		{
+			URI:             c.WithOAuthURI(logoutURL),
+			ExpectedCode:    http.StatusUnauthorized,
+			ExpectedHeaders: map[string]string{"Content-Type": jsonMime},
+		},
+		{
+			URI:             c.WithOAuthURI(logoutURL),
+			HasCookieToken:  true,
+			RawToken:        "this.is.a.bad.token",
+			ExpectedCode:    http.StatusUnauthorized,
+			ExpectedHeaders: map[string]string{"Content-Type": jsonMime},
+		},
+		{
+			URI:             c.WithOAuthURI(logoutURL),
+			RawToken:        "this.is.a.bad.token",
+			ExpectedCode:    http.StatusUnauthorized,
+			ExpectedHeaders: map[string]string{"Content-Type": jsonMime},
+		},
+	}
+	newFakeProxy(nil).RunTests(t, requests)
+}
+
+func TestLogoutHandlerGood(t *testing.T) {
+	c := newFakeKeycloakConfig()
+	requests := []fakeRequest{
+		{
+			URI:             c.WithOAuthURI(logoutURL),
+			HasToken:        true,
+			ExpectedCode:    http.StatusOK,
+			ExpectedHeaders: map[string]string{"Content-Type": jsonMime},
+		},
+		{
+			URI:              c.WithOAuthURI(logoutURL) + "?redirect=http://example.com",
+			HasToken:         true,
+			ExpectedCode:     http.StatusTemporaryRedirect,
+			ExpectedLocation: "http://example.com",
+		},
+	}
+	newFakeProxy(nil).RunTests(t, requests)
+}
+
+func TestLogoutHandlerWebhooks(t *testing.T) {
+	notified := make(chan logoutNotification, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var notification logoutNotification
+		assert.Equal(t, http.MethodPost, req.Method)
+		assert.NoError(t, json.NewDecoder(req.Body).Decode(&notification))
+		notified <- notification
+	}))
+	defer hook.Close()
+
+	c := newFakeKeycloakConfig()
+	c.LogoutWebhooks = []string{hook.URL}
+	requests := []fakeRequest{
+		{
+			URI:          c.WithOAuthURI(logoutURL),
+			HasToken:     true,
+			ExpectedCode: http.StatusOK,
+		},
+	}
+	newFakeProxy(c).RunTests(t, requests)
+
+	select {
+	case notification := <-notified:
+		assert.Equal(t, defaultTestTokenClaims["sub"], notification.Subject)
+		assert.Equal(t, defaultTestTokenClaims["session_state"], notification.SessionID)
+		assert.Equal(t, defaultTestTokenClaims["email"], notification.Email)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the logout to be notified to the webhook")
+	}
+}
+
+func TestLogoutWebhookSignature(t *testing.T) {
+	type signed struct {
+		timestamp string
+		signature string
+		body      []byte
+	}
+	received := make(chan signed, 2)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := ioutil.ReadAll(req.Body)
+		assert.NoError(t, err)
+		received <- signed{
+			timestamp: req.Header.Get(headerXAuthTimestamp),
+			signature: req.Header.Get(headerXAuthSignature),
+			body:      body,
+		}
+	}))
+	defer hook.Close()
+
+	payload := []byte(`{"subject":"user"}`)
+	require.NoError(t, postLogoutNotification(hook.Client(), hook.URL, payload, "secret"))
+	notification := <-received
+	assert.Equal(t, payload, notification.body)
+	timestamp, err := strconv.ParseInt(notification.timestamp, 10, 64)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), time.Unix(timestamp, 0), time.Minute)
+
+	// the application verifies the signature with the shared secret
+	mac := hmac.New(sha256.New, []byte("secret"))
+	_, _ = mac.Write([]byte(notification.timestamp + "."))
+	_, _ = mac.Write(notification.body)
+	assert.Equal(t, "sha256="+hex.EncodeToString(mac.Sum(nil)), notification.signature)
+
+	// without a secret, the notifications are not signed
+	require.NoError(t, postLogoutNotification(hook.Client(), hook.URL, payload, ""))
+	notification = <-received
+	assert.Empty(t, notification.timestamp)
+	assert.Empty(t, notification.signature)
+}
+
+func TestLogoutHandlerRevocationMode(t *testing.T) {
+	cases := []struct {
+		Mode     string
+		Access   bool
+		Expected []string
+	}{
+		{Mode: revocationModeEndSession, Expected: []string{"refresh_token"}},
+		{Mode: revocationModeRevoke},
+		{Mode: revocationModeRevoke, Access: true, Expected: []string{"access_token"}},
+		{Mode: revocationModeNone, Access: true},
+	}
+	for _, x := range cases {
+		var received []string
+		revocation := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			assert.NoError(t, req.ParseForm())
+			switch {
+			case req.PostForm.Get("refresh_token") != "":
+				received = append(received, "refresh_token")
+			default:
+				received = append(received, req.PostForm.Get("token_type_hint"))
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+
+		c := newFakeKeycloakConfig()
+		c.RevocationMode = x.Mode
+		c.EnableRevokeAccessToken = x.Access
+		p := newFakeProxy(c)
+		c.RevocationEndpoint = revocation.URL
+		p.RunTests(t, []fakeRequest{
+			{
+				URI:          c.WithOAuthURI(logoutURL),
+				HasToken:     true,
+				ExpectedCode: http.StatusOK,
+			},
+		})
+		revocation.Close()
+
+		assert.Equal(t, x.Expected, received, "mode: %s", x.Mode)
+	}
+}
+
+func TestTokenHandler(t *testing.T) {
+	uri := newFakeKeycloakConfig().WithOAuthURI(tokenURL)
+	goodToken := newTestToken("example").getToken()
+	requests := []fakeRequest{
+		{
+			URI:             uri,
+			HasToken:        true,
+			RawToken:        (&goodToken).Encode(),
+			ExpectedCode:    http.StatusOK,
+			ExpectedHeaders: map[string]string{"Content-Type": jsonMime},
+		},
+		{
+			URI:             uri,
+			ExpectedCode:    http.StatusUnauthorized,
+			ExpectedHeaders: map[string]string{"Content-Type": jsonMime},
+		},
+		{
+			URI:             uri,
+			RawToken:        "niothing",
+			ExpectedCode:    http.StatusUnauthorized,
+			ExpectedHeaders: map[string]string{"Content-Type": jsonMime},
+		},
+		{
+			URI:             uri,
+			HasToken:        true,
+			HasCookieToken:  true,
+			ExpectedCode:    http.StatusOK,
+			ExpectedHeaders: map[string]string{"Content-Type": jsonMime},
+		},
+	}
+	newFakeProxy(nil).RunTests(t, requests)
+}
+
+func TestServiceRedirect(t *testing.T) {
+	requests := []fakeRequest{
+		{
+			URI:              "/admin",
+			Redirects:        true,
+			ExpectedCode:     http.StatusTemporaryRedirect,
+			ExpectedLocation: "/oauth/authorize?state",
+		},
+		{
+			URI:          "/admin",
+			ExpectedCode: http.StatusUnauthorized,
+		},
+	}
+	newFakeProxy(nil).RunTests(t, requests)
+}
+
+func TestAuthorizationURLWithSkipToken(t *testing.T) {
+	c := newFakeKeycloakConfig()
+	c.SkipTokenVerification = true
+	newFakeProxy(c).RunTests(t, []fakeRequest{
+		{
+			URI:             c.WithOAuthURI(authorizationURL),
+			ExpectedCode:    http.StatusNotAcceptable,
+			ExpectedHeaders: map[string]string{"Content-Type": jsonMime},
+		},
+	})
+}
+
+func TestAuthorizationURL(t *testing.T) {
+	requests := []fakeRequest{
+		{
+			URI:              "/admin",
+			Redirects:        true,
+			ExpectedLocation: "/oauth/authorize?state",
+			ExpectedCode:     http.StatusTemporaryRedirect,
+		},
+		{
+			URI:              "/admin/test",
+			Redirects:        true,
+			ExpectedLocation: "/oauth/authorize?state",
+			ExpectedCode:     http.StatusTemporaryRedirect,
+		},
+		{
+			URI:              "/help/../admin",
+			Redirects:        true,
+			ExpectedLocation: "/oauth/authorize?state",
+			ExpectedCode:     http.StatusTemporaryRedirect,
+		},
+		{
+			URI:              "/admin?test=yes&test1=test",
+			Redirects:        true,
+			ExpectedLocation: "/oauth/authorize?state",
+			ExpectedCode:     http.StatusTemporaryRedirect,
+		},
+		{
+			URI:          "/oauth/test",
+			Redirects:    true,
+			ExpectedCode: http.StatusNotFound,
+		},
+		{
+			URI:          "/oauth/callback/..//test",
+			Redirects:    true,
+			ExpectedCode: http.StatusNotFound,
+		},
+	}
+	newFakeProxy(nil).RunTests(t, requests)
+}
+
+func TestCallbackURL(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	requests := []fakeRequest{
+		{
+			URI:             cfg.WithOAuthURI(callbackURL),
+			Method:          http.MethodPost,
+			ExpectedCode:    http.StatusMethodNotAllowed,
+			ExpectedHeaders: map[string]string{"Content-Type": jsonMime},
+		},
+		{
+			URI:             cfg.WithOAuthURI(callbackURL),
+			ExpectedCode:    http.StatusBadRequest,
+			ExpectedHeaders: map[string]string{"Content-Type": jsonMime},
+		},
+		{
+			URI:              cfg.WithOAuthURI(callbackURL) + "?code=fake",
+			ExpectedCookies:  map[string]string{cfg.CookieAccessName: ""},
+			ExpectedLocation: "/",
+			ExpectedCode:     http.StatusTemporaryRedirect,
+		},
+		{
+			URI:              cfg.WithOAuthURI(callbackURL) + "?code=fake&state=/admin",
+			ExpectedCookies:  map[string]string{cfg.CookieAccessName: ""},
+			ExpectedLocation: "/",
+			ExpectedCode:     http.StatusTemporaryRedirect,
+		},
+		{
+			URI:              cfg.WithOAuthURI(callbackURL) + "?code=fake&state=L2FkbWlu",
+			ExpectedCookies:  map[string]string{cfg.CookieAccessName: ""},
+			ExpectedLocation: "/",
+			ExpectedCode:     http.StatusTemporaryRedirect,
+		},
+	}
+	newFakeProxy(cfg).RunTests(t, requests)
+}
+
+func TestCallbackInterstitial(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.EnableCallbackInterstitial = true
+	cfg.EnableSecurityFilter = true
+	cfg.ContentSecurityPolicy = "default-src 'self'"
+	requests := []fakeRequest{
+		{
+			URI:                     cfg.WithOAuthURI(callbackURL) + "?code=fake&state=xyz",
+			ExpectedCode:            http.StatusOK,
+			ExpectedHeaders:         map[string]string{"Content-Type": "text/html; charset=utf-8"},
+			ExpectedContentContains: `<input type="hidden" name="replayed" value="true">`,
+		},
+		{
+			// the page is submitted by its script, allowed by the policy of the page, or by the user
+			URI:                     cfg.WithOAuthURI(callbackURL) + "?code=fake&state=xyz",
+			ExpectedCode:            http.StatusOK,
+			ExpectedContentContains: `<button type="submit">Continue</button>`,
+			OnResponse: func(_ int, _ *resty.Request, resp *resty.Response) {
+				policies := resp.Header().Values("Content-Security-Policy")
+				require.Len(t, policies, 1)
+				matches := regexp.MustCompile(`script-src 'nonce-([^']+)'`).FindStringSubmatch(policies[0])
+				require.Len(t, matches, 2)
+				assert.Contains(t, string(resp.Body()), `<script nonce="`+matches[1]+`">document.forms[0].submit()</script>`)
+				assert.NotContains(t, string(resp.Body()), "onload")
+			},
+		},
+		{
+			// the query is escaped in the page
+			URI:                     cfg.WithOAuthURI(callbackURL) + "?code=fake&state=%22%3E%3Cscript%3E",
+			ExpectedCode:            http.StatusOK,
+			ExpectedContentContains: `name="state" value="&#34;&gt;&lt;script&gt;"`,
+		},
+		{
+			URI:          cfg.WithOAuthURI(callbackURL) + "?code=fake&state=xyz",
+			Cookies:      []*http.Cookie{{Name: requestStateCookie, Value: "xyz"}},
+			ExpectedCode: http.StatusTemporaryRedirect,
+		},
+		{
+			// a replayed callback still without cookies is not replayed again
+			URI:          cfg.WithOAuthURI(callbackURL) + "?code=fake&state=xyz&replayed=true",
+			ExpectedCode: http.StatusTemporaryRedirect,
+		},
+		{
+			URI:          cfg.WithOAuthURI(callbackURL) + "?code=fake",
+			ExpectedCode: http.StatusTemporaryRedirect,
+		},
+	}
+	newFakeProxy(cfg).RunTests(t, requests)
+}
+
+func TestFormPostCallback(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.ResponseMode = responseModeFormPost
+	requests := []fakeRequest{
+		{
+			URI:              cfg.WithOAuthURI(authorizationURL),
+			ExpectedLocation: "response_mode=form_post",
+			ExpectedCode:     http.StatusTemporaryRedirect,
+		},
+		{
+			URI:              cfg.WithOAuthURI(callbackURL),
+			Method:           http.MethodPost,
+			FormValues:       map[string]string{"code": "fake", "state": "xyz"},
+			ExpectedCookies:  map[string]string{cfg.CookieAccessName: ""},
+			ExpectedLocation: "/",
+			ExpectedCode:     http.StatusTemporaryRedirect,
+		},
+		{
+			URI:          cfg.WithOAuthURI(callbackURL),
+			Method:       http.MethodPost,
+			FormValues:   map[string]string{"state": "xyz"},
+			ExpectedCode: http.StatusBadRequest,
+		},
+		{
+			// the redirections are still accepted
+			URI:              cfg.WithOAuthURI(callbackURL) + "?code=fake",
+			ExpectedCookies:  map[string]string{cfg.CookieAccessName: ""},
+			ExpectedLocation: "/",
+			ExpectedCode:     http.StatusTemporaryRedirect,
+		},
+	}
+	newFakeProxy(cfg).RunTests(t, requests)
+}
+
+func TestCallbackURLWithAllowedRedirectHosts(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.AllowedRedirectHosts = []string{"app.example.com"}
+	requestURI := func(target string) []*http.Cookie {
+		return []*http.Cookie{
+			{Name: requestURICookie, Value: base64.StdEncoding.EncodeToString([]byte(target))},
+		}
+	}
+	requests := []fakeRequest{
+		{
+			URI:             cfg.WithOAuthURI(callbackURL) + "?code=fake&state=xyz",
+			Cookies:         requestURI("https://app.example.com/landing"),
+			ExpectedHeaders: map[string]string{"Location": "https://app.example.com/landing"},
+			ExpectedCode:    http.StatusTemporaryRedirect,
+		},
+		{
+			URI:             cfg.WithOAuthURI(callbackURL) + "?code=fake&state=xyz",
+			Cookies:         requestURI("/admin"),
+			ExpectedHeaders: map[string]string{"Location": "/admin"},
+			ExpectedCode:    http.StatusTemporaryRedirect,
+		},
+		{
+			URI:             cfg.WithOAuthURI(callbackURL) + "?code=fake&state=xyz",
+			Cookies:         requestURI("https://evil.com/landing"),
+			ExpectedHeaders: map[string]string{"Location": "/"},
+			ExpectedCode:    http.StatusTemporaryRedirect,
+		},
+		{
+			URI:             cfg.WithOAuthURI(callbackURL) + "?code=fake&state=xyz",
+			Cookies:         requestURI("//evil.com/landing"),
+			ExpectedHeaders: map[string]string{"Location": "/"},
+			ExpectedCode:    http.StatusTemporaryRedirect,
+		},
+	}
+	newFakeProxy(cfg).RunTests(t, requests)
+}
+
+func TestCallbackURLWithDefaultRedirectHosts(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	requestURI := func(target string) []*http.Cookie {
+		return []*http.Cookie{
+			{Name: requestURICookie, Value: base64.StdEncoding.EncodeToString([]byte(target))},
+		}
+	}
+	requests := []fakeRequest{
+		{
+			URI:             cfg.WithOAuthURI(callbackURL) + "?code=fake&state=xyz",
+			Cookies:         requestURI("/admin"),
+			ExpectedHeaders: map[string]string{"Location": "/admin"},
+			ExpectedCode:    http.StatusTemporaryRedirect,
+		},
+		{
+			URI:             cfg.WithOAuthURI(callbackURL) + "?code=fake&state=xyz",
+			Cookies:         requestURI("https://evil.com/landing"),
+			ExpectedHeaders: map[string]string{"Location": "/"},
+			ExpectedCode:    http.StatusTemporaryRedirect,
+		},
+		{
+			URI:             cfg.WithOAuthURI(callbackURL) + "?code=fake&state=xyz",
+			Cookies:         requestURI("//evil.com/landing"),
+			ExpectedHeaders: map[string]string{"Location": "/"},
+			ExpectedCode:    http.StatusTemporaryRedirect,
+		},
+	}
+	newFakeProxy(cfg).RunTests(t, requests)
+}
+
+func TestCrossSubdomainSession(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.CookieDomain = "example.com"
+	cfg.EnableCrossSubdomainSession = true
+	cfg.AllowedRedirectHosts = []string{"other.com"}
+	requestURI := func(target string) []*http.Cookie {
+		return []*http.Cookie{
+			{Name: requestURICookie, Value: base64.StdEncoding.EncodeToString([]byte(target))},
+		}
+	}
+	requests := []fakeRequest{
+		{
+			URI:          "/admin?page=1",
+			Redirects:    true,
+			ExpectedCode: http.StatusTemporaryRedirect,
+			ExpectedCookiesValidator: map[string]func(string) bool{
+				requestURICookie: func(value string) bool {
+					decoded, err := base64.StdEncoding.DecodeString(value)
+					return err == nil && strings.HasSuffix(string(decoded), "/admin?page=1")
+				},
+			},
+		},
+		{
+			URI:             cfg.WithOAuthURI(callbackURL) + "?code=fake&state=xyz",
+			Cookies:         requestURI("https://app2.example.com/landing"),
+			ExpectedHeaders: map[string]string{"Location": "https://app2.example.com/landing"},
+			ExpectedCode:    http.StatusTemporaryRedirect,
+			ExpectedCookies: map[string]string{requestURICookie: ""},
+		},
+		{
+			URI:             cfg.WithOAuthURI(callbackURL) + "?code=fake&state=xyz",
+			Cookies:         requestURI("https://evil.com/landing"),
+			ExpectedHeaders: map[string]string{"Location": "/"},
+			ExpectedCode:    http.StatusTemporaryRedirect,
+		},
+	}
+	newFakeProxy(cfg).RunTests(t, requests)
+}
+
+func TestSilentRenewal(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.EnableSilentRenewal = true
+	requests := []fakeRequest{
+		{
+			URI:              cfg.WithOAuthURI(silentURL),
+			Redirects:        true,
+			ExpectedLocation: "prompt=none",
+			ExpectedCode:     http.StatusTemporaryRedirect,
+		},
+		{
+			URI:              cfg.WithOAuthURI(silentURL),
+			Redirects:        true,
+			ExpectedLocation: "state=" + silentStatePrefix,
+			ExpectedCode:     http.StatusTemporaryRedirect,
+		},
+		{
+			URI:          cfg.WithOAuthURI(callbackURL) + "?code=fake&state=" + silentStatePrefix + "xyz",
+			ExpectedCode: http.StatusNoContent,
+			ExpectedCookiesValidator: map[string]func(string) bool{
+				cfg.CookieAccessName: func(value string) bool { return value != "" },
+			},
+		},
+		{
+			URI:          cfg.WithOAuthURI(callbackURL) + "?error=login_required&state=" + silentStatePrefix + "xyz",
+			ExpectedCode: http.StatusUnauthorized,
+		},
+		{
+			URI:          cfg.WithOAuthURI(callbackURL) + "?error=login_required&state=xyz",
+			ExpectedCode: http.StatusBadRequest,
+		},
+	}
+	newFakeProxy(cfg).RunTests(t, requests)
+}
+
+func TestSilentRenewalDisabled(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	requests := []fakeRequest{
+		{
+			URI:          cfg.WithOAuthURI(silentURL),
+			Redirects:    true,
+			ExpectedCode: http.StatusNotFound,
+		},
+	}
+	newFakeProxy(cfg).RunTests(t, requests)
+}
+
+func TestHealthHandler(t *testing.T) {
+	c := newFakeKeycloakConfig()
+	requests := []fakeRequest{
+		{
+			URI:             c.WithOAuthURI(healthURL),
+			ExpectedCode:    http.StatusOK,
+			ExpectedContent: `{"status":"OK"}`,
+			ExpectedHeaders: map[string]string{"Content-Type": jsonMime},
+		},
+		{
+			URI:             c.WithOAuthURI(healthURL),
+			Method:          http.MethodHead,
+			ExpectedCode:    http.StatusMethodNotAllowed,
+			ExpectedHeaders: map[string]string{"Content-Type": jsonMime},
+		},
+	}
+	newFakeProxy(nil).RunTests(t, requests)
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// headerLimitsMiddleware rejects the requests with too many or too large headers.
+// The server rejects the requests far beyond the size limit by itself, before any middleware.
+func (r *oauthProxy) headerLimitsMiddleware(maxBytes, maxCount int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			size, count := requestHeaderSize(req)
+			if (maxBytes > 0 && size > maxBytes) || (maxCount > 0 && count > maxCount) {
+				r.headerLimitResponse(w, req, size, count)
+				return
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// requestHeaderSize is the size of the request line and headers as read by the server, and the number of headers
+func requestHeaderSize(req *http.Request) (int, int) {
+	size := len(req.Method) + len(req.RequestURI) + len(req.Proto) + 4
+	count := 0
+	if req.Host != "" {
+		// the host header is not kept in the request headers
+		size += len("Host") + len(req.Host) + 4
+		count++
+	}
+	for name, values := range req.Header {
+		for _, value := range values {
+			size += len(name) + len(value) + 4
+			count++
+		}
+	}
+
+	return size, count
+}
+
+// headerLimitResponse responds to the requests exceeding the header limits
+func (r *oauthProxy) headerLimitResponse(w http.ResponseWriter, req *http.Request, size, count int) {
+	_, logger := r.traceSpanRequest(req)
+
+	logger.Warn("request header fields too large",
+		zap.Int("header_bytes", size),
+		zap.Int("header_count", count))
+	r.errorPageResponse(w, req, r.config.HeaderLimitPage, "request header fields too large", http.StatusRequestHeaderFieldsTooLarge)
+}
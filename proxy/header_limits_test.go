@@ -0,0 +1,87 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeaderLimits(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.ServerMaxHeaderBytes = 2048
+	cfg.ServerMaxHeaderCount = 10
+	many := make(map[string]string, 10)
+	for _, name := range []string{"A", "B", "C", "D", "E", "F", "G", "H", "I", "J"} {
+		many["X-"+name] = "1"
+	}
+	newFakeProxy(cfg).RunTests(t, []fakeRequest{
+		{
+			URI:           fakeTestWhitelistedURL,
+			Headers:       map[string]string{"X-Test": "1"},
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+		},
+		{
+			URI:                     fakeTestWhitelistedURL,
+			Headers:                 many,
+			ExpectedCode:            http.StatusRequestHeaderFieldsTooLarge,
+			ExpectedContentContains: "request header fields too large",
+		},
+		{
+			URI:          fakeTestWhitelistedURL,
+			Headers:      map[string]string{"X-Test": strings.Repeat("x", 2048)},
+			ExpectedCode: http.StatusRequestHeaderFieldsTooLarge,
+		},
+	})
+}
+
+func TestHeaderLimitTemplate(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.ServerMaxHeaderBytes = 1024
+	cfg.HeaderLimitPage = "../templates/header_limit.html.tmpl"
+	newFakeProxy(cfg).RunTests(t, []fakeRequest{
+		{
+			URI:                     fakeTestWhitelistedURL,
+			Headers:                 map[string]string{"X-Test": strings.Repeat("x", 1024)},
+			ExpectedCode:            http.StatusRequestHeaderFieldsTooLarge,
+			ExpectedContentContains: "431 Request Header Fields Too Large",
+		},
+	})
+}
+
+func TestAdminHeaderLimits(t *testing.T) {
+	cfg := &Config{
+		ServerReadHeaderTimeout: time.Second,
+		ServerMaxHeaderBytes:    4096,
+		ServerMaxHeaderCount:    20,
+	}
+	timeout, maxBytes, maxCount := cfg.adminHeaderLimits()
+	assert.Equal(t, time.Second, timeout)
+	assert.Equal(t, 4096, maxBytes)
+	assert.Equal(t, 20, maxCount)
+
+	cfg.AdminReadHeaderTimeout = 2 * time.Second
+	cfg.AdminMaxHeaderCount = 5
+	timeout, maxBytes, maxCount = cfg.adminHeaderLimits()
+	assert.Equal(t, 2*time.Second, timeout)
+	assert.Equal(t, 4096, maxBytes)
+	assert.Equal(t, 5, maxCount)
+}
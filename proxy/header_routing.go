@@ -0,0 +1,78 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// headerRouting selects the upstream of a resource from a header of the request
+type headerRouting struct {
+	header    string
+	upstreams map[string]*url.URL
+}
+
+// newHeaderRouting returns the header routing of a resource, or nil when the resource routes all requests
+// to the same upstream
+func newHeaderRouting(resource *Resource) *headerRouting {
+	if resource == nil || resource.UpstreamHeader == "" {
+		return nil
+	}
+	routing := &headerRouting{
+		header:    http.CanonicalHeaderKey(resource.UpstreamHeader),
+		upstreams: make(map[string]*url.URL, len(resource.HeaderUpstreams)),
+	}
+	for value, upstream := range resource.HeaderUpstreams {
+		// the urls have been checked with the resource
+		u, _ := url.Parse(upstream)
+		routing.upstreams[value] = u
+	}
+
+	return routing
+}
+
+// upstream returns the upstream mapped to the value of the header, if any
+func (h *headerRouting) upstream(req *http.Request) (string, *url.URL) {
+	value := strings.TrimSpace(req.Header.Get(h.header))
+	if value == "" {
+		return "", nil
+	}
+
+	return value, h.upstreams[value]
+}
+
+// isHeaderRoutingValid checks the header routing settings of a resource
+func isHeaderRoutingValid(resource *Resource) error {
+	if resource.UpstreamHeader == "" {
+		if len(resource.HeaderUpstreams) > 0 {
+			return fmt.Errorf("the resource %s maps header values to upstreams, but has no upstream-header", resource.URL)
+		}
+		return nil
+	}
+	if len(resource.HeaderUpstreams) == 0 {
+		return fmt.Errorf("the resource %s routes on the header %s, but has no header-upstream-urls", resource.URL, resource.UpstreamHeader)
+	}
+	for value, upstream := range resource.HeaderUpstreams {
+		if u, err := url.Parse(upstream); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("upstream for the header value %q of resource %s is not a valid URL: %q", value, resource.URL, upstream)
+		}
+	}
+
+	return nil
+}
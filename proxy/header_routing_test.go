@@ -0,0 +1,107 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeaderRouting(t *testing.T) {
+	v1 := newNamedUpstream("v1")
+	defer v1.Close()
+	v2 := newNamedUpstream("v2")
+	defer v2.Close()
+	acme := newNamedUpstream("acme")
+	defer acme.Close()
+
+	cfg := newFakeKeycloakConfig()
+	cfg.Resources = []*Resource{
+		{
+			URL:             "/api/*",
+			Methods:         allHTTPMethods,
+			Upstream:        v1.URL,
+			MaxIdleConns:    10,
+			UpstreamHeader:  "x-api-version",
+			HeaderUpstreams: map[string]string{"2": v2.URL + "/next"},
+			UpstreamClaim:   "tenant",
+			ClaimUpstreams:  map[string]string{"acme": acme.URL},
+		},
+		{
+			URL:             "/public/*",
+			WhiteListed:     true,
+			Methods:         allHTTPMethods,
+			Upstream:        v1.URL,
+			MaxIdleConns:    10,
+			UpstreamHeader:  "X-API-Version",
+			HeaderUpstreams: map[string]string{"2": v2.URL},
+		},
+	}
+	requests := []fakeRequest{
+		{
+			URI:                     "/api/items",
+			HasToken:                true,
+			Headers:                 map[string]string{"X-API-Version": "2"},
+			ExpectedCode:            http.StatusOK,
+			ExpectedContentContains: "v2 /next/api/items",
+		},
+		{
+			URI:                     "/api/items",
+			HasToken:                true,
+			Headers:                 map[string]string{"X-API-Version": "3"},
+			ExpectedCode:            http.StatusOK,
+			ExpectedContentContains: "v1 /api/items",
+		},
+		{
+			URI:                     "/api/items",
+			HasToken:                true,
+			ExpectedCode:            http.StatusOK,
+			ExpectedContentContains: "v1 /api/items",
+		},
+		{
+			// the claim routing prevails over the header routing
+			URI:                     "/api/items",
+			HasToken:                true,
+			TokenClaims:             map[string]interface{}{"tenant": "acme"},
+			Headers:                 map[string]string{"X-API-Version": "2"},
+			ExpectedCode:            http.StatusOK,
+			ExpectedContentContains: "acme /api/items",
+		},
+		{
+			URI:                     "/public/items",
+			Headers:                 map[string]string{"X-API-Version": "2"},
+			ExpectedCode:            http.StatusOK,
+			ExpectedContentContains: "v2 /public/items",
+		},
+	}
+	newFakeProxy(cfg).RunTests(t, requests)
+}
+
+func TestHeaderRoutingValid(t *testing.T) {
+	valid := &Resource{URL: "/api/*", UpstreamHeader: "X-API-Version", HeaderUpstreams: map[string]string{"2": "http://v2:8080"}}
+	assert.NoError(t, valid.valid())
+
+	cs := []*Resource{
+		{URL: "/api/*", UpstreamHeader: "X-API-Version"},
+		{URL: "/api/*", HeaderUpstreams: map[string]string{"2": "http://v2:8080"}},
+		{URL: "/api/*", UpstreamHeader: "X-API-Version", HeaderUpstreams: map[string]string{"2": "v2:8080"}},
+	}
+	for i, c := range cs {
+		assert.Error(t, c.valid(), "case %d", i)
+	}
+}
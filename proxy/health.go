@@ -0,0 +1,240 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// healthCheckInterval is the minimum interval between two checks of the dependencies
+	healthCheckInterval = 10 * time.Second
+	// healthCheckTimeout is the maximum duration of a dependency check
+	healthCheckTimeout = 2 * time.Second
+
+	healthOK       = "OK"
+	healthDegraded = "DEGRADED"
+	healthFailing  = "FAILING"
+)
+
+// dependencyStatus is the status of a dependency reported by the health endpoint
+type dependencyStatus struct {
+	Status      string     `json:"status"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// healthStatus is the response of the health endpoint when the dependencies are reported
+type healthStatus struct {
+	Status       string                       `json:"status"`
+	Dependencies map[string]*dependencyStatus `json:"dependencies"`
+}
+
+// healthChecks keeps track of the status of the dependencies of the service. The dependencies are
+// checked when the health endpoint is called, at most once per interval, so frequent probes don't
+// translate into calls to the provider.
+type healthChecks struct {
+	sync.Mutex
+	checkedAt    time.Time
+	dependencies map[string]*dependencyStatus
+}
+
+func newHealthChecks() *healthChecks {
+	return &healthChecks{dependencies: make(map[string]*dependencyStatus)}
+}
+
+// record updates the status of a dependency with the result of a check
+func (h *healthChecks) record(name string, err error, at time.Time) {
+	status, found := h.dependencies[name]
+	if !found {
+		status = &dependencyStatus{}
+		h.dependencies[name] = status
+	}
+	if err != nil {
+		status.Status = healthFailing
+		status.Error = err.Error()
+		return
+	}
+	status.Status = healthOK
+	status.Error = ""
+	status.LastSuccess = &at
+}
+
+// getHealthStatus checks the dependencies of the service, unless they have been checked recently
+func (r *oauthProxy) getHealthStatus() healthStatus {
+	h := r.health
+	h.Lock()
+	defer h.Unlock()
+
+	if time.Since(h.checkedAt) >= healthCheckInterval {
+		checks := r.dependencyChecks()
+		results := make([]error, len(checks))
+		names := make([]string, 0, len(checks))
+		var wg sync.WaitGroup
+		i := 0
+		for name, check := range checks {
+			wg.Add(1)
+			go func(i int, check func() error) {
+				defer wg.Done()
+				results[i] = check()
+			}(i, check)
+			names = append(names, name)
+			i++
+		}
+		wg.Wait()
+		now := time.Now()
+		for i, name := range names {
+			h.record(name, results[i], now)
+		}
+		h.checkedAt = now
+	}
+
+	health := healthStatus{Status: healthOK, Dependencies: make(map[string]*dependencyStatus, len(h.dependencies))}
+	for name, status := range h.dependencies {
+		copied := *status
+		health.Dependencies[name] = &copied
+		if status.Status != healthOK {
+			health.Status = healthDegraded
+		}
+	}
+	if name, status := r.keysStatus(); status != nil {
+		health.Dependencies[name] = status
+		if status.Status != healthOK {
+			health.Status = healthDegraded
+		}
+	}
+
+	return health
+}
+
+// dependencyChecks returns the checks of the dependencies of the service
+func (r *oauthProxy) dependencyChecks() map[string]func() error {
+	checks := make(map[string]func() error)
+	if r.idpClient != nil {
+		client := &http.Client{Transport: r.idpClient.Transport, Timeout: healthCheckTimeout}
+		checks["discovery"] = func() error {
+			return checkEndpoint(client, r.config.DiscoveryURL+"/.well-known/openid-configuration", false)
+		}
+		if idp := r.getProviderConfig(); idp.TokenEndpoint != nil {
+			checks["token_endpoint"] = func() error {
+				// the token endpoint only accepts posted grants: any response tells it is reachable
+				return checkEndpoint(client, idp.TokenEndpoint.String(), true)
+			}
+		}
+	}
+	if r.useStore() {
+		checks["store"] = func() error {
+			_, err := r.store.Get("health")
+			return err
+		}
+	}
+	upstreams := append([]string{r.config.Upstream}, r.config.UpstreamURLs...)
+	for _, x := range r.config.Resources {
+		if x.Upstream != "" {
+			upstreams = append(upstreams, x.Upstream)
+			upstreams = append(upstreams, x.Upstreams...)
+		}
+	}
+	dial := r.resolver.dialContext(newDialer(r.config.UpstreamTimeout, r.config.UpstreamKeepaliveTimeout, r.config.UpstreamFallbackDelay))
+	for _, upstream := range upstreams {
+		location := upstream
+		checks["upstream:"+location] = func() error {
+			return checkUpstream(location, dial)
+		}
+	}
+
+	return checks
+}
+
+// keysStatus reports the state of the provider keys, which are kept fresh in the background
+func (r *oauthProxy) keysStatus() (string, *dependencyStatus) {
+	if !r.isProviderReady() {
+		return "jwks", &dependencyStatus{Status: healthFailing, Error: "the provider discovery has not succeeded yet"}
+	}
+	keys := r.getProviderKeys()
+	if keys == nil {
+		return "", nil
+	}
+	keys.RLock()
+	defer keys.RUnlock()
+	status := &dependencyStatus{Status: healthOK}
+	if !keys.fetchedAt.IsZero() {
+		fetchedAt := keys.fetchedAt
+		status.LastSuccess = &fetchedAt
+	}
+	switch {
+	case keys.lastErr != nil:
+		status.Status = healthFailing
+		status.Error = keys.lastErr.Error()
+	case keys.keys == nil:
+		status.Status = healthFailing
+		status.Error = "the provider keys have not been retrieved yet"
+	case keys.keys.ExpiresAt().Before(time.Now()):
+		status.Status = healthFailing
+		status.Error = "the provider keys have expired"
+	}
+
+	return "jwks", status
+}
+
+// checkEndpoint checks an http endpoint responds, with a successful status unless any response is expected
+func checkEndpoint(client *http.Client, location string, anyResponse bool) error {
+	resp, err := client.Get(location)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError || (!anyResponse && resp.StatusCode != http.StatusOK) {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// checkUpstream checks a connection can be opened to an upstream endpoint
+func checkUpstream(location string, dial dialContextFunc) error {
+	u, err := url.Parse(location)
+	if err != nil {
+		return err
+	}
+	network, address := "tcp", u.Host
+	switch {
+	case u.Scheme == "unix":
+		network, address = "unix", u.Host+u.Path
+	case u.Host == "":
+		return errors.New("the upstream has no host")
+	case u.Port() == "" && strings.HasPrefix(u.Scheme, "https"):
+		address = net.JoinHostPort(u.Hostname(), "443")
+	case u.Port() == "":
+		address = net.JoinHostPort(u.Hostname(), "80")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+	conn, err := dial(ctx, network, address)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
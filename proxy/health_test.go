@@ -0,0 +1,61 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthDependencies(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer upstream.Close()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	unreachable := "http://" + closed.Addr().String()
+	_ = closed.Close()
+
+	cfg := newFakeKeycloakConfig()
+	cfg.EnableHealthDependencies = true
+	cfg.Upstream = upstream.URL
+	cfg.Resources = append(cfg.Resources, &Resource{URL: "/unreachable", Upstream: unreachable, WhiteListed: true})
+	p := newFakeProxy(cfg)
+	defer p.idp.Close()
+
+	resp, err := http.Get(p.getServiceURL() + cfg.OAuthURI + healthURL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var health healthStatus
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&health))
+	assert.Equal(t, healthDegraded, health.Status)
+	for _, name := range []string{"discovery", "token_endpoint", "jwks", "upstream:" + upstream.URL} {
+		require.Contains(t, health.Dependencies, name)
+		assert.Equal(t, healthOK, health.Dependencies[name].Status, name)
+		assert.NotNil(t, health.Dependencies[name].LastSuccess, name)
+	}
+	require.Contains(t, health.Dependencies, "upstream:"+unreachable)
+	assert.Equal(t, healthFailing, health.Dependencies["upstream:"+unreachable].Status)
+	assert.NotEmpty(t, health.Dependencies["upstream:"+unreachable].Error)
+	assert.Nil(t, health.Dependencies["upstream:"+unreachable].LastSuccess)
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+// hedgedTransport sends a second attempt of the idempotent requests to another upstream when the first one
+// is slow to respond, and returns the first response
+type hedgedTransport struct {
+	http.RoundTripper
+	name    string
+	delay   time.Duration
+	targets []*url.URL
+	next    uint32
+}
+
+// hedgedAttempt is the outcome of an attempt of a hedged request
+type hedgedAttempt struct {
+	resp   *http.Response
+	err    error
+	hedged bool
+	cancel context.CancelFunc
+}
+
+// cancelOnClose releases the context of the winning attempt once its response is consumed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+
+	return err
+}
+
+// newHedgedTransport wraps the transport of a resource when it hedges its requests
+func newHedgedTransport(resource *Resource, transport http.RoundTripper) http.RoundTripper {
+	if resource.HedgeDelay <= 0 || len(resource.HedgeUpstreams) == 0 {
+		return transport
+	}
+	targets := make([]*url.URL, 0, len(resource.HedgeUpstreams))
+	for _, upstream := range resource.HedgeUpstreams {
+		// the urls have been checked with the resource
+		u, _ := url.Parse(upstream)
+		targets = append(targets, u)
+	}
+
+	return &hedgedTransport{RoundTripper: transport, name: resource.URL, delay: resource.HedgeDelay, targets: targets}
+}
+
+// hedgeable checks if the request may be sent twice
+func hedgeable(req *http.Request) bool {
+	return isSafeMethod(req.Method) && req.ContentLength == 0 && req.Header.Get(headerUpgrade) == ""
+}
+
+// hedgedRequest is the request sent to the next hedge upstream
+func (t *hedgedTransport) hedgedRequest(ctx context.Context, req *http.Request) *http.Request {
+	target := t.targets[int(atomic.AddUint32(&t.next, 1)-1)%len(t.targets)]
+	hedged := req.Clone(ctx)
+	hedged.URL.Scheme = target.Scheme
+	hedged.URL.Host = target.Host
+	if req.Host == req.URL.Host {
+		hedged.Host = target.Host
+	}
+
+	return hedged
+}
+
+func (t *hedgedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !hedgeable(req) {
+		return t.RoundTripper.RoundTrip(req)
+	}
+
+	attempts := make(chan hedgedAttempt, 2)
+	attempt := func(req *http.Request, hedged bool, cancel context.CancelFunc) {
+		resp, err := t.RoundTripper.RoundTrip(req)
+		attempts <- hedgedAttempt{resp: resp, err: err, hedged: hedged, cancel: cancel}
+	}
+	cancels := make(map[bool]context.CancelFunc, 2)
+	primaryCtx, cancelPrimary := context.WithCancel(req.Context())
+	cancels[false] = cancelPrimary
+	go attempt(req.WithContext(primaryCtx), false, cancelPrimary)
+
+	timer := time.NewTimer(t.delay)
+	defer timer.Stop()
+	launched, pending := false, 1
+	hedge := func() {
+		launched = true
+		pending++
+		hedgedCtx, cancelHedged := context.WithCancel(req.Context())
+		cancels[true] = cancelHedged
+		go attempt(t.hedgedRequest(hedgedCtx, req), true, cancelHedged)
+	}
+
+	var last hedgedAttempt
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if !launched {
+				hedge()
+			}
+		case result := <-attempts:
+			pending--
+			if result.err != nil {
+				result.cancel()
+				last = result
+				if !launched && req.Context().Err() == nil {
+					// the hedged attempt is not delayed after a failure
+					hedge()
+				}
+				continue
+			}
+			hedgedRequestsMetric.WithLabelValues(t.name, winnerLabel(launched, result.hedged)).Inc()
+			if pending > 0 {
+				// the slower attempt is canceled, and its response discarded
+				cancels[!result.hedged]()
+				go discardAttempt(attempts)
+			}
+			result.resp.Body = &cancelOnClose{ReadCloser: result.resp.Body, cancel: result.cancel}
+
+			return result.resp, nil
+		}
+	}
+
+	return nil, last.err
+}
+
+// winnerLabel labels the attempt which responded first
+func winnerLabel(launched, hedged bool) string {
+	switch {
+	case !launched:
+		return "single"
+	case hedged:
+		return "hedge"
+	default:
+		return "primary"
+	}
+}
+
+// discardAttempt releases the attempt which lost the race
+func discardAttempt(attempts <-chan hedgedAttempt) {
+	result := <-attempts
+	result.cancel()
+	if result.resp != nil {
+		_ = result.resp.Body.Close()
+	}
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newHedgeUpstream is an upstream responding its name after a delay, unless the request is canceled
+func newHedgeUpstream(name string, delay time.Duration, calls, canceled *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(calls, 1)
+		select {
+		case <-time.After(delay):
+			_, _ = w.Write([]byte(name))
+		case <-req.Context().Done():
+			atomic.AddInt32(canceled, 1)
+		}
+	}))
+}
+
+func TestHedgedTransport(t *testing.T) {
+	var primaryCalls, primaryCanceled, hedgeCalls, hedgeCanceled int32
+	slow := newHedgeUpstream("primary", 2*time.Second, &primaryCalls, &primaryCanceled)
+	defer slow.Close()
+	fast := newHedgeUpstream("hedge", 0, &hedgeCalls, &hedgeCanceled)
+	defer fast.Close()
+
+	resource := &Resource{URL: "/hedged/*", HedgeDelay: 50 * time.Millisecond, HedgeUpstreams: []string{fast.URL}}
+	transport := newHedgedTransport(resource, http.DefaultTransport)
+	roundTrip := func(method, location string) (string, error) {
+		req, err := http.NewRequest(method, location, nil)
+		require.NoError(t, err)
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+
+		return string(body), err
+	}
+
+	// the slow primary is raced by the hedged attempt, then canceled
+	started := time.Now()
+	body, err := roundTrip(http.MethodGet, slow.URL+"/hedged/test")
+	require.NoError(t, err)
+	assert.Equal(t, "hedge", body)
+	assert.True(t, time.Since(started) < time.Second)
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&primaryCanceled) == 1 }, time.Second, 10*time.Millisecond)
+
+	// a fast primary is not hedged
+	body, err = roundTrip(http.MethodGet, fast.URL+"/hedged/test")
+	require.NoError(t, err)
+	assert.Equal(t, "hedge", body)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&hedgeCalls))
+
+	// unsafe methods are never sent twice
+	req, err := http.NewRequest(http.MethodPost, slow.URL+"/hedged/test", strings.NewReader("body"))
+	require.NoError(t, err)
+	assert.False(t, hedgeable(req))
+
+	// a failed primary is hedged at once
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	started = time.Now()
+	body, err = roundTrip(http.MethodGet, down.URL+"/hedged/test")
+	require.NoError(t, err)
+	assert.Equal(t, "hedge", body)
+	assert.True(t, time.Since(started) < resource.HedgeDelay)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&hedgeCanceled))
+}
+
+func TestHedgedResource(t *testing.T) {
+	var primaryCalls, primaryCanceled, hedgeCalls, hedgeCanceled int32
+	slow := newHedgeUpstream("primary", 2*time.Second, &primaryCalls, &primaryCanceled)
+	defer slow.Close()
+	fast := newHedgeUpstream("hedge", 0, &hedgeCalls, &hedgeCanceled)
+	defer fast.Close()
+
+	cfg := newFakeKeycloakConfig()
+	for _, x := range cfg.Resources {
+		if x.URL == fakeTestWhitelistedURL {
+			x.Upstream = slow.URL
+			x.HedgeDelay = 50 * time.Millisecond
+			x.HedgeUpstreams = []string{fast.URL}
+		}
+	}
+	p := newFakeProxy(cfg)
+	defer func() {
+		p.idp.Close()
+		p.proxy.server.Close()
+	}()
+
+	resp, err := http.Get(p.getServiceURL() + "/auth_all/white_listed/test")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "hedge", string(body))
+}
+
+func TestHedgedResourceValid(t *testing.T) {
+	assert.NoError(t, (&Resource{URL: "/hedged/*", HedgeDelay: time.Millisecond, HedgeUpstreams: []string{"http://127.0.0.1:8080"}}).valid())
+	assert.Error(t, (&Resource{URL: "/hedged/*", HedgeDelay: time.Millisecond}).valid())
+	assert.Error(t, (&Resource{URL: "/hedged/*", HedgeDelay: -time.Millisecond, HedgeUpstreams: []string{"http://127.0.0.1:8080"}}).valid())
+	assert.Error(t, (&Resource{URL: "/hedged/*", HedgeDelay: time.Millisecond, HedgeUpstreams: []string{"127.0.0.1:8080"}}).valid())
+	assert.Error(t, (&Resource{URL: "/hedged/*", HedgeDelay: time.Millisecond, HedgeUpstreams: []string{"http://127.0.0.1:8080/base"}}).valid())
+}
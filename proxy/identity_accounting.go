@@ -0,0 +1,160 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var identityBytesMetric = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "proxy_identity_bytes_total",
+		Help: "The bytes of the request and response bodies of the authenticated users, partitioned by resource and direction",
+	},
+	[]string{"resource", "direction"},
+)
+
+func init() {
+	prometheus.MustRegister(identityBytesMetric)
+}
+
+// byteUsage is the bytes transferred by the users during the current day (UTC), through this instance only
+type byteUsage struct {
+	sync.Mutex
+	day   time.Time
+	bytes map[string]int64
+	now   func() time.Time
+}
+
+func newByteUsage() *byteUsage {
+	return &byteUsage{bytes: make(map[string]int64), now: time.Now}
+}
+
+// today returns the start of the current day, resetting the usage on a new day. The lock must be held.
+func (u *byteUsage) today() time.Time {
+	day := u.now().UTC().Truncate(24 * time.Hour)
+	if !day.Equal(u.day) {
+		u.day = day
+		u.bytes = make(map[string]int64)
+	}
+
+	return day
+}
+
+// add records the bytes transferred by a user
+func (u *byteUsage) add(subject string, bytes int64) {
+	u.Lock()
+	defer u.Unlock()
+	u.today()
+	u.bytes[subject] += bytes
+}
+
+// exceeds checks if a user has exhausted a daily budget, returning when the budget is reset
+func (u *byteUsage) exceeds(subject string, budget int64) (time.Time, bool) {
+	u.Lock()
+	defer u.Unlock()
+	day := u.today()
+
+	return day.Add(24 * time.Hour), u.bytes[subject] >= budget
+}
+
+// countingReader counts the bytes read from a request body
+type countingReader struct {
+	io.ReadCloser
+	count int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	atomic.AddInt64(&c.count, int64(n))
+
+	return n, err
+}
+
+// identityAccountingMiddleware counts the bytes of the bodies sent and received by the authenticated users of a
+// resource. It wraps the whole chain of the resource, as the identity is known once the request has been proxied.
+func (r *oauthProxy) identityAccountingMiddleware(resource *Resource) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !r.config.EnableIdentityAccounting {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			var body *countingReader
+			if req.Body != nil && req.Body != http.NoBody {
+				body = &countingReader{ReadCloser: req.Body}
+				req.Body = body
+			}
+			resp, wrapped := w.(middleware.WrapResponseWriter)
+			var written int
+			if wrapped {
+				written = resp.BytesWritten()
+			}
+
+			next.ServeHTTP(w, req)
+
+			scope, ok := req.Context().Value(contextScopeName).(*RequestScope)
+			if !ok || scope.Identity == nil {
+				return
+			}
+			var in, out int64
+			if body != nil {
+				in = atomic.LoadInt64(&body.count)
+			}
+			if wrapped {
+				out = int64(resp.BytesWritten() - written)
+			}
+			identityBytesMetric.WithLabelValues(resource.URL, "in").Add(float64(in))
+			identityBytesMetric.WithLabelValues(resource.URL, "out").Add(float64(out))
+			if r.usage != nil {
+				r.usage.add(scope.Identity.id, in+out)
+			}
+		})
+	}
+}
+
+// byteQuotaMiddleware rejects the requests of the users who have exhausted their daily byte budget on this instance
+func (r *oauthProxy) byteQuotaMiddleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if r.usage == nil {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			scope, ok := req.Context().Value(contextScopeName).(*RequestScope)
+			if !ok || scope.AccessDenied || scope.Identity == nil {
+				next.ServeHTTP(w, req)
+				return
+			}
+			if reset, exceeded := r.usage.exceeds(scope.Identity.id, int64(r.config.IdentityDailyByteQuota)); exceeded {
+				w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(reset).Seconds())+1))
+				r.errorResponse(w, req, "the daily byte quota of the user is exhausted", http.StatusTooManyRequests, nil)
+				r.revokeProxy(w, req)
+				return
+			}
+
+			next.ServeHTTP(w, req)
+		})
+	}
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	resty "gopkg.in/resty.v1"
+)
+
+func TestByteUsage(t *testing.T) {
+	now := time.Date(2020, 6, 1, 23, 0, 0, 0, time.UTC)
+	usage := newByteUsage()
+	usage.now = func() time.Time { return now }
+
+	usage.add("alice", 60)
+	usage.add("alice", 40)
+	usage.add("bob", 10)
+	reset, exceeded := usage.exceeds("alice", 100)
+	assert.True(t, exceeded)
+	assert.Equal(t, time.Date(2020, 6, 2, 0, 0, 0, 0, time.UTC), reset)
+	_, exceeded = usage.exceeds("bob", 100)
+	assert.False(t, exceeded)
+
+	// the budgets are reset every day
+	now = now.Add(2 * time.Hour)
+	_, exceeded = usage.exceeds("alice", 100)
+	assert.False(t, exceeded)
+}
+
+func TestIdentityAccounting(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = ioutil.ReadAll(req.Body)
+		_, _ = w.Write([]byte(strings.Repeat("x", 200)))
+	}))
+	defer upstream.Close()
+
+	cfg := newFakeKeycloakConfig()
+	cfg.EnableIdentityAccounting = true
+	cfg.IdentityDailyByteQuota = 100
+	cfg.Resources = []*Resource{
+		{
+			URL:          "/metered/*",
+			Methods:      allHTTPMethods,
+			Upstream:     upstream.URL,
+			MaxIdleConns: 10,
+		},
+		{
+			URL:         "/public/*",
+			WhiteListed: true,
+			Methods:     allHTTPMethods,
+		},
+	}
+	in := identityBytesMetric.WithLabelValues("/metered/*", "in")
+	out := identityBytesMetric.WithLabelValues("/metered/*", "out")
+	inBefore, outBefore := testutil.ToFloat64(in), testutil.ToFloat64(out)
+
+	requests := []fakeRequest{
+		{
+			URI:          "/metered/upload",
+			Method:       http.MethodPost,
+			Body:         "0123456789",
+			HasToken:     true,
+			ExpectedCode: http.StatusOK,
+		},
+		{
+			// the quota is exhausted by the previous response
+			URI:          "/metered/upload",
+			HasToken:     true,
+			ExpectedCode: http.StatusTooManyRequests,
+			OnResponse: func(i int, _ *resty.Request, resp *resty.Response) {
+				assert.NotEmpty(t, resp.Header().Get("Retry-After"), "case %d, expected a Retry-After header", i)
+				assert.Equal(t, float64(10), testutil.ToFloat64(in)-inBefore, "case %d, expected the bytes received", i)
+				// the error responses are accounted too
+				assert.True(t, testutil.ToFloat64(out)-outBefore > 200, "case %d, expected the bytes sent", i)
+			},
+		},
+		{
+			// the white-listed resources are not metered
+			URI:           "/public/file",
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+		},
+	}
+	newFakeProxy(cfg).RunTests(t, requests)
+}
@@ -0,0 +1,31 @@
+package proxy
+
+import (
+	"net/http"
+	"time"
+)
+
+// storage is used to hold the offline refresh token, assuming you don't want to use
+// the default practice of a encrypted cookie
+type storage interface {
+	// Set the token to the store, expiring after a ttl unless zero
+	Set(string, string, time.Duration) error
+	// SetIfAbsent sets a key unless it is already set, expiring after a ttl unless zero. It returns false when
+	// the key is already set.
+	SetIfAbsent(string, string, time.Duration) (bool, error)
+	// Get retrieves a token from the store
+	Get(string) (string, error)
+	// GetAndDelete retrieves a key and removes it at once, returning an empty value when the key is not found
+	GetAndDelete(string) (string, error)
+	// Delete removes a key from the store
+	Delete(string) error
+	// List retrieves the keys and values stored under a key prefix
+	List(string) (map[string]string, error)
+	// Close is used to close off any resources
+	Close() error
+}
+
+// reverseProxy is a wrapper for any underlying handler
+type reverseProxy interface {
+	ServeHTTP(rw http.ResponseWriter, req *http.Request)
+}
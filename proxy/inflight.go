@@ -0,0 +1,162 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// inflightLimiter limits the number of requests processed concurrently for the same client
+type inflightLimiter struct {
+	sync.Mutex
+	max     int
+	timeout time.Duration
+	clients map[string]*inflightSlots
+}
+
+// inflightSlots are the concurrency slots of a client, with the number of requests holding or waiting for one
+type inflightSlots struct {
+	slots chan struct{}
+	refs  int
+}
+
+func newInflightLimiter(max int, timeout time.Duration) *inflightLimiter {
+	return &inflightLimiter{
+		max:     max,
+		timeout: timeout,
+		clients: make(map[string]*inflightSlots),
+	}
+}
+
+// acquire waits for a concurrency slot for the client, up to the queue timeout. It returns
+// a function releasing the slot, or false when the client is over its limit.
+func (l *inflightLimiter) acquire(ctx context.Context, client string) (func(), bool) {
+	l.Lock()
+	c, found := l.clients[client]
+	if !found {
+		c = &inflightSlots{slots: make(chan struct{}, l.max)}
+		l.clients[client] = c
+	}
+	c.refs++
+	l.Unlock()
+
+	acquired := false
+	select {
+	case c.slots <- struct{}{}:
+		acquired = true
+	default:
+		if l.timeout > 0 {
+			timer := time.NewTimer(l.timeout)
+			select {
+			case c.slots <- struct{}{}:
+				acquired = true
+			case <-timer.C:
+			case <-ctx.Done():
+			}
+			timer.Stop()
+		}
+	}
+	if !acquired {
+		l.forget(client, c)
+		return nil, false
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			<-c.slots
+			l.forget(client, c)
+		})
+	}, true
+}
+
+// forget drops a reference to the client slots, removing them once unused
+func (l *inflightLimiter) forget(client string, c *inflightSlots) {
+	l.Lock()
+	defer l.Unlock()
+	c.refs--
+	if c.refs == 0 {
+		delete(l.clients, client)
+	}
+}
+
+// inflightMiddleware limits the concurrent requests per client IP, and releases the slot held
+// for the identity once the request has been proxied
+func (r *oauthProxy) inflightMiddleware() func(http.Handler) http.Handler {
+	var limiter *inflightLimiter
+	if r.config.MaxInflightPerIP > 0 {
+		limiter = newInflightLimiter(r.config.MaxInflightPerIP, r.config.InflightQueueTimeout)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if scope, ok := req.Context().Value(contextScopeName).(*RequestScope); ok {
+				defer func() {
+					if scope.releaseInflight != nil {
+						scope.releaseInflight()
+					}
+				}()
+			}
+
+			if limiter != nil {
+				release, ok := limiter.acquire(req.Context(), realIP(req))
+				if !ok {
+					inflightRejectedMetric.WithLabelValues("ip").Inc()
+					r.errorResponse(w, req, "too many concurrent requests from client address", http.StatusTooManyRequests, nil)
+					return
+				}
+				defer release()
+			}
+
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// inflightIdentityMiddleware limits the concurrent requests per authenticated user
+func (r *oauthProxy) inflightIdentityMiddleware() func(http.Handler) http.Handler {
+	if r.config.MaxInflightPerIdentity == 0 {
+		return func(next http.Handler) http.Handler {
+			return next
+		}
+	}
+	limiter := newInflightLimiter(r.config.MaxInflightPerIdentity, r.config.InflightQueueTimeout)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			scope, ok := req.Context().Value(contextScopeName).(*RequestScope)
+			if !ok || scope.AccessDenied || scope.Identity == nil {
+				next.ServeHTTP(w, req)
+				return
+			}
+
+			release, acquired := limiter.acquire(req.Context(), scope.Identity.id)
+			if !acquired {
+				inflightRejectedMetric.WithLabelValues("identity").Inc()
+				r.errorResponse(w, req, "too many concurrent requests from user", http.StatusTooManyRequests, nil)
+				r.revokeProxy(w, req)
+				return
+			}
+			// the slot is held until the request has been proxied upstream
+			scope.releaseInflight = release
+
+			next.ServeHTTP(w, req)
+		})
+	}
+}
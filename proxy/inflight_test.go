@@ -0,0 +1,117 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInflightLimiter(t *testing.T) {
+	l := newInflightLimiter(1, 0)
+	release, ok := l.acquire(context.Background(), "client")
+	require.True(t, ok)
+	_, ok = l.acquire(context.Background(), "client")
+	assert.False(t, ok)
+
+	// other clients are not affected
+	other, ok := l.acquire(context.Background(), "other")
+	require.True(t, ok)
+	other()
+
+	// waiting requests get the slot once released
+	l.timeout = time.Second
+	go func() {
+		<-time.After(50 * time.Millisecond)
+		release()
+	}()
+	release, ok = l.acquire(context.Background(), "client")
+	require.True(t, ok)
+
+	// waiting requests give up with the request
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, ok = l.acquire(ctx, "client")
+	assert.False(t, ok)
+
+	release()
+	release()
+	assert.Empty(t, l.clients)
+}
+
+func TestInflightLimits(t *testing.T) {
+	limits := map[string]func(*Config){
+		"identity": func(cfg *Config) { cfg.MaxInflightPerIdentity = 1 },
+		"ip":       func(cfg *Config) { cfg.MaxInflightPerIP = 1 },
+	}
+	for name, limit := range limits {
+		t.Run(name, func(t *testing.T) {
+			received := make(chan struct{})
+			unblock := make(chan struct{})
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				received <- struct{}{}
+				<-unblock
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer upstream.Close()
+
+			cfg := newFakeKeycloakConfig()
+			limit(cfg)
+			for _, x := range cfg.Resources {
+				if x.URL == fakeAuthAllURL {
+					// a dedicated connection pool reaches the test upstream rather than the fake one
+					x.Upstream = upstream.URL
+					x.MaxIdleConns = 1
+				}
+			}
+			p := newFakeProxy(cfg)
+			token, err := p.idp.signToken(newTestToken(p.idp.getLocation()).claims)
+			require.NoError(t, err)
+
+			do := func() int {
+				req, err := http.NewRequest(http.MethodGet, p.getServiceURL()+"/auth_all/test", nil)
+				require.NoError(t, err)
+				req.Header.Set(authorizationHeader, "Bearer "+token.Encode())
+				resp, err := http.DefaultClient.Do(req)
+				require.NoError(t, err)
+				_ = resp.Body.Close()
+				return resp.StatusCode
+			}
+
+			first := make(chan int)
+			go func() {
+				first <- do()
+			}()
+			<-received
+
+			// a second request of the same client is rejected while the first is in progress
+			assert.Equal(t, http.StatusTooManyRequests, do())
+			close(unblock)
+			assert.Equal(t, http.StatusOK, <-first)
+
+			go func() {
+				<-received
+			}()
+			assert.Equal(t, http.StatusOK, do())
+		})
+	}
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/jose"
+)
+
+// errTokenInactive indicates the introspection endpoint reports the token is not active, e.g. expired or revoked
+var errTokenInactive = errors.New("the access token is not active")
+
+// introspectionURL returns the RFC 7662 introspection endpoint of the provider
+func (r *oauthProxy) introspectionURL() string {
+	provider := strings.TrimSuffix(r.config.DiscoveryURL, "/.well-known/openid-configuration")
+	return defaultTo(r.config.IntrospectionEndpoint, provider+"/protocol/openid-connect/token/introspect")
+}
+
+// introspectToken posts an access token to the introspection endpoint, authenticated as the client, and returns
+// the claims of an active token. The claims are those of the token with keycloak, and at least its subject,
+// expiration and client with other providers: the client is then taken as the audience. As with the signed tokens,
+// the token must be issued by the provider for the client.
+func (r *oauthProxy) introspectToken(ctx context.Context, token string) (jose.Claims, error) {
+	client, err := r.getProviderClient().OAuthClient()
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{"token": []string{token}, "token_type_hint": []string{"access_token"}}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, r.introspectionURL(), bytes.NewBufferString(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.SetBasicAuth(url.QueryEscape(r.config.ClientID), url.QueryEscape(r.config.ClientSecret))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	start := time.Now()
+	response, err := client.HttpClient().Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	oauthLatencyMetric.WithLabelValues("introspect").Observe(time.Since(start).Seconds())
+
+	content, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("the introspection endpoint responded %d: %s", response.StatusCode, content)
+	}
+	var claims jose.Claims
+	if err := json.Unmarshal(content, &claims); err != nil {
+		return nil, err
+	}
+	if active, _ := claims["active"].(bool); !active {
+		return nil, errTokenInactive
+	}
+	delete(claims, "active")
+	if _, found := claims[claimAudience]; !found {
+		if clientID, ok := claims["client_id"].(string); ok {
+			claims[claimAudience] = clientID
+		}
+	}
+	if err := r.verifyIntrospectedClaims(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// verifyIntrospectedClaims checks the issuer, when introspected, and the audience of an active token
+func (r *oauthProxy) verifyIntrospectedClaims(claims jose.Claims) error {
+	if iss, found := claims["iss"].(string); found {
+		token, err := jose.NewJWT(jose.JOSEHeader{"alg": "none"}, claims)
+		if err != nil {
+			return err
+		}
+		issuer, err := r.expectedIssuer(token)
+		if err != nil {
+			return err
+		}
+		if strings.TrimSuffix(iss, "/") != strings.TrimSuffix(issuer, "/") {
+			return fmt.Errorf("the access token is issued by %s, not by the provider %s", iss, issuer)
+		}
+	}
+	var audiences []string
+	if aud, found, err := claims.StringClaim(claimAudience); err == nil && found {
+		audiences = append(audiences, aud)
+	} else if aud, found, err := claims.StringsClaim(claimAudience); err == nil && found {
+		audiences = aud
+	}
+	if !containsString(r.config.ClientID, audiences) {
+		return fmt.Errorf("the access token is not issued for the client %s, aud=%v", r.config.ClientID, audiences)
+	}
+
+	return nil
+}
+
+// introspectIdentity returns the identity of an opaque access token, from the claims of the introspection
+func (r *oauthProxy) introspectIdentity(ctx context.Context, access string) (*userContext, error) {
+	claims, err := r.introspectToken(ctx, access)
+	if err != nil {
+		return nil, err
+	}
+	// the claims are carried by an unsigned token, for the identity to be extracted as from a jwt
+	token, err := jose.NewJWT(jose.JOSEHeader{"alg": "none"}, claims)
+	if err != nil {
+		return nil, err
+	}
+	user, err := r.identities.extractIdentity(token)
+	if err != nil {
+		return nil, err
+	}
+	user.opaqueToken = access
+
+	return user, nil
+}
+
+// introspectIdentityToken verifies a jwt access token is active at the introspection endpoint, in place of its
+// signature
+func (r *oauthProxy) introspectIdentityToken(user *userContext) error {
+	if user.expiresAt.Add(r.config.ClockSkewLeeway).Before(time.Now()) {
+		return ErrAccessTokenExpired
+	}
+	if _, err := r.introspectToken(context.Background(), user.encodedToken()); err != nil {
+		return err
+	}
+	r.tokens.addUntil(user, time.Now().Add(r.config.IntrospectionCacheDuration))
+
+	return nil
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-oidc/jose"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntrospectionValid(t *testing.T) {
+	c := &Config{EnableTokenIntrospection: true, ClientSecret: fakeSecret, IntrospectionEndpoint: "https://idp.example.com/introspect"}
+	assert.NoError(t, c.isIntrospectionValid())
+
+	c = &Config{EnableTokenIntrospection: true}
+	assert.Error(t, c.isIntrospectionValid(), "the introspection requires the client secret")
+
+	c = &Config{EnableTokenIntrospection: true, ClientSecret: fakeSecret, IntrospectionEndpoint: "/introspect"}
+	assert.Error(t, c.isIntrospectionValid())
+
+	c = &Config{EnableTokenIntrospection: true, ClientSecret: fakeSecret, IntrospectionCacheDuration: -time.Second}
+	assert.Error(t, c.isIntrospectionValid())
+}
+
+func TestTokenIntrospection(t *testing.T) {
+	// the tokens signed by a key the proxy does not hold are only known to the provider
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	var unknown, foreign *fakeToken
+	var signed, signedForeign *jose.JWT
+
+	var introspected int32
+	introspection := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&introspected, 1)
+		if id, secret, _ := req.BasicAuth(); id != fakeClientID || secret != fakeSecret {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		response := map[string]interface{}{"active": false}
+		switch token := req.PostFormValue("token"); token {
+		case "opaque":
+			response = map[string]interface{}{
+				"active":             true,
+				"sub":                "robot-id",
+				"exp":                time.Now().Add(time.Hour).Unix(),
+				"client_id":          fakeClientID,
+				"preferred_username": "robot",
+				"realm_access":       map[string]interface{}{"roles": []string{"machines"}},
+			}
+		case "other-client":
+			response = map[string]interface{}{
+				"active":    true,
+				"sub":       "robot-id",
+				"exp":       time.Now().Add(time.Hour).Unix(),
+				"client_id": "billing",
+			}
+		case signed.Encode():
+			response = map[string]interface{}{"active": true}
+			for k, v := range unknown.claims {
+				response[k] = v
+			}
+		case signedForeign.Encode():
+			response = map[string]interface{}{"active": true}
+			for k, v := range foreign.claims {
+				response[k] = v
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+	defer introspection.Close()
+
+	c := newFakeKeycloakConfig()
+	c.EnableTokenIntrospection = true
+	c.IntrospectionEndpoint = introspection.URL
+	c.IntrospectionCacheDuration = time.Minute
+	c.TokenCacheSize = 10
+	c.NoRedirects = true
+	c.Resources = []*Resource{{URL: "/machines/*", Methods: allHTTPMethods, Roles: []string{"machines"}}}
+	p := newFakeProxy(c)
+	defer func() {
+		p.idp.Close()
+		p.proxy.server.Close()
+	}()
+	unknown = newTestToken(p.idp.getLocation())
+	unknown.addRealmRoles([]string{"machines"})
+	signed, err = jose.NewSignedJWT(unknown.claims, jose.NewSignerRSA("other-kid", *key))
+	require.NoError(t, err)
+	foreign = newTestToken("https://other.example.com")
+	foreign.addRealmRoles([]string{"machines"})
+	signedForeign, err = jose.NewSignedJWT(foreign.claims, jose.NewSignerRSA("other-kid", *key))
+	require.NoError(t, err)
+
+	get := func(token string) (int, fakeUpstreamResponse) {
+		req, err := http.NewRequest(http.MethodGet, p.getServiceURL()+"/machines/jobs", nil)
+		require.NoError(t, err)
+		req.Header.Set(authorizationHeader, authorizationType+" "+token)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var upstream fakeUpstreamResponse
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&upstream))
+		}
+		return resp.StatusCode, upstream
+	}
+
+	code, upstream := get("opaque")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Bearer opaque", upstream.Headers.Get(authorizationHeader), "the opaque token is forwarded as is")
+	assert.Equal(t, "robot", upstream.Headers.Get("X-Auth-Username"))
+
+	// the active tokens are cached
+	code, _ = get("opaque")
+	assert.Equal(t, http.StatusOK, code)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&introspected))
+
+	code, _ = get("revoked")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	code, _ = get(signed.Encode())
+	assert.Equal(t, http.StatusOK, code, "the jwt are introspected in place of their signature")
+
+	// the active tokens of other clients or issuers are refused
+	code, _ = get("other-client")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = get(signedForeign.Encode())
+	assert.Equal(t, http.StatusForbidden, code)
+
+	// the requests are not authenticated while the provider cannot be reached
+	introspection.Close()
+	code, _ = get("other")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+}
@@ -0,0 +1,330 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/oauth2"
+	"github.com/urfave/cli"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// syncOptions are the parameters of the synchronization of the resources with keycloak
+type syncOptions struct {
+	// adminURL is the base url of keycloak, derived from the discovery url by default
+	adminURL string
+	// realm is the realm of the client, derived from the discovery url by default
+	realm string
+	// clientID is the client whose roles and authorization resources are read
+	clientID string
+	// username and password are the credentials of an administrator of the admin realm, the client
+	// credentials of the configuration being used otherwise
+	username   string
+	password   string
+	adminRealm string
+	// clientSecret is the secret of the client, when authenticating with the client credentials
+	clientSecret string
+}
+
+// keycloakAuthzResource is a resource of the authorization services of a keycloak client
+type keycloakAuthzResource struct {
+	Name       string              `json:"name"`
+	URIs       []string            `json:"uris"`
+	Attributes map[string][]string `json:"attributes"`
+	Scopes     []struct {
+		Name string `json:"name"`
+	} `json:"scopes"`
+}
+
+// syncedResource is a resource generated from keycloak, rendered without the unset options
+type syncedResource struct {
+	URL         string   `yaml:"uri"`
+	Methods     []string `yaml:"methods,omitempty"`
+	Roles       []string `yaml:"roles,omitempty"`
+	UMAResource string   `yaml:"uma-resource,omitempty"`
+}
+
+// newSyncResourcesCommand creates the sync-resources subcommand, which generates the resources from keycloak
+func newSyncResourcesCommand() cli.Command {
+	return cli.Command{
+		Name:      "sync-resources",
+		Usage:     "generate the resources from the roles and authorization resources of the client in keycloak",
+		UsageText: "keycloak-gatekeeper --config FILE [options] sync-resources [--output FILE]",
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "admin-url", Usage: "base url of keycloak, derived from the discovery url by default"},
+			cli.StringFlag{Name: "realm", Usage: "realm of the client, derived from the discovery url by default"},
+			cli.StringFlag{Name: "admin-username", Usage: "username of a keycloak administrator, the client credentials are used by default", EnvVar: "KEYCLOAK_ADMIN_USERNAME"},
+			cli.StringFlag{Name: "admin-password", Usage: "password of the keycloak administrator", EnvVar: "KEYCLOAK_ADMIN_PASSWORD"},
+			cli.StringFlag{Name: "admin-realm", Value: "master", Usage: "realm of the keycloak administrator"},
+			cli.StringFlag{Name: "output", Usage: "path to the file of the generated resources, written to the standard output by default"},
+		},
+		Action: func(cx *cli.Context) error {
+			config := newDefaultConfig()
+			if configFile := cx.GlobalString("config"); configFile != "" {
+				if err := readConfigFile(configFile, config); err != nil {
+					return printError("unable to read the configuration file: %s, error: %s", configFile, err.Error())
+				}
+			}
+			root := cx
+			for root.Parent() != nil {
+				root = root.Parent()
+			}
+			if err := parseCLIOptions(root, config); err != nil {
+				return printError(err.Error())
+			}
+
+			options := syncOptions{
+				adminURL:     cx.String("admin-url"),
+				realm:        cx.String("realm"),
+				clientID:     config.ClientID,
+				username:     cx.String("admin-username"),
+				password:     cx.String("admin-password"),
+				adminRealm:   cx.String("admin-realm"),
+				clientSecret: config.ClientSecret,
+			}
+			if options.adminURL == "" || options.realm == "" {
+				base, realm, err := keycloakAdminEndpoint(config.DiscoveryURL)
+				if err != nil {
+					return printError(err.Error())
+				}
+				options.adminURL, options.realm = defaultTo(options.adminURL, base), defaultTo(options.realm, realm)
+			}
+			if options.clientID == "" {
+				return printError("the client id has not been set")
+			}
+			hc, err := newKeycloakAdminClient(config)
+			if err != nil {
+				return printError(err.Error())
+			}
+
+			content, err := syncResources(context.Background(), hc, options)
+			if err != nil {
+				return printError("unable to read the resources from keycloak: %s", err)
+			}
+			output := cx.String("output")
+			if output == "" {
+				_, _ = os.Stdout.Write(content)
+				return nil
+			}
+			if err := writeFileAtomically(output, content); err != nil {
+				return printError("unable to write the resources: %s, error: %s", output, err)
+			}
+
+			return nil
+		},
+	}
+}
+
+// keycloakAdminEndpoint derives the base url of keycloak and the realm from a discovery url,
+// e.g. https://keycloak/auth/realms/example
+func keycloakAdminEndpoint(discoveryURL string) (string, string, error) {
+	u, err := url.Parse(strings.TrimSuffix(discoveryURL, "/.well-known/openid-configuration"))
+	if err != nil || u.Host == "" {
+		return "", "", fmt.Errorf("the keycloak url cannot be derived from the discovery url: %q", discoveryURL)
+	}
+	index := strings.LastIndex(u.Path, "/realms/")
+	if index < 0 || strings.Contains(strings.Trim(u.Path[index+8:], "/"), "/") || strings.Trim(u.Path[index+8:], "/") == "" {
+		return "", "", fmt.Errorf("the discovery url is not the one of a keycloak realm: %q", discoveryURL)
+	}
+	realm := strings.Trim(u.Path[index+8:], "/")
+	u.Path = u.Path[:index]
+
+	return u.String(), realm, nil
+}
+
+// newKeycloakAdminClient returns a client of keycloak, trusting the provider as the proxy does
+func newKeycloakAdminClient(config *Config) (*http.Client, error) {
+	var pool *x509.CertPool
+	if config.OpenIDProviderCA != "" {
+		var err error
+		if pool, err = makeCertPool("OpenID provider", config.OpenIDProviderCA); err != nil {
+			return nil, err
+		}
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: makeProxyFunc(config.OpenIDProviderProxy, config.OpenIDProviderNoProxy),
+			//nolint:gas
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: config.SkipOpenIDProviderTLSVerify, RootCAs: pool},
+		},
+		Timeout: 10 * time.Second,
+	}, nil
+}
+
+// syncResources reads the roles and the authorization resources of the client, and renders them as resources
+func syncResources(ctx context.Context, hc *http.Client, options syncOptions) ([]byte, error) {
+	token, err := keycloakAdminToken(ctx, hc, options)
+	if err != nil {
+		return nil, err
+	}
+	admin := strings.TrimSuffix(options.adminURL, "/") + "/admin/realms/" + url.PathEscape(options.realm)
+
+	var clients []struct {
+		ID string `json:"id"`
+	}
+	if err := keycloakAdminGet(ctx, hc, token, admin+"/clients?clientId="+url.QueryEscape(options.clientID), &clients); err != nil {
+		return nil, err
+	}
+	if len(clients) != 1 {
+		return nil, fmt.Errorf("the client %s is not found in the realm %s", options.clientID, options.realm)
+	}
+	client := admin + "/clients/" + url.PathEscape(clients[0].ID)
+
+	var roles []struct {
+		Name string `json:"name"`
+	}
+	if err := keycloakAdminGet(ctx, hc, token, client+"/roles", &roles); err != nil {
+		return nil, err
+	}
+	clientRoles := make([]string, 0, len(roles))
+	for _, x := range roles {
+		clientRoles = append(clientRoles, x.Name)
+	}
+	var resources []keycloakAuthzResource
+	if err := keycloakAdminGet(ctx, hc, token, client+"/authz/resource-server/resource", &resources); err != nil {
+		return nil, err
+	}
+
+	return renderSyncedResources(options, clientRoles, resources)
+}
+
+// renderSyncedResources converts the authorization resources of the client into resources: a resource per uri,
+// restricted to the http methods among its scopes, and requiring the roles of its "roles" attribute, qualified with
+// the client id when they are roles of the client
+func renderSyncedResources(options syncOptions, clientRoles []string, resources []keycloakAuthzResource) ([]byte, error) {
+	var synced []syncedResource
+	for _, x := range resources {
+		var methods []string
+		for _, scope := range x.Scopes {
+			if method := strings.ToUpper(scope.Name); isValidHTTPMethod(method) && !containedIn(method, methods, false) {
+				methods = append(methods, method)
+			}
+		}
+		sort.Strings(methods)
+		var roles []string
+		for _, role := range x.Attributes["roles"] {
+			if containedIn(role, clientRoles, false) {
+				role = options.clientID + ":" + role
+			}
+			roles = append(roles, role)
+		}
+		for _, uri := range x.URIs {
+			synced = append(synced, syncedResource{URL: uri, Methods: methods, Roles: roles, UMAResource: x.Name})
+		}
+	}
+	sort.SliceStable(synced, func(i, j int) bool { return synced[i].URL < synced[j].URL })
+
+	content, err := yaml.Marshal(map[string][]syncedResource{"resources": synced})
+	if err != nil {
+		return nil, err
+	}
+	header := fmt.Sprintf("# generated from the client %s of the keycloak realm %s\n", options.clientID, options.realm)
+
+	return append([]byte(header), content...), nil
+}
+
+// keycloakAdminToken obtains an access token to the admin api, from the credentials of an administrator
+// or the client credentials
+func keycloakAdminToken(ctx context.Context, hc *http.Client, options syncOptions) (string, error) {
+	realm := options.realm
+	form := url.Values{}
+	if options.username != "" {
+		realm = options.adminRealm
+		form.Set("grant_type", oauth2.GrantTypeUserCreds)
+		form.Set("client_id", "admin-cli")
+		form.Set("username", options.username)
+		form.Set("password", options.password)
+	} else {
+		form.Set("grant_type", oauth2.GrantTypeClientCreds)
+		form.Set("client_id", options.clientID)
+		form.Set("client_secret", options.clientSecret)
+	}
+	endpoint := strings.TrimSuffix(options.adminURL, "/") + "/realms/" + url.PathEscape(realm) + "/protocol/openid-connect/token"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := keycloakDo(hc, req, &token); err != nil {
+		return "", fmt.Errorf("unable to authenticate to keycloak: %s", err)
+	}
+
+	return token.AccessToken, nil
+}
+
+// keycloakAdminGet decodes the response of the admin api to a request
+func keycloakAdminGet(ctx context.Context, hc *http.Client, token, endpoint string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(authorizationHeader, authorizationType+" "+token)
+
+	return keycloakDo(hc, req, v)
+}
+
+func keycloakDo(hc *http.Client, req *http.Request, v interface{}) error {
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response from %s: %s", req.URL.Path, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// writeFileAtomically replaces a file, so that its readers never see a partial content
+func writeFileAtomically(filename string, content []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.Write(content); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filename)
+}
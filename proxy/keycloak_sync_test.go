@@ -0,0 +1,122 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestKeycloakAdminEndpoint(t *testing.T) {
+	cs := []struct {
+		DiscoveryURL string
+		Base         string
+		Realm        string
+	}{
+		{DiscoveryURL: "https://keycloak.example.com/auth/realms/hod-test", Base: "https://keycloak.example.com/auth", Realm: "hod-test"},
+		{DiscoveryURL: "https://keycloak.example.com/realms/test/.well-known/openid-configuration", Base: "https://keycloak.example.com", Realm: "test"},
+		{DiscoveryURL: "https://accounts.example.com"},
+		{DiscoveryURL: "https://keycloak.example.com/realms/"},
+		{DiscoveryURL: "realms/test"},
+	}
+	for i, c := range cs {
+		base, realm, err := keycloakAdminEndpoint(c.DiscoveryURL)
+		if c.Realm == "" {
+			assert.Error(t, err, "case %d", i)
+			continue
+		}
+		require.NoError(t, err, "case %d", i)
+		assert.Equal(t, c.Base, base, "case %d", i)
+		assert.Equal(t, c.Realm, realm, "case %d", i)
+	}
+}
+
+func TestSyncResources(t *testing.T) {
+	keycloak := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/auth/realms/master/protocol/openid-connect/token" {
+			if req.PostFormValue("client_id") != "admin-cli" || req.PostFormValue("password") != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]string{"access_token": "admin"})
+			return
+		}
+		if req.Header.Get(authorizationHeader) != "Bearer admin" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var content string
+		switch req.URL.Path {
+		case "/auth/admin/realms/test/clients":
+			content = `[{"id": "5b1f"}]`
+			if req.URL.Query().Get("clientId") != fakeClientID {
+				content = `[]`
+			}
+		case "/auth/admin/realms/test/clients/5b1f/roles":
+			content = `[{"name": "editor"}, {"name": "viewer"}]`
+		case "/auth/admin/realms/test/clients/5b1f/authz/resource-server/resource":
+			content = `[
+				{"name": "documents", "uris": ["/documents/*", "/drafts/*"], "attributes": {"roles": ["editor", "admin"]},
+				 "scopes": [{"name": "get"}, {"name": "PUT"}, {"name": "share"}]},
+				{"name": "Default Resource", "uris": ["/*"]}
+			]`
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(content))
+	}))
+	defer keycloak.Close()
+
+	options := syncOptions{
+		adminURL:   keycloak.URL + "/auth",
+		realm:      "test",
+		clientID:   fakeClientID,
+		username:   "admin",
+		password:   "secret",
+		adminRealm: "master",
+	}
+	content, err := syncResources(context.Background(), keycloak.Client(), options)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "# generated from the client test of the keycloak realm test")
+
+	// the generated resources are read as the ones of a configuration
+	config := newDefaultConfig()
+	require.NoError(t, yaml.Unmarshal(content, config))
+	require.Len(t, config.Resources, 3)
+	assert.Equal(t, &Resource{URL: "/*", UMAResource: "Default Resource"}, config.Resources[0])
+	assert.Equal(t, &Resource{
+		URL:         "/documents/*",
+		Methods:     []string{http.MethodGet, http.MethodPut},
+		Roles:       []string{fakeClientID + ":editor", "admin"},
+		UMAResource: "documents",
+	}, config.Resources[1])
+	assert.Equal(t, "/drafts/*", config.Resources[2].URL)
+
+	options.password = "wrong"
+	_, err = syncResources(context.Background(), keycloak.Client(), options)
+	assert.Error(t, err)
+	options.password, options.clientID = "secret", "unknown"
+	_, err = syncResources(context.Background(), keycloak.Client(), options)
+	assert.Error(t, err)
+}
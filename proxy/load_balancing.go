@@ -0,0 +1,110 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sync/atomic"
+)
+
+const (
+	balanceRoundRobin       = "round-robin"
+	balanceLeastConnections = "least-connections"
+)
+
+// balancedUpstream is an upstream endpoint, with the count of its requests in flight
+type balancedUpstream struct {
+	url    *url.URL
+	active int64
+}
+
+// release accounts for the end of a request to the upstream
+func (u *balancedUpstream) release() {
+	atomic.AddInt64(&u.active, -1)
+}
+
+// upstreamBalancer spreads the requests over identical upstream endpoints
+type upstreamBalancer struct {
+	strategy  string
+	upstreams []*balancedUpstream
+	next      uint32
+}
+
+// newUpstreamBalancer creates a balancer over a primary upstream and the others, if any
+func newUpstreamBalancer(primary string, others []string, strategy string) *upstreamBalancer {
+	if primary == "" || len(others) == 0 {
+		return nil
+	}
+	balancer := &upstreamBalancer{strategy: defaultTo(strategy, balanceRoundRobin)}
+	for _, upstream := range append([]string{primary}, others...) {
+		// the urls have been checked with the configuration
+		u, _ := url.Parse(upstream)
+		balancer.upstreams = append(balancer.upstreams, &balancedUpstream{url: u})
+	}
+
+	return balancer
+}
+
+// newUpstreamBalancer returns the balancer of a resource, or else the one of the default upstream
+func (r *oauthProxy) newUpstreamBalancer(resource *Resource) *upstreamBalancer {
+	if resource != nil && resource.Upstream != "" {
+		return newUpstreamBalancer(resource.Upstream, resource.Upstreams, resource.UpstreamBalancing)
+	}
+
+	return r.balancer
+}
+
+// pick selects the upstream of a request, which must be released once the request is proxied
+func (b *upstreamBalancer) pick() *balancedUpstream {
+	start := int(atomic.AddUint32(&b.next, 1)-1) % len(b.upstreams)
+	picked := b.upstreams[start]
+	if b.strategy == balanceLeastConnections {
+		// the ties are broken in turn, for the idle upstreams to be used evenly
+		for i := 1; i < len(b.upstreams); i++ {
+			candidate := b.upstreams[(start+i)%len(b.upstreams)]
+			if atomic.LoadInt64(&candidate.active) < atomic.LoadInt64(&picked.active) {
+				picked = candidate
+			}
+		}
+	}
+	atomic.AddInt64(&picked.active, 1)
+
+	return picked
+}
+
+// isUpstreamBalancingValid checks the other upstreams and the strategy balancing the requests over them
+func isUpstreamBalancingValid(primary string, others []string, strategy string) error {
+	switch strategy {
+	case "", balanceRoundRobin, balanceLeastConnections:
+	default:
+		return fmt.Errorf("invalid upstream balancing %q, expected %s or %s", strategy, balanceRoundRobin, balanceLeastConnections)
+	}
+	if len(others) == 0 {
+		return nil
+	}
+	if primary == "" {
+		return errors.New("upstream-urls are balanced with the upstream-url, which is not set")
+	}
+	for _, upstream := range append([]string{primary}, others...) {
+		if u, err := url.Parse(upstream); err != nil || (u.Scheme != unsecureScheme && u.Scheme != secureScheme) || u.Host == "" {
+			return fmt.Errorf("the balanced upstream %q is not a valid http url", upstream)
+		}
+	}
+
+	return nil
+}
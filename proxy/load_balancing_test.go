@@ -0,0 +1,93 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpstreamBalancerRoundRobin(t *testing.T) {
+	balancer := newUpstreamBalancer("http://a", []string{"http://b", "http://c"}, "")
+	require.NotNil(t, balancer)
+
+	var hosts []string
+	for i := 0; i < 6; i++ {
+		picked := balancer.pick()
+		hosts = append(hosts, picked.url.Host)
+		picked.release()
+	}
+	assert.Equal(t, []string{"a", "b", "c", "a", "b", "c"}, hosts)
+
+	assert.Nil(t, newUpstreamBalancer("http://a", nil, balanceRoundRobin), "expected no balancer for a single upstream")
+}
+
+func TestUpstreamBalancerLeastConnections(t *testing.T) {
+	balancer := newUpstreamBalancer("http://a", []string{"http://b", "http://c"}, balanceLeastConnections)
+
+	// a long request stays on the first upstream
+	slow := balancer.pick()
+	assert.Equal(t, "a", slow.url.Host)
+	for i := 0; i < 4; i++ {
+		picked := balancer.pick()
+		assert.NotEqual(t, "a", picked.url.Host, "case %d, expected the busy upstream to be avoided", i)
+		picked.release()
+	}
+	slow.release()
+}
+
+func TestUpstreamBalancing(t *testing.T) {
+	var lock sync.Mutex
+	hits := make(map[string]int)
+	var urls []string
+	for _, name := range []string{"one", "two", "three"} {
+		name := name
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			lock.Lock()
+			hits[name]++
+			lock.Unlock()
+		}))
+		defer upstream.Close()
+		urls = append(urls, upstream.URL)
+	}
+
+	cfg := newFakeKeycloakConfig()
+	cfg.Resources = []*Resource{
+		{
+			URL:          "/api/*",
+			Methods:      allHTTPMethods,
+			Upstream:     urls[0],
+			Upstreams:    urls[1:],
+			MaxIdleConns: 10,
+		},
+	}
+	requests := make([]fakeRequest, 0, 6)
+	for i := 0; i < 6; i++ {
+		requests = append(requests, fakeRequest{
+			URI:          "/api/orders",
+			HasToken:     true,
+			ExpectedCode: http.StatusOK,
+		})
+	}
+	newFakeProxy(cfg).RunTests(t, requests)
+
+	assert.Equal(t, map[string]int{"one": 2, "two": 2, "three": 2}, hits)
+}
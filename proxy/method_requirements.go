@@ -0,0 +1,69 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MethodRequirement is a role or group requirement on some of the methods of a resource
+type MethodRequirement struct {
+	// Methods are the methods of the requests the requirement applies to
+	Methods []string `json:"methods" yaml:"methods"`
+	// Roles the roles required to send the requests
+	Roles []string `json:"roles" yaml:"roles"`
+	// Groups the groups, any of which is required to send the requests
+	Groups []string `json:"groups" yaml:"groups"`
+	// RequireAnyRole indicates that ANY of the roles are required, the default is all
+	RequireAnyRole bool `json:"require-any-role" yaml:"require-any-role"`
+}
+
+// matches checks if the requirement applies to a method
+func (m *MethodRequirement) matches(method string) bool {
+	return containedIn(method, m.Methods, false)
+}
+
+// admits checks if the roles and groups of a user meet the requirement
+func (m *MethodRequirement) admits(user *userContext) bool {
+	return hasAccess(m.Roles, user.roles, !m.RequireAnyRole, false) && hasAccess(m.Groups, user.groups, false, true)
+}
+
+// isMethodRequirementsValid checks the requirements on the methods of a resource
+func isMethodRequirementsValid(resource *Resource) error {
+	if len(resource.MethodRequirements) == 0 {
+		return nil
+	}
+	if resource.WhiteListed || resource.OptionalAuth {
+		return fmt.Errorf("the resource %s has method-requirements, but admits the requests without authentication", resource.URL)
+	}
+	for _, rule := range resource.MethodRequirements {
+		if len(rule.Methods) == 0 {
+			return fmt.Errorf("a method requirement of resource %s has no methods", resource.URL)
+		}
+		for i, method := range rule.Methods {
+			rule.Methods[i] = strings.ToUpper(method)
+			if !containedIn(rule.Methods[i], resource.Methods, false) {
+				return fmt.Errorf("the method %s of a method requirement is not proxied by resource %s", method, resource.URL)
+			}
+		}
+		if len(rule.Roles) == 0 && len(rule.Groups) == 0 {
+			return fmt.Errorf("the methods %s of resource %s require no roles or groups", strings.Join(rule.Methods, ","), resource.URL)
+		}
+	}
+
+	return nil
+}
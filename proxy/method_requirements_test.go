@@ -0,0 +1,77 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMethodRequirements(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.Resources = []*Resource{
+		{
+			URL:     "/documents/*",
+			Methods: allHTTPMethods,
+			Roles:   []string{"user"},
+			MethodRequirements: []*MethodRequirement{
+				{Methods: []string{http.MethodGet, http.MethodHead}, Roles: []string{"viewer", "editor"}, RequireAnyRole: true},
+				{Methods: []string{http.MethodPost, http.MethodPut}, Roles: []string{"editor"}},
+				{Methods: []string{http.MethodDelete}, Roles: []string{"editor"}, Groups: []string{"admins"}},
+			},
+		},
+	}
+	requests := []fakeRequest{
+		{URI: "/documents/1", HasToken: true, Roles: []string{"user", "viewer"}, ExpectedProxy: true, ExpectedCode: http.StatusOK},
+		{URI: "/documents/1", HasToken: true, Roles: []string{"user", "editor"}, ExpectedProxy: true, ExpectedCode: http.StatusOK},
+		{URI: "/documents/1", HasToken: true, Roles: []string{"user"}, ExpectedCode: http.StatusForbidden},
+		// the roles of the resource are still required
+		{URI: "/documents/1", HasToken: true, Roles: []string{"viewer"}, ExpectedCode: http.StatusForbidden},
+		{URI: "/documents/1", Method: http.MethodPost, HasToken: true, Roles: []string{"user", "viewer"}, ExpectedCode: http.StatusForbidden},
+		{URI: "/documents/1", Method: http.MethodPost, HasToken: true, Roles: []string{"user", "editor"}, ExpectedProxy: true, ExpectedCode: http.StatusOK},
+		{URI: "/documents/1", Method: http.MethodDelete, HasToken: true, Roles: []string{"user", "editor"}, ExpectedCode: http.StatusForbidden},
+		{
+			URI: "/documents/1", Method: http.MethodDelete, HasToken: true, Roles: []string{"user", "editor"}, Groups: []string{"admins"},
+			ExpectedProxy: true, ExpectedCode: http.StatusOK,
+		},
+		// the methods without requirements only need the roles of the resource
+		{URI: "/documents/1", Method: http.MethodPatch, HasToken: true, Roles: []string{"user"}, ExpectedProxy: true, ExpectedCode: http.StatusOK},
+	}
+	newFakeProxy(cfg).RunTests(t, requests)
+}
+
+func TestMethodRequirementsValid(t *testing.T) {
+	valid := &Resource{URL: "/documents/*", Methods: allHTTPMethods, MethodRequirements: []*MethodRequirement{{Methods: []string{"post"}, Roles: []string{"editor"}}}}
+	assert.NoError(t, valid.valid())
+	assert.Equal(t, []string{http.MethodPost}, valid.MethodRequirements[0].Methods)
+
+	// the resources proxy all the methods by default
+	defaulted := &Resource{URL: "/documents/*", MethodRequirements: []*MethodRequirement{{Methods: []string{http.MethodPost}, Roles: []string{"editor"}}}}
+	assert.NoError(t, defaulted.valid())
+
+	cs := []*Resource{
+		{URL: "/documents/*", Methods: allHTTPMethods, MethodRequirements: []*MethodRequirement{{Roles: []string{"editor"}}}},
+		{URL: "/documents/*", Methods: allHTTPMethods, MethodRequirements: []*MethodRequirement{{Methods: []string{http.MethodPost}}}},
+		{URL: "/documents/*", Methods: []string{http.MethodGet}, MethodRequirements: []*MethodRequirement{{Methods: []string{http.MethodPost}, Roles: []string{"editor"}}}},
+		{URL: "/documents/*", Methods: allHTTPMethods, WhiteListed: true, MethodRequirements: []*MethodRequirement{{Methods: []string{http.MethodPost}, Roles: []string{"editor"}}}},
+		{URL: "/documents/*", Methods: allHTTPMethods, OptionalAuth: true, MethodRequirements: []*MethodRequirement{{Methods: []string{http.MethodPost}, Roles: []string{"editor"}}}},
+	}
+	for i, c := range cs {
+		assert.Error(t, c.valid(), "case %d", i)
+	}
+}
@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	certificateRotationMetric = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "proxy_certificate_rotation_total",
+			Help: "The total amount of times the certificate has been rotated",
+		},
+	)
+	oauthTokensMetric = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "proxy_oauth_tokens_total",
+			Help: "A summary of the tokens issued, renewed or failed logins",
+		},
+		[]string{"action"},
+	)
+	oauthLatencyMetric = prometheus.NewSummaryVec(
+		prometheus.SummaryOpts{
+			Name: "proxy_oauth_request_latency_sec",
+			Help: "A summary of the request latancy for requests against the openid provider",
+		},
+		[]string{"action"},
+	)
+	providerUnavailableMetric = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "proxy_oauth_provider_unavailable_total",
+			Help: "The requests which could not be served because the openid provider was unreachable, partitioned by action",
+		},
+		[]string{"action"},
+	)
+	panicsMetric = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "proxy_panics_total",
+			Help: "The panics recovered while handling requests, partitioned by listener",
+		},
+		[]string{"listener"},
+	)
+	latencyMetric = prometheus.NewSummary(
+		prometheus.SummaryOpts{
+			Name: "proxy_request_duration_sec",
+			Help: "A summary of the http request latency for proxy requests",
+		},
+	)
+	statusMetric = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "proxy_request_status_total",
+			Help: "The HTTP requests partitioned by status code",
+		},
+		[]string{"code", "method"},
+	)
+	resourceRequestsMetric = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "proxy_resource_requests_total",
+			Help: "The HTTP requests partitioned by resource matched, method and status code",
+		},
+		[]string{"resource", "method", "code"},
+	)
+	resourceLatencyMetric = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "proxy_resource_request_duration_sec",
+			Help:    "A histogram of the http request latency partitioned by resource matched and method",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"resource", "method"},
+	)
+	upstreamOpenConnectionsMetric = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "proxy_upstream_open_connections",
+			Help: "The number of connections currently open to the upstream, partitioned by connection pool",
+		},
+		[]string{"upstream"},
+	)
+	inflightRejectedMetric = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "proxy_inflight_rejected_total",
+			Help: "The requests rejected because the client has reached its limit of concurrent requests, partitioned by limit",
+		},
+		[]string{"limit"},
+	)
+	coalescedRequestsMetric = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "proxy_coalesced_requests_total",
+			Help: "The requests served by an upstream round trip shared with identical concurrent requests",
+		},
+	)
+	hedgedRequestsMetric = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "proxy_hedged_requests_total",
+			Help: "The idempotent requests to resources hedging their requests, partitioned by resource and attempt which responded first",
+		},
+		[]string{"resource", "winner"},
+	)
+	upstreamConnectionsMetric = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "proxy_upstream_connections_total",
+			Help: "The connections obtained to send requests upstream, partitioned by connection pool and whether an idle connection was reused",
+		},
+		[]string{"upstream", "reused"},
+	)
+	upstreamRetiredConnectionsMetric = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "proxy_upstream_retired_connections_total",
+			Help: "The keepalive connections closed past their maximum lifetime, partitioned by connection pool",
+		},
+		[]string{"upstream"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(certificateRotationMetric)
+	prometheus.MustRegister(latencyMetric)
+	prometheus.MustRegister(oauthLatencyMetric)
+	prometheus.MustRegister(oauthTokensMetric)
+	prometheus.MustRegister(providerUnavailableMetric)
+	prometheus.MustRegister(statusMetric)
+	prometheus.MustRegister(resourceRequestsMetric)
+	prometheus.MustRegister(resourceLatencyMetric)
+	prometheus.MustRegister(upstreamOpenConnectionsMetric)
+	prometheus.MustRegister(upstreamConnectionsMetric)
+	prometheus.MustRegister(upstreamRetiredConnectionsMetric)
+	prometheus.MustRegister(inflightRejectedMetric)
+	prometheus.MustRegister(panicsMetric)
+	prometheus.MustRegister(coalescedRequestsMetric)
+	prometheus.MustRegister(hedgedRequestsMetric)
+}
+
+func (r *oauthProxy) metricsHandler() http.Handler {
+	if !r.config.EnableMetrics {
+		return nil
+	}
+	return promhttp.Handler()
+}
+
+// proxyMetricsHandler forwards the request to the prometheus handler
+func (r *oauthProxy) proxyMetricsHandler(w http.ResponseWriter, req *http.Request) {
+	if !r.config.EnableMetrics {
+		return
+	}
+	if r.config.LocalhostMetrics {
+		// option to only give access to a localhost metrics collection agent
+		if !net.ParseIP(realIP(req)).IsLoopback() {
+			r.accessForbidden(w, req)
+			return
+		}
+	}
+	r.metricsHandler().ServeHTTP(w, req)
+}
@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsMiddleware(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.EnableMetrics = true
+	cfg.LocalhostMetrics = true
+	requests := []fakeRequest{
+		{
+			URI:                     cfg.WithOAuthURI(metricsURL),
+			ExpectedCode:            http.StatusOK,
+			ExpectedContentContains: "proxy_request_status_total",
+		},
+		{
+			URI: cfg.WithOAuthURI(metricsURL),
+			Headers: map[string]string{
+				"X-Forwarded-For": "10.0.0.1",
+			},
+			ExpectedCode: http.StatusForbidden,
+		},
+	}
+	newFakeProxy(cfg).RunTests(t, requests)
+}
+
+func TestResourceMetrics(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	denied := resourceRequestsMetric.WithLabelValues(fakeAdminRoleURL, http.MethodGet, "403")
+	permitted := resourceRequestsMetric.WithLabelValues(fakeTestWhitelistedURL, http.MethodGet, "200")
+	deniedBefore, permittedBefore := testutil.ToFloat64(denied), testutil.ToFloat64(permitted)
+
+	requests := []fakeRequest{
+		{
+			URI:          "/admin/users",
+			HasToken:     true,
+			ExpectedCode: http.StatusForbidden,
+		},
+		{
+			URI:           "/auth_all/white_listed/page",
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+		},
+		{
+			URI:          "/oauth/health",
+			ExpectedCode: http.StatusOK,
+		},
+	}
+	newFakeProxy(cfg).RunTests(t, requests)
+
+	assert.Equal(t, deniedBefore+1, testutil.ToFloat64(denied))
+	assert.Equal(t, permittedBefore+1, testutil.ToFloat64(permitted))
+	assert.NotZero(t, testutil.CollectAndCount(resourceLatencyMetric))
+}
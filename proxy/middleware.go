@@ -13,13 +13,14 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package main
+package proxy
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
 	"net/http"
-	"regexp"
+	"net/url"
 	"strings"
 	"time"
 
@@ -37,28 +38,41 @@ const (
 	normalizeFlags purell.NormalizationFlags = purell.FlagRemoveDotSegments | purell.FlagRemoveDuplicateSlashes
 )
 
+// normalizeURL removes the dot segments and duplicate slashes of the path of a request, as matched by the resources
+func normalizeURL(u *url.URL) {
+	purell.NormalizeURL(u, normalizeFlags)
+
+	// ensure we have a slash in the url
+	if !strings.HasPrefix(u.Path, "/") {
+		u.Path = "/" + u.Path
+	}
+}
+
 // entrypointMiddleware is custom filtering for incoming requests
 func entrypointMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		keep := req.URL.Path
-		purell.NormalizeURL(req.URL, normalizeFlags)
-
-		// ensure we have a slash in the url
-		if !strings.HasPrefix(req.URL.Path, "/") {
-			req.URL.Path = "/" + req.URL.Path
-		}
+		normalizeURL(req.URL)
 		req.RequestURI = req.URL.RawPath
 		req.URL.RawPath = req.URL.Path
 
 		// @step: create a context for the request
 		scope := &RequestScope{}
-		resp := middleware.NewWrapResponseWriter(w, 1)
+		resp, wrapped := w.(middleware.WrapResponseWriter)
+		if !wrapped {
+			resp = newResponseWriter(w, 1)
+		}
 		start := time.Now()
 		next.ServeHTTP(resp, req.WithContext(context.WithValue(req.Context(), contextScopeName, scope)))
 
 		// @metric record the time taken then response code
-		latencyMetric.Observe(time.Since(start).Seconds())
-		statusMetric.WithLabelValues(fmt.Sprintf("%d", resp.Status()), req.Method).Inc()
+		latency, code := time.Since(start).Seconds(), fmt.Sprintf("%d", resp.Status())
+		latencyMetric.Observe(latency)
+		statusMetric.WithLabelValues(code, req.Method).Inc()
+		if scope.resource != "" {
+			resourceLatencyMetric.WithLabelValues(scope.resource, req.Method).Observe(latency)
+			resourceRequestsMetric.WithLabelValues(scope.resource, req.Method, code).Inc()
+		}
 
 		// place back the original uri for proxying request
 		req.URL.Path = keep
@@ -67,6 +81,19 @@ func entrypointMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// resourceMiddleware records the resource matched by the request, for the metrics to be partitioned by resource
+func resourceMiddleware(resource *Resource) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if scope, ok := req.Context().Value(contextScopeName).(*RequestScope); ok {
+				scope.resource = resource.URL
+				scope.audiences = resource.Audiences
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
 // requestIDMiddleware is responsible for adding a request id if none found
 func (r *oauthProxy) requestIDMiddleware(header string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -105,6 +132,16 @@ func (r *oauthProxy) loggingMiddleware(next http.Handler) http.Handler {
 
 // authenticationMiddleware is responsible for verifying the access token
 func (r *oauthProxy) authenticationMiddleware() func(http.Handler) http.Handler {
+	return r.authenticate(false)
+}
+
+// optionalAuthenticationMiddleware verifies the access token if any, but forwards the request anonymously
+// whenever the identity is missing or has expired, instead of redirecting for authorization
+func (r *oauthProxy) optionalAuthenticationMiddleware() func(http.Handler) http.Handler {
+	return r.authenticate(true)
+}
+
+func (r *oauthProxy) authenticate(optional bool) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 			ctx, span, logger := r.traceSpan(req.Context(), "authentication middleware")
@@ -114,11 +151,29 @@ func (r *oauthProxy) authenticationMiddleware() func(http.Handler) http.Handler
 
 			clientIP := req.RemoteAddr
 
+			// unauthenticated requests are redirected for authorization, unless authentication is optional
+			unauthenticated := func(req *http.Request) {
+				if optional {
+					logger.Debug("no valid session found in request, forwarding anonymously", zap.String("client_ip", clientIP))
+					next.ServeHTTP(w, anonymousRequest(req))
+					return
+				}
+				next.ServeHTTP(w, req.WithContext(r.redirectToAuthorization(w, req)))
+			}
+
 			// grab the user identity from the request
 			user, err := r.getIdentity(req.WithContext(ctx))
+			if err == ErrSessionNotFound {
+				user, err = r.restoreSession(w, req.WithContext(ctx))
+			}
+			if err != nil && r.config.EnableTokenIntrospection && isProviderUnavailable(err) {
+				r.providerUnavailable(w, req.WithContext(ctx), "introspect", err)
+				r.revokeProxy(w, req.WithContext(ctx))
+				return
+			}
 			if err != nil {
 				logger.Warn("no session found in request, redirecting for authorization", zap.Error(err))
-				next.ServeHTTP(w, req.WithContext(r.redirectToAuthorization(w, req.WithContext(ctx))))
+				unauthenticated(req.WithContext(ctx))
 				return
 			}
 
@@ -136,17 +191,55 @@ func (r *oauthProxy) authenticationMiddleware() func(http.Handler) http.Handler
 						zap.String("username", user.name),
 						zap.String("expired_on", user.expiresAt.String()))
 
-					next.ServeHTTP(w, req.WithContext(r.redirectToAuthorization(w, req.WithContext(ctx))))
+					unauthenticated(req.WithContext(ctx))
 					return
 				}
 				next.ServeHTTP(w, req.WithContext(ctx))
 				return
 			}
 
-			if err := verifyToken(r.client, user.token); err != nil {
+			// step: reject the tokens issued before the not-before policy pushed by the provider
+			if r.isIssuedBeforeNotBefore(user) {
+				logger.Warn("access token was issued before the not-before policy, the session has been revoked",
+					zap.String("client_ip", clientIP),
+					zap.String("email", user.email))
+
+				r.clearAllCookies(req.WithContext(ctx), w)
+				unauthenticated(req.WithContext(ctx))
+				return
+			}
+
+			// step: reject the tokens of a subject whose sessions have been revoked from the admin api
+			if r.isSubjectRevoked(user) {
+				logger.Warn("access token was issued before the sessions of the subject were revoked",
+					zap.String("client_ip", clientIP),
+					zap.String("email", user.email))
+
+				r.clearAllCookies(req.WithContext(ctx), w)
+				unauthenticated(req.WithContext(ctx))
+				return
+			}
+
+			// step: reject the tokens of a session logged out by the provider
+			if r.config.EnableBackChannelLogout && r.isSessionRevoked(user) {
+				logger.Warn("access token was issued before the session was logged out by the provider",
+					zap.String("client_ip", clientIP),
+					zap.String("email", user.email))
+
+				r.clearAllCookies(req.WithContext(ctx), w)
+				unauthenticated(req.WithContext(ctx))
+				return
+			}
+
+			if err := r.verifyIdentity(user, scope.audiences); err != nil {
 				// step: if the error post verification is anything other than a token
 				// expired error we immediately throw an access forbidden - as there is
 				// something messed up in the token
+				if r.config.EnableTokenIntrospection && isProviderUnavailable(err) {
+					r.providerUnavailable(w, req.WithContext(ctx), "introspect", err)
+					r.revokeProxy(w, req.WithContext(ctx))
+					return
+				}
 				if err != ErrAccessTokenExpired {
 					logger.Warn("access token failed verification",
 						zap.String("client_ip", clientIP),
@@ -163,7 +256,7 @@ func (r *oauthProxy) authenticationMiddleware() func(http.Handler) http.Handler
 						zap.String("email", user.name),
 						zap.String("expired_on", user.expiresAt.String()))
 
-					next.ServeHTTP(w, req.WithContext(r.redirectToAuthorization(w, req)))
+					unauthenticated(req.WithContext(ctx))
 					return
 				}
 
@@ -173,11 +266,15 @@ func (r *oauthProxy) authenticationMiddleware() func(http.Handler) http.Handler
 
 				// step : refresh the token, update user and session
 				if err = r.refreshToken(w, req.WithContext(ctx), user); err != nil {
-					switch err {
-					case ErrEncode, ErrEncryption:
+					switch {
+					case err == ErrEncode || err == ErrEncryption:
 						r.errorResponse(w, req, err.Error(), http.StatusInternalServerError, err)
+					case isProviderUnavailable(err):
+						// the session is kept, for the refresh to be attempted again once the provider is back
+						r.providerUnavailable(w, req.WithContext(ctx), "renew", err)
+						r.revokeProxy(w, req.WithContext(ctx))
 					default:
-						next.ServeHTTP(w, req.WithContext(r.redirectToAuthorization(w, req.WithContext(ctx))))
+						unauthenticated(req.WithContext(ctx))
 					}
 					return
 				}
@@ -185,76 +282,72 @@ func (r *oauthProxy) authenticationMiddleware() func(http.Handler) http.Handler
 				ctx = context.WithValue(ctx, contextScopeName, scope)
 			}
 
+			// step: check periodically the session has not been terminated on the provider
+			if !r.isSessionValid(user) {
+				logger.Warn("the session has been terminated on the provider",
+					zap.String("client_ip", clientIP),
+					zap.String("email", user.email))
+
+				r.clearAllCookies(req.WithContext(ctx), w)
+				unauthenticated(req.WithContext(ctx))
+				return
+			}
+			r.resetAuthRedirects(w, req)
+
 			next.ServeHTTP(w, req.WithContext(ctx))
 		})
 	}
 }
 
-// checkClaim checks whether claim in userContext matches claimName, match. It can be String or Strings claim.
-func (r *oauthProxy) checkClaim(user *userContext, claimName string, match *regexp.Regexp, resourceURL string) bool {
+// anonymousRequest drops the identity from the request scope, as well as any identity header sent by the client
+func anonymousRequest(req *http.Request) *http.Request {
+	if scope, ok := req.Context().Value(contextScopeName).(*RequestScope); ok {
+		scope.Identity = nil
+	}
+	req.Header.Del(authorizationHeader)
+	for name := range req.Header {
+		if strings.HasPrefix(name, "X-Auth-") {
+			req.Header.Del(name)
+		}
+	}
+
+	return req
+}
+
+// checkClaim checks whether a claim of the user meets a requirement, any of its values for a list
+func (r *oauthProxy) checkClaim(user *userContext, match *claimMatcher, resourceURL string) bool {
 	errFields := []zapcore.Field{
-		zap.String("claim", claimName),
+		zap.String("claim", match.claim),
 		zap.String("access", "denied"),
 		zap.String("email", user.email),
 		zap.String("resource", resourceURL),
 	}
 
-	if _, found := user.claims[claimName]; !found {
+	values, found := match.values(user.claims)
+	if !found {
 		r.log.Warn("the token does not have the claim", errFields...)
 		return false
 	}
-
-	// Check string claim.
-	valueStr, foundStr, errStr := user.claims.StringClaim(claimName)
-	// We have found string claim, so let's check whether it matches.
-	if foundStr {
-		if match.MatchString(valueStr) {
+	for _, value := range values {
+		if match.matches(value) {
 			return true
 		}
-		r.log.Warn("claim requirement does not match claim in token", append(errFields,
-			zap.String("issued", valueStr),
-			zap.String("required", match.String()),
-		)...)
-
-		return false
-	}
-
-	// Check strings claim.
-	valueStrs, foundStrs, errStrs := user.claims.StringsClaim(claimName)
-	// We have found strings claim, so let's check whether it matches.
-	if foundStrs {
-		for _, value := range valueStrs {
-			if match.MatchString(value) {
-				return true
-			}
-		}
-		r.log.Warn("claim requirement does not match any claim in token", append(errFields,
-			zap.String("issued", fmt.Sprintf("%v", valueStrs)),
-			zap.String("required", match.String()),
-		)...)
-
-		return false
-	}
-
-	// If this fails, the claim is probably float or int.
-	if errStr != nil && errStrs != nil {
-		r.log.Warn("unable to extract the claim from token (tried string and strings)", append(errFields,
-			zap.Error(errStr),
-			zap.Error(errStrs),
-		)...)
-		return false
 	}
+	r.log.Warn("claim requirement does not match the claim in token", append(errFields,
+		zap.String("issued", fmt.Sprintf("%v", values)),
+		zap.String("required", match.requirement),
+	)...)
 
-	r.log.Warn("unexpected error", errFields...)
 	return false
 }
 
 // admissionMiddleware is responsible for checking the access token against the protected resource
 func (r *oauthProxy) admissionMiddleware(resource *Resource) func(http.Handler) http.Handler {
-	claimMatches := make(map[string]*regexp.Regexp)
-	for k, v := range r.config.MatchClaims {
-		claimMatches[k] = regexp.MustCompile(v)
-	}
+	// the claim matchers are validated with the configuration
+	claimMatches, _ := newClaimMatchers(r.config.MatchClaims)
+	expression, _ := compileAccessExpression(resource.AccessExpression)
+	policy := r.newOPADecider(resource)
+	permissions := r.newUMADecider(resource)
 
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
@@ -270,6 +363,14 @@ func (r *oauthProxy) admissionMiddleware(resource *Resource) func(http.Handler)
 				return
 			}
 			user := scope.Identity
+			if user == nil {
+				// anonymous access to a resource with optional authentication
+				if resource.GraphQL {
+					ctx, _ = r.graphQLAdmissionContext(w, req.WithContext(ctx), resource, nil)
+				}
+				next.ServeHTTP(w, req.WithContext(ctx))
+				return
+			}
 
 			// @step: we need to check the roles
 			if !hasAccess(resource.Roles, user.roles, !resource.RequireAnyRole, false) {
@@ -295,14 +396,110 @@ func (r *oauthProxy) admissionMiddleware(resource *Resource) func(http.Handler)
 				return
 			}
 
+			// @step: the token must be meant for one of the audiences of the resource
+			if !hasAccess(resource.Audiences, user.audiences, false, false) {
+				logger.Warn("access denied, invalid audience",
+					zap.String("access", "denied"),
+					zap.String("email", user.email),
+					zap.String("resource", resource.URL),
+					zap.String("audiences", strings.Join(resource.Audiences, ",")),
+					zap.String("token_audiences", strings.Join(user.audiences, ",")))
+
+				next.ServeHTTP(w, req.WithContext(r.accessForbidden(w, req.WithContext(ctx))))
+				return
+			}
+
+			// @step: some methods may require roles or groups of their own
+			for _, rule := range resource.MethodRequirements {
+				if rule.matches(req.Method) && !rule.admits(user) {
+					logger.Warn("access denied, invalid roles or groups for the method",
+						zap.String("access", "denied"),
+						zap.String("email", user.email),
+						zap.String("resource", resource.URL),
+						zap.String("method", req.Method),
+						zap.String("roles", strings.Join(rule.Roles, ",")),
+						zap.String("groups", strings.Join(rule.Groups, ",")))
+
+					next.ServeHTTP(w, req.WithContext(r.accessForbidden(w, req.WithContext(ctx))))
+					return
+				}
+			}
+
 			// step: if we have any claim matching, lets validate the tokens has the claims
-			for claimName, match := range claimMatches {
-				if !r.checkClaim(user, claimName, match, resource.URL) {
+			for _, match := range claimMatches {
+				if !r.checkClaim(user, match, resource.URL) {
+					next.ServeHTTP(w, req.WithContext(r.accessForbidden(w, req.WithContext(ctx))))
+					return
+				}
+			}
+
+			// @step: the access expression of the resource must hold
+			if expression != nil {
+				allowed, err := expression.allows(req, user, r.config.ScriptTimeout)
+				if err != nil {
+					r.errorResponse(w, req.WithContext(ctx), "the access expression failed", http.StatusInternalServerError, err)
+					next.ServeHTTP(w, req.WithContext(r.revokeProxy(w, req.WithContext(ctx))))
+					return
+				}
+				if !allowed {
+					logger.Warn("access denied by the access expression",
+						zap.String("access", "denied"),
+						zap.String("email", user.email),
+						zap.String("resource", resource.URL))
+
+					next.ServeHTTP(w, req.WithContext(r.accessForbidden(w, req.WithContext(ctx))))
+					return
+				}
+			}
+
+			// @step: the open policy agent may have the final say on the requests
+			if policy != nil {
+				allowed, err := policy.allows(req, resource, user)
+				if err != nil {
+					r.errorResponse(w, req.WithContext(ctx), "unable to obtain the policy decision", http.StatusServiceUnavailable, err)
+					next.ServeHTTP(w, req.WithContext(r.revokeProxy(w, req.WithContext(ctx))))
+					return
+				}
+				if !allowed {
+					logger.Warn("access denied by policy",
+						zap.String("access", "denied"),
+						zap.String("email", user.email),
+						zap.String("resource", resource.URL))
+
+					next.ServeHTTP(w, req.WithContext(r.accessForbidden(w, req.WithContext(ctx))))
+					return
+				}
+			}
+
+			// @step: the keycloak authorization services may have the final say as well
+			if permissions != nil {
+				allowed, err := permissions.allows(req, user)
+				if err != nil {
+					r.errorResponse(w, req.WithContext(ctx), "unable to obtain the authorization decision", http.StatusServiceUnavailable, err)
+					next.ServeHTTP(w, req.WithContext(r.revokeProxy(w, req.WithContext(ctx))))
+					return
+				}
+				if !allowed {
+					logger.Warn("access denied by the authorization services",
+						zap.String("access", "denied"),
+						zap.String("email", user.email),
+						zap.String("resource", resource.URL),
+						zap.String("permission", permissions.permission(req)))
+
 					next.ServeHTTP(w, req.WithContext(r.accessForbidden(w, req.WithContext(ctx))))
 					return
 				}
 			}
 
+			// @step: the operations sent to a GraphQL endpoint may require roles of their own
+			if resource.GraphQL {
+				var permitted bool
+				if ctx, permitted = r.graphQLAdmissionContext(w, req.WithContext(ctx), resource, user); !permitted {
+					next.ServeHTTP(w, req.WithContext(ctx))
+					return
+				}
+			}
+
 			logger.Debug("access permitted to resource",
 				zap.String("access", "permitted"),
 				zap.String("email", user.email),
@@ -328,42 +525,33 @@ func (r *oauthProxy) responseHeaderMiddleware(headers map[string]string) func(ht
 	}
 }
 
-// identityHeadersMiddleware is responsible for adding the authentication headers to upstream
-func (r *oauthProxy) identityHeadersMiddleware(custom []string) func(http.Handler) http.Handler {
-	// config-driven request header setters
-	setters := make([]func(*http.Request, *userContext), 0, 20)
+// identityHeaders returns the setter of the headers carrying the identity of the users, as configured
+func (r *oauthProxy) identityHeaders(custom []string) func(http.Header, *userContext) {
+	// config-driven header setters
+	setters := make([]func(http.Header, *userContext), 0, 20)
 
 	if r.config.EnableClaimsHeaders {
-		setters = append(setters, func(req *http.Request, user *userContext) {
-			req.Header.Set("X-Auth-Audience", strings.Join(user.audiences, ","))
-			req.Header.Set("X-Auth-Email", user.email)
-			req.Header.Set("X-Auth-ExpiresIn", user.expiresAt.String())
-			req.Header.Set("X-Auth-Groups", strings.Join(user.groups, ","))
-			req.Header.Set("X-Auth-Roles", strings.Join(user.roles, ","))
-			req.Header.Set("X-Auth-Subject", user.id)
-			req.Header.Set("X-Auth-Userid", user.name)
-			req.Header.Set("X-Auth-Username", user.name)
+		setters = append(setters, func(h http.Header, user *userContext) {
+			h.Set("X-Auth-Audience", strings.Join(user.audiences, ","))
+			h.Set("X-Auth-Email", user.email)
+			h.Set("X-Auth-ExpiresIn", user.expiresAt.String())
+			h.Set("X-Auth-Groups", strings.Join(user.groups, ","))
+			h.Set("X-Auth-Roles", strings.Join(user.roles, ","))
+			h.Set("X-Auth-Subject", user.id)
+			h.Set("X-Auth-Userid", user.name)
+			h.Set("X-Auth-Username", user.name)
 		})
 	}
 
 	if r.config.EnableTokenHeader {
-		setters = append(setters, func(req *http.Request, user *userContext) {
-			req.Header.Set("X-Auth-Token", user.token.Encode())
+		setters = append(setters, func(h http.Header, user *userContext) {
+			h.Set("X-Auth-Token", user.encodedToken())
 		})
 	}
 
 	if r.config.EnableAuthorizationHeader {
-		setters = append(setters, func(req *http.Request, user *userContext) {
-			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", user.token.Encode()))
-		})
-	}
-
-	// are we filtering out the cookies to upstream ?
-	// NOTE: cookies are actually just redacted
-	if !r.config.EnableAuthorizationCookies {
-		cookieFilter := []string{r.config.CookieAccessName, r.config.CookieRefreshName}
-		setters = append(setters, func(req *http.Request, _ *userContext) {
-			_ = filterCookies(req, cookieFilter)
+		setters = append(setters, func(h http.Header, user *userContext) {
+			h.Set("Authorization", fmt.Sprintf("Bearer %s", user.encodedToken()))
 		})
 	}
 
@@ -372,34 +560,100 @@ func (r *oauthProxy) identityHeadersMiddleware(custom []string) func(http.Handle
 		for _, x := range custom {
 			customClaims[x] = fmt.Sprintf("X-Auth-%s", toHeader(x))
 		}
-		setters = append(setters, func(req *http.Request, user *userContext) {
+		setters = append(setters, func(h http.Header, user *userContext) {
 			// inject any custom claims
 			for claim, header := range customClaims {
 				if claim, found := user.claims[claim]; found {
-					req.Header.Set(header, fmt.Sprintf("%v", claim))
+					h.Set(header, fmt.Sprintf("%v", claim))
 				}
 			}
 		})
 	}
 
-	setClaimsHeaders := func(req *http.Request, user *userContext) {
+	return func(h http.Header, user *userContext) {
 		for _, setter := range setters {
-			setter(req, user)
+			setter(h, user)
 		}
 	}
+}
+
+// identityHeadersMiddleware is responsible for adding the authentication headers to upstream
+func (r *oauthProxy) identityHeadersMiddleware(custom []string) func(http.Handler) http.Handler {
+	setClaimsHeaders := r.identityHeaders(custom)
+
+	// are we filtering out the cookies to upstream ?
+	// NOTE: cookies are actually just redacted
+	var cookieFilter []string
+	if !r.config.EnableAuthorizationCookies {
+		cookieFilter = []string{r.config.CookieAccessName, r.config.CookieRefreshName}
+	}
 
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 			scope := req.Context().Value(contextScopeName).(*RequestScope)
 			if scope.Identity != nil {
 				user := scope.Identity
-				setClaimsHeaders(req, user)
+				setClaimsHeaders(req.Header, user)
+				if cookieFilter != nil {
+					_ = filterCookies(req, cookieFilter)
+				}
+			}
+			if r.config.UpstreamTokenCookie != "" {
+				if err := r.upstreamTokenCookie(req, scope.Identity); err != nil {
+					r.errorResponse(w, req, "unable to encrypt the access token of the upstream cookie", http.StatusInternalServerError, err)
+					return
+				}
 			}
 			next.ServeHTTP(w, req)
 		})
 	}
 }
 
+// upstreamTokenCookie passes the access token of the user to the upstream in the upstream token cookie, encrypted
+// with the key shared with the upstream when set. The cookie sent by the client is always removed.
+func (r *oauthProxy) upstreamTokenCookie(req *http.Request, user *userContext) error {
+	if user == nil {
+		setRequestCookie(req, r.config.UpstreamTokenCookie, "")
+		return nil
+	}
+	value := user.encodedToken()
+	if r.config.UpstreamTokenCookieKey != "" {
+		cipherText, err := encryptDataBlock([]byte(value), []byte(r.config.UpstreamTokenCookieKey))
+		if err != nil {
+			return err
+		}
+		value = base64.RawStdEncoding.EncodeToString(cipherText)
+	}
+	setRequestCookie(req, r.config.UpstreamTokenCookie, value)
+
+	return nil
+}
+
+// methodOverrideMiddleware routes the POST requests with the method of the override header, which is removed.
+// It is applied before routing, so the rules of the resources apply to the overridden method.
+func (r *oauthProxy) methodOverrideMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		method := req.Header.Get(headerXMethodOverride)
+		if method == "" {
+			next.ServeHTTP(w, req)
+			return
+		}
+		req.Header.Del(headerXMethodOverride)
+		if req.Method != http.MethodPost {
+			next.ServeHTTP(w, req)
+			return
+		}
+		method = strings.ToUpper(method)
+		if !isValidResourceMethod(method) {
+			r.errorResponse(w, req, "invalid method override", http.StatusBadRequest, nil)
+			return
+		}
+		req.Method = method
+
+		next.ServeHTTP(w, req)
+	})
+}
+
 // securityMiddleware performs numerous security checks on the request
 func (r *oauthProxy) securityMiddleware(next http.Handler) http.Handler {
 	r.log.Info("enabling the security filter middleware",
@@ -456,6 +710,16 @@ func csrfSameSiteValue(value string) gcsrf.SameSiteMode {
 }
 
 func (r *oauthProxy) csrfConfigMiddleware() func(http.Handler) http.Handler {
+	if r.config.EnableCSRF && r.isDoubleSubmitCSRF() {
+		// CSRF protection with a stateless double-submit cookie, signed with HMAC-SHA256
+		r.log.Info("enabling CSRF protection with double-submit cookies")
+		return r.csrfDoubleSubmitMiddleware()
+	}
+	if r.config.EnableCSRF && r.isStoredCSRF() {
+		// CSRF protection with the secrets kept in the shared store, keyed by the user session
+		r.log.Info("enabling CSRF protection with secrets in the store")
+		return r.csrfStoreMiddleware()
+	}
 	if r.config.EnableCSRF {
 		// CSRF protection establishes a session scoped CSRF state with an encrypted cookie.
 		// Encryption algorithm is AES-256
@@ -467,7 +731,7 @@ func (r *oauthProxy) csrfConfigMiddleware() func(http.Handler) http.Handler {
 			gcsrf.SameSite(csrfSameSiteValue(r.config.SameSiteCookie)),
 			gcsrf.HttpOnly(r.config.HTTPOnlyCookie),
 			gcsrf.Secure(r.config.SecureCookie),
-			gcsrf.Path("/"),
+			gcsrf.Path(r.config.cookiePath()),
 			gcsrf.ErrorHandler(http.HandlerFunc(r.csrfErrorHandler)))
 
 	}
@@ -483,7 +747,7 @@ func (r *oauthProxy) csrfSkipMiddleware() func(next http.Handler) http.Handler {
 				case "GET", "HEAD", "OPTIONS", "TRACE":
 					next.ServeHTTP(w, req)
 				default:
-					next.ServeHTTP(w, gcsrf.UnsafeSkipCheck(req))
+					next.ServeHTTP(w, r.csrfSkipCheck(req))
 				}
 			})
 		}
@@ -502,7 +766,7 @@ func (r *oauthProxy) csrfSkipResourceMiddleware(resource *Resource) func(http.Ha
 			// CSRF check managed by proxy check is disabled on this resource
 			return func(next http.Handler) http.Handler {
 				return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-					next.ServeHTTP(w, gcsrf.UnsafeSkipCheck(req))
+					next.ServeHTTP(w, r.csrfSkipCheck(req))
 				})
 			}
 		}
@@ -520,7 +784,7 @@ func (r *oauthProxy) csrfSkipResourceMiddleware(resource *Resource) func(http.Ha
 
 				// request credentials come as a bearer token: skip CSRF check
 				if scope.Identity.isBearer() {
-					next.ServeHTTP(w, gcsrf.UnsafeSkipCheck(req))
+					next.ServeHTTP(w, r.csrfSkipCheck(req))
 					return
 				}
 
@@ -559,7 +823,7 @@ func (r *oauthProxy) csrfHeaderMiddleware() func(next http.Handler) http.Handler
 					return
 				}
 
-				csrfToken := gcsrf.Token(req)
+				csrfToken := r.csrfToken(req)
 				if csrfToken == "" {
 					next.ServeHTTP(w, req)
 					return
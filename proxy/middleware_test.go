@@ -13,9 +13,11 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package main
+package proxy
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -30,12 +32,14 @@ import (
 	"github.com/rs/cors"
 	uuid "github.com/satori/go.uuid"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 	resty "gopkg.in/resty.v1"
 )
 
 type fakeRequest struct {
 	BasicAuth               bool
+	Body                    string
 	Cookies                 []*http.Cookie
 	Expires                 time.Duration
 	FormValues              map[string]string
@@ -179,6 +183,9 @@ func (f *fakeProxy) RunTests(t *testing.T, requests []fakeRequest) {
 		if len(c.Headers) > 0 {
 			request.SetHeaders(c.Headers)
 		}
+		if c.Body != "" {
+			request.SetBody(c.Body)
+		}
 		if c.FormValues != nil {
 			request.SetFormData(c.FormValues)
 		}
@@ -228,7 +235,7 @@ func (f *fakeProxy) RunTests(t *testing.T, requests []fakeRequest) {
 			l, _ := url.Parse(resp.Header().Get("Location"))
 			assert.True(t, strings.Contains(l.String(), c.ExpectedLocation), "expected location to contain %s", l.String())
 			if l.Query().Get("state") != "" {
-				state, err := uuid.FromString(l.Query().Get("state"))
+				state, err := uuid.FromString(strings.TrimPrefix(l.Query().Get("state"), silentStatePrefix))
 				if err != nil {
 					assert.Fail(t, "expected state parameter with valid UUID, got: %s with error %s", state.String(), err)
 				}
@@ -280,14 +287,14 @@ func (f *fakeProxy) RunTests(t *testing.T, requests []fakeRequest) {
 					assert.Equal(t, cookie.Value, v, "case %d, expected cookie value: %s, got: %s", i, v, cookie.Value)
 				}
 			}
-			for k, v := range c.ExpectedCookiesValidator {
-				cookie := findCookie(k, resp.Cookies())
-				if !assert.NotNil(t, cookie, "case %d, expected cookie %s not found", i, k) {
-					continue
-				}
-				if v != nil {
-					assert.True(t, v(cookie.Value), "case %d, invalid cookie value: %s", i, cookie.Value)
-				}
+		}
+		for k, v := range c.ExpectedCookiesValidator {
+			cookie := findCookie(k, resp.Cookies())
+			if !assert.NotNil(t, cookie, "case %d, expected cookie %s not found", i, k) {
+				continue
+			}
+			if v != nil {
+				assert.True(t, v(cookie.Value), "case %d, invalid cookie value: %s", i, cookie.Value)
 			}
 		}
 		if c.OnResponse != nil {
@@ -660,6 +667,58 @@ func TestWhiteListedRequests(t *testing.T) {
 	newFakeProxy(cfg).RunTests(t, requests)
 }
 
+func TestOptionalAuthRequests(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.Resources = []*Resource{
+		{
+			URL:     "/*",
+			Methods: allHTTPMethods,
+		},
+		{
+			URL:          "/public*",
+			OptionalAuth: true,
+			Methods:      allHTTPMethods,
+		},
+	}
+	requests := []fakeRequest{
+		{ // anonymous requests are forwarded
+			URI:                    "/public",
+			Headers:                map[string]string{"X-Auth-Email": "forged@example.com"},
+			ExpectedCode:           http.StatusOK,
+			ExpectedProxy:          true,
+			ExpectedNoProxyHeaders: []string{"X-Auth-Email", "X-Auth-Token"},
+		},
+		{ // expired identities are dropped
+			URI:                    "/public/test",
+			HasToken:               true,
+			Expires:                -48 * time.Hour,
+			ExpectedCode:           http.StatusOK,
+			ExpectedProxy:          true,
+			ExpectedNoProxyHeaders: []string{"X-Auth-Email", "X-Auth-Token", "Authorization"},
+		},
+		{ // valid identities are forwarded
+			URI:                  "/public/test",
+			HasToken:             true,
+			ExpectedCode:         http.StatusOK,
+			ExpectedProxy:        true,
+			ExpectedProxyHeaders: map[string]string{"X-Auth-Email": "gambol99@gmail.com"},
+		},
+		{ // invalid tokens are still rejected
+			URI:          "/public/test",
+			HasToken:     true,
+			NotSigned:    true,
+			ExpectedCode: http.StatusForbidden,
+		},
+		{
+			URI:          "/private",
+			HasToken:     true,
+			Expires:      -48 * time.Hour,
+			ExpectedCode: http.StatusUnauthorized,
+		},
+	}
+	newFakeProxy(cfg).RunTests(t, requests)
+}
+
 func TestBlackAndWhiteListedRequests(t *testing.T) {
 	cfg := newFakeKeycloakConfig()
 	cfg.Resources = []*Resource{
@@ -1116,6 +1175,38 @@ func TestCrossSiteHandler(t *testing.T) {
 				},
 			},
 		},
+		{
+			Cors: cors.Options{
+				AllowedOrigins:   []string{"https://*.example.com"},
+				AllowCredentials: true,
+			},
+			Request: fakeRequest{
+				URI: fakeAuthAllURL,
+				Headers: map[string]string{
+					"Origin": "https://app.example.com",
+				},
+				ExpectedHeaders: map[string]string{
+					"Access-Control-Allow-Origin":      "https://app.example.com",
+					"Access-Control-Allow-Credentials": "true",
+					"Vary":                             "Origin",
+				},
+			},
+		},
+		{
+			Cors: cors.Options{
+				AllowedOrigins: []string{"https://*.example.com"},
+			},
+			Request: fakeRequest{
+				URI: fakeAuthAllURL,
+				Headers: map[string]string{
+					"Origin": "https://example.com",
+				},
+				ExpectedHeaders: map[string]string{
+					"Access-Control-Allow-Origin": "",
+					"Vary":                        "Origin",
+				},
+			},
+		},
 	}
 
 	for _, c := range cases {
@@ -1130,6 +1221,75 @@ func TestCrossSiteHandler(t *testing.T) {
 		newFakeProxy(cfg).RunTests(t, []fakeRequest{c.Request})
 	}
 }
+func TestCrossSiteHandlerResourceOverrides(t *testing.T) {
+	const (
+		console = "https://console.example.com"
+		widget  = "https://widget.example.com"
+	)
+	cfg := newFakeKeycloakConfig()
+	cfg.CorsOrigins = []string{console}
+	cfg.CorsMethods = []string{http.MethodGet}
+	for _, x := range cfg.Resources {
+		if x.URL == fakeTestWhitelistedURL {
+			x.CorsOrigins = []string{"*"}
+			x.CorsMethods = []string{http.MethodGet, http.MethodPost}
+		}
+	}
+	preflight := func(origin, method string) map[string]string {
+		return map[string]string{
+			"Origin":                        origin,
+			"Access-Control-Request-Method": method,
+		}
+	}
+
+	requests := []fakeRequest{
+		{
+			URI:     "/auth_all/white_listed/widget",
+			Method:  http.MethodOptions,
+			Headers: preflight(widget, http.MethodPost),
+			ExpectedHeaders: map[string]string{
+				"Access-Control-Allow-Origin":  "*",
+				"Access-Control-Allow-Methods": http.MethodPost,
+			},
+		},
+		{
+			URI:           "/auth_all/white_listed/widget",
+			Headers:       map[string]string{"Origin": widget},
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+			ExpectedHeaders: map[string]string{
+				"Access-Control-Allow-Origin": "*",
+			},
+		},
+		{
+			URI:     "/admin",
+			Method:  http.MethodOptions,
+			Headers: preflight(widget, http.MethodGet),
+			ExpectedHeaders: map[string]string{
+				"Access-Control-Allow-Origin": "",
+			},
+		},
+		{
+			URI:     "/admin",
+			Method:  http.MethodOptions,
+			Headers: preflight(console, http.MethodGet),
+			ExpectedHeaders: map[string]string{
+				"Access-Control-Allow-Origin":  console,
+				"Access-Control-Allow-Methods": http.MethodGet,
+			},
+		},
+		{
+			URI:     "/admin",
+			Method:  http.MethodOptions,
+			Headers: preflight(console, http.MethodPost),
+			ExpectedHeaders: map[string]string{
+				"Access-Control-Allow-Methods": "",
+			},
+		},
+	}
+	newFakeProxy(cfg).RunTests(t, requests)
+}
+
 func TestCheckRefreshTokens(t *testing.T) {
 	cfg := newFakeKeycloakConfig()
 	cfg.EnableRefreshTokens = true
@@ -1647,6 +1807,32 @@ func TestRolesAdmissionHandlerClaims(t *testing.T) {
 				ExpectedCode:  http.StatusOK,
 			},
 		},
+		{
+			Matches: map[string]string{
+				"acr":                ">=2",
+				"realm_access.roles": "^admin$",
+			},
+			Request: fakeRequest{
+				URI:      testAdminURI,
+				HasToken: true,
+				TokenClaims: jose.Claims{
+					"acr":          2,
+					"realm_access": map[string]interface{}{"roles": []string{"user", "admin"}},
+				},
+				ExpectedProxy: true,
+				ExpectedCode:  http.StatusOK,
+			},
+		},
+		{
+			Matches: map[string]string{"acr": ">=2"},
+			Request: fakeRequest{
+				URI:           testAdminURI,
+				HasToken:      true,
+				TokenClaims:   jose.Claims{"acr": "1"},
+				ExpectedProxy: false,
+				ExpectedCode:  http.StatusForbidden,
+			},
+		},
 	}
 	for _, c := range requests {
 		cfg := newFakeKeycloakConfig()
@@ -1655,3 +1841,190 @@ func TestRolesAdmissionHandlerClaims(t *testing.T) {
 		newFakeProxy(cfg).RunTests(t, []fakeRequest{c.Request})
 	}
 }
+
+func TestExtensionMethods(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.EnableMethodOverride = true
+	cfg.Resources = []*Resource{
+		{
+			URL:     "/dav/*",
+			Methods: []string{"PROPFIND", "MKCOL", http.MethodPost},
+			Roles:   []string{fakeAdminRole},
+		},
+		{
+			URL:         "/public/*",
+			Methods:     []string{"REPORT"},
+			WhiteListed: true,
+		},
+	}
+	requests := []fakeRequest{
+		{
+			URI:           "/dav/calendar",
+			Method:        "PROPFIND",
+			HasToken:      true,
+			Roles:         []string{fakeAdminRole},
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+		},
+		{
+			URI:          "/dav/calendar",
+			Method:       "PROPFIND",
+			HasToken:     true,
+			ExpectedCode: http.StatusForbidden,
+		},
+		{
+			URI:          "/dav/calendar",
+			Method:       "PROPFIND",
+			ExpectedCode: http.StatusUnauthorized,
+		},
+		{
+			// methods which are not declared on any resource are not proxied
+			URI:      "/dav/calendar",
+			Method:   "LOCK",
+			HasToken: true,
+			Roles:    []string{fakeAdminRole},
+		},
+		{
+			URI:           "/public/calendar",
+			Method:        "REPORT",
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+		},
+		{
+			// the resource rules apply to the overridden method
+			URI:           "/public/calendar",
+			Method:        http.MethodPost,
+			Headers:       map[string]string{headerXMethodOverride: "report"},
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+		},
+		{
+			URI:          "/dav/calendar",
+			Method:       http.MethodPost,
+			Headers:      map[string]string{headerXMethodOverride: "MKCOL"},
+			ExpectedCode: http.StatusUnauthorized,
+		},
+		{
+			URI:          "/public/calendar",
+			Method:       http.MethodPost,
+			Headers:      map[string]string{headerXMethodOverride: "NOT A METHOD"},
+			ExpectedCode: http.StatusBadRequest,
+		},
+	}
+	newFakeProxy(cfg).RunTests(t, requests)
+}
+
+func TestUpstreamTokenCookie(t *testing.T) {
+	for _, key := range []string{"", testKey} {
+		cfg := newFakeKeycloakConfig()
+		cfg.UpstreamTokenCookie = "upstream-token"
+		cfg.UpstreamTokenCookieKey = key
+		cfg.Resources = []*Resource{
+			{URL: "/auth_all/*", Methods: allHTTPMethods},
+			{URL: "/public/*", Methods: allHTTPMethods, OptionalAuth: true},
+		}
+		p := newFakeProxy(cfg)
+		signed, err := p.idp.signToken(newTestToken(p.idp.getLocation()).claims)
+		require.NoError(t, err)
+
+		get := func(uri, token string) string {
+			req, err := http.NewRequest(http.MethodGet, p.getServiceURL()+uri, nil)
+			require.NoError(t, err)
+			req.AddCookie(&http.Cookie{Name: "upstream-token", Value: "forged"})
+			req.AddCookie(&http.Cookie{Name: "other", Value: "kept"})
+			if token != "" {
+				req.Header.Set(authorizationHeader, authorizationType+" "+token)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			var upstream fakeUpstreamResponse
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&upstream))
+			return upstream.Headers.Get("Cookie")
+		}
+
+		cookies := get("/auth_all/test", signed.Encode())
+		require.True(t, strings.HasPrefix(cookies, "other=kept; upstream-token="), cookies)
+		value := strings.TrimPrefix(cookies, "other=kept; upstream-token=")
+		if key != "" {
+			cipherText, err := base64.RawStdEncoding.DecodeString(value)
+			require.NoError(t, err)
+			plain, err := decryptDataBlock(cipherText, []byte(key))
+			require.NoError(t, err)
+			value = string(plain)
+		}
+		assert.Equal(t, signed.Encode(), value)
+
+		// the cookie of the client is removed from the anonymous requests
+		assert.Equal(t, "other=kept", get("/public/test", ""))
+
+		p.idp.Close()
+		p.proxy.server.Close()
+	}
+
+	cfg := newFakeKeycloakConfig()
+	cfg.UpstreamTokenCookie = cfg.CookieAccessName
+	assert.Error(t, cfg.isUpstreamTokenCookieValid())
+	cfg.UpstreamTokenCookie = "bad;name"
+	assert.Error(t, cfg.isUpstreamTokenCookieValid())
+	cfg.UpstreamTokenCookie = "upstream-token"
+	cfg.UpstreamTokenCookieKey = "short"
+	assert.Error(t, cfg.isUpstreamTokenCookieValid())
+}
+
+func TestAudiencesAdmission(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.Resources = []*Resource{
+		{URL: "/billing/*", Methods: allHTTPMethods, Audiences: []string{"billing", "accounting"}},
+		{URL: "/shipping/*", Methods: allHTTPMethods, Audiences: []string{"shipping"}},
+		{URL: "/orders/*", Methods: allHTTPMethods},
+	}
+	requests := []fakeRequest{
+		{
+			URI:           "/billing/invoices",
+			HasToken:      true,
+			TokenClaims:   map[string]interface{}{"aud": []string{fakeClientID, "billing"}},
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+		},
+		{
+			URI:          "/shipping/orders",
+			HasToken:     true,
+			TokenClaims:  map[string]interface{}{"aud": []string{fakeClientID, "billing"}},
+			ExpectedCode: http.StatusForbidden,
+		},
+		{
+			URI:          "/billing/invoices",
+			HasToken:     true,
+			ExpectedCode: http.StatusForbidden,
+		},
+		// the tokens issued for the audiences of a resource only are accepted there, not elsewhere
+		{
+			URI:           "/billing/invoices",
+			HasToken:      true,
+			TokenClaims:   map[string]interface{}{"aud": "accounting"},
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+		},
+		{
+			URI:          "/shipping/orders",
+			HasToken:     true,
+			TokenClaims:  map[string]interface{}{"aud": "accounting"},
+			ExpectedCode: http.StatusForbidden,
+		},
+		{
+			URI:          "/orders/1",
+			HasToken:     true,
+			TokenClaims:  map[string]interface{}{"aud": "accounting"},
+			ExpectedCode: http.StatusForbidden,
+		},
+		{
+			URI:           "/orders/1",
+			HasToken:      true,
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+		},
+	}
+	newFakeProxy(cfg).RunTests(t, requests)
+}
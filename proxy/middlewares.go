@@ -0,0 +1,232 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// MiddlewareConfig is the subset of the configuration used by the exported middlewares. The fields keep
+// the meaning of the equivalent proxy configuration options.
+type MiddlewareConfig struct {
+	// DiscoveryURL is the url for the openid configuration
+	DiscoveryURL string
+	// ClientID is the client id
+	ClientID string
+	// ClientSecret is the secret for AS
+	ClientSecret string
+	// OpenIDProviderTimeout is the timeout to retrieve the provider configuration on start up
+	OpenIDProviderTimeout time.Duration
+	// OpenIDProviderCA is the path to a CA certificate to verify the provider tls certificate
+	OpenIDProviderCA string
+	// OpenIDProviderPins are the hashes of the public keys of the provider certificates, or of their authorities
+	OpenIDProviderPins []string
+	// SkipOpenIDProviderTLSVerify skips the verification of the provider tls certificate
+	SkipOpenIDProviderTLSVerify bool
+	// CookieAccessName is the name of the cookie holding the access token, when not sent as a bearer token
+	CookieAccessName string
+	// EnableEncryptedToken indicates the access token cookie is encrypted
+	EnableEncryptedToken bool
+	// EncryptionKey is the key used to decrypt the access token cookie and sign the CSRF tokens
+	EncryptionKey string
+	// MatchClaims is a series of claims the access token must match
+	MatchClaims map[string]string
+	// Provider selects the conventions of the identity provider (default: keycloak)
+	Provider string
+	// RoleClaims, ClientRolesClaim and GroupClaims locate the roles and groups in the claims (default: keycloak claims)
+	RoleClaims       []string
+	ClientRolesClaim string
+	GroupClaims      []string
+	// EnableClaimsHeaders sets the X-Auth headers with the claims of the access token
+	EnableClaimsHeaders bool
+	// AddClaims is a series of additional claims set as X-Auth headers
+	AddClaims []string
+	// EnableTokenHeader sets the X-Auth-Token header with the access token
+	EnableTokenHeader bool
+	// EnableAuthorizationHeader sets the Authorization header with the access token
+	EnableAuthorizationHeader bool
+	// EnableCSRF enables the CSRF middleware
+	EnableCSRF bool
+	// CSRFCookieName is the name of the CSRF cookie
+	CSRFCookieName string
+	// CSRFHeader is the name of the header carrying the CSRF token
+	CSRFHeader string
+	// CSRFTokenDuration is the lifetime of the CSRF tokens
+	CSRFTokenDuration time.Duration
+	// DisableAllLogging disables all logging to stdout
+	DisableAllLogging bool
+	// EnableJSONLogging logs in json format
+	EnableJSONLogging bool
+}
+
+// AdmissionRule is the access control of the admission middleware
+type AdmissionRule struct {
+	// Roles is the list of roles the user must hold
+	Roles []string
+	// RequireAnyRole indicates only one of the roles is required
+	RequireAnyRole bool
+	// Groups is a list of groups the user must belong to, any of which is enough
+	Groups []string
+}
+
+// Middlewares provides the verification logic of the proxy as net/http middlewares, to protect any router.
+// The requests are not redirected to the provider for authorization: missing or invalid credentials are
+// rejected with a 401 status, and denied requests with a 403 status.
+//
+// Authentication must come first in the chain, since other middlewares act upon the authenticated identity.
+type Middlewares struct {
+	proxy *oauthProxy
+}
+
+// NewMiddlewares creates the middlewares, retrieving the provider configuration
+func NewMiddlewares(config MiddlewareConfig) (*Middlewares, error) {
+	if config.DiscoveryURL == "" {
+		return nil, errors.New("you have not specified the discovery url")
+	}
+	if config.ClientID == "" {
+		return nil, errors.New("you have not specified the client id")
+	}
+	if config.EnableEncryptedToken && config.EncryptionKey == "" {
+		return nil, errors.New("you have not specified an encryption key for encoding the access token")
+	}
+	if config.EnableCSRF && config.EncryptionKey == "" {
+		return nil, errors.New("the CSRF protection requires an encryption key to sign the tokens")
+	}
+	if _, err := newClaimMatchers(config.MatchClaims); err != nil {
+		return nil, err
+	}
+
+	cfg := newDefaultConfig()
+	cfg.DiscoveryURL = config.DiscoveryURL
+	cfg.ClientID = config.ClientID
+	cfg.ClientSecret = config.ClientSecret
+	if config.OpenIDProviderTimeout > 0 {
+		cfg.OpenIDProviderTimeout = config.OpenIDProviderTimeout
+	}
+	cfg.OpenIDProviderCA = config.OpenIDProviderCA
+	cfg.OpenIDProviderPins = config.OpenIDProviderPins
+	cfg.SkipOpenIDProviderTLSVerify = config.SkipOpenIDProviderTLSVerify
+	cfg.CookieAccessName = defaultTo(config.CookieAccessName, cfg.CookieAccessName)
+	cfg.EnableEncryptedToken = config.EnableEncryptedToken
+	cfg.EncryptionKey = config.EncryptionKey
+	cfg.MatchClaims = config.MatchClaims
+	cfg.Provider = config.Provider
+	cfg.RoleClaims = config.RoleClaims
+	cfg.ClientRolesClaim = config.ClientRolesClaim
+	cfg.GroupClaims = config.GroupClaims
+	cfg.EnableClaimsHeaders = config.EnableClaimsHeaders
+	cfg.AddClaims = config.AddClaims
+	cfg.EnableTokenHeader = config.EnableTokenHeader
+	cfg.EnableAuthorizationHeader = config.EnableAuthorizationHeader
+	// the cookies of the application are left untouched
+	cfg.EnableAuthorizationCookies = true
+	cfg.EnableCSRF = config.EnableCSRF
+	cfg.CSRFMode = csrfModeDoubleSubmit
+	cfg.CSRFCookieName = defaultTo(config.CSRFCookieName, cfg.CSRFCookieName)
+	cfg.CSRFHeader = defaultTo(config.CSRFHeader, cfg.CSRFHeader)
+	if config.CSRFTokenDuration > 0 {
+		cfg.CSRFTokenDuration = config.CSRFTokenDuration
+	}
+	cfg.DisableAllLogging = config.DisableAllLogging
+	cfg.EnableJSONLogging = config.EnableJSONLogging
+	// there are no oauth handlers to complete an authorization or a refresh
+	cfg.NoRedirects = true
+	cfg.EnableRefreshTokens = false
+
+	proxy, err := newOAuthProxy(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Middlewares{proxy: proxy}, nil
+}
+
+// Authentication verifies the access token of the request, from the authorization header or the access cookie
+func (m *Middlewares) Authentication() func(http.Handler) http.Handler {
+	return m.wrap(m.proxy.authenticationMiddleware())
+}
+
+// Admission checks the authenticated identity against the roles, groups and claims the access requires
+func (m *Middlewares) Admission(rule AdmissionRule) func(http.Handler) http.Handler {
+	resource := &Resource{
+		URL:            "/",
+		Roles:          rule.Roles,
+		RequireAnyRole: rule.RequireAnyRole,
+		Groups:         rule.Groups,
+	}
+	admission := m.proxy.admissionMiddleware(resource)
+
+	return m.wrap(func(next http.Handler) http.Handler {
+		admit := admission(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if scope := req.Context().Value(contextScopeName).(*RequestScope); scope.Identity == nil {
+				// the admission requires an authenticated identity
+				m.proxy.errorResponse(w, req, "", http.StatusUnauthorized, nil)
+				return
+			}
+			admit.ServeHTTP(w, req)
+		})
+	})
+}
+
+// IdentityHeaders sets the identity headers of the configuration on the request, e.g. X-Auth-Subject
+func (m *Middlewares) IdentityHeaders() func(http.Handler) http.Handler {
+	return m.wrap(m.proxy.identityHeadersMiddleware(m.proxy.config.AddClaims))
+}
+
+// CSRF protects the requests authenticated with the access cookie against cross-site request forgery,
+// with the stateless double-submit cookie method: the client sends the token of the CSRF cookie back as a header.
+// The requests are passed through unless EnableCSRF is set.
+func (m *Middlewares) CSRF() func(http.Handler) http.Handler {
+	if !m.proxy.config.EnableCSRF {
+		return func(next http.Handler) http.Handler {
+			return next
+		}
+	}
+
+	return m.wrap(m.proxy.csrfDoubleSubmitMiddleware())
+}
+
+// Close releases the resources held by the middlewares
+func (m *Middlewares) Close() error {
+	m.proxy.stopProvider()
+
+	return nil
+}
+
+// wrap adapts a middleware of the proxy to any router: the request scope is created if missing,
+// and the chain is stopped whenever the middleware has denied the request
+func (m *Middlewares) wrap(middleware func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if scope := req.Context().Value(contextScopeName).(*RequestScope); scope.AccessDenied {
+				// the response has already been written
+				return
+			}
+			next.ServeHTTP(w, req)
+		}))
+
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if _, found := req.Context().Value(contextScopeName).(*RequestScope); !found {
+				req = req.WithContext(context.WithValue(req.Context(), contextScopeName, &RequestScope{}))
+			}
+			handler.ServeHTTP(w, req)
+		})
+	}
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddlewares(t *testing.T) {
+	idp := newFakeAuthServer()
+	defer idp.Close()
+
+	m, err := NewMiddlewares(MiddlewareConfig{
+		DiscoveryURL:        idp.getLocation(),
+		ClientID:            fakeClientID,
+		ClientSecret:        fakeSecret,
+		EnableClaimsHeaders: true,
+		DisableAllLogging:   true,
+	})
+	require.NoError(t, err)
+	defer m.Close()
+
+	router := chi.NewRouter()
+	router.Use(m.Authentication(), m.IdentityHeaders())
+	protected := func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Subject", req.Header.Get("X-Auth-Subject"))
+	}
+	router.With(m.Admission(AdmissionRule{Roles: []string{fakeAdminRole}})).Get("/admin", protected)
+	router.Get("/any", protected)
+
+	token := newTestToken(idp.getLocation())
+	user, err := idp.signToken(token.claims)
+	require.NoError(t, err)
+	token.addRealmRoles([]string{fakeAdminRole})
+	admin, err := idp.signToken(token.claims)
+	require.NoError(t, err)
+
+	cases := []struct {
+		URI          string
+		Token        string
+		ExpectedCode int
+	}{
+		{URI: "/any", ExpectedCode: http.StatusUnauthorized},
+		{URI: "/any", Token: "invalid", ExpectedCode: http.StatusUnauthorized},
+		{URI: "/any", Token: user.Encode(), ExpectedCode: http.StatusOK},
+		{URI: "/admin", Token: user.Encode(), ExpectedCode: http.StatusForbidden},
+		{URI: "/admin", Token: admin.Encode(), ExpectedCode: http.StatusOK},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, c.URI, nil)
+		if c.Token != "" {
+			req.Header.Set(authorizationHeader, "Bearer "+c.Token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, c.ExpectedCode, w.Code, c.URI)
+		if c.ExpectedCode == http.StatusOK {
+			assert.NotEmpty(t, w.Header().Get("X-Subject"), c.URI)
+		}
+	}
+}
+
+func TestMiddlewaresAdmissionRequiresAuthentication(t *testing.T) {
+	idp := newFakeAuthServer()
+	defer idp.Close()
+
+	m, err := NewMiddlewares(MiddlewareConfig{DiscoveryURL: idp.getLocation(), ClientID: fakeClientID, DisableAllLogging: true})
+	require.NoError(t, err)
+	defer m.Close()
+
+	handler := m.Admission(AdmissionRule{})(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// the CSRF protection is disabled
+	w = httptest.NewRecorder()
+	m.CSRF()(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+}
+
+func TestNewMiddlewaresInvalidConfig(t *testing.T) {
+	_, err := NewMiddlewares(MiddlewareConfig{ClientID: fakeClientID})
+	assert.Error(t, err)
+	_, err = NewMiddlewares(MiddlewareConfig{DiscoveryURL: "http://127.0.0.1", ClientID: fakeClientID, EnableCSRF: true})
+	assert.Error(t, err)
+}
@@ -13,10 +13,11 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package main
+package proxy
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
 	"net/http"
 	"time"
@@ -51,14 +52,41 @@ func (r *oauthProxy) redirectToURL(url string, w http.ResponseWriter, req *http.
 
 // redirectToAuthorization redirects the user to authorization handler
 func (r *oauthProxy) redirectToAuthorization(w http.ResponseWriter, req *http.Request) context.Context {
-	if r.config.NoRedirects {
+	scope, _ := req.Context().Value(contextScopeName).(*RequestScope)
+	if r.config.NoRedirects || (scope != nil && scope.noRedirects) {
 		r.errorResponse(w, req, "", http.StatusUnauthorized, nil)
 		return r.revokeProxy(w, req)
 	}
 
-	// step: add a state referrer to the authorization page
-	uuid := r.writeStateParameterCookie(req, w)
-	authQuery := fmt.Sprintf("?state=%s", uuid)
+	// step: a redirection would lose the body of the request: let the client retry once logged in
+	if r.config.NoRedirectsOnUnsafeMethods && !isSafeMethod(req.Method) {
+		w.Header().Set(loginURLHeader, r.withOAuthURI(req, authorizationURL))
+		r.errorResponse(w, req, "authentication required, retry the request after logging in", http.StatusUnauthorized, nil)
+		return r.revokeProxy(w, req)
+	}
+
+	// step: add a state referrer to the authorization page, unless the authorization issues its own
+	var authQuery string
+	if !r.config.EnableStrictState {
+		uuid := r.writeStateParameterCookie(req, w)
+		authQuery = fmt.Sprintf("?state=%s", uuid)
+	}
+
+	// step: when the session is shared across subdomains, the callback may be served by another host:
+	// unless the app did specify a landing url, we remember the originating one
+	if r.config.EnableCrossSubdomainSession {
+		if cookie, _ := req.Cookie(requestURICookie); cookie == nil {
+			landing := getRequestHostURL(req) + req.URL.RequestURI()
+			r.dropCallbackCookie(w, req.Host, requestURICookie, base64.StdEncoding.EncodeToString([]byte(landing)), 0)
+		}
+	}
+
+	// step: the requests authenticated for another service land back on their original uri
+	if scope != nil && scope.forwardedURI != "" {
+		if cookie, _ := req.Cookie(requestURICookie); cookie == nil {
+			r.dropCallbackCookie(w, req.Host, requestURICookie, base64.StdEncoding.EncodeToString([]byte(scope.forwardedURI)), 0)
+		}
+	}
 
 	// step: if verification is switched off, we can't authorize
 	if r.config.SkipTokenVerification {
@@ -66,9 +94,9 @@ func (r *oauthProxy) redirectToAuthorization(w http.ResponseWriter, req *http.Re
 		return r.revokeProxy(w, req)
 	}
 	if r.config.InvalidAuthRedirectsWith303 {
-		r.redirectToURL(r.config.WithOAuthURI(authorizationURL+authQuery), w, req, http.StatusSeeOther)
+		r.redirectToURL(r.withOAuthURI(req, authorizationURL+authQuery), w, req, http.StatusSeeOther)
 	} else {
-		r.redirectToURL(r.config.WithOAuthURI(authorizationURL+authQuery), w, req, http.StatusTemporaryRedirect)
+		r.redirectToURL(r.withOAuthURI(req, authorizationURL+authQuery), w, req, http.StatusTemporaryRedirect)
 	}
 
 	return r.revokeProxy(w, req)
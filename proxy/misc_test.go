@@ -13,7 +13,7 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package main
+package proxy
 
 import (
 	"net/http"
@@ -21,6 +21,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	resty "gopkg.in/resty.v1"
 )
 
 func TestRedirectToAuthorizationUnauthorized(t *testing.T) {
@@ -57,6 +58,36 @@ func TestRedirectToAuthorizationWith303Enabled(t *testing.T) {
 	newFakeProxy(cfg).RunTests(t, requests)
 }
 
+func TestRedirectToAuthorizationUnsafeMethods(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.NoRedirectsOnUnsafeMethods = true
+
+	requests := []fakeRequest{
+		{
+			URI:              "/admin",
+			Redirects:        true,
+			ExpectedLocation: "/oauth/authorize?state",
+			ExpectedCode:     http.StatusTemporaryRedirect,
+		},
+		{
+			URI:          "/admin",
+			Method:       http.MethodPost,
+			Redirects:    true,
+			ExpectedCode: http.StatusUnauthorized,
+			OnResponse: func(_ int, _ *resty.Request, resp *resty.Response) {
+				assert.Contains(t, resp.Header().Get("X-Auth-Login-Url"), authorizationURL)
+			},
+		},
+		{
+			URI:          "/admin",
+			Method:       http.MethodDelete,
+			Redirects:    true,
+			ExpectedCode: http.StatusUnauthorized,
+		},
+	}
+	newFakeProxy(cfg).RunTests(t, requests)
+}
+
 func TestRedirectToAuthorizationSkipToken(t *testing.T) {
 	requests := []fakeRequest{
 		{URI: "/admin", ExpectedCode: http.StatusUnauthorized},
@@ -1,4 +1,4 @@
-package main
+package proxy
 
 /*
 // deprecated upgrade: WebSocket connection upgrade is natively supported by stdlib reverse proxy
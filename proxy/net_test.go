@@ -13,7 +13,7 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package main
+package proxy
 
 /*
 // deprecated upgrade: WebSocket connection upgrade is natively supported by stdlib reverse proxy
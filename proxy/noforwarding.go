@@ -1,6 +1,6 @@
 //+build noforwarding
 
-package main
+package proxy
 
 import (
 	"errors"
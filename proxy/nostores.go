@@ -0,0 +1,77 @@
+//+build nostores
+
+package proxy
+
+import (
+	"errors"
+	"time"
+
+	"github.com/coreos/go-oidc/jose"
+)
+
+func (r *Config) isStoreValid() error {
+	if r.StoreURL != "" {
+		return errors.New("remote stores are disabled in this build: you can't configure StoreURL")
+	}
+	return nil
+}
+
+func createStorage(location string, dial dialContextFunc) (storage, error) {
+	return nil, nil
+}
+
+func (r *oauthProxy) useStore() bool {
+	return false
+}
+
+func (r *oauthProxy) StoreRefreshToken(token jose.JWT, value string) error {
+	return nil
+}
+
+func (r *oauthProxy) CloseStore() error {
+	return nil
+}
+
+func (r *oauthProxy) GetRefreshToken(token jose.JWT) (string, error) {
+	return "", nil
+}
+
+func (r *oauthProxy) DeleteRefreshToken(token jose.JWT) error {
+	return nil
+}
+
+func (r *oauthProxy) StoreSession(user *userContext, ip string, ttl time.Duration) error {
+	return nil
+}
+
+func (r *oauthProxy) RenewStoredSession(id string, token jose.JWT, ttl time.Duration) error {
+	return nil
+}
+
+func (r *oauthProxy) DeleteStoredSession(id string) error {
+	return nil
+}
+
+func (r *oauthProxy) ListStoredSessions() ([]*storedSession, error) {
+	return nil, nil
+}
+
+func (r *oauthProxy) RevokeSubjectSessions(subject string, revokedAt time.Time) (int, error) {
+	return 0, nil
+}
+
+func (r *oauthProxy) GetSubjectRevocation(subject string) (time.Time, error) {
+	return time.Time{}, nil
+}
+
+func (r *oauthProxy) RevokeSession(id string, revokedAt time.Time) (*storedSession, error) {
+	return nil, nil
+}
+
+func (r *oauthProxy) UseLogoutToken(id string, ttl time.Duration) (bool, error) {
+	return false, nil
+}
+
+func (r *oauthProxy) GetSessionRevocation(id string) (time.Time, error) {
+	return time.Time{}, nil
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/coreos/go-oidc/jose"
+	"go.uber.org/zap"
+)
+
+// pushNotBeforeAction is the action pushed by keycloak when the not-before policy of a client or realm changes
+const pushNotBeforeAction = "PUSH_NOT_BEFORE"
+
+// notBeforeKey is the key of the not-before policy in the store, shared by the instances of the proxy
+const notBeforeKey = "not-before"
+
+// getNotBefore returns the time before which tokens are no longer valid, if any
+func (r *oauthProxy) getNotBefore() time.Time {
+	notBefore := atomic.LoadInt64(&r.notBefore)
+	if r.useStore() {
+		value, err := r.store.Get(notBeforeKey)
+		if err != nil {
+			r.log.Warn("unable to read the not-before policy from the store", zap.Error(err))
+		} else if notBefore, err = strconv.ParseInt(defaultTo(value, "0"), 10, 64); err != nil {
+			r.log.Warn("invalid not-before policy in the store", zap.String("value", value))
+			notBefore = atomic.LoadInt64(&r.notBefore)
+		}
+	}
+	if notBefore == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(notBefore, 0)
+}
+
+// setNotBefore sets the time before which tokens are no longer valid, unless it is older than the current
+// one: a zero time clears it. It indicates whether the policy has been applied.
+func (r *oauthProxy) setNotBefore(notBefore time.Time) (bool, error) {
+	var value int64
+	if !notBefore.IsZero() {
+		value = notBefore.Unix()
+	}
+	if current := r.getNotBefore(); value != 0 && !current.IsZero() && value <= current.Unix() {
+		return false, nil
+	}
+	if r.useStore() {
+		if err := r.store.Set(notBeforeKey, strconv.FormatInt(value, 10), 0); err != nil {
+			return false, err
+		}
+	}
+	atomic.StoreInt64(&r.notBefore, value)
+
+	return true, nil
+}
+
+// isIssuedBeforeNotBefore checks if a token has been issued before the not-before policy
+func (r *oauthProxy) isIssuedBeforeNotBefore(user *userContext) bool {
+	notBefore := r.getNotBefore()
+	if notBefore.IsZero() {
+		return false
+	}
+	issuedAt, found, err := user.claims.TimeClaim("iat")
+	if err != nil || !found {
+		// without an issuance time, we can't tell the token apart from revoked ones
+		return true
+	}
+
+	return issuedAt.Before(notBefore)
+}
+
+// pushNotBeforeHandler accepts the not-before policy pushed by keycloak on the client admin url,
+// e.g. when all sessions are revoked from the admin console
+func (r *oauthProxy) pushNotBeforeHandler(w http.ResponseWriter, req *http.Request) {
+	ctx, span, logger := r.traceSpan(req.Context(), "push not-before handler")
+	if span != nil {
+		defer span.End()
+	}
+
+	content, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, 64*1024))
+	if err != nil {
+		r.errorResponse(w, req.WithContext(ctx), "unable to read the pushed not-before policy", http.StatusBadRequest, err)
+		return
+	}
+
+	token, err := jose.ParseJWT(strings.TrimSpace(string(content)))
+	if err != nil {
+		r.errorResponse(w, req.WithContext(ctx), "unable to parse the pushed not-before policy", http.StatusBadRequest, err)
+		return
+	}
+
+	if err = r.verifyAdminAction(token); err != nil {
+		r.errorResponse(w, req.WithContext(ctx), "the pushed not-before policy failed verification", http.StatusForbidden, err)
+		return
+	}
+
+	claims, err := token.Claims()
+	if err != nil {
+		r.errorResponse(w, req.WithContext(ctx), "unable to decode the pushed not-before policy", http.StatusBadRequest, err)
+		return
+	}
+
+	notBefore, err := parseNotBeforeAction(claims, r.config.ClientID)
+	if err != nil {
+		r.errorResponse(w, req.WithContext(ctx), "invalid pushed not-before policy", http.StatusBadRequest, err)
+		return
+	}
+
+	applied, err := r.setNotBefore(notBefore)
+	switch {
+	case err != nil:
+		r.errorResponse(w, req.WithContext(ctx), "unable to record the pushed not-before policy", http.StatusInternalServerError, err)
+		return
+	case !applied:
+		logger.Info("ignored a not-before policy older than the current one",
+			zap.String("not_before", notBefore.Format(time.RFC3339)))
+	case notBefore.IsZero():
+		logger.Info("cleared the not-before policy")
+	default:
+		logger.Info("updated not-before policy, tokens issued earlier are no longer accepted",
+			zap.String("not_before", notBefore.Format(time.RFC3339)))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// verifyAdminAction checks the signature of an admin action sent by the provider against the provider keys
+func (r *oauthProxy) verifyAdminAction(token jose.JWT) error {
+	keys := r.getProviderKeys()
+	if keys == nil {
+		return errors.New("the provider does not publish any keys")
+	}
+
+	return keys.verifySignature(token)
+}
+
+// parseNotBeforeAction extracts the not-before time from a keycloak admin action
+func parseNotBeforeAction(claims jose.Claims, clientID string) (time.Time, error) {
+	if action, _, _ := claims.StringClaim("action"); action != pushNotBeforeAction {
+		return time.Time{}, fmt.Errorf("unexpected admin action: %q", action)
+	}
+	if resource, found, _ := claims.StringClaim("resource"); found && resource != clientID {
+		return time.Time{}, fmt.Errorf("admin action is intended for another client: %q", resource)
+	}
+	expiration, found, err := claims.Int64Claim("expiration")
+	if err != nil || !found {
+		return time.Time{}, errors.New("admin action has no expiration")
+	}
+	if time.Now().After(time.Unix(expiration, 0)) {
+		return time.Time{}, errors.New("admin action has expired")
+	}
+	notBefore, found, err := claims.Int64Claim("notBefore")
+	if err != nil || !found {
+		return time.Time{}, errors.New("admin action has no notBefore")
+	}
+	if notBefore == 0 {
+		return time.Time{}, nil
+	}
+
+	return time.Unix(notBefore, 0), nil
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-oidc/jose"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	resty "gopkg.in/resty.v1"
+)
+
+func newFakeNotBeforeAction(clientID string, notBefore time.Time) jose.Claims {
+	return jose.Claims{
+		"id":         "4d6c9b5c-2a27-4b6e-8c8e-0e7b1d5b4f1a",
+		"action":     pushNotBeforeAction,
+		"resource":   clientID,
+		"expiration": float64(time.Now().Add(time.Minute).Unix()),
+		"notBefore":  float64(notBefore.Unix()),
+	}
+}
+
+func TestParseNotBeforeAction(t *testing.T) {
+	notBefore := time.Now().Truncate(time.Second)
+
+	parsed, err := parseNotBeforeAction(newFakeNotBeforeAction("test", notBefore), "test")
+	assert.NoError(t, err)
+	assert.True(t, notBefore.Equal(parsed))
+
+	_, err = parseNotBeforeAction(newFakeNotBeforeAction("other", notBefore), "test")
+	assert.Error(t, err)
+
+	action := newFakeNotBeforeAction("test", notBefore)
+	action["action"] = "LOGOUT"
+	_, err = parseNotBeforeAction(action, "test")
+	assert.Error(t, err)
+
+	action = newFakeNotBeforeAction("test", notBefore)
+	action["expiration"] = float64(time.Now().Add(-time.Minute).Unix())
+	_, err = parseNotBeforeAction(action, "test")
+	assert.Error(t, err)
+
+	parsed, err = parseNotBeforeAction(newFakeNotBeforeAction("test", time.Unix(0, 0)), "test")
+	assert.NoError(t, err)
+	assert.True(t, parsed.IsZero())
+}
+
+func TestPushNotBefore(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.EnableNotBeforePush = true
+	p := newFakeProxy(cfg)
+	pushURL := p.getServiceURL() + cfg.WithOAuthURI(pushNotBeforeURL)
+
+	// an unsigned policy is rejected
+	unsigned, err := jose.NewJWT(jose.JOSEHeader{"alg": "RS256"}, newFakeNotBeforeAction(cfg.ClientID, time.Now().Add(time.Hour)))
+	require.NoError(t, err)
+	resp, err := http.Post(pushURL, "text/plain", strings.NewReader(unsigned.Encode()))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.True(t, p.proxy.getNotBefore().IsZero())
+
+	notBefore := time.Now().Add(-time.Minute).Truncate(time.Second)
+	assert.Equal(t, http.StatusNoContent, pushNotBefore(t, p, notBefore))
+	assert.True(t, notBefore.Equal(p.proxy.getNotBefore()))
+
+	// an older policy is ignored
+	assert.Equal(t, http.StatusNoContent, pushNotBefore(t, p, notBefore.Add(-time.Hour)))
+	assert.True(t, notBefore.Equal(p.proxy.getNotBefore()))
+
+	requests := []fakeRequest{
+		{
+			URI:          testAdminURI,
+			HasToken:     true,
+			Roles:        []string{fakeAdminRole},
+			TokenClaims:  jose.Claims{"iat": float64(time.Now().Add(-2 * time.Hour).Unix())},
+			ExpectedCode: http.StatusUnauthorized,
+		},
+		{
+			URI:           testAdminURI,
+			HasToken:      true,
+			Roles:         []string{fakeAdminRole},
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+			OnResponse: func(int, *resty.Request, *resty.Response) {
+				// the older tokens are accepted again once the policy is cleared
+				assert.Equal(t, http.StatusNoContent, pushNotBefore(t, p, time.Time{}))
+				assert.True(t, p.proxy.getNotBefore().IsZero())
+			},
+		},
+		{
+			URI:           testAdminURI,
+			HasToken:      true,
+			Roles:         []string{fakeAdminRole},
+			TokenClaims:   jose.Claims{"iat": float64(time.Now().Add(-2 * time.Hour).Unix())},
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+		},
+	}
+	p.RunTests(t, requests)
+}
+
+func TestPushNotBeforeWithStore(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "keycloak-gatekeeper")
+	require.NoError(t, err)
+	_ = tmpfile.Close()
+	defer os.Remove(tmpfile.Name())
+
+	cfg := newFakeKeycloakConfig()
+	cfg.EnableNotBeforePush = true
+	cfg.StoreURL = fmt.Sprintf("boltdb:///%s", tmpfile.Name())
+	p := newFakeProxy(cfg)
+	defer func() {
+		_ = p.proxy.CloseStore()
+	}()
+
+	notBefore := time.Now().Add(-time.Minute).Truncate(time.Second)
+	assert.Equal(t, http.StatusNoContent, pushNotBefore(t, p, notBefore))
+	value, err := p.proxy.store.Get(notBeforeKey)
+	require.NoError(t, err)
+	assert.Equal(t, strconv.FormatInt(notBefore.Unix(), 10), value)
+
+	// the policy recorded by another instance applies
+	later := notBefore.Add(30 * time.Second)
+	require.NoError(t, p.proxy.store.Set(notBeforeKey, strconv.FormatInt(later.Unix(), 10), 0))
+	assert.True(t, later.Equal(p.proxy.getNotBefore()))
+	assert.Equal(t, http.StatusNoContent, pushNotBefore(t, p, notBefore))
+	assert.True(t, later.Equal(p.proxy.getNotBefore()))
+
+	assert.Equal(t, http.StatusNoContent, pushNotBefore(t, p, time.Time{}))
+	value, err = p.proxy.store.Get(notBeforeKey)
+	require.NoError(t, err)
+	assert.Equal(t, "0", value)
+	assert.True(t, p.proxy.getNotBefore().IsZero())
+}
+
+// pushNotBefore pushes a signed not-before policy to the proxy, returning the status of the response
+func pushNotBefore(t *testing.T, p *fakeProxy, notBefore time.Time) int {
+	signed, err := p.idp.signToken(newFakeNotBeforeAction(p.config.ClientID, notBefore))
+	require.NoError(t, err)
+	resp, err := http.Post(p.getServiceURL()+p.config.WithOAuthURI(pushNotBeforeURL), "text/plain", strings.NewReader(signed.Encode()))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+
+	return resp.StatusCode
+}
+
+func TestPushNotBeforeDisabled(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	requests := []fakeRequest{
+		{
+			URI:          cfg.WithOAuthURI(pushNotBeforeURL),
+			Method:       http.MethodPost,
+			ExpectedCode: http.StatusNotFound,
+		},
+	}
+	newFakeProxy(cfg).RunTests(t, requests)
+}
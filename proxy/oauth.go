@@ -0,0 +1,411 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/jose"
+	"github.com/coreos/go-oidc/key"
+	"github.com/coreos/go-oidc/oauth2"
+	"github.com/coreos/go-oidc/oidc"
+	"go.uber.org/zap"
+)
+
+// getProviderConfig returns the current configuration of the provider
+func (r *oauthProxy) getProviderConfig() oidc.ProviderConfig {
+	r.idpLock.RLock()
+	defer r.idpLock.RUnlock()
+
+	return r.idp
+}
+
+// setProviderConfig replaces the configuration of the provider
+func (r *oauthProxy) setProviderConfig(idp oidc.ProviderConfig) {
+	r.idpLock.Lock()
+	defer r.idpLock.Unlock()
+	r.idp = idp
+}
+
+// getProviderClient returns the openid client of the provider, nil until the provider is discovered
+func (r *oauthProxy) getProviderClient() *oidc.Client {
+	r.idpLock.RLock()
+	defer r.idpLock.RUnlock()
+
+	return r.client
+}
+
+// getProviderKeys returns the keys of the provider, nil when it publishes none or until it is discovered
+func (r *oauthProxy) getProviderKeys() *providerKeys {
+	r.idpLock.RLock()
+	defer r.idpLock.RUnlock()
+
+	return r.keys
+}
+
+// getOAuthClient returns a oauth2 client from the openid client
+func (r *oauthProxy) getOAuthClient(redirectionURL string) (*oauth2.Client, error) {
+	idp := r.getProviderConfig()
+
+	return oauth2.NewClient(r.idpClient, oauth2.Config{
+		Credentials: oauth2.ClientCredentials{
+			ID:     r.config.ClientID,
+			Secret: r.config.ClientSecret,
+		},
+		AuthMethod:  oauth2.AuthMethodClientSecretBasic,
+		AuthURL:     idp.AuthEndpoint.String(),
+		RedirectURL: redirectionURL,
+		Scope:       append(r.config.Scopes, oidc.DefaultScope...),
+		TokenURL:    idp.TokenEndpoint.String(),
+	})
+}
+
+// verifyToken verify that the token in the user context is valid
+func (r *oauthProxy) verifyToken(token jose.JWT) error {
+	return r.verifyTokenAudience(token, r.config.ClientID)
+}
+
+// verifyTokenAudience verifies a token issued for an audience. Without the keys of the provider, the tokens are
+// verified by the client, for the client id only.
+func (r *oauthProxy) verifyTokenAudience(token jose.JWT, audience string) error {
+	token, err := withClockSkewLeeway(token, r.config.ClockSkewLeeway)
+	if err != nil {
+		return err
+	}
+	if keys := r.getProviderKeys(); keys != nil {
+		kid, _ := token.KeyID()
+		issuer, eri := r.expectedIssuer(token)
+		if eri != nil {
+			return eri
+		}
+		verifier := oidc.NewJWTVerifier(issuer, audience, keys.sync, func() []key.PublicKey {
+			return keys.get(kid)
+		})
+		err = verifier.Verify(token)
+	} else {
+		err = r.getProviderClient().VerifyJWT(token)
+	}
+	if err != nil {
+		if strings.Contains(err.Error(), "token is expired") {
+			return ErrAccessTokenExpired
+		}
+		return err
+	}
+
+	return nil
+}
+
+// withClockSkewLeeway checks the time claims of a token with a leeway for the clocks of the provider and the proxy.
+// The library checks the expiry without any: it is given the token with its expiry shifted by the leeway, the
+// signature being verified on the raw payload.
+func withClockSkewLeeway(token jose.JWT, leeway time.Duration) (jose.JWT, error) {
+	claims, err := token.Claims()
+	if err != nil {
+		return token, err
+	}
+	now := time.Now()
+	if nbf, found, err := claims.TimeClaim("nbf"); err == nil && found && now.Add(leeway).Before(nbf) {
+		return token, ErrTokenNotYetValid
+	}
+	if iat, found, err := claims.TimeClaim("iat"); err == nil && found && now.Add(leeway).Before(iat) {
+		return token, ErrTokenNotYetValid
+	}
+	exp, found, err := claims.TimeClaim("exp")
+	if err != nil || !found || leeway == 0 {
+		return token, nil
+	}
+	if now.After(exp.Add(leeway)) {
+		return token, ErrAccessTokenExpired
+	}
+	claims.Add("exp", exp.Add(leeway).Unix())
+	if token.Payload, err = json.Marshal(claims); err != nil {
+		return token, err
+	}
+
+	return token, nil
+}
+
+// getRefreshedToken attempts to refresh the access token, returning the parsed token, optionally with a renewed
+// refresh token and the time the access and refresh tokens expire
+//
+// NOTE: we may be able to extract the specific (non-standard) claim refresh_expires_in and refresh_expires
+// from response.RawBody.
+// When not available, keycloak provides us with the same (for now) expiry value for ID token.
+//
+// The renewed ID token is returned in place of the access token for the providers using ID tokens as session tokens.
+func getRefreshedToken(client *oidc.Client, t string, idToken bool) (jose.JWT, string, time.Time, time.Duration, error) {
+	cl, err := client.OAuthClient()
+	if err != nil {
+		return jose.JWT{}, "", time.Time{}, time.Duration(0), err
+	}
+	response, err := getToken(cl, oauth2.GrantTypeRefreshToken, t)
+	if err != nil {
+		if strings.Contains(err.Error(), "refresh token has expired") {
+			return jose.JWT{}, "", time.Time{}, time.Duration(0), ErrRefreshTokenExpired
+		}
+		return jose.JWT{}, "", time.Time{}, time.Duration(0), err
+	}
+
+	// extracts non-standard claims about refresh token, to get refresh token expiry
+	var (
+		refreshExpiresIn time.Duration
+		extraClaims      struct {
+			RefreshExpiresIn json.Number `json:"refresh_expires_in"`
+		}
+	)
+	_ = json.Unmarshal(response.RawBody, &extraClaims)
+	if extraClaims.RefreshExpiresIn != "" {
+		if asInt, erj := extraClaims.RefreshExpiresIn.Int64(); erj == nil {
+			refreshExpiresIn = time.Duration(asInt) * time.Second
+		}
+	}
+	renewed := response.AccessToken
+	if idToken {
+		renewed = response.IDToken
+	}
+	token, identity, err := parseToken(renewed)
+	if err != nil {
+		return jose.JWT{}, "", time.Time{}, time.Duration(0), err
+	}
+
+	return token, response.RefreshToken, identity.ExpiresAt, refreshExpiresIn, nil
+}
+
+// refreshedToken is the outcome of an access token refresh
+type refreshedToken struct {
+	token            jose.JWT
+	refreshToken     string
+	accessExpiresAt  time.Time
+	refreshExpiresIn time.Duration
+}
+
+// getRefreshedTokenOnce refreshes the access token, making sure that concurrent requests of a session
+// presenting the same refresh token result in a single call to the provider, the outcome being shared
+// by all waiters. It also reports if this call actually performed the refresh.
+func (r *oauthProxy) getRefreshedTokenOnce(refresh string) (refreshedToken, bool, error) {
+	var leader bool
+	v, err, _ := r.refreshes.Do(refresh, func() (interface{}, error) {
+		leader = true
+		token, newRefreshToken, accessExpiresAt, refreshExpiresIn, err := getRefreshedToken(r.getProviderClient(), refresh, r.profile.idTokenSession)
+		if err != nil {
+			return refreshedToken{}, err
+		}
+		oauthTokensMetric.WithLabelValues("renew").Inc()
+
+		return refreshedToken{
+			token:            token,
+			refreshToken:     newRefreshToken,
+			accessExpiresAt:  accessExpiresAt,
+			refreshExpiresIn: refreshExpiresIn,
+		}, nil
+	})
+
+	return v.(refreshedToken), leader, err
+}
+
+// withResponseMode requests the mode of the authorization response, unless the default query mode is used
+func (r *oauthProxy) withResponseMode(authURL string) string {
+	if r.config.ResponseMode == "" || r.config.ResponseMode == responseModeQuery {
+		return authURL
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return authURL
+	}
+	query := u.Query()
+	query.Set("response_mode", r.config.ResponseMode)
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+// formPostCallback moves the parameters of an authorization response posted to the callback (form_post response
+// mode) to the query of the request, where they are found with a redirection
+func formPostCallback(req *http.Request) error {
+	if err := req.ParseForm(); err != nil {
+		return err
+	}
+	req.URL.RawQuery = req.PostForm.Encode()
+
+	return nil
+}
+
+// exchangeAuthenticationCode exchanges the authentication code with the oauth server for a access token
+func exchangeAuthenticationCode(client *oauth2.Client, code string) (oauth2.TokenResponse, error) {
+	return getToken(client, oauth2.GrantTypeAuthCode, code)
+}
+
+// getUserinfo is responsible for getting the userinfo from the IDP
+func getUserinfo(client *oauth2.Client, endpoint string, token string) (jose.Claims, error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(authorizationHeader, fmt.Sprintf("Bearer %s", token))
+
+	resp, err := client.HttpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, ErrUserinfoRejected
+	}
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var claims jose.Claims
+	if err := json.Unmarshal(content, &claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// getToken retrieves a code from the provider, extracts and verified the token
+func getToken(client *oauth2.Client, grantType, code string) (oauth2.TokenResponse, error) {
+	start := time.Now()
+	token, err := client.RequestToken(grantType, code)
+	if err != nil {
+		return token, err
+	}
+	taken := time.Since(start).Seconds()
+	switch grantType {
+	case oauth2.GrantTypeAuthCode:
+		oauthTokensMetric.WithLabelValues("exchange").Inc()
+		oauthLatencyMetric.WithLabelValues("exchange").Observe(taken)
+	case oauth2.GrantTypeRefreshToken:
+		oauthTokensMetric.WithLabelValues("renew").Inc()
+		oauthLatencyMetric.WithLabelValues("renew").Observe(taken)
+	}
+
+	return token, err
+}
+
+// parseToken retrieves the user identity from the token
+func parseToken(t string) (jose.JWT, *oidc.Identity, error) {
+	token, err := jose.ParseJWT(t)
+	if err != nil {
+		return jose.JWT{}, nil, err
+	}
+	claims, err := token.Claims()
+	if err != nil {
+		return jose.JWT{}, nil, err
+	}
+	identity, err := oidc.IdentityFromClaims(claims)
+	if err != nil {
+		return jose.JWT{}, nil, err
+	}
+
+	return token, identity, nil
+}
+
+// revokeTokens revokes the tokens of a user logging out, according to the configured revocation mode
+func (r *oauthProxy) revokeTokens(ctx context.Context, user *userContext, refresh string) {
+	mode := defaultTo(r.config.RevocationMode, revocationModeEndSession)
+	if mode == revocationModeNone {
+		return
+	}
+
+	var endSessionURL string
+	if idp := r.getProviderConfig(); idp.EndSessionEndpoint != nil {
+		endSessionURL = idp.EndSessionEndpoint.String()
+	}
+
+	// step: post the refresh token (or the access token) to the provider logout endpoint.
+	// The revocation url overrides the logout endpoint, unless both methods are used.
+	if mode == revocationModeEndSession || mode == revocationModeBoth {
+		revocationURL := endSessionURL
+		if mode == revocationModeEndSession {
+			revocationURL = defaultTo(r.config.RevocationEndpoint, endSessionURL)
+		}
+		if revocationURL != "" {
+			form := url.Values{"refresh_token": []string{defaultTo(refresh, user.encodedToken())}}
+			r.postRevocation(ctx, user, revocationURL, form)
+		}
+	}
+
+	// step: revoke the tokens at the RFC 7009 revocation endpoint
+	if mode == revocationModeRevoke || mode == revocationModeBoth {
+		provider := strings.TrimSuffix(r.config.DiscoveryURL, "/.well-known/openid-configuration")
+		revocationURL := defaultTo(r.config.RevocationEndpoint, provider+"/protocol/openid-connect/revoke")
+		if refresh != "" {
+			form := url.Values{"token": []string{refresh}, "token_type_hint": []string{"refresh_token"}}
+			r.postRevocation(ctx, user, revocationURL, form)
+		}
+		if r.config.EnableRevokeAccessToken {
+			form := url.Values{"token": []string{user.encodedToken()}, "token_type_hint": []string{"access_token"}}
+			r.postRevocation(ctx, user, revocationURL, form)
+		}
+	}
+}
+
+// postRevocation posts a revocation request to the provider, authenticated as the client
+func (r *oauthProxy) postRevocation(ctx context.Context, user *userContext, revocationURL string, form url.Values) {
+	logger := r.log.With(zap.String("revocation_url", revocationURL))
+
+	client, err := r.getProviderClient().OAuthClient()
+	if err != nil {
+		logger.Error("unable to retrieve the openid client", zap.Error(err))
+		return
+	}
+
+	// step: construct the url for revocation
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, revocationURL, bytes.NewBufferString(form.Encode()))
+	if err != nil {
+		logger.Error("unable to construct the revocation request", zap.Error(err))
+		return
+	}
+
+	// step: add the authentication headers and content-type
+	request.SetBasicAuth(url.QueryEscape(r.config.ClientID), url.QueryEscape(r.config.ClientSecret))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	start := time.Now()
+	response, err := client.HttpClient().Do(request)
+	if err != nil {
+		logger.Error("unable to post to revocation endpoint", zap.Error(err))
+		return
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	oauthLatencyMetric.WithLabelValues("revocation").Observe(time.Since(start).Seconds())
+
+	// step: check the response
+	switch response.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		logger.Info("successfully logged out of the endpoint", zap.String("email", user.email))
+	default:
+		content, _ := ioutil.ReadAll(response.Body)
+		logger.Error("invalid response from revocation endpoint",
+			zap.Int("status", response.StatusCode),
+			zap.String("response", fmt.Sprintf("%s", content)))
+	}
+}
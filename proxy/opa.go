@@ -0,0 +1,130 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+// opaInput is the input document of the policy decisions
+type opaInput struct {
+	Method     string                 `json:"method"`
+	Path       string                 `json:"path"`
+	Query      map[string][]string    `json:"query"`
+	Host       string                 `json:"host"`
+	RemoteAddr string                 `json:"remote_addr"`
+	Resource   string                 `json:"resource"`
+	Subject    string                 `json:"subject"`
+	Roles      []string               `json:"roles"`
+	Groups     []string               `json:"groups"`
+	Claims     map[string]interface{} `json:"claims"`
+}
+
+// opaDecider queries the decisions of an Open Policy Agent data API
+type opaDecider struct {
+	client *http.Client
+	url    string
+}
+
+// newOPADecider returns the decider of a resource, if any policy applies to it
+func (r *oauthProxy) newOPADecider(resource *Resource) *opaDecider {
+	location := defaultTo(resource.OPAURL, r.config.OPAURL)
+	if location == "" {
+		return nil
+	}
+
+	return &opaDecider{
+		client: &http.Client{Timeout: r.config.OPATimeout},
+		url:    location,
+	}
+}
+
+// allows queries the decision of the policy on an authenticated request. The decision is either a boolean,
+// or a document with an allow boolean.
+func (d *opaDecider) allows(req *http.Request, resource *Resource, user *userContext) (bool, error) {
+	input := opaInput{
+		Method:     req.Method,
+		Path:       req.URL.Path,
+		Query:      req.URL.Query(),
+		Host:       req.Host,
+		RemoteAddr: realIP(req),
+		Resource:   resource.URL,
+		Subject:    user.id,
+		Roles:      user.roles,
+		Groups:     user.groups,
+		Claims:     user.claims,
+	}
+	payload, err := json.Marshal(map[string]interface{}{"input": input})
+	if err != nil {
+		return false, err
+	}
+
+	request, err := http.NewRequestWithContext(req.Context(), http.MethodPost, d.url, bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", jsonMime)
+
+	response, err := d.client.Do(request)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, response.Body)
+		_ = response.Body.Close()
+	}()
+	if response.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("unexpected response status from the policy agent: %d", response.StatusCode)
+	}
+
+	var decision struct {
+		Result interface{} `json:"result"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&decision); err != nil {
+		return false, fmt.Errorf("invalid decision from the policy agent: %v", err)
+	}
+
+	switch result := decision.Result.(type) {
+	case nil:
+		// an undefined decision denies the request
+		return false, nil
+	case bool:
+		return result, nil
+	case map[string]interface{}:
+		allow, ok := result["allow"].(bool)
+		return ok && allow, nil
+	default:
+		return false, fmt.Errorf("unexpected decision from the policy agent: %v", result)
+	}
+}
+
+// isOPAURLValid checks the url of a policy decision
+func isOPAURLValid(location string) error {
+	if location == "" {
+		return nil
+	}
+	if u, err := url.Parse(location); err != nil || (u.Scheme != unsecureScheme && u.Scheme != secureScheme) || u.Host == "" {
+		return fmt.Errorf("the opa url %q is not a valid http url, e.g. http://localhost:8181/v1/data/gatekeeper/allow", location)
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newFakeOPA decides like a policy permitting the reads, and the writes of the finance department
+func newFakeOPA(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var body struct {
+			Input opaInput `json:"input"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			t.Errorf("invalid policy input: %v", err)
+		}
+		allow := body.Input.Method == http.MethodGet || body.Input.Claims["department"] == "finance"
+		switch req.URL.Path {
+		case "/v1/data/gatekeeper":
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"result": map[string]interface{}{"allow": allow}})
+		case "/v1/data/gatekeeper/admin":
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"result": body.Input.Path == "/admin/public"})
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+}
+
+func TestOPAAdmission(t *testing.T) {
+	opa := newFakeOPA(t)
+	defer opa.Close()
+
+	cfg := newFakeKeycloakConfig()
+	cfg.OPAURL = opa.URL + "/v1/data/gatekeeper"
+	cfg.Resources = []*Resource{
+		{
+			URL:     "/admin/*",
+			Methods: allHTTPMethods,
+			OPAURL:  opa.URL + "/v1/data/gatekeeper/admin",
+		},
+		{
+			URL:     "/broken/*",
+			Methods: allHTTPMethods,
+			OPAURL:  opa.URL + "/v1/data/missing",
+		},
+		{
+			URL:     "/*",
+			Methods: allHTTPMethods,
+		},
+	}
+	requests := []fakeRequest{
+		{
+			URI:           "/orders",
+			HasToken:      true,
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+		},
+		{
+			URI:          "/orders",
+			Method:       http.MethodPost,
+			HasToken:     true,
+			ExpectedCode: http.StatusForbidden,
+		},
+		{
+			URI:           "/orders",
+			Method:        http.MethodPost,
+			HasToken:      true,
+			TokenClaims:   map[string]interface{}{"department": "finance"},
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+		},
+		{
+			// the policy of the resource is queried in place of the global one
+			URI:           "/admin/public",
+			HasToken:      true,
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+		},
+		{
+			URI:          "/admin/private",
+			HasToken:     true,
+			ExpectedCode: http.StatusForbidden,
+		},
+		{
+			URI:          "/broken/path",
+			HasToken:     true,
+			ExpectedCode: http.StatusServiceUnavailable,
+		},
+		{
+			URI:          "/orders",
+			Redirects:    false,
+			ExpectedCode: http.StatusUnauthorized,
+		},
+	}
+	newFakeProxy(cfg).RunTests(t, requests)
+}
@@ -0,0 +1,247 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// Plugin is a compiled-in extension of the request processing.
+//
+// Plugins are registered with RegisterPlugin, usually from an init function, and
+// enabled by name from the configuration. A plugin takes part in the processing
+// by implementing any of the hook interfaces: PreAuthHook, PostAuthHook,
+// PreUpstreamHook and PostUpstreamHook.
+type Plugin interface {
+	// Name is the name enabling the plugin in the configuration
+	Name() string
+}
+
+// PreAuthHook is called on every proxied request, before the authentication.
+//
+// Returning false stops the processing of the request: the hook is then
+// responsible for writing the response.
+type PreAuthHook interface {
+	PreAuth(w http.ResponseWriter, req *http.Request) bool
+}
+
+// PostAuthHook is called once the request has been authenticated, before the admission.
+//
+// The identity is nil when the authentication is optional and the request anonymous.
+// Returning false stops the processing of the request: the hook is then
+// responsible for writing the response.
+type PostAuthHook interface {
+	PostAuth(w http.ResponseWriter, req *http.Request, identity *Identity) bool
+}
+
+// PreUpstreamHook is called right before the request is forwarded to the upstream,
+// once the identity headers have been set.
+//
+// The identity is nil on white-listed resources and anonymous requests.
+// Returning false stops the processing of the request: the hook is then
+// responsible for writing the response.
+type PreUpstreamHook interface {
+	PreUpstream(w http.ResponseWriter, req *http.Request, identity *Identity) bool
+}
+
+// PostUpstreamHook is called with the response of the upstream, before it is
+// sent back to the client. Returning an error responds with a bad gateway.
+//
+// Streaming resources are not subject to this hook.
+type PostUpstreamHook interface {
+	PostUpstream(res *http.Response) error
+}
+
+var plugins = struct {
+	sync.RWMutex
+	registry map[string]Plugin
+}{
+	registry: make(map[string]Plugin),
+}
+
+// RegisterPlugin makes a plugin available to the configuration. It panics if
+// the plugin is nil or a plugin with the same name is already registered.
+func RegisterPlugin(plugin Plugin) {
+	if plugin == nil {
+		panic("gatekeeper: cannot register a nil plugin")
+	}
+	plugins.Lock()
+	defer plugins.Unlock()
+
+	if _, found := plugins.registry[plugin.Name()]; found {
+		panic(fmt.Sprintf("gatekeeper: plugin %q is already registered", plugin.Name()))
+	}
+	plugins.registry[plugin.Name()] = plugin
+}
+
+// RegisteredPlugins returns the sorted names of the registered plugins
+func RegisteredPlugins() []string {
+	plugins.RLock()
+	defer plugins.RUnlock()
+
+	names := make([]string, 0, len(plugins.registry))
+	for name := range plugins.registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// lookupPlugins resolves the plugins enabled by name, in order
+func lookupPlugins(names []string) ([]Plugin, error) {
+	plugins.RLock()
+	defer plugins.RUnlock()
+
+	list := make([]Plugin, 0, len(names))
+	for _, name := range names {
+		plugin, found := plugins.registry[name]
+		if !found {
+			return nil, fmt.Errorf("the plugin %q is not registered", name)
+		}
+		list = append(list, plugin)
+	}
+
+	return list, nil
+}
+
+// Identity is the authenticated user of a request, as exposed to the plugins
+type Identity struct {
+	scope *RequestScope
+}
+
+// Subject returns the subject of the token
+func (i *Identity) Subject() string {
+	return i.scope.Identity.id
+}
+
+// Email returns the email of the user
+func (i *Identity) Email() string {
+	return i.scope.Identity.email
+}
+
+// Roles returns the roles held by the user
+func (i *Identity) Roles() []string {
+	return append([]string{}, i.scope.Identity.roles...)
+}
+
+// Groups returns the groups the user is a member of
+func (i *Identity) Groups() []string {
+	return append([]string{}, i.scope.Identity.groups...)
+}
+
+// Claim returns a claim of the token
+func (i *Identity) Claim(name string) (interface{}, bool) {
+	value, found := i.scope.Identity.claims[name]
+
+	return value, found
+}
+
+// SetClaim adds or replaces a claim for the rest of the processing of the request,
+// e.g. to feed the custom claim headers. The token itself is left untouched.
+func (i *Identity) SetClaim(name string, value interface{}) {
+	// the identity may be shared with other requests through the token cache
+	user := *i.scope.Identity
+	user.claims = make(map[string]interface{}, len(i.scope.Identity.claims)+1)
+	for k, v := range i.scope.Identity.claims {
+		user.claims[k] = v
+	}
+	user.claims[name] = value
+	i.scope.Identity = &user
+}
+
+// pluginIdentity returns the identity of the request for the plugins, if any
+func pluginIdentity(scope *RequestScope) *Identity {
+	if scope.Identity == nil {
+		return nil
+	}
+
+	return &Identity{scope: scope}
+}
+
+// pluginsMiddleware runs a stage of hooks from the enabled plugins
+func (r *oauthProxy) pluginsMiddleware(hook func(Plugin, http.ResponseWriter, *http.Request, *RequestScope) (bool, bool)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(r.plugins) == 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			scope := req.Context().Value(contextScopeName).(*RequestScope)
+			// the requests already denied or redirected have been answered
+			if scope.AccessDenied {
+				next.ServeHTTP(w, req)
+				return
+			}
+			for _, plugin := range r.plugins {
+				if implemented, proceed := hook(plugin, w, req, scope); implemented && !proceed {
+					r.log.Debug("request stopped by plugin", zap.String("plugin", plugin.Name()), zap.String("path", req.URL.Path))
+					scope.AccessDenied = true
+					return
+				}
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// preAuthPluginsMiddleware runs the pre-authentication hooks
+func (r *oauthProxy) preAuthPluginsMiddleware() func(http.Handler) http.Handler {
+	return r.pluginsMiddleware(func(plugin Plugin, w http.ResponseWriter, req *http.Request, _ *RequestScope) (bool, bool) {
+		if h, ok := plugin.(PreAuthHook); ok {
+			return true, h.PreAuth(w, req)
+		}
+		return false, true
+	})
+}
+
+// postAuthPluginsMiddleware runs the post-authentication hooks
+func (r *oauthProxy) postAuthPluginsMiddleware() func(http.Handler) http.Handler {
+	return r.pluginsMiddleware(func(plugin Plugin, w http.ResponseWriter, req *http.Request, scope *RequestScope) (bool, bool) {
+		if h, ok := plugin.(PostAuthHook); ok {
+			return true, h.PostAuth(w, req, pluginIdentity(scope))
+		}
+		return false, true
+	})
+}
+
+// preUpstreamPluginsMiddleware runs the pre-upstream hooks
+func (r *oauthProxy) preUpstreamPluginsMiddleware() func(http.Handler) http.Handler {
+	return r.pluginsMiddleware(func(plugin Plugin, w http.ResponseWriter, req *http.Request, scope *RequestScope) (bool, bool) {
+		if h, ok := plugin.(PreUpstreamHook); ok {
+			return true, h.PreUpstream(w, req, pluginIdentity(scope))
+		}
+		return false, true
+	})
+}
+
+// postUpstreamPlugins runs the post-upstream hooks on a response from upstream
+func (r *oauthProxy) postUpstreamPlugins(res *http.Response) error {
+	for _, plugin := range r.plugins {
+		if h, ok := plugin.(PostUpstreamHook); ok {
+			if err := h.PostUpstream(res); err != nil {
+				return fmt.Errorf("plugin %s: %w", plugin.Name(), err)
+			}
+		}
+	}
+
+	return nil
+}
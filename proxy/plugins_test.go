@@ -0,0 +1,180 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakePlugin struct{}
+
+func (fakePlugin) Name() string { return "fake" }
+
+func (fakePlugin) PreAuth(w http.ResponseWriter, req *http.Request) bool {
+	if req.Header.Get("X-Block") != "" {
+		w.WriteHeader(http.StatusUnavailableForLegalReasons)
+		return false
+	}
+	return true
+}
+
+func (fakePlugin) PostAuth(w http.ResponseWriter, req *http.Request, identity *Identity) bool {
+	if identity == nil {
+		return true
+	}
+	if req.Header.Get("X-Block-User") == identity.Subject() {
+		w.WriteHeader(http.StatusForbidden)
+		return false
+	}
+	identity.SetClaim("tenant", "acme")
+	return true
+}
+
+func (fakePlugin) PreUpstream(w http.ResponseWriter, req *http.Request, identity *Identity) bool {
+	if identity == nil {
+		req.Header.Set("X-Plugin", "anonymous")
+		return true
+	}
+	req.Header.Set("X-Plugin", identity.Email())
+	return true
+}
+
+func (fakePlugin) PostUpstream(res *http.Response) error {
+	if res.Request.Header.Get("X-Fail") != "" {
+		return errors.New("rejected response")
+	}
+	res.Header.Set("X-Plugin-Response", "seen")
+	return nil
+}
+
+func init() {
+	RegisterPlugin(fakePlugin{})
+}
+
+func TestPlugins(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.Plugins = []string{"fake"}
+	cfg.AddClaims = []string{"tenant"}
+	requests := []fakeRequest{
+		{
+			URI:          fakeAuthAllURL + "/test",
+			HasToken:     true,
+			Headers:      map[string]string{"X-Block": "true"},
+			ExpectedCode: http.StatusUnavailableForLegalReasons,
+		},
+		{
+			URI:          fakeAuthAllURL + "/test",
+			HasToken:     true,
+			Headers:      map[string]string{"X-Block-User": "1e11e539-8256-4b3b-bda8-cc0d56cddb48"},
+			ExpectedCode: http.StatusForbidden,
+		},
+		{
+			URI:           fakeAuthAllURL + "/test",
+			HasToken:      true,
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+			ExpectedProxyHeaders: map[string]string{
+				"X-Auth-Tenant": "acme",
+				"X-Plugin":      "gambol99@gmail.com",
+			},
+		},
+		{
+			URI:           fakeTestWhitelistedURL,
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+			ExpectedProxyHeaders: map[string]string{
+				"X-Plugin": "anonymous",
+			},
+		},
+	}
+	newFakeProxy(cfg).RunTests(t, requests)
+}
+
+func TestPostUpstreamPlugins(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	cfg := newFakeKeycloakConfig()
+	cfg.Plugins = []string{"fake"}
+	p := newFakeProxy(cfg)
+	proxy := p.proxy.newUpstreamProxy(http.DefaultTransport, false)
+
+	resp := httptest.NewRecorder()
+	proxy.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, upstream.URL, nil))
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "seen", resp.Header().Get("X-Plugin-Response"))
+
+	req := httptest.NewRequest(http.MethodGet, upstream.URL, nil)
+	req.Header.Set("X-Fail", "true")
+	resp = httptest.NewRecorder()
+	proxy.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusBadGateway, resp.Code)
+	assert.Empty(t, resp.Header().Get("X-Plugin-Response"))
+}
+
+func TestPluginsSkipDeniedRequests(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.Plugins = []string{"fake"}
+	p := newFakeProxy(cfg)
+	defer func() {
+		p.idp.Close()
+		p.proxy.server.Close()
+	}()
+	var proxied bool
+	handler := p.proxy.postAuthPluginsMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		proxied = true
+	}))
+
+	scope := &RequestScope{AccessDenied: true, Identity: &userContext{id: "sub", claims: map[string]interface{}{"sub": "sub"}}}
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("X-Block-User", "sub")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req.WithContext(context.WithValue(req.Context(), contextScopeName, scope)))
+	assert.True(t, proxied)
+	assert.Equal(t, http.StatusOK, resp.Code, "the hooks do not answer a denied request")
+}
+
+func TestPluginClaimsNotShared(t *testing.T) {
+	user := &userContext{id: "sub", claims: map[string]interface{}{"sub": "sub"}}
+	scope := &RequestScope{Identity: user}
+	pluginIdentity(scope).SetClaim("tenant", "acme")
+
+	_, found := user.claims["tenant"]
+	assert.False(t, found)
+	value, found := pluginIdentity(scope).Claim("tenant")
+	assert.True(t, found)
+	assert.Equal(t, "acme", value)
+}
+
+func TestRegisterPluginTwice(t *testing.T) {
+	assert.Contains(t, RegisteredPlugins(), "fake")
+	assert.Panics(t, func() { RegisterPlugin(fakePlugin{}) })
+}
+
+func TestUnknownPlugin(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.Upstream = "http://127.0.0.1"
+	cfg.Plugins = []string{"missing"}
+	assert.Error(t, cfg.isValid())
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// profilingGuard protects the profiling endpoints with a bearer token, a rate limit and an expiry
+type profilingGuard struct {
+	sync.Mutex
+	token     string
+	limit     int
+	expiresAt time.Time
+	window    time.Time
+	count     int
+}
+
+func newProfilingGuard(token string, limit int, duration time.Duration) *profilingGuard {
+	g := &profilingGuard{
+		token: token,
+		limit: limit,
+	}
+	if duration > 0 {
+		g.expiresAt = time.Now().Add(duration)
+	}
+
+	return g
+}
+
+// isExpired checks if the profiling endpoints have been automatically disabled
+func (g *profilingGuard) isExpired() bool {
+	return !g.expiresAt.IsZero() && time.Now().After(g.expiresAt)
+}
+
+// isAuthorized checks the request carries the profiling token, if any is required
+func (g *profilingGuard) isAuthorized(req *http.Request) bool {
+	return g.token == "" || hasBearerToken(req, g.token)
+}
+
+// hasBearerToken checks the request carries the expected token in its authorization header
+func hasBearerToken(req *http.Request, token string) bool {
+	bearer := strings.TrimPrefix(req.Header.Get(authorizationHeader), "Bearer ")
+
+	return subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) == 1
+}
+
+// allow checks the rate limit of the profiling endpoints over a one-minute window
+func (g *profilingGuard) allow() bool {
+	if g.limit == 0 {
+		return true
+	}
+	g.Lock()
+	defer g.Unlock()
+	now := time.Now()
+	if now.Sub(g.window) >= time.Minute {
+		g.window = now
+		g.count = 0
+	}
+	if g.count >= g.limit {
+		return false
+	}
+	g.count++
+
+	return true
+}
+
+// profilingMiddleware enforces the safeguards on the profiling endpoints
+func (r *oauthProxy) profilingMiddleware(guard *profilingGuard) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			switch {
+			case guard.isExpired():
+				r.errorResponse(w, req, "profiling endpoints have been disabled", http.StatusNotFound, nil)
+			case !guard.isAuthorized(req):
+				r.errorResponse(w, req, "invalid or missing profiling token", http.StatusUnauthorized, nil)
+			case !guard.allow():
+				r.errorResponse(w, req, "too many requests to the profiling endpoints", http.StatusTooManyRequests, nil)
+			default:
+				next.ServeHTTP(w, req)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// mutexProfileFraction is the sampling rate of the mutex contention events, when profiles may be dumped
+const mutexProfileFraction = 100
+
+// dumpedProfiles are the profiles written on demand, with their debug level
+var dumpedProfiles = []struct {
+	name  string
+	debug int
+	ext   string
+}{
+	{name: "goroutine", debug: 2, ext: "txt"},
+	{name: "heap", ext: "pprof"},
+	{name: "mutex", ext: "pprof"},
+}
+
+// dumpProfiles writes the goroutine, heap and mutex profiles to the profiles directory,
+// or logs a goroutine dump when no directory is configured
+func (r *oauthProxy) dumpProfiles() error {
+	if r.config.ProfilesDir == "" {
+		buf := &bytes.Buffer{}
+		if err := pprof.Lookup("goroutine").WriteTo(buf, 2); err != nil {
+			return err
+		}
+		r.log.Info("goroutine dump", zap.Int("goroutines", runtime.NumGoroutine()), zap.String("dump", buf.String()))
+
+		return nil
+	}
+
+	if err := os.MkdirAll(r.config.ProfilesDir, 0700); err != nil {
+		return err
+	}
+	stamp := time.Now().UTC().Format("20060102T150405.000")
+	for _, x := range dumpedProfiles {
+		filename := filepath.Join(r.config.ProfilesDir, fmt.Sprintf("%s-%s.%s", x.name, stamp, x.ext))
+		if err := writeProfile(filename, x.name, x.debug); err != nil {
+			return fmt.Errorf("unable to write the %s profile: %s", x.name, err)
+		}
+		r.log.Info("profile written", zap.String("profile", x.name), zap.String("file", filename))
+	}
+
+	return nil
+}
+
+// writeProfile writes a runtime profile to a file
+func writeProfile(filename, name string, debug int) error {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if name == "heap" {
+		// reflect the latest allocations
+		runtime.GC()
+	}
+	if err := pprof.Lookup(name).WriteTo(file, debug); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	return file.Close()
+}
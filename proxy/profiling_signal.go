@@ -0,0 +1,57 @@
+//+build !windows
+
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+
+	"go.uber.org/zap"
+)
+
+// watchProfilingSignal dumps the profiles whenever the process receives SIGUSR1
+func (r *oauthProxy) watchProfilingSignal() {
+	if r.profilingSignal != nil {
+		return
+	}
+	if r.config.ProfilesDir != "" {
+		runtime.SetMutexProfileFraction(mutexProfileFraction)
+	}
+	r.profilingSignal = make(chan os.Signal, 1)
+	signal.Notify(r.profilingSignal, syscall.SIGUSR1)
+
+	go func(signals chan os.Signal) {
+		for range signals {
+			if err := r.dumpProfiles(); err != nil {
+				r.log.Error("failed to dump the profiles", zap.Error(err))
+			}
+		}
+	}(r.profilingSignal)
+}
+
+// stopProfilingSignal stops dumping the profiles on SIGUSR1
+func (r *oauthProxy) stopProfilingSignal() {
+	if r.profilingSignal == nil {
+		return
+	}
+	signal.Stop(r.profilingSignal)
+	close(r.profilingSignal)
+	r.profilingSignal = nil
+}
@@ -0,0 +1,71 @@
+//+build !windows
+
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDumpProfiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profiles")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := newFakeKeycloakConfig()
+	p := newFakeProxy(cfg)
+	// a goroutine dump is logged without any directory
+	assert.NoError(t, p.proxy.dumpProfiles())
+
+	cfg.ProfilesDir = filepath.Join(dir, "dumps")
+	require.NoError(t, p.proxy.dumpProfiles())
+	for _, name := range []string{"goroutine-*.txt", "heap-*.pprof", "mutex-*.pprof"} {
+		files, err := filepath.Glob(filepath.Join(cfg.ProfilesDir, name))
+		require.NoError(t, err)
+		if assert.Len(t, files, 1, "profile %s", name) {
+			info, err := os.Stat(files[0])
+			require.NoError(t, err)
+			assert.NotZero(t, info.Size())
+		}
+	}
+}
+
+func TestProfilingSignal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profiles")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := newFakeKeycloakConfig()
+	cfg.ProfilesDir = dir
+	p := newFakeProxy(cfg)
+	p.proxy.watchProfilingSignal()
+	defer p.proxy.stopProfilingSignal()
+
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGUSR1))
+	assert.Eventually(t, func() bool {
+		files, _ := filepath.Glob(filepath.Join(dir, "mutex-*.pprof"))
+		return len(files) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
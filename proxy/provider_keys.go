@@ -0,0 +1,226 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/jose"
+	"github.com/coreos/go-oidc/key"
+	"github.com/coreos/go-oidc/oidc"
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+)
+
+// providerFetches shares the fetches in flight of the discovery and key set documents, by url, between the proxies
+var providerFetches singleflight.Group
+
+const (
+	// keysSyncWindow is the minimum interval between two fetches of the provider keys
+	keysSyncWindow = 5 * time.Second
+	// keysRetryInterval is the interval between two attempts to prefetch the provider keys after a failure
+	keysRetryInterval = 10 * time.Second
+)
+
+// sharedKeySetRepo fetches the key set of an endpoint, sharing the fetches in flight with the other proxies of the
+// endpoint, e.g. the realms of a same provider
+type sharedKeySetRepo struct {
+	endpoint string
+	repo     key.ReadableKeySetRepo
+}
+
+// newSharedKeySetRepo returns the repository of the keys published at an endpoint
+func newSharedKeySetRepo(hc *http.Client, endpoint string) key.ReadableKeySetRepo {
+	return &sharedKeySetRepo{endpoint: endpoint, repo: oidc.NewRemotePublicKeyRepo(hc, endpoint)}
+}
+
+// Get implements the key.ReadableKeySetRepo interface
+func (s *sharedKeySetRepo) Get() (key.KeySet, error) {
+	shared, err, _ := providerFetches.Do("keys "+s.endpoint, func() (interface{}, error) {
+		return s.repo.Get()
+	})
+	if err != nil {
+		return nil, err
+	}
+	ks, _ := shared.(key.KeySet)
+
+	return ks, nil
+}
+
+// providerKeys caches the signing keys published by the provider. Concurrent fetches are coalesced into
+// a single call, and the keys are refreshed in the background ahead of their expiry, so a cache miss under
+// load does not translate into a burst of calls to the provider.
+type providerKeys struct {
+	sync.RWMutex
+	repo      key.ReadableKeySetRepo
+	keys      *key.PublicKeySet
+	fetchedAt time.Time
+	lastSync  time.Time
+	lastErr   error
+	fetches   singleflight.Group
+	log       *zap.Logger
+	done      chan struct{}
+	stopped   sync.Once
+}
+
+func newProviderKeys(repo key.ReadableKeySetRepo, log *zap.Logger) *providerKeys {
+	return &providerKeys{
+		repo: repo,
+		log:  log,
+		done: make(chan struct{}),
+	}
+}
+
+// get returns the unexpired keys, restricted to the key ID when specified
+func (k *providerKeys) get(kid string) []key.PublicKey {
+	k.RLock()
+	defer k.RUnlock()
+	if k.keys == nil || k.keys.ExpiresAt().Before(time.Now()) {
+		return []key.PublicKey{}
+	}
+	if kid == "" {
+		return k.keys.Keys()
+	}
+	if pk := k.keys.Key(kid); pk != nil {
+		return []key.PublicKey{*pk}
+	}
+
+	return []key.PublicKey{}
+}
+
+// sync fetches the keys from the provider, unless this has been attempted very recently.
+// Concurrent calls wait on a single fetch.
+func (k *providerKeys) sync() error {
+	_, err, _ := k.fetches.Do("keys", func() (interface{}, error) {
+		k.RLock()
+		recent := time.Since(k.lastSync) < keysSyncWindow
+		k.RUnlock()
+		if recent {
+			return nil, nil
+		}
+
+		return nil, k.fetch()
+	})
+
+	return err
+}
+
+// reload fetches the keys from the provider regardless of the last fetch, e.g. after a key rotation,
+// and returns the number of keys retrieved
+func (k *providerKeys) reload() (int, error) {
+	_, err, _ := k.fetches.Do("reload", func() (interface{}, error) {
+		return nil, k.fetch()
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(k.get("")), nil
+}
+
+// setRepo changes the location the keys are fetched from
+func (k *providerKeys) setRepo(repo key.ReadableKeySetRepo) {
+	k.Lock()
+	defer k.Unlock()
+	k.repo = repo
+}
+
+func (k *providerKeys) fetch() error {
+	k.RLock()
+	repo := k.repo
+	k.RUnlock()
+
+	start := time.Now()
+	ks, err := repo.Get()
+	oauthLatencyMetric.WithLabelValues("keys").Observe(time.Since(start).Seconds())
+
+	k.Lock()
+	defer k.Unlock()
+	k.lastSync = time.Now()
+	k.lastErr = err
+	if err != nil {
+		return err
+	}
+	keys, ok := ks.(*key.PublicKeySet)
+	if !ok {
+		k.lastErr = errors.New("unexpected key set returned by the provider")
+		return k.lastErr
+	}
+	k.keys = keys
+	k.fetchedAt = k.lastSync
+
+	return nil
+}
+
+// nextSync returns the delay before the keys should be prefetched, i.e. when three quarters of their
+// lifetime has elapsed
+func (k *providerKeys) nextSync() time.Duration {
+	k.RLock()
+	defer k.RUnlock()
+	if k.keys == nil || k.lastErr != nil {
+		return keysRetryInterval
+	}
+	lifetime := k.keys.ExpiresAt().Sub(k.fetchedAt)
+	next := time.Until(k.fetchedAt.Add(lifetime * 3 / 4))
+	if next < keysSyncWindow {
+		next = keysSyncWindow
+	}
+
+	return next
+}
+
+// prefetch keeps the keys fresh in the background, until stopped
+func (k *providerKeys) prefetch() {
+	for {
+		if err := k.sync(); err != nil {
+			k.log.Warn("unable to retrieve the provider keys", zap.Error(err))
+		}
+		select {
+		case <-time.After(k.nextSync()):
+		case <-k.done:
+			return
+		}
+	}
+}
+
+// stop ends the prefetching of the keys
+func (k *providerKeys) stop() {
+	k.stopped.Do(func() { close(k.done) })
+}
+
+// verifySignature checks the signature of a token against the provider keys, fetching the keys
+// again when none matches, e.g. after a key rotation
+func (k *providerKeys) verifySignature(token jose.JWT) error {
+	kid, _ := token.KeyID()
+	if ok, err := oidc.VerifySignature(token, k.get(kid)); err != nil || ok {
+		return err
+	}
+	if err := k.sync(); err != nil {
+		return err
+	}
+	ok, err := oidc.VerifySignature(token, k.get(kid))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("invalid signature")
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-oidc/jose"
+	"github.com/coreos/go-oidc/key"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type fakeKeySetRepo struct {
+	calls int32
+	delay time.Duration
+	jwk   jose.JWK
+	ttl   time.Duration
+	err   error
+}
+
+func (f *fakeKeySetRepo) Get() (key.KeySet, error) {
+	atomic.AddInt32(&f.calls, 1)
+	time.Sleep(f.delay)
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	return key.NewPublicKeySet([]jose.JWK{f.jwk}, time.Now().Add(f.ttl)), nil
+}
+
+func TestProviderKeysSync(t *testing.T) {
+	idp := newFakeAuthServer()
+	repo := &fakeKeySetRepo{delay: 50 * time.Millisecond, jwk: idp.key, ttl: time.Hour}
+	keys := newProviderKeys(repo, zap.NewNop())
+	assert.Empty(t, keys.get(""))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, keys.sync())
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&repo.calls))
+	assert.Len(t, keys.get(""), 1)
+	assert.Len(t, keys.get(idp.key.ID), 1)
+	assert.Empty(t, keys.get("unknown"))
+
+	// keys are not fetched again within the sync window
+	assert.NoError(t, keys.sync())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&repo.calls))
+
+	// unless a reload is forced
+	count, err := keys.reload()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&repo.calls))
+
+	// keys are prefetched ahead of their expiry
+	next := keys.nextSync()
+	assert.True(t, next > 44*time.Minute && next <= 45*time.Minute, "unexpected next sync: %s", next)
+
+	// failures are retried sooner
+	keys.lastSync = time.Time{}
+	repo.err = errors.New("unavailable")
+	assert.Error(t, keys.sync())
+	assert.Equal(t, keysRetryInterval, keys.nextSync())
+	assert.Len(t, keys.get(""), 1)
+}
+
+func TestProviderKeysVerifySignature(t *testing.T) {
+	idp := newFakeAuthServer()
+	repo := &fakeKeySetRepo{jwk: idp.key, ttl: time.Hour}
+	keys := newProviderKeys(repo, zap.NewNop())
+
+	token, err := idp.signToken(newTestToken(idp.getLocation()).claims)
+	require.NoError(t, err)
+
+	// the keys are fetched on first use
+	assert.NoError(t, keys.verifySignature(*token))
+	assert.NoError(t, keys.verifySignature(*token))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&repo.calls))
+
+	unsigned, err := jose.NewJWT(jose.JOSEHeader{"alg": "RS256"}, newTestToken(idp.getLocation()).claims)
+	require.NoError(t, err)
+	assert.Error(t, keys.verifySignature(unsigned))
+}
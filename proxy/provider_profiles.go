@@ -0,0 +1,217 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/coreos/go-oidc/jose"
+	"github.com/coreos/go-oidc/oidc"
+)
+
+const (
+	providerKeycloak = "keycloak"
+	providerOIDC     = "oidc"
+	providerAzureAD  = "azure-ad"
+	providerOkta     = "okta"
+	providerAuth0    = "auth0"
+
+	// tenantPlaceholder is the tenant in the issuer of the multi-tenant discovery of Azure AD
+	tenantPlaceholder = "{tenantid}"
+	// claimTenantID is the tenant of the user in Azure AD tokens
+	claimTenantID = "tid"
+)
+
+// providerProfile captures the conventions of an identity provider, where they depart from keycloak
+type providerProfile struct {
+	// roleClaims and groupClaims locate the roles and groups, unless configured
+	roleClaims  []string
+	groupClaims []string
+	// idTokenSession uses the ID token as the session token, the access tokens being meant for other audiences
+	idTokenSession bool
+	// tenantIssuer accepts a discovery issuer with a tenant placeholder, resolved from the tenant claim of the tokens
+	// among the allowed tenants
+	tenantIssuer bool
+	// logoutURL builds the url logging out the user from the provider, back to the redirection url
+	logoutURL func(r *oauthProxy, user *userContext, redirect string) (string, error)
+}
+
+// providerProfiles are the supported identity providers
+var providerProfiles = map[string]*providerProfile{
+	providerKeycloak: {
+		logoutURL: keycloakLogoutURL,
+	},
+	providerOIDC: {
+		roleClaims:     []string{"roles"},
+		groupClaims:    []string{claimGroups},
+		idTokenSession: true,
+		logoutURL:      endSessionLogoutURL,
+	},
+	providerAzureAD: {
+		roleClaims:     []string{"roles"},
+		groupClaims:    []string{claimGroups},
+		idTokenSession: true,
+		tenantIssuer:   true,
+		logoutURL:      endSessionLogoutURL,
+	},
+	providerOkta: {
+		roleClaims:     []string{"roles"},
+		groupClaims:    []string{claimGroups},
+		idTokenSession: true,
+		logoutURL:      endSessionLogoutURL,
+	},
+	providerAuth0: {
+		roleClaims:     []string{"roles"},
+		groupClaims:    []string{claimGroups},
+		idTokenSession: true,
+		logoutURL:      auth0LogoutURL,
+	},
+}
+
+// providerNames returns the sorted names of the provider profiles
+func providerNames() []string {
+	names := make([]string, 0, len(providerProfiles))
+	for name := range providerProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// getProviderProfile returns the conventions of the configured identity provider
+func getProviderProfile(name string) *providerProfile {
+	if profile, found := providerProfiles[name]; found {
+		return profile
+	}
+
+	return providerProfiles[providerKeycloak]
+}
+
+// keycloakLogoutURL redirects to the logout endpoint of the keycloak realm
+func keycloakLogoutURL(r *oauthProxy, _ *userContext, redirect string) (string, error) {
+	sendTo := fmt.Sprintf("%s/protocol/openid-connect/logout", strings.TrimSuffix(r.config.DiscoveryURL, "/.well-known/openid-configuration"))
+
+	return fmt.Sprintf("%s?redirect_uri=%s", sendTo, url.QueryEscape(redirect)), nil
+}
+
+// endSessionLogoutURL redirects to the end session endpoint of the provider (OpenID Connect RP-initiated logout)
+func endSessionLogoutURL(r *oauthProxy, user *userContext, redirect string) (string, error) {
+	idp := r.getProviderConfig()
+	if idp.EndSessionEndpoint == nil {
+		return "", errors.New("the provider does not advertise any end session endpoint")
+	}
+	params := url.Values{}
+	params.Set("post_logout_redirect_uri", redirect)
+	params.Set("client_id", r.config.ClientID)
+	if r.profile.idTokenSession && user != nil {
+		params.Set("id_token_hint", user.token.Encode())
+	}
+	endpoint := *idp.EndSessionEndpoint
+	if endpoint.RawQuery != "" {
+		endpoint.RawQuery += "&"
+	}
+	endpoint.RawQuery += params.Encode()
+
+	return endpoint.String(), nil
+}
+
+// auth0LogoutURL redirects to the logout endpoint of the auth0 tenant
+func auth0LogoutURL(r *oauthProxy, _ *userContext, redirect string) (string, error) {
+	idp := r.getProviderConfig()
+	if idp.Issuer == nil {
+		return "", errors.New("the provider has no issuer")
+	}
+	params := url.Values{}
+	params.Set("client_id", r.config.ClientID)
+	params.Set("returnTo", redirect)
+
+	return fmt.Sprintf("%s://%s/v2/logout?%s", idp.Issuer.Scheme, idp.Issuer.Host, params.Encode()), nil
+}
+
+// fetchProviderConfig retrieves the provider configuration from the discovery url. The discoveries in flight are
+// shared, e.g. by the realms of a same provider.
+func (r *oauthProxy) fetchProviderConfig(hc *http.Client) (oidc.ProviderConfig, error) {
+	shared, err, _ := providerFetches.Do(fmt.Sprintf("discovery %t %s", r.profile.tenantIssuer, r.config.DiscoveryURL), func() (interface{}, error) {
+		return r.discoverProvider(hc)
+	})
+	config, _ := shared.(oidc.ProviderConfig)
+
+	return config, err
+}
+
+// discoverProvider retrieves the provider configuration from the discovery url
+func (r *oauthProxy) discoverProvider(hc *http.Client) (oidc.ProviderConfig, error) {
+	if !r.profile.tenantIssuer {
+		return oidc.FetchProviderConfig(hc, r.config.DiscoveryURL)
+	}
+
+	// the multi-tenant discovery advertises a templated issuer, unlike the url it is served from
+	var config oidc.ProviderConfig
+	resp, err := hc.Get(strings.TrimSuffix(r.config.DiscoveryURL, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return config, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return config, fmt.Errorf("the discovery responded with %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&config); err != nil {
+		return config, err
+	}
+	if config.Issuer == nil {
+		return config, errors.New("the provider configuration has no issuer")
+	}
+	discovery, err := url.Parse(r.config.DiscoveryURL)
+	if err != nil {
+		return config, err
+	}
+	if !strings.EqualFold(config.Issuer.Host, discovery.Host) {
+		return config, fmt.Errorf("the issuer %s does not match the discovery url %s", config.Issuer, r.config.DiscoveryURL)
+	}
+
+	return config, nil
+}
+
+// expectedIssuer returns the issuer a token must come from, resolving the tenant of templated issuers
+func (r *oauthProxy) expectedIssuer(token jose.JWT) (string, error) {
+	issuer := r.getProviderConfig().Issuer.String()
+	// the placeholder is escaped in the parsed url
+	issuer = strings.Replace(issuer, url.PathEscape(tenantPlaceholder), tenantPlaceholder, 1)
+	if !r.profile.tenantIssuer || !strings.Contains(issuer, tenantPlaceholder) {
+		return issuer, nil
+	}
+	claims, err := token.Claims()
+	if err != nil {
+		return "", err
+	}
+	tenant, found, err := claims.StringClaim(claimTenantID)
+	if err != nil || !found || tenant == "" || strings.ContainsAny(tenant, "/?#") {
+		return "", errors.New("the token has no valid tenant claim")
+	}
+	// the tenant is claimed by the token itself, any tenant of the provider issuing tokens matching their issuer
+	if !containsString(tenant, r.config.AllowedTenants) {
+		return "", fmt.Errorf("the tenant %s is not allowed", tenant)
+	}
+
+	return strings.Replace(issuer, tenantPlaceholder, tenant, 1), nil
+}
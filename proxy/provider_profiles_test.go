@@ -0,0 +1,231 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-oidc/jose"
+	"github.com/coreos/go-oidc/key"
+	"github.com/oneconcern/keycloak-gatekeeper/gatekeepertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProviderLogoutURL(t *testing.T) {
+	cs := []struct {
+		Provider string
+		Path     string
+		Params   map[string]string
+	}{
+		{
+			Provider: providerKeycloak,
+			Path:     "/auth/realms/hod-test/protocol/openid-connect/logout",
+			Params:   map[string]string{"redirect_uri": "http://example.com"},
+		},
+		{
+			Provider: providerOIDC,
+			Path:     "/auth/realms/hod-test/protocol/openid-connect/logout",
+			Params: map[string]string{
+				"post_logout_redirect_uri": "http://example.com",
+				"client_id":                fakeClientID,
+			},
+		},
+		{
+			Provider: providerAuth0,
+			Path:     "/v2/logout",
+			Params: map[string]string{
+				"returnTo":  "http://example.com",
+				"client_id": fakeClientID,
+			},
+		},
+	}
+	for i, c := range cs {
+		cfg := newFakeKeycloakConfig()
+		cfg.Provider = c.Provider
+		p := newFakeProxy(cfg)
+		user, err := p.proxy.identities.extractIdentity(newTestToken(p.idp.getLocation()).getToken())
+		require.NoError(t, err)
+
+		location, err := p.proxy.profile.logoutURL(p.proxy, user, "http://example.com")
+		require.NoError(t, err, "case %d", i)
+		u, err := url.Parse(location)
+		require.NoError(t, err)
+		assert.Equal(t, c.Path, u.Path, "case %d", i)
+		for k, v := range c.Params {
+			assert.Equal(t, v, u.Query().Get(k), "case %d, param %s", i, k)
+		}
+		// the session token is the ID token of the providers other than keycloak
+		assert.Equal(t, c.Provider == providerOIDC, u.Query().Get("id_token_hint") != "", "case %d", i)
+	}
+}
+
+func TestProviderLogoutRedirect(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.Provider = providerOkta
+	cfg.EnableLogoutRedirect = true
+	newFakeProxy(cfg).RunTests(t, []fakeRequest{
+		{
+			URI:              cfg.WithOAuthURI(logoutURL),
+			HasToken:         true,
+			ExpectedCode:     http.StatusTemporaryRedirect,
+			ExpectedLocation: "/auth/realms/hod-test/protocol/openid-connect/logout?client_id=" + fakeClientID + "&id_token_hint=",
+		},
+	})
+}
+
+func TestProviderDefaultClaims(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.Provider = providerAzureAD
+	cfg.Resources = []*Resource{
+		{URL: "/admin/*", Methods: allHTTPMethods, Roles: []string{"admin"}},
+	}
+	newFakeProxy(cfg).RunTests(t, []fakeRequest{
+		{
+			URI:           "/admin/test",
+			HasToken:      true,
+			TokenClaims:   jose.Claims{"roles": []string{"admin"}},
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+		},
+		{
+			URI:          "/admin/test",
+			HasToken:     true,
+			Roles:        []string{"admin"},
+			ExpectedCode: http.StatusForbidden,
+		},
+	})
+}
+
+func TestTenantIssuer(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.Provider = providerAzureAD
+	cfg.AllowedTenants = []string{"hod-test"}
+	p := newFakeProxy(cfg)
+
+	// the multi-tenant discovery advertises a templated issuer
+	idp := p.proxy.getProviderConfig()
+	templated, err := url.Parse(strings.Replace(idp.Issuer.String(), "hod-test", tenantPlaceholder, 1))
+	require.NoError(t, err)
+	idp.Issuer = templated
+	p.proxy.setProviderConfig(idp)
+
+	p.RunTests(t, []fakeRequest{
+		{
+			URI:           fakeAuthAllURL + "/test",
+			HasToken:      true,
+			TokenClaims:   jose.Claims{claimTenantID: "hod-test"},
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+		},
+		{
+			URI:          fakeAuthAllURL + "/test",
+			HasToken:     true,
+			TokenClaims:  jose.Claims{claimTenantID: "other"},
+			ExpectedCode: http.StatusForbidden,
+		},
+		{
+			// the tokens of the other tenants are refused, whatever their issuer
+			URI:      fakeAuthAllURL + "/test",
+			HasToken: true,
+			TokenClaims: jose.Claims{
+				claimTenantID: "other",
+				"iss":         strings.Replace(p.idp.getLocation(), "hod-test", "other", 1),
+			},
+			ExpectedCode: http.StatusForbidden,
+		},
+		{
+			URI:          fakeAuthAllURL + "/test",
+			HasToken:     true,
+			ExpectedCode: http.StatusForbidden,
+		},
+	})
+}
+
+func TestFetchTenantProviderConfig(t *testing.T) {
+	var issuer string
+	discovery := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", jsonMime)
+		fmt.Fprintf(w, `{"issuer":"%s","authorization_endpoint":"%s/authorize","token_endpoint":"%s/token",
+"jwks_uri":"%s/keys","response_types_supported":["code"],"subject_types_supported":["pairwise"],
+"id_token_signing_alg_values_supported":["RS256"]}`, issuer, issuer, issuer, issuer)
+	}))
+	defer discovery.Close()
+
+	p := &oauthProxy{
+		config:  &Config{DiscoveryURL: discovery.URL + "/common/v2.0"},
+		profile: providerProfiles[providerAzureAD],
+	}
+	issuer = discovery.URL + "/" + tenantPlaceholder + "/v2.0"
+	config, err := p.fetchProviderConfig(http.DefaultClient)
+	require.NoError(t, err)
+	p.idp = config
+	p.config.AllowedTenants = []string{"6a1e2ac3"}
+	token := newTestToken(discovery.URL)
+	token.merge(jose.Claims{claimTenantID: "6a1e2ac3"})
+	expected, err := p.expectedIssuer(token.getToken())
+	require.NoError(t, err)
+	assert.Equal(t, discovery.URL+"/6a1e2ac3/v2.0", expected)
+
+	// the issuer must be served by the provider
+	issuer = "https://login.example.com/" + tenantPlaceholder + "/v2.0"
+	_, err = p.fetchProviderConfig(http.DefaultClient)
+	assert.Error(t, err)
+
+	// other providers check the issuer matches the discovery url
+	p.profile = providerProfiles[providerOIDC]
+	issuer = discovery.URL + "/" + tenantPlaceholder + "/v2.0"
+	_, err = p.fetchProviderConfig(http.DefaultClient)
+	assert.Error(t, err)
+}
+
+func TestFetchProviderShared(t *testing.T) {
+	auth := newFakeAuthServer()
+	defer auth.Close()
+	auth.Fail(gatekeepertest.DiscoveryEndpoint, gatekeepertest.Failure{Delay: 100 * time.Millisecond})
+	auth.Fail(gatekeepertest.KeysEndpoint, gatekeepertest.Failure{Delay: 100 * time.Millisecond})
+
+	// the realms of a same provider discover it and fetch its keys concurrently
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p := &oauthProxy{
+				config:  &Config{DiscoveryURL: auth.getLocation()},
+				profile: providerProfiles[providerOIDC],
+			}
+			config, err := p.fetchProviderConfig(http.DefaultClient)
+			if !assert.NoError(t, err) {
+				return
+			}
+			keys, err := newSharedKeySetRepo(http.DefaultClient, config.KeysEndpoint.String()).Get()
+			if assert.NoError(t, err) {
+				assert.NotEmpty(t, keys.(*key.PublicKeySet).Keys())
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, auth.Requests(gatekeepertest.DiscoveryEndpoint))
+	assert.Equal(t, 1, auth.Requests(gatekeepertest.KeysEndpoint))
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/coreos/go-oidc/oidc"
+	"go.uber.org/zap"
+)
+
+// providerRetryInterval is the interval between two attempts to retrieve the provider configuration
+var providerRetryInterval = 3 * time.Second
+
+// errProviderDiscovery indicates the provider configuration could not be retrieved in time
+var errProviderDiscovery = errors.New("failed to retrieve the provider configuration from discovery url")
+
+// providerTransport reports the gateway errors of the provider as transport errors: the token
+// responses would otherwise lose their status and be taken for a rejected grant
+type providerTransport struct {
+	http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (t *providerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("%w: %s responded with %s", ErrProviderUnavailable, req.URL.Host, resp.Status)
+	}
+
+	return resp, nil
+}
+
+// isProviderUnavailable checks if an error of a provider request is due to the provider being unreachable,
+// rather than to the provider refusing the request
+func isProviderUnavailable(err error) bool {
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// isProviderReady checks the provider configuration has been retrieved
+func (r *oauthProxy) isProviderReady() bool {
+	return atomic.LoadInt32(&r.providerPending) == 0
+}
+
+// providerMiddleware responds the provider is unavailable until its configuration has been retrieved
+func (r *oauthProxy) providerMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !r.isProviderReady() {
+			r.providerUnavailable(w, req, "discovery", errProviderDiscovery)
+			r.revokeProxy(w, req)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// providerUnavailable responds the provider cannot be reached, advising the client when to retry
+func (r *oauthProxy) providerUnavailable(w http.ResponseWriter, req *http.Request, action string, err error) {
+	_, logger := r.traceSpanRequest(req)
+
+	logger.Warn("the openid provider is unavailable", zap.String("action", action), zap.Error(err))
+	providerUnavailableMetric.WithLabelValues(action).Inc()
+	if r.config.OpenIDProviderRetryAfter > 0 {
+		w.Header().Set(headerRetryAfter, strconv.Itoa(int(math.Ceil(r.config.OpenIDProviderRetryAfter.Seconds()))))
+	}
+	r.errorPageResponse(w, req, r.config.ProviderUnavailablePage, "identity provider unavailable", http.StatusServiceUnavailable)
+}
+
+// awaitProvider retries the provider discovery in the background, until the configuration is retrieved or the
+// proxy is closed
+func (r *oauthProxy) awaitProvider(interval time.Duration) {
+	for {
+		select {
+		case <-time.After(interval):
+		case <-r.providerDone:
+			return
+		}
+		idp, err := r.fetchProviderConfig(r.idpClient)
+		if err == nil {
+			var client *oidc.Client
+			if client, err = r.newProviderClient(r.idpClient, idp); err == nil {
+				if r.setProvider(client, idp) {
+					r.log.Info("successfully retrieved openid configuration from the discovery")
+				}
+				return
+			}
+		}
+		r.log.Warn("failed to get provider configuration from discovery", zap.Error(err))
+	}
+}
+
+// setProvider swaps in the client, configuration and keys of the provider discovered in the background, unless
+// the proxy has been closed meanwhile
+func (r *oauthProxy) setProvider(client *oidc.Client, idp oidc.ProviderConfig) bool {
+	r.idpLock.Lock()
+	defer r.idpLock.Unlock()
+	select {
+	case <-r.providerDone:
+		return false
+	default:
+	}
+	r.client, r.idp = client, idp
+	r.keys = r.newProviderKeys(idp)
+	atomic.StoreInt32(&r.providerPending, 0)
+
+	return true
+}
+
+// useProviderKeys verifies the tokens with the keys of the provider, which are kept fresh in the background
+func (r *oauthProxy) useProviderKeys() {
+	keys := r.newProviderKeys(r.getProviderConfig())
+	r.idpLock.Lock()
+	defer r.idpLock.Unlock()
+	r.keys = keys
+}
+
+// newProviderKeys returns the keys published by a provider, if any, prefetched in the background
+func (r *oauthProxy) newProviderKeys(idp oidc.ProviderConfig) *providerKeys {
+	if idp.KeysEndpoint == nil {
+		return nil
+	}
+	keys := newProviderKeys(newSharedKeySetRepo(r.idpClient, idp.KeysEndpoint.String()), r.log)
+	go keys.prefetch()
+
+	return keys
+}
+
+// stopProvider stops the provider discovery retried in the background, and the refresh of the keys
+func (r *oauthProxy) stopProvider() {
+	r.idpLock.Lock()
+	defer r.idpLock.Unlock()
+	if r.providerDone != nil {
+		select {
+		case <-r.providerDone:
+		default:
+			close(r.providerDone)
+		}
+	}
+	if r.keys != nil {
+		r.keys.stop()
+	}
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	resty "gopkg.in/resty.v1"
+)
+
+func TestProviderTransport(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer idp.Close()
+	client := &http.Client{Transport: &providerTransport{http.DefaultTransport}}
+
+	for _, code := range []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout} {
+		status = code
+		_, err := client.Get(idp.URL)
+		assert.True(t, errors.Is(err, ErrProviderUnavailable), "status %d", code)
+		assert.True(t, isProviderUnavailable(err), "status %d", code)
+	}
+
+	// the other errors of the provider are its own answer
+	status = http.StatusInternalServerError
+	resp, err := client.Get(idp.URL)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+
+	idp.Close()
+	_, err = client.Get(idp.URL)
+	assert.True(t, isProviderUnavailable(err))
+	assert.False(t, isProviderUnavailable(errors.New("invalid_grant")))
+	assert.False(t, isProviderUnavailable(nil))
+}
+
+func TestProviderUnavailable(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.EnableRefreshTokens = true
+	cfg.EncryptionKey = testKey
+	cfg.OpenIDProviderRetryAfter = 5 * time.Second
+	p := newFakeProxy(cfg)
+	p.idp.setTokenExpiration(1000 * time.Millisecond)
+
+	unavailable := func(no int, req *resty.Request, resp *resty.Response) {
+		if no == 0 {
+			<-time.After(1000 * time.Millisecond)
+		}
+		p.idp.setUnavailable(true)
+	}
+	requests := []fakeRequest{
+		{
+			URI:           fakeAuthAllURL,
+			HasLogin:      true,
+			Redirects:     true,
+			OnResponse:    unavailable,
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+		},
+		{
+			// the expired access token cannot be refreshed
+			URI:                     fakeAuthAllURL,
+			ExpectedCode:            http.StatusServiceUnavailable,
+			ExpectedHeaders:         map[string]string{headerRetryAfter: "5"},
+			ExpectedContentContains: "identity provider unavailable",
+		},
+		{
+			URI:             cfg.WithOAuthURI(callbackURL) + "?code=fake",
+			ExpectedCode:    http.StatusServiceUnavailable,
+			ExpectedHeaders: map[string]string{headerRetryAfter: "5"},
+		},
+		{
+			URI:          cfg.WithOAuthURI(loginURL),
+			Method:       http.MethodPost,
+			FormValues:   map[string]string{"username": "test", "password": "test"},
+			ExpectedCode: http.StatusServiceUnavailable,
+		},
+		{
+			URI:           fakeTestWhitelistedURL,
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+		},
+	}
+	p.RunTests(t, requests)
+}
+
+func TestStartWithoutProvider(t *testing.T) {
+	defer func(interval time.Duration) { providerRetryInterval = interval }(providerRetryInterval)
+	providerRetryInterval = 10 * time.Millisecond
+
+	auth := newFakeAuthServer().setUnavailable(true)
+	defer auth.Close()
+	cfg := newFakeKeycloakConfig()
+	cfg.DiscoveryURL = auth.getLocation()
+	cfg.OpenIDProviderTimeout = 100 * time.Millisecond
+	cfg.OpenIDProviderRetryAfter = time.Second
+
+	_, err := newProxy(cfg)
+	assert.Equal(t, errProviderDiscovery, err)
+
+	cfg.EnableStartWithoutProvider = true
+	proxy, err := newProxy(cfg)
+	require.NoError(t, err)
+	proxy.upstream = &fakeUpstreamService{}
+	svc := httptest.NewServer(proxy.router)
+	defer svc.Close()
+
+	token, err := auth.signToken(newTestToken(auth.getLocation()).claims)
+	require.NoError(t, err)
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	get := func(uri string, authenticated bool) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, svc.URL+uri, nil)
+		require.NoError(t, err)
+		if authenticated {
+			req.Header.Set(authorizationHeader, "Bearer "+token.Encode())
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		return resp
+	}
+
+	// the whitelisted resources are served while the discovery is retried
+	assert.Equal(t, http.StatusOK, get("/auth_all/white_listed/test", false).StatusCode)
+	resp := get(fakeAuthAllURL+"/test", true)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, "1", resp.Header.Get(headerRetryAfter))
+	assert.Equal(t, http.StatusServiceUnavailable, get(cfg.WithOAuthURI(authorizationURL), false).StatusCode)
+	assert.Equal(t, http.StatusOK, get(cfg.WithOAuthURI(healthURL), false).StatusCode)
+
+	auth.setUnavailable(false)
+	assert.Eventually(t, proxy.isProviderReady, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, http.StatusOK, get(fakeAuthAllURL+"/test", true).StatusCode)
+	resp = get(cfg.WithOAuthURI(authorizationURL), false)
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Location"), auth.getLocation())
+}
+
+func TestStartWithoutProviderClosed(t *testing.T) {
+	defer func(interval time.Duration) { providerRetryInterval = interval }(providerRetryInterval)
+	providerRetryInterval = 10 * time.Millisecond
+
+	auth := newFakeAuthServer().setUnavailable(true)
+	defer auth.Close()
+	cfg := newFakeKeycloakConfig()
+	cfg.DiscoveryURL = auth.getLocation()
+	cfg.OpenIDProviderTimeout = 100 * time.Millisecond
+	cfg.EnableStartWithoutProvider = true
+	proxy, err := newProxy(cfg)
+	require.NoError(t, err)
+
+	// the discovery is no longer retried once the proxy is closed
+	proxy.stopProvider()
+	auth.setUnavailable(false)
+	time.Sleep(20 * providerRetryInterval)
+	assert.False(t, proxy.isProviderReady())
+	assert.Nil(t, proxy.getProviderKeys())
+}
@@ -0,0 +1,321 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+)
+
+// realmName is the syntax of the realm names, used in the callback url and the cookies of the realms
+var realmName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// Realm is another openid provider or keycloak realm served by the proxy, selected per host or per resource. A realm
+// without a discovery url is a group of resources with their own sessions, on the provider of the proxy. A realm with
+// hostnames is a virtual host, serving an application with its own resources, upstream and cookies.
+type Realm struct {
+	// Name identifies the realm in the resources, its callback url and its cookies
+	Name string `json:"name" yaml:"name"`
+	// DiscoveryURL is the discovery url of the openid provider of the realm, the one of the proxy by default
+	DiscoveryURL string `json:"discovery-url" yaml:"discovery-url"`
+	// ClientID is the client of the proxy in the realm, the one of the proxy by default
+	ClientID string `json:"client-id" yaml:"client-id"`
+	// ClientSecret is the secret of the client
+	ClientSecret string `json:"client-secret" yaml:"client-secret"`
+	// CookieAccessName is the name of the access cookie of the realm, suffixed by the name of the realm by default
+	CookieAccessName string `json:"cookie-access-name" yaml:"cookie-access-name"`
+	// CookieRefreshName is the name of the refresh cookie of the realm, suffixed by the name of the realm by default
+	CookieRefreshName string `json:"cookie-refresh-name" yaml:"cookie-refresh-name"`
+	// CookiePath is the path of the access and refresh cookies of the realm
+	CookiePath string `json:"cookie-path" yaml:"cookie-path"`
+	// CookieDomain is the domain of the cookies of the realm, the one of the proxy by default unless the realm has
+	// hostnames, its cookies being then restricted to the host header
+	CookieDomain string `json:"cookie-domain" yaml:"cookie-domain"`
+	// Upstream is the upstream of the realm, the one of the proxy by default
+	Upstream string `json:"upstream-url" yaml:"upstream-url"`
+	// RedirectionURL is the redirection url of the realm, taken from the host header by default
+	RedirectionURL string `json:"redirection-url" yaml:"redirection-url"`
+	// Hostnames are the hosts whose requests are all served by the realm
+	Hostnames []string `json:"hostnames" yaml:"hostnames"`
+}
+
+// parseRealm parses a realm from the command line, e.g. name=partners|discovery-url=...|client-id=...|hostnames=a,b
+func parseRealm(realm string) (*Realm, error) {
+	r := &Realm{}
+	for _, x := range strings.Split(realm, "|") {
+		kp := strings.SplitN(x, "=", 2)
+		if len(kp) != 2 {
+			return nil, errors.New("invalid realm keypair, should be (name|discovery-url|client-id|client-secret|redirection-url|cookie-access-name|cookie-refresh-name|cookie-path|cookie-domain|upstream-url|hostnames)=value")
+		}
+		switch kp[0] {
+		case "name":
+			r.Name = kp[1]
+		case "discovery-url":
+			r.DiscoveryURL = kp[1]
+		case "client-id":
+			r.ClientID = kp[1]
+		case "client-secret":
+			r.ClientSecret = kp[1]
+		case "redirection-url":
+			r.RedirectionURL = kp[1]
+		case "cookie-access-name":
+			r.CookieAccessName = kp[1]
+		case "cookie-refresh-name":
+			r.CookieRefreshName = kp[1]
+		case "cookie-path":
+			r.CookiePath = kp[1]
+		case "cookie-domain":
+			r.CookieDomain = kp[1]
+		case "upstream-url":
+			r.Upstream = kp[1]
+		case "hostnames":
+			r.Hostnames = strings.Split(kp[1], ",")
+		default:
+			return nil, fmt.Errorf("invalid realm keypair %s, should be (name|discovery-url|client-id|client-secret|redirection-url|cookie-access-name|cookie-refresh-name|cookie-path|cookie-domain|upstream-url|hostnames)=value", kp[0])
+		}
+	}
+
+	return r, nil
+}
+
+// isRealmsValid checks the realms are named, have a client on their own provider, do not share the cookies of
+// another realm, and are selected by some hosts or resources. The cookies of the virtual hosts are restricted to
+// their hosts, unless their domain is set.
+func (r *Config) isRealmsValid() error {
+	if len(r.Realms) > 0 && r.EnableForwarding {
+		return errors.New("the realms are served by the reverse proxy, not the forwarding proxy")
+	}
+	names := make(map[string]bool)
+	hosts := make(map[string]string)
+	cookies := map[string]string{
+		r.CookieAccessName + r.sessionCookiePath():  "the proxy",
+		r.CookieRefreshName + r.sessionCookiePath(): "the proxy",
+	}
+	for _, x := range r.Realms {
+		if !realmName.MatchString(x.Name) {
+			return fmt.Errorf("the realm name %q must be made of letters, digits, '-' and '_'", x.Name)
+		}
+		if names[x.Name] {
+			return fmt.Errorf("the realm %s is declared twice", x.Name)
+		}
+		names[x.Name] = true
+		if x.DiscoveryURL != "" {
+			if u, err := url.Parse(x.DiscoveryURL); err != nil || u.Scheme == "" || u.Host == "" {
+				return fmt.Errorf("the discovery url of the realm %s is not a valid URL: %s", x.Name, x.DiscoveryURL)
+			}
+			if x.ClientID == "" {
+				return fmt.Errorf("the realm %s requires a client id", x.Name)
+			}
+		}
+		if x.CookiePath != "" && !strings.HasPrefix(x.CookiePath, "/") {
+			return fmt.Errorf("the cookie path of the realm %s must be an absolute path", x.Name)
+		}
+		if x.Upstream != "" {
+			if u, err := url.Parse(x.Upstream); err != nil || u.Scheme == "" {
+				return fmt.Errorf("the upstream of the realm %s is not a valid URL: %s", x.Name, x.Upstream)
+			}
+		}
+		config := r.realmConfig(x)
+		if len(x.Hostnames) == 0 || config.CookieDomain != "" {
+			sessions := []string{config.CookieAccessName, config.CookieRefreshName}
+			for _, name := range sessions {
+				if other, found := cookies[name+config.sessionCookiePath()]; found {
+					return fmt.Errorf("the cookie %s is used by both the realm %s and %s", name, x.Name, other)
+				}
+			}
+			for _, name := range sessions {
+				cookies[name+config.sessionCookiePath()] = "the realm " + x.Name
+			}
+		}
+		if x.RedirectionURL != "" {
+			if u, err := url.Parse(x.RedirectionURL); err != nil || u.Scheme == "" || u.Host == "" {
+				return fmt.Errorf("the redirection url of the realm %s is not a valid URL: %s", x.Name, x.RedirectionURL)
+			}
+		}
+		for _, host := range x.Hostnames {
+			host = strings.ToLower(host)
+			if other, found := hosts[host]; found {
+				return fmt.Errorf("the host %s is served by both the realms %s and %s", host, other, x.Name)
+			}
+			hosts[host] = x.Name
+		}
+	}
+	selected := make(map[string]bool)
+	for _, x := range r.Resources {
+		if x.Realm == "" {
+			continue
+		}
+		if !names[x.Realm] {
+			return fmt.Errorf("the resource %s refers to the unknown realm %s", x.URL, x.Realm)
+		}
+		selected[x.Realm] = true
+	}
+	for _, x := range r.Realms {
+		if len(x.Hostnames) == 0 && !selected[x.Name] {
+			return fmt.Errorf("the realm %s is selected by no host nor resource", x.Name)
+		}
+	}
+
+	return nil
+}
+
+// realmConfig returns the configuration of a realm: the one of the proxy with the provider, client and upstream of
+// the realm, its callback url and cookies being namespaced by the name of the realm unless the cookies are named. The
+// realm serves its own resources, or else the resources of the proxy which are not served by any realm.
+func (r *Config) realmConfig(realm *Realm) *Config {
+	config := *r
+	config.Realms = nil
+	if realm.DiscoveryURL != "" {
+		config.DiscoveryURL = realm.DiscoveryURL
+		config.ClientID = realm.ClientID
+		config.ClientSecret = realm.ClientSecret
+		// the endpoints of the provider of the proxy are not the ones of the realm
+		config.RevocationEndpoint = ""
+		config.IntrospectionEndpoint = ""
+	}
+	config.RedirectionURL = realm.RedirectionURL
+	if len(realm.Hostnames) == 0 {
+		config.RedirectionURL = defaultTo(realm.RedirectionURL, r.RedirectionURL)
+	}
+	config.OAuthURI = path.Join(r.OAuthURI, realm.Name)
+	config.CookieAccessName = defaultTo(realm.CookieAccessName, r.CookieAccessName+"-"+realm.Name)
+	config.CookieRefreshName = defaultTo(realm.CookieRefreshName, r.CookieRefreshName+"-"+realm.Name)
+	config.CookiePath = defaultTo(realm.CookiePath, r.CookiePath)
+	config.CookieDomain = defaultTo(realm.CookieDomain, r.CookieDomain)
+	if len(realm.Hostnames) > 0 {
+		// the virtual hosts only answer to their own hosts, with cookies restricted to them by default
+		config.CookieDomain = realm.CookieDomain
+		config.EnableCrossSubdomainSession = r.EnableCrossSubdomainSession && realm.CookieDomain != ""
+		if len(r.Hostnames) > 0 {
+			config.Hostnames = realm.Hostnames
+		}
+	}
+	if realm.Upstream != "" {
+		config.Upstream = realm.Upstream
+		config.UpstreamURLs = nil
+	}
+
+	config.Resources = nil
+	for _, x := range r.Resources {
+		if x.Realm == realm.Name {
+			// the resource is served by the provider of the realm proxy
+			resource := *x
+			resource.Realm = ""
+			config.Resources = append(config.Resources, &resource)
+		}
+	}
+	if len(config.Resources) == 0 {
+		for _, x := range r.Resources {
+			if x.Realm == "" {
+				config.Resources = append(config.Resources, x)
+			}
+		}
+	}
+
+	return &config
+}
+
+// realmProxy is the proxy serving the requests of a realm, with its own openid client and router
+type realmProxy struct {
+	realm *Realm
+	proxy *oauthProxy
+}
+
+// realmRouter dispatches the requests to the realms, by host then by resource, the other requests being served
+// by the router of the proxy
+type realmRouter struct {
+	main   http.Handler
+	hosts  map[string]http.Handler
+	routes chi.Router
+}
+
+// createRealms creates the proxies of the realms, and routes the requests to them
+func (r *oauthProxy) createRealms() error {
+	router := &realmRouter{main: r.router, hosts: make(map[string]http.Handler), routes: chi.NewRouter()}
+	router.routes.NotFound(serveRealmRequest(r.router))
+	router.routes.MethodNotAllowed(serveRealmRequest(r.router))
+
+	for _, realm := range r.config.Realms {
+		config := r.config.realmConfig(realm)
+		r.log.Info("serving the realm", zap.String("realm", realm.Name),
+			zap.String("discovery_url", config.DiscoveryURL),
+			zap.String("client_id", config.ClientID),
+			zap.String("cookie_access_name", config.CookieAccessName),
+			zap.String("upstream", config.Upstream),
+			zap.Strings("hostnames", realm.Hostnames))
+		proxy, err := newProxy(config)
+		if err != nil {
+			return fmt.Errorf("unable to create the realm %s: %s", realm.Name, err)
+		}
+		r.realms = append(r.realms, &realmProxy{realm: realm, proxy: proxy})
+
+		for _, host := range realm.Hostnames {
+			router.hosts[strings.ToLower(host)] = proxy.router
+		}
+		// the resources of a virtual host are only served on its hosts
+		routed := make(map[string]bool)
+		for _, x := range r.config.Resources {
+			if x.Realm == realm.Name && len(realm.Hostnames) == 0 && !routed[x.URL] {
+				router.routes.Handle(x.URL, serveRealmRequest(proxy.router))
+				routed[x.URL] = true
+			}
+		}
+		if len(routed) > 0 {
+			router.routes.Handle(path.Join(config.BaseURI, config.OAuthURI)+"/*", serveRealmRequest(proxy.router))
+		}
+	}
+	r.router = router
+
+	return nil
+}
+
+// ServeHTTP dispatches a request to its realm, if any
+func (x *realmRouter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if handler, found := x.hosts[strings.ToLower(host)]; found {
+		handler.ServeHTTP(w, req)
+		return
+	}
+	// the realm is selected by the normalized path, the one matched by the resources of the proxies
+	routed := req.WithContext(context.WithValue(req.Context(), realmRequestKey{}, req))
+	u := *req.URL
+	normalizeURL(&u)
+	routed.URL = &u
+	x.routes.ServeHTTP(w, routed)
+}
+
+// realmRequestKey is the context key of the request dispatched to a realm, before the normalization of its path
+type realmRequestKey struct{}
+
+// serveRealmRequest serves the request dispatched to a realm as received
+func serveRealmRequest(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		next.ServeHTTP(w, req.Context().Value(realmRequestKey{}).(*http.Request))
+	}
+}
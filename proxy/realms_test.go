@@ -0,0 +1,315 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRealms(t *testing.T) {
+	main, partners := newFakeAuthServer(), newFakeAuthServer()
+	defer main.Close()
+	defer partners.Close()
+
+	cfg := newFakeKeycloakConfig()
+	cfg.DiscoveryURL = main.getLocation()
+	cfg.Realms = []*Realm{
+		{Name: "partners", DiscoveryURL: partners.getLocation(), ClientID: fakeClientID},
+		{Name: "tenant", DiscoveryURL: partners.getLocation(), ClientID: fakeClientID, Hostnames: []string{"tenant.example.com"}},
+	}
+	cfg.Resources = append(cfg.Resources, &Resource{URL: "/partners/*", Methods: allHTTPMethods, Realm: "partners"})
+	require.NoError(t, cfg.isRealmsValid())
+	p, err := newProxy(cfg)
+	require.NoError(t, err)
+	p.upstream = &fakeUpstreamService{}
+	require.Len(t, p.realms, 2)
+	for _, x := range p.realms {
+		x.proxy.upstream = &fakeUpstreamService{}
+	}
+	service := httptest.NewServer(p.router)
+	defer service.Close()
+
+	token := func(idp *fakeAuthServer) string {
+		signed, err := idp.signToken(newTestToken(idp.getLocation()).claims)
+		require.NoError(t, err)
+		return signed.Encode()
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	do := func(host, uri, token string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, service.URL+uri, nil)
+		require.NoError(t, err)
+		req.Host = host
+		if token != "" {
+			req.Header.Set(authorizationHeader, authorizationType+" "+token)
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		return resp
+	}
+
+	// the resources of a realm are authenticated by its provider
+	assert.Equal(t, http.StatusOK, do("", "/partners/docs", token(partners)).StatusCode)
+	assert.Equal(t, http.StatusForbidden, do("", "/partners/docs", token(main)).StatusCode)
+	// the realm is selected by the normalized path
+	assert.Equal(t, http.StatusForbidden, do("", "//partners/docs", token(main)).StatusCode)
+	assert.Equal(t, http.StatusForbidden, do("", "/x/../partners/docs", token(main)).StatusCode)
+	assert.Equal(t, http.StatusOK, do("", "//partners/docs", token(partners)).StatusCode)
+	resp := do("", "/partners/docs", "")
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+	assert.True(t, strings.HasPrefix(resp.Header.Get("Location"), "/oauth/partners/authorize"), resp.Header.Get("Location"))
+
+	// the other resources by the provider of the proxy
+	assert.Equal(t, http.StatusOK, do("", "/auth_all/test", token(main)).StatusCode)
+	assert.Equal(t, http.StatusForbidden, do("", "/auth_all/test", token(partners)).StatusCode)
+
+	// the hosts of a realm are served by the realm
+	assert.Equal(t, http.StatusOK, do("tenant.example.com", "/auth_all/test", token(partners)).StatusCode)
+	assert.Equal(t, http.StatusForbidden, do("Tenant.Example.com:443", "/auth_all/test", token(main)).StatusCode)
+}
+
+func TestRealmSessions(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.Realms = []*Realm{{Name: "reports", CookieAccessName: "reports-access", CookiePath: "/reports"}}
+	cfg.Resources = append(cfg.Resources, &Resource{URL: "/reports/*", Methods: allHTTPMethods, Realm: "reports"})
+	p := newFakeProxy(cfg)
+	defer func() {
+		p.idp.Close()
+		p.proxy.server.Close()
+	}()
+	require.Len(t, p.proxy.realms, 1)
+	realm := p.proxy.realms[0].proxy
+	realm.upstream = &fakeUpstreamService{}
+	assert.Equal(t, cfg.DiscoveryURL, realm.config.DiscoveryURL)
+	assert.Equal(t, fakeClientID, realm.config.ClientID)
+	assert.Equal(t, cfg.CookieRefreshName+"-reports", realm.config.CookieRefreshName)
+
+	signed, err := p.idp.signToken(newTestToken(p.idp.getLocation()).claims)
+	require.NoError(t, err)
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	do := func(uri, cookie string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, p.getServiceURL()+uri, nil)
+		require.NoError(t, err)
+		req.AddCookie(&http.Cookie{Name: cookie, Value: signed.Encode()})
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		return resp
+	}
+
+	// the sessions of the proxy and of the group of resources do not collide
+	assert.Equal(t, http.StatusOK, do("/reports/daily", "reports-access").StatusCode)
+	assert.Equal(t, http.StatusTemporaryRedirect, do("/reports/daily", cfg.CookieAccessName).StatusCode)
+	assert.Equal(t, http.StatusOK, do("/auth_all/test", cfg.CookieAccessName).StatusCode)
+	assert.Equal(t, http.StatusTemporaryRedirect, do("/auth_all/test", "reports-access").StatusCode)
+
+	resp := httptest.NewRecorder()
+	realm.dropAccessTokenCookie(newFakeHTTPRequest(http.MethodGet, "/reports/daily"), resp, "token", 0)
+	assert.Equal(t, "reports-access=token; Path=/reports; Domain=127.0.0.1", resp.Header().Get("Set-Cookie"))
+}
+
+func TestVirtualHosts(t *testing.T) {
+	upstream := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			_, _ = w.Write([]byte(name))
+		}))
+	}
+	main, reports := upstream("main"), upstream("reports")
+	defer main.Close()
+	defer reports.Close()
+	idp := newFakeAuthServer()
+	defer idp.Close()
+
+	cfg := newFakeKeycloakConfig()
+	cfg.DiscoveryURL = idp.getLocation()
+	cfg.Upstream = main.URL
+	cfg.CookieDomain = "example.com"
+	cfg.Hostnames = []string{"www.example.com"}
+	cfg.EnableSecurityFilter = true
+	cfg.Realms = []*Realm{{
+		Name:           "reports",
+		Upstream:       reports.URL,
+		RedirectionURL: "https://reports.example.net",
+		Hostnames:      []string{"reports.example.net"},
+	}}
+	cfg.Resources = append(cfg.Resources, &Resource{URL: "/daily/*", Methods: allHTTPMethods, Roles: []string{"reader"}, Realm: "reports"})
+	require.NoError(t, cfg.isRealmsValid())
+	p, err := newProxy(cfg)
+	require.NoError(t, err)
+	service := httptest.NewServer(p.router)
+	defer service.Close()
+
+	realm := p.realms[0].proxy.config
+	assert.Empty(t, realm.CookieDomain)
+	assert.Equal(t, []string{"reports.example.net"}, realm.Hostnames)
+	assert.Equal(t, "https://reports.example.net", realm.RedirectionURL)
+
+	signed, err := idp.signToken(newTestToken(idp.getLocation()).claims)
+	require.NoError(t, err)
+	do := func(host, uri string) (int, string) {
+		req, err := http.NewRequest(http.MethodGet, service.URL+uri, nil)
+		require.NoError(t, err)
+		req.Host = host
+		req.Header.Set(authorizationHeader, authorizationType+" "+signed.Encode())
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		content, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(content)
+	}
+
+	// each host is served by its own upstream, with its own resources
+	code, content := do("reports.example.net", "/auth_all/test")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "reports", content)
+	code, _ = do("reports.example.net", "/daily/sales")
+	assert.Equal(t, http.StatusForbidden, code)
+	code, content = do("www.example.com", "/auth_all/test")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "main", content)
+	code, content = do("www.example.com", "/daily/sales")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "main", content)
+	code, _ = do("other.example.com", "/auth_all/test")
+	assert.Equal(t, http.StatusInternalServerError, code)
+}
+
+func TestIsRealmsValid(t *testing.T) {
+	realm := func() *Realm {
+		return &Realm{Name: "partners", DiscoveryURL: "https://keycloak.example.com/auth/realms/partners", ClientID: "gatekeeper",
+			Hostnames: []string{"partners.example.com"}}
+	}
+	cs := []struct {
+		Config *Config
+		Error  string
+	}{
+		{Config: &Config{Realms: []*Realm{realm()}}},
+		{
+			Config: &Config{
+				Realms:    []*Realm{{Name: "partners", DiscoveryURL: realm().DiscoveryURL, ClientID: "gatekeeper"}},
+				Resources: []*Resource{{URL: "/partners/*", Realm: "partners"}},
+			},
+		},
+		{
+			Config: &Config{Realms: []*Realm{{Name: "partners", DiscoveryURL: realm().DiscoveryURL, ClientID: "gatekeeper"}}},
+			Error:  "the realm partners is selected by no host nor resource",
+		},
+		{
+			Config: &Config{Realms: []*Realm{realm(), realm()}},
+			Error:  "the realm partners is declared twice",
+		},
+		{
+			Config: &Config{Realms: []*Realm{{Name: "part/ners"}}},
+			Error:  `the realm name "part/ners" must be made of letters, digits, '-' and '_'`,
+		},
+		{
+			Config: &Config{Realms: []*Realm{{Name: "partners", DiscoveryURL: "keycloak", ClientID: "gatekeeper"}}},
+			Error:  "the discovery url of the realm partners is not a valid URL: keycloak",
+		},
+		{
+			Config: &Config{Realms: []*Realm{{Name: "partners", DiscoveryURL: realm().DiscoveryURL}}},
+			Error:  "the realm partners requires a client id",
+		},
+		{
+			Config: &Config{Realms: []*Realm{realm()}, Resources: []*Resource{{URL: "/docs/*", Realm: "unknown"}}},
+			Error:  "the resource /docs/* refers to the unknown realm unknown",
+		},
+		{
+			Config: &Config{Realms: []*Realm{realm(), {Name: "other", DiscoveryURL: realm().DiscoveryURL, ClientID: "gatekeeper",
+				Hostnames: []string{"PARTNERS.example.com"}}}},
+			Error: "the host partners.example.com is served by both the realms partners and other",
+		},
+		{
+			Config: &Config{Realms: []*Realm{{Name: "reports", Hostnames: []string{"reports.example.com"}}}},
+		},
+		{
+			Config: &Config{
+				CookieAccessName: accessCookie,
+				Realms:           []*Realm{{Name: "reports", CookieAccessName: accessCookie, CookiePath: "/reports", Hostnames: []string{"reports.example.com"}}},
+			},
+		},
+		{
+			Config: &Config{
+				CookieAccessName: accessCookie,
+				Realms:           []*Realm{{Name: "reports", CookieAccessName: accessCookie}},
+				Resources:        []*Resource{{URL: "/reports/*", Realm: "reports"}},
+			},
+			Error: "the cookie kc-access is used by both the realm reports and the proxy",
+		},
+		{
+			Config: &Config{Realms: []*Realm{{Name: "reports", CookiePath: "reports", Hostnames: []string{"reports.example.com"}}}},
+			Error:  "the cookie path of the realm reports must be an absolute path",
+		},
+		{
+			Config: &Config{
+				CookieAccessName: accessCookie,
+				Realms:           []*Realm{{Name: "reports", CookieAccessName: accessCookie, Hostnames: []string{"reports.example.net"}}},
+			},
+		},
+		{
+			Config: &Config{Realms: []*Realm{{Name: "reports", Upstream: "reports", Hostnames: []string{"reports.example.net"}}}},
+			Error:  "the upstream of the realm reports is not a valid URL: reports",
+		},
+		{
+			Config: &Config{Realms: []*Realm{realm()}, EnableForwarding: true},
+			Error:  "the realms are served by the reverse proxy, not the forwarding proxy",
+		},
+	}
+	for i, c := range cs {
+		err := c.Config.isRealmsValid()
+		if c.Error == "" {
+			assert.NoError(t, err, "case %d", i)
+			continue
+		}
+		if assert.Error(t, err, "case %d", i) {
+			assert.Equal(t, c.Error, err.Error(), "case %d", i)
+		}
+	}
+}
+
+func TestParseRealm(t *testing.T) {
+	realm, err := parseRealm("name=partners|discovery-url=https://keycloak/auth/realms/partners|client-id=gatekeeper|client-secret=secret|hostnames=a.example.com,b.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, &Realm{
+		Name:         "partners",
+		DiscoveryURL: "https://keycloak/auth/realms/partners",
+		ClientID:     "gatekeeper",
+		ClientSecret: "secret",
+		Hostnames:    []string{"a.example.com", "b.example.com"},
+	}, realm)
+
+	realm, err = parseRealm("name=reports|cookie-access-name=reports-access|cookie-refresh-name=reports-refresh|cookie-path=/reports|cookie-domain=example.net|upstream-url=http://127.0.0.1:8080")
+	require.NoError(t, err)
+	assert.Equal(t, &Realm{
+		Name:              "reports",
+		CookieAccessName:  "reports-access",
+		CookieRefreshName: "reports-refresh",
+		CookiePath:        "/reports",
+		CookieDomain:      "example.net",
+		Upstream:          "http://127.0.0.1:8080",
+	}, realm)
+
+	_, err = parseRealm("name=partners|unknown=value")
+	assert.Error(t, err)
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"strings"
+
+	"github.com/go-chi/chi/middleware"
+	uuid "github.com/satori/go.uuid"
+	"go.uber.org/zap"
+)
+
+// recoveryMiddleware converts the panics of the handlers into 500 responses, reported with the stack trace
+// and the request id. The panics aborting a response on purpose are left to the server.
+func (r *oauthProxy) recoveryMiddleware(listener string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			resp := newResponseWriter(w, req.ProtoMajor)
+			defer func() {
+				recovered := recover()
+				if recovered == nil {
+					return
+				}
+				if recovered == http.ErrAbortHandler {
+					panic(recovered)
+				}
+				r.reportPanic(resp, req, listener, recovered)
+			}()
+			next.ServeHTTP(resp, req)
+		})
+	}
+}
+
+// reportPanic logs and counts a recovered panic, responding 500 unless the response has already started
+func (r *oauthProxy) reportPanic(w middleware.WrapResponseWriter, req *http.Request, listener string, recovered interface{}) {
+	header := r.config.RequestIDHeader
+	if header == "" {
+		header = headerXRequestID
+	}
+	id := req.Header.Get(header)
+	if id == "" {
+		id = uuid.NewV1().String()
+	}
+
+	panicsMetric.WithLabelValues(listener).Inc()
+	r.log.Error("recovered from a panic while handling the request",
+		zap.String("listener", listener),
+		zap.String("request_id", id),
+		zap.String("method", req.Method),
+		zap.String("path", req.URL.Path),
+		zap.String("client_ip", realIP(req)),
+		zap.String("panic", fmt.Sprint(recovered)),
+		zap.Strings("stack", panicStack()))
+
+	if w.Status() != 0 || w.BytesWritten() > 0 {
+		// the client must not take the truncated response for a complete one
+		panic(http.ErrAbortHandler)
+	}
+	statusMetric.WithLabelValues(fmt.Sprintf("%d", http.StatusInternalServerError), req.Method).Inc()
+	w.Header().Set(header, id)
+	errorResponse(w, "internal server error, request id: "+id, http.StatusInternalServerError)
+}
+
+// panicStack returns the frames of the panicking goroutine, from the origin of the panic
+func panicStack() []string {
+	pcs := make([]uintptr, 64)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(4, pcs)])
+	stack := make([]string, 0, len(pcs))
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "runtime.") {
+			stack = append(stack, fmt.Sprintf("%s %s:%d", frame.Function, frame.File, frame.Line))
+		}
+		if !more {
+			break
+		}
+	}
+
+	return stack
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+type panickingPlugin struct{}
+
+func (panickingPlugin) Name() string { return "panicking" }
+
+func (panickingPlugin) PreAuth(w http.ResponseWriter, req *http.Request) bool {
+	if req.Header.Get("X-Panic") != "" {
+		panic("boom")
+	}
+	return true
+}
+
+func (panickingPlugin) PostAuth(http.ResponseWriter, *http.Request, *Identity) bool { return true }
+
+func (panickingPlugin) PreUpstream(http.ResponseWriter, *http.Request, *Identity) bool { return true }
+
+func (panickingPlugin) PostUpstream(*http.Response) error { return nil }
+
+func init() {
+	RegisterPlugin(panickingPlugin{})
+}
+
+func TestRecoveryMiddleware(t *testing.T) {
+	proxy := &oauthProxy{config: &Config{RequestIDHeader: headerXRequestID}, log: zap.NewNop()}
+	recovery := proxy.recoveryMiddleware("test")
+	panics := panicsMetric.WithLabelValues("test")
+	before := testutil.ToFloat64(panics)
+
+	handler := recovery(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("boom")
+	}))
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+	id := resp.Header().Get(headerXRequestID)
+	assert.NotEmpty(t, id)
+	assert.Contains(t, resp.Body.String(), id)
+	assert.Equal(t, before+1, testutil.ToFloat64(panics))
+
+	// the request id of the client is reported
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(headerXRequestID, "abc")
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	assert.Equal(t, "abc", resp.Header().Get(headerXRequestID))
+
+	// a response already started is aborted
+	started := recovery(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		panic("boom")
+	}))
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		started.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+	aborted := recovery(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		aborted.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+	assert.Equal(t, before+3, testutil.ToFloat64(panics))
+}
+
+func TestRecoveryMiddlewareListener(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.Plugins = []string{"panicking"}
+	cfg.EnableRequestID = true
+	cfg.RequestIDHeader = headerXRequestID
+	requests := []fakeRequest{
+		{
+			URI:             fakeAuthAllURL + "/test",
+			HasToken:        true,
+			Headers:         map[string]string{"X-Panic": "true", headerXRequestID: "abc"},
+			ExpectedCode:    http.StatusInternalServerError,
+			ExpectedHeaders: map[string]string{headerXRequestID: "abc"},
+		},
+		{
+			// the listener keeps serving requests
+			URI:           fakeAuthAllURL + "/test",
+			HasToken:      true,
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+		},
+	}
+	newFakeProxy(cfg).RunTests(t, requests)
+}
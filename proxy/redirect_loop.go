@@ -0,0 +1,112 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+var authRedirectLoopsMetric = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "proxy_auth_redirect_loops_total",
+		Help: "The clients caught in a loop of redirections to the authorization endpoint",
+	},
+)
+
+func init() {
+	prometheus.MustRegister(authRedirectLoopsMetric)
+}
+
+// parseAuthRedirects returns the redirections counted by the redirect loop cookie, with the start of their window
+func parseAuthRedirects(value string) (int, time.Time, bool) {
+	items := strings.SplitN(value, ".", 2)
+	if len(items) != 2 {
+		return 0, time.Time{}, false
+	}
+	count, err := strconv.Atoi(items[0])
+	if err != nil || count < 0 {
+		return 0, time.Time{}, false
+	}
+	start, err := strconv.ParseInt(items[1], 10, 64)
+	if err != nil {
+		return 0, time.Time{}, false
+	}
+
+	return count, time.Unix(start, 0), true
+}
+
+// countAuthRedirect counts the redirections of a client to the authorization endpoint in a cookie. Past the maximum
+// within the window, the client is looping, e.g. its session cookie is refused by the browser or its token is
+// rejected because of a clock skew: the loop is broken with an error page. It returns false when the page is served.
+func (r *oauthProxy) countAuthRedirect(w http.ResponseWriter, req *http.Request) bool {
+	if r.config.MaxAuthRedirects == 0 {
+		return true
+	}
+	now := time.Now()
+	count, start := 0, now
+	if cookie, err := req.Cookie(redirectLoopCookie); err == nil {
+		if n, since, ok := parseAuthRedirects(cookie.Value); ok && now.Sub(since) < r.config.AuthRedirectWindow {
+			count, start = n, since
+		}
+	}
+	count++
+
+	if count > r.config.MaxAuthRedirects {
+		authRedirectLoopsMetric.Inc()
+		_, logger := r.traceSpanRequest(req)
+		_, accessErr := req.Cookie(r.config.CookieAccessName)
+		_, stateErr := req.Cookie(requestStateCookie)
+		logger.Warn("the client is looping through the authorization endpoint",
+			zap.String("client_ip", realIP(req)),
+			zap.String("user_agent", req.UserAgent()),
+			zap.Int("redirections", count),
+			zap.Duration("window", r.config.AuthRedirectWindow),
+			zap.Bool("access_cookie", accessErr == nil),
+			zap.Bool("state_cookie", stateErr == nil))
+
+		// the next attempt of the client starts a new count
+		r.dropCookie(w, req.Host, redirectLoopCookie, "", -10*time.Hour)
+		r.errorPageResponse(w, req, r.config.RedirectLoopPage,
+			"the authentication is looping: check the cookies of this site are allowed and the clock of the device is right",
+			http.StatusLoopDetected)
+		return false
+	}
+
+	// the counter is not restricted by the same site policy of the session, to be sent along the redirections
+	cookie := r.cookieDropper(req.Host, redirectLoopCookie, fmt.Sprintf("%d.%d", count, start.Unix()), r.config.AuthRedirectWindow)
+	cookie.SameSite = http.SameSiteLaxMode
+	cookie.Expires = start.Add(r.config.AuthRedirectWindow)
+	http.SetCookie(w, cookie)
+
+	return true
+}
+
+// resetAuthRedirects clears the count of the redirections of an authenticated client
+func (r *oauthProxy) resetAuthRedirects(w http.ResponseWriter, req *http.Request) {
+	if r.config.MaxAuthRedirects == 0 {
+		return
+	}
+	if _, err := req.Cookie(redirectLoopCookie); err == nil {
+		r.dropCookie(w, req.Host, redirectLoopCookie, "", -10*time.Hour)
+	}
+}
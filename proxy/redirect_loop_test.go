@@ -0,0 +1,84 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthRedirectLoop(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.MaxAuthRedirects = 2
+	cfg.AuthRedirectWindow = time.Minute
+	p := newFakeProxy(cfg)
+	defer func() {
+		p.idp.Close()
+		p.proxy.server.Close()
+	}()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	do := func(uri string, cookie *http.Cookie, token string) (*http.Response, *http.Cookie) {
+		req, err := http.NewRequest(http.MethodGet, p.getServiceURL()+uri, nil)
+		require.NoError(t, err)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		if token != "" {
+			req.Header.Set(authorizationHeader, authorizationType+" "+token)
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		return resp, findCookie(redirectLoopCookie, resp.Cookies())
+	}
+
+	authorize := cfg.OAuthURI + authorizationURL
+	resp, cookie := do(authorize, nil, "")
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+	require.NotNil(t, cookie)
+	resp, cookie = do(authorize, cookie, "")
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+	require.NotNil(t, cookie)
+
+	// the loop is broken, and the next attempt starts a new count
+	resp, cleared := do(authorize, cookie, "")
+	assert.Equal(t, http.StatusLoopDetected, resp.StatusCode)
+	require.NotNil(t, cleared)
+	assert.Empty(t, cleared.Value)
+
+	// the redirections counted before the window are forgotten
+	stale := &http.Cookie{Name: redirectLoopCookie, Value: fmt.Sprintf("5.%d", time.Now().Add(-time.Hour).Unix())}
+	resp, cookie = do(authorize, stale, "")
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+	require.NotNil(t, cookie)
+	count, _, ok := parseAuthRedirects(cookie.Value)
+	assert.True(t, ok)
+	assert.Equal(t, 1, count)
+
+	// the count is cleared once the client is authenticated
+	signed, err := p.idp.signToken(newTestToken(p.idp.getLocation()).claims)
+	require.NoError(t, err)
+	resp, cleared = do("/auth_all/test", cookie, signed.Encode())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NotNil(t, cleared)
+	assert.Empty(t, cleared.Value)
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// hostResolver resolves the hosts dialed for the openid provider and the upstreams, with static aliases,
+// a dedicated name server and a query timeout
+type hostResolver struct {
+	resolver *net.Resolver
+	aliases  map[string]string
+	server   string
+	timeout  time.Duration
+}
+
+// newHostResolver returns the resolver of the configuration, or nil when the system one is used as is
+func newHostResolver(config *Config) *hostResolver {
+	if config.DNSResolver == "" && config.DNSTimeout == 0 && len(config.HostAliases) == 0 {
+		return nil
+	}
+	h := &hostResolver{
+		aliases: make(map[string]string, len(config.HostAliases)),
+		server:  config.DNSResolver,
+		timeout: config.DNSTimeout,
+	}
+	for host, alias := range config.HostAliases {
+		h.aliases[strings.ToLower(host)] = alias
+	}
+	if h.server != "" || h.timeout > 0 {
+		h.resolver = &net.Resolver{PreferGo: true, Dial: h.dialNameServer}
+	}
+
+	return h
+}
+
+// dialContext returns the dial function of a dialer, resolving the hosts of the addresses with the resolver.
+// The addresses of the hosts are dialed by the dialer, dual-stack hosts included.
+func (h *hostResolver) dialContext(dialer *net.Dialer) dialContextFunc {
+	if h == nil {
+		return dialer.DialContext
+	}
+	resolving := *dialer
+	resolving.Resolver = h.resolver
+
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		if host, port, err := net.SplitHostPort(address); err == nil {
+			if alias, found := h.aliases[strings.ToLower(host)]; found {
+				address = net.JoinHostPort(alias, port)
+			}
+		}
+
+		return resolving.DialContext(ctx, network, address)
+	}
+}
+
+// dialNameServer dials the name server of the configuration in place of the system ones, the queries to the name
+// server timing out after the query timeout
+func (h *hostResolver) dialNameServer(ctx context.Context, network, address string) (net.Conn, error) {
+	if h.server != "" {
+		address = h.server
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil || h.timeout <= 0 {
+		return conn, err
+	}
+	// the resolver sets its own deadline on the connections, bounded by the query timeout
+	if udp, ok := conn.(*net.UDPConn); ok {
+		return &nameServerPacketConn{UDPConn: udp, timeout: h.timeout}, nil
+	}
+
+	return &nameServerConn{Conn: conn, timeout: h.timeout}, nil
+}
+
+// nameServerConn is a stream connection to a name server, whose deadlines are bounded by a timeout
+type nameServerConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *nameServerConn) SetDeadline(t time.Time) error {
+	return c.Conn.SetDeadline(deadlineWithin(t, c.timeout))
+}
+
+// nameServerPacketConn is a packet connection to a name server, whose deadlines are bounded by a timeout
+type nameServerPacketConn struct {
+	*net.UDPConn
+	timeout time.Duration
+}
+
+func (c *nameServerPacketConn) SetDeadline(t time.Time) error {
+	return c.UDPConn.SetDeadline(deadlineWithin(t, c.timeout))
+}
+
+// deadlineWithin returns a deadline, or the end of a timeout when earlier
+func deadlineWithin(deadline time.Time, timeout time.Duration) time.Time {
+	if limit := time.Now().Add(timeout); deadline.IsZero() || deadline.After(limit) {
+		return limit
+	}
+
+	return deadline
+}
+
+// isHostResolutionValid checks the name server and the aliases of the hosts
+func (r *Config) isHostResolutionValid() error {
+	if r.DNSResolver != "" {
+		if _, _, err := net.SplitHostPort(r.DNSResolver); err != nil {
+			return fmt.Errorf("the dns-resolver must be an address with a port, e.g. 10.0.0.10:53: %v", err)
+		}
+	}
+	if r.DNSTimeout < 0 {
+		return errors.New("dns-timeout must be a positive duration")
+	}
+	for host, alias := range r.HostAliases {
+		if host == "" || alias == "" || strings.Contains(alias, ":") && net.ParseIP(alias) == nil {
+			return fmt.Errorf("invalid host alias %s=%s, expected a host aliased to an address or another host", host, alias)
+		}
+	}
+
+	return nil
+}
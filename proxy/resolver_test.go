@@ -0,0 +1,127 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// newFakeNameServer answers the A and AAAA queries with the loopback addresses, or never answers when silent
+func newFakeNameServer(t *testing.T, silent bool) net.PacketConn {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var query dnsmessage.Message
+			if silent || query.Unpack(buf[:n]) != nil || len(query.Questions) == 0 {
+				continue
+			}
+			answer := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: query.Header.ID, Response: true, Authoritative: true},
+				Questions: query.Questions,
+			}
+			switch question := query.Questions[0]; question.Type {
+			case dnsmessage.TypeA:
+				answer.Answers = []dnsmessage.Resource{{
+					Header: dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+					Body:   &dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}},
+				}}
+			case dnsmessage.TypeAAAA:
+				answer.Answers = []dnsmessage.Resource{{
+					Header: dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeAAAA, Class: dnsmessage.ClassINET, TTL: 60},
+					Body:   &dnsmessage.AAAAResource{AAAA: [16]byte{15: 1}},
+				}}
+			}
+			packed, err := answer.Pack()
+			if err == nil {
+				_, _ = conn.WriteTo(packed, addr)
+			}
+		}
+	}()
+
+	return conn
+}
+
+func TestHostResolverNameServer(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer upstream.Close()
+	u, _ := url.Parse(upstream.URL)
+
+	nameServer := newFakeNameServer(t, false)
+	defer nameServer.Close()
+	resolver := newHostResolver(&Config{DNSResolver: nameServer.LocalAddr().String(), DNSTimeout: time.Second})
+	dial := resolver.dialContext(&net.Dialer{})
+
+	conn, err := dial(context.Background(), "tcp", net.JoinHostPort("api.split-horizon.example", u.Port()))
+	require.NoError(t, err)
+	assert.Equal(t, u.Host, conn.RemoteAddr().String())
+	_ = conn.Close()
+}
+
+func TestHostResolverTimeout(t *testing.T) {
+	nameServer := newFakeNameServer(t, true)
+	defer nameServer.Close()
+	resolver := newHostResolver(&Config{DNSResolver: nameServer.LocalAddr().String(), DNSTimeout: 50 * time.Millisecond})
+	dial := resolver.dialContext(&net.Dialer{})
+
+	started := time.Now()
+	_, err := dial(context.Background(), "tcp", "api.split-horizon.example:80")
+	assert.Error(t, err)
+	assert.True(t, time.Since(started) < time.Second, "expected the lookup to time out")
+}
+
+func TestHostAliases(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Upstream-Host", req.Host)
+	}))
+	defer upstream.Close()
+	u, _ := url.Parse(upstream.URL)
+
+	cfg := newFakeKeycloakConfig()
+	cfg.HostAliases = map[string]string{"Backend.Internal": "127.0.0.1"}
+	cfg.Resources = []*Resource{
+		{
+			URL:          "/backend/*",
+			Methods:      allHTTPMethods,
+			Upstream:     "http://backend.internal:" + u.Port(),
+			MaxIdleConns: 10,
+		},
+	}
+	newFakeProxy(cfg).RunTests(t, []fakeRequest{
+		{
+			URI:             "/backend/orders",
+			HasToken:        true,
+			ExpectedCode:    http.StatusOK,
+			ExpectedHeaders: map[string]string{"X-Upstream-Host": "backend.internal:" + u.Port()},
+		},
+	})
+}
@@ -0,0 +1,527 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Resource represents an upstream resource to protect
+type Resource struct {
+	// URL the url for the resource
+	URL string `json:"uri" yaml:"uri"`
+	// Several URLs sharing the same config: expanded as as many resources
+	URLs []string `json:"uris" yaml:"uris"`
+	// Methods the method type
+	Methods []string `json:"methods" yaml:"methods"`
+	// WhiteListed permits the prefix through
+	WhiteListed bool `json:"white-listed" yaml:"white-listed"`
+	// BlackListed denies the prefix through
+	BlackListed bool `json:"black-listed" yaml:"black-listed"`
+	// RequireAnyRole indicates that ANY of the roles are required, the default is all
+	RequireAnyRole bool `json:"require-any-role" yaml:"require-any-role"`
+	// Roles the roles required to access this url
+	Roles []string `json:"roles" yaml:"roles"`
+	// Groups is a list of groups the user is in
+	Groups []string `json:"groups" yaml:"groups"`
+	// Audiences are the audiences of which the access tokens must carry any, in place of the client id
+	Audiences []string `json:"audiences" yaml:"audiences"`
+	// Realm is the name of the realm authenticating the requests to this resource, the provider of the proxy by default
+	Realm string `json:"realm" yaml:"realm"`
+	// MethodRequirements are the roles and groups required to send some of the methods, in addition to the ones of the resource
+	MethodRequirements []*MethodRequirement `json:"method-requirements" yaml:"method-requirements"`
+	// OptionalAuth forwards requests anonymously when there is no identity or it has expired
+	OptionalAuth bool `json:"optional-auth" yaml:"optional-auth"`
+	// EnableCSRF enables CSRF check on this upstream Resource
+	EnableCSRF bool `json:"enable-csrf" yaml:"enable-csrf"`
+	// StripBasePath is the prefix to strip from URL before sending upstream
+	StripBasePath string `json:"strip-basepath" yaml:"strip-basepath"`
+	// CorsOrigins overrides the origins permitted on this resource
+	CorsOrigins []string `json:"cors-origins" yaml:"cors-origins"`
+	// CorsMethods overrides the access control methods on this resource
+	CorsMethods []string `json:"cors-methods" yaml:"cors-methods"`
+	// CorsHeaders overrides the access control headers on this resource
+	CorsHeaders []string `json:"cors-headers" yaml:"cors-headers"`
+	// MaxIdleConns overrides the max idle connections kept alive to the upstream of this resource
+	MaxIdleConns int `json:"max-idle-connections" yaml:"max-idle-connections"`
+	// MaxIdleConnsPerHost overrides the max idle connections kept alive per host for this resource
+	MaxIdleConnsPerHost int `json:"max-idle-connections-per-host" yaml:"max-idle-connections-per-host"`
+	// MaxConnsPerHost limits the total number of connections per host for this resource
+	MaxConnsPerHost int `json:"max-connections-per-host" yaml:"max-connections-per-host"`
+	// IdleConnTimeout overrides the time the keepalive connections to the upstream of this resource may stay idle
+	IdleConnTimeout time.Duration `json:"idle-connection-timeout" yaml:"idle-connection-timeout"`
+	// MaxConnLifetime overrides the time the keepalive connections to the upstream of this resource are reused
+	MaxConnLifetime time.Duration `json:"max-connection-lifetime" yaml:"max-connection-lifetime"`
+	// ResponseTimeout is the deadline of the upstream responses to this resource, after which the request is canceled (504),
+	// except for upgraded connections
+	ResponseTimeout time.Duration `json:"response-timeout" yaml:"response-timeout"`
+	// Streaming flushes the upstream responses after each write, without any interception of the response but the header rules
+	Streaming bool `json:"streaming" yaml:"streaming"`
+	// Coalesce shares a single upstream round trip between the identical concurrent GET requests of an identity
+	Coalesce bool `json:"coalesce-requests" yaml:"coalesce-requests"`
+	// HedgeUpstreams are other upstream endpoints serving this resource, to which the hedged attempts are sent
+	HedgeUpstreams []string `json:"hedge-upstream-urls" yaml:"hedge-upstream-urls"`
+	// HedgeDelay is the time waited for an upstream response before sending a second attempt of an idempotent request
+	HedgeDelay time.Duration `json:"hedge-delay" yaml:"hedge-delay"`
+	// DebugCapture logs a sample of the requests and responses of this resource, with their headers and bodies
+	DebugCapture bool `json:"debug-capture" yaml:"debug-capture"`
+	// Script is a script run on the requests to this resource, once authenticated, to set headers or deny
+	Script string `json:"script" yaml:"script"`
+	// AccessExpression is a CEL expression on the claims and the request which must hold for the requests to be admitted
+	AccessExpression string `json:"access-expression" yaml:"access-expression"`
+	// StripResponseHeaders are the headers removed from the upstream responses to this resource, in addition to the global ones
+	StripResponseHeaders []string `json:"strip-response-headers" yaml:"strip-response-headers"`
+	// OverrideResponseHeaders are the headers replacing the upstream values on this resource, over the global ones
+	OverrideResponseHeaders map[string]string `json:"override-response-headers" yaml:"override-response-headers"`
+	// ResponseCookieDomain overrides the domain rewritten in the cookies set by the upstream of this resource
+	ResponseCookieDomain string `json:"response-cookie-domain" yaml:"response-cookie-domain"`
+	// ResponseBodyRewrites are the substitutions in the bodies of the upstream responses to this resource, in addition to the global ones
+	ResponseBodyRewrites []*BodyRewrite `json:"response-body-rewrites" yaml:"response-body-rewrites"`
+	// UpstreamClaim is the claim of the user selecting the upstream among ClaimUpstreams
+	UpstreamClaim string `json:"upstream-claim" yaml:"upstream-claim"`
+	// ClaimUpstreams maps the values of UpstreamClaim to upstream endpoints, the others using the upstream of the resource
+	ClaimUpstreams map[string]string `json:"claim-upstream-urls" yaml:"claim-upstream-urls"`
+	// UpstreamHeader is the request header selecting the upstream among HeaderUpstreams
+	UpstreamHeader string `json:"upstream-header" yaml:"upstream-header"`
+	// HeaderUpstreams maps the values of UpstreamHeader to upstream endpoints, the others using the upstream of the resource
+	HeaderUpstreams map[string]string `json:"header-upstream-urls" yaml:"header-upstream-urls"`
+	// AWSSigV4Service is the aws service (e.g. execute-api, s3, es) the upstream requests are signed for with AWS SigV4
+	AWSSigV4Service string `json:"aws-sigv4-service" yaml:"aws-sigv4-service"`
+	// AWSSigV4Region is the aws region of the signed requests, defaulting to AWS_REGION
+	AWSSigV4Region string `json:"aws-sigv4-region" yaml:"aws-sigv4-region"`
+	// FaultInjection injects delays and errors in the requests to the resource, when enable-fault-injection is set
+	FaultInjection *FaultInjection `json:"fault-injection" yaml:"fault-injection"`
+	// StaticDirectory is a local directory the files of the resource are served from, in place of an upstream
+	StaticDirectory string `json:"static-directory" yaml:"static-directory"`
+	// StaticFallback serves the index.html of the static directory for the missing paths, e.g. the routes of a single page app
+	StaticFallback bool `json:"static-spa-fallback" yaml:"static-spa-fallback"`
+	// StaticMaxAge is how long the browsers may cache the static files, but for the index.html documents
+	StaticMaxAge time.Duration `json:"static-max-age" yaml:"static-max-age"`
+	// OPAURL is the open policy agent decision admitting the requests to this resource, in place of the global one
+	OPAURL string `json:"opa-url" yaml:"opa-url"`
+	// UMAResource is the name of the keycloak resource of the requests to this resource, when the authorization
+	// services are enforced. The requests are matched to the keycloak resources by their uri otherwise.
+	UMAResource string `json:"uma-resource" yaml:"uma-resource"`
+	// ClientCertIdentities are the common names or alternative names of the verified client certificates admitted
+	// to this resource without a token
+	ClientCertIdentities []string `json:"client-cert-identities" yaml:"client-cert-identities"`
+	// GraphQL marks a GraphQL endpoint, the operations of which are admitted upon GraphQLOperations
+	GraphQL bool `json:"graphql" yaml:"graphql"`
+	// Decompress decompresses the gzip and deflate bodies inspected by the features of this resource, e.g. graphql or rewrites
+	Decompress bool `json:"decompress" yaml:"decompress"`
+	// GraphQLOperations are the roles required to run some of the operations sent to this resource
+	GraphQLOperations []*GraphQLOperation `json:"graphql-operations" yaml:"graphql-operations"`
+	// Upstream is the upstream endpoint i.e whom were proxying to
+	Upstream string `json:"upstream-url" yaml:"upstream-url" usage:"url for the upstream endpoint you wish to proxy this resource"`
+	// UpstreamH2C speaks HTTP/2 without TLS (h2c) to the upstream of this resource, e.g. to proxy gRPC services
+	UpstreamH2C bool `json:"upstream-h2c" yaml:"upstream-h2c"`
+	// Upstreams are other endpoints identical to the upstream of this resource, the requests being balanced over all of them
+	Upstreams []string `json:"upstream-urls" yaml:"upstream-urls"`
+	// UpstreamBalancing is the strategy balancing the requests over the upstreams of this resource
+	UpstreamBalancing string `json:"upstream-balancing" yaml:"upstream-balancing"`
+	// TODO: UpstreamCA is the path to a CA certificate in PEM format to validate the upstream certificate
+	// UpstreamCA string `json:"upstream-ca" yaml:"upstream-ca" usage:"the path to a file container a CA certificate to validate the upstream tls endpoint for this resource"`
+}
+
+func newResource() *Resource {
+	return &Resource{
+		Methods: allHTTPMethods,
+	}
+}
+
+// parse decodes a resource definition
+func (r *Resource) parse(resource string) (*Resource, error) {
+	if resource == "" {
+		return nil, errors.New("the resource has no options")
+	}
+	for _, x := range strings.Split(resource, "|") {
+		kp := strings.Split(x, "=")
+		if len(kp) != 2 {
+			return nil, errors.New("invalid resource keypair, should be (uri|uris|roles|methods|white-listed)=comma_values")
+		}
+		switch kp[0] {
+		case "uri":
+			r.URL = kp[1]
+			if !strings.HasPrefix(r.URL, "/") {
+				return nil, errors.New("the resource uri should start with a '/'")
+			}
+		case "uris":
+			r.URLs = strings.Split(kp[1], ",")
+			for _, u := range r.URLs {
+				if !strings.HasPrefix(u, "/") {
+					return nil, errors.New("the resource uri should start with a '/'")
+				}
+
+			}
+		case "methods":
+			r.Methods = strings.Split(kp[1], ",")
+			if len(r.Methods) == 1 {
+				if strings.EqualFold(r.Methods[0], anyMethod) {
+					r.Methods = allHTTPMethods
+				}
+			}
+		case "require-any-role":
+			v, err := strconv.ParseBool(kp[1])
+			if err != nil {
+				return nil, err
+			}
+			r.RequireAnyRole = v
+		case "roles":
+			r.Roles = strings.Split(kp[1], ",")
+		case "groups":
+			r.Groups = strings.Split(kp[1], ",")
+		case "audiences":
+			r.Audiences = strings.Split(kp[1], ",")
+		case "realm":
+			r.Realm = kp[1]
+		case "white-listed":
+			value, err := strconv.ParseBool(kp[1])
+			if err != nil {
+				return nil, errors.New("the value of whitelisted must be true|TRUE|T or it's false equivalent")
+			}
+			r.WhiteListed = value
+		case "upstream-url":
+			r.Upstream = kp[1]
+		case "upstream-urls":
+			r.Upstreams = strings.Split(kp[1], ",")
+		case "upstream-balancing":
+			r.UpstreamBalancing = kp[1]
+		case "upstream-h2c":
+			v, err := strconv.ParseBool(kp[1])
+			if err != nil {
+				return nil, errors.New("the value of upstream-h2c must be true|TRUE|T or it's false equivalent")
+			}
+			r.UpstreamH2C = v
+		case "strip-basepath":
+			r.StripBasePath = kp[1]
+		case "optional-auth":
+			v, err := strconv.ParseBool(kp[1])
+			if err != nil {
+				return nil, errors.New("the value of optional-auth must be true|TRUE|T or it's false equivalent")
+			}
+			r.OptionalAuth = v
+		case "idle-connection-timeout", "max-connection-lifetime":
+			v, err := time.ParseDuration(kp[1])
+			if err != nil {
+				return nil, fmt.Errorf("the value of %s must be a duration, e.g. 5m", kp[0])
+			}
+			if kp[0] == "idle-connection-timeout" {
+				r.IdleConnTimeout = v
+			} else {
+				r.MaxConnLifetime = v
+			}
+		case "response-timeout":
+			v, err := time.ParseDuration(kp[1])
+			if err != nil {
+				return nil, errors.New("the value of response-timeout must be a duration, e.g. 30s")
+			}
+			r.ResponseTimeout = v
+		case "streaming":
+			v, err := strconv.ParseBool(kp[1])
+			if err != nil {
+				return nil, errors.New("the value of streaming must be true|TRUE|T or it's false equivalent")
+			}
+			r.Streaming = v
+		case "coalesce-requests":
+			v, err := strconv.ParseBool(kp[1])
+			if err != nil {
+				return nil, errors.New("the value of coalesce-requests must be true|TRUE|T or it's false equivalent")
+			}
+			r.Coalesce = v
+		case "hedge-upstream-urls":
+			r.HedgeUpstreams = strings.Split(kp[1], ",")
+		case "hedge-delay":
+			v, err := time.ParseDuration(kp[1])
+			if err != nil {
+				return nil, errors.New("the value of hedge-delay must be a duration, e.g. 50ms")
+			}
+			r.HedgeDelay = v
+		case "graphql":
+			v, err := strconv.ParseBool(kp[1])
+			if err != nil {
+				return nil, errors.New("the value of graphql must be true|TRUE|T or it's false equivalent")
+			}
+			r.GraphQL = v
+		case "decompress":
+			v, err := strconv.ParseBool(kp[1])
+			if err != nil {
+				return nil, errors.New("the value of decompress must be true|TRUE|T or it's false equivalent")
+			}
+			r.Decompress = v
+		case "aws-sigv4-service":
+			r.AWSSigV4Service = kp[1]
+		case "aws-sigv4-region":
+			r.AWSSigV4Region = kp[1]
+		case "static-directory":
+			r.StaticDirectory = kp[1]
+		case "static-spa-fallback":
+			v, err := strconv.ParseBool(kp[1])
+			if err != nil {
+				return nil, errors.New("the value of static-spa-fallback must be true|TRUE|T or it's false equivalent")
+			}
+			r.StaticFallback = v
+		case "static-max-age":
+			v, err := time.ParseDuration(kp[1])
+			if err != nil {
+				return nil, errors.New("the value of static-max-age must be a duration, e.g. 1h")
+			}
+			r.StaticMaxAge = v
+		case "opa-url":
+			r.OPAURL = kp[1]
+		case "uma-resource":
+			r.UMAResource = kp[1]
+		case "client-cert-identities":
+			r.ClientCertIdentities = strings.Split(kp[1], ",")
+		case "debug-capture":
+			v, err := strconv.ParseBool(kp[1])
+			if err != nil {
+				return nil, errors.New("the value of debug-capture must be true|TRUE|T or it's false equivalent")
+			}
+			r.DebugCapture = v
+		case "strip-response-headers":
+			r.StripResponseHeaders = strings.Split(kp[1], ",")
+		case "response-cookie-domain":
+			r.ResponseCookieDomain = kp[1]
+		case "cors-origins":
+			r.CorsOrigins = strings.Split(kp[1], ",")
+		case "cors-methods":
+			r.CorsMethods = strings.Split(kp[1], ",")
+		case "cors-headers":
+			r.CorsHeaders = strings.Split(kp[1], ",")
+		case "max-idle-connections", "max-idle-connections-per-host", "max-connections-per-host":
+			v, err := strconv.Atoi(kp[1])
+			if err != nil {
+				return nil, fmt.Errorf("the value of %s must be an integer", kp[0])
+			}
+			switch kp[0] {
+			case "max-idle-connections":
+				r.MaxIdleConns = v
+			case "max-idle-connections-per-host":
+				r.MaxIdleConnsPerHost = v
+			default:
+				r.MaxConnsPerHost = v
+			}
+		case "enable-csrf":
+			v, err := strconv.ParseBool(kp[1])
+			if err != nil {
+				return nil, errors.New("the value of enable-csrf must be true|TRUE|T or it's false equivalent")
+			}
+			r.EnableCSRF = v
+		default:
+			return nil, errors.New("invalid identifier, should be roles, uri or methods")
+		}
+	}
+
+	return r, nil
+}
+
+// valid ensure the resource is valid
+func (r *Resource) valid() error {
+	if r.Methods == nil {
+		r.Methods = make([]string, 0)
+	}
+	if r.WhiteListed && r.BlackListed {
+		return errors.New("can't specify both black and white listed")
+	}
+	if r.Roles == nil {
+		r.Roles = make([]string, 0)
+	}
+	if r.OptionalAuth && (len(r.Roles) > 0 || len(r.Groups) > 0) {
+		return errors.New("can't require roles or groups on a resource with optional authentication")
+	}
+	if r.URL != "" && len(r.URLs) > 0 {
+		return errors.New("can't specify both uri and uris")
+	}
+	if r.URL == "" && len(r.URLs) == 0 {
+		return errors.New("resource does not have url")
+	}
+	if r.URL == "" && len(r.URLs) > 0 {
+		for _, u := range r.URLs {
+			if u == "" {
+				return errors.New("resource does not have url")
+			}
+		}
+	}
+	if strings.HasSuffix(r.URL, "/") && !r.WhiteListed {
+		return fmt.Errorf("you need a wildcard on the url resource to cover all request i.e. --resources=uri=%s*", r.URL)
+	}
+	if r.Upstream != "" {
+		if _, err := url.Parse(r.Upstream); err != nil {
+			return fmt.Errorf("upstream specified for resource %s is not a valid URL: %q", r.URL, r.Upstream)
+		}
+	}
+	if err := isUpstreamBalancingValid(r.Upstream, r.Upstreams, r.UpstreamBalancing); err != nil {
+		return fmt.Errorf("%v, on resource %s", err, r.URL)
+	}
+	if r.UpstreamH2C && strings.HasPrefix(r.Upstream, secureScheme+":") {
+		return fmt.Errorf("the resource %s speaks h2c to a https upstream: HTTP/2 is already negotiated over TLS", r.URL)
+	}
+
+	if r.Coalesce && r.Streaming {
+		return fmt.Errorf("the requests to the streaming resource %s cannot be coalesced", r.URL)
+	}
+
+	if err := isClaimRoutingValid(r); err != nil {
+		return err
+	}
+
+	if err := isHeaderRoutingValid(r); err != nil {
+		return err
+	}
+
+	if err := isGraphQLValid(r); err != nil {
+		return err
+	}
+
+	if r.FaultInjection != nil {
+		if err := isFaultInjectionValid(r.FaultInjection); err != nil {
+			return fmt.Errorf("%v, on resource %s", err, r.URL)
+		}
+	}
+
+	if err := isStaticFilesValid(r); err != nil {
+		return err
+	}
+
+	if err := isOPAURLValid(r.OPAURL); err != nil {
+		return fmt.Errorf("%v, on resource %s", err, r.URL)
+	}
+
+	if len(r.ClientCertIdentities) > 0 && r.WhiteListed {
+		return fmt.Errorf("the resource %s is white-listed, the client-cert-identities do not apply", r.URL)
+	}
+
+	if r.AWSSigV4Service == "" && r.AWSSigV4Region != "" {
+		return fmt.Errorf("the resource %s has aws-sigv4-region, but no aws-sigv4-service", r.URL)
+	}
+	if r.AWSSigV4Service != "" && awsRegion(r.AWSSigV4Region) == "" {
+		return fmt.Errorf("the resource %s signs its requests for %s, but no aws-sigv4-region or AWS_REGION is set", r.URL, r.AWSSigV4Service)
+	}
+
+	if r.HedgeDelay < 0 {
+		return fmt.Errorf("the hedge delay for resource %s must be a positive duration", r.URL)
+	}
+	if r.HedgeDelay > 0 && len(r.HedgeUpstreams) == 0 {
+		return fmt.Errorf("the resource %s hedges its requests, but has no hedge-upstream-urls", r.URL)
+	}
+	for _, upstream := range r.HedgeUpstreams {
+		if u, err := url.Parse(upstream); err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			return fmt.Errorf("hedge upstream for resource %s is not a valid URL without path: %q", r.URL, upstream)
+		}
+	}
+
+	if r.ResponseTimeout < 0 {
+		return fmt.Errorf("the response timeout for resource %s must be a positive duration", r.URL)
+	}
+	if r.MaxIdleConns < 0 || r.MaxIdleConnsPerHost < 0 || r.MaxConnsPerHost < 0 {
+		return fmt.Errorf("connection pool settings for resource %s must be positive numbers", r.URL)
+	}
+	if r.IdleConnTimeout < 0 || r.MaxConnLifetime < 0 {
+		return fmt.Errorf("the connection durations for resource %s must be positive durations", r.URL)
+	}
+	if r.MaxIdleConns > 0 && r.MaxIdleConnsPerHost > r.MaxIdleConns {
+		return fmt.Errorf("max-idle-connections-per-host for resource %s must be <= max-idle-connections", r.URL)
+	}
+
+	if err := isResponseRulesValid(r.StripResponseHeaders, r.ResponseCookieDomain); err != nil {
+		return fmt.Errorf("%v, on resource %s", err, r.URL)
+	}
+	if err := isBodyRewritesValid(r.ResponseBodyRewrites); err != nil {
+		return fmt.Errorf("%v, on resource %s", err, r.URL)
+	}
+
+	if _, err := compileScript(r.Script); err != nil {
+		return fmt.Errorf("invalid script for resource %s: %s", r.URL, err)
+	}
+	if len(r.Audiences) > 0 && r.WhiteListed {
+		return fmt.Errorf("the audiences of resource %s cannot be checked on a white-listed resource", r.URL)
+	}
+	for _, aud := range r.Audiences {
+		if aud == "" {
+			return fmt.Errorf("the audiences of resource %s cannot be empty", r.URL)
+		}
+	}
+	if r.AccessExpression != "" && (r.WhiteListed || r.OptionalAuth) {
+		return fmt.Errorf("an access expression on resource %s requires the authentication of the requests", r.URL)
+	}
+	if _, err := compileAccessExpression(r.AccessExpression); err != nil {
+		return fmt.Errorf("invalid access expression for resource %s: %s", r.URL, err)
+	}
+
+	for _, m := range r.CorsMethods {
+		if !isValidResourceMethod(m) {
+			return fmt.Errorf("invalid CORS method %s", m)
+		}
+	}
+
+	// step: add any of no methods
+	if len(r.Methods) == 0 {
+		r.Methods = allHTTPMethods
+	}
+	// step: check the method is valid
+	for _, m := range r.Methods {
+		if !isValidResourceMethod(m) {
+			return fmt.Errorf("invalid method %s", m)
+		}
+	}
+
+	// step: the methods of the requirements are proxied, by default all of them
+	return isMethodRequirementsValid(r)
+}
+
+// hasCors checks if this resource overrides the global CORS policy
+func (r Resource) hasCors() bool {
+	return len(r.CorsOrigins) > 0 || len(r.CorsMethods) > 0 || len(r.CorsHeaders) > 0
+}
+
+// hasConnectionPool checks if this resource uses a dedicated connection pool to its upstream
+func (r Resource) hasConnectionPool() bool {
+	return r.MaxIdleConns > 0 || r.MaxIdleConnsPerHost > 0 || r.MaxConnsPerHost > 0 || r.IdleConnTimeout > 0 ||
+		r.MaxConnLifetime > 0 || r.UpstreamH2C
+}
+
+// getRoles returns a list of roles for this resource
+func (r Resource) getRoles() string {
+	return strings.Join(r.Roles, ",")
+}
+
+// String returns a string representation of the resource
+func (r Resource) String() string {
+	if r.WhiteListed {
+		return fmt.Sprintf("uri: %s, white-listed", r.URL)
+	}
+
+	roles := "authentication only"
+	if r.OptionalAuth {
+		roles = "optional authentication"
+	}
+	methods := anyMethod
+
+	if len(r.Roles) > 0 {
+		roles = strings.Join(r.Roles, ",")
+	}
+
+	if len(r.Methods) > 0 {
+		methods = strings.Join(r.Methods, ",")
+	}
+
+	return fmt.Sprintf("uri: %s, methods: %s, required: %s", r.URL, methods, roles)
+}
@@ -13,10 +13,11 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package main
+package proxy
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -81,10 +82,42 @@ func TestResourceParseOk(t *testing.T) {
 			Option:   "uri=/*|groups=admin",
 			Resource: &Resource{URL: "/*", Methods: allHTTPMethods, Groups: []string{"admin"}},
 		},
+		{
+			Option:   "uri=/*|audiences=billing,accounting",
+			Resource: &Resource{URL: "/*", Methods: allHTTPMethods, Audiences: []string{"billing", "accounting"}},
+		},
 		{
 			Option:   "uri=/*|require-any-role=true",
 			Resource: &Resource{URL: "/*", Methods: allHTTPMethods, RequireAnyRole: true},
 		},
+		{
+			Option:   "uri=/public/*|optional-auth=true",
+			Resource: &Resource{URL: "/public/*", Methods: allHTTPMethods, OptionalAuth: true},
+		},
+		{
+			Option:   "uri=/reports/*|max-idle-connections=10|max-idle-connections-per-host=5|max-connections-per-host=20",
+			Resource: &Resource{URL: "/reports/*", Methods: allHTTPMethods, MaxIdleConns: 10, MaxIdleConnsPerHost: 5, MaxConnsPerHost: 20},
+		},
+		{
+			Option:   "uri=/reports/*|response-timeout=30s",
+			Resource: &Resource{URL: "/reports/*", Methods: allHTTPMethods, ResponseTimeout: 30 * time.Second},
+		},
+		{
+			Option:   "uri=/downloads/*|streaming=true",
+			Resource: &Resource{URL: "/downloads/*", Methods: allHTTPMethods, Streaming: true},
+		},
+		{
+			Option:   "uri=/catalog/*|coalesce-requests=true",
+			Resource: &Resource{URL: "/catalog/*", Methods: allHTTPMethods, Coalesce: true},
+		},
+		{
+			Option:   "uri=/search/*|hedge-delay=50ms|hedge-upstream-urls=http://search-1:8080,http://search-2:8080",
+			Resource: &Resource{URL: "/search/*", Methods: allHTTPMethods, HedgeDelay: 50 * time.Millisecond, HedgeUpstreams: []string{"http://search-1:8080", "http://search-2:8080"}},
+		},
+		{
+			Option:   "uri=/widget/*|cors-origins=*|cors-methods=GET,POST|cors-headers=X-Widget",
+			Resource: &Resource{URL: "/widget/*", Methods: allHTTPMethods, CorsOrigins: []string{"*"}, CorsMethods: []string{"GET", "POST"}, CorsHeaders: []string{"X-Widget"}},
+		},
 		{
 			Option:   "uris=/*,/more,/another|require-any-role=true",
 			Resource: &Resource{URLs: []string{"/*", "/more", "/another"}, Methods: allHTTPMethods, RequireAnyRole: true},
@@ -128,12 +161,23 @@ func TestIsValid(t *testing.T) {
 				Methods: []string{"NO_SUCH_METHOD"},
 			},
 		},
+		{
+			Resource: &Resource{URL: "/dav/*", Methods: []string{"PROPFIND", "MKCOL", "REPORT", "VERSION-CONTROL"}},
+			Ok:       true,
+		},
 		{
 			Resource: &Resource{
 				URL:  "/test",
 				URLs: []string{"/test", "/another"},
 			},
 		},
+		{
+			Resource: &Resource{URL: "/public", OptionalAuth: true},
+			Ok:       true,
+		},
+		{
+			Resource: &Resource{URL: "/public", OptionalAuth: true, Roles: []string{"admin"}},
+		},
 	}
 
 	for i, c := range testCases {
@@ -142,6 +186,8 @@ func TestIsValid(t *testing.T) {
 			t.Errorf("case %d should not have failed, error: %s", i, err)
 		}
 	}
+	assert.Error(t, (&Resource{URL: "/test", Methods: []string{"NO_SUCH_METHOD"}}).valid())
+	assert.Error(t, (&Resource{URL: "/test", Methods: []string{"propfind"}}).valid())
 }
 
 var expectedRoles = []string{"1", "2", "3"}
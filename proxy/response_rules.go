@@ -0,0 +1,171 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// removeCookieDomain is the cookie domain rewrite removing the domain attribute of the upstream cookies
+const removeCookieDomain = "-"
+
+// responseRules strip or rewrite the headers of the upstream responses, before they reach the client
+type responseRules struct {
+	// strip are the lower cased names of the removed headers
+	strip map[string]struct{}
+	// prefixes are the lower cased prefixes of the removed headers
+	prefixes []string
+	// override are the headers replacing the upstream values
+	override map[string]string
+	// cookieDomain rewrites the domain of the upstream cookies
+	cookieDomain string
+	// body rewrites the bodies of the upstream responses
+	body *bodyRewriter
+}
+
+// newResponseRules merges the global rules with the rules of a resource, with nil when there are none.
+// Stripped headers and body rewrites add up, while the overrides and the cookie domain of the resource take precedence.
+func newResponseRules(config *Config, resource *Resource) *responseRules {
+	strip := append([]string{}, config.StripResponseHeaders...)
+	rewrites := append([]*BodyRewrite{}, config.ResponseBodyRewrites...)
+	override := make(map[string]string, len(config.OverrideResponseHeaders))
+	for k, v := range config.OverrideResponseHeaders {
+		override[http.CanonicalHeaderKey(k)] = v
+	}
+	cookieDomain := config.ResponseCookieDomain
+	if resource != nil {
+		strip = append(strip, resource.StripResponseHeaders...)
+		rewrites = append(rewrites, resource.ResponseBodyRewrites...)
+		for k, v := range resource.OverrideResponseHeaders {
+			override[http.CanonicalHeaderKey(k)] = v
+		}
+		if resource.ResponseCookieDomain != "" {
+			cookieDomain = resource.ResponseCookieDomain
+		}
+	}
+	if len(strip) == 0 && len(override) == 0 && cookieDomain == "" && len(rewrites) == 0 {
+		return nil
+	}
+
+	rules := &responseRules{
+		strip:        make(map[string]struct{}, len(strip)),
+		override:     override,
+		cookieDomain: cookieDomain,
+		body:         newBodyRewriter(rewrites, config.ResponseBodyRewriteTypes, config.ResponseBodyRewriteMaxSize, resource != nil && resource.Decompress),
+	}
+	for _, name := range strip {
+		name = strings.ToLower(name)
+		if strings.HasSuffix(name, wildcard) {
+			rules.prefixes = append(rules.prefixes, strings.TrimSuffix(name, wildcard))
+			continue
+		}
+		rules.strip[name] = struct{}{}
+	}
+
+	return rules
+}
+
+// isResponseRulesValid checks the names of the stripped headers and the cookie domain rewrite
+func isResponseRulesValid(strip []string, cookieDomain string) error {
+	for _, name := range strip {
+		if name == "" || name == wildcard || strings.Contains(strings.TrimSuffix(name, wildcard), wildcard) {
+			return fmt.Errorf("invalid stripped response header %q, expect a header name, possibly with a trailing wildcard", name)
+		}
+	}
+	if strings.ContainsAny(cookieDomain, " ;,=") {
+		return fmt.Errorf("invalid response cookie domain %q", cookieDomain)
+	}
+
+	return nil
+}
+
+// applyResponseRules applies the rules of the resource carried by the request to an upstream response
+func applyResponseRules(res *http.Response) {
+	if res.Request == nil {
+		return
+	}
+	if rules, ok := res.Request.Context().Value(contextResponseRulesName).(*responseRules); ok {
+		rules.apply(res.Header)
+	}
+}
+
+// rewriteResponseBody applies the body rewrites of the resource carried by the request to an upstream response
+func rewriteResponseBody(res *http.Response) error {
+	if res.Request == nil {
+		return nil
+	}
+	if rules, ok := res.Request.Context().Value(contextResponseRulesName).(*responseRules); ok && rules.body != nil {
+		return rules.body.rewrite(res)
+	}
+
+	return nil
+}
+
+// apply strips and overrides the headers of an upstream response
+func (r *responseRules) apply(header http.Header) {
+	for name := range header {
+		if r.isStripped(name) {
+			header.Del(name)
+		}
+	}
+	for k, v := range r.override {
+		if v == "" {
+			header.Del(k)
+			continue
+		}
+		header.Set(k, v)
+	}
+	if r.cookieDomain == "" {
+		return
+	}
+	for i, cookie := range header["Set-Cookie"] {
+		header["Set-Cookie"][i] = rewriteCookieDomain(cookie, r.cookieDomain)
+	}
+}
+
+// isStripped checks if a header is removed from the upstream responses
+func (r *responseRules) isStripped(name string) bool {
+	name = strings.ToLower(name)
+	if _, found := r.strip[name]; found {
+		return true
+	}
+	for _, prefix := range r.prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rewriteCookieDomain replaces the domain attribute of a Set-Cookie header, leaving the other attributes untouched
+func rewriteCookieDomain(cookie, domain string) string {
+	parts := strings.Split(cookie, ";")
+	rewritten := make([]string, 0, len(parts)+1)
+	for i, part := range parts {
+		if i > 0 && strings.HasPrefix(strings.ToLower(strings.TrimSpace(part)), "domain=") {
+			continue
+		}
+		rewritten = append(rewritten, part)
+	}
+	if domain != removeCookieDomain {
+		rewritten = append(rewritten, " Domain="+domain)
+	}
+
+	return strings.Join(rewritten, ";")
+}
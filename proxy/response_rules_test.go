@@ -0,0 +1,154 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRewriteCookieDomain(t *testing.T) {
+	cs := []struct {
+		Cookie   string
+		Domain   string
+		Expected string
+	}{
+		{Cookie: "sid=1; Path=/; Domain=internal.local; HttpOnly", Domain: "example.com", Expected: "sid=1; Path=/; HttpOnly; Domain=example.com"},
+		{Cookie: "sid=1; domain=.internal.local", Domain: removeCookieDomain, Expected: "sid=1"},
+		{Cookie: "sid=1", Domain: "example.com", Expected: "sid=1; Domain=example.com"},
+		{Cookie: "domain=1; Path=/", Domain: removeCookieDomain, Expected: "domain=1; Path=/"},
+	}
+	for i, c := range cs {
+		assert.Equal(t, c.Expected, rewriteCookieDomain(c.Cookie, c.Domain), "case %d", i)
+	}
+}
+
+func TestResponseRules(t *testing.T) {
+	config := &Config{
+		StripResponseHeaders:    []string{"Server", "x-powered-by"},
+		OverrideResponseHeaders: map[string]string{"cache-control": "no-store"},
+	}
+	resource := &Resource{
+		StripResponseHeaders:    []string{"Access-Control-*"},
+		OverrideResponseHeaders: map[string]string{"Cache-Control": "private", "X-Debug": ""},
+		ResponseCookieDomain:    "example.com",
+	}
+	assert.Nil(t, newResponseRules(&Config{}, nil))
+	assert.Nil(t, newResponseRules(&Config{}, &Resource{}))
+
+	header := http.Header{
+		"Server":                       {"nginx"},
+		"X-Powered-By":                 {"PHP/7.4"},
+		"Access-Control-Allow-Origin":  {"*"},
+		"Access-Control-Allow-Headers": {"*"},
+		"Cache-Control":                {"public"},
+		"X-Debug":                      {"true"},
+		"Set-Cookie":                   {"a=1; Domain=internal.local", "b=2"},
+		"Content-Type":                 {"text/plain"},
+	}
+	newResponseRules(config, resource).apply(header)
+	assert.Equal(t, http.Header{
+		"Cache-Control": {"private"},
+		"Set-Cookie":    {"a=1; Domain=example.com", "b=2; Domain=example.com"},
+		"Content-Type":  {"text/plain"},
+	}, header)
+
+	header = http.Header{"Server": {"nginx"}, "Access-Control-Allow-Origin": {"*"}, "Cache-Control": {"public"}}
+	newResponseRules(config, nil).apply(header)
+	assert.Equal(t, http.Header{"Access-Control-Allow-Origin": {"*"}, "Cache-Control": {"no-store"}}, header)
+}
+
+func TestResponseRulesValid(t *testing.T) {
+	assert.NoError(t, isResponseRulesValid([]string{"Server", "X-Powered-*"}, "example.com"))
+	assert.NoError(t, isResponseRulesValid(nil, removeCookieDomain))
+	assert.Error(t, isResponseRulesValid([]string{""}, ""))
+	assert.Error(t, isResponseRulesValid([]string{"*"}, ""))
+	assert.Error(t, isResponseRulesValid([]string{"X-*-By"}, ""))
+	assert.Error(t, isResponseRulesValid(nil, "example.com; Secure"))
+}
+
+func TestUpstreamResponseRules(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Server", "nginx")
+		w.Header().Set("X-Powered-By", "PHP/7.4")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Set-Cookie", "sid=1; Path=/; Domain=internal.local")
+		(&fakeUpstreamService{}).ServeHTTP(w, req)
+	}))
+	defer upstream.Close()
+
+	cfg := newFakeKeycloakConfig()
+	cfg.StripResponseHeaders = []string{"Server", "X-Powered-By"}
+	cfg.Resources = []*Resource{
+		{
+			URL:                  "/filtered/*",
+			Methods:              allHTTPMethods,
+			WhiteListed:          true,
+			Upstream:             upstream.URL,
+			StripResponseHeaders: []string{"Access-Control-*"},
+			ResponseCookieDomain: removeCookieDomain,
+		},
+		{
+			URL:         "/streamed/*",
+			Methods:     allHTTPMethods,
+			WhiteListed: true,
+			Upstream:    upstream.URL,
+			Streaming:   true,
+		},
+		{
+			URL:         "/plain/*",
+			Methods:     allHTTPMethods,
+			WhiteListed: true,
+			Upstream:    upstream.URL,
+		},
+	}
+	p := newFakeProxy(cfg)
+	p.proxy.upstream = p.proxy.newUpstreamProxy(http.DefaultTransport, false)
+	p.RunTests(t, []fakeRequest{
+		{
+			URI:           "/filtered/test",
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+			ExpectedHeaders: map[string]string{
+				"Server":                      "",
+				"X-Powered-By":                "",
+				"Access-Control-Allow-Origin": "",
+				"Set-Cookie":                  "sid=1; Path=/",
+			},
+		},
+		{
+			URI:           "/streamed/test",
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+			ExpectedHeaders: map[string]string{
+				"Server":                      "",
+				"Access-Control-Allow-Origin": "*",
+			},
+		},
+		{
+			URI:           "/plain/test",
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+			ExpectedHeaders: map[string]string{
+				"X-Powered-By": "",
+				"Set-Cookie":   "sid=1; Path=/; Domain=internal.local",
+			},
+		},
+	})
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+
+	"github.com/go-chi/chi/middleware"
+)
+
+// informationalWriter is a response writer which records the status and size of the response, and forwards
+// the informational (1xx) responses of the upstreams, e.g. 103 Early Hints. The wrapped writer would otherwise
+// take these as the final response, and drop the final status.
+type informationalWriter struct {
+	middleware.WrapResponseWriter
+	w http.ResponseWriter
+}
+
+// newResponseWriter wraps a response writer, in place of middleware.NewWrapResponseWriter
+func newResponseWriter(w http.ResponseWriter, protoMajor int) middleware.WrapResponseWriter {
+	return &informationalWriter{WrapResponseWriter: middleware.NewWrapResponseWriter(w, protoMajor), w: w}
+}
+
+// WriteHeader sends the informational responses straight to the client. 101 Switching Protocols is final.
+func (i *informationalWriter) WriteHeader(code int) {
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		i.w.WriteHeader(code)
+		return
+	}
+	i.WrapResponseWriter.WriteHeader(code)
+}
+
+// Flush implements http.Flusher
+func (i *informationalWriter) Flush() {
+	if flusher, ok := i.WrapResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker, when supported by the wrapped writer
+func (i *informationalWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := i.WrapResponseWriter.(http.Hijacker); ok {
+		return hijacker.Hijack()
+	}
+
+	return nil, nil, http.ErrNotSupported
+}
+
+// Push implements http.Pusher, when supported by the wrapped writer
+func (i *informationalWriter) Push(target string, opts *http.PushOptions) error {
+	if pusher, ok := i.WrapResponseWriter.(http.Pusher); ok {
+		return pusher.Push(target, opts)
+	}
+
+	return http.ErrNotSupported
+}
@@ -0,0 +1,827 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"net/http/httputil"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/cors"
+	"go.uber.org/zap"
+	"golang.org/x/net/http2"
+)
+
+// createReverseProxy creates a reverse proxy
+func (r *oauthProxy) createReverseProxy() error {
+	r.log.Info("enabled reverse proxy mode, default upstream url", zap.String("url", r.config.Upstream))
+	if err := r.createStdProxy(r.endpoint); err != nil {
+		return err
+	}
+	// @step: the extension methods of the resources must be known to the router before any route is added
+	for _, x := range r.config.Resources {
+		registerHTTPMethods(x.Methods)
+	}
+
+	engine := chi.NewRouter()
+	r.useDefaultStack(engine)
+	if r.config.ServerMaxHeaderBytes > 0 || r.config.ServerMaxHeaderCount > 0 {
+		engine.Use(r.headerLimitsMiddleware(r.config.ServerMaxHeaderBytes, r.config.ServerMaxHeaderCount))
+	}
+	if r.config.EnableMethodOverride {
+		engine.Use(r.methodOverrideMiddleware)
+	}
+	if r.config.EnableClientCertHeaders {
+		engine.Use(clientCertHeadersMiddleware)
+	}
+
+	// @step: configure CORS middleware
+	r.useCors(engine)
+
+	// @step: limit the concurrent requests per client
+	if r.config.MaxInflightPerIP > 0 || r.config.MaxInflightPerIdentity > 0 {
+		engine.Use(r.inflightMiddleware())
+	}
+
+	r.router = engine
+
+	if len(r.config.ResponseHeaders) > 0 {
+		engine.Use(r.responseHeaderMiddleware(r.config.ResponseHeaders))
+	}
+
+	// configure CSRF middleware
+	r.csrf = r.csrfConfigMiddleware()
+
+	// step: add the handlers for oauth
+	oauth := engine.With(
+		proxyDenyMiddleware,
+		r.csrfSkipMiddleware(), // handle CSRF state, but skip check on POST endpoints below
+		r.csrfProtectMiddleware(),
+		r.csrfHeaderMiddleware())
+	oauthRoutes := oauth.Route(r.config.OAuthURI,
+		func(e chi.Router) {
+			e.NotFound(http.NotFound)
+			e.MethodNotAllowed(methodNotAllowedHandler)
+
+			// disabled endpoints are not routed and respond 404
+			enabled := func(endpoint string) bool {
+				return !r.config.isEndpointDisabled(endpoint)
+			}
+
+			// the endpoints relying on the provider are unavailable until its configuration is retrieved
+			provider := e.With(r.providerMiddleware)
+
+			if enabled(authorizationURL) {
+				provider.HandleFunc(authorizationURL, r.oauthAuthorizationHandler)
+			}
+			if enabled(callbackURL) {
+				provider.Get(callbackURL, r.oauthCallbackHandler)
+				if r.config.ResponseMode == responseModeFormPost {
+					provider.Post(callbackURL, r.oauthCallbackHandler)
+				}
+			}
+			if enabled(expiredURL) {
+				e.Get(expiredURL, r.expirationHandler)
+			}
+
+			if r.config.EnableSilentRenewal && enabled(silentURL) {
+				provider.Get(silentURL, r.silentRenewalHandler)
+			}
+
+			if enabled(logoutURL) {
+				provider.With(r.authenticationMiddleware()).Get(logoutURL, r.logoutHandler)
+			}
+			if enabled(tokenURL) {
+				provider.With(r.authenticationMiddleware()).Get(tokenURL, r.tokenHandler)
+			}
+
+			if r.config.EnableCSRF && enabled(csrfURL) {
+				provider.With(r.authenticationMiddleware()).Get(csrfURL, r.csrfTokenHandler)
+			}
+
+			if r.config.EnableRefreshTokens && enabled(refreshURL) {
+				provider.With(r.authenticationMiddleware()).Get(refreshURL, r.refreshHandler)
+			}
+
+			if enabled(loginURL) {
+				provider.Post(loginURL, r.loginHandler)
+			}
+
+			if r.config.EnableNotBeforePush {
+				provider.Post(pushNotBeforeURL, r.pushNotBeforeHandler)
+			}
+
+			if r.config.EnableBackChannelLogout {
+				provider.Post(backChannelURL, r.backChannelLogoutHandler)
+			}
+
+			if r.config.EnableWellKnown {
+				provider.Get(jwksURL, r.jwksHandler)
+			}
+
+			if r.config.EnableForwardAuth {
+				provider.With(r.forwardAuthMiddleware, r.authenticationMiddleware()).HandleFunc(forwardAuthURL, r.forwardAuthHandler())
+			}
+
+			if r.config.ListenAdmin == "" {
+				e.Mount("/", r.createAdminRoutes())
+			}
+		})
+	if r.config.BaseURI != "" {
+		// the outer proxy may forward the requests with or without the base path
+		oauth.Mount(path.Join(r.config.BaseURI, r.config.OAuthURI), oauthRoutes)
+	}
+
+	if r.config.ListenAdmin == "" {
+		// if no dedicated admin listener is set, publish debug routes on main listener
+		if debugEngine := r.createDebugRoutes(); debugEngine != nil {
+			engine.With(proxyDenyMiddleware).Mount(debugURL, debugEngine)
+		}
+	}
+
+	if r.config.EnableWellKnown {
+		// the browser apps discover the provider through the proxy
+		wellKnown := engine.With(proxyDenyMiddleware, r.providerMiddleware)
+		wellKnown.Get(wellKnownURL, r.wellKnownHandler)
+		if r.config.BaseURI != "" {
+			wellKnown.Get(path.Join(r.config.BaseURI, wellKnownURL), r.wellKnownHandler)
+		}
+	}
+
+	// step: load the templates if any
+	if err := r.createTemplates(); err != nil {
+		return err
+	}
+
+	// step: provision the protected resources
+	addDefaultDeny := r.config.EnableDefaultDeny
+	for _, x := range r.config.Resources {
+		if x.URL[len(x.URL)-1:] == "/" {
+			r.log.Warn("the resource url is not a prefix",
+				zap.String("resource", x.URL),
+				zap.String("change", x.URL),
+				zap.String("amended", strings.TrimRight(x.URL, "/")))
+		}
+		if x.URL == allRoutes && r.config.EnableDefaultDeny {
+			addDefaultDeny = false
+		}
+	}
+
+	// step: define expected behaviour on default route: "/*"
+	if addDefaultDeny {
+		if r.config.EnableDefaultNotFound {
+			r.log.Info("routes which are not explicitly declared as resources will respond 401 not authenticated or 404 NotFound for authenticated users")
+			engine.With(r.providerMiddleware, r.authenticationMiddleware()).
+				Handle(allRoutes, http.HandlerFunc(methodNotFoundHandler))
+		} else {
+			r.log.Info("adding a default denial to protected resources: all routes to upstream require authentication")
+			r.config.Resources = append(r.config.Resources, &Resource{URL: allRoutes, Methods: allHTTPMethods})
+		}
+	} else {
+		if r.config.EnableDefaultNotFound {
+			// this setting kicks in only on default catch all route, not if one has been explicitly set up
+			foundAllRoutes := false
+			for _, x := range r.config.Resources {
+				if x.URL == allRoutes {
+					foundAllRoutes = true
+					break
+				}
+			}
+			if !foundAllRoutes {
+				r.log.Info("routes which are not explicitly declared as resources will respond 404 NotFound")
+				engine.Handle(allRoutes, http.HandlerFunc(methodNotFoundHandler))
+			}
+		} else {
+			r.log.Warn("routes to upstream are not configured to be denied by default")
+			engine.With(r.proxyMiddleware(nil), r.preAuthPluginsMiddleware(), r.preUpstreamPluginsMiddleware()).HandleFunc(allRoutes, emptyHandler)
+		}
+	}
+
+	// the in-flight requests of a user are limited across all resources
+	inflightIdentity := r.inflightIdentityMiddleware()
+	for _, x := range r.config.Resources {
+		if x.Realm != "" {
+			// served by the proxy of the realm
+			continue
+		}
+		r.log.Info("protecting resource", zap.String("resource", x.String()))
+		script, err := compileScript(x.Script)
+		if err != nil {
+			return fmt.Errorf("invalid script for resource %s: %s", x.URL, err)
+		}
+		switch {
+		case !x.WhiteListed && !x.BlackListed:
+			authentication := r.authenticationMiddleware()
+			if x.OptionalAuth {
+				authentication = r.optionalAuthenticationMiddleware()
+			}
+			e := engine.With(
+				resourceMiddleware(x),
+				r.identityAccountingMiddleware(x),
+				r.decompressMiddleware(x),
+				r.debugCaptureMiddleware(x),
+				r.graphQLBodyMiddleware(x),
+				r.proxyMiddleware(x),
+				r.preAuthPluginsMiddleware(),
+				r.clientCertMiddleware(x, r.providerMiddleware, authentication),
+				r.postAuthPluginsMiddleware(),
+				inflightIdentity,
+				r.byteQuotaMiddleware(),
+				r.upstreamBackoffMiddleware(),
+				r.admissionMiddleware(x),
+				r.identityHeadersMiddleware(r.config.AddClaims),
+				r.scriptMiddleware(script),
+				r.csrfSkipResourceMiddleware(x),
+				r.csrfProtectMiddleware(),
+				r.csrfHeaderMiddleware(),
+				r.preUpstreamPluginsMiddleware(),
+				r.faultInjectionMiddleware(x))
+			e.Handle(x.URL, http.HandlerFunc(methodNotAllowedHandler))
+			for _, m := range x.Methods {
+				e.MethodFunc(m, x.URL, emptyHandler)
+			}
+		case x.WhiteListed:
+			e := engine.With(
+				resourceMiddleware(x),
+				r.decompressMiddleware(x),
+				r.debugCaptureMiddleware(x),
+				r.proxyMiddleware(x),
+				r.preAuthPluginsMiddleware(),
+				r.scriptMiddleware(script),
+				r.preUpstreamPluginsMiddleware(),
+				r.faultInjectionMiddleware(x))
+			e.Handle(x.URL, http.HandlerFunc(methodNotAllowedHandler))
+			for _, m := range x.Methods {
+				e.MethodFunc(m, x.URL, emptyHandler)
+			}
+		case x.BlackListed:
+			fallthrough
+		default:
+			engine.With(resourceMiddleware(x)).Handle(x.URL, http.HandlerFunc(r.forbiddenHandler))
+		}
+	}
+
+	// startup information
+
+	if r.config.EnableSessionCookies {
+		r.log.Info("using session cookies only for access and refresh tokens")
+	}
+
+	for name, value := range r.config.MatchClaims {
+		r.log.Info("token must contain", zap.String("claim", name), zap.String("value", value))
+	}
+
+	if r.config.CorsDisableUpstream {
+		r.log.Warn("CorsDisableUpstream is now deprecated and you may safely remove this from your configuration")
+	}
+
+	if r.config.RedirectionURL == "" {
+		r.log.Warn("no redirection url has been set, will use host headers")
+	}
+
+	if r.config.EnableEncryptedToken {
+		r.log.Info("session access tokens will be encrypted")
+	}
+
+	if r.config.SkipUpstreamTLSVerify && r.config.UpstreamCA != "" {
+		r.log.Warn("you have specified an upstream CA to check, but have left the skip-upstream-tls-verify parameter to true (the default)")
+	}
+
+	return nil
+}
+
+// proxyMiddleware is responsible for handling reverse proxy request to the upstream endpoint
+func (r *oauthProxy) proxyMiddleware(resource *Resource) func(http.Handler) http.Handler {
+	if resource != nil && resource.StaticDirectory != "" {
+		return r.staticFilesMiddleware(resource)
+	}
+	var upstreamHost, upstreamScheme, upstreamBasePath, stripBasePath, matched string
+	if resource != nil && resource.Upstream != "" {
+		// resource-specific routing to upstream
+		u, _ := url.Parse(resource.Upstream)
+		matched = resource.URL
+		upstreamHost = u.Host
+		upstreamScheme = u.Scheme
+		upstreamBasePath = u.Path
+	} else {
+		// default routing
+		upstreamHost = r.endpoint.Host
+		upstreamScheme = r.endpoint.Scheme
+		upstreamBasePath = r.endpoint.Path
+	}
+	var pool string
+	var timeout time.Duration
+	var coalesce bool
+	budget := r.config.RequestTimeout
+	if resource != nil {
+		stripBasePath = resource.StripBasePath
+		pool = resource.URL
+		timeout = resource.ResponseTimeout
+		coalesce = resource.Coalesce
+		if resource.Streaming {
+			budget = 0
+		}
+	}
+
+	// config-driven header setters
+	setters := make([]func(*http.Request), 0, 20)
+	if len(r.config.CorsOrigins) > 0 || resource != nil && len(resource.CorsOrigins) > 0 {
+		setters = append(setters, func(req *http.Request) {
+			// if CORS is enabled by gatekeeper, do not propagate CORS requests upstream
+			req.Header.Del("Origin")
+		})
+	}
+
+	if len(r.config.Headers) > 0 {
+		setters = append(setters, func(req *http.Request) {
+			// add any custom headers to the request
+			for k, v := range r.config.Headers {
+				req.Header.Set(k, v)
+			}
+		})
+	}
+	cookieFilter := make([]string, 0, 4)
+	cookieFilter = append(cookieFilter, requestURICookie, requestStateCookie)
+	if r.config.EnableCSRF {
+		setters = append(setters, func(req *http.Request) {
+			// remove csrf header
+			req.Header.Del(r.config.CSRFHeader)
+		})
+		cookieFilter = append(cookieFilter, r.config.CSRFCookieName)
+	}
+	if !r.config.EnableAuthorizationCookies {
+		cookieFilter = append(cookieFilter, r.config.CookieAccessName, r.config.CookieRefreshName)
+	}
+	setters = append(setters, func(req *http.Request) {
+		// cookies filtered to upstream
+		_ = filterCookies(req, cookieFilter)
+	})
+
+	setHeaders := func(req *http.Request) {
+		for _, setter := range setters {
+			setter(req)
+		}
+	}
+	rules := newResponseRules(r.config, resource)
+	routing := newClaimRouting(resource)
+	headerRouting := newHeaderRouting(resource)
+	signer := r.newAWSSigner(resource)
+	balancer := r.newUpstreamBalancer(resource)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			started := time.Now()
+			next.ServeHTTP(w, req)
+
+			_, span, logger := r.traceSpan(req.Context(), "reverse proxy middleware")
+			if span != nil {
+				defer span.End()
+				propagateSpan(span, req)
+			}
+
+			// @step: retrieve the request scope
+			scope := req.Context().Value(contextScopeName)
+			if scope != nil {
+				sc := scope.(*RequestScope)
+				if sc.AccessDenied {
+					return
+				}
+			}
+
+			// @step: the upstream may be selected by a claim of the user, or else by a header of the request
+			host, scheme, basePath := upstreamHost, upstreamScheme, upstreamBasePath
+			var routed bool
+			if routing != nil {
+				if value, u := routing.upstream(req); u != nil {
+					logger.Debug("routing on claim", zap.String("claim", routing.claim), zap.String("value", value), zap.String("upstream", u.Host))
+					host, scheme, basePath = u.Host, u.Scheme, u.Path
+					routed = true
+				}
+			}
+			if headerRouting != nil && !routed {
+				if value, u := headerRouting.upstream(req); u != nil {
+					logger.Debug("routing on header", zap.String("header", headerRouting.header), zap.String("value", value), zap.String("upstream", u.Host))
+					host, scheme, basePath = u.Host, u.Scheme, u.Path
+					routed = true
+				}
+			}
+			if balancer != nil && !routed {
+				picked := balancer.pick()
+				defer picked.release()
+				host, scheme, basePath = picked.url.Host, picked.url.Scheme, picked.url.Path
+			}
+
+			// @step: add the proxy forwarding headers
+			req.Header.Add("X-Forwarded-For", realIP(req)) // TODO(fredbi): check if still necessary with net/http/httputil reverse proxy
+			req.Header.Set("X-Forwarded-Host", req.Host)
+			if fp := req.Header.Get("X-Forwarded-Proto"); fp != "" {
+				req.Header.Set("X-Forwarded-Proto", fp)
+			} else {
+				req.Header.Set("X-Forwarded-Proto", scheme)
+			}
+
+			// config-driven headers
+			setHeaders(req)
+
+			req.URL.Host = host
+			req.URL.Scheme = scheme
+			if stripBasePath != "" {
+				// strip prefix if needed
+				logger.Debug("stripping prefix from URL", zap.String("stripBasePath", stripBasePath), zap.String("original_path", req.URL.Path))
+				req.URL.Path = strings.TrimPrefix(req.URL.Path, stripBasePath)
+			}
+			if basePath != "" {
+				// add upstream URL component if any
+				req.URL.Path = path.Join(basePath, req.URL.Path)
+			}
+
+			// @note: by default goproxy only provides a forwarding proxy, thus all requests have to be absolute and we must update the host headers
+			if v := req.Header.Get("Host"); v != "" {
+				req.Host = v
+				req.Header.Del("Host")
+			} else if !r.config.PreserveHost {
+				req.Host = host
+			}
+			logger.Debug("proxying to upstream", zap.String("matched_resource", matched), zap.Stringer("upstream_url", req.URL), zap.String("host_header", req.Host))
+
+			upstream := r.upstream
+			if dedicated, ok := r.upstreams[pool]; ok {
+				upstream = dedicated
+			}
+			if rules != nil {
+				// the response header rules of the resource are applied by the upstream proxy
+				req = req.WithContext(context.WithValue(req.Context(), contextResponseRulesName, rules))
+			}
+			// upgraded connections (e.g. websockets) outlive their response and are not limited by deadlines
+			upgraded := req.Header.Get(headerUpgrade) != ""
+			if budget > 0 && !upgraded {
+				// the budget of the request runs from the start of the resource chain, authentication included
+				ctx, cancel := context.WithDeadline(req.Context(), started.Add(budget))
+				defer cancel()
+				req = req.WithContext(ctx)
+			}
+			if timeout > 0 && !upgraded {
+				// the upstream request is canceled past the deadline, and reported by the upstream proxy
+				ctx, cancel := context.WithTimeout(req.Context(), timeout)
+				defer cancel()
+				req = req.WithContext(ctx)
+			}
+			if deadline, ok := req.Context().Deadline(); ok && r.config.EnableRequestTimeoutHeader {
+				req.Header.Set(headerXRequestTimeout, strconv.FormatInt(int64(time.Until(deadline)/time.Millisecond), 10))
+			}
+			if signer != nil {
+				// the request is signed last, with the headers and path sent upstream
+				if err := signer.sign(req); err != nil {
+					code := http.StatusBadGateway
+					if err == errAWSPayloadTooLarge {
+						code = http.StatusRequestEntityTooLarge
+					}
+					r.errorResponse(w, req, "unable to sign the upstream request", code, err)
+					return
+				}
+			}
+			if coalesce {
+				r.coalesceUpstream(upstream, w, req)
+			} else {
+				upstream.ServeHTTP(w, req)
+			}
+
+			if r.config.Verbose {
+				// debug response headers
+				logger.Debug("response from gatekeeper", zap.Any("headers", w.Header()))
+			}
+		})
+	}
+}
+
+// createStdProxy creates a reverse http proxy client to the upstream
+// TODO(fredbi): support multiple proxies with possibly different dialers and TLS configs
+func (r *oauthProxy) createStdProxy(upstream *url.URL) error {
+	// NOTE(http2): in order to properly receive response headers, the connect timeout has to be less than ServerWriteTimeout
+	defaultDialer := r.resolver.dialContext(newDialer(r.config.UpstreamTimeout, r.config.UpstreamKeepaliveTimeout, r.config.UpstreamFallbackDelay))
+	dialer := defaultDialer
+
+	// are we using a unix socket?
+	// TODO(fredbi): this does not work with multiple upstream configuration
+	// TODO(fredbi): create as many upstreams as different upstream schemes
+	if upstream != nil && upstream.Scheme == "unix" {
+		r.log.Info("using unix socket for upstream", zap.String("socket", fmt.Sprintf("%s%s", upstream.Host, upstream.Path)))
+
+		socketPath := fmt.Sprintf("%s%s", upstream.Host, upstream.Path)
+		dialer = func(_ context.Context, network, address string) (net.Conn, error) {
+			return net.Dial("unix", socketPath)
+		}
+		upstream.Path = ""
+		upstream.Host = "domain-sock"
+		upstream.Scheme = unsecureScheme
+	}
+
+	// create the upstream tls configuration
+	tlsConfig, err := r.buildProxyTLSConfig()
+	if err != nil {
+		return err
+	}
+
+	var transport http.RoundTripper
+	if r.config.UpstreamH2C {
+		transport = newH2CTransport(defaultUpstreamPool, dialer)
+	} else if transport, err = r.newUpstreamTransport(defaultUpstreamPool, dialer, tlsConfig, connectionPool{
+		maxIdleConns:        r.config.MaxIdleConns,
+		maxIdleConnsPerHost: r.config.MaxIdleConnsPerHost,
+		idleConnTimeout:     r.config.UpstreamIdleConnTimeout,
+		maxConnLifetime:     r.config.UpstreamMaxConnLifetime,
+	}); err != nil {
+		return err
+	}
+	r.upstream = r.newUpstreamProxy(transport, false)
+
+	// @step: resources may use a dedicated connection pool, so a busy upstream does not starve the others,
+	// or stream the upstream responses
+	r.upstreams = make(map[string]reverseProxy)
+	for _, x := range r.config.Resources {
+		if !x.hasConnectionPool() {
+			if x.Streaming || x.HedgeDelay > 0 {
+				r.upstreams[x.URL] = r.newUpstreamProxy(newHedgedTransport(x, transport), x.Streaming)
+			}
+			continue
+		}
+		pool := connectionPool{
+			maxIdleConns:        r.config.MaxIdleConns,
+			maxIdleConnsPerHost: r.config.MaxIdleConnsPerHost,
+			maxConnsPerHost:     x.MaxConnsPerHost,
+			idleConnTimeout:     r.config.UpstreamIdleConnTimeout,
+			maxConnLifetime:     r.config.UpstreamMaxConnLifetime,
+		}
+		if x.MaxIdleConns > 0 {
+			pool.maxIdleConns = x.MaxIdleConns
+		}
+		if x.MaxIdleConnsPerHost > 0 {
+			pool.maxIdleConnsPerHost = x.MaxIdleConnsPerHost
+		}
+		if pool.maxIdleConnsPerHost > pool.maxIdleConns {
+			pool.maxIdleConnsPerHost = pool.maxIdleConns
+		}
+		if x.IdleConnTimeout > 0 {
+			pool.idleConnTimeout = x.IdleConnTimeout
+		}
+		if x.MaxConnLifetime > 0 {
+			pool.maxConnLifetime = x.MaxConnLifetime
+		}
+		resourceDialer := dialer
+		if x.Upstream != "" {
+			resourceDialer = defaultDialer
+		}
+		if x.UpstreamH2C || r.config.UpstreamH2C {
+			r.log.Info("using a dedicated h2c upstream connection", zap.String("resource", x.URL))
+			r.upstreams[x.URL] = r.newUpstreamProxy(newHedgedTransport(x, newH2CTransport(x.URL, resourceDialer)), x.Streaming)
+			continue
+		}
+		r.log.Info("using a dedicated upstream connection pool",
+			zap.String("resource", x.URL),
+			zap.Int("max_idle_connections", pool.maxIdleConns),
+			zap.Int("max_idle_connections_per_host", pool.maxIdleConnsPerHost),
+			zap.Int("max_connections_per_host", pool.maxConnsPerHost),
+			zap.Duration("idle_connection_timeout", pool.idleConnTimeout),
+			zap.Duration("max_connection_lifetime", pool.maxConnLifetime))
+
+		transport, err := r.newUpstreamTransport(x.URL, resourceDialer, tlsConfig, pool)
+		if err != nil {
+			return err
+		}
+		r.upstreams[x.URL] = r.newUpstreamProxy(newHedgedTransport(x, transport), x.Streaming)
+	}
+
+	return nil
+}
+
+// newUpstreamTransport creates an instrumented transport to the upstream
+func (r *oauthProxy) newUpstreamTransport(name string, dialer dialContextFunc, tlsConfig *tls.Config, pool connectionPool) (http.RoundTripper, error) {
+	lifetimes := newConnLifetimes(pool.maxConnLifetime)
+	transport := &http.Transport{
+		DialContext:           instrumentDialer(name, dialer, lifetimes),
+		Proxy:                 makeProxyFunc(r.config.UpstreamProxy, r.config.UpstreamNoProxy),
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   r.config.UpstreamTLSHandshakeTimeout,
+		MaxIdleConns:          pool.maxIdleConns,
+		MaxIdleConnsPerHost:   pool.maxIdleConnsPerHost,
+		MaxConnsPerHost:       pool.maxConnsPerHost,
+		IdleConnTimeout:       pool.idleConnTimeout,
+		DisableKeepAlives:     !r.config.UpstreamKeepalives,
+		ExpectContinueTimeout: r.config.UpstreamExpectContinueTimeout,
+		ResponseHeaderTimeout: r.config.UpstreamResponseHeaderTimeout,
+	}
+	if err := http2.ConfigureTransport(transport); err != nil {
+		return nil, err
+	}
+	r.useUpstreamCA(transport)
+
+	return &instrumentedTransport{RoundTripper: transport, name: name, lifetimes: lifetimes}, nil
+}
+
+// newUpstreamProxy creates a reverse http proxy to the upstream, using the given transport.
+// A streaming proxy flushes after each write, and only applies the response header rules to the upstream response.
+func (r *oauthProxy) newUpstreamProxy(transport http.RoundTripper, streaming bool) reverseProxy {
+	proxy := &httputil.ReverseProxy{
+		Director:      func(*http.Request) {}, // most of the work is already done by middleware above. Some of this could be done by Director just as well
+		Transport:     transport,
+		BufferPool:    copyBuffers,
+		FlushInterval: r.config.UpstreamFlushInterval,
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			_, span, logger := r.traceSpan(req.Context(), "reverse proxy middleware")
+			if span != nil {
+				defer span.End()
+			}
+
+			if req.Context().Err() == context.DeadlineExceeded {
+				logger.Warn("upstream response timeout", zap.Error(err))
+				r.errorPageResponse(w, req, r.config.GatewayTimeoutPage, "", http.StatusGatewayTimeout)
+				return
+			}
+			logger.Warn("reverse proxy error", zap.Error(err))
+			r.errorResponse(w, req, "", http.StatusBadGateway, err)
+		},
+	}
+	if streaming {
+		proxy.FlushInterval = -1
+		proxy.ModifyResponse = func(res *http.Response) error {
+			r.backoff.record(res)
+			applyResponseRules(res)
+			return nil
+		}
+		return proxy
+	}
+
+	proxy.ModifyResponse = func(res *http.Response) error {
+		r.backoff.record(res)
+		if r.config.Verbose {
+			// debug response headers
+			r.log.Debug("response from upstream",
+				zap.Int("status code", res.StatusCode),
+				zap.String("proto", res.Proto),
+				zap.Int64("content-length", res.ContentLength),
+				zap.Any("headers", res.Header))
+		}
+		// filter out possible conflicting headers from upstream (i.e. gatekeeper value override)
+		if r.config.EnableSecurityFilter {
+			if r.config.EnableBrowserXSSFilter {
+				res.Header.Del(headerXXSSProtection)
+			}
+			if r.config.ContentSecurityPolicy != "" {
+				res.Header.Del(headerXPolicy)
+			}
+			if r.config.EnableContentNoSniff {
+				res.Header.Del(headerXContentTypeOptions)
+			}
+			if r.config.EnableFrameDeny {
+				res.Header.Del(headerXFrameOptions)
+			}
+			if r.config.EnableSTS || r.config.EnableSTSPreload {
+				res.Header.Del(headerXSTS)
+			}
+		}
+		for hdr := range r.config.Headers {
+			res.Header.Del(hdr)
+		}
+
+		if r.config.hasCors() && res.Request.Header.Get("Origin") == "" {
+			// remove cors headers from upstream, whenever CORS is handled by gatekeeper for this request
+			// This avoids the concatenation of multiple headers whenever
+			// upstreams response provides some CORS headers.
+			res.Header.Del("Access-Control-Allow-Origin")
+			res.Header.Del("Access-Control-Allow-Credentials")
+			res.Header.Del("Access-Control-Allow-Headers")
+			res.Header.Del("Access-Control-Allow-Methods")
+			res.Header.Del("Access-Control-Max-Age")
+		}
+		applyResponseRules(res)
+		if err := rewriteResponseBody(res); err != nil {
+			return err
+		}
+
+		return r.postUpstreamPlugins(res)
+	}
+
+	return proxy
+}
+
+// registerHTTPMethods adds the extension methods to the methods routed by chi
+func registerHTTPMethods(methods []string) {
+	for _, m := range methods {
+		if !isValidHTTPMethod(m) {
+			chi.RegisterMethod(m)
+		}
+	}
+}
+
+// corsOptions returns the CORS policy for a resource, with the global settings as defaults
+func (r *oauthProxy) corsOptions(resource *Resource) cors.Options {
+	options := cors.Options{
+		AllowedOrigins:   r.config.CorsOrigins,
+		AllowedMethods:   r.config.CorsMethods,
+		AllowedHeaders:   r.config.CorsHeaders,
+		AllowCredentials: r.config.CorsCredentials,
+		ExposedHeaders:   r.config.CorsExposedHeaders,
+		MaxAge:           int(r.config.CorsMaxAge.Seconds()),
+		Debug:            r.config.Verbose,
+	}
+	if resource == nil {
+		return withOriginPatterns(options)
+	}
+	if len(resource.CorsOrigins) > 0 {
+		options.AllowedOrigins = resource.CorsOrigins
+	}
+	if len(resource.CorsMethods) > 0 {
+		options.AllowedMethods = resource.CorsMethods
+	}
+	if len(resource.CorsHeaders) > 0 {
+		options.AllowedHeaders = resource.CorsHeaders
+	}
+
+	return withOriginPatterns(options)
+}
+
+// withOriginPatterns validates origins by pattern when the CORS origins contain wildcard subdomains or regexes.
+// The matched origin is echoed back, which makes the response vary on the Origin header.
+func withOriginPatterns(options cors.Options) cors.Options {
+	if !hasOriginPatterns(options.AllowedOrigins) {
+		return options
+	}
+	matcher, err := newOriginMatcher(options.AllowedOrigins)
+	if err != nil {
+		// the origins have been checked when validating the configuration
+		return options
+	}
+	options.AllowedOrigins = nil
+	options.AllowOriginFunc = matcher.match
+
+	return options
+}
+
+func (r *oauthProxy) useCors(engine chi.Router) {
+	// @step: resources may override the global CORS policy. The resource is matched
+	// independently from the main router, because preflight requests must be answered
+	// before any authentication takes place.
+	policies := make(map[string]*cors.Cors)
+	matcher := chi.NewRouter()
+	for _, x := range r.config.Resources {
+		matcher.Handle(x.URL, http.HandlerFunc(emptyHandler))
+		if x.hasCors() {
+			policies[x.URL] = cors.New(r.corsOptions(x))
+		}
+	}
+
+	if len(policies) == 0 {
+		if len(r.config.CorsOrigins) > 0 {
+			engine.Use(cors.New(r.corsOptions(nil)).Handler)
+		}
+		return
+	}
+
+	var global *cors.Cors
+	if len(r.config.CorsOrigins) > 0 {
+		global = cors.New(r.corsOptions(nil))
+	}
+
+	engine.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			policy := global
+			if !strings.HasPrefix(req.URL.Path, r.config.OAuthURI+trailer) {
+				rctx := chi.NewRouteContext()
+				if matcher.Match(rctx, req.Method, req.URL.Path) {
+					if override, ok := policies[rctx.RoutePattern()]; ok {
+						policy = override
+					}
+				}
+			}
+			if policy == nil {
+				next.ServeHTTP(w, req)
+				return
+			}
+			policy.Handler(next).ServeHTTP(w, req)
+		})
+	})
+}
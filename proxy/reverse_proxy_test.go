@@ -0,0 +1,229 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResourceResponseTimeout(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-req.Context().Done():
+			return
+		}
+		(&fakeUpstreamService{}).ServeHTTP(w, req)
+	}))
+	defer upstream.Close()
+
+	cfg := newFakeKeycloakConfig()
+	cfg.GatewayTimeoutPage = "../templates/gateway_timeout.html.tmpl"
+	cfg.Resources = []*Resource{
+		{
+			URL:             "/slow/*",
+			Methods:         allHTTPMethods,
+			WhiteListed:     true,
+			Upstream:        upstream.URL,
+			ResponseTimeout: 50 * time.Millisecond,
+		},
+		{
+			URL:             "/patient/*",
+			Methods:         allHTTPMethods,
+			WhiteListed:     true,
+			Upstream:        upstream.URL,
+			ResponseTimeout: 10 * time.Second,
+		},
+	}
+	p := newFakeProxy(cfg)
+	p.proxy.upstream = p.proxy.newUpstreamProxy(http.DefaultTransport, false)
+	p.RunTests(t, []fakeRequest{
+		{
+			URI:                     "/slow/test",
+			ExpectedCode:            http.StatusGatewayTimeout,
+			ExpectedContentContains: "504 Gateway Timeout",
+		},
+		{
+			// upgraded connections are exempt from the deadline
+			URI:           "/slow/test",
+			Headers:       map[string]string{"Connection": "Upgrade", headerUpgrade: "websocket"},
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+		},
+		{
+			URI:           "/patient/test",
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+		},
+	})
+}
+
+func TestInvalidResourceResponseTimeout(t *testing.T) {
+	r := &Resource{URL: "/test", ResponseTimeout: -time.Second}
+	assert.Error(t, r.valid())
+}
+
+func TestRequestTimeout(t *testing.T) {
+	budgets := make(chan string, 10)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		budgets <- req.Header.Get(headerXRequestTimeout)
+		if req.URL.Query().Get("slow") != "" {
+			select {
+			case <-time.After(time.Second):
+			case <-req.Context().Done():
+				return
+			}
+		}
+		(&fakeUpstreamService{}).ServeHTTP(w, req)
+	}))
+	defer upstream.Close()
+
+	cfg := newFakeKeycloakConfig()
+	cfg.RequestTimeout = 200 * time.Millisecond
+	cfg.EnableRequestTimeoutHeader = true
+	cfg.Resources = []*Resource{
+		{
+			URL:         "/budget/*",
+			Methods:     allHTTPMethods,
+			WhiteListed: true,
+			Upstream:    upstream.URL,
+		},
+		{
+			URL:             "/short/*",
+			Methods:         allHTTPMethods,
+			WhiteListed:     true,
+			Upstream:        upstream.URL,
+			ResponseTimeout: 50 * time.Millisecond,
+		},
+		{
+			URL:         "/stream/*",
+			Methods:     allHTTPMethods,
+			WhiteListed: true,
+			Upstream:    upstream.URL,
+			Streaming:   true,
+		},
+	}
+	p := newFakeProxy(cfg)
+	p.proxy.upstream = p.proxy.newUpstreamProxy(http.DefaultTransport, false)
+
+	p.RunTests(t, []fakeRequest{
+		{URI: "/budget/test", ExpectedProxy: true, ExpectedCode: http.StatusOK},
+		{URI: "/short/test", ExpectedProxy: true, ExpectedCode: http.StatusOK},
+		{URI: "/stream/test", ExpectedProxy: true, ExpectedCode: http.StatusOK},
+		{URI: "/budget/test?slow=true", ExpectedCode: http.StatusGatewayTimeout},
+	})
+	budget, err := strconv.Atoi(<-budgets)
+	assert.NoError(t, err)
+	assert.True(t, budget > 0 && budget <= 200, "unexpected budget: %d", budget)
+
+	// the shortest of the request budget and the resource timeout is propagated
+	budget, err = strconv.Atoi(<-budgets)
+	assert.NoError(t, err)
+	assert.True(t, budget > 0 && budget <= 50, "unexpected budget: %d", budget)
+
+	// streaming resources are not limited by the request budget
+	assert.Empty(t, <-budgets)
+}
+
+func TestInformationalResponsesAndTrailers(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodPut {
+			content, _ := ioutil.ReadAll(req.Body)
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write(content)
+			return
+		}
+		w.Header().Set("Link", "</style.css>; rel=preload; as=style")
+		w.WriteHeader(http.StatusEarlyHints)
+		w.Header().Del("Link")
+		w.Header().Set("Trailer", "X-Checksum")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = io.WriteString(w, "created")
+		w.Header().Set("X-Checksum", "abcd")
+		w.Header().Set(http.TrailerPrefix+"X-Undeclared", "efgh")
+	}))
+	defer upstream.Close()
+
+	cfg := newFakeKeycloakConfig()
+	cfg.EnableLogging = true
+	cfg.Resources = []*Resource{
+		{
+			URL:          "/early/*",
+			Methods:      allHTTPMethods,
+			WhiteListed:  true,
+			Upstream:     upstream.URL,
+			DebugCapture: true,
+		},
+	}
+	p := newFakeProxy(cfg)
+	p.proxy.upstream = p.proxy.newUpstreamProxy(http.DefaultTransport, false)
+	defer func() {
+		p.idp.Close()
+		p.proxy.server.Close()
+	}()
+
+	var informational []int
+	var links []string
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			informational = append(informational, code)
+			links = append(links, header.Get("Link"))
+			return nil
+		},
+	}
+	req, err := http.NewRequest(http.MethodGet, p.getServiceURL()+"/early/test", nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, []int{http.StatusEarlyHints}, informational)
+	assert.Equal(t, []string{"</style.css>; rel=preload; as=style"}, links)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Link"))
+	assert.Equal(t, "created", string(body))
+	assert.Equal(t, "abcd", resp.Trailer.Get("X-Checksum"))
+	assert.Equal(t, "efgh", resp.Trailer.Get("X-Undeclared"))
+
+	// uploads waiting for a 100 Continue response are passed through
+	client := &http.Client{Transport: &http.Transport{ExpectContinueTimeout: 5 * time.Second}}
+	req, err = http.NewRequest(http.MethodPut, p.getServiceURL()+"/early/upload", strings.NewReader("uploaded"))
+	require.NoError(t, err)
+	req.Header.Set("Expect", "100-continue")
+	informational = nil
+	resp, err = client.Do(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err = ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, []int{http.StatusContinue}, informational)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "uploaded", string(body))
+}
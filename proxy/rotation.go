@@ -0,0 +1,285 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+// watchFilesDelay is the delay to coalesce the changes of the files watched, e.g. of a certificate and its key
+var watchFilesDelay = 200 * time.Millisecond
+
+type certificationRotation struct {
+	sync.RWMutex
+	// certificate holds the current issuing certificate, replaced on reload
+	certificate *tls.Certificate
+	// certificateFile is the path the certificate
+	certificateFile string
+	// the privateKeyFile is the path of the private key
+	privateKeyFile string
+	// the logger for this service
+	log *zap.Logger
+}
+
+// newCertificateRotator creates a new certificate
+func newCertificateRotator(cert, key string, log *zap.Logger) (*certificationRotation, error) {
+	// step: attempt to load the certificate
+	certificate, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		return nil, err
+	}
+	// @step: are we watching the files for changes?
+	return &certificationRotation{
+		certificate:     &certificate,
+		certificateFile: cert,
+		log:             log,
+		privateKeyFile:  key,
+	}, nil
+}
+
+// watch is responsible for adding a file notification and watch on the files for changes
+func (c *certificationRotation) watch() error {
+	c.log.Info("adding a file watch on the certificates, certificate",
+		zap.String("certificate", c.certificateFile),
+		zap.String("private_key", c.privateKeyFile))
+
+	return watchFiles([]string{c.certificateFile, c.privateKeyFile}, c.log, c.reloadChanged)
+}
+
+// reloadChanged reloads the certificate when its files have changed
+func (c *certificationRotation) reloadChanged() {
+	if certificate, err := tls.LoadX509KeyPair(c.certificateFile, c.privateKeyFile); err == nil {
+		c.RLock()
+		unchanged := len(c.certificate.Certificate) > 0 && bytes.Equal(c.certificate.Certificate[0], certificate.Certificate[0])
+		c.RUnlock()
+		if unchanged {
+			return
+		}
+	}
+	expires, err := c.reload()
+	if err != nil {
+		c.log.Error("unable to load the updated certificate",
+			zap.String("certificate", c.certificateFile),
+			zap.Error(err))
+		return
+	}
+	c.log.Info("replacing the server certificate with updated version",
+		zap.String("certificate", c.certificateFile),
+		zap.Time("expires", expires))
+}
+
+// watchFiles calls onChange when the files may have changed, the events being coalesced over watchFilesDelay.
+// As kubernetes updates the files of the mounted secrets by swapping a symlink to their directory, any event in
+// the directories of the files is considered.
+func watchFiles(files []string, log *zap.Logger, onChange func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	// add the directories of the files to the watch list
+	for _, x := range files {
+		if err := watcher.Add(path.Dir(x)); err != nil {
+			_ = watcher.Close()
+			return fmt.Errorf("unable to add watch on directory: %s, error: %s", path.Dir(x), err)
+		}
+	}
+
+	// step: watching for events
+	go func() {
+		log.Info("starting to watch changes to the files", zap.Strings("files", files))
+		var pending *time.Timer
+		for {
+			select {
+			case _, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if pending != nil {
+					pending.Stop()
+				}
+				pending = time.AfterFunc(watchFilesDelay, onChange)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Error("received an error from the file watcher", zap.Error(err))
+			}
+		}
+	}()
+
+	return nil
+}
+
+// reload loads the certificate from the files again and returns its expiry. The current
+// certificate is kept when the files are invalid or the new certificate has expired.
+func (c *certificationRotation) reload() (time.Time, error) {
+	certificate, err := tls.LoadX509KeyPair(c.certificateFile, c.privateKeyFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return time.Time{}, err
+	}
+	if time.Now().After(leaf.NotAfter) {
+		return leaf.NotAfter, fmt.Errorf("the certificate %s has expired on %s", c.certificateFile, leaf.NotAfter.Format(time.RFC3339))
+	}
+	// @metric inform of the rotation
+	certificateRotationMetric.Inc()
+
+	return leaf.NotAfter, c.storeCertificate(certificate)
+}
+
+// storeCertificate provides entrypoint to update the certificate
+func (c *certificationRotation) storeCertificate(certifacte tls.Certificate) error {
+	c.Lock()
+	defer c.Unlock()
+	c.certificate = &certifacte
+
+	return nil
+}
+
+// GetCertificate is responsible for retrieving
+func (c *certificationRotation) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.certificate, nil
+}
+
+// caRotation holds the pool of a certificate authority, loaded from its file again when it changes
+type caRotation struct {
+	sync.RWMutex
+	// pool holds the certificates of the authority
+	pool *x509.CertPool
+	// content is the content of the file loaded
+	content []byte
+	// who names the authority in the errors
+	who string
+	// file is the path of the authority certificates
+	file string
+	log  *zap.Logger
+}
+
+// newCARotator loads a certificate authority from a file
+func newCARotator(who, file string, log *zap.Logger) (*caRotation, error) {
+	c := &caRotation{who: who, file: file, log: log}
+	if _, err := c.reload(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// watch reloads the certificate authority when its file changes
+func (c *caRotation) watch() error {
+	return watchFiles([]string{c.file}, c.log, func() {
+		changed, err := c.reload()
+		if err != nil {
+			c.log.Error("unable to load the updated certificate authority", zap.String("path", c.file), zap.Error(err))
+			return
+		}
+		if changed {
+			c.log.Info("replacing the certificate authority with updated version", zap.String("path", c.file))
+		}
+	})
+}
+
+// reload loads the certificate authority from its file again, returning whether it has changed. The current
+// authority is kept when the file is invalid.
+func (c *caRotation) reload() (bool, error) {
+	content, err := ioutil.ReadFile(c.file)
+	if err != nil {
+		return false, fmt.Errorf("cannot read cert file for %s: %q: %v", c.who, c.file, err)
+	}
+	c.RLock()
+	unchanged := bytes.Equal(content, c.content)
+	c.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(content) {
+		return false, fmt.Errorf("invalid %s PEM certificate", c.who)
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if c.pool != nil {
+		// @metric inform of the rotation
+		certificateRotationMetric.Inc()
+	}
+	c.pool = pool
+	c.content = content
+
+	return true, nil
+}
+
+// certPool returns the current pool of the authority
+func (c *caRotation) certPool() *x509.CertPool {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.pool
+}
+
+// dialTLS establishes the tls connections verified by the current authority, as the authority of a tls
+// configuration is not reloaded by the transports
+func (c *caRotation) dialTLS(dial dialContextFunc, config *tls.Config, timeout time.Duration) dialContextFunc {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := dial(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		cfg := config.Clone()
+		cfg.RootCAs = c.certPool()
+		if cfg.ServerName == "" {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				host = address
+			}
+			cfg.ServerName = host
+		}
+
+		deadline, ok := ctx.Deadline()
+		if timeout > 0 && (!ok || time.Now().Add(timeout).Before(deadline)) {
+			deadline, ok = time.Now().Add(timeout), true
+		}
+		if ok {
+			_ = conn.SetDeadline(deadline)
+		}
+		tlsConn := tls.Client(conn, cfg)
+		if err := tlsConn.Handshake(); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		_ = conn.SetDeadline(time.Time{})
+
+		return tlsConn, nil
+	}
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+const (
+	testCertificateFile = "../tests/proxy.pem"
+	testPrivateKeyFile  = "../tests/proxy-key.pem"
+)
+
+func newTestCertificateRotator(t *testing.T) *certificationRotation {
+	c, err := newCertificateRotator(testCertificateFile, testPrivateKeyFile, zap.NewNop())
+	assert.NotNil(t, c)
+	assert.Equal(t, testCertificateFile, c.certificateFile)
+	assert.Equal(t, testPrivateKeyFile, c.privateKeyFile)
+	if !assert.NoError(t, err) {
+		t.Fatalf("unable to create the certificate rotator, error: %s", err)
+	}
+
+	return c
+}
+
+func TestNewCeritifacteRotator(t *testing.T) {
+	c, err := newCertificateRotator(testCertificateFile, testPrivateKeyFile, zap.NewNop())
+	assert.NotNil(t, c)
+	assert.NoError(t, err)
+}
+
+func TestNewCeritifacteRotatorFailure(t *testing.T) {
+	c, err := newCertificateRotator("../tests/does_not_exist", testPrivateKeyFile, zap.NewNop())
+	assert.Nil(t, c)
+	assert.Error(t, err)
+}
+
+func TestGetCertificate(t *testing.T) {
+	c := newTestCertificateRotator(t)
+	assert.NotEmpty(t, c.certificate)
+	crt, err := c.GetCertificate(nil)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, crt)
+}
+
+func TestLoadCertificate(t *testing.T) {
+	c := newTestCertificateRotator(t)
+	assert.NotEmpty(t, c.certificate)
+	_ = c.storeCertificate(tls.Certificate{})
+	crt, err := c.GetCertificate(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, &tls.Certificate{}, crt)
+}
+
+func TestWatchCertificate(t *testing.T) {
+	c := newTestCertificateRotator(t)
+	err := c.watch()
+	assert.NoError(t, err)
+}
+
+func writeTestCertificate(t *testing.T, certFile, keyFile string, expire time.Duration) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	cert, err := createCertificate(key, []string{"localhost"}, expire)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600))
+	require.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600))
+}
+
+func TestReloadCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotation")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	writeTestCertificate(t, certFile, keyFile, time.Hour)
+	c, err := newCertificateRotator(certFile, keyFile, zap.NewNop())
+	require.NoError(t, err)
+	crt, _ := c.GetCertificate(nil)
+	previous := crt.Certificate[0]
+
+	writeTestCertificate(t, certFile, keyFile, 2*time.Hour)
+	expires, err := c.reload()
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(2*time.Hour), expires, time.Minute)
+	crt, _ = c.GetCertificate(nil)
+	reloaded := crt.Certificate[0]
+	assert.NotEqual(t, previous, reloaded)
+
+	// an expired certificate is not loaded
+	for src, dst := range map[string]string{testCertificateFile: certFile, testPrivateKeyFile: keyFile} {
+		content, err := ioutil.ReadFile(src)
+		require.NoError(t, err)
+		require.NoError(t, ioutil.WriteFile(dst, content, 0600))
+	}
+	_, err = c.reload()
+	assert.Error(t, err)
+	current, _ := c.GetCertificate(nil)
+	assert.Equal(t, reloaded, current.Certificate[0])
+}
+
+// swapTestSecret writes a certificate and its key as kubernetes does for the mounted secrets, swapping the
+// ..data symlink to a new directory of files
+func swapTestSecret(t *testing.T, dir, version string, write func(dir string)) {
+	versioned := filepath.Join(dir, version)
+	require.NoError(t, os.Mkdir(versioned, 0700))
+	write(versioned)
+	tmp := filepath.Join(dir, "..data_tmp")
+	require.NoError(t, os.Symlink(version, tmp))
+	require.NoError(t, os.Rename(tmp, filepath.Join(dir, "..data")))
+}
+
+func TestWatchCertificateSecretSwap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotation")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeSecret := func(versioned string) {
+		writeTestCertificate(t, filepath.Join(versioned, "tls.crt"), filepath.Join(versioned, "tls.key"), time.Hour)
+	}
+	swapTestSecret(t, dir, "..2020_01_01", writeSecret)
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	require.NoError(t, os.Symlink("..data/tls.crt", certFile))
+	require.NoError(t, os.Symlink("..data/tls.key", keyFile))
+
+	c, err := newCertificateRotator(certFile, keyFile, zap.NewNop())
+	require.NoError(t, err)
+	require.NoError(t, c.watch())
+	crt, _ := c.GetCertificate(nil)
+	previous := crt.Certificate[0]
+
+	swapTestSecret(t, dir, "..2020_01_31", writeSecret)
+	assert.Eventually(t, func() bool {
+		crt, _ := c.GetCertificate(nil)
+		return !assert.ObjectsAreEqual(previous, crt.Certificate[0])
+	}, 5*time.Second, 50*time.Millisecond, "the certificate must be reloaded when the secret is updated")
+}
+
+func TestUpstreamCAReload(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer upstream.Close()
+	dir, err := ioutil.TempDir("", "rotation")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+
+	// the upstream is first verified by another authority
+	other, err := ioutil.ReadFile(testCertificateFile)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(caFile, other, 0600))
+	ca, err := newCARotator("upstream CA", caFile, zap.NewNop())
+	require.NoError(t, err)
+	require.NoError(t, ca.watch())
+
+	dialer := &net.Dialer{}
+	client := &http.Client{Transport: &http.Transport{
+		DialTLSContext: ca.dialTLS(dialer.DialContext, &tls.Config{}, time.Second),
+	}}
+	get := func() error {
+		resp, err := client.Get(upstream.URL)
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		return err
+	}
+	assert.Error(t, get())
+
+	require.NoError(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: upstream.Certificate().Raw}), 0600))
+	assert.Eventually(t, func() bool {
+		return get() == nil
+	}, 5*time.Second, 50*time.Millisecond, "the upstream must be verified by the updated authority")
+
+	// an invalid authority is not loaded
+	require.NoError(t, ioutil.WriteFile(caFile, []byte("invalid"), 0600))
+	_, err = ca.reload()
+	assert.Error(t, err)
+	assert.NoError(t, get())
+}
@@ -0,0 +1,297 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/vm"
+	"go.uber.org/zap"
+)
+
+// maxScriptSteps bounds the number of nodes of a script, the allocations of its evaluation and the number of
+// functions it calls on a request
+const maxScriptSteps = 10000
+
+var (
+	errScriptTimeout = errors.New("the script exceeded its time limit")
+	errScriptSteps   = errors.New("the script exceeded its evaluation budget")
+	// errScriptDenied stops the evaluation of a script once it has denied the request
+	errScriptDenied = errors.New("the script denied the request")
+)
+
+// scriptHeaderName are the names of the headers a script may set or remove
+var scriptHeaderName = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+
+// scriptBuiltins are the builtin functions of expr available to the scripts: those iterating over collections,
+// reading the clock or decoding data are left out
+var scriptBuiltins = []string{
+	"len", "lower", "upper", "trim", "trimPrefix", "trimSuffix", "hasPrefix", "hasSuffix", "indexOf", "split", "join",
+	"string", "int", "float",
+}
+
+// script is a compiled resource script.
+//
+// A script is an expr (https://expr-lang.org) program, whose expressions are separated by ';' and which acts on
+// the request by calling set(header, value), del(header) and deny(code, message), e.g.
+//
+//	set("X-Tenant", claims.tenant);
+//	"admin" in roles ? set("X-Admin", true) : false;
+//	del("X-Debug");
+//	claims.email_verified != true && deny(403, "email not verified")
+//
+// The variables are claims, subject, email, roles, groups, method, path, host, query and client_ip, and
+// header(name) reads a header of the request. The programs may neither declare variables nor iterate, and their
+// evaluation is bounded in steps and time.
+type script struct {
+	program *vm.Program
+}
+
+// scriptEnv is the evaluation environment of a script on a request
+type scriptEnv struct {
+	Claims   map[string]interface{} `expr:"claims"`
+	Subject  string                 `expr:"subject"`
+	Email    string                 `expr:"email"`
+	Roles    []string               `expr:"roles"`
+	Groups   []string               `expr:"groups"`
+	Method   string                 `expr:"method"`
+	Path     string                 `expr:"path"`
+	Host     string                 `expr:"host"`
+	Query    map[string]string      `expr:"query"`
+	ClientIP string                 `expr:"client_ip"`
+
+	Header func(string) (string, error)            `expr:"header"`
+	Set    func(string, interface{}) (bool, error) `expr:"set"`
+	Del    func(string) (bool, error)              `expr:"del"`
+	Deny   func(int, ...string) (bool, error)      `expr:"deny"`
+}
+
+// scriptRun is the state of the evaluation of a script on a request
+type scriptRun struct {
+	steps    int
+	deadline time.Time
+	code     int
+	message  string
+}
+
+// step accounts for a call of the script, enforcing its limits
+func (s *scriptRun) step() error {
+	s.steps++
+	if s.steps > maxScriptSteps {
+		return errScriptSteps
+	}
+	if !s.deadline.IsZero() && time.Now().After(s.deadline) {
+		return errScriptTimeout
+	}
+
+	return nil
+}
+
+// newScriptEnv provides the environment of a script on the request of a user
+func newScriptEnv(req *http.Request, user *userContext, s *scriptRun) *scriptEnv {
+	env := &scriptEnv{
+		Method:   req.Method,
+		Path:     req.URL.Path,
+		Host:     req.Host,
+		Query:    make(map[string]string),
+		ClientIP: realIP(req),
+	}
+	if user != nil {
+		env.Claims = user.claims
+		env.Subject = user.id
+		env.Email = user.email
+		env.Roles = user.roles
+		env.Groups = user.groups
+	}
+	for k, v := range req.URL.Query() {
+		env.Query[k] = v[0]
+	}
+	env.Header = func(name string) (string, error) {
+		if err := s.step(); err != nil {
+			return "", err
+		}
+		return req.Header.Get(name), nil
+	}
+	env.Set = func(name string, value interface{}) (bool, error) {
+		if err := s.step(); err != nil {
+			return false, err
+		}
+		if !scriptHeaderName.MatchString(name) {
+			return false, fmt.Errorf("invalid header name %q", name)
+		}
+		req.Header.Set(name, scriptString(value))
+		return true, nil
+	}
+	env.Del = func(name string) (bool, error) {
+		if err := s.step(); err != nil {
+			return false, err
+		}
+		req.Header.Del(name)
+		return true, nil
+	}
+	env.Deny = func(code int, message ...string) (bool, error) {
+		if err := s.step(); err != nil {
+			return false, err
+		}
+		if code < 400 || code > 599 {
+			return false, fmt.Errorf("invalid status code %d", code)
+		}
+		s.code = code
+		s.message = strings.Join(message, " ")
+		return false, errScriptDenied
+	}
+
+	return env
+}
+
+// run evaluates the script on a request, returning a status code when the request is denied
+func (s *script) run(req *http.Request, user *userContext, timeout time.Duration) (int, string, error) {
+	state := &scriptRun{}
+	if timeout > 0 {
+		state.deadline = time.Now().Add(timeout)
+	}
+
+	machine := &vm.VM{MemoryBudget: maxScriptSteps}
+	if _, err := machine.Run(s.program, newScriptEnv(req, user, state)); err != nil {
+		switch {
+		case errors.Is(err, errScriptDenied):
+			return state.code, state.message, nil
+		case errors.Is(err, errScriptTimeout):
+			return 0, "", errScriptTimeout
+		case errors.Is(err, errScriptSteps), strings.Contains(err.Error(), "memory budget exceeded"):
+			return 0, "", errScriptSteps
+		default:
+			return 0, "", err
+		}
+	}
+	if !state.deadline.IsZero() && time.Now().After(state.deadline) {
+		return 0, "", errScriptTimeout
+	}
+
+	return 0, "", nil
+}
+
+// scriptMiddleware runs the script of a resource, if any
+func (r *oauthProxy) scriptMiddleware(s *script) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if s == nil {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			scope := req.Context().Value(contextScopeName).(*RequestScope)
+			// the requests already denied or redirected have been answered
+			if scope.AccessDenied {
+				next.ServeHTTP(w, req)
+				return
+			}
+			code, msg, err := s.run(req, scope.Identity, r.config.ScriptTimeout)
+			switch {
+			case err != nil:
+				r.errorResponse(w, req, "the resource script failed", http.StatusInternalServerError, err)
+				scope.AccessDenied = true
+			case code == http.StatusForbidden:
+				r.log.Debug("request denied by the resource script", zap.String("path", req.URL.Path))
+				r.accessForbidden(w, req, msg)
+			case code != 0:
+				r.errorResponse(w, req, msg, code, nil)
+				scope.AccessDenied = true
+			default:
+				next.ServeHTTP(w, req)
+			}
+		})
+	}
+}
+
+// compileScript compiles a resource script, an empty script yields nil
+func compileScript(source string) (*script, error) {
+	if strings.TrimSpace(source) == "" {
+		return nil, nil
+	}
+	options := []expr.Option{expr.Env(scriptEnv{}), expr.DisableAllBuiltins(), expr.MaxNodes(maxScriptSteps)}
+	for _, name := range scriptBuiltins {
+		options = append(options, expr.EnableBuiltin(name))
+	}
+	program, err := expr.Compile(source, options...)
+	if err != nil {
+		return nil, err
+	}
+	// the variables would let the values grow beyond the size of the program
+	checker := &scriptChecker{}
+	node := program.Node()
+	ast.Walk(&node, checker)
+	if checker.err != nil {
+		return nil, checker.err
+	}
+
+	return &script{program: program}, nil
+}
+
+// scriptChecker rejects the constructs the scripts may not use
+type scriptChecker struct {
+	err error
+}
+
+// Visit implements ast.Visitor
+func (c *scriptChecker) Visit(node *ast.Node) {
+	if c.err != nil {
+		return
+	}
+	switch x := (*node).(type) {
+	case *ast.VariableDeclaratorNode:
+		c.err = fmt.Errorf("the variable %q may not be declared", x.Name)
+	case *ast.BuiltinNode:
+		if !containedIn(x.Name, scriptBuiltins, false) {
+			c.err = fmt.Errorf("the function %s may not be called", x.Name)
+		}
+	case *ast.PredicateNode:
+		c.err = errors.New("the scripts may not iterate")
+	}
+}
+
+// scriptString converts a value to a header value
+func scriptString(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case bool:
+		return strconv.FormatBool(x)
+	case int:
+		return strconv.Itoa(x)
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case []string:
+		return strings.Join(x, ",")
+	case []interface{}:
+		values := make([]string, 0, len(x))
+		for _, item := range x {
+			values = append(values, scriptString(item))
+		}
+		return strings.Join(values, ",")
+	default:
+		return fmt.Sprintf("%v", x)
+	}
+}
@@ -0,0 +1,245 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompileScript(t *testing.T) {
+	cs := []struct {
+		Script string
+		Ok     bool
+	}{
+		{Script: "", Ok: true},
+		{Script: "// the tenant\nset(\"X-Tenant\", claims.tenant)\n", Ok: true},
+		{Script: `set("X-Tenant", claims.tenant)`, Ok: true},
+		{Script: `"admin" in roles && !claims.disabled ? set("X-Name", lower(claims["given_name"]) + "." + upper(email)) : false`, Ok: true},
+		{Script: "del(\"X-Debug\");\n(method == \"DELETE\" || path matches \"^/admin/\") && deny(403, \"no\")", Ok: true},
+		{Script: `if header("X-Client") == "bot" { deny(429) } else { true }`, Ok: true},
+		{Script: `deny(`},
+		{Script: `deny("403")`},
+		{Script: `set("X-Tenant")`},
+		{Script: `set("X-Tenant", unknown)`},
+		{Script: `set("X-Tenant", missing(email))`},
+		{Script: `set("X-Tenant", lower(email, path))`},
+		{Script: `set("X-Tenant", path matches "(")`},
+		{Script: `set("X-Tenant", "unterminated)`},
+		{Script: `let tenant = claims.tenant; set("X-Tenant", tenant)`},
+		{Script: `all(roles, # != "admin") && deny(403)`},
+		{Script: `set("X-Now", now())`},
+		{Script: `set("X-Long", path` + strings.Repeat(` + path`, maxScriptSteps) + `)`},
+	}
+	for i, c := range cs {
+		_, err := compileScript(c.Script)
+		assert.Equal(t, c.Ok, err == nil, "case %d, script: %q, error: %v", i, c.Script, err)
+	}
+}
+
+func TestRunScript(t *testing.T) {
+	user := &userContext{
+		id:     "1e11e539",
+		email:  "gambol99@gmail.com",
+		roles:  []string{"admin", "test"},
+		groups: []string{"devops"},
+		claims: map[string]interface{}{
+			"tenant":         "acme",
+			"email_verified": false,
+			"level":          float64(3),
+			"scopes":         []interface{}{"read", "write"},
+			"address":        map[string]interface{}{"country": "fr"},
+		},
+	}
+	cs := []struct {
+		Script          string
+		User            *userContext
+		Headers         map[string]string
+		ExpectedCode    int
+		ExpectedMessage string
+		ExpectedHeaders map[string]string
+	}{
+		{
+			Script:          `set("X-Tenant", claims.tenant)`,
+			User:            user,
+			ExpectedHeaders: map[string]string{"X-Tenant": "acme"},
+		},
+		{
+			Script:          `set("X-Country", upper(claims.address.country) + "/" + claims.scopes[1])`,
+			User:            user,
+			ExpectedHeaders: map[string]string{"X-Country": "FR/write"},
+		},
+		{
+			Script:          "\"admin\" in roles && set(\"X-Admin\", true);\nset(\"X-Ops\", \"ops\" in groups)",
+			User:            user,
+			ExpectedHeaders: map[string]string{"X-Admin": "true", "X-Ops": "false"},
+		},
+		{
+			Script:          `claims.level == 3 ? set("X-Level", claims.level) : false`,
+			User:            user,
+			ExpectedHeaders: map[string]string{"X-Level": "3"},
+		},
+		{
+			Script:          `set("X-Scopes", claims.scopes); set("X-Count", len(roles))`,
+			User:            user,
+			ExpectedHeaders: map[string]string{"X-Scopes": "read,write", "X-Count": "2"},
+		},
+		{
+			Script:          `del("X-Debug")`,
+			User:            user,
+			Headers:         map[string]string{"X-Debug": "true"},
+			ExpectedHeaders: map[string]string{"X-Debug": ""},
+		},
+		{
+			Script:          `claims.email_verified != true && deny(403, "email not verified")`,
+			User:            user,
+			ExpectedCode:    http.StatusForbidden,
+			ExpectedMessage: "email not verified",
+		},
+		{
+			Script:       `if header("X-Client") == "bot" { deny(429) } else { true }`,
+			User:         user,
+			Headers:      map[string]string{"X-Client": "bot"},
+			ExpectedCode: http.StatusTooManyRequests,
+		},
+		{
+			Script:          `subject == "" && deny(401); hasPrefix(path, "/api") && hasSuffix(path, "s") && set("X-Path", path)`,
+			User:            user,
+			ExpectedHeaders: map[string]string{"X-Path": "/api/items"},
+		},
+		{
+			Script:          `subject == "" && deny(401); set("X-Tenant", claims.tenant)`,
+			ExpectedCode:    http.StatusUnauthorized,
+			ExpectedHeaders: map[string]string{"X-Tenant": ""},
+		},
+	}
+	for i, c := range cs {
+		s, err := compileScript(c.Script)
+		require.NoError(t, err, "case %d", i)
+		req := httptest.NewRequest(http.MethodGet, "/api/items", nil)
+		for k, v := range c.Headers {
+			req.Header.Set(k, v)
+		}
+		code, msg, err := s.run(req, c.User, time.Second)
+		require.NoError(t, err, "case %d", i)
+		assert.Equal(t, c.ExpectedCode, code, "case %d", i)
+		assert.Equal(t, c.ExpectedMessage, msg, "case %d", i)
+		for k, v := range c.ExpectedHeaders {
+			assert.Equal(t, v, req.Header.Get(k), "case %d, header %s", i, k)
+		}
+	}
+}
+
+func TestRunScriptErrors(t *testing.T) {
+	cs := []string{
+		`deny(200)`,
+		`set("X Tenant", path)`,
+		`set("X-Level", claims.level + 1)`,
+	}
+	for i, c := range cs {
+		s, err := compileScript(c)
+		require.NoError(t, err, "case %d", i)
+		_, _, err = s.run(httptest.NewRequest(http.MethodGet, "/", nil), nil, time.Second)
+		assert.Error(t, err, "case %d", i)
+	}
+}
+
+func TestRunScriptLimits(t *testing.T) {
+	// a large range exhausts the evaluation budget
+	s, err := compileScript(fmt.Sprintf(`set("X-Count", len(1..%d))`, maxScriptSteps*2))
+	require.NoError(t, err)
+	_, _, err = s.run(httptest.NewRequest(http.MethodGet, "/", nil), nil, 0)
+	assert.Equal(t, errScriptSteps, err)
+
+	s, err = compileScript(`set("X-Path", path)`)
+	require.NoError(t, err)
+	_, _, err = s.run(httptest.NewRequest(http.MethodGet, "/", nil), nil, time.Nanosecond)
+	assert.Equal(t, errScriptTimeout, err)
+}
+
+func TestScriptMiddleware(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.Resources = []*Resource{
+		{
+			URL:     "/scripted/*",
+			Methods: allHTTPMethods,
+			Script: `set("X-Tenant", claims.tenant);
+claims.tenant == "evil" && deny(403)`,
+		},
+		{
+			URL:         "/public/*",
+			Methods:     allHTTPMethods,
+			WhiteListed: true,
+			Script:      `header("X-Hidden") != "" && deny(404)`,
+		},
+	}
+	requests := []fakeRequest{
+		{
+			URI:                  "/scripted/test",
+			HasToken:             true,
+			TokenClaims:          map[string]interface{}{"tenant": "acme"},
+			ExpectedProxy:        true,
+			ExpectedCode:         http.StatusOK,
+			ExpectedProxyHeaders: map[string]string{"X-Tenant": "acme"},
+		},
+		{
+			URI:          "/scripted/test",
+			HasToken:     true,
+			TokenClaims:  map[string]interface{}{"tenant": "evil"},
+			ExpectedCode: http.StatusForbidden,
+		},
+		{
+			URI:           "/public/test",
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+		},
+		{
+			URI:          "/public/test",
+			Headers:      map[string]string{"X-Hidden": "true"},
+			ExpectedCode: http.StatusNotFound,
+		},
+	}
+	newFakeProxy(cfg).RunTests(t, requests)
+}
+
+func TestScriptSkipsDeniedRequests(t *testing.T) {
+	s, err := compileScript(`header("X-Hidden") != "" && deny(404)`)
+	require.NoError(t, err)
+	var proxied bool
+	handler := (&oauthProxy{config: newFakeKeycloakConfig()}).scriptMiddleware(s)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		proxied = true
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("X-Hidden", "true")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req.WithContext(context.WithValue(req.Context(), contextScopeName, &RequestScope{AccessDenied: true})))
+	assert.True(t, proxied)
+	assert.Equal(t, http.StatusOK, resp.Code, "the script does not answer a denied request")
+}
+
+func TestInvalidResourceScript(t *testing.T) {
+	r := &Resource{URL: "/test", Script: "deny(403"}
+	assert.Error(t, r.valid())
+}
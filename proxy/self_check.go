@@ -0,0 +1,261 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/go-chi/chi"
+	"github.com/oneconcern/keycloak-gatekeeper/version"
+)
+
+// selfCheckReport summarizes the effective configuration of the service on startup
+type selfCheckReport struct {
+	Version     string              `json:"version"`
+	Mode        string              `json:"mode"`
+	Listeners   []selfCheckListener `json:"listeners"`
+	Provider    selfCheckProvider   `json:"provider"`
+	Upstream    string              `json:"upstream,omitempty"`
+	Resources   int                 `json:"resources"`
+	Routes      int                 `json:"routes"`
+	Middlewares map[string]bool     `json:"middlewares"`
+	Warnings    []string            `json:"warnings"`
+}
+
+// selfCheckListener is a listener of the service
+type selfCheckListener struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	TLS     bool   `json:"tls"`
+}
+
+// selfCheckProvider is the openid provider of the service
+type selfCheckProvider struct {
+	DiscoveryURL string `json:"discovery_url,omitempty"`
+	Issuer       string `json:"issuer,omitempty"`
+	Discovered   bool   `json:"discovered"`
+}
+
+// selfCheck reports the effective configuration of the service, with the misconfigurations detected
+func (r *oauthProxy) selfCheck() *selfCheckReport {
+	report := &selfCheckReport{
+		Version:   version.GetVersion(),
+		Mode:      "reverse-proxy",
+		Upstream:  r.config.Upstream,
+		Resources: len(r.config.Resources),
+		Middlewares: map[string]bool{
+			"allowed_hosts":       len(r.config.AllowedHosts) > 0,
+			"client_cert_headers": r.config.EnableClientCertHeaders,
+			"cors":                len(r.config.CorsOrigins) > 0,
+			"csrf":                r.config.EnableCSRF,
+			"default_deny":        r.config.EnableDefaultDeny,
+			"fault_injection":     r.config.EnableFaultInjection,
+			"https_redirection":   r.config.EnableHTTPSRedirect,
+			"identity_headers":    r.config.EnableClaimsHeaders || r.config.EnableTokenHeader,
+			"login_handler":       r.config.EnableLoginHandler,
+			"metrics":             r.config.EnableMetrics,
+			"proxy_protocol":      r.config.EnableProxyProtocol,
+			"refresh_tokens":      r.config.EnableRefreshTokens,
+			"request_id":          r.config.EnableRequestID,
+			"request_logging":     r.config.EnableLogging,
+			"security_filter":     r.config.EnableSecurityFilter,
+			"token_verification":  !r.config.SkipTokenVerification,
+			"tracing":             r.config.EnableTracing,
+		},
+		Warnings: selfCheckWarnings(r.config),
+	}
+	if r.config.EnableForwarding {
+		report.Mode = "forwarding"
+		report.Upstream = ""
+	}
+
+	report.Provider.DiscoveryURL = r.config.DiscoveryURL
+	if r.getProviderClient() != nil && atomic.LoadInt32(&r.providerPending) == 0 {
+		report.Provider.Discovered = true
+		if issuer := r.getProviderConfig().Issuer; issuer != nil {
+			report.Provider.Issuer = issuer.String()
+		}
+	}
+
+	router := r.router
+	if realms, ok := router.(*realmRouter); ok {
+		router = realms.main
+	}
+	if routes, ok := router.(chi.Routes); ok {
+		_ = chi.Walk(routes, func(string, string, http.Handler, ...func(http.Handler) http.Handler) error {
+			report.Routes++
+			return nil
+		})
+	}
+
+	return report
+}
+
+// selfCheckListeners returns the listeners of the service, once bound
+func (r *oauthProxy) selfCheckListeners() []selfCheckListener {
+	var listeners []selfCheckListener
+	if r.listener != nil {
+		listeners = append(listeners, selfCheckListener{
+			Name:    "main",
+			Address: r.listener.Addr().String(),
+			TLS:     r.config.isTLSEnabled(),
+		})
+	}
+	if r.config.ListenHTTP != "" {
+		listeners = append(listeners, selfCheckListener{Name: "http", Address: r.config.ListenHTTP})
+	}
+	if r.config.ListenAdmin != "" {
+		listeners = append(listeners, selfCheckListener{
+			Name:    "admin",
+			Address: r.config.ListenAdmin,
+			TLS:     r.config.ListenAdminScheme == secureScheme && r.config.isTLSEnabled(),
+		})
+	}
+
+	return listeners
+}
+
+// selfCheckWarnings returns the misconfigurations of a configuration, which do not prevent the service from
+// starting but are likely mistakes
+func selfCheckWarnings(config *Config) []string {
+	warnings := make([]string, 0)
+	warn := func(format string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+
+	redirection, _ := url.Parse(config.RedirectionURL)
+	plainHTTP := redirection != nil && redirection.Scheme == unsecureScheme
+	overHTTPS := config.isTLSEnabled() || (redirection != nil && redirection.Scheme == secureScheme)
+	if config.SecureCookie && plainHTTP && !config.isTLSEnabled() {
+		warn("the cookies are secure but the service is served over plain http (secure-cookie, redirection-url): browsers will not send them back")
+	}
+	if !config.SecureCookie && overHTTPS {
+		warn("the cookies are not secure while the service is served over https (secure-cookie)")
+	}
+	if config.SkipTokenVerification {
+		warn("the access tokens are not verified (skip-token-verification)")
+	}
+	if config.SkipOpenIDProviderTLSVerify {
+		warn("the tls certificate of the openid provider is not verified (skip-openid-provider-tls-verify)")
+	}
+	if !config.EnableForwarding && config.SkipUpstreamTLSVerify && config.UpstreamCA == "" &&
+		strings.HasPrefix(config.Upstream, secureScheme+"://") {
+		warn("the tls certificate of the upstream is not verified (skip-upstream-tls-verify)")
+	}
+	if !config.EnableForwarding && !config.EnableDefaultDeny {
+		warn("the routes which are not declared as resources are proxied without authentication (enable-default-deny)")
+	}
+	if config.EnableAdminAPI && config.AdminAPIToken == "" {
+		warn("the admin api is not protected by a token (admin-api-token)")
+	}
+	if config.EnableProfiling && config.ProfilingToken == "" {
+		warn("the profiling endpoints are not protected by a token (profiling-token)")
+	}
+	if config.CorsCredentials && containedIn("*", config.CorsOrigins, false) {
+		warn("the credentials are allowed to any cors origin (cors-origins, cors-credentials)")
+	}
+	if isWeakKey(config.EncryptionKey) {
+		warn("the encryption key is not random enough (encryption-key): generate it from a secure random source")
+	}
+	if isWeakKey(config.UpstreamTokenCookieKey) {
+		warn("the key of the upstream token cookie is not random enough (upstream-token-cookie-key): generate it from a secure random source")
+	}
+	if config.EnableFaultInjection {
+		warn("the fault injection is enabled (enable-fault-injection)")
+	}
+	for _, x := range config.Resources {
+		if x.DebugCapture {
+			warn("the requests to %s are captured with their bodies (debug-capture)", x.URL)
+		}
+	}
+
+	return warnings
+}
+
+// isWeakKey checks if a key shows too few distinct characters to have been generated randomly, e.g. a repeated
+// pattern, or a sequential one. The random keys show about as many distinct characters as expected from as many draws
+// in the alphabet of the key, e.g. hexadecimal: the keys showing less than half of them are not random.
+func isWeakKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	distinct := make(map[rune]bool)
+	ascending, descending := 0, 0
+	previous := rune(-1)
+	for _, c := range key {
+		distinct[c] = true
+		switch c - previous {
+		case 1:
+			ascending++
+		case -1:
+			descending++
+		}
+		previous = c
+	}
+	length := len([]rune(key))
+	alphabet := float64(keyAlphabet(key))
+	expected := alphabet * (1 - math.Pow(1-1/alphabet, float64(length)))
+
+	return float64(len(distinct))*2 < expected || ascending*2 >= length || descending*2 >= length
+}
+
+// keyAlphabet returns the size of the alphabet a key is drawn from: the digits, hexadecimal, or the classes of its
+// characters
+func keyAlphabet(key string) int {
+	var digits, hexLowers, hexUppers, lowers, uppers, others bool
+	for _, c := range key {
+		switch {
+		case c >= '0' && c <= '9':
+			digits = true
+		case c >= 'a' && c <= 'f':
+			hexLowers = true
+		case c >= 'A' && c <= 'F':
+			hexUppers = true
+		case c >= 'a' && c <= 'z':
+			lowers = true
+		case c >= 'A' && c <= 'Z':
+			uppers = true
+		default:
+			others = true
+		}
+	}
+	switch {
+	case !lowers && !uppers && !others && (hexLowers || hexUppers):
+		return 16
+	case !lowers && !uppers && !others:
+		return 10
+	}
+	size := 0
+	if digits {
+		size += 10
+	}
+	if lowers || hexLowers {
+		size += 26
+	}
+	if uppers || hexUppers {
+		size += 26
+	}
+	if others {
+		size += 32
+	}
+
+	return size
+}
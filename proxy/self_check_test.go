@@ -0,0 +1,239 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestSelfCheckWarnings(t *testing.T) {
+	secure := func() *Config {
+		c := newFakeKeycloakConfig()
+		c.RedirectionURL = "https://app.example.com"
+		c.SecureCookie = true
+		c.EnableDefaultDeny = true
+		return c
+	}
+	tests := []struct {
+		Name    string
+		Modify  func(*Config)
+		Warning string
+	}{
+		{
+			Name:   "secure",
+			Modify: func(*Config) {},
+		},
+		{
+			Name: "secure cookies over plain http",
+			Modify: func(c *Config) {
+				c.RedirectionURL = "http://app.example.com"
+			},
+			Warning: "the cookies are secure but the service is served over plain http",
+		},
+		{
+			Name: "insecure cookies over https",
+			Modify: func(c *Config) {
+				c.SecureCookie = false
+			},
+			Warning: "the cookies are not secure",
+		},
+		{
+			Name: "upstream not verified",
+			Modify: func(c *Config) {
+				c.Upstream = "https://backend.example.com"
+				c.SkipUpstreamTLSVerify = true
+			},
+			Warning: "skip-upstream-tls-verify",
+		},
+		{
+			Name: "upstream verified with a ca",
+			Modify: func(c *Config) {
+				c.Upstream = "https://backend.example.com"
+				c.SkipUpstreamTLSVerify = true
+				c.UpstreamCA = "ca.pem"
+			},
+		},
+		{
+			Name: "no default deny",
+			Modify: func(c *Config) {
+				c.EnableDefaultDeny = false
+			},
+			Warning: "enable-default-deny",
+		},
+		{
+			Name: "admin api without token",
+			Modify: func(c *Config) {
+				c.EnableAdminAPI = true
+			},
+			Warning: "admin-api-token",
+		},
+		{
+			Name: "cors credentials to any origin",
+			Modify: func(c *Config) {
+				c.CorsOrigins = []string{"*"}
+				c.CorsCredentials = true
+			},
+			Warning: "cors-credentials",
+		},
+		{
+			Name: "weak encryption key",
+			Modify: func(c *Config) {
+				c.EncryptionKey = "abcdefghijklmnopqrstuvwxyz012345"
+			},
+			Warning: "encryption-key",
+		},
+		{
+			Name: "debug capture",
+			Modify: func(c *Config) {
+				c.Resources = append(c.Resources, &Resource{URL: "/captured", DebugCapture: true})
+			},
+			Warning: "the requests to /captured are captured",
+		},
+	}
+	for _, c := range tests {
+		config := secure()
+		c.Modify(config)
+		warnings := selfCheckWarnings(config)
+		if c.Warning == "" {
+			assert.Empty(t, warnings, c.Name)
+			continue
+		}
+		if assert.Len(t, warnings, 1, c.Name) {
+			assert.Contains(t, warnings[0], c.Warning, c.Name)
+		}
+	}
+}
+
+func TestIsWeakKey(t *testing.T) {
+	assert.False(t, isWeakKey(""))
+	assert.False(t, isWeakKey(testKey))
+	assert.False(t, isWeakKey("Xz3kP9qL2mW7vB1n"))
+	assert.True(t, isWeakKey("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"))
+	assert.True(t, isWeakKey("abababababababab"))
+	assert.True(t, isWeakKey("0123456789abcdef"))
+	assert.True(t, isWeakKey("ZYXWVUTSRQPONMLK"))
+	assert.True(t, isWeakKey("0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a0a"))
+
+	// the keys generated randomly, e.g. by openssl rand -hex 16 or -base64 24, are not weak
+	for i := 0; i < 10000; i++ {
+		random := make([]byte, 24)
+		_, err := rand.Read(random)
+		require.NoError(t, err)
+		hexKey, base64Key := hex.EncodeToString(random[:16]), base64.StdEncoding.EncodeToString(random)
+		require.False(t, isWeakKey(hexKey), hexKey)
+		require.False(t, isWeakKey(base64Key), base64Key)
+	}
+}
+
+func TestHardenPreset(t *testing.T) {
+	c := newFakeKeycloakConfig()
+	c.Harden = true
+	c.SecureCookie = false
+	c.HTTPOnlyCookie = false
+	c.SameSiteCookie = SameSiteNone
+	c.EnableDefaultDeny = false
+	c.applyHardening()
+	assert.True(t, c.Strict)
+	assert.True(t, c.SecureCookie)
+	assert.True(t, c.HTTPOnlyCookie)
+	assert.Equal(t, SameSiteLax, c.SameSiteCookie)
+	assert.True(t, c.EnableDefaultDeny)
+	assert.True(t, c.EnableSecurityFilter)
+	assert.True(t, c.EnableContentNoSniff && c.EnableFrameDeny && c.EnableBrowserXSSFilter)
+	assert.False(t, c.EnableSTS, "the transport security is only announced over tls")
+
+	// the insecure combinations left refuse the start of the service
+	c = newFakeKeycloakConfig()
+	c.Harden = true
+	c.SkipTokenVerification = true
+	auth := newFakeAuthServer()
+	defer auth.Close()
+	c.DiscoveryURL = auth.getLocation()
+	c.applyHardening()
+	proxy, err := newProxy(c)
+	require.NoError(t, err)
+	err = proxy.Run()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "skip-token-verification")
+	assert.Nil(t, proxy.listener, "the service must not listen")
+}
+
+func TestSelfCheckReport(t *testing.T) {
+	c := newFakeKeycloakConfig()
+	c.EnableDefaultDeny = true
+	c.EnableMetrics = true
+	p := newFakeProxy(c)
+	defer func() {
+		p.idp.Close()
+		p.proxy.server.Close()
+	}()
+
+	report := p.proxy.selfCheck()
+	assert.Equal(t, "reverse-proxy", report.Mode)
+	assert.Equal(t, len(c.Resources), report.Resources)
+	assert.True(t, report.Routes > report.Resources, "the routes must include the oauth endpoints")
+	assert.True(t, report.Provider.Discovered)
+	assert.Equal(t, p.idp.getLocation(), report.Provider.Issuer)
+	assert.True(t, report.Middlewares["metrics"])
+	assert.True(t, report.Middlewares["default_deny"])
+	assert.False(t, report.Middlewares["csrf"])
+
+	listeners := p.proxy.selfCheckListeners()
+	require.NotEmpty(t, listeners)
+	assert.Equal(t, "main", listeners[0].Name)
+	assert.Equal(t, p.proxy.listener.Addr().String(), listeners[0].Address)
+	assert.False(t, listeners[0].TLS)
+}
+
+func TestSelfCheckStrict(t *testing.T) {
+	c := newFakeKeycloakConfig()
+	c.EnableDefaultDeny = false
+	c.Strict = true
+	auth := newFakeAuthServer()
+	defer auth.Close()
+	c.DiscoveryURL = auth.getLocation()
+	proxy, err := newProxy(c)
+	require.NoError(t, err)
+	core, logs := observer.New(zapcore.InfoLevel)
+	proxy.log = zap.New(core)
+
+	err = proxy.Run()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "refusing to start in strict mode")
+	assert.Contains(t, err.Error(), "enable-default-deny")
+	assert.Nil(t, proxy.listener, "the service must not listen")
+
+	// the summary is logged once the service listens
+	c.Strict = false
+	require.NoError(t, proxy.Run())
+	defer proxy.server.Close()
+	entries := logs.FilterMessage("startup self-check").All()
+	require.Len(t, entries, 1)
+	assert.Equal(t, zapcore.WarnLevel, entries[0].Level)
+	report, ok := entries[0].ContextMap()["self_check"].(*selfCheckReport)
+	require.True(t, ok)
+	assert.NotEmpty(t, report.Listeners)
+	assert.NotEmpty(t, report.Warnings)
+}
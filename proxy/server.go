@@ -13,10 +13,9 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package main
+package proxy
 
 import (
-	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
@@ -28,10 +27,15 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"os/user"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"golang.org/x/crypto/acme"
 	"golang.org/x/crypto/acme/autocert"
 
 	httplog "log"
@@ -39,26 +43,65 @@ import (
 	proxyproto "github.com/armon/go-proxyproto"
 	"github.com/coreos/go-oidc/oidc"
 	"github.com/go-chi/chi"
-	"github.com/go-chi/chi/middleware"
 	"github.com/oneconcern/keycloak-gatekeeper/version"
 	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
 )
 
 type oauthProxy struct {
+	// notBefore is the unix time before which tokens are rejected (accessed atomically, kept first for alignment)
+	notBefore int64
+	// draining is set when the service is taken out of rotation
+	draining int32
+	// providerPending is set while the provider discovery is retried in the background
+	providerPending int32
+	// providerDone stops the provider discovery retried in the background
+	providerDone chan struct{}
+
 	client      *oidc.Client
 	config      *Config
 	endpoint    *url.URL
 	idp         oidc.ProviderConfig
+	idpLock     sync.RWMutex
 	idpClient   *http.Client
 	listener    net.Listener
 	log         *zap.Logger
 	router      http.Handler
 	adminRouter http.Handler
 	server      *http.Server
+	httpServer  *http.Server
+	adminServer *http.Server
+	acme        *autocert.Manager
 	store       storage
 	templates   *template.Template
 	upstream    reverseProxy
+	upstreams   map[string]reverseProxy
+	balancer    *upstreamBalancer
+	resolver    *hostResolver
 	csrf        func(http.Handler) http.Handler
+	sessions    *sessionValidations
+	states      *issuedStates
+	wellKnown   *wellKnownDocuments
+	tokens      *tokenCache
+	refreshes   singleflight.Group
+	coalesced   coalescer
+	keys        *providerKeys
+	health      *healthChecks
+	certs       []*certificationRotation
+	upstreamCA  *caRotation
+	plugins     []Plugin
+	capture     *debugCapture
+	identities  *identityMapping
+	profile     *providerProfile
+	faults      *faultInjections
+	realms      []*realmProxy
+	usage       *byteUsage
+	backoff     *upstreamBackoff
+	// awsCredentials signs the upstream requests of the resources with aws-sigv4-service
+	awsCredentials *awsCredentialsProvider
+
+	// profilingSignal receives the SIGUSR1 requests for profile dumps
+	profilingSignal chan os.Signal
 
 	// preconfigured closures
 	cookieChunker func(string, string) int
@@ -71,6 +114,38 @@ func init() {
 
 // newProxy create's a new proxy from configuration
 func newProxy(config *Config) (*oauthProxy, error) {
+	svc, err := newOAuthProxy(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.EnableForwarding {
+		// runs forward proxy mode
+		if err := svc.createForwardingProxy(); err != nil {
+			return nil, err
+		}
+	} else {
+		// runs reverse proxy mode
+		if err := svc.createReverseProxy(); err != nil {
+			return nil, err
+		}
+		// the realms are served by proxies of their own
+		if len(config.Realms) > 0 {
+			if err := svc.createRealms(); err != nil {
+				return nil, err
+			}
+		}
+
+		// publish health, metrics and profiling endpoints
+		svc.createAdminServices()
+	}
+
+	return svc, nil
+}
+
+// newOAuthProxy creates the proxy service from configuration, i.e. the openid client, store and caches,
+// without any router
+func newOAuthProxy(config *Config) (*oauthProxy, error) {
 	// create the service logger
 	log, err := createLogger(config)
 	if err != nil {
@@ -78,21 +153,73 @@ func newProxy(config *Config) (*oauthProxy, error) {
 	}
 
 	log.Info("starting the service", zap.String("prog", version.Prog), zap.String("author", version.Author), zap.String("version", version.GetVersion()))
+	profile := getProviderProfile(config.Provider)
+	roleClaims, groupClaims := config.RoleClaims, config.GroupClaims
+	if len(roleClaims) == 0 {
+		roleClaims = profile.roleClaims
+	}
+	if len(groupClaims) == 0 {
+		groupClaims = profile.groupClaims
+	}
 	svc := &oauthProxy{
-		config: config,
-		log:    log,
+		config:       config,
+		log:          log,
+		profile:      profile,
+		identities:   newIdentityMapping(roleClaims, config.ClientRolesClaim, groupClaims),
+		providerDone: make(chan struct{}),
 	}
 	svc.cookieChunker = svc.makeCookieChunker()
 	svc.cookieDropper = svc.makeCookieDropper()
 
+	if config.SessionValidationInterval > 0 {
+		svc.sessions = newSessionValidations(config.SessionValidationInterval)
+	}
+	if config.TokenCacheSize > 0 {
+		svc.tokens = newTokenCache(config.TokenCacheSize)
+	}
+	if config.EnableStrictState {
+		svc.states = newIssuedStates()
+	}
+	if config.EnableWellKnown {
+		svc.wellKnown = newWellKnownDocuments(config.WellKnownCacheDuration)
+	}
+	if config.EnableHealthDependencies {
+		svc.health = newHealthChecks()
+	}
+	for _, x := range config.Resources {
+		if x.DebugCapture {
+			log.Warn("debug capture is enabled: requests and responses are logged with their bodies", zap.String("resource", x.URL))
+			svc.capture = newDebugCapture(config.DebugCaptureRate, config.DebugCaptureMaxBody, config.DebugCaptureRedactions)
+		}
+	}
+	if config.IdentityDailyByteQuota > 0 {
+		svc.usage = newByteUsage()
+	}
+	if config.EnableUpstreamBackoff {
+		svc.backoff = newUpstreamBackoff(config.UpstreamBackoffMaxDuration)
+	}
+	if config.EnableFaultInjection {
+		log.Warn("fault injection is enabled: the requests to the resources may be delayed or failed on purpose")
+		svc.faults = newFaultInjections(config.Resources)
+	}
+	if svc.plugins, err = lookupPlugins(config.Plugins); err != nil {
+		return nil, err
+	}
+	for _, plugin := range svc.plugins {
+		log.Info("enabling plugin", zap.String("plugin", plugin.Name()))
+	}
+
 	// parse the upstream endpoint
 	if svc.endpoint, err = url.Parse(config.Upstream); err != nil {
 		return nil, err
 	}
+	svc.balancer = newUpstreamBalancer(config.Upstream, config.UpstreamURLs, config.UpstreamBalancing)
+	svc.resolver = newHostResolver(config)
 
 	// initialize the store if any
 	if config.StoreURL != "" {
-		if svc.store, err = createStorage(config.StoreURL); err != nil {
+		storeDialer := newDialer(config.StoreConnectTimeout, config.StoreKeepaliveTimeout, config.StoreFallbackDelay)
+		if svc.store, err = createStorage(config.StoreURL, svc.resolver.dialContext(storeDialer)); err != nil {
 			return nil, err
 		}
 	}
@@ -100,7 +227,15 @@ func newProxy(config *Config) (*oauthProxy, error) {
 	// initialize the openid client
 	if !config.SkipTokenVerification {
 		if svc.client, svc.idp, svc.idpClient, err = svc.newOpenIDClient(); err != nil {
-			return nil, err
+			if !config.EnableStartWithoutProvider || err != errProviderDiscovery {
+				return nil, err
+			}
+			log.Warn("starting without the openid provider, only the whitelisted resources are served until the discovery succeeds",
+				zap.Error(err))
+			atomic.StoreInt32(&svc.providerPending, 1)
+			go svc.awaitProvider(providerRetryInterval)
+		} else {
+			svc.useProviderKeys()
 		}
 	} else {
 		log.Warn("TESTING ONLY CONFIG - access token verification has been disabled")
@@ -110,21 +245,6 @@ func newProxy(config *Config) (*oauthProxy, error) {
 		log.Warn("client credentials are not set, depending on provider (confidential|public) you might be unable to auth")
 	}
 
-	if config.EnableForwarding {
-		// runs forward proxy mode
-		if err := svc.createForwardingProxy(); err != nil {
-			return nil, err
-		}
-	} else {
-		// runs reverse proxy mode
-		if err := svc.createReverseProxy(); err != nil {
-			return nil, err
-		}
-
-		// publish health, metrics and profiling endpoints
-		svc.createAdminServices()
-	}
-
 	return svc, nil
 }
 
@@ -159,7 +279,7 @@ func createLogger(config *Config) (*zap.Logger, error) {
 func (r *oauthProxy) useDefaultStack(engine chi.Router) {
 	engine.MethodNotAllowed(emptyHandler)
 	engine.NotFound(emptyHandler)
-	engine.Use(middleware.Recoverer)
+	engine.Use(r.recoveryMiddleware("main"))
 
 	// @check if the request tracking id middleware is enabled
 	if r.config.EnableRequestID {
@@ -173,6 +293,11 @@ func (r *oauthProxy) useDefaultStack(engine chi.Router) {
 		engine.Use(r.loggingMiddleware)
 	}
 
+	// @step: reject unknown hosts before any redirection is built from them
+	if len(r.config.AllowedHosts) > 0 {
+		engine.Use(r.allowedHostsMiddleware)
+	}
+
 	if r.config.EnableSecurityFilter {
 		engine.Use(r.securityMiddleware)
 	}
@@ -184,6 +309,12 @@ func (r *oauthProxy) useDefaultStack(engine chi.Router) {
 
 // Run starts the proxy service
 func (r *oauthProxy) Run() error {
+	// step: refuse to start on misconfigurations in strict mode
+	report := r.selfCheck()
+	if r.config.Strict && len(report.Warnings) > 0 {
+		return fmt.Errorf("refusing to start in strict mode: %s", strings.Join(report.Warnings, "; "))
+	}
+
 	listener, err := r.createHTTPListener(makeListenerConfig(r.config))
 	if err != nil {
 		return fmt.Errorf("could not start main service: %v", err)
@@ -191,14 +322,17 @@ func (r *oauthProxy) Run() error {
 
 	// step: create the main http(s) server
 	server := &http.Server{
-		Addr:         r.config.Listen,
-		Handler:      r.router,
-		ReadTimeout:  r.config.ServerReadTimeout,
-		WriteTimeout: r.config.ServerWriteTimeout,
-		IdleTimeout:  r.config.ServerIdleTimeout,
+		Addr:              r.config.Listen,
+		Handler:           r.router,
+		ReadTimeout:       r.config.ServerReadTimeout,
+		ReadHeaderTimeout: r.config.ServerReadHeaderTimeout,
+		WriteTimeout:      r.config.ServerWriteTimeout,
+		IdleTimeout:       r.config.ServerIdleTimeout,
+		MaxHeaderBytes:    r.config.ServerMaxHeaderBytes,
 	}
 	r.server = server
 	r.listener = listener
+	r.watchProfilingSignal()
 
 	go func() {
 		r.log.Info("keycloak proxy service starting", zap.String("interface", r.config.Listen))
@@ -214,20 +348,24 @@ func (r *oauthProxy) Run() error {
 		r.log.Info("keycloak proxy http service starting", zap.String("interface", r.config.ListenHTTP))
 		httpListener, err := r.createHTTPListener(listenerConfig{
 			listen:        r.config.ListenHTTP,
+			network:       r.config.listenNetwork(),
 			proxyProtocol: r.config.EnableProxyProtocol,
 		})
 		if err != nil {
 			return err
 		}
 		httpsvc := &http.Server{
-			Addr:         r.config.ListenHTTP,
-			Handler:      r.router,
-			ReadTimeout:  r.config.ServerReadTimeout,
-			WriteTimeout: r.config.ServerWriteTimeout,
-			IdleTimeout:  r.config.ServerIdleTimeout,
+			Addr:              r.config.ListenHTTP,
+			Handler:           r.acmeHTTPHandler(r.router),
+			ReadTimeout:       r.config.ServerReadTimeout,
+			ReadHeaderTimeout: r.config.ServerReadHeaderTimeout,
+			WriteTimeout:      r.config.ServerWriteTimeout,
+			IdleTimeout:       r.config.ServerIdleTimeout,
+			MaxHeaderBytes:    r.config.ServerMaxHeaderBytes,
 		}
+		r.httpServer = httpsvc
 		go func() {
-			if err := httpsvc.Serve(httpListener); err != nil {
+			if err := httpsvc.Serve(httpListener); err != nil && err != http.ErrServerClosed {
 				r.log.Fatal("failed to start the http redirect service", zap.Error(err))
 			}
 		}()
@@ -247,7 +385,10 @@ func (r *oauthProxy) Run() error {
 			// run the admin endpoint (metrics, health) with http
 			adminListener, err = r.createHTTPListener(listenerConfig{
 				listen:        r.config.ListenAdmin,
+				network:       r.config.listenNetwork(),
 				proxyProtocol: r.config.EnableProxyProtocol,
+				socketMode:    r.config.adminSocketMode(),
+				socketGroup:   r.config.ListenAdminSocketGroup,
 			})
 			if err != nil {
 				return err
@@ -257,6 +398,9 @@ func (r *oauthProxy) Run() error {
 
 			// admin specific overides
 			adminListenerConfig.listen = r.config.ListenAdmin
+			adminListenerConfig.listenIPv6 = ""
+			adminListenerConfig.socketMode = r.config.adminSocketMode()
+			adminListenerConfig.socketGroup = r.config.ListenAdminSocketGroup
 
 			// TLS configuration defaults to the one for the main service,
 			// and may be overidden
@@ -279,20 +423,32 @@ func (r *oauthProxy) Run() error {
 				return err
 			}
 		}
+		readHeaderTimeout, maxHeaderBytes, _ := r.config.adminHeaderLimits()
 		adminsvc := &http.Server{
-			Addr:         r.config.ListenAdmin,
-			Handler:      r.adminRouter,
-			ReadTimeout:  r.config.ServerReadTimeout,
-			WriteTimeout: r.config.ServerWriteTimeout,
-			IdleTimeout:  r.config.ServerIdleTimeout,
+			Addr:              r.config.ListenAdmin,
+			Handler:           r.adminRouter,
+			ReadTimeout:       r.config.ServerReadTimeout,
+			ReadHeaderTimeout: readHeaderTimeout,
+			WriteTimeout:      r.config.ServerWriteTimeout,
+			IdleTimeout:       r.config.ServerIdleTimeout,
+			MaxHeaderBytes:    maxHeaderBytes,
 		}
+		r.adminServer = adminsvc
 
 		go func() {
-			if ers := adminsvc.Serve(adminListener); ers != nil {
+			if ers := adminsvc.Serve(adminListener); ers != nil && ers != http.ErrServerClosed {
 				r.log.Fatal("failed to start the admin service", zap.Error(ers))
 			}
 		}()
 	}
+
+	report.Listeners = r.selfCheckListeners()
+	if len(report.Warnings) > 0 {
+		r.log.Warn("startup self-check", zap.Any("self_check", report))
+	} else {
+		r.log.Info("startup self-check", zap.Any("self_check", report))
+	}
+
 	return nil
 }
 
@@ -301,9 +457,12 @@ type listenerConfig struct {
 	ca                  string   // the path to a certificate authority
 	certificate         string   // the path to the certificate if any
 	clientCerts         []string // the paths to client certificates to use for mutual tls
+	clientCertOptional  bool     // whether the clients may connect without a certificate, verified when given
 	hostnames           []string // list of hostnames the service will respond to
 	letsEncryptCacheDir string   // the path to cache letsencrypt certificates
 	listen              string   // the interface to bind the listener to
+	listenIPv6          string   // a separate IPv6 interface, the listen interface being then bound on IPv4 only
+	network             string   // the network of the tcp listener, e.g. tcp6 for IPv6 only
 	privateKey          string   // the path to the private key if any
 	proxyProtocol       bool     // whether to enable proxy protocol on the listen
 	redirectionURL      string   // url to redirect to
@@ -311,6 +470,10 @@ type listenerConfig struct {
 	useLetsEncryptTLS   bool     // indicates we are using letsencrypt
 	useSelfSignedTLS    bool     // indicates we are using the self-signed tls
 
+	// the permissions of a unix socket, left to the umask when unset
+	socketGroup string
+	socketMode  os.FileMode
+
 	// advanced TLS settings
 	*tlsAdvancedConfig
 }
@@ -321,6 +484,8 @@ func makeListenerConfig(config *Config) listenerConfig {
 		hostnames:           config.Hostnames,
 		letsEncryptCacheDir: config.LetsEncryptCacheDir,
 		listen:              config.Listen,
+		listenIPv6:          config.ListenIPv6,
+		network:             config.listenNetwork(),
 		proxyProtocol:       config.EnableProxyProtocol,
 		redirectionURL:      config.RedirectionURL,
 		privateKey:          config.TLSPrivateKey,
@@ -346,12 +511,37 @@ func makeListenerConfig(config *Config) listenerConfig {
 	if len(config.TLSClientCertificates) > 0 {
 		cfg.clientCerts = config.TLSClientCertificates
 	}
+	cfg.clientCertOptional = config.TLSClientAuth == clientAuthVerifyIfGiven
 	return cfg
 }
 
 // ErrHostNotConfigured indicates the hostname was not configured
 var ErrHostNotConfigured = errors.New("acme/autocert: host not configured")
 
+// setSocketPermissions restricts the access to a unix socket by its file mode and group
+func setSocketPermissions(socket string, mode os.FileMode, group string) error {
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			if g, err = user.LookupGroupId(group); err != nil {
+				return fmt.Errorf("unknown group %s of the unix socket %s", group, socket)
+			}
+		}
+		gid, err := strconv.Atoi(g.Gid)
+		if err != nil {
+			return err
+		}
+		if err := os.Chown(socket, -1, gid); err != nil {
+			return err
+		}
+	}
+	if mode != 0 {
+		return os.Chmod(socket, mode)
+	}
+
+	return nil
+}
+
 // createHTTPListener is responsible for creating a listening socket
 func (r *oauthProxy) createHTTPListener(config listenerConfig) (net.Listener, error) {
 	var listener net.Listener
@@ -369,7 +559,11 @@ func (r *oauthProxy) createHTTPListener(config listenerConfig) (net.Listener, er
 		if listener, err = net.Listen("unix", socket); err != nil {
 			return nil, err
 		}
-	} else if listener, err = net.Listen("tcp", config.listen); err != nil {
+		if err = setSocketPermissions(socket, config.socketMode, config.socketGroup); err != nil {
+			_ = listener.Close()
+			return nil, err
+		}
+	} else if listener, err = listenTCP(config); err != nil {
 		return nil, err
 	}
 
@@ -386,35 +580,7 @@ func (r *oauthProxy) createHTTPListener(config listenerConfig) (net.Listener, er
 		}
 
 		if config.useLetsEncryptTLS {
-			r.log.Info("enabling letsencrypt tls support")
-
-			m := autocert.Manager{
-				Prompt: autocert.AcceptTOS,
-				Cache:  autocert.DirCache(config.letsEncryptCacheDir),
-				HostPolicy: func(_ context.Context, host string) error {
-					if len(config.hostnames) > 0 {
-						found := false
-
-						for _, h := range config.hostnames {
-							found = found || (h == host)
-						}
-
-						if !found {
-							return ErrHostNotConfigured
-						}
-					} else if config.redirectionURL != "" {
-						if u, err := url.Parse(config.redirectionURL); err != nil {
-							return err
-						} else if u.Host != host {
-							return ErrHostNotConfigured
-						}
-					}
-
-					return nil
-				},
-			}
-
-			getCertificate = m.GetCertificate
+			getCertificate = r.acmeManager(config).GetCertificate
 		}
 
 		if config.useSelfSignedTLS {
@@ -441,6 +607,8 @@ func (r *oauthProxy) createHTTPListener(config listenerConfig) (net.Listener, er
 				return nil, err
 			}
 
+			r.certs = append(r.certs, rotate)
+
 			getCertificate = rotate.GetCertificate
 		}
 
@@ -460,6 +628,10 @@ func (r *oauthProxy) createHTTPListener(config listenerConfig) (net.Listener, er
 			MinVersion:               ts.tlsMinVersion,
 			CipherSuites:             ts.tlsCipherSuites,
 		}
+		if config.useLetsEncryptTLS {
+			// answers the tls-alpn-01 challenges
+			tlsConfig.NextProtos = append(tlsConfig.NextProtos, acme.ALPNProto)
+		}
 
 		// @check if we are doing mutual tls
 		if len(config.clientCerts) > 0 {
@@ -471,6 +643,9 @@ func (r *oauthProxy) createHTTPListener(config listenerConfig) (net.Listener, er
 			}
 			tlsConfig.ClientCAs = caCertPool
 			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+			if config.clientCertOptional {
+				tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+			}
 		}
 		listener = tls.NewListener(listener, tlsConfig)
 	}
@@ -491,6 +666,26 @@ func (r *oauthProxy) createTemplates() error {
 		list = append(list, r.config.ForbiddenPage)
 	}
 
+	if r.config.GatewayTimeoutPage != "" {
+		r.log.Debug("loading the custom gateway timeout page", zap.String("page", r.config.GatewayTimeoutPage))
+		list = append(list, r.config.GatewayTimeoutPage)
+	}
+
+	if r.config.ProviderUnavailablePage != "" {
+		r.log.Debug("loading the custom provider unavailable page", zap.String("page", r.config.ProviderUnavailablePage))
+		list = append(list, r.config.ProviderUnavailablePage)
+	}
+
+	if r.config.HeaderLimitPage != "" {
+		r.log.Debug("loading the custom header limit page", zap.String("page", r.config.HeaderLimitPage))
+		list = append(list, r.config.HeaderLimitPage)
+	}
+
+	if r.config.RedirectLoopPage != "" {
+		r.log.Debug("loading the custom redirect loop page", zap.String("page", r.config.RedirectLoopPage))
+		list = append(list, r.config.RedirectLoopPage)
+	}
+
 	if len(list) > 0 {
 		r.log.Info("loading the custom templates", zap.String("templates", strings.Join(list, ",")))
 		r.templates = template.Must(template.ParseFiles(list...))
@@ -519,52 +714,70 @@ func (r *oauthProxy) newOpenIDClient() (*oidc.Client, oidc.ProviderConfig, *http
 			return nil, config, nil, err
 		}
 	}
+	tlsConfig := &tls.Config{
+		//nolint:gas
+		InsecureSkipVerify: r.config.SkipOpenIDProviderTLSVerify,
+		RootCAs:            pool,
+	}
+	// step: pin the public keys of the provider certificates
+	if len(r.config.OpenIDProviderPins) > 0 {
+		pins, erp := newSPKIPins(r.config.OpenIDProviderPins, r.config.OpenIDProviderGracePins, r.log)
+		if erp != nil {
+			return nil, config, nil, erp
+		}
+		tlsConfig.VerifyPeerCertificate = pins.verifyPeerCertificate
+	}
 	hc := &http.Client{
-		Transport: &http.Transport{
-			Proxy: func(_ *http.Request) (*url.URL, error) {
-				if r.config.OpenIDProviderProxy != "" {
-					idpProxyURL, erp := url.Parse(r.config.OpenIDProviderProxy)
-					if erp != nil {
-						r.log.Warn("invalid proxy address for open IDP provider proxy", zap.Error(erp))
-						return nil, nil
-					}
-					return idpProxyURL, nil
-				}
-
-				return nil, nil
-			},
-			TLSClientConfig: &tls.Config{
-				//nolint:gas
-				InsecureSkipVerify: r.config.SkipOpenIDProviderTLSVerify,
-				RootCAs:            pool,
-			},
-		},
+		Transport: &providerTransport{&http.Transport{
+			DialContext:     r.resolver.dialContext(newDialer(r.config.OpenIDProviderConnectTimeout, r.config.OpenIDProviderKeepaliveTimeout, r.config.OpenIDProviderFallbackDelay)),
+			Proxy:           makeProxyFunc(r.config.OpenIDProviderProxy, r.config.OpenIDProviderNoProxy),
+			TLSClientConfig: tlsConfig,
+		}},
 		Timeout: time.Second * 10,
 	}
 
 	// step: attempt to retrieve the provider configuration
-	completeCh := make(chan bool)
+	completeCh := make(chan oidc.ProviderConfig, 1)
+	stopCh := make(chan struct{})
+	interval := providerRetryInterval
 	go func() {
 		for {
 			r.log.Info("attempting to retrieve configuration discovery url",
 				zap.String("url", r.config.DiscoveryURL),
 				zap.String("timeout", r.config.OpenIDProviderTimeout.String()))
-			if config, err = oidc.FetchProviderConfig(hc, r.config.DiscoveryURL); err == nil {
-				break // break and complete
+			discovered, err := r.fetchProviderConfig(hc)
+			if err == nil {
+				completeCh <- discovered
+				return
 			}
 			r.log.Warn("failed to get provider configuration from discovery", zap.Error(err))
-			time.Sleep(time.Second * 3)
+			select {
+			case <-stopCh:
+				return
+			case <-time.After(interval):
+			}
 		}
-		completeCh <- true
 	}()
 	// wait for timeout or successful retrieval
 	select {
 	case <-time.After(r.config.OpenIDProviderTimeout):
-		return nil, config, nil, errors.New("failed to retrieve the provider configuration from discovery url")
-	case <-completeCh:
+		close(stopCh)
+		// the client is kept for the discovery to be retried in the background
+		return nil, config, hc, errProviderDiscovery
+	case config = <-completeCh:
 		r.log.Info("successfully retrieved openid configuration from the discovery")
 	}
 
+	client, err := r.newProviderClient(hc, config)
+	if err != nil {
+		return nil, config, hc, err
+	}
+
+	return client, config, hc, nil
+}
+
+// newProviderClient creates the openid client of a provider configuration
+func (r *oauthProxy) newProviderClient(hc *http.Client, config oidc.ProviderConfig) (*oidc.Client, error) {
 	client, err := oidc.NewClient(oidc.ClientConfig{
 		Credentials: oidc.ClientCredentials{
 			ID:     r.config.ClientID,
@@ -576,12 +789,14 @@ func (r *oauthProxy) newOpenIDClient() (*oidc.Client, oidc.ProviderConfig, *http
 		Scope:          append(r.config.Scopes, oidc.DefaultScope...),
 	})
 	if err != nil {
-		return nil, config, hc, err
+		return nil, err
+	}
+	// start the provider sync for key rotation, the keys being otherwise fetched from the provider keys endpoint
+	if !r.profile.tenantIssuer {
+		client.SyncProviderConfig(r.config.DiscoveryURL)
 	}
-	// start the provider sync for key rotation
-	client.SyncProviderConfig(r.config.DiscoveryURL)
 
-	return client, config, hc, nil
+	return client, nil
 }
 
 // Render implements the echo Render interface
@@ -610,18 +825,35 @@ func (r *oauthProxy) buildProxyTLSConfig() (*tls.Config, error) {
 	}
 
 	// @check if we have an upstream ca to verify the upstream
-	if r.config.UpstreamCA != "" {
+	if r.config.UpstreamCA != "" && r.upstreamCA == nil {
 		r.log.Info("loading the upstream ca", zap.String("path", r.config.UpstreamCA))
-		pool, err := makeCertPool("upstream CA", r.config.UpstreamCA)
+		ca, err := newCARotator("upstream CA", r.config.UpstreamCA, r.log)
 		if err != nil {
 			r.log.Error("unable to read upstream CA certificate", zap.String("path", r.config.UpstreamCA), zap.Error(err))
 			return nil, err
 		}
-		tlsConfig.RootCAs = pool
+		// the upstream ca is reloaded when its file changes
+		if err := ca.watch(); err != nil {
+			r.log.Error("error while setting file watch on the upstream CA certificate", zap.Error(err))
+			return nil, err
+		}
+		r.upstreamCA = ca
+	}
+	if r.upstreamCA != nil {
+		tlsConfig.RootCAs = r.upstreamCA.certPool()
 	}
 	return tlsConfig, nil
 }
 
+// useUpstreamCA verifies the tls connections of an upstream transport with the current upstream ca. The
+// connections through an egress proxy are verified by the ca loaded on start.
+func (r *oauthProxy) useUpstreamCA(transport *http.Transport) {
+	if r.upstreamCA == nil || transport.TLSClientConfig == nil || transport.TLSClientConfig.InsecureSkipVerify {
+		return
+	}
+	transport.DialTLSContext = r.upstreamCA.dialTLS(transport.DialContext, transport.TLSClientConfig, transport.TLSHandshakeTimeout)
+}
+
 func makeCertPool(who string, certs ...string) (*x509.CertPool, error) {
 	caCertPool := x509.NewCertPool()
 	for _, cert := range certs {
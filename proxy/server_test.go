@@ -13,7 +13,7 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package main
+package proxy
 
 import (
 	"context"
@@ -129,7 +129,7 @@ func TestForwardingProxy(t *testing.T) {
 
 func TestForbiddenTemplate(t *testing.T) {
 	cfg := newFakeKeycloakConfig()
-	cfg.ForbiddenPage = "templates/forbidden.html.tmpl"
+	cfg.ForbiddenPage = "../templates/forbidden.html.tmpl"
 	cfg.Resources = []*Resource{
 		{
 			URL:     "/*",
@@ -231,7 +231,7 @@ func TestDefaultDenial(t *testing.T) {
 
 func TestAuthorizationTemplate(t *testing.T) {
 	cfg := newFakeKeycloakConfig()
-	cfg.SignInPage = "templates/sign_in.html.tmpl"
+	cfg.SignInPage = "../templates/sign_in.html.tmpl"
 	cfg.Resources = []*Resource{
 		{
 			URL:     "/*",
@@ -508,7 +508,8 @@ func makeTestCodeFlowLogin(location string) (*http.Response, error) {
 		if err != nil {
 			return nil, err
 		}
-		if resp.StatusCode != http.StatusTemporaryRedirect {
+		// the provider redirects back with a 302, the proxy with a 307
+		if resp.StatusCode != http.StatusTemporaryRedirect && resp.StatusCode != http.StatusFound {
 			return nil, errors.New("no redirection found in resp")
 		}
 		location = resp.Header.Get("Location")
@@ -13,13 +13,13 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package main
+package proxy
 
 import (
-	"bytes"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/coreos/go-oidc/jose"
 	"go.uber.org/zap"
@@ -33,20 +33,47 @@ func (r *oauthProxy) getIdentity(req *http.Request) (*userContext, error) {
 	if err != nil {
 		return nil, err
 	}
-	if r.config.EnableEncryptedToken || r.config.ForceEncryptedCookie && !isBearer {
+	// step: the cookie may only hold the handle of an access token kept in the store
+	var handle string
+	if r.config.EnableServerSideTokens && !isBearer {
+		handle = access
+		if access, err = r.getStoredAccessToken(handle); err != nil {
+			return nil, err
+		}
+	}
+	// step: skip decoding and verification of a token seen before
+	if user := r.tokens.get(access); user != nil {
+		user.bearerToken = isBearer
+		user.sessionHandle = handle
+		return user, nil
+	}
+	raw := access
+	if (r.config.EnableEncryptedToken || r.config.ForceEncryptedCookie && !isBearer) && handle == "" {
 		if access, err = decodeText(access, r.config.EncryptionKey); err != nil {
 			return nil, ErrDecryption
 		}
 	}
-	token, err := jose.ParseJWT(access)
-	if err != nil {
-		return nil, err
-	}
-	user, err := extractIdentity(token)
-	if err != nil {
-		return nil, err
+	var user *userContext
+	if token, erp := jose.ParseJWT(access); erp == nil {
+		if user, err = r.identities.extractIdentity(token); err != nil {
+			return nil, err
+		}
+	} else {
+		if !r.config.EnableTokenIntrospection {
+			return nil, erp
+		}
+		// step: the opaque tokens are only known to the provider
+		if user, err = r.introspectIdentity(req.Context(), access); err != nil {
+			return nil, err
+		}
 	}
 	user.bearerToken = isBearer
+	user.rawToken = raw
+	user.sessionHandle = handle
+	if user.opaqueToken != "" {
+		r.tokens.addUntil(user, time.Now().Add(r.config.IntrospectionCacheDuration))
+		user.verified = true
+	}
 
 	r.log.Debug("found the user identity",
 		zap.String("id", user.id),
@@ -58,6 +85,32 @@ func (r *oauthProxy) getIdentity(req *http.Request) (*userContext, error) {
 	return user, nil
 }
 
+// restoreSession renews the session of a client left with its refresh token cookie only, e.g. once the session
+// cookies are dropped by a restart of the browser, or the access token cookie has expired
+func (r *oauthProxy) restoreSession(w http.ResponseWriter, req *http.Request) (*userContext, error) {
+	if !r.config.EnableRefreshTokens || r.useStore() || r.config.EnableServerSideTokens {
+		return nil, ErrSessionNotFound
+	}
+	if _, err := req.Cookie(r.config.CookieRefreshName); err != nil {
+		return nil, ErrSessionNotFound
+	}
+
+	restored := &userContext{}
+	if err := r.refreshToken(w, req, restored); err != nil {
+		return nil, err
+	}
+	user, err := r.identities.extractIdentity(restored.token)
+	if err != nil {
+		return nil, err
+	}
+
+	r.log.Info("restored the session from the refresh token",
+		zap.String("client_ip", req.RemoteAddr),
+		zap.String("email", user.email))
+
+	return user, nil
+}
+
 // getRefreshTokenFromCookie returns the refresh token from the cookie if any
 func (r *oauthProxy) getRefreshTokenFromCookie(req *http.Request) (string, error) {
 	token, err := getTokenInCookie(req, r.config.CookieRefreshName)
@@ -106,15 +159,17 @@ func getTokenInBearer(req *http.Request) (string, error) {
 
 // getTokenInCookie retrieves the access token from the request cookies
 func getTokenInCookie(req *http.Request, name string) (string, error) {
-	var token bytes.Buffer
+	token := getBuffer()
+	defer putBuffer(token)
+	cookies := req.Cookies()
 
-	if cookie := findCookie(name, req.Cookies()); cookie != nil {
+	if cookie := findCookie(name, cookies); cookie != nil {
 		token.WriteString(cookie.Value)
 	}
 
 	// add also divided cookies
 	for i := 1; i < 600; i++ {
-		cookie := findCookie(name+"-"+strconv.Itoa(i), req.Cookies())
+		cookie := findCookie(name+"-"+strconv.Itoa(i), cookies)
 		if cookie == nil {
 			break
 		} else {
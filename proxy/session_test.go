@@ -13,14 +13,16 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package main
+package proxy
 
 import (
 	"fmt"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGetIndentity(t *testing.T) {
@@ -180,3 +182,53 @@ func TestGetRefreshTokenFromCookie(t *testing.T) {
 		}
 	}
 }
+
+func TestRestoreSessionFromRefreshCookie(t *testing.T) {
+	const refreshKey = "Qm7vT2xKp9LcW4sN"
+	cfg := newFakeKeycloakConfig()
+	cfg.EnableRefreshTokens = true
+	cfg.EncryptionKey = testKey
+	cfg.RefreshTokenCookieKey = refreshKey
+	cfg.RefreshTokenCookieDuration = 48 * time.Hour
+	cfg.EnableSessionCookies = true
+	p := newFakeProxy(cfg)
+	defer func() {
+		p.idp.Close()
+		p.proxy.server.Close()
+	}()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	token := newTestToken(p.idp.getLocation())
+	token.newJTI()
+	refresh, err := p.idp.signToken(token.claims)
+	require.NoError(t, err)
+	do := func(key string) *http.Response {
+		encrypted, err := encodeTextWithCompression(refresh.Encode(), key, cfg.EnableCookieCompression)
+		require.NoError(t, err)
+		req, err := http.NewRequest(http.MethodGet, p.getServiceURL()+fakeAuthAllURL, nil)
+		require.NoError(t, err)
+		req.AddCookie(&http.Cookie{Name: cfg.CookieRefreshName, Value: encrypted})
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		return resp
+	}
+
+	// the session is restored from the refresh token cookie alone
+	resp := do(refreshKey)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	access := findCookie(cfg.CookieAccessName, resp.Cookies())
+	require.NotNil(t, access)
+	assert.NotEmpty(t, access.Value)
+	assert.True(t, access.Expires.IsZero(), "the access token cookie must remain a session cookie")
+	renewed := findCookie(cfg.CookieRefreshName, resp.Cookies())
+	require.NotNil(t, renewed)
+	assert.WithinDuration(t, time.Now().Add(cfg.RefreshTokenCookieDuration), renewed.Expires, time.Minute)
+	decoded, err := decodeText(renewed.Value, refreshKey)
+	require.NoError(t, err)
+	assert.NotEmpty(t, decoded)
+
+	// the refresh tokens are not encrypted with the encryption key
+	resp = do(testKey)
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+}
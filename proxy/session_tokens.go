@@ -0,0 +1,76 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	cryptorand "crypto/rand"
+	sha "crypto/sha256"
+	"encoding/base64"
+	"time"
+)
+
+// accessKeyPrefix prefixes the keys of the access tokens kept in the store
+const accessKeyPrefix = "access:"
+
+// accessKey is the key of the access token of a session handle in the store: the handles, which
+// authenticate the users, are not kept in clear
+func accessKey(handle string) string {
+	hash := sha.Sum256([]byte(handle))
+	return accessKeyPrefix + base64.RawStdEncoding.EncodeToString(hash[:])
+}
+
+// storeAccessToken keeps the access token of a session in the store for the lifetime of the session, and returns
+// the opaque handle set in the access cookie in its stead. The handle of a refreshed session is kept.
+func (r *oauthProxy) storeAccessToken(handle, token string, ttl time.Duration) (string, error) {
+	if handle == "" {
+		secret := make([]byte, 32)
+		if _, err := cryptorand.Read(secret); err != nil {
+			return "", err
+		}
+		handle = base64.RawURLEncoding.EncodeToString(secret)
+	}
+	encrypted, err := encodeText(token, r.config.EncryptionKey)
+	if err != nil {
+		return "", err
+	}
+	if err := r.store.Set(accessKey(handle), encrypted, ttl); err != nil {
+		return "", err
+	}
+
+	return handle, nil
+}
+
+// getStoredAccessToken returns the access token kept in the store for a session handle
+func (r *oauthProxy) getStoredAccessToken(handle string) (string, error) {
+	encrypted, err := r.store.Get(accessKey(handle))
+	if err != nil {
+		return "", err
+	}
+	if encrypted == "" {
+		return "", ErrSessionNotFound
+	}
+	token, err := decodeText(encrypted, r.config.EncryptionKey)
+	if err != nil {
+		return "", ErrDecryption
+	}
+
+	return token, nil
+}
+
+// deleteStoredAccessToken removes the access token of a session handle from the store
+func (r *oauthProxy) deleteStoredAccessToken(handle string) error {
+	return r.store.Delete(accessKey(handle))
+}
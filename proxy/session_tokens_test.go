@@ -0,0 +1,103 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/resty.v1"
+)
+
+func TestServerSideTokens(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "keycloak-gatekeeper")
+	require.NoError(t, err)
+	_ = tmpfile.Close()
+	defer os.Remove(tmpfile.Name())
+
+	cfg := newFakeKeycloakConfig()
+	cfg.EnableRefreshTokens = true
+	cfg.EnableServerSideTokens = true
+	cfg.EncryptionKey = testKey
+	cfg.StoreURL = fmt.Sprintf("boltdb:///%s", tmpfile.Name())
+	p := newFakeProxy(cfg)
+	defer func() { _ = p.proxy.CloseStore() }()
+	p.idp.setTokenExpiration(1000 * time.Millisecond).setRefreshTokenExpiration(time.Hour)
+
+	var handle string
+	isHandle := func(value string) bool {
+		// the cookie holds the handle of the session, not the token
+		return value != "" && !strings.Contains(value, ".")
+	}
+	requests := []fakeRequest{
+		{
+			URI:           fakeAuthAllURL,
+			HasLogin:      true,
+			Redirects:     true,
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+			OnResponse: func(int, *resty.Request, *resty.Response) {
+				handle = p.cookies[cfg.CookieAccessName].Value
+				<-time.After(1000 * time.Millisecond)
+			},
+		},
+		{
+			// the refreshed access token is kept under the same handle
+			URI:           fakeAuthAllURL,
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+			ExpectedCookiesValidator: map[string]func(string) bool{
+				cfg.CookieAccessName: func(value string) bool { return isHandle(value) && value == handle },
+			},
+			OnResponse: func(int, *resty.Request, *resty.Response) {
+				delete(p.cookies, cfg.CookieAccessName)
+			},
+		},
+		{
+			URI:          fakeAuthAllURL,
+			Cookies:      []*http.Cookie{{Name: cfg.CookieAccessName, Value: "forged"}},
+			ExpectedCode: http.StatusUnauthorized,
+		},
+	}
+	p.RunTests(t, requests)
+	assert.True(t, isHandle(handle))
+
+	// the access token is kept for the lifetime of the session only
+	store := p.proxy.store.(*boltdbStore)
+	require.NoError(t, store.client.View(func(tx *bolt.Tx) error {
+		assert.NotNil(t, tx.Bucket([]byte(dbExpiries)).Get([]byte(accessKey(handle))))
+		return nil
+	}))
+}
+
+func TestServerSideTokensValid(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.EnableServerSideTokens = true
+	cfg.EncryptionKey = testKey
+	assert.Error(t, cfg.isValid())
+
+	cfg.StoreURL = "boltdb:///tmp/tokens"
+	cfg.EncryptionKey = "short"
+	assert.Error(t, cfg.isValid())
+}
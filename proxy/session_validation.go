@@ -0,0 +1,143 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/jose"
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+)
+
+// sessionValidations keeps track of the last time sessions were validated against the provider
+type sessionValidations struct {
+	sync.Mutex
+	interval  time.Duration
+	validated map[string]time.Time
+	lastPurge time.Time
+	// checks shares a validation between the concurrent requests of a session
+	checks singleflight.Group
+}
+
+func newSessionValidations(interval time.Duration) *sessionValidations {
+	return &sessionValidations{
+		interval:  interval,
+		validated: make(map[string]time.Time),
+		lastPurge: time.Now(),
+	}
+}
+
+// isDue checks if a session should be validated again
+func (s *sessionValidations) isDue(session string) bool {
+	s.Lock()
+	defer s.Unlock()
+	last, found := s.validated[session]
+
+	return !found || time.Since(last) >= s.interval
+}
+
+// record sets the last validation time of a session, and purges the stale sessions
+func (s *sessionValidations) record(session string) {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	s.validated[session] = now
+
+	if now.Sub(s.lastPurge) < s.interval {
+		return
+	}
+	for k, last := range s.validated {
+		if now.Sub(last) >= s.interval {
+			delete(s.validated, k)
+		}
+	}
+	s.lastPurge = now
+}
+
+// forget removes a session which is no longer valid
+func (s *sessionValidations) forget(session string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.validated, session)
+}
+
+// sessionValidationKey identifies the session of a user, or its token when the provider tells no session
+func sessionValidationKey(user *userContext) string {
+	if session := user.getSessionID(); session != "" {
+		return session
+	}
+
+	return user.encodedToken()
+}
+
+// recordSessionValidation notes that the session of a token just issued by the provider is valid
+func (r *oauthProxy) recordSessionValidation(token jose.JWT) {
+	if r.sessions == nil {
+		return
+	}
+	if user, err := r.identities.extractIdentity(token); err == nil {
+		r.sessions.record(sessionValidationKey(user))
+	}
+}
+
+// isSessionValid checks periodically the session of the user against the provider userinfo endpoint, the
+// concurrent requests of a session sharing a single check. An unreachable provider is not considered as a
+// session termination.
+func (r *oauthProxy) isSessionValid(user *userContext) bool {
+	idp := r.getProviderConfig()
+	if r.sessions == nil || idp.UserInfoEndpoint == nil {
+		return true
+	}
+
+	session := sessionValidationKey(user)
+	if !r.sessions.isDue(session) {
+		return true
+	}
+
+	valid, _, _ := r.sessions.checks.Do(session, func() (interface{}, error) {
+		// another request may have completed the check in the meantime
+		if !r.sessions.isDue(session) {
+			return true, nil
+		}
+		client, err := r.getProviderClient().OAuthClient()
+		if err != nil {
+			r.log.Warn("unable to retrieve the oauth client to validate the session", zap.Error(err))
+			return true, nil
+		}
+
+		start := time.Now()
+		_, err = getUserinfo(client, idp.UserInfoEndpoint.String(), user.encodedToken())
+		oauthLatencyMetric.WithLabelValues("userinfo").Observe(time.Since(start).Seconds())
+
+		switch err {
+		case nil:
+			r.sessions.record(session)
+		case ErrUserinfoRejected:
+			r.sessions.forget(session)
+			return false, nil
+		default:
+			// avoid flooding an unavailable provider: the session will be checked on the next interval
+			r.log.Warn("unable to validate the session against the provider", zap.Error(err))
+			r.sessions.record(session)
+		}
+
+		return true, nil
+	})
+
+	return valid.(bool)
+}
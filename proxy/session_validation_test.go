@@ -0,0 +1,110 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-oidc/jose"
+	"github.com/oneconcern/keycloak-gatekeeper/gatekeepertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionValidations(t *testing.T) {
+	s := newSessionValidations(50 * time.Millisecond)
+	assert.True(t, s.isDue("session"))
+	s.record("session")
+	assert.False(t, s.isDue("session"))
+	time.Sleep(60 * time.Millisecond)
+	assert.True(t, s.isDue("session"))
+
+	// stale sessions are purged
+	s.record("other")
+	assert.Len(t, s.validated, 1)
+
+	s.forget("other")
+	assert.True(t, s.isDue("other"))
+}
+
+func TestSessionValidationInterval(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.SessionValidationInterval = time.Hour
+	p := newFakeProxy(cfg)
+	p.idp.RevokeSession("killed-session")
+
+	requests := []fakeRequest{
+		{
+			URI:           testAdminURI,
+			HasToken:      true,
+			Roles:         []string{fakeAdminRole},
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+		},
+		{
+			URI:          testAdminURI,
+			HasToken:     true,
+			Roles:        []string{fakeAdminRole},
+			TokenClaims:  jose.Claims{claimSessionState: "killed-session"},
+			ExpectedCode: http.StatusUnauthorized,
+		},
+	}
+	p.RunTests(t, requests)
+}
+
+func TestSessionValidationOnce(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.SessionValidationInterval = time.Hour
+	px, idp, _ := newTestProxyService(cfg)
+	idp.Fail(gatekeepertest.UserinfoEndpoint, gatekeepertest.Failure{Delay: 100 * time.Millisecond})
+	token := newTestToken(idp.getLocation())
+	token.merge(jose.Claims{claimSessionState: "session"})
+	signed, err := idp.signToken(token.claims)
+	require.NoError(t, err)
+	user, err := px.identities.extractIdentity(*signed)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.True(t, px.isSessionValid(user))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, idp.Requests(gatekeepertest.UserinfoEndpoint))
+}
+
+func TestSessionValidationAfterLogin(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.SessionValidationInterval = time.Hour
+	px, idp, _ := newTestProxyService(cfg)
+	token := newTestToken(idp.getLocation())
+	token.merge(jose.Claims{claimSessionState: "session"})
+	signed, err := idp.signToken(token.claims)
+	require.NoError(t, err)
+	user, err := px.identities.extractIdentity(*signed)
+	require.NoError(t, err)
+
+	// the session just opened is not validated until the next interval
+	px.recordSessionValidation(*signed)
+	assert.True(t, px.isSessionValid(user))
+	assert.Equal(t, 0, idp.Requests(gatekeepertest.UserinfoEndpoint))
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+const spkiPinPrefix = "sha256/"
+
+// errSPKIPinMismatch indicates none of the certificates presented matches a pin
+var errSPKIPinMismatch = errors.New("the tls certificate of the openid provider does not match any pinned public key")
+
+// spkiPins verifies the certificates of the provider against the hashes of their subject public key info. The
+// grace pins are still accepted while the keys are rotated, with a warning.
+type spkiPins struct {
+	pins  map[string]bool
+	grace map[string]bool
+	log   *zap.Logger
+}
+
+// newSPKIPins creates the pins of the provider certificates
+func newSPKIPins(pins, grace []string, log *zap.Logger) (*spkiPins, error) {
+	p := &spkiPins{log: log}
+	var err error
+	if p.pins, err = parseSPKIPins(pins); err != nil {
+		return nil, err
+	}
+	if p.grace, err = parseSPKIPins(grace); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// parseSPKIPins parses base64 encoded sha256 hashes, optionally prefixed by sha256/
+func parseSPKIPins(pins []string) (map[string]bool, error) {
+	parsed := make(map[string]bool, len(pins))
+	for _, pin := range pins {
+		encoded := strings.TrimPrefix(strings.TrimSpace(pin), spkiPinPrefix)
+		hash, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("the pin %q is not a base64 encoded sha256 hash of a subject public key info", pin)
+		}
+		parsed[encoded] = true
+	}
+
+	return parsed, nil
+}
+
+// spkiHash returns the base64 encoded sha256 hash of the subject public key info of a certificate
+func spkiHash(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// verifyPeerCertificate accepts the connections when a certificate of the chain, the leaf or an authority, matches
+// a pin. The chains verified are checked when the certificates are verified, only the leaf presented otherwise: the
+// other certificates presented are not bound to the leaf, and could be any public authority appended by a peer.
+func (p *spkiPins) verifyPeerCertificate(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	var certs []*x509.Certificate
+	for _, chain := range verifiedChains {
+		certs = append(certs, chain...)
+	}
+	if len(verifiedChains) == 0 {
+		if len(rawCerts) == 0 {
+			return errSPKIPinMismatch
+		}
+		cert, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return err
+		}
+		certs = append(certs, cert)
+	}
+
+	var grace *x509.Certificate
+	for _, cert := range certs {
+		hash := spkiHash(cert)
+		if p.pins[hash] {
+			return nil
+		}
+		if p.grace[hash] && grace == nil {
+			grace = cert
+		}
+	}
+	if grace != nil {
+		p.log.Warn("the tls certificate of the openid provider matches a grace pin, the pins should be rotated",
+			zap.String("subject", grace.Subject.String()),
+			zap.String("pin", spkiPinPrefix+spkiHash(grace)))
+		return nil
+	}
+
+	return errSPKIPinMismatch
+}
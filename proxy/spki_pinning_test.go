@@ -0,0 +1,105 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// otherPin is the pin of a public key not presented by the test servers
+var otherPin = func() string {
+	hash := sha256.Sum256([]byte("other"))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}()
+
+func TestProviderPinningValid(t *testing.T) {
+	c := &Config{OpenIDProviderPins: []string{"sha256/" + otherPin, otherPin}}
+	assert.NoError(t, c.isProviderPinningValid())
+
+	c = &Config{OpenIDProviderPins: []string{"sha256/not-a-hash"}}
+	assert.Error(t, c.isProviderPinningValid())
+
+	c = &Config{OpenIDProviderPins: []string{base64.StdEncoding.EncodeToString([]byte("short"))}}
+	assert.Error(t, c.isProviderPinningValid())
+
+	c = &Config{OpenIDProviderGracePins: []string{otherPin}}
+	assert.Error(t, c.isProviderPinningValid(), "the grace pins require pins")
+}
+
+func TestSPKIPinning(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer server.Close()
+	serverPin := spkiHash(server.Certificate())
+
+	get := func(pins, grace []string, log *zap.Logger) error {
+		p, err := newSPKIPins(pins, grace, log)
+		require.NoError(t, err)
+		transport := server.Client().Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig.VerifyPeerCertificate = p.verifyPeerCertificate
+		resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		return err
+	}
+
+	assert.NoError(t, get([]string{otherPin, spkiPinPrefix + serverPin}, nil, zap.NewNop()))
+
+	err := get([]string{otherPin}, nil, zap.NewNop())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), errSPKIPinMismatch.Error())
+	}
+
+	// the certificates of the grace pins are accepted with a warning
+	core, logs := observer.New(zapcore.WarnLevel)
+	assert.NoError(t, get([]string{otherPin}, []string{serverPin}, zap.New(core)))
+	assert.Equal(t, 1, logs.FilterMessageSnippet("grace pin").Len())
+
+	// the certificates presented are pinned when they are not verified
+	p, err := newSPKIPins([]string{serverPin}, nil, zap.NewNop())
+	require.NoError(t, err)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		//nolint:gas
+		InsecureSkipVerify:    true,
+		VerifyPeerCertificate: p.verifyPeerCertificate,
+	}}}
+	resp, err := client.Get(server.URL)
+	if assert.NoError(t, err) {
+		_ = resp.Body.Close()
+	}
+
+	// the other certificates presented are not bound to the leaf, unless verified
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	other, err := createCertificate(key, []string{"localhost"}, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, errSPKIPinMismatch, p.verifyPeerCertificate([][]byte{other.Certificate[0], server.Certificate().Raw}, nil))
+	assert.NoError(t, p.verifyPeerCertificate([][]byte{server.Certificate().Raw, other.Certificate[0]}, nil))
+}
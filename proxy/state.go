@@ -0,0 +1,174 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	sha "crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// stateKeyPrefix prefixes the keys of the issued states in the store
+const stateKeyPrefix = "state:"
+
+// maxIssuedStates bounds the number of states kept in memory, the oldest being forgotten past it
+const maxIssuedStates = 100000
+
+var (
+	// errStateMissing indicates the callback does not follow an authorization issued to the client
+	errStateMissing = errors.New("no authorization request issued for the state")
+	// errStateMismatch indicates the state of the callback is not the one issued to the client
+	errStateMismatch = errors.New("the state does not match the authorization request")
+	// errStateExpired indicates the authorization request is too old
+	errStateExpired = errors.New("the authorization request has expired")
+	// errStateReused indicates the state has already been used by a callback
+	errStateReused = errors.New("the state has already been used")
+)
+
+// issuedStates keeps the states of the authorizations in progress when no store is used, so that each is
+// accepted once by the callback
+type issuedStates struct {
+	sync.Mutex
+	expiries map[string]time.Time
+	// issued are the states in the order of their expiry, all of them having the same lifetime
+	issued []issuedState
+}
+
+type issuedState struct {
+	key       string
+	expiresAt time.Time
+}
+
+func newIssuedStates() *issuedStates {
+	return &issuedStates{expiries: make(map[string]time.Time)}
+}
+
+// add records a state, forgetting the expired ones and the oldest past the maximum
+func (s *issuedStates) add(key string, expiresAt time.Time) {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	expired := 0
+	for ; expired < len(s.issued); expired++ {
+		if !now.After(s.issued[expired].expiresAt) && len(s.issued)-expired < maxIssuedStates {
+			break
+		}
+		delete(s.expiries, s.issued[expired].key)
+	}
+	s.issued = append(s.issued[expired:], issuedState{key: key, expiresAt: expiresAt})
+	s.expiries[key] = expiresAt
+}
+
+// remove forgets a state, indicating whether it was recorded
+func (s *issuedStates) remove(key string) bool {
+	s.Lock()
+	defer s.Unlock()
+	expiresAt, found := s.expiries[key]
+	if !found {
+		return false
+	}
+	delete(s.expiries, key)
+	// the states are ordered by expiry: the state is among those expiring at the same time
+	first := sort.Search(len(s.issued), func(i int) bool { return !s.issued[i].expiresAt.Before(expiresAt) })
+	for i := first; i < len(s.issued); i++ {
+		if s.issued[i].key == key {
+			s.issued = append(s.issued[:i], s.issued[i+1:]...)
+			break
+		}
+	}
+
+	return true
+}
+
+// stateKey is the key of an issued state, which is not kept in clear
+func stateKey(state string) string {
+	hash := sha.Sum256([]byte(state))
+	return stateKeyPrefix + base64.RawStdEncoding.EncodeToString(hash[:])
+}
+
+// issueState creates the state of an authorization request, recorded until its callback. The client gets it
+// in an encrypted cookie, with its expiry.
+func (r *oauthProxy) issueState(w http.ResponseWriter, req *http.Request, prefix string) (string, error) {
+	state := prefix + uuid.NewV4().String()
+	expiresAt := time.Now().Add(r.config.StateExpiration)
+	value, err := encodeText(state+"|"+strconv.FormatInt(expiresAt.Unix(), 10), r.config.EncryptionKey)
+	if err != nil {
+		return "", err
+	}
+	if r.useStore() {
+		if err = r.store.Set(stateKey(state), strconv.FormatInt(expiresAt.Unix(), 10), r.config.StateExpiration); err != nil {
+			return "", err
+		}
+	} else {
+		r.states.add(stateKey(state), expiresAt)
+	}
+	r.dropCallbackCookie(w, req.Host, requestStateCookie, value, r.config.StateExpiration)
+
+	return state, nil
+}
+
+// consumeState checks the callback follows an authorization request issued to the client, and that its
+// state is used once only
+func (r *oauthProxy) consumeState(w http.ResponseWriter, req *http.Request) error {
+	state := req.URL.Query().Get("state")
+	cookie, _ := req.Cookie(requestStateCookie)
+	if state == "" || cookie == nil {
+		return errStateMissing
+	}
+	r.clearStateCookie(req, w)
+
+	decoded, err := decodeText(cookie.Value, r.config.EncryptionKey)
+	if err != nil {
+		return errStateMissing
+	}
+	items := strings.SplitN(decoded, "|", 2)
+	if len(items) != 2 || items[0] != state {
+		return errStateMismatch
+	}
+	expiresAt, err := strconv.ParseInt(items[1], 10, 64)
+	if err != nil {
+		return errStateMismatch
+	}
+
+	key := stateKey(state)
+	found := false
+	if r.useStore() {
+		// the state is taken at once, so that concurrent callbacks can't both use it
+		value, err := r.store.GetAndDelete(key)
+		if err != nil {
+			return err
+		}
+		found = value != ""
+	} else {
+		found = r.states.remove(key)
+	}
+	switch {
+	case time.Now().Unix() > expiresAt:
+		return errStateExpired
+	case !found:
+		return errStateReused
+	}
+
+	return nil
+}
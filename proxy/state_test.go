@@ -0,0 +1,163 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStrictState(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.EnableStrictState = true
+	cfg.EncryptionKey = testKey
+	cfg.StateExpiration = time.Minute
+	p := newFakeProxy(cfg)
+	defer func() {
+		p.idp.Close()
+		p.proxy.server.Close()
+	}()
+
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	client := &http.Client{Jar: jar, CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	get := func(client *http.Client, location string, cookies ...*http.Cookie) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, location, nil)
+		require.NoError(t, err)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		return resp
+	}
+	findCookie := func(resp *http.Response, name string) *http.Cookie {
+		for _, cookie := range resp.Cookies() {
+			if cookie.Name == name {
+				return cookie
+			}
+		}
+		return nil
+	}
+
+	// the authorization issues its own state, whatever the client asks for
+	resp := get(client, p.getServiceURL()+cfg.WithOAuthURI(authorizationURL)+"?state=chosen")
+	require.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+	authURL, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	assert.NotEqual(t, "chosen", authURL.Query().Get("state"))
+	stateCookie := findCookie(resp, requestStateCookie)
+	require.NotNil(t, stateCookie)
+	assert.NotContains(t, stateCookie.Value, authURL.Query().Get("state"))
+
+	resp = get(client, authURL.String())
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	callback := resp.Header.Get("Location")
+
+	resp = get(client, callback)
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+	assert.NotNil(t, findCookie(resp, cfg.CookieAccessName))
+
+	// the state is used once, even with the cookie it was issued with
+	assert.Equal(t, http.StatusForbidden, get(client, callback).StatusCode)
+	assert.Equal(t, http.StatusForbidden, get(&http.Client{}, callback, stateCookie).StatusCode)
+
+	// a callback without any authorization request of the client is rejected (login CSRF)
+	resp = get(client, p.getServiceURL()+cfg.WithOAuthURI(authorizationURL))
+	require.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+	authURL, err = url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	resp = get(client, authURL.String())
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.Equal(t, http.StatusForbidden, get(&http.Client{}, resp.Header.Get("Location")).StatusCode)
+}
+
+func TestConsumeState(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.EnableStrictState = true
+	cfg.EncryptionKey = testKey
+	cfg.StateExpiration = time.Minute
+	p := newFakeProxy(cfg)
+	defer func() {
+		p.idp.Close()
+		p.proxy.server.Close()
+	}()
+
+	callback := func(state string, cookie string) error {
+		req := httptest.NewRequest(http.MethodGet, "/oauth/callback?state="+url.QueryEscape(state), nil)
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: requestStateCookie, Value: cookie})
+		}
+		return p.proxy.consumeState(httptest.NewRecorder(), req)
+	}
+	issued := httptest.NewRecorder()
+	state, err := p.proxy.issueState(issued, httptest.NewRequest(http.MethodGet, "/oauth/authorize", nil), silentStatePrefix)
+	require.NoError(t, err)
+	assert.Contains(t, state, silentStatePrefix)
+	cookie := issued.Result().Cookies()[0].Value
+
+	assert.Equal(t, errStateMissing, callback(state, ""))
+	assert.Equal(t, errStateMissing, callback(state, "garbage"))
+	assert.Equal(t, errStateMismatch, callback("other", cookie))
+	assert.NoError(t, callback(state, cookie))
+	assert.Equal(t, errStateReused, callback(state, cookie))
+
+	expired, err := encodeText("old|"+strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10), testKey)
+	require.NoError(t, err)
+	assert.Equal(t, errStateExpired, callback("old", expired))
+}
+
+func TestIssuedStatesExpiry(t *testing.T) {
+	states := newIssuedStates()
+	now := time.Now()
+	states.add("expired-1", now.Add(-time.Minute))
+	states.add("expired-2", now.Add(-time.Second))
+	states.add("used", now.Add(time.Minute))
+	assert.True(t, states.remove("used"))
+	states.add("issued", now.Add(time.Minute))
+
+	assert.Len(t, states.expiries, 1)
+	assert.Len(t, states.issued, 1)
+	assert.False(t, states.remove("expired-1"))
+	assert.True(t, states.remove("issued"))
+	assert.Empty(t, states.issued)
+}
+
+func TestIssuedStatesLimit(t *testing.T) {
+	states := newIssuedStates()
+	expiresAt := time.Now().Add(time.Minute)
+	for i := 0; i < maxIssuedStates+10; i++ {
+		states.add(strconv.Itoa(i), expiresAt)
+	}
+
+	assert.Len(t, states.expiries, maxIssuedStates)
+	assert.Len(t, states.issued, maxIssuedStates)
+	assert.False(t, states.remove("9"), "the oldest states are forgotten")
+	assert.True(t, states.remove("10"))
+	assert.True(t, states.remove(strconv.Itoa(maxIssuedStates+9)))
+	assert.Len(t, states.issued, maxIssuedStates-2)
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// staticIndex is the document served for the directories, and as the fallback of single page apps
+const staticIndex = "index.html"
+
+// staticFilesMiddleware serves the files of a static resource from its local directory, in place of an upstream
+func (r *oauthProxy) staticFilesMiddleware(resource *Resource) func(http.Handler) http.Handler {
+	root := http.Dir(resource.StaticDirectory)
+	var cacheControl string
+	if resource.StaticMaxAge > 0 {
+		// the files are served to authenticated users only, and not kept by shared caches
+		cacheControl = fmt.Sprintf("private, max-age=%d", int(resource.StaticMaxAge.Seconds()))
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			next.ServeHTTP(w, req)
+
+			if scope, ok := req.Context().Value(contextScopeName).(*RequestScope); ok && scope.AccessDenied {
+				return
+			}
+			if req.Method != http.MethodGet && req.Method != http.MethodHead {
+				w.Header().Set("Allow", "GET, HEAD")
+				methodNotAllowedHandler(w, req)
+				return
+			}
+
+			name := req.URL.Path
+			if resource.StripBasePath != "" {
+				name = strings.TrimPrefix(name, resource.StripBasePath)
+			}
+			name = path.Clean("/" + name)
+
+			file, info, err := openStaticFile(root, name)
+			if os.IsNotExist(err) && resource.StaticFallback && path.Ext(name) == "" {
+				// the routes of a single page app are resolved by the app
+				name = "/" + staticIndex
+				file, info, err = openStaticFile(root, name)
+			}
+			switch {
+			case os.IsNotExist(err), os.IsPermission(err):
+				methodNotFoundHandler(w, req)
+				return
+			case err != nil:
+				r.errorResponse(w, req, "unable to serve the static file", http.StatusInternalServerError, err)
+				return
+			}
+			defer file.Close()
+
+			if path.Base(info.Name()) == staticIndex {
+				// the documents of the app are always revalidated, for the app to pick its latest assets
+				w.Header().Set("Cache-Control", "no-cache")
+			} else if cacheControl != "" {
+				w.Header().Set("Cache-Control", cacheControl)
+			}
+			r.log.Debug("serving static file", zap.String("resource", resource.URL), zap.String("file", name))
+			http.ServeContent(w, req, info.Name(), info.ModTime(), file)
+		})
+	}
+}
+
+// openStaticFile opens a file of a static directory, or the index of a directory. The directories
+// are not listed.
+func openStaticFile(root http.FileSystem, name string) (http.File, os.FileInfo, error) {
+	file, err := root.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, nil, err
+	}
+	if !info.IsDir() {
+		return file, info, nil
+	}
+	_ = file.Close()
+
+	return openStaticFile(root, path.Join(name, staticIndex))
+}
+
+// isStaticFilesValid checks the settings of a static resource
+func isStaticFilesValid(resource *Resource) error {
+	if resource.StaticDirectory == "" {
+		if resource.StaticFallback || resource.StaticMaxAge != 0 {
+			return fmt.Errorf("the resource %s has static file settings, but no static-directory", resource.URL)
+		}
+		return nil
+	}
+	if info, err := os.Stat(resource.StaticDirectory); err != nil || !info.IsDir() {
+		return fmt.Errorf("the static directory %s of resource %s is not a directory", resource.StaticDirectory, resource.URL)
+	}
+	if resource.Upstream != "" || resource.UpstreamClaim != "" || resource.UpstreamHeader != "" || len(resource.HedgeUpstreams) > 0 {
+		return fmt.Errorf("the resource %s serves static files, and cannot have an upstream", resource.URL)
+	}
+	if resource.Coalesce || resource.AWSSigV4Service != "" {
+		return fmt.Errorf("the resource %s serves static files, and cannot coalesce or sign upstream requests", resource.URL)
+	}
+	if resource.StaticMaxAge < 0 {
+		return fmt.Errorf("the static-max-age of resource %s must be a positive duration", resource.URL)
+	}
+
+	return nil
+}
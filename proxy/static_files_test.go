@@ -0,0 +1,123 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newStaticDirectory(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "static")
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "assets"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte("<html>app</html>"), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "assets", "app.js"), []byte("console.log('app')"), 0600))
+
+	return dir
+}
+
+func TestStaticFiles(t *testing.T) {
+	dir := newStaticDirectory(t)
+	defer os.RemoveAll(dir)
+
+	cfg := newFakeKeycloakConfig()
+	cfg.Resources = []*Resource{
+		{
+			URL:             "/app/*",
+			Methods:         allHTTPMethods,
+			StaticDirectory: dir,
+			StripBasePath:   "/app",
+			StaticFallback:  true,
+			StaticMaxAge:    time.Hour,
+		},
+		{
+			URL:             "/docs/*",
+			Methods:         allHTTPMethods,
+			StaticDirectory: dir,
+			StripBasePath:   "/docs",
+		},
+	}
+	requests := []fakeRequest{
+		{
+			URI:          "/app/",
+			Redirects:    false,
+			ExpectedCode: http.StatusUnauthorized,
+		},
+		{
+			URI:                     "/app/",
+			HasToken:                true,
+			ExpectedCode:            http.StatusOK,
+			ExpectedContentContains: "<html>app</html>",
+			ExpectedHeaders:         map[string]string{"Cache-Control": "no-cache", "Content-Type": "text/html; charset=utf-8"},
+		},
+		{
+			URI:                     "/app/assets/app.js",
+			HasToken:                true,
+			ExpectedCode:            http.StatusOK,
+			ExpectedContentContains: "console.log('app')",
+			ExpectedHeaders:         map[string]string{"Cache-Control": "private, max-age=3600"},
+		},
+		{
+			// the routes of the app fall back to its index
+			URI:                     "/app/settings/profile",
+			HasToken:                true,
+			ExpectedCode:            http.StatusOK,
+			ExpectedContentContains: "<html>app</html>",
+		},
+		{
+			URI:          "/app/assets/missing.js",
+			HasToken:     true,
+			ExpectedCode: http.StatusNotFound,
+		},
+		{
+			URI:          "/app/assets/app.js",
+			Method:       http.MethodPost,
+			HasToken:     true,
+			ExpectedCode: http.StatusMethodNotAllowed,
+		},
+		{
+			URI:          "/docs/settings",
+			HasToken:     true,
+			ExpectedCode: http.StatusNotFound,
+		},
+		{
+			// directories are not listed
+			URI:          "/docs/assets/",
+			HasToken:     true,
+			ExpectedCode: http.StatusNotFound,
+		},
+	}
+	newFakeProxy(cfg).RunTests(t, requests)
+}
+
+func TestStaticFilesValid(t *testing.T) {
+	dir := newStaticDirectory(t)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, (&Resource{URL: "/*", StaticDirectory: dir, StaticFallback: true}).valid())
+	assert.Error(t, (&Resource{URL: "/*", StaticDirectory: filepath.Join(dir, "index.html")}).valid())
+	assert.Error(t, (&Resource{URL: "/*", StaticDirectory: dir, Upstream: "http://127.0.0.1"}).valid())
+	assert.Error(t, (&Resource{URL: "/*", StaticFallback: true}).valid())
+	assert.Error(t, (&Resource{URL: "/*", StaticDirectory: dir, StaticMaxAge: -time.Second}).valid())
+}
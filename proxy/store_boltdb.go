@@ -0,0 +1,251 @@
+//+build !nostores
+
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	dbName = "keycloak"
+	// dbExpiries is the bucket of the expiries of the keys set with a ttl
+	dbExpiries = "keycloak-expiries"
+	// boltdbPurgeInterval is the minimum interval between the purges of the expired keys
+	boltdbPurgeInterval = time.Minute
+)
+
+var (
+	// ErrNoBoltdbBucket means the bucket does not exist
+	ErrNoBoltdbBucket = errors.New("the boltdb bucket does not exists")
+)
+
+// A local file store used to hold the refresh tokens
+type boltdbStore struct {
+	client *bolt.DB
+	// purgedAt is the time of the last purge of the expired keys, in unix nanoseconds
+	purgedAt int64
+}
+
+func newBoltDBStore(location *url.URL) (storage, error) {
+	// step: drop the initial slash
+	path := strings.TrimPrefix(location.Path, "/")
+	db, err := bolt.Open(path, 0600, &bolt.Options{
+		Timeout: 10 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// step: create the buckets
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, e := tx.CreateBucketIfNotExists([]byte(dbName)); e != nil {
+			return e
+		}
+		_, e := tx.CreateBucketIfNotExists([]byte(dbExpiries))
+		return e
+	})
+
+	return &boltdbStore{
+		client: db,
+	}, err
+}
+
+// Set adds a token to the store, expiring after a ttl unless zero
+func (r *boltdbStore) Set(key, value string, ttl time.Duration) error {
+	err := r.client.Update(func(tx *bolt.Tx) error {
+		bucket, expiries := tx.Bucket([]byte(dbName)), tx.Bucket([]byte(dbExpiries))
+		if bucket == nil || expiries == nil {
+			return ErrNoBoltdbBucket
+		}
+		if ttl > 0 {
+			expiresAt := strconv.FormatInt(time.Now().Add(ttl).UnixNano(), 10)
+			if err := expiries.Put([]byte(key), []byte(expiresAt)); err != nil {
+				return err
+			}
+		} else if err := expiries.Delete([]byte(key)); err != nil {
+			return err
+		}
+		return bucket.Put([]byte(key), []byte(value))
+	})
+	if err != nil {
+		return err
+	}
+
+	return r.purgeExpired()
+}
+
+// SetIfAbsent sets a key unless it is already set, expiring after a ttl unless zero
+func (r *boltdbStore) SetIfAbsent(key, value string, ttl time.Duration) (bool, error) {
+	var set bool
+	err := r.client.Update(func(tx *bolt.Tx) error {
+		bucket, expiries := tx.Bucket([]byte(dbName)), tx.Bucket([]byte(dbExpiries))
+		if bucket == nil || expiries == nil {
+			return ErrNoBoltdbBucket
+		}
+		if bucket.Get([]byte(key)) != nil && !isExpired(tx, []byte(key), time.Now()) {
+			return nil
+		}
+		if ttl > 0 {
+			expiresAt := strconv.FormatInt(time.Now().Add(ttl).UnixNano(), 10)
+			if err := expiries.Put([]byte(key), []byte(expiresAt)); err != nil {
+				return err
+			}
+		} else if err := expiries.Delete([]byte(key)); err != nil {
+			return err
+		}
+		set = true
+		return bucket.Put([]byte(key), []byte(value))
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return set, r.purgeExpired()
+}
+
+// Get retrieves a token from the store
+func (r *boltdbStore) Get(key string) (string, error) {
+	var value string
+	err := r.client.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(dbName))
+		if bucket == nil {
+			return ErrNoBoltdbBucket
+		}
+		if !isExpired(tx, []byte(key), time.Now()) {
+			value = string(bucket.Get([]byte(key)))
+		}
+		return nil
+	})
+
+	return value, err
+}
+
+// GetAndDelete retrieves a key and removes it from the bucket in the same transaction
+func (r *boltdbStore) GetAndDelete(key string) (string, error) {
+	var value string
+	err := r.client.Update(func(tx *bolt.Tx) error {
+		bucket, expiries := tx.Bucket([]byte(dbName)), tx.Bucket([]byte(dbExpiries))
+		if bucket == nil || expiries == nil {
+			return ErrNoBoltdbBucket
+		}
+		if !isExpired(tx, []byte(key), time.Now()) {
+			value = string(bucket.Get([]byte(key)))
+		}
+		if err := expiries.Delete([]byte(key)); err != nil {
+			return err
+		}
+		return bucket.Delete([]byte(key))
+	})
+
+	return value, err
+}
+
+// Delete removes the key from the bucket
+func (r *boltdbStore) Delete(key string) error {
+	return r.client.Update(func(tx *bolt.Tx) error {
+		bucket, expiries := tx.Bucket([]byte(dbName)), tx.Bucket([]byte(dbExpiries))
+		if bucket == nil || expiries == nil {
+			return ErrNoBoltdbBucket
+		}
+		if err := expiries.Delete([]byte(key)); err != nil {
+			return err
+		}
+		return bucket.Delete([]byte(key))
+	})
+}
+
+// List retrieves the keys and values stored under a key prefix
+func (r *boltdbStore) List(prefix string) (map[string]string, error) {
+	values := make(map[string]string)
+	now := time.Now()
+	err := r.client.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(dbName))
+		if bucket == nil {
+			return ErrNoBoltdbBucket
+		}
+		c := bucket.Cursor()
+		for k, v := c.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, v = c.Next() {
+			if !isExpired(tx, k, now) {
+				values[string(k)] = string(v)
+			}
+		}
+		return nil
+	})
+
+	return values, err
+}
+
+// purgeExpired removes the expired keys from the store, at most once per purge interval
+func (r *boltdbStore) purgeExpired() error {
+	now := time.Now()
+	purgedAt := atomic.LoadInt64(&r.purgedAt)
+	if now.UnixNano()-purgedAt < int64(boltdbPurgeInterval) || !atomic.CompareAndSwapInt64(&r.purgedAt, purgedAt, now.UnixNano()) {
+		return nil
+	}
+
+	return r.client.Update(func(tx *bolt.Tx) error {
+		bucket, expiries := tx.Bucket([]byte(dbName)), tx.Bucket([]byte(dbExpiries))
+		if bucket == nil || expiries == nil {
+			return ErrNoBoltdbBucket
+		}
+		var expired [][]byte
+		c := expiries.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			if isExpired(tx, k, now) {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+		}
+		for _, k := range expired {
+			if err := expiries.Delete(k); err != nil {
+				return err
+			}
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// isExpired checks if a key set with a ttl has expired
+func isExpired(tx *bolt.Tx, key []byte, now time.Time) bool {
+	expiries := tx.Bucket([]byte(dbExpiries))
+	if expiries == nil {
+		return false
+	}
+	value := expiries.Get(key)
+	if value == nil {
+		return false
+	}
+	expiresAt, err := strconv.ParseInt(string(value), 10, 64)
+
+	return err == nil && now.UnixNano() > expiresAt
+}
+
+// Close closes of any open resources
+func (r *boltdbStore) Close() error {
+	return r.client.Close()
+}
@@ -0,0 +1,191 @@
+/*
+Copyright 2017 All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeBoltDBStore struct {
+	storedb *os.File
+	store   *boltdbStore
+}
+
+func (f *fakeBoltDBStore) close() {
+	if f.storedb != nil {
+		f.storedb.Close()
+		os.Remove(f.storedb.Name())
+	}
+}
+
+func newTestBoldDB(t *testing.T) *fakeBoltDBStore {
+	tmpfile, err := ioutil.TempFile("/tmp", "keycloak-gatekeeper")
+	if err != nil {
+		t.Fatalf("unable to create temporary file, error: %s", err)
+	}
+	u, err := url.Parse(fmt.Sprintf("file:///%s", tmpfile.Name()))
+	if err != nil {
+		t.Fatalf("unable to parse file url, error: %s", err)
+	}
+	s, err := newBoltDBStore(u)
+	if err != nil {
+		tmpfile.Close()
+		os.Remove(tmpfile.Name())
+		t.Fatalf("unable to test boltdb, error: %s", err)
+	}
+	return &fakeBoltDBStore{tmpfile, s.(*boltdbStore)}
+}
+
+func TestNewBoltDBStore(t *testing.T) {
+	s := newTestBoldDB(t)
+	defer s.close()
+	assert.NotNil(t, s)
+}
+
+func TestBoltSet(t *testing.T) {
+	s := newTestBoldDB(t)
+	defer s.close()
+	err := s.store.Set("test", "value", 0)
+	assert.NoError(t, err)
+}
+
+func TestBoltGet(t *testing.T) {
+	s := newTestBoldDB(t)
+	defer s.close()
+	v, err := s.store.Get("test")
+	assert.NoError(t, err)
+	assert.Empty(t, v)
+	err = s.store.Set("test", "value", 0)
+	assert.NoError(t, err)
+	v, err = s.store.Get("test")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", v)
+}
+
+func TestBoltSetIfAbsent(t *testing.T) {
+	s := newTestBoldDB(t)
+	defer s.close()
+	set, err := s.store.SetIfAbsent("test", "value", 50*time.Millisecond)
+	assert.NoError(t, err)
+	assert.True(t, set)
+	set, err = s.store.SetIfAbsent("test", "other", 0)
+	assert.NoError(t, err)
+	assert.False(t, set)
+	v, err := s.store.Get("test")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", v)
+
+	// an expired key is set again
+	time.Sleep(100 * time.Millisecond)
+	set, err = s.store.SetIfAbsent("test", "other", 0)
+	assert.NoError(t, err)
+	assert.True(t, set)
+	v, err = s.store.Get("test")
+	assert.NoError(t, err)
+	assert.Equal(t, "other", v)
+}
+
+func TestBoltGetAndDelete(t *testing.T) {
+	s := newTestBoldDB(t)
+	defer s.close()
+	assert.NoError(t, s.store.Set("test", "value", time.Hour))
+	v, err := s.store.GetAndDelete("test")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", v)
+	v, err = s.store.GetAndDelete("test")
+	assert.NoError(t, err)
+	assert.Empty(t, v)
+
+	// an expired key is not returned
+	assert.NoError(t, s.store.Set("test", "value", time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+	v, err = s.store.GetAndDelete("test")
+	assert.NoError(t, err)
+	assert.Empty(t, v)
+}
+
+func TestBoltDelete(t *testing.T) {
+	keyname := "test"
+	value := "value"
+	s := newTestBoldDB(t)
+	defer s.close()
+	err := s.store.Set(keyname, value, 0)
+	assert.NoError(t, err)
+	v, err := s.store.Get(keyname)
+	assert.NoError(t, err)
+	assert.Equal(t, value, v)
+	err = s.store.Delete(keyname)
+	assert.NoError(t, err)
+	v, err = s.store.Get(keyname)
+	assert.NoError(t, err)
+	assert.Empty(t, v)
+}
+
+func TestBoldClose(t *testing.T) {
+	s := newTestBoldDB(t)
+	defer s.close()
+	err := s.store.Close()
+	assert.NoError(t, err)
+}
+
+func TestBoltList(t *testing.T) {
+	s := newTestBoldDB(t)
+	defer s.close()
+	assert.NoError(t, s.store.Set("session:1", "one", 0))
+	assert.NoError(t, s.store.Set("session:2", "two", 0))
+	assert.NoError(t, s.store.Set("other", "value", 0))
+	values, err := s.store.List("session:")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"session:1": "one", "session:2": "two"}, values)
+}
+
+func TestBoltExpiry(t *testing.T) {
+	s := newTestBoldDB(t)
+	defer s.close()
+	assert.NoError(t, s.store.Set("session:1", "one", 50*time.Millisecond))
+	assert.NoError(t, s.store.Set("session:2", "two", time.Hour))
+	assert.NoError(t, s.store.Set("session:3", "three", 0))
+	v, err := s.store.Get("session:1")
+	assert.NoError(t, err)
+	assert.Equal(t, "one", v)
+
+	time.Sleep(100 * time.Millisecond)
+	v, err = s.store.Get("session:1")
+	assert.NoError(t, err)
+	assert.Empty(t, v)
+	values, err := s.store.List("session:")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"session:2": "two", "session:3": "three"}, values)
+
+	// a key set again without ttl no longer expires
+	assert.NoError(t, s.store.Set("session:2", "two", 0))
+	s.store.purgedAt = 0
+	assert.NoError(t, s.store.purgeExpired())
+	assert.NoError(t, s.store.client.View(func(tx *bolt.Tx) error {
+		assert.Nil(t, tx.Bucket([]byte(dbName)).Get([]byte("session:1")), "the expired keys are purged")
+		assert.Equal(t, 0, tx.Bucket([]byte(dbExpiries)).Stats().KeyN)
+		return nil
+	}))
+}
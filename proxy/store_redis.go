@@ -0,0 +1,147 @@
+//+build !nostores
+
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	redis "gopkg.in/redis.v4"
+)
+
+type redisStore struct {
+	client *redis.Client
+}
+
+// newRedisStore creates a new redis store, the connections of which are opened by a dial function if any
+func newRedisStore(location *url.URL, dial dialContextFunc) (storage, error) {
+	// step: get any password
+	password := ""
+	if location.User != nil {
+		password, _ = location.User.Password()
+	}
+
+	// step: the database is selected by the path, e.g. redis://127.0.0.1:6379/2
+	var db int
+	if path := strings.Trim(location.Path, "/"); path != "" {
+		var err error
+		if db, err = strconv.Atoi(path); err != nil {
+			return nil, fmt.Errorf("invalid redis database %q in the store url", path)
+		}
+	}
+
+	// step: parse the url notation
+	options := &redis.Options{
+		Addr:     location.Host,
+		DB:       db,
+		Password: password,
+	}
+	if dial != nil {
+		options.Dialer = func() (net.Conn, error) {
+			return dial(context.Background(), "tcp", location.Host)
+		}
+	}
+	client := redis.NewClient(options)
+
+	return redisStore{
+		client: client,
+	}, nil
+}
+
+// Set adds a token to the store, expiring after a ttl unless zero
+func (r redisStore) Set(key, value string, ttl time.Duration) error {
+	if err := r.client.Set(key, value, ttl); err.Err() != nil {
+		return err.Err()
+	}
+
+	return nil
+}
+
+// SetIfAbsent sets a key unless it is already set, expiring after a ttl unless zero
+func (r redisStore) SetIfAbsent(key, value string, ttl time.Duration) (bool, error) {
+	return r.client.SetNX(key, value, ttl).Result()
+}
+
+// Get retrieves a token from the store, or an empty value when the key is not found
+func (r redisStore) Get(key string) (string, error) {
+	value, err := r.client.Get(key).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+
+	return value, err
+}
+
+// GetAndDelete retrieves a key and removes it in a transaction, or returns an empty value when the key is not found
+func (r redisStore) GetAndDelete(key string) (string, error) {
+	var get *redis.StringCmd
+	err := r.client.Watch(func(tx *redis.Tx) error {
+		_, err := tx.MultiExec(func() error {
+			get = tx.Get(key)
+			tx.Del(key)
+			return nil
+		})
+		return err
+	})
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return get.Val(), nil
+}
+
+// Delete remove the key
+func (r redisStore) Delete(key string) error {
+	return r.client.Del(key).Err()
+}
+
+// List retrieves the keys and values stored under a key prefix
+func (r redisStore) List(prefix string) (map[string]string, error) {
+	values := make(map[string]string)
+	iter := r.client.Scan(0, prefix+"*", 100).Iterator()
+	for iter.Next() {
+		result := r.client.Get(iter.Val())
+		if result.Err() == redis.Nil {
+			// the key expired or has been removed meanwhile
+			continue
+		}
+		if result.Err() != nil {
+			return nil, result.Err()
+		}
+		values[iter.Val()] = result.Val()
+	}
+
+	return values, iter.Err()
+}
+
+// Close closes of any open resources
+func (r redisStore) Close() error {
+	if r.client != nil {
+		return r.client.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,212 @@
+//+build !nostores
+
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/coreos/go-oidc/jose"
+)
+
+const (
+	// sessionKeyPrefix prefixes the keys of the session records in the store
+	sessionKeyPrefix = "session:"
+	// revocationKeyPrefix prefixes the keys of the revocation markers of the subjects in the store
+	revocationKeyPrefix = "revoked:"
+	// sessionRevocationKeyPrefix prefixes the keys of the revocation markers of the sessions in the store
+	sessionRevocationKeyPrefix = "revoked-session:"
+	// logoutTokenKeyPrefix prefixes the keys of the ids of the logout tokens already accepted in the store
+	logoutTokenKeyPrefix = "logout-token:"
+)
+
+// StoreSession records the session of a user in the store, next to its refresh token, for the lifetime of the
+// refresh token
+func (r *oauthProxy) StoreSession(user *userContext, ip string, ttl time.Duration) error {
+	client, _, _ := user.claims.StringClaim(claimAuthorizedParty)
+
+	return r.putStoredSession(&storedSession{
+		ID:        sessionID(user),
+		Subject:   user.id,
+		Email:     user.email,
+		Client:    client,
+		IP:        ip,
+		CreatedAt: time.Now(),
+		ExpiresAt: user.expiresAt,
+		TokenKey:  getHashKey(&user.token),
+	}, ttl)
+}
+
+// RenewStoredSession updates the session record with the refreshed access token, for the lifetime of the
+// refresh token
+func (r *oauthProxy) RenewStoredSession(id string, token jose.JWT, ttl time.Duration) error {
+	session, err := r.getStoredSession(id)
+	if err != nil || session == nil {
+		return err
+	}
+	user, err := r.identities.extractIdentity(token)
+	if err != nil {
+		return err
+	}
+	if renewed := sessionID(user); renewed != id {
+		// sessions without a session claim are identified by their access token
+		if err = r.store.Delete(sessionKeyPrefix + id); err != nil {
+			return err
+		}
+		session.ID = renewed
+	}
+	session.ExpiresAt = user.expiresAt
+	session.TokenKey = getHashKey(&token)
+
+	return r.putStoredSession(session, ttl)
+}
+
+// DeleteStoredSession removes a session record from the store
+func (r *oauthProxy) DeleteStoredSession(id string) error {
+	return r.store.Delete(sessionKeyPrefix + id)
+}
+
+// ListStoredSessions retrieves all the session records from the store
+func (r *oauthProxy) ListStoredSessions() ([]*storedSession, error) {
+	values, err := r.store.List(sessionKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]*storedSession, 0, len(values))
+	for _, value := range values {
+		session := new(storedSession)
+		if err := json.Unmarshal([]byte(value), session); err != nil {
+			r.log.Warn("skipping an invalid session record in the store")
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, nil
+}
+
+// RevokeSubjectSessions removes the sessions of a subject created before a revocation time from the store, and
+// records a revocation marker so the tokens issued earlier to the subject are rejected. The marker is kept for the
+// fallback lifetime of the sessions, the access token duration, and is not moved back by an earlier revocation.
+func (r *oauthProxy) RevokeSubjectSessions(subject string, revokedAt time.Time) (int, error) {
+	current, err := r.GetSubjectRevocation(subject)
+	if err != nil {
+		return 0, err
+	}
+	if revokedAt.After(current) {
+		if err := r.store.Set(revocationKeyPrefix+subject, strconv.FormatInt(revokedAt.Unix(), 10), r.config.AccessTokenDuration); err != nil {
+			return 0, err
+		}
+	}
+	sessions, err := r.ListStoredSessions()
+	if err != nil {
+		return 0, err
+	}
+	revoked := 0
+	for _, session := range sessions {
+		if session.Subject != subject || !session.CreatedAt.Before(revokedAt) {
+			continue
+		}
+		if session.TokenKey != "" {
+			if err := r.store.Delete(session.TokenKey); err != nil {
+				return revoked, err
+			}
+		}
+		if err := r.DeleteStoredSession(session.ID); err != nil {
+			return revoked, err
+		}
+		revoked++
+	}
+
+	return revoked, nil
+}
+
+// GetSubjectRevocation returns the time before which the tokens of a subject are no longer valid, if any
+func (r *oauthProxy) GetSubjectRevocation(subject string) (time.Time, error) {
+	value, err := r.store.Get(revocationKeyPrefix + subject)
+	if err != nil || value == "" {
+		return time.Time{}, err
+	}
+	revokedAt, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(revokedAt, 0), nil
+}
+
+// RevokeSession removes a session from the store, and records a revocation marker so the tokens issued for the
+// session before the revocation time are rejected. It returns the record of the session, if it was stored.
+func (r *oauthProxy) RevokeSession(id string, revokedAt time.Time) (*storedSession, error) {
+	if err := r.store.Set(sessionRevocationKeyPrefix+id, strconv.FormatInt(revokedAt.Unix(), 10), r.config.AccessTokenDuration); err != nil {
+		return nil, err
+	}
+	session, err := r.getStoredSession(id)
+	if err != nil || session == nil {
+		return nil, err
+	}
+	if session.TokenKey != "" {
+		if err := r.store.Delete(session.TokenKey); err != nil {
+			return nil, err
+		}
+	}
+
+	return session, r.DeleteStoredSession(id)
+}
+
+// UseLogoutToken records the id of a logout token for its lifetime, returning false when it has already been used
+func (r *oauthProxy) UseLogoutToken(id string, ttl time.Duration) (bool, error) {
+	return r.store.SetIfAbsent(logoutTokenKeyPrefix+id, "1", ttl)
+}
+
+// GetSessionRevocation returns the time before which the tokens of a session are no longer valid, if any
+func (r *oauthProxy) GetSessionRevocation(id string) (time.Time, error) {
+	value, err := r.store.Get(sessionRevocationKeyPrefix + id)
+	if err != nil || value == "" {
+		return time.Time{}, err
+	}
+	revokedAt, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(revokedAt, 0), nil
+}
+
+func (r *oauthProxy) getStoredSession(id string) (*storedSession, error) {
+	value, err := r.store.Get(sessionKeyPrefix + id)
+	if err != nil || value == "" {
+		return nil, err
+	}
+	session := new(storedSession)
+	if err := json.Unmarshal([]byte(value), session); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+func (r *oauthProxy) putStoredSession(session *storedSession, ttl time.Duration) error {
+	value, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	return r.store.Set(sessionKeyPrefix+session.ID, string(value), ttl)
+}
@@ -15,7 +15,7 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package main
+package proxy
 
 import (
 	"fmt"
@@ -35,7 +35,7 @@ func (r *Config) isStoreValid() error {
 }
 
 // createStorage creates the store client for use
-func createStorage(location string) (storage, error) {
+func createStorage(location string, dial dialContextFunc) (storage, error) {
 	var store storage
 	var err error
 
@@ -45,7 +45,7 @@ func createStorage(location string) (storage, error) {
 	}
 	switch u.Scheme {
 	case "redis":
-		store, err = newRedisStore(u)
+		store, err = newRedisStore(u, dial)
 	case "boltdb":
 		store, err = newBoltDBStore(u)
 	default:
@@ -62,7 +62,7 @@ func (r *oauthProxy) useStore() bool {
 
 // StoreRefreshToken the token to the store
 func (r *oauthProxy) StoreRefreshToken(token jose.JWT, value string) error {
-	return r.store.Set(getHashKey(&token), value)
+	return r.store.Set(getHashKey(&token), value, 0)
 }
 
 // Get retrieves a token from the store, the key we are using here is the access token
@@ -13,7 +13,7 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package main
+package proxy
 
 import (
 	"os"
@@ -23,13 +23,13 @@ import (
 )
 
 func TestCreateStorageRedis(t *testing.T) {
-	store, err := createStorage("redis://127.0.0.1")
+	store, err := createStorage("redis://127.0.0.1", nil)
 	assert.NotNil(t, store)
 	assert.NoError(t, err)
 }
 
 func TestCreateStorageBoltDB(t *testing.T) {
-	store, err := createStorage("boltdb:////tmp/bolt")
+	store, err := createStorage("boltdb:////tmp/bolt", nil)
 	assert.NotNil(t, store)
 	assert.NoError(t, err)
 	if store != nil {
@@ -38,7 +38,7 @@ func TestCreateStorageBoltDB(t *testing.T) {
 }
 
 func TestCreateStorageFail(t *testing.T) {
-	store, err := createStorage("not_there:///tmp/bolt")
+	store, err := createStorage("not_there:///tmp/bolt", nil)
 	assert.Nil(t, store)
 	assert.Error(t, err)
 }
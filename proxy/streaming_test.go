@@ -0,0 +1,82 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamingResource(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("X-Injected", "upstream")
+		_, _ = w.Write([]byte("{\"first\":true}\n"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-time.After(5 * time.Second):
+		}
+		_, _ = w.Write([]byte("{\"last\":true}\n"))
+	}))
+	defer upstream.Close()
+
+	cfg := newFakeKeycloakConfig()
+	cfg.Headers = map[string]string{"X-Injected": "gatekeeper"}
+	for _, x := range cfg.Resources {
+		if x.URL == fakeTestWhitelistedURL {
+			x.Upstream = upstream.URL
+			x.Streaming = true
+		}
+	}
+	p := newFakeProxy(cfg)
+	assert.Contains(t, p.proxy.upstreams, fakeTestWhitelistedURL)
+
+	resp, err := http.Get(p.getServiceURL() + "/auth_all/white_listed/stream")
+	require.NoError(t, err)
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	// streamed responses skip the response interception
+	assert.Equal(t, "upstream", resp.Header.Get("X-Injected"))
+
+	// the first line is received while the upstream response is still in progress
+	received := make(chan string)
+	reader := bufio.NewReader(resp.Body)
+	go func() {
+		line, _ := reader.ReadString('\n')
+		received <- line
+	}()
+	select {
+	case line := <-received:
+		assert.Equal(t, "{\"first\":true}\n", line)
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the response to be streamed")
+	}
+	close(release)
+
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "{\"last\":true}\n", line)
+}
@@ -0,0 +1,446 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/coreos/go-oidc/jose"
+	"github.com/go-chi/chi"
+	"github.com/urfave/cli"
+	"go.uber.org/zap"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var errACLCasesFailed = errors.New("some cases did not have the expected outcome")
+
+const (
+	aclAllow = "allow"
+	aclDeny  = "deny"
+)
+
+// aclCase is a request checked by the test-acl command
+type aclCase struct {
+	// Name describes the case in the report
+	Name string `json:"name" yaml:"name"`
+	// Method is the method of the request, GET by default
+	Method string `json:"method" yaml:"method"`
+	// Path is the path of the request
+	Path string `json:"path" yaml:"path"`
+	// Host is the host of the request, selecting the realms served on their hostnames
+	Host string `json:"host" yaml:"host"`
+	// Claims are the claims of the access token, the request is anonymous without any
+	Claims map[string]interface{} `json:"claims" yaml:"claims"`
+	// Expect is the expected outcome, allow or deny
+	Expect string `json:"expect" yaml:"expect"`
+}
+
+// label names the case in the report
+func (c aclCase) label() string {
+	if c.Name != "" {
+		return c.Name
+	}
+
+	return c.Method + " " + c.Path
+}
+
+// aclOutcome is the decision of the proxy on a request
+type aclOutcome struct {
+	// realm is the name of the realm serving the request, if any
+	realm string
+	// resource is the url of the resource matched, if any
+	resource string
+	// decision is either allow or deny
+	decision string
+	// reason explains the decision
+	reason string
+}
+
+// aclResult is the outcome of a case, against its expectation
+type aclResult struct {
+	aclCase
+	aclOutcome
+}
+
+// passed checks the outcome is the one expected, if any
+func (r aclResult) passed() bool {
+	return r.Expect == "" || r.Expect == r.decision
+}
+
+// aclMatch records the resource routed to by the router
+type aclMatch struct {
+	resource      *Resource
+	methodAllowed bool
+}
+
+type aclMatchKey struct{}
+
+type aclRealmKey struct{}
+
+// aclTester evaluates the requests against the resources of a configuration, without any openid provider
+// or upstream. The scripts and the open policy agent are not queried.
+type aclTester struct {
+	proxy  *oauthProxy
+	router chi.Router
+	claims []*claimMatcher
+	// realm is the name of the realm tested, if any
+	realm string
+	// hosts and routes select the testers of the realms, by host then by resource, as the realm router does
+	hosts  map[string]*aclTester
+	routes chi.Router
+}
+
+// newTestACLCommand creates the test-acl subcommand, which checks the access to the resources of a configuration
+func newTestACLCommand() cli.Command {
+	return cli.Command{
+		Name:      "test-acl",
+		Usage:     "check a table of requests against the resources of the configuration, exiting 1 when an outcome is not the one expected",
+		UsageText: "keycloak-gatekeeper --config FILE [options] test-acl --cases FILE",
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "cases", Usage: "path to a yaml or json list of requests, with their method, path, claims and expected outcome"},
+		},
+		Action: func(cx *cli.Context) error {
+			config := newDefaultConfig()
+			if configFile := cx.GlobalString("config"); configFile != "" {
+				if err := readConfigFile(configFile, config); err != nil {
+					return printError("unable to read the configuration file: %s, error: %s", configFile, err.Error())
+				}
+			}
+			root := cx
+			for root.Parent() != nil {
+				root = root.Parent()
+			}
+			if err := parseCLIOptions(root, config); err != nil {
+				return printError(err.Error())
+			}
+			if err := config.isValid(); err != nil {
+				return printError(err.Error())
+			}
+
+			if cx.String("cases") == "" {
+				return printError("the cases to check have not been set")
+			}
+			cases, err := readACLCases(cx.String("cases"))
+			if err != nil {
+				return printError("unable to read the cases: %s, error: %s", cx.String("cases"), err.Error())
+			}
+
+			results := newACLTester(config).run(cases)
+			reportACLResults(os.Stdout, results)
+			for _, result := range results {
+				if !result.passed() {
+					return printError(errACLCasesFailed.Error())
+				}
+			}
+
+			return nil
+		},
+	}
+}
+
+// readACLCases reads the cases of the test-acl command
+func readACLCases(filename string) ([]aclCase, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	// yaml is a superset of json
+	var cases []aclCase
+	if err := yaml.Unmarshal(content, &cases); err != nil {
+		return nil, err
+	}
+	for i, c := range cases {
+		if c.Path == "" || !strings.HasPrefix(c.Path, "/") {
+			return nil, fmt.Errorf("the case %q must have an absolute path", c.label())
+		}
+		if c.Expect != "" && c.Expect != aclAllow && c.Expect != aclDeny {
+			return nil, fmt.Errorf("the case %q must expect either %s or %s", c.label(), aclAllow, aclDeny)
+		}
+		cases[i].Method = strings.ToUpper(defaultTo(c.Method, http.MethodGet))
+	}
+
+	return cases, nil
+}
+
+// newACLTester routes the resources of a configuration as the proxy does, dispatching the requests to its realms
+func newACLTester(config *Config) *aclTester {
+	t := newRealmACLTester(config, "")
+	t.hosts = make(map[string]*aclTester)
+	t.routes = chi.NewRouter()
+	for _, realm := range config.Realms {
+		tester := newRealmACLTester(config.realmConfig(realm), realm.Name)
+		for _, host := range realm.Hostnames {
+			t.hosts[strings.ToLower(host)] = tester
+		}
+		// the resources of a virtual host are only served on its hosts
+		routed := make(map[string]bool)
+		for _, x := range config.Resources {
+			if x.Realm == realm.Name && len(realm.Hostnames) == 0 && !routed[x.URL] {
+				t.routes.Handle(x.URL, aclRealmRoute(tester))
+				routed[x.URL] = true
+			}
+		}
+	}
+
+	return t
+}
+
+// newRealmACLTester routes the resources of a proxy or realm
+func newRealmACLTester(config *Config, realm string) *aclTester {
+	profile := getProviderProfile(config.Provider)
+	roleClaims, groupClaims := config.RoleClaims, config.GroupClaims
+	if len(roleClaims) == 0 {
+		roleClaims = profile.roleClaims
+	}
+	if len(groupClaims) == 0 {
+		groupClaims = profile.groupClaims
+	}
+	t := &aclTester{
+		proxy: &oauthProxy{
+			config:     config,
+			log:        zap.NewNop(),
+			profile:    profile,
+			identities: newIdentityMapping(roleClaims, config.ClientRolesClaim, groupClaims),
+		},
+		router: chi.NewRouter(),
+		realm:  realm,
+	}
+	t.claims, _ = newClaimMatchers(config.MatchClaims)
+	sort.Slice(t.claims, func(i, j int) bool { return t.claims[i].claim < t.claims[j].claim })
+
+	resources := config.Resources
+	if config.EnableDefaultDeny && !config.EnableDefaultNotFound {
+		catchAll := false
+		for _, x := range resources {
+			catchAll = catchAll || x.URL == allRoutes
+		}
+		if !catchAll {
+			resources = append(resources, &Resource{URL: allRoutes, Methods: allHTTPMethods})
+		}
+	}
+	for _, x := range resources {
+		if x.Realm != "" {
+			// served by the realm
+			continue
+		}
+		resource := x
+		t.router.Handle(x.URL, aclRoute(resource, false))
+		for _, m := range x.Methods {
+			t.router.Method(m, x.URL, aclRoute(resource, true))
+		}
+	}
+
+	return t
+}
+
+// aclRoute records the resource of a route
+func aclRoute(resource *Resource, methodAllowed bool) http.Handler {
+	return http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+		match := req.Context().Value(aclMatchKey{}).(*aclMatch)
+		match.resource = resource
+		match.methodAllowed = methodAllowed
+	})
+}
+
+// aclRealmRoute records the tester of the realm of a route
+func aclRealmRoute(tester *aclTester) http.Handler {
+	return http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+		*req.Context().Value(aclRealmKey{}).(**aclTester) = tester
+	})
+}
+
+// run evaluates the cases
+func (t *aclTester) run(cases []aclCase) []aclResult {
+	results := make([]aclResult, 0, len(cases))
+	for _, c := range cases {
+		results = append(results, aclResult{aclCase: c, aclOutcome: t.evaluate(c)})
+	}
+
+	return results
+}
+
+// evaluate decides on a request as the realm dispatch, routing, authentication and admission of the proxy do
+func (t *aclTester) evaluate(c aclCase) aclOutcome {
+	req := httptest.NewRequest(c.Method, c.Path, nil)
+	if c.Host != "" {
+		req.Host = c.Host
+	}
+	normalizeURL(req.URL)
+
+	tester := t
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if realm, found := t.hosts[strings.ToLower(host)]; found {
+		tester = realm
+	} else if t.routes != nil {
+		t.routes.ServeHTTP(httptest.NewRecorder(), req.WithContext(context.WithValue(req.Context(), aclRealmKey{}, &tester)))
+	}
+	outcome := tester.decide(c, req)
+	outcome.realm = tester.realm
+
+	return outcome
+}
+
+// decide decides on a request as the routing, authentication and admission of the proxy do
+func (t *aclTester) decide(c aclCase, req *http.Request) aclOutcome {
+	match := &aclMatch{}
+	t.router.ServeHTTP(httptest.NewRecorder(), req.WithContext(context.WithValue(req.Context(), aclMatchKey{}, match)))
+
+	config := t.proxy.config
+	resource := match.resource
+	switch {
+	case resource == nil && config.EnableDefaultNotFound:
+		return aclOutcome{decision: aclDeny, reason: "no resource matched, not found"}
+	case resource == nil:
+		return aclOutcome{decision: aclAllow, reason: "no resource matched, proxied without authentication"}
+	case resource.BlackListed:
+		return aclOutcome{resource: resource.URL, decision: aclDeny, reason: "blacklisted resource"}
+	case !match.methodAllowed:
+		return aclOutcome{resource: resource.URL, decision: aclDeny, reason: "method not allowed"}
+	case resource.WhiteListed:
+		return aclOutcome{resource: resource.URL, decision: aclAllow, reason: "whitelisted resource"}
+	case c.Claims == nil && resource.OptionalAuth:
+		return aclOutcome{resource: resource.URL, decision: aclAllow, reason: "anonymous access"}
+	case c.Claims == nil:
+		return aclOutcome{resource: resource.URL, decision: aclDeny, reason: "authentication required"}
+	}
+
+	user, err := t.identity(c.Claims)
+	if err != nil {
+		return aclOutcome{resource: resource.URL, decision: aclDeny, reason: fmt.Sprintf("invalid claims: %v", err)}
+	}
+	if !hasAccess(resource.Roles, user.roles, !resource.RequireAnyRole, false) {
+		return aclOutcome{resource: resource.URL, decision: aclDeny, reason: "invalid roles, required: " + resource.getRoles()}
+	}
+	if !hasAccess(resource.Groups, user.groups, false, true) {
+		return aclOutcome{resource: resource.URL, decision: aclDeny, reason: "invalid groups, required: " + strings.Join(resource.Groups, ",")}
+	}
+	if !hasAccess(resource.Audiences, user.audiences, false, false) {
+		return aclOutcome{resource: resource.URL, decision: aclDeny, reason: "invalid audience, required: " + strings.Join(resource.Audiences, ",")}
+	}
+	for _, rule := range resource.MethodRequirements {
+		if rule.matches(c.Method) && !rule.admits(user) {
+			return aclOutcome{resource: resource.URL, decision: aclDeny, reason: "invalid roles or groups for the method " + c.Method}
+		}
+	}
+	for _, match := range t.claims {
+		if !t.proxy.checkClaim(user, match, resource.URL) {
+			return aclOutcome{resource: resource.URL, decision: aclDeny, reason: "the claim " + match.claim + " does not match"}
+		}
+	}
+
+	if expression, _ := compileAccessExpression(resource.AccessExpression); expression != nil {
+		allowed, err := expression.allows(req, user, config.ScriptTimeout)
+		if err != nil {
+			return aclOutcome{resource: resource.URL, decision: aclDeny, reason: fmt.Sprintf("the access expression failed: %v", err)}
+		}
+		if !allowed {
+			return aclOutcome{resource: resource.URL, decision: aclDeny, reason: "the access expression does not hold"}
+		}
+	}
+
+	reason := "access permitted"
+	if defaultTo(resource.OPAURL, config.OPAURL) != "" {
+		reason += ", the policy agent is not queried"
+	}
+
+	return aclOutcome{resource: resource.URL, decision: aclAllow, reason: reason}
+}
+
+// identity extracts the user of a set of claims, as from an access token
+func (t *aclTester) identity(claims map[string]interface{}) (*userContext, error) {
+	// the claims decoded from yaml are normalized as decoded from a token
+	encoded, err := json.Marshal(jsonValue(claims))
+	if err != nil {
+		return nil, err
+	}
+	var normalized jose.Claims
+	if err := json.Unmarshal(encoded, &normalized); err != nil {
+		return nil, err
+	}
+	if _, found := normalized[claimSubject]; !found {
+		normalized[claimSubject] = "test-acl"
+	}
+	if _, found := normalized[claimAudience]; !found {
+		normalized[claimAudience] = t.proxy.config.ClientID
+	}
+	token, err := jose.NewJWT(jose.JOSEHeader{"alg": "RS256"}, normalized)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.proxy.identities.extractIdentity(token)
+}
+
+// jsonValue converts the maps decoded from yaml into maps with string keys
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, x := range v {
+			m[fmt.Sprint(key)] = jsonValue(x)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, x := range v {
+			m[key] = jsonValue(x)
+		}
+		return m
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, x := range v {
+			list[i] = jsonValue(x)
+		}
+		return list
+	default:
+		return v
+	}
+}
+
+// reportACLResults writes the outcome of the cases, and a summary
+func reportACLResults(w io.Writer, results []aclResult) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	var failed int
+	for _, result := range results {
+		status := "PASS"
+		if !result.passed() {
+			status = "FAIL"
+			failed++
+		}
+		resource := defaultTo(result.resource, "-")
+		if result.realm != "" {
+			resource = result.realm + ":" + resource
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", status, result.label(), resource, result.decision, result.reason)
+	}
+	_ = tw.Flush()
+	_, _ = fmt.Fprintf(w, "%d cases, %d failed\n", len(results), failed)
+}
@@ -0,0 +1,242 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli"
+)
+
+const fakeACLCases = `
+- name: admins manage the users
+  method: delete
+  path: /admin/users
+  claims:
+    department: finance
+    realm_access:
+      roles: [admin]
+  expect: allow
+- name: users do not manage the users
+  method: DELETE
+  path: /admin/users
+  claims:
+    realm_access:
+      roles: [user]
+  expect: deny
+- path: /admin/users
+  expect: deny
+- path: /public/logo.png
+  expect: allow
+- method: POST
+  path: /public/logo.png
+  expect: deny
+- path: /finance/report
+  claims:
+    department: finance
+    groups: [finance]
+  expect: allow
+- path: /finance/report
+  claims:
+    groups: [finance]
+    department: sales
+  expect: deny
+- path: /unknown
+  claims:
+    department: finance
+  expect: allow
+`
+
+func newFakeACLConfig() *Config {
+	config := newDefaultConfig()
+	config.ClientID = fakeClientID
+	config.MatchClaims = map[string]string{"department": "^finance$"}
+	config.Resources = []*Resource{
+		{URL: "/admin*", Methods: allHTTPMethods, Roles: []string{"admin"}},
+		{URL: "/public*", Methods: []string{"GET"}, WhiteListed: true},
+		{URL: "/finance*", Methods: allHTTPMethods, Groups: []string{"finance"}},
+	}
+
+	return config
+}
+
+func TestACLTester(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gatekeeper")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "cases.yml")
+	require.NoError(t, ioutil.WriteFile(file, []byte(fakeACLCases), 0600))
+	cases, err := readACLCases(file)
+	require.NoError(t, err)
+	require.Len(t, cases, 8)
+	assert.Equal(t, "DELETE", cases[0].Method)
+	assert.Equal(t, "GET", cases[2].Method)
+
+	// the claims matched are required of every authenticated request
+	config := newFakeACLConfig()
+	config.MatchClaims = nil
+	results := newACLTester(config).run(cases)
+	expected := []struct {
+		resource string
+		decision string
+	}{
+		{resource: "/admin*", decision: aclAllow},
+		{resource: "/admin*", decision: aclDeny},
+		{resource: "/admin*", decision: aclDeny},
+		{resource: "/public*", decision: aclAllow},
+		{resource: "/public*", decision: aclDeny},
+		{resource: "/finance*", decision: aclAllow},
+		{resource: "/finance*", decision: aclAllow},
+		{resource: allRoutes, decision: aclAllow},
+	}
+	for i, c := range expected {
+		assert.Equal(t, c.resource, results[i].resource, "case %d", i)
+		assert.Equal(t, c.decision, results[i].decision, "case %d: %s", i, results[i].reason)
+	}
+	assert.False(t, results[6].passed())
+
+	results = newACLTester(newFakeACLConfig()).run(cases)
+	for i, result := range results {
+		assert.True(t, result.passed(), "case %d: %s", i, result.reason)
+	}
+
+	var report bytes.Buffer
+	reportACLResults(&report, results)
+	assert.Contains(t, report.String(), "admins manage the users")
+	assert.Contains(t, report.String(), "8 cases, 0 failed")
+}
+
+func TestACLTesterMethodRequirements(t *testing.T) {
+	config := newFakeACLConfig()
+	config.MatchClaims = nil
+	config.Resources = []*Resource{
+		{
+			URL:                "/documents*",
+			Methods:            allHTTPMethods,
+			MethodRequirements: []*MethodRequirement{{Methods: []string{http.MethodDelete}, Roles: []string{"editor"}}},
+		},
+	}
+	viewer := map[string]interface{}{"realm_access": map[string]interface{}{"roles": []interface{}{"viewer"}}}
+	tester := newACLTester(config)
+	assert.Equal(t, aclAllow, tester.evaluate(aclCase{Method: http.MethodGet, Path: "/documents/1", Claims: viewer}).decision)
+	assert.Equal(t, aclDeny, tester.evaluate(aclCase{Method: http.MethodDelete, Path: "/documents/1", Claims: viewer}).decision)
+}
+
+func TestACLTesterRealms(t *testing.T) {
+	config := newFakeACLConfig()
+	config.MatchClaims = nil
+	config.Realms = []*Realm{
+		{Name: "partners", DiscoveryURL: "https://partners.example.com/auth/realms/partners", ClientID: "partners"},
+		{Name: "docs", Hostnames: []string{"docs.example.com"}},
+	}
+	config.Resources = append(config.Resources,
+		&Resource{URL: "/partners*", Methods: allHTTPMethods, Roles: []string{"partner"}, Realm: "partners"},
+		&Resource{URL: "/admin*", Methods: []string{"GET"}, WhiteListed: true, Realm: "docs"},
+	)
+	partner := map[string]interface{}{"realm_access": map[string]interface{}{"roles": []interface{}{"partner"}}}
+	tester := newACLTester(config)
+
+	outcome := tester.evaluate(aclCase{Method: http.MethodGet, Path: "/partners/report", Claims: partner})
+	assert.Equal(t, "partners", outcome.realm)
+	assert.Equal(t, aclAllow, outcome.decision, outcome.reason)
+	// the realm is selected by the normalized path
+	outcome = tester.evaluate(aclCase{Method: http.MethodGet, Path: "/x/../partners/report"})
+	assert.Equal(t, "partners", outcome.realm)
+	assert.Equal(t, aclDeny, outcome.decision)
+
+	// the virtual hosts serve their own resources
+	outcome = tester.evaluate(aclCase{Method: http.MethodGet, Path: "/admin/users", Host: "docs.example.com:443"})
+	assert.Equal(t, "docs", outcome.realm)
+	assert.Equal(t, aclAllow, outcome.decision, outcome.reason)
+	outcome = tester.evaluate(aclCase{Method: http.MethodGet, Path: "/admin/users"})
+	assert.Empty(t, outcome.realm)
+	assert.Equal(t, "/admin*", outcome.resource)
+	assert.Equal(t, aclDeny, outcome.decision)
+}
+
+func TestACLTesterUnmatchedRoutes(t *testing.T) {
+	config := newFakeACLConfig()
+	config.EnableDefaultDeny = false
+	outcome := newACLTester(config).evaluate(aclCase{Method: "GET", Path: "/unknown"})
+	assert.Equal(t, aclAllow, outcome.decision)
+	assert.Empty(t, outcome.resource)
+
+	config.EnableDefaultNotFound = true
+	outcome = newACLTester(config).evaluate(aclCase{Method: "GET", Path: "/unknown"})
+	assert.Equal(t, aclDeny, outcome.decision)
+}
+
+func TestReadACLCasesInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gatekeeper")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "cases.json")
+	require.NoError(t, ioutil.WriteFile(file, []byte(`[{"path": "/", "expect": "maybe"}]`), 0600))
+	_, err = readACLCases(file)
+	assert.Error(t, err)
+
+	require.NoError(t, ioutil.WriteFile(file, []byte(`[{"path": "admin"}]`), 0600))
+	_, err = readACLCases(file)
+	assert.Error(t, err)
+}
+
+func TestTestACLCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gatekeeper")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cases := filepath.Join(dir, "cases.yml")
+	require.NoError(t, ioutil.WriteFile(cases, []byte(fakeACLCases), 0600))
+	config := filepath.Join(dir, "config.yml")
+	require.NoError(t, ioutil.WriteFile(config, []byte(`
+listen: 127.0.0.1:3000
+discovery-url: https://keycloak.example.com/auth/realms/test
+client-id: test
+client-secret: secret
+upstream-url: http://127.0.0.1:8080
+match-claims:
+  department: ^finance$
+resources:
+- uri: /admin*
+  roles: [admin]
+- uri: /public*
+  methods: [GET]
+  white-listed: true
+- uri: /finance*
+  groups: [finance]
+`), 0600))
+
+	stdout, exiter, errWriter := os.Stdout, cli.OsExiter, cli.ErrWriter
+	defer func() { os.Stdout, cli.OsExiter, cli.ErrWriter = stdout, exiter, errWriter }()
+	os.Stdout, _ = os.Open(os.DevNull)
+	cli.OsExiter = func(int) {}
+	cli.ErrWriter = ioutil.Discard
+
+	app := NewOauthProxyApp()
+	assert.NoError(t, app.Run([]string{"keycloak-gatekeeper", "--config", config, "test-acl", "--cases", cases}))
+
+	require.NoError(t, ioutil.WriteFile(cases, []byte(`[{"path": "/admin/users", "expect": "allow"}]`), 0600))
+	assert.Error(t, app.Run([]string{"keycloak-gatekeeper", "--config", config, "test-acl", "--cases", cases}))
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// tokenCache is a LRU cache of verified access tokens, keyed by the hash of the token found in the request.
+// A cached identity remains valid until the token expires, or for a shorter duration when introspected.
+type tokenCache struct {
+	sync.Mutex
+	size    int
+	entries map[[sha256.Size]byte]*list.Element
+	order   *list.List
+}
+
+type tokenCacheEntry struct {
+	key     [sha256.Size]byte
+	user    *userContext
+	expires time.Time
+}
+
+func newTokenCache(size int) *tokenCache {
+	return &tokenCache{
+		size:    size,
+		entries: make(map[[sha256.Size]byte]*list.Element, size),
+		order:   list.New(),
+	}
+}
+
+// get returns a copy of the verified identity for a token, if any
+func (c *tokenCache) get(raw string) *userContext {
+	if c == nil {
+		return nil
+	}
+	key := sha256.Sum256([]byte(raw))
+
+	c.Lock()
+	defer c.Unlock()
+	element, found := c.entries[key]
+	if !found {
+		return nil
+	}
+	entry := element.Value.(*tokenCacheEntry)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return nil
+	}
+	c.order.MoveToFront(element)
+	user := *entry.user
+
+	return &user
+}
+
+// add records the identity of a token which has passed verification
+func (c *tokenCache) add(user *userContext) {
+	c.addUntil(user, user.expiresAt)
+}
+
+// addUntil records the identity of a verified token until a time, bounded by the expiration of the token
+func (c *tokenCache) addUntil(user *userContext, until time.Time) {
+	if c == nil || user.rawToken == "" {
+		return
+	}
+	if until.After(user.expiresAt) {
+		until = user.expiresAt
+	}
+	key := sha256.Sum256([]byte(user.rawToken))
+	cached := *user
+	cached.verified = true
+
+	c.Lock()
+	defer c.Unlock()
+	if element, found := c.entries[key]; found {
+		entry := element.Value.(*tokenCacheEntry)
+		entry.user, entry.expires = &cached, until
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&tokenCacheEntry{key: key, user: &cached, expires: until})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*tokenCacheEntry).key)
+	}
+}
+
+// verifyIdentity verifies the access token of the user, unless it has already been verified. The token is issued
+// for the client id or, on a resource with audiences, for any of them.
+func (r *oauthProxy) verifyIdentity(user *userContext, audiences []string) error {
+	if user.verified {
+		return nil
+	}
+	if r.config.EnableTokenIntrospection {
+		return r.introspectIdentityToken(user)
+	}
+	for _, audience := range audiences {
+		if user.isAudience(audience) {
+			// the token is not cached, as it is not valid on the resources of other audiences
+			return r.verifyTokenAudience(user.token, audience)
+		}
+	}
+	if err := r.verifyToken(user.token); err != nil {
+		return err
+	}
+	r.tokens.add(user)
+
+	return nil
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenCache(t *testing.T) {
+	c := newTokenCache(2)
+	expires := time.Now().Add(time.Hour)
+	c.add(&userContext{rawToken: "one", email: "one@example.com", expiresAt: expires})
+	c.add(&userContext{rawToken: "two", expiresAt: expires})
+
+	user := c.get("one")
+	require.NotNil(t, user)
+	assert.True(t, user.verified)
+	assert.Equal(t, "one@example.com", user.email)
+
+	// the cache hands out copies
+	user.email = "changed"
+	assert.Equal(t, "one@example.com", c.get("one").email)
+
+	// the least recently used token is evicted
+	c.add(&userContext{rawToken: "three", expiresAt: expires})
+	assert.Nil(t, c.get("two"))
+	assert.NotNil(t, c.get("one"))
+	assert.NotNil(t, c.get("three"))
+	assert.Equal(t, 2, c.order.Len())
+
+	// expired tokens are dropped
+	c.add(&userContext{rawToken: "expired", expiresAt: time.Now().Add(-time.Second)})
+	assert.Nil(t, c.get("expired"))
+	assert.Equal(t, 1, c.order.Len())
+
+	var disabled *tokenCache
+	disabled.add(&userContext{rawToken: "one", expiresAt: expires})
+	assert.Nil(t, disabled.get("one"))
+}
+
+func TestTokenCacheRequests(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.TokenCacheSize = 10
+	p := newFakeProxy(cfg)
+	token := newTestToken(p.idp.getLocation())
+	token.addRealmRoles([]string{fakeAdminRole})
+	signed, err := p.idp.signToken(token.claims)
+	require.NoError(t, err)
+	authorization := map[string]string{"Authorization": "Bearer " + signed.Encode()}
+
+	requests := []fakeRequest{
+		{
+			URI:           testAdminURI,
+			Headers:       authorization,
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+		},
+		{
+			URI:           testAdminURI,
+			Headers:       authorization,
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+		},
+		{
+			URI:          fakeTestRoleURL,
+			Headers:      authorization,
+			ExpectedCode: http.StatusForbidden,
+		},
+	}
+	p.RunTests(t, requests)
+	assert.Equal(t, 1, p.proxy.tokens.order.Len())
+	assert.NotNil(t, p.proxy.tokens.get(signed.Encode()))
+}
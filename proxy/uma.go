@@ -0,0 +1,108 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// umaGrantType is the grant of the keycloak authorization services, deciding on the permissions of a token
+const umaGrantType = "urn:ietf:params:oauth:grant-type:uma-ticket"
+
+// umaDecider queries the decisions of the keycloak authorization services on the permissions of the requests
+type umaDecider struct {
+	proxy *oauthProxy
+	// resource is the name of the keycloak resource of the requests, matched by the path of the request when empty
+	resource string
+}
+
+// newUMADecider returns the decider of a resource, when the keycloak authorization services are enforced
+func (r *oauthProxy) newUMADecider(resource *Resource) *umaDecider {
+	if !r.config.EnableUMA {
+		return nil
+	}
+
+	return &umaDecider{proxy: r, resource: resource.UMAResource}
+}
+
+// permission returns the permission requested for a request, as resource#scope, the scope being the method
+func (d *umaDecider) permission(req *http.Request) string {
+	return defaultTo(d.resource, req.URL.Path) + "#" + req.Method
+}
+
+// allows asks the token endpoint whether the token of the user is granted the permission of the request, with the
+// decision response mode. The requests are matched to the resources by uri unless their resource is named.
+func (d *umaDecider) allows(req *http.Request, user *userContext) (bool, error) {
+	form := url.Values{
+		"grant_type":    []string{umaGrantType},
+		"audience":      []string{d.proxy.config.ClientID},
+		"permission":    []string{d.permission(req)},
+		"response_mode": []string{"decision"},
+	}
+	if d.resource == "" {
+		form.Set("permission_resource_format", "uri")
+		form.Set("permission_resource_matching_uri", "true")
+	}
+
+	request, err := http.NewRequestWithContext(req.Context(), http.MethodPost,
+		d.proxy.getProviderConfig().TokenEndpoint.String(), strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set(authorizationHeader, authorizationType+" "+user.encodedToken())
+
+	start := time.Now()
+	response, err := d.proxy.idpClient.Do(request)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, response.Body)
+		_ = response.Body.Close()
+	}()
+	oauthLatencyMetric.WithLabelValues("uma").Observe(time.Since(start).Seconds())
+
+	var decision struct {
+		Result bool   `json:"result"`
+		Error  string `json:"error"`
+	}
+	content, err := ioutil.ReadAll(io.LimitReader(response.Body, 1<<16))
+	if err != nil {
+		return false, err
+	}
+	_ = json.Unmarshal(content, &decision)
+
+	switch {
+	case response.StatusCode == http.StatusOK:
+		return decision.Result, nil
+	case response.StatusCode == http.StatusForbidden, response.StatusCode == http.StatusUnauthorized:
+		// the permission is not granted, or the token is not accepted by the authorization services
+		return false, nil
+	case response.StatusCode == http.StatusBadRequest && decision.Error == "invalid_resource":
+		// no resource of the authorization services matches the request
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected response from the authorization services: %d %s", response.StatusCode, content)
+	}
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUMAEnforcement(t *testing.T) {
+	granted := map[string]bool{"/uma/reports#GET": true, "invoices#POST": true}
+	authz := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.PostFormValue("grant_type") != umaGrantType || req.PostFormValue("response_mode") != "decision" ||
+			req.PostFormValue("audience") != fakeClientID || !strings.HasPrefix(req.Header.Get(authorizationHeader), "Bearer ") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		permission := req.PostFormValue("permission")
+		byURI := req.PostFormValue("permission_resource_format") == "uri"
+		switch {
+		case strings.HasPrefix(permission, "/uma/broken"):
+			w.WriteHeader(http.StatusInternalServerError)
+		case strings.HasPrefix(permission, "/uma/unknown") && byURI:
+			renderJSON(http.StatusBadRequest, w, req, map[string]string{"error": "invalid_resource"})
+		case granted[permission] && byURI == strings.HasPrefix(permission, "/"):
+			renderJSON(http.StatusOK, w, req, map[string]bool{"result": true})
+		default:
+			renderJSON(http.StatusForbidden, w, req, map[string]string{"error": "access_denied"})
+		}
+	}))
+	defer authz.Close()
+
+	cfg := newFakeKeycloakConfig()
+	cfg.EnableUMA = true
+	cfg.Resources = []*Resource{
+		{URL: "/uma/*", Methods: allHTTPMethods},
+		{URL: "/invoices/*", Methods: allHTTPMethods, UMAResource: "invoices"},
+	}
+	p := newFakeProxy(cfg)
+	defer func() {
+		p.idp.Close()
+		p.proxy.server.Close()
+	}()
+	idp := p.proxy.getProviderConfig()
+	endpoint, err := url.Parse(authz.URL)
+	require.NoError(t, err)
+	idp.TokenEndpoint = endpoint
+	p.proxy.setProviderConfig(idp)
+
+	requests := []fakeRequest{
+		{URI: "/uma/reports", HasToken: true, ExpectedProxy: true, ExpectedCode: http.StatusOK},
+		{URI: "/uma/reports", Method: http.MethodDelete, HasToken: true, ExpectedCode: http.StatusForbidden},
+		{URI: "/uma/unknown", HasToken: true, ExpectedCode: http.StatusForbidden},
+		{URI: "/uma/broken", HasToken: true, ExpectedCode: http.StatusServiceUnavailable},
+		{URI: "/invoices/1", Method: http.MethodPost, HasToken: true, ExpectedProxy: true, ExpectedCode: http.StatusOK},
+		{URI: "/invoices/1", HasToken: true, ExpectedCode: http.StatusForbidden},
+	}
+	p.RunTests(t, requests)
+}
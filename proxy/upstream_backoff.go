@@ -0,0 +1,150 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var upstreamBackoffMetric = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "proxy_upstream_backoff_shed_total",
+		Help: "The requests rejected at the gateway while the upstream has asked the user to retry later, partitioned by status code",
+	},
+	[]string{"code"},
+)
+
+func init() {
+	prometheus.MustRegister(upstreamBackoffMetric)
+}
+
+// upstreamBackoff records the users asked by the upstream to retry later, with a 429 or 503 response and a
+// Retry-After header, until the advised time
+type upstreamBackoff struct {
+	sync.Mutex
+	max   time.Duration
+	users map[string]backoffEntry
+	now   func() time.Time
+}
+
+// backoffEntry is the status of the upstream response and the time the user may retry
+type backoffEntry struct {
+	code  int
+	until time.Time
+}
+
+func newUpstreamBackoff(max time.Duration) *upstreamBackoff {
+	return &upstreamBackoff{max: max, users: make(map[string]backoffEntry), now: time.Now}
+}
+
+// parseRetryAfter returns the delay of a Retry-After header, either a number of seconds or an http date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, seconds > 0
+	}
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	delay := date.Sub(now)
+
+	return delay, delay > 0
+}
+
+// record sheds the user of an upstream response asking to retry later, for the advised delay capped to the maximum
+func (b *upstreamBackoff) record(res *http.Response) {
+	if b == nil || res.Request == nil {
+		return
+	}
+	if res.StatusCode != http.StatusTooManyRequests && res.StatusCode != http.StatusServiceUnavailable {
+		return
+	}
+	scope, ok := res.Request.Context().Value(contextScopeName).(*RequestScope)
+	if !ok || scope.Identity == nil {
+		return
+	}
+	now := b.now()
+	delay, ok := parseRetryAfter(res.Header.Get(headerRetryAfter), now)
+	if !ok {
+		return
+	}
+	if delay > b.max {
+		delay = b.max
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	for user, entry := range b.users {
+		if !now.Before(entry.until) {
+			delete(b.users, user)
+		}
+	}
+	b.users[scope.Identity.id] = backoffEntry{code: res.StatusCode, until: now.Add(delay)}
+}
+
+// shed returns the status of the upstream and the remaining delay when the user must retry later
+func (b *upstreamBackoff) shed(user string) (int, time.Duration, bool) {
+	b.Lock()
+	defer b.Unlock()
+	entry, found := b.users[user]
+	if !found {
+		return 0, 0, false
+	}
+	remaining := entry.until.Sub(b.now())
+	if remaining <= 0 {
+		delete(b.users, user)
+		return 0, 0, false
+	}
+
+	return entry.code, remaining, true
+}
+
+// upstreamBackoffMiddleware rejects the requests of the users the upstream has asked to retry later, with the
+// status of the upstream and the remaining delay, so that retry storms do not reach a struggling upstream
+func (r *oauthProxy) upstreamBackoffMiddleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if r.backoff == nil {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			scope, ok := req.Context().Value(contextScopeName).(*RequestScope)
+			if !ok || scope.AccessDenied || scope.Identity == nil {
+				next.ServeHTTP(w, req)
+				return
+			}
+			if code, remaining, shed := r.backoff.shed(scope.Identity.id); shed {
+				upstreamBackoffMetric.WithLabelValues(strconv.Itoa(code)).Inc()
+				w.Header().Set(headerRetryAfter, strconv.Itoa(int((remaining+time.Second-1)/time.Second)))
+				r.errorResponse(w, req, "the upstream has asked the user to retry later", code, nil)
+				r.revokeProxy(w, req)
+				return
+			}
+
+			next.ServeHTTP(w, req)
+		})
+	}
+}
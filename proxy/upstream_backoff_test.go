@@ -0,0 +1,115 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	delay, ok := parseRetryAfter("120", now)
+	assert.True(t, ok)
+	assert.Equal(t, 2*time.Minute, delay)
+
+	delay, ok = parseRetryAfter(now.Add(time.Minute).Format(http.TimeFormat), now)
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, delay)
+
+	for _, value := range []string{"", "0", "-5", "soon", now.Add(-time.Minute).Format(http.TimeFormat)} {
+		_, ok = parseRetryAfter(value, now)
+		assert.False(t, ok, value)
+	}
+}
+
+func TestUpstreamBackoffExpiry(t *testing.T) {
+	now := time.Now()
+	b := newUpstreamBackoff(time.Minute)
+	b.now = func() time.Time { return now }
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req = req.WithContext(context.WithValue(req.Context(), contextScopeName, &RequestScope{Identity: &userContext{id: "alice"}}))
+	b.record(&http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{"Retry-After": {"3600"}}, Request: req})
+
+	code, remaining, shed := b.shed("alice")
+	assert.True(t, shed)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, time.Minute, remaining, "the delay is capped")
+	_, _, shed = b.shed("bob")
+	assert.False(t, shed)
+
+	now = now.Add(time.Minute)
+	_, _, shed = b.shed("alice")
+	assert.False(t, shed)
+	assert.Empty(t, b.users)
+}
+
+func TestUpstreamBackoff(t *testing.T) {
+	var calls int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer upstream.Close()
+
+	c := newFakeKeycloakConfig()
+	c.EnableUpstreamBackoff = true
+	c.UpstreamBackoffMaxDuration = time.Minute
+	c.Resources = []*Resource{{URL: "/api/*", Methods: allHTTPMethods, Upstream: upstream.URL}}
+	p := newFakeProxy(c)
+	defer func() {
+		p.idp.Close()
+		p.proxy.server.Close()
+	}()
+	p.proxy.upstream = p.proxy.newUpstreamProxy(http.DefaultTransport, false)
+
+	get := func(subject string) *http.Response {
+		token := newTestToken(p.idp.getLocation())
+		token.claims.Add("sub", subject)
+		signed, err := p.idp.signToken(token.claims)
+		require.NoError(t, err)
+		req, err := http.NewRequest(http.MethodGet, p.getServiceURL()+"/api/items", nil)
+		require.NoError(t, err)
+		req.Header.Set(authorizationHeader, authorizationType+" "+signed.Encode())
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		return resp
+	}
+
+	resp := get("alice")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "30", resp.Header.Get("Retry-After"))
+
+	// the retries of the user are shed at the gateway
+	resp = get("alice")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get("Retry-After"))
+	assert.EqualValues(t, 1, atomic.LoadInt32(&calls))
+
+	// the other users still reach the upstream
+	get("bob")
+	assert.EqualValues(t, 2, atomic.LoadInt32(&calls))
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/http2"
+)
+
+// defaultUpstreamPool is the name of the connection pool shared by all resources without a dedicated pool
+const defaultUpstreamPool = "default"
+
+type dialContextFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// connectionPool are the settings of a pool of keepalive connections to the upstream
+type connectionPool struct {
+	maxIdleConns        int
+	maxIdleConnsPerHost int
+	maxConnsPerHost     int
+	// idleConnTimeout closes the connections idle for longer, unlimited when 0
+	idleConnTimeout time.Duration
+	// maxConnLifetime retires the connections open for longer, unlimited when 0
+	maxConnLifetime time.Duration
+}
+
+// connLifetimes keeps track of when the connections of a pool were opened, by their local and remote addresses
+type connLifetimes struct {
+	lifetime time.Duration
+	opened   sync.Map
+}
+
+// newConnLifetimes returns the tracking of the connections of a pool, nil unless their lifetime is limited
+func newConnLifetimes(lifetime time.Duration) *connLifetimes {
+	if lifetime <= 0 {
+		return nil
+	}
+
+	return &connLifetimes{lifetime: lifetime}
+}
+
+// connKey identifies a connection by its addresses, the same through the tls layer
+func connKey(conn net.Conn) string {
+	return conn.LocalAddr().String() + "|" + conn.RemoteAddr().String()
+}
+
+// expired checks if a connection has been open for longer than the lifetime of the pool
+func (l *connLifetimes) expired(conn net.Conn) bool {
+	opened, found := l.opened.Load(connKey(conn))
+	return found && time.Since(opened.(time.Time)) > l.lifetime
+}
+
+// instrumentDialer keeps track of the connections opened by a connection pool, and of their age when their
+// lifetime is limited
+func instrumentDialer(name string, dial dialContextFunc, lifetimes *connLifetimes) dialContextFunc {
+	gauge := upstreamOpenConnectionsMetric.WithLabelValues(name)
+
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := dial(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		gauge.Inc()
+		counted := &countedConn{Conn: conn, gauge: gauge}
+		// the connections over unix sockets are not told apart by their addresses
+		if lifetimes != nil && conn.LocalAddr().Network() != "unix" {
+			key := connKey(conn)
+			lifetimes.opened.Store(key, time.Now())
+			counted.closed = func() { lifetimes.opened.Delete(key) }
+		}
+
+		return counted, nil
+	}
+}
+
+// countedConn decrements the open connections gauge when closed
+type countedConn struct {
+	net.Conn
+	once   sync.Once
+	gauge  prometheus.Gauge
+	closed func()
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() {
+		c.gauge.Dec()
+		if c.closed != nil {
+			c.closed()
+		}
+	})
+
+	return c.Conn.Close()
+}
+
+// instrumentedTransport records whether requests sent upstream reuse an idle connection. With a limited lifetime,
+// the requests reusing a connection past its lifetime ask the upstream to close it after the response, so that the
+// pool does not stay pinned to the instances which were up when the connections were opened, e.g. along the rolling
+// deployments of the upstream behind a load balancer.
+type instrumentedTransport struct {
+	http.RoundTripper
+	name      string
+	lifetimes *connLifetimes
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.lifetimes != nil {
+		// the connection header may be set on a copy of the request
+		req = req.WithContext(req.Context())
+		req.Header = req.Header.Clone()
+	}
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			upstreamConnectionsMetric.WithLabelValues(t.name, strconv.FormatBool(info.Reused)).Inc()
+			if t.lifetimes != nil && info.Reused && !isHTTP2Conn(info.Conn) && t.lifetimes.expired(info.Conn) {
+				upstreamRetiredConnectionsMetric.WithLabelValues(t.name).Inc()
+				req.Header.Set("Connection", "close")
+			}
+		},
+	}
+
+	return t.RoundTripper.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
+
+// isHTTP2Conn checks if a connection speaks HTTP/2, on which the requests cannot ask for the connection to be closed
+func isHTTP2Conn(conn net.Conn) bool {
+	tlsConn, ok := conn.(*tls.Conn)
+	return ok && tlsConn.ConnectionState().NegotiatedProtocol == http2.NextProtoTLS
+}
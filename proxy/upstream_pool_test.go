@@ -0,0 +1,122 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	resty "gopkg.in/resty.v1"
+)
+
+func TestResourceConnectionPool(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	cfg := newFakeKeycloakConfig()
+	cfg.UpstreamKeepalives = true
+	for _, x := range cfg.Resources {
+		if x.URL == fakeAuthAllURL {
+			x.Upstream = upstream.URL
+			x.MaxIdleConns = 2
+			x.MaxConnsPerHost = 4
+		}
+	}
+	p := newFakeProxy(cfg)
+	assert.Len(t, p.proxy.upstreams, 1)
+	assert.Contains(t, p.proxy.upstreams, fakeAuthAllURL)
+
+	reused := upstreamConnectionsMetric.WithLabelValues(fakeAuthAllURL, "true")
+	opened := upstreamConnectionsMetric.WithLabelValues(fakeAuthAllURL, "false")
+	beforeReused, beforeOpened := testutil.ToFloat64(reused), testutil.ToFloat64(opened)
+
+	requests := []fakeRequest{
+		{
+			URI:          "/auth_all/test",
+			HasToken:     true,
+			ExpectedCode: http.StatusOK,
+		},
+		{
+			URI:          "/auth_all/test",
+			HasToken:     true,
+			ExpectedCode: http.StatusOK,
+		},
+		{
+			URI:           fakeAdminRoleURL,
+			HasToken:      true,
+			Roles:         []string{fakeAdminRole},
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+		},
+	}
+	p.RunTests(t, requests)
+
+	assert.Equal(t, beforeOpened+1, testutil.ToFloat64(opened))
+	assert.Equal(t, beforeReused+1, testutil.ToFloat64(reused))
+	assert.Equal(t, float64(1), testutil.ToFloat64(upstreamOpenConnectionsMetric.WithLabelValues(fakeAuthAllURL)))
+}
+
+func TestConnectionLifetime(t *testing.T) {
+	var (
+		lock    sync.Mutex
+		clients []string
+		closing []bool
+	)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		lock.Lock()
+		clients = append(clients, req.RemoteAddr)
+		closing = append(closing, req.Close)
+		lock.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	cfg := newFakeKeycloakConfig()
+	cfg.UpstreamKeepalives = true
+	cfg.UpstreamIdleConnTimeout = time.Minute
+	for _, x := range cfg.Resources {
+		if x.URL == fakeAuthAllURL {
+			x.Upstream = upstream.URL
+			x.MaxConnLifetime = 100 * time.Millisecond
+		}
+	}
+	p := newFakeProxy(cfg)
+	assert.Contains(t, p.proxy.upstreams, fakeAuthAllURL)
+	retired := upstreamRetiredConnectionsMetric.WithLabelValues(fakeAuthAllURL)
+	before := testutil.ToFloat64(retired)
+
+	request := fakeRequest{URI: "/auth_all/test", HasToken: true, ExpectedCode: http.StatusOK}
+	wait := request
+	wait.OnResponse = func(int, *resty.Request, *resty.Response) { <-time.After(150 * time.Millisecond) }
+	p.RunTests(t, []fakeRequest{request, wait, request, request})
+
+	// the connection is reused until its lifetime, then closed after the next response
+	require.Len(t, clients, 4)
+	assert.Equal(t, clients[0], clients[1])
+	assert.Equal(t, clients[1], clients[2])
+	assert.True(t, closing[2])
+	assert.NotEqual(t, clients[2], clients[3])
+	assert.False(t, closing[3])
+	assert.Equal(t, before+1, testutil.ToFloat64(retired))
+}
@@ -0,0 +1,264 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/jose"
+	"github.com/coreos/go-oidc/oidc"
+)
+
+// identityMapping locates the roles and groups in the claims of the tokens
+type identityMapping struct {
+	// roleClaims are the claims holding the realm roles
+	roleClaims []string
+	// clientRolesClaim is the claim holding the roles per client
+	clientRolesClaim string
+	// groupClaims are the claims holding the groups
+	groupClaims []string
+}
+
+// defaultIdentityMapping locates the roles and groups as mapped by default in keycloak
+var defaultIdentityMapping = newIdentityMapping(nil, "", nil)
+
+// newIdentityMapping creates a mapping of the claims, with the keycloak claims as defaults
+func newIdentityMapping(roleClaims []string, clientRolesClaim string, groupClaims []string) *identityMapping {
+	if len(roleClaims) == 0 {
+		roleClaims = []string{claimRealmAccess + "." + claimResourceRoles}
+	}
+	if clientRolesClaim == "" {
+		clientRolesClaim = claimResourceAccess
+	}
+	if len(groupClaims) == 0 {
+		groupClaims = []string{claimGroups}
+	}
+
+	return &identityMapping{
+		roleClaims:       roleClaims,
+		clientRolesClaim: clientRolesClaim,
+		groupClaims:      groupClaims,
+	}
+}
+
+// lookupClaim finds a claim by name, or else by a dotted path into nested claims
+func lookupClaim(claims map[string]interface{}, path string) (interface{}, bool) {
+	if value, found := claims[path]; found {
+		return value, true
+	}
+	parts := strings.SplitN(path, ".", 2)
+	if len(parts) != 2 {
+		return nil, false
+	}
+	nested, found := claims[parts[0]].(map[string]interface{})
+	if !found {
+		return nil, false
+	}
+
+	return lookupClaim(nested, parts[1])
+}
+
+// claimValues returns the values of a claim holding a list or a single value
+func claimValues(claims map[string]interface{}, path string) ([]string, error) {
+	value, found := lookupClaim(claims, path)
+	if !found || value == nil {
+		return nil, nil
+	}
+	switch v := value.(type) {
+	case string:
+		return []string{v}, nil
+	case []string:
+		return v, nil
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, x := range v {
+			list = append(list, fmt.Sprintf("%v", x))
+		}
+		return list, nil
+	default:
+		return nil, fmt.Errorf("the claim %s is neither a string or a list", path)
+	}
+}
+
+// extractIdentity parse the jwt token and extracts the various elements is order to construct,
+// with the roles and groups from the default keycloak claims
+func extractIdentity(token jose.JWT) (*userContext, error) {
+	return defaultIdentityMapping.extractIdentity(token)
+}
+
+// extractIdentity parse the jwt token and extracts the various elements is order to construct
+func (m *identityMapping) extractIdentity(token jose.JWT) (*userContext, error) {
+	claims, err := token.Claims()
+	if err != nil {
+		return nil, err
+	}
+	identity, err := oidc.IdentityFromClaims(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	// @step: ensure we have and can extract the preferred name of the user, if not, we set to the ID
+	preferredName, found, err := claims.StringClaim(claimPreferredName)
+	if err != nil || !found {
+		preferredName = identity.Email
+	}
+
+	var audiences []string
+	aud, found, err := claims.StringClaim(claimAudience)
+	if err == nil && found {
+		audiences = append(audiences, aud)
+	} else {
+		aud, found, erc := claims.StringsClaim(claimAudience)
+		if erc != nil || !found {
+			return nil, ErrNoTokenAudience
+		}
+		audiences = aud
+	}
+
+	// @step: extract the realm roles
+	var roleList []string
+	for _, x := range m.roleClaims {
+		roles, err := claimValues(claims, x)
+		if err != nil {
+			return nil, err
+		}
+		roleList = append(roleList, roles...)
+	}
+
+	// @step: extract the client roles from the access token
+	if accesses, found := lookupClaim(claims, m.clientRolesClaim); found {
+		clients, _ := accesses.(map[string]interface{})
+		for name, list := range clients {
+			scopes, _ := list.(map[string]interface{})
+			roles, err := claimValues(scopes, claimResourceRoles)
+			if err != nil {
+				return nil, err
+			}
+			for _, r := range roles {
+				roleList = append(roleList, fmt.Sprintf("%s:%s", name, r))
+			}
+		}
+	}
+
+	// @step: extract any group information from the tokens
+	var groups []string
+	for _, x := range m.groupClaims {
+		list, err := claimValues(claims, x)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, list...)
+	}
+
+	return &userContext{
+		audiences:     audiences,
+		claims:        claims,
+		email:         identity.Email,
+		expiresAt:     identity.ExpiresAt,
+		groups:        groups,
+		id:            identity.ID,
+		name:          preferredName,
+		preferredName: preferredName,
+		roles:         roleList,
+		token:         token,
+	}, nil
+}
+
+// userContext holds the information extracted the token
+type userContext struct {
+	// the id of the user
+	id string
+	// the audience for the token
+	audiences []string
+	// whether the context is from a session cookie or authorization header
+	bearerToken bool
+	// the claims associated to the token
+	claims jose.Claims
+	// the email associated to the user
+	email string
+	// the expiration of the access token
+	expiresAt time.Time
+	// groups is a collection of groups the user in in
+	groups []string
+	// a name of the user
+	name string
+	// preferredName is the name of the user
+	preferredName string
+	// roles is a collection of roles the users holds
+	roles []string
+	// the access token itself
+	token jose.JWT
+	// the access token as found in the request, before decryption
+	rawToken string
+	// the opaque access token, when the claims of the token are those of its introspection
+	opaqueToken string
+	// the opaque handle found in the access cookie, when the access token is kept in the store
+	sessionHandle string
+	// whether the access token has already been verified
+	verified bool
+}
+
+// encodedToken returns the access token of the user, opaque or jwt
+func (r *userContext) encodedToken() string {
+	if r.opaqueToken != "" {
+		return r.opaqueToken
+	}
+
+	return r.token.Encode()
+}
+
+// isAudience checks the audience
+func (r *userContext) isAudience(aud string) bool {
+	return containsString(aud, r.audiences)
+}
+
+// getRoles returns a list of roles
+func (r *userContext) getRoles() string {
+	return strings.Join(r.roles, ",")
+}
+
+// getSessionID returns the identifier of the provider session, if any
+func (r *userContext) getSessionID() string {
+	// keycloak sets the session in the session_state claim, other providers use sid
+	if sid, found, _ := r.claims.StringClaim(claimSessionID); found {
+		return sid
+	}
+	sid, _, _ := r.claims.StringClaim(claimSessionState)
+
+	return sid
+}
+
+// isExpired checks if the token has expired
+func (r *userContext) isExpired() bool {
+	return r.expiresAt.Before(time.Now())
+}
+
+// isBearer checks if the token
+func (r *userContext) isBearer() bool {
+	return r.bearerToken
+}
+
+// isCookie checks if it's by a cookie
+func (r *userContext) isCookie() bool {
+	return !r.isBearer()
+}
+
+// String returns a string representation of the user context
+func (r *userContext) String() string {
+	return fmt.Sprintf("user: %s, expires: %s, roles: %s", r.preferredName, r.expiresAt.String(), strings.Join(r.roles, ","))
+}
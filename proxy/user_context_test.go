@@ -13,13 +13,16 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package main
+package proxy
 
 import (
+	"net/http"
 	"testing"
 	"time"
 
+	"github.com/coreos/go-oidc/jose"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestIsAudience(t *testing.T) {
@@ -102,6 +105,35 @@ func TestGetUserRealmRoleContext(t *testing.T) {
 	assert.Equal(t, roles, context.roles)
 }
 
+func TestGetUserContextCustomClaims(t *testing.T) {
+	token := newTestToken("test")
+	token.addRealmRoles([]string{"realm"})
+	token.addGroups([]string{"default"})
+	token.merge(jose.Claims{
+		"roles":                     []interface{}{"flat"},
+		"https://example.com/roles": []interface{}{"namespaced"},
+		"memberOf":                  "cn=admins,dc=example,dc=com",
+		"apps":                      map[string]interface{}{"portal": map[string]interface{}{"roles": []interface{}{"viewer"}}},
+		"profile":                   map[string]interface{}{"teams": []interface{}{"blue", "red"}},
+	})
+
+	mapping := newIdentityMapping([]string{"roles", "https://example.com/roles"}, "apps", []string{"memberOf", "profile.teams"})
+	context, err := mapping.extractIdentity(token.getToken())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"flat", "namespaced", "portal:viewer"}, context.roles)
+	assert.Equal(t, []string{"cn=admins,dc=example,dc=com", "blue", "red"}, context.groups)
+
+	// the keycloak claims are used by default
+	context, err = newIdentityMapping(nil, "", nil).extractIdentity(token.getToken())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"realm"}, context.roles)
+	assert.Equal(t, []string{"default"}, context.groups)
+
+	token.merge(jose.Claims{"roles": map[string]interface{}{"invalid": true}})
+	_, err = mapping.extractIdentity(token.getToken())
+	assert.Error(t, err)
+}
+
 func TestUserContextString(t *testing.T) {
 	token := newTestToken("test")
 	context, err := extractIdentity(token.getToken())
@@ -109,3 +141,42 @@ func TestUserContextString(t *testing.T) {
 	assert.NotNil(t, context)
 	assert.NotEmpty(t, context.String())
 }
+
+func TestAdmissionCustomClaims(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.RoleClaims = []string{"roles"}
+	cfg.GroupClaims = []string{"memberOf"}
+	cfg.Resources = []*Resource{
+		{URL: "/admin/*", Methods: allHTTPMethods, Roles: []string{"admin"}},
+		{URL: "/team/*", Methods: allHTTPMethods, Groups: []string{"team"}},
+	}
+	requests := []fakeRequest{
+		{
+			URI:           "/admin/test",
+			HasToken:      true,
+			TokenClaims:   jose.Claims{"roles": []string{"admin"}},
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+		},
+		{
+			URI:          "/admin/test",
+			HasToken:     true,
+			Roles:        []string{"admin"},
+			ExpectedCode: http.StatusForbidden,
+		},
+		{
+			URI:           "/team/test",
+			HasToken:      true,
+			TokenClaims:   jose.Claims{"memberOf": "team"},
+			ExpectedProxy: true,
+			ExpectedCode:  http.StatusOK,
+		},
+		{
+			URI:          "/team/test",
+			HasToken:     true,
+			Groups:       []string{"team"},
+			ExpectedCode: http.StatusForbidden,
+		},
+	}
+	newFakeProxy(cfg).RunTests(t, requests)
+}
@@ -13,7 +13,7 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package main
+package proxy
 
 import (
 	"bytes"
@@ -65,6 +65,8 @@ var (
 
 var (
 	symbolsFilter = regexp.MustCompilePOSIX("[_$><\\[\\].,\\+-/'%^&*()!\\\\]+")
+	// extensionMethod is the format of the extension http methods, e.g. PROPFIND or VERSION-CONTROL
+	extensionMethod = regexp.MustCompile(`^[A-Z]+(-[A-Z]+)*$`)
 )
 
 const (
@@ -120,17 +122,22 @@ func createCertificate(key *rsa.PrivateKey, hostnames []string, expire time.Dura
 
 // getRequestHostURL returns the hostname from the request
 func getRequestHostURL(r *http.Request) string {
-	hostname := r.Host
-	if r.Header.Get("X-Forwarded-Host") != "" {
-		hostname = r.Header.Get("X-Forwarded-Host")
-	}
-
 	scheme := unsecureScheme
 	if r.TLS != nil {
 		scheme = secureScheme
 	}
 
-	return fmt.Sprintf("%s://%s", scheme, hostname)
+	return fmt.Sprintf("%s://%s", scheme, requestHost(r))
+}
+
+// requestHost returns the host of a request, or the first host forwarded by a proxy
+func requestHost(r *http.Request) string {
+	hostname := r.Host
+	if forwarded := r.Header.Get("X-Forwarded-Host"); forwarded != "" {
+		hostname = strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+
+	return bracketHost(hostname)
 }
 
 // readConfigFile reads and parses the configuration file
@@ -187,14 +194,38 @@ func decryptDataBlock(cipherText, key []byte) ([]byte, error) {
 	return gcm.Open(nil, nonce, input, nil)
 }
 
+const (
+	// payloadMagic prefixes the encrypted payloads, telling compressed and plain payloads apart.
+	// Payloads without this prefix have been issued by earlier versions, and are always compressed.
+	payloadMagic      = 0x00
+	payloadCompressed = 'z'
+	payloadPlain      = 'p'
+)
+
 // encodeText encodes the session state information into a value for a cookie to consume
 func encodeText(plaintext, key string) (string, error) {
-	var compressedText bytes.Buffer
-	w := zlib.NewWriter(&compressedText)
-	_, _ = w.Write([]byte(plaintext))
-	w.Close()
+	return encodeTextWithCompression(plaintext, key, true)
+}
 
-	cipherText, err := encryptDataBlock(compressedText.Bytes(), []byte(key))
+// encodeTextWithCompression encodes the session state information into a value for a cookie to consume,
+// optionally compressing the payload before encryption whenever it makes it smaller
+func encodeTextWithCompression(plaintext, key string, compress bool) (string, error) {
+	payload := getBuffer()
+	defer putBuffer(payload)
+	if compress {
+		payload.Write([]byte{payloadMagic, payloadCompressed})
+		w := getZlibWriter(payload)
+		_, _ = io.WriteString(w, plaintext)
+		w.Close()
+		putZlibWriter(w)
+	}
+	if !compress || payload.Len() >= len(plaintext)+2 {
+		payload.Reset()
+		payload.Write([]byte{payloadMagic, payloadPlain})
+		payload.WriteString(plaintext)
+	}
+
+	cipherText, err := encryptDataBlock(payload.Bytes(), []byte(key))
 	if err != nil {
 		return "", err
 	}
@@ -215,18 +246,29 @@ func decodeText(state, key string) (string, error) {
 		return "", ErrInvalidSession
 	}
 
-	b := bytes.NewBuffer(decoded)
-	r, err := zlib.NewReader(b)
+	if len(decoded) >= 2 && decoded[0] == payloadMagic {
+		switch decoded[1] {
+		case payloadPlain:
+			return string(decoded[2:]), nil
+		case payloadCompressed:
+			decoded = decoded[2:]
+		default:
+			return "", ErrInvalidSession
+		}
+	}
+
+	r, err := zlib.NewReader(bytes.NewReader(decoded))
 	if err != nil {
 		return "", ErrInvalidSession
 	}
 	defer r.Close()
 
-	uncompressed, err := ioutil.ReadAll(r)
-	if err != nil {
+	uncompressed := getBuffer()
+	defer putBuffer(uncompressed)
+	if _, err = uncompressed.ReadFrom(r); err != nil {
 		return "", ErrInvalidSession
 	}
-	return string(uncompressed), nil
+	return uncompressed.String(), nil
 }
 
 // decodeKeyPairs converts a list of strings (key=pair) to a map
@@ -255,6 +297,11 @@ func isValidHTTPMethod(method string) bool {
 	return false
 }
 
+// isValidResourceMethod ensure this is either a standard http method or an extension method, such as the WebDAV ones
+func isValidResourceMethod(method string) bool {
+	return isValidHTTPMethod(method) || extensionMethod.MatchString(method)
+}
+
 // defaultTo returns the value of the default
 func defaultTo(v, d string) string {
 	if v != "" {
@@ -326,6 +373,47 @@ func containedIn(value string, list []string, enableWildcard bool) bool {
 	return false
 }
 
+// containsFold checks if a value is in a list of strings, regardless of the case
+func containsFold(value string, list []string) bool {
+	for _, x := range list {
+		if strings.EqualFold(x, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isSafeMethod checks if the http method is not expected to change any state
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	default:
+		return false
+	}
+}
+
+// matchHostname checks if a hostname matches any of the patterns, supporting wildcard
+// subdomains such as *.example.com
+func matchHostname(hostname string, patterns []string) bool {
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+	for _, x := range patterns {
+		pattern := strings.ToLower(x)
+		if strings.HasPrefix(pattern, "*.") {
+			if strings.HasSuffix(hostname, pattern[1:]) && len(hostname) > len(pattern)-1 {
+				return true
+			}
+			continue
+		}
+		if hostname == pattern {
+			return true
+		}
+	}
+
+	return false
+}
+
 // containsSubString checks if substring exists
 func containsSubString(value string, list []string) bool {
 	for _, x := range list {
@@ -429,13 +517,30 @@ func printError(message string, args ...interface{}) *cli.ExitError {
 func realIP(req *http.Request) string {
 	ra := req.RemoteAddr
 	if ip := req.Header.Get(headerXForwardedFor); ip != "" {
-		ra = strings.Split(ip, ", ")[0]
+		ra = strings.Split(ip, ",")[0]
 	} else if ip := req.Header.Get(headerXRealIP); ip != "" {
 		ra = ip
-	} else {
-		ra, _, _ = net.SplitHostPort(ra)
 	}
-	return ra
+	return hostIP(ra)
+}
+
+// hostIP extracts the ip address of a host, which may be bracketed and carry a port
+func hostIP(host string) string {
+	host = strings.TrimSpace(host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+}
+
+// bracketHost brackets the IPv6 addresses of a host, for it to be used in a url
+func bracketHost(host string) string {
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		return "[" + host + "]"
+	}
+
+	return host
 }
 
 // backported from https://github.com/coreos/go-oidc/blob/master/oidc/verification.go#L28-L37
@@ -13,12 +13,15 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package main
+package proxy
 
 import (
 	"bytes"
+	"compress/zlib"
 	"crypto/tls"
+	"encoding/base64"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
@@ -95,6 +98,20 @@ func TestGetRequestHostURL(t *testing.T) {
 			Hostname:   "www.test.com",
 			TLS:        &tls.ConnectionState{},
 		},
+		{
+			Expected: "http://[fd00::1]:3000",
+			Hostname: "[fd00::1]:3000",
+		},
+		{
+			Expected:   "http://[fd00::1]",
+			HostHeader: "fd00::1",
+			Hostname:   "www.test.com",
+		},
+		{
+			Expected:   "http://www.first.com",
+			HostHeader: "www.first.com, www.second.com",
+			Hostname:   "www.test.com",
+		},
 	}
 	for i, c := range cs {
 		request := &http.Request{
@@ -110,6 +127,29 @@ func TestGetRequestHostURL(t *testing.T) {
 	}
 }
 
+func TestRealIP(t *testing.T) {
+	cs := []struct {
+		RemoteAddr string
+		Headers    map[string]string
+		Expected   string
+	}{
+		{RemoteAddr: "10.0.0.1:4567", Expected: "10.0.0.1"},
+		{RemoteAddr: "[fd00::1]:4567", Expected: "fd00::1"},
+		{RemoteAddr: "10.0.0.1", Expected: "10.0.0.1"},
+		{RemoteAddr: "10.0.0.1:4567", Headers: map[string]string{headerXForwardedFor: "fd00::2, 10.0.0.2"}, Expected: "fd00::2"},
+		{RemoteAddr: "10.0.0.1:4567", Headers: map[string]string{headerXForwardedFor: "[fd00::2]:8080,10.0.0.2"}, Expected: "fd00::2"},
+		{RemoteAddr: "10.0.0.1:4567", Headers: map[string]string{headerXForwardedFor: "10.0.0.3"}, Expected: "10.0.0.3"},
+		{RemoteAddr: "10.0.0.1:4567", Headers: map[string]string{headerXRealIP: "[fd00::3]"}, Expected: "fd00::3"},
+	}
+	for i, c := range cs {
+		request := &http.Request{RemoteAddr: c.RemoteAddr, Header: make(http.Header)}
+		for k, v := range c.Headers {
+			request.Header.Set(k, v)
+		}
+		assert.Equal(t, c.Expected, realIP(request), "case %d, unexpected client ip", i)
+	}
+}
+
 func BenchmarkUUID(b *testing.B) {
 	for n := 0; n < b.N; n++ {
 		s := uuid.NewV1()
@@ -235,6 +275,42 @@ func TestDecodeText(t *testing.T) {
 	assert.Equal(t, fakeText, decoded, "the decoded text is not the same")
 }
 
+func TestDecodeTextCompression(t *testing.T) {
+	fakeKey := "HYLNt2JSzD7Lpz0djTRudmlOpbwx1oHB"
+	token := newTestToken("https://keycloak.example.com").getToken()
+	fakeText := token.Encode()
+
+	compressed, err := encodeTextWithCompression(fakeText, fakeKey, true)
+	require.NoError(t, err)
+	plain, err := encodeTextWithCompression(fakeText, fakeKey, false)
+	require.NoError(t, err)
+	assert.True(t, len(compressed) < len(plain), "compressed: %d, plain: %d", len(compressed), len(plain))
+
+	for _, encrypted := range []string{compressed, plain} {
+		decoded, err := decodeText(encrypted, fakeKey)
+		require.NoError(t, err)
+		assert.Equal(t, fakeText, decoded)
+	}
+
+	// short payloads which would not shrink are kept plain
+	short, err := encodeTextWithCompression("a", fakeKey, true)
+	require.NoError(t, err)
+	decoded, err := decodeText(short, fakeKey)
+	require.NoError(t, err)
+	assert.Equal(t, "a", decoded)
+
+	// payloads issued before the compression marker was introduced are still accepted
+	legacy := new(bytes.Buffer)
+	w := zlib.NewWriter(legacy)
+	_, _ = io.WriteString(w, fakeText)
+	require.NoError(t, w.Close())
+	cipherText, err := encryptDataBlock(legacy.Bytes(), []byte(fakeKey))
+	require.NoError(t, err)
+	decoded, err = decodeText(base64.RawStdEncoding.EncodeToString(cipherText), fakeKey)
+	require.NoError(t, err)
+	assert.Equal(t, fakeText, decoded)
+}
+
 func TestFindCookie(t *testing.T) {
 	cookies := []*http.Cookie{
 		{Name: "cookie_there"},
@@ -435,6 +511,22 @@ func TestContainedIn(t *testing.T) {
 	assert.True(t, containedIn("1*", []string{"123", "3", "4"}, true))
 }
 
+func TestMatchHostname(t *testing.T) {
+	patterns := []string{"app.example.com", "*.apps.example.com"}
+
+	assert.True(t, matchHostname("app.example.com", patterns))
+	assert.True(t, matchHostname("APP.example.com", patterns))
+	assert.True(t, matchHostname("app.example.com.", patterns))
+	assert.True(t, matchHostname("one.apps.example.com", patterns))
+	assert.True(t, matchHostname("one.two.apps.example.com", patterns))
+
+	assert.False(t, matchHostname("apps.example.com", patterns))
+	assert.False(t, matchHostname("example.com", patterns))
+	assert.False(t, matchHostname("app.example.com.evil.com", patterns))
+	assert.False(t, matchHostname("evilapps.example.com", patterns))
+	assert.False(t, matchHostname("", patterns))
+}
+
 func TestContainsSubString(t *testing.T) {
 	assert.False(t, containsSubString("bar.com", []string{"foo.bar.com"}))
 	assert.True(t, containsSubString("www.foo.bar.com", []string{"foo.bar.com"}))
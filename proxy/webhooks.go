@@ -0,0 +1,106 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// logoutNotification is the payload posted to the logout webhooks
+type logoutNotification struct {
+	Subject   string `json:"subject"`
+	SessionID string `json:"session_id,omitempty"`
+	Email     string `json:"email,omitempty"`
+}
+
+// notifyLogout posts the subject and session of a user who logged out to the configured webhooks
+func (r *oauthProxy) notifyLogout(user *userContext) {
+	notification := logoutNotification{
+		Subject:   user.id,
+		SessionID: user.getSessionID(),
+		Email:     user.email,
+	}
+
+	payload, err := json.Marshal(notification)
+	if err != nil {
+		r.log.Error("unable to encode the logout notification", zap.Error(err))
+		return
+	}
+
+	client := &http.Client{Timeout: r.config.LogoutWebhookTimeout}
+	for _, hook := range r.config.LogoutWebhooks {
+		if err := postLogoutNotification(client, hook, payload, r.config.LogoutWebhookSecret); err != nil {
+			r.log.Warn("unable to notify logout to webhook",
+				zap.String("webhook", hook),
+				zap.String("subject", notification.Subject),
+				zap.Error(err))
+			continue
+		}
+		r.log.Debug("notified logout to webhook",
+			zap.String("webhook", hook),
+			zap.String("subject", notification.Subject))
+	}
+}
+
+// postLogoutNotification posts a logout notification to a webhook. With a secret, the notification is signed with an
+// HMAC-SHA256 of its timestamp and body, so that the applications can tell it from a forged one.
+func postLogoutNotification(client *http.Client, hook string, payload []byte, secret string) error {
+	request, err := http.NewRequestWithContext(context.Background(), http.MethodPost, hook, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", jsonMime)
+	if secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		request.Header.Set(headerXAuthTimestamp, timestamp)
+		request.Header.Set(headerXAuthSignature, "sha256="+signLogoutNotification(secret, timestamp, payload))
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	if response.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("unexpected response status from webhook: %d", response.StatusCode)
+	}
+
+	return nil
+}
+
+// signLogoutNotification computes the hex-encoded HMAC-SHA256 of the timestamp and body of a logout notification,
+// separated by a dot
+func signLogoutNotification(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(timestamp + "."))
+	_, _ = mac.Write(payload)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// wellKnownMaxSize is the maximum size of a document fetched from the provider
+const wellKnownMaxSize = 1 << 20
+
+// wellKnownDocuments caches the discovery documents of the provider, served to the clients of the proxy
+type wellKnownDocuments struct {
+	sync.RWMutex
+	duration time.Duration
+	entries  map[string]wellKnownDocument
+	fetches  singleflight.Group
+}
+
+type wellKnownDocument struct {
+	content   map[string]interface{}
+	expiresAt time.Time
+}
+
+func newWellKnownDocuments(duration time.Duration) *wellKnownDocuments {
+	return &wellKnownDocuments{
+		duration: duration,
+		entries:  make(map[string]wellKnownDocument),
+	}
+}
+
+// get returns the cached document, fetching it from the provider when missing or expired.
+// Concurrent fetches of a document are coalesced into a single call, and an expired document is
+// served when the provider cannot be reached.
+func (d *wellKnownDocuments) get(hc *http.Client, location string) (map[string]interface{}, error) {
+	d.RLock()
+	entry, found := d.entries[location]
+	d.RUnlock()
+	if found && time.Now().Before(entry.expiresAt) {
+		return entry.content, nil
+	}
+
+	content, err, _ := d.fetches.Do(location, func() (interface{}, error) {
+		content, err := fetchWellKnownDocument(hc, location)
+		if err != nil {
+			return nil, err
+		}
+		d.Lock()
+		d.entries[location] = wellKnownDocument{content: content, expiresAt: time.Now().Add(d.duration)}
+		d.Unlock()
+
+		return content, nil
+	})
+	if err != nil {
+		if found {
+			return entry.content, nil
+		}
+		return nil, err
+	}
+
+	return content.(map[string]interface{}), nil
+}
+
+// fetchWellKnownDocument retrieves a json document from the provider
+func fetchWellKnownDocument(hc *http.Client, location string) (map[string]interface{}, error) {
+	resp, err := hc.Get(location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		return nil, fmt.Errorf("%s responded with %s", location, resp.Status)
+	}
+
+	var content map[string]interface{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, wellKnownMaxSize)).Decode(&content); err != nil {
+		return nil, fmt.Errorf("invalid document at %s: %s", location, err)
+	}
+
+	return content, nil
+}
+
+// wellKnownHandler serves the openid configuration of the provider, the endpoints the proxy implements
+// being rewritten to the proxy and the others, which require a direct access to the provider, removed
+func (r *oauthProxy) wellKnownHandler(w http.ResponseWriter, req *http.Request) {
+	content, err := r.wellKnown.get(r.idpClient, r.config.DiscoveryURL+wellKnownURL)
+	if err != nil {
+		r.wellKnownError(w, req, err)
+		return
+	}
+
+	base := r.config.RedirectionURL
+	if base == "" {
+		base = getRequestHostURL(req)
+	}
+	rewritten := make(map[string]interface{}, len(content))
+	for k, v := range content {
+		if strings.HasSuffix(k, "_endpoint") || strings.HasSuffix(k, "_iframe") || k == "mtls_endpoint_aliases" {
+			continue
+		}
+		rewritten[k] = v
+	}
+	rewritten["authorization_endpoint"] = base + path.Clean(r.withOAuthURI(req, authorizationURL))
+	rewritten["end_session_endpoint"] = base + path.Clean(r.withOAuthURI(req, logoutURL))
+	rewritten["jwks_uri"] = base + path.Clean(r.withOAuthURI(req, jwksURL))
+
+	r.writeWellKnown(w, rewritten)
+}
+
+// jwksHandler serves the signing keys of the provider
+func (r *oauthProxy) jwksHandler(w http.ResponseWriter, req *http.Request) {
+	idp := r.getProviderConfig()
+	if idp.KeysEndpoint == nil {
+		r.errorResponse(w, req, "the provider does not publish its signing keys", http.StatusNotFound, nil)
+		return
+	}
+	content, err := r.wellKnown.get(r.idpClient, idp.KeysEndpoint.String())
+	if err != nil {
+		r.wellKnownError(w, req, err)
+		return
+	}
+
+	r.writeWellKnown(w, content)
+}
+
+func (r *oauthProxy) wellKnownError(w http.ResponseWriter, req *http.Request, err error) {
+	if isProviderUnavailable(err) {
+		r.providerUnavailable(w, req, "well-known", err)
+		return
+	}
+	r.errorResponse(w, req, "unable to retrieve the document from the provider", http.StatusBadGateway, err)
+}
+
+func (r *oauthProxy) writeWellKnown(w http.ResponseWriter, content map[string]interface{}) {
+	w.Header().Set("Content-Type", jsonMime)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(r.config.WellKnownCacheDuration.Seconds())))
+	_ = json.NewEncoder(w).Encode(content)
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getWellKnownDocument(t *testing.T, location string) (map[string]interface{}, *http.Response) {
+	resp, err := http.Get(location)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp
+	}
+	var content map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&content))
+
+	return content, resp
+}
+
+func TestWellKnown(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.EnableWellKnown = true
+	cfg.WellKnownCacheDuration = time.Minute
+	_, idp, svc := newTestProxyService(cfg)
+	defer idp.Close()
+
+	content, resp := getWellKnownDocument(t, svc+wellKnownURL)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "public, max-age=60", resp.Header.Get("Cache-Control"))
+	assert.Equal(t, svc+"/oauth/authorize", content["authorization_endpoint"])
+	assert.Equal(t, svc+"/oauth/logout", content["end_session_endpoint"])
+	assert.Equal(t, svc+"/oauth/jwks", content["jwks_uri"])
+	assert.Equal(t, idp.getLocation(), content["issuer"])
+	// the endpoints requiring a direct access to the provider are not published
+	assert.NotContains(t, content, "token_endpoint")
+	assert.NotContains(t, content, "userinfo_endpoint")
+
+	keys, resp := getWellKnownDocument(t, svc+"/oauth/jwks")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotEmpty(t, keys["keys"])
+
+	// the cached documents are served while the provider is down
+	idp.setUnavailable(true)
+	_, resp = getWellKnownDocument(t, svc+wellKnownURL)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	_, resp = getWellKnownDocument(t, svc+"/oauth/jwks")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestWellKnownProviderUnavailable(t *testing.T) {
+	cfg := newFakeKeycloakConfig()
+	cfg.EnableWellKnown = true
+	cfg.WellKnownCacheDuration = time.Minute
+	_, idp, svc := newTestProxyService(cfg)
+	defer idp.Close()
+
+	idp.setUnavailable(true)
+	_, resp := getWellKnownDocument(t, svc+wellKnownURL)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
+
+func TestWellKnownDisabled(t *testing.T) {
+	_, idp, svc := newTestProxyService(nil)
+	defer idp.Close()
+
+	_, resp := getWellKnownDocument(t, svc+"/oauth/jwks")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestWellKnownDocumentsExpired(t *testing.T) {
+	_, idp, _ := newTestProxyService(nil)
+	defer idp.Close()
+
+	documents := newWellKnownDocuments(time.Nanosecond)
+	location := idp.getLocation() + wellKnownURL
+	content, err := documents.get(http.DefaultClient, location)
+	require.NoError(t, err)
+	assert.Equal(t, idp.getLocation(), content["issuer"])
+
+	// the expired document is kept when the provider is down
+	idp.setUnavailable(true)
+	content, err = documents.get(http.DefaultClient, location)
+	require.NoError(t, err)
+	assert.Equal(t, idp.getLocation(), content["issuer"])
+
+	_, err = newWellKnownDocuments(time.Minute).get(http.DefaultClient, location)
+	assert.Error(t, err)
+}